@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+The access log records every request as a line of JSON in AccessLog.File,
+independently of LogRequests, which logs to stdout. The file is rotated when
+it grows past AccessLog.MaxSizeMiB or gets older than
+AccessLog.RotateIntervalHours: File is renamed to File.1, File.1 to File.2,
+and so on, and anything past AccessLog.MaxBackups is deleted.
+*/
+
+// A log file that rotates itself. Safe for concurrent use.
+type RotatingFile struct {
+	mutex      sync.Mutex
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+	file       *os.File
+	size       int64
+	openedAt   time.Time
+}
+
+// A maxSize or interval of 0 means the file is never rotated for that reason
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	if f.size > 0 {
+		// Count an existing file's age from when it was last written, so
+		// restarts don't postpone rotation forever
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+func (f *RotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(f.backupPath(f.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := f.maxBackups - 1; n >= 1; n -= 1 {
+		if err := os.Rename(f.backupPath(n), f.backupPath(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.size > 0 {
+		tooBig := f.maxSize > 0 && f.size+int64(len(p)) > f.maxSize
+		tooOld := f.interval > 0 && time.Since(f.openedAt) >= f.interval
+		if tooBig || tooOld {
+			if err := f.rotate(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+func OpenAccessLog(config *accessLogConfig) (*RotatingFile, error) {
+	return OpenRotatingFile(
+		config.File,
+		int64(config.MaxSizeMiB)*1024*1024,
+		time.Duration(config.RotateIntervalHours)*time.Hour,
+		config.MaxBackups,
+	)
+}
+
+func makeAccessLogMiddleware(app *App) echo.MiddlewareFunc {
+	return middleware.LoggerWithConfig(middleware.LoggerConfig{
+		Skipper: func(c echo.Context) bool {
+			return app.Config().AccessLog.ExcludeTextures && strings.HasPrefix(c.Path(), "/drasl/texture/")
+		},
+		Output: app.AccessLog,
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	t.Run("Test RotatingFile", testRotatingFile)
+	{
+		ts := &TestSuite{}
+
+		logDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(logDirectory)
+
+		config := testConfig()
+		config.AccessLog.Enable = true
+		config.AccessLog.File = filepath.Join(logDirectory, "access.log")
+		config.AccessLog.ExcludeTextures = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test access log", ts.testAccessLog)
+	}
+}
+
+func testRotatingFile(t *testing.T) {
+	logDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+	defer os.RemoveAll(logDirectory)
+	path := filepath.Join(logDirectory, "access.log")
+
+	f, err := OpenRotatingFile(path, 10, 0, 2)
+	assert.Nil(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := f.Write([]byte(line))
+		assert.Nil(t, err)
+	}
+
+	// Each line is too big to share a file with another, and only two
+	// backups are kept
+	assert.Equal(t, "fourth\n", string(Unwrap(os.ReadFile(path))))
+	assert.Equal(t, "third\n", string(Unwrap(os.ReadFile(path+".1"))))
+	assert.Equal(t, "second\n", string(Unwrap(os.ReadFile(path+".2"))))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, f.Close())
+
+	// Reopening appends to the existing file
+	f, err = OpenRotatingFile(path, 0, time.Hour, 2)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "fourth\nfifth\n", string(Unwrap(os.ReadFile(path))))
+
+	// Rotate once the file is older than the interval
+	f.openedAt = time.Now().Add(-2 * time.Hour)
+	_, err = f.Write([]byte("sixth\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "sixth\n", string(Unwrap(os.ReadFile(path))))
+	assert.Equal(t, "fourth\nfifth\n", string(Unwrap(os.ReadFile(path+".1"))))
+	assert.Nil(t, f.Close())
+}
+
+func (ts *TestSuite) testAccessLog(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/drasl/api/v1/info", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ts.Get(t, ts.Server, "/drasl/texture/skin/nonexistent.png", nil, nil)
+
+	lines := strings.Split(strings.TrimSpace(string(Unwrap(os.ReadFile(ts.Config.AccessLog.File)))), "\n")
+	assert.Equal(t, 1, len(lines))
+
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "/drasl/api/v1/info", entry["uri"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+}
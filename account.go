@@ -26,13 +26,13 @@ func AccountPlayerNameToID(app *App) func(c echo.Context) error {
 		result := app.DB.First(&user, "player_name = ?", playerName)
 		if result.Error != nil {
 			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-				for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+				for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
 					reqURL, err := url.JoinPath(fallbackAPIServer.AccountURL, "users/profiles/minecraft", playerName)
 					if err != nil {
 						log.Println(err)
 						continue
 					}
-					res, err := app.CachedGet(reqURL, fallbackAPIServer.CacheTTLSeconds)
+					res, err := app.CachedGet(c.Request().Context(), &fallbackAPIServer, FallbackCacheUUID, reqURL)
 					if err != nil {
 						log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
 						continue
@@ -42,6 +42,7 @@ func AccountPlayerNameToID(app *App) func(c echo.Context) error {
 						// Be silent, 404s will be common here
 						continue
 					}
+					app.RecordFallbackUse(&fallbackAPIServer)
 					return c.Blob(http.StatusOK, "application/json", res.BodyBytes)
 				}
 				errorMessage := fmt.Sprintf("Couldn't find any profile with name %s", playerName)
@@ -50,6 +51,9 @@ func AccountPlayerNameToID(app *App) func(c echo.Context) error {
 			return result.Error
 		}
 
+		if notModified, err := app.ProfileNotModified(c, &user, "name-to-id"); notModified || err != nil {
+			return err
+		}
 		id, err := UUIDToID(user.UUID)
 		if err != nil {
 			return err
@@ -81,13 +85,13 @@ func AccountPlayerNamesToIDs(app *App) func(c echo.Context) error {
 			result := app.DB.First(&user, "player_name = ?", playerName)
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-					for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+					for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
 						reqURL, err := url.JoinPath(fallbackAPIServer.AccountURL, "users/profiles/minecraft", playerName)
 						if err != nil {
 							log.Println(err)
 							continue
 						}
-						res, err := app.CachedGet(reqURL, fallbackAPIServer.CacheTTLSeconds)
+						res, err := app.CachedGet(c.Request().Context(), &fallbackAPIServer, FallbackCacheUUID, reqURL)
 						if err != nil {
 							log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
 							continue
@@ -103,6 +107,7 @@ func AccountPlayerNamesToIDs(app *App) func(c echo.Context) error {
 							log.Printf("Received invalid response from fallback API server at %s\n", reqURL)
 							continue
 						}
+						app.RecordFallbackUse(&fallbackAPIServer)
 						response = append(response, playerRes)
 						break
 					}
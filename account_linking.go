@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+Linking a Drasl account to an existing account, e.g. a Mojang account, for
+networks that accept both. A user proves they own the existing account the
+same way existing players do when registering, by setting a challenge skin on
+it, and the existing account's UUID is recorded alongside their Drasl
+account. Proxy plugins can then look up either identity from the other
+through /drasl/api/v2/server/linked-account and merge or migrate the
+player's data. The existing accounts come from the
+RegistrationExistingPlayer sources.
+*/
+
+var errLinkedAccountTaken = errors.New("account already linked")
+
+// Link user to the account `username` on `source`, replacing any account
+// they had already linked. The user must have set the challenge skin for
+// `challengeToken` on the account.
+func (app *App) LinkAccount(user *User, source *registrationExistingPlayerSource, username string, challengeToken string) (*LinkedAccount, error) {
+	details, err := validateChallenge(app, source, username, challengeToken, true)
+	if err != nil {
+		return nil, err
+	}
+
+	linkedAccount := LinkedAccount{
+		UUID:       details.UUID,
+		UserUUID:   user.UUID,
+		Source:     source.Nickname,
+		PlayerName: details.Username,
+		CreatedAt:  time.Now(),
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		// The existing account may already belong to someone else, either
+		// as a link or because they registered with its UUID
+		var count int64
+		if err := tx.Model(&LinkedAccount{}).Where("uuid = ? AND user_uuid != ?", details.UUID, user.UUID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errLinkedAccountTaken
+		}
+		if err := tx.Model(&User{}).Where("uuid = ? AND uuid != ?", details.UUID, user.UUID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return errLinkedAccountTaken
+		}
+
+		if err := tx.Where("user_uuid = ?", user.UUID).Delete(&LinkedAccount{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&linkedAccount).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &linkedAccount, nil
+}
+
+// The account user has linked, or nil if they haven't linked one
+func (app *App) GetLinkedAccount(user *User) (*LinkedAccount, error) {
+	var linkedAccount LinkedAccount
+	if err := app.DB.First(&linkedAccount, "user_uuid = ?", user.UUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &linkedAccount, nil
+}
+
+func (app *App) UnlinkAccount(user *User) error {
+	return app.DB.Where("user_uuid = ?", user.UUID).Delete(&LinkedAccount{}).Error
+}
+
+// The user who linked the existing account with the given UUID, if any
+func (app *App) FindUserByLinkedUUID(linkedUUID string) (*User, *LinkedAccount, error) {
+	var linkedAccount LinkedAccount
+	if err := app.DB.First(&linkedAccount, "uuid = ?", linkedUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", linkedAccount.UserUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return &user, &linkedAccount, nil
+}
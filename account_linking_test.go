@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestAccountLinking(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		config.AccountLinking.Allow = true
+		config.RegistrationExistingPlayer = registrationExistingPlayerConfig{
+			Nickname:           "Aux",
+			SessionURL:         ts.AuxApp.SessionURL,
+			AccountURL:         ts.AuxApp.AccountURL,
+			ChallengeExpireSec: DefaultConfig().RegistrationExistingPlayer.ChallengeExpireSec,
+		}
+		config.TrustedServers = []TrustedServer{{Nickname: "proxy", Token: "proxy-token-0123456789"}}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.AuxServer, EXISTING_USERNAME)
+
+		t.Run("Test account linking", ts.testAccountLinking)
+	}
+}
+
+func (ts *TestSuite) testAccountLinking(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	var auxUser User
+	assert.Nil(t, ts.AuxApp.DB.First(&auxUser, "username = ?", EXISTING_USERNAME).Error)
+
+	serverToken := "proxy-token-0123456789"
+	profileURL := ts.App.FrontEndURL + "/drasl/profile"
+	link := func(challengeToken string, cookie *http.Cookie) string {
+		form := url.Values{}
+		form.Set("username", EXISTING_USERNAME)
+		form.Set("source", "Aux")
+		form.Set("challengeToken", challengeToken)
+		form.Set("returnUrl", profileURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/link-account", form, []http.Cookie{*cookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	// Linking requires being logged in
+	rec := ts.Get(t, ts.Server, "/drasl/challenge-skin?link=true&username="+EXISTING_USERNAME, nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "Linking accounts is not allowed.", getErrorMessage(rec))
+
+	challengePath := "/drasl/challenge-skin?link=true&source=Aux&username=" + EXISTING_USERNAME
+	challengeToken := ts.solveSkinChallengeAt(t, challengePath, EXISTING_USERNAME, []http.Cookie{*browserTokenCookie})
+	assert.Equal(t, "Couldn't verify your skin, maybe try again?", link("This is not a valid challenge token.", browserTokenCookie))
+	assert.Equal(t, "", link(challengeToken.Value, browserTokenCookie))
+
+	linkedAccount, err := ts.App.GetLinkedAccount(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, auxUser.UUID, linkedAccount.UUID)
+	assert.Equal(t, EXISTING_USERNAME, linkedAccount.PlayerName)
+	assert.Equal(t, "Aux", linkedAccount.Source)
+
+	{
+		// Trusted servers can look up either account from the other
+		var response apiLinkedAccountResponse
+		rec = ts.Get(t, ts.Server, "/drasl/api/v1/server/linked-account?uuid="+user.UUID, nil, &serverToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, Unwrap(UUIDToID(auxUser.UUID)), response.LinkedID)
+		assert.Equal(t, EXISTING_USERNAME, response.LinkedName)
+
+		rec = ts.Get(t, ts.Server, "/drasl/api/v1/server/linked-account?linkedUuid="+Unwrap(UUIDToID(auxUser.UUID)), nil, &serverToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, Unwrap(UUIDToID(user.UUID)), response.ID)
+		assert.Equal(t, TEST_USERNAME, response.Name)
+
+		rec = ts.Get(t, ts.Server, "/drasl/api/v1/server/linked-account?uuid="+user.UUID, nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	{
+		// Someone else can't link the same account
+		otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, TEST_OTHER_USERNAME)
+		otherChallengeToken := ts.solveSkinChallengeAt(t, challengePath, EXISTING_USERNAME, []http.Cookie{*otherBrowserTokenCookie})
+		assert.Equal(t, "That account is already linked to another Drasl account.", link(otherChallengeToken.Value, otherBrowserTokenCookie))
+	}
+	{
+		form := url.Values{}
+		form.Set("returnUrl", profileURL)
+		rec = ts.PostForm(t, ts.Server, "/drasl/unlink-account", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		rec = ts.Get(t, ts.Server, "/drasl/api/v1/server/linked-account?uuid="+user.UUID, nil, &serverToken)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+/*
+Two-person approval for destructive admin actions. With
+TwoPersonApproval.Enable, deleting another user's account, deleting a group
+or a batch of gift codes, and rotating or deleting a forwarding secret don't
+happen right away. The admin who asks for one records a pending action, and
+a different admin has to approve it from the Admin page within
+TwoPersonApproval.ExpireSec. Requests, approvals, and cancellations all go
+in the audit log.
+*/
+
+const (
+	AdminActionDeleteUser             string = "delete-user"
+	AdminActionDeleteGroup            string = "delete-group"
+	AdminActionDeleteGiftCodes        string = "delete-gift-codes"
+	AdminActionRotateForwardingSecret string = "rotate-forwarding-secret"
+	AdminActionDeleteForwardingSecret string = "delete-forwarding-secret"
+)
+
+var errPendingAdminActionNotFound = errors.New("That request doesn't exist or has expired.")
+var errApproveOwnAdminAction = errors.New("A different admin has to approve this.")
+var errAdminActionTargetNotFound = errors.New("What this request was for no longer exists.")
+
+// Record `admin`'s request to do `action` to `target`: a username, group
+// name, gift code batch name, or forwarding secret backend. `targetUUID` is
+// the user's UUID for actions on a user, or empty.
+func (app *App) RequestAdminAction(admin *User, action string, target string, targetUUID string) (*PendingAdminAction, error) {
+	now := time.Now()
+	pending := PendingAdminAction{
+		Action:              action,
+		Target:              target,
+		TargetUUID:          targetUUID,
+		RequestedByUUID:     admin.UUID,
+		RequestedByUsername: admin.Username,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(time.Duration(app.Config().TwoPersonApproval.ExpireSec) * time.Second),
+	}
+	if err := app.DB.Create(&pending).Error; err != nil {
+		return nil, err
+	}
+	if err := app.LogAudit(admin, AuditActionRequestAdminAction, nil, pending.Describe()); err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// Pending actions that haven't expired, oldest first. Expired ones are
+// deleted.
+func (app *App) GetPendingAdminActions() ([]PendingAdminAction, error) {
+	if err := app.DB.Where("expires_at <= ?", time.Now()).Delete(&PendingAdminAction{}).Error; err != nil {
+		return nil, err
+	}
+	var pending []PendingAdminAction
+	err := app.DB.Order("created_at").Find(&pending).Error
+	return pending, err
+}
+
+func (app *App) getPendingAdminAction(id uint) (*PendingAdminAction, error) {
+	var pending PendingAdminAction
+	if err := app.DB.First(&pending, "id = ? AND expires_at > ?", id, time.Now()).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errPendingAdminActionNotFound
+		}
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// Approve a pending action on behalf of `admin` and carry it out. Returns a
+// message for the admin describing what was done.
+func (app *App) ApproveAdminAction(admin *User, id uint) (string, error) {
+	pending, err := app.getPendingAdminAction(id)
+	if err != nil {
+		return "", err
+	}
+	if pending.RequestedByUUID == admin.UUID {
+		return "", errApproveOwnAdminAction
+	}
+
+	// Delete first so the action can't be approved twice
+	result := app.DB.Delete(pending)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", errPendingAdminActionNotFound
+	}
+
+	details := fmt.Sprintf("%s (requested by %s)", pending.Describe(), pending.RequestedByUsername)
+	if err := app.LogAudit(admin, AuditActionApproveAdminAction, nil, details); err != nil {
+		return "", err
+	}
+	return app.doAdminAction(admin, pending)
+}
+
+// Withdraw or turn down a pending action. Any admin can cancel one.
+func (app *App) CancelAdminAction(admin *User, id uint) error {
+	pending, err := app.getPendingAdminAction(id)
+	if err != nil {
+		return err
+	}
+	if err := app.DB.Delete(pending).Error; err != nil {
+		return err
+	}
+	details := fmt.Sprintf("%s (requested by %s)", pending.Describe(), pending.RequestedByUsername)
+	return app.LogAudit(admin, AuditActionCancelAdminAction, nil, details)
+}
+
+func (app *App) doAdminAction(admin *User, pending *PendingAdminAction) (string, error) {
+	target := pending.Target
+	switch pending.Action {
+	case AdminActionDeleteUser:
+		var user User
+		if err := app.DB.First(&user, "uuid = ?", pending.TargetUUID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", errAdminActionTargetNotFound
+			}
+			return "", err
+		}
+		if err := DeleteUser(app, &user); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %s.", user.Username), nil
+	case AdminActionDeleteGroup:
+		var group Group
+		if err := app.DB.First(&group, "name = ?", target).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", errAdminActionTargetNotFound
+			}
+			return "", err
+		}
+		if err := app.DeleteGroup(&group); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Group %s deleted.", target), nil
+	case AdminActionDeleteGiftCodes:
+		count, err := app.DeleteGiftCodes(target)
+		if err != nil {
+			return "", err
+		}
+		if err := app.LogAudit(admin, AuditActionDeleteGiftCodes, nil, fmt.Sprintf("%s (%d)", target, count)); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted %d gift codes.", count), nil
+	case AdminActionRotateForwardingSecret:
+		if _, err := app.RotateForwardingSecret(target); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", errAdminActionTargetNotFound
+			}
+			return "", err
+		}
+		if err := app.LogAudit(admin, AuditActionRotateForwardingSecret, nil, target); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Rotated the forwarding secret for %s.", target), nil
+	case AdminActionDeleteForwardingSecret:
+		if err := app.DeleteForwardingSecret(target); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return "", errAdminActionTargetNotFound
+			}
+			return "", err
+		}
+		if err := app.LogAudit(admin, AuditActionDeleteForwardingSecret, nil, target); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Deleted the forwarding secret for %s.", target), nil
+	}
+	return "", fmt.Errorf("Unknown admin action %s", pending.Action)
+}
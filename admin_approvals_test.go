@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAdminApprovals(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin", "admin2"}
+		config.TwoPersonApproval.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test two-person approval", ts.testAdminApprovals)
+	}
+}
+
+func (ts *TestSuite) testAdminApprovals(t *testing.T) {
+	adminCookie := ts.CreateTestUser(ts.Server, "admin")
+	admin2Cookie := ts.CreateTestUser(ts.Server, "admin2")
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+	returnURL := ts.App.FrontEndURL + "/drasl/admin"
+	post := func(path string, form url.Values, cookie *http.Cookie) (string, string) {
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, path, form, []http.Cookie{*cookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		successMessage := Unwrap(url.QueryUnescape(getCookie(rec, "successMessage").Value))
+		return successMessage, getErrorMessage(rec)
+	}
+	latestPending := func() PendingAdminAction {
+		var pending PendingAdminAction
+		assert.Nil(t, ts.App.DB.Last(&pending).Error)
+		return pending
+	}
+	actionForm := func(pending PendingAdminAction) url.Values {
+		return url.Values{"id": {fmt.Sprint(pending.ID)}}
+	}
+
+	// Deleting a forwarding secret waits for a second admin
+	_, err := ts.App.CreateForwardingSecret("lobby")
+	assert.Nil(t, err)
+	successMessage, _ := post("/drasl/admin/delete-forwarding-secret", url.Values{"backend": {"lobby"}}, adminCookie)
+	assert.Equal(t, "Another admin has to approve this on the Admin page before it happens.", successMessage)
+	forwardingSecrets, err := ts.App.GetForwardingSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(forwardingSecrets))
+
+	pending := latestPending()
+	assert.Equal(t, AdminActionDeleteForwardingSecret, pending.Action)
+	assert.Equal(t, "lobby", pending.Target)
+
+	rec := ts.Get(t, ts.Server, "/drasl/admin", []http.Cookie{*admin2Cookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "delete-forwarding-secret lobby")
+
+	// The admin who asked can't approve it themselves
+	_, errorMessage := post("/drasl/admin/approve-action", actionForm(pending), adminCookie)
+	assert.Equal(t, "A different admin has to approve this.", errorMessage)
+
+	successMessage, errorMessage = post("/drasl/admin/approve-action", actionForm(pending), admin2Cookie)
+	assert.Equal(t, "", errorMessage)
+	assert.Equal(t, "Deleted the forwarding secret for lobby.", successMessage)
+	forwardingSecrets, err = ts.App.GetForwardingSecrets()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(forwardingSecrets))
+
+	// Each request can only be approved once
+	_, errorMessage = post("/drasl/admin/approve-action", actionForm(pending), admin2Cookie)
+	assert.Equal(t, "That request doesn't exist or has expired.", errorMessage)
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionApproveAdminAction).Error)
+	assert.Equal(t, "admin2", auditLogEntry.ActorUsername)
+	assert.Equal(t, "delete-forwarding-secret lobby (requested by admin)", auditLogEntry.Details)
+
+	// Deleting another user's account too
+	form := url.Values{}
+	form.Set("username", TEST_USERNAME)
+	form.Set("password", TEST_PASSWORD)
+	form.Set("confirmUsername", TEST_USERNAME)
+	successMessage, _ = post("/drasl/delete-user", form, adminCookie)
+	assert.Equal(t, "Another admin has to approve this on the Admin page before it happens.", successMessage)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	// Any admin can cancel a request
+	pending = latestPending()
+	assert.Equal(t, AdminActionDeleteUser, pending.Action)
+	successMessage, _ = post("/drasl/admin/cancel-action", actionForm(pending), adminCookie)
+	assert.Equal(t, "Request cancelled.", successMessage)
+	_, errorMessage = post("/drasl/admin/approve-action", actionForm(pending), admin2Cookie)
+	assert.Equal(t, "That request doesn't exist or has expired.", errorMessage)
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	// Requests expire
+	post("/drasl/delete-user", form, adminCookie)
+	pending = latestPending()
+	assert.Nil(t, ts.App.DB.Model(&pending).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, errorMessage = post("/drasl/admin/approve-action", actionForm(pending), admin2Cookie)
+	assert.Equal(t, "That request doesn't exist or has expired.", errorMessage)
+
+	// The account requested is the one deleted, even if it's renamed and
+	// someone else takes its username before the request is approved
+	post("/drasl/delete-user", form, adminCookie)
+	renamedUsername := "renamed"
+	assert.Nil(t, ts.App.DB.Model(&user).Updates(map[string]interface{}{
+		"username":               renamedUsername,
+		"normalized_username":    NormalizeName(renamedUsername),
+		"player_name":            renamedUsername,
+		"normalized_player_name": NormalizeName(renamedUsername),
+	}).Error)
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	successMessage, errorMessage = post("/drasl/admin/approve-action", actionForm(latestPending()), admin2Cookie)
+	assert.Equal(t, "", errorMessage)
+	assert.Equal(t, "Deleted "+renamedUsername+".", successMessage)
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&User{}).Where("uuid = ?", user.UUID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	assert.Nil(t, ts.App.DB.Model(&User{}).Where("username = ?", TEST_USERNAME).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	actions := []string{}
+	auditLog, err := ts.App.GetAuditLog(50)
+	assert.Nil(t, err)
+	for _, entry := range auditLog {
+		actions = append(actions, entry.Action)
+	}
+	assert.Contains(t, actions, AuditActionRequestAdminAction)
+	assert.Contains(t, actions, AuditActionCancelAdminAction)
+}
@@ -0,0 +1,1308 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Drasl's own JSON API, for launchers and other tools that want information
+about this instance beyond what the Yggdrasil and authlib-injector APIs offer.
+*/
+
+type apiInfoResponse struct {
+	InstanceName          string     `json:"instanceName"`
+	ImplementationVersion string     `json:"implementationVersion"`
+	FrontEndURL           string     `json:"frontEndUrl"`
+	AuthlibInjectorURL    string     `json:"authlibInjectorUrl"`
+	MOTD                  *string    `json:"motd"`
+	MOTDUpdatedAt         *time.Time `json:"motdUpdatedAt"`
+	Branding              struct {
+		LogoURL     string  `json:"logoUrl"`
+		FaviconURL  string  `json:"faviconUrl"`
+		AccentColor *string `json:"accentColor"`
+		FooterText  *string `json:"footerText"`
+	} `json:"branding"`
+}
+
+// GET /drasl/api/:version/info
+func APIInfo(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		res := apiInfoResponse{
+			InstanceName:          app.Config().InstanceName,
+			ImplementationVersion: Constants.Version,
+			FrontEndURL:           app.FrontEndURL,
+			AuthlibInjectorURL:    app.AuthlibInjectorURL,
+		}
+		res.Branding.LogoURL = app.LogoURL()
+		res.Branding.FaviconURL = app.FaviconURL()
+		if app.Config().Branding.AccentColor != "" {
+			res.Branding.AccentColor = &app.Config().Branding.AccentColor
+		}
+		if app.Config().Branding.FooterText != "" {
+			res.Branding.FooterText = &app.Config().Branding.FooterText
+		}
+
+		announcement, err := app.GetAnnouncement()
+		if err != nil {
+			return err
+		}
+		if announcement != nil {
+			res.MOTD = &announcement.Markdown
+			res.MOTDUpdatedAt = &announcement.UpdatedAt
+		}
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type apiPublicKey struct {
+	// PEM, as in the signaturePublickey of the authlib-injector metadata
+	PEM string `json:"pem"`
+	// Base64-encoded DER, as served by /minecraftservices/publickeys
+	Base64 string `json:"base64"`
+	// Lowercase hex SHA-256 of the DER encoding
+	SHA256Fingerprint string `json:"sha256Fingerprint"`
+}
+
+type apiPublicKeysResponse struct {
+	SignaturePublicKey  apiPublicKey   `json:"signaturePublicKey"`
+	ProfilePropertyKeys []apiPublicKey `json:"profilePropertyKeys"`
+}
+
+func makeAPIPublicKey(key *rsa.PublicKey) (apiPublicKey, error) {
+	pubDER, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return apiPublicKey{}, err
+	}
+	pubPEM, err := authlibInjectorSerializeKey(key)
+	if err != nil {
+		return apiPublicKey{}, err
+	}
+	sum := sha256.Sum256(pubDER)
+	return apiPublicKey{
+		PEM:               pubPEM,
+		Base64:            base64.StdEncoding.EncodeToString(pubDER),
+		SHA256Fingerprint: hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// GET /drasl/api/:version/public-keys
+func APIPublicKeys(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		signaturePublicKey, err := makeAPIPublicKey(&app.Key.PublicKey)
+		if err != nil {
+			return err
+		}
+		profilePropertyKeys := make([]apiPublicKey, 0, len(app.ProfilePropertyKeys()))
+		for _, key := range app.ProfilePropertyKeys() {
+			apiKey, err := makeAPIPublicKey(&key)
+			if err != nil {
+				return err
+			}
+			profilePropertyKeys = append(profilePropertyKeys, apiKey)
+		}
+		return c.JSON(http.StatusOK, apiPublicKeysResponse{
+			SignaturePublicKey:  signaturePublicKey,
+			ProfilePropertyKeys: profilePropertyKeys,
+		})
+	}
+}
+
+// GET /drasl/api/:version/public-key.pem
+func APIPublicKeyPEM(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		pubPEM, err := authlibInjectorSerializeKey(&app.Key.PublicKey)
+		if err != nil {
+			return err
+		}
+		return c.Blob(http.StatusOK, "application/x-pem-file", []byte(pubPEM))
+	}
+}
+
+type apiRegistrationSource struct {
+	Nickname   string  `json:"nickname"`
+	SetSkinURL *string `json:"setSkinUrl,omitempty"`
+}
+
+type apiRegistrationOptionsResponse struct {
+	NewPlayer struct {
+		Allow             bool `json:"allow"`
+		AllowChoosingUUID bool `json:"allowChoosingUuid"`
+		RequireInvite     bool `json:"requireInvite"`
+		RequireApproval   bool `json:"requireApproval"`
+	} `json:"newPlayer"`
+	ExistingPlayer struct {
+		Allow                   bool                    `json:"allow"`
+		RequireSkinVerification bool                    `json:"requireSkinVerification"`
+		RequireInvite           bool                    `json:"requireInvite"`
+		RequireApproval         bool                    `json:"requireApproval"`
+		Sources                 []apiRegistrationSource `json:"sources"`
+	} `json:"existingPlayer"`
+	RequireEmail      bool `json:"requireEmail"`
+	MinPasswordLength int  `json:"minPasswordLength"`
+	TermsOfService    struct {
+		URL               *string `json:"url"`
+		Version           *string `json:"version"`
+		RequireAcceptance bool    `json:"requireAcceptance"`
+	} `json:"termsOfService"`
+}
+
+// GET /drasl/api/:version/register
+func APIRegistrationOptions(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var res apiRegistrationOptionsResponse
+		res.NewPlayer.Allow = app.Config().RegistrationNewPlayer.Allow
+		res.NewPlayer.AllowChoosingUUID = app.Config().RegistrationNewPlayer.AllowChoosingUUID
+		res.NewPlayer.RequireInvite = app.Config().RegistrationNewPlayer.RequireInvite
+		res.NewPlayer.RequireApproval = app.Config().RegistrationNewPlayer.RequireApproval
+
+		res.ExistingPlayer.Allow = app.Config().RegistrationExistingPlayer.Allow
+		res.ExistingPlayer.RequireSkinVerification = app.Config().RegistrationExistingPlayer.RequireSkinVerification
+		res.ExistingPlayer.RequireInvite = app.Config().RegistrationExistingPlayer.RequireInvite
+		res.ExistingPlayer.RequireApproval = app.Config().RegistrationExistingPlayer.RequireApproval
+		res.ExistingPlayer.Sources = []apiRegistrationSource{}
+		if app.Config().RegistrationExistingPlayer.Allow {
+			for _, source := range app.Config().RegistrationExistingPlayer.AllSources() {
+				apiSource := apiRegistrationSource{Nickname: source.Nickname}
+				if source.SetSkinURL != "" {
+					apiSource.SetSkinURL = Ptr(source.SetSkinURL)
+				}
+				res.ExistingPlayer.Sources = append(res.ExistingPlayer.Sources, apiSource)
+			}
+		}
+
+		res.RequireEmail = RegistrationRequiresEmail(app)
+		res.MinPasswordLength = app.Config().MinPasswordLength
+		if termsOfServiceURL := app.TermsOfServiceURL(); termsOfServiceURL != "" {
+			res.TermsOfService.URL = &termsOfServiceURL
+			if app.Config().TermsOfService.Version != "" {
+				res.TermsOfService.Version = &app.Config().TermsOfService.Version
+			}
+		}
+		res.TermsOfService.RequireAcceptance = app.Config().TermsOfService.RequireAcceptance
+
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type apiChallengeSkinResponse struct {
+	ChallengeToken string    `json:"challengeToken"`
+	ExpiresAt      time.Time `json:"expiresAt"`
+	// A base64-encoded PNG
+	Skin string `json:"skin"`
+}
+
+// GET /drasl/api/:version/challenge-skin
+func APIChallengeSkin(app *App) func(c echo.Context) error {
+	verificationSkin := loadVerificationSkin(app)
+
+	return func(c echo.Context) error {
+		if !app.Config().RegistrationExistingPlayer.Allow || !app.Config().RegistrationExistingPlayer.RequireSkinVerification {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr(RegistrationErrorExistingPlayerNotAllowed), Ptr("Skin verification is not required on this server."))
+		}
+
+		username := c.QueryParam("username")
+		if err := ValidateUsername(app, username); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr(RegistrationErrorInvalidUsername), Ptr("Invalid username: "+err.Error()))
+		}
+		if getExistingPlayerSource(app, c.QueryParam("source")) == nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr(RegistrationErrorUnknownSource), Ptr("Unknown account provider."))
+		}
+
+		challengeToken, err := makeChallengeToken()
+		if err != nil {
+			return err
+		}
+		expiresAt, _ := challengeTokenExpiresAt(app, challengeToken)
+
+		challengeSkin, err := makeChallengeSkin(app, verificationSkin, username, challengeToken)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, apiChallengeSkinResponse{
+			ChallengeToken: challengeToken,
+			ExpiresAt:      expiresAt,
+			Skin:           base64.StdEncoding.EncodeToString(challengeSkin),
+		})
+	}
+}
+
+type apiRegisterRequest struct {
+	Username       string `json:"username"`
+	Password       string `json:"password"`
+	Email          string `json:"email"`
+	UUID           string `json:"uuid"`
+	InviteCode     string `json:"inviteCode"`
+	ExistingPlayer bool   `json:"existingPlayer"`
+	Source         string `json:"source"`
+	ChallengeToken string `json:"challengeToken"`
+	AcceptTerms    bool   `json:"acceptTerms"`
+}
+
+type apiRegisterResponse struct {
+	UUID            string `json:"uuid"`
+	Username        string `json:"username"`
+	PlayerName      string `json:"playerName"`
+	PendingApproval bool   `json:"pendingApproval"`
+}
+
+func registrationErrorStatus(code string) int {
+	switch code {
+	case RegistrationErrorUsernameTaken, RegistrationErrorUUIDTaken:
+		return http.StatusConflict
+	case RegistrationErrorAddressDenied,
+		RegistrationErrorNewPlayerNotAllowed,
+		RegistrationErrorExistingPlayerNotAllowed,
+		RegistrationErrorChoosingUUIDNotAllowed,
+		RegistrationErrorInviteNotFound,
+		RegistrationErrorExistingPlayerNotVerified:
+		return http.StatusForbidden
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// POST /drasl/api/:version/register
+// Errors have the same shape as Yggdrasil errors, with `error` set to one of
+// the RegistrationError codes. On success, the launcher can sign in with
+// /authenticate as usual.
+func APIRegister(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		req := new(apiRegisterRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("invalid_request"), Ptr("Invalid request body."))
+		}
+
+		user, err := app.RegisterUser(&RegistrationRequest{
+			Username:       req.Username,
+			Password:       req.Password,
+			Email:          req.Email,
+			ChosenUUID:     req.UUID,
+			InviteCode:     req.InviteCode,
+			ExistingPlayer: req.ExistingPlayer,
+			Source:         req.Source,
+			ChallengeToken: req.ChallengeToken,
+			IP:             c.RealIP(),
+			AcceptedTerms:  req.AcceptTerms,
+		})
+		if err != nil {
+			var registrationError *RegistrationError
+			if errors.As(err, &registrationError) {
+				return MakeErrorResponse(&c, registrationErrorStatus(registrationError.Code), &registrationError.Code, &registrationError.Message)
+			}
+			return err
+		}
+
+		return c.JSON(http.StatusOK, apiRegisterResponse{
+			UUID:            user.UUID,
+			Username:        user.Username,
+			PlayerName:      user.PlayerName,
+			PendingApproval: user.IsPendingApproval,
+		})
+	}
+}
+
+type apiDeviceCodeResponse struct {
+	DeviceCode              string `json:"deviceCode"`
+	UserCode                string `json:"userCode"`
+	VerificationURI         string `json:"verificationUri"`
+	VerificationURIComplete string `json:"verificationUriComplete"`
+	ExpiresIn               int    `json:"expiresIn"`
+	Interval                int    `json:"interval"`
+}
+
+// POST /drasl/api/:version/device/code
+// Start the device login flow. The launcher should show userCode and
+// verificationUri to the player, then poll /drasl/api/:version/device/token every
+// `interval` seconds.
+func APIDeviceCode(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().DeviceLogin.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr(DeviceLoginErrorNotAllowed), Ptr("Device login is not allowed on this server."))
+		}
+
+		deviceAuthorization, err := app.CreateDeviceAuthorization()
+		if err != nil {
+			return err
+		}
+
+		verificationURI, err := url.JoinPath(app.FrontEndURL, "drasl/device")
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, apiDeviceCodeResponse{
+			DeviceCode:              deviceAuthorization.DeviceCode,
+			UserCode:                deviceAuthorization.UserCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?" + url.Values{"code": {deviceAuthorization.UserCode}}.Encode(),
+			ExpiresIn:               app.Config().DeviceLogin.ExpireSec,
+			Interval:                app.Config().DeviceLogin.PollIntervalSec,
+		})
+	}
+}
+
+type apiDeviceTokenRequest struct {
+	DeviceCode  string  `json:"deviceCode"`
+	ClientToken *string `json:"clientToken"`
+	Agent       *Agent  `json:"agent"`
+	RequestUser bool    `json:"requestUser"`
+}
+
+// POST /drasl/api/:version/device/token
+// Once the player has approved the request, respond like /authenticate.
+// Until then, `error` is authorization_pending, slow_down, access_denied, or
+// expired_token, as in RFC 8628.
+func APIDeviceToken(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().DeviceLogin.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr(DeviceLoginErrorNotAllowed), Ptr("Device login is not allowed on this server."))
+		}
+
+		req := new(apiDeviceTokenRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("invalid_request"), Ptr("Invalid request body."))
+		}
+
+		user, errorCode, err := app.RedeemDeviceAuthorization(req.DeviceCode)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, &errorCode, Ptr(DEVICE_LOGIN_ERROR_MESSAGES[errorCode]))
+		}
+		if user.IsPendingApproval {
+			return c.JSONBlob(http.StatusForbidden, pendingApprovalBlob)
+		}
+		if user.IsLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(LockedMessage(user, time.Now())))
+		}
+		if allowed, reason := app.ExternalAuthAllows(user, c.RealIP(), c.Request().UserAgent()); !allowed {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(reason))
+		}
+
+		newDevice := !user.HasClient(req.ClientToken)
+		res, err := app.AuthenticateClient(user, req.ClientToken, req.Agent, req.RequestUser)
+		if err != nil {
+			return err
+		}
+		if newDevice {
+			app.NotifySecurityEvent(user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		}
+		app.PublishEvent(MakeEvent(EventLogin, user, c.RealIP()))
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type apiQRLoginRequest struct {
+	Token       string  `json:"token"`
+	ClientToken *string `json:"clientToken"`
+	Agent       *Agent  `json:"agent"`
+	RequestUser bool    `json:"requestUser"`
+}
+
+// POST /drasl/api/:version/qr-login
+// Sign a launcher in with the token from a QR code shown on the web
+// interface, responding like /authenticate. The token can only be used once.
+func APIQRLogin(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().QRLogin.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("qr_login_not_allowed"), Ptr("QR code login is not allowed on this server."))
+		}
+
+		req := new(apiQRLoginRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("invalid_request"), Ptr("Invalid request body."))
+		}
+
+		user, err := app.ClaimQRLogin(req.Token)
+		if err != nil {
+			if errors.Is(err, errQRLoginNotFound) {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr(DeviceLoginErrorExpiredToken), Ptr("That QR code is invalid or has expired."))
+			}
+			return err
+		}
+		if user.IsPendingApproval {
+			return c.JSONBlob(http.StatusForbidden, pendingApprovalBlob)
+		}
+		if user.IsLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(LockedMessage(user, time.Now())))
+		}
+		if allowed, reason := app.ExternalAuthAllows(user, c.RealIP(), c.Request().UserAgent()); !allowed {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(reason))
+		}
+
+		if err := app.DB.Preload("Clients").First(user, "uuid = ?", user.UUID).Error; err != nil {
+			return err
+		}
+		newDevice := !user.HasClient(req.ClientToken)
+		res, err := app.AuthenticateClient(user, req.ClientToken, req.Agent, req.RequestUser)
+		if err != nil {
+			return err
+		}
+		if newDevice {
+			app.NotifySecurityEvent(user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		}
+		app.PublishEvent(MakeEvent(EventLogin, user, c.RealIP()))
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type apiAdminUser struct {
+	UUID         string     `json:"uuid"`
+	Username     string     `json:"username"`
+	PlayerName   string     `json:"playerName"`
+	IsAdmin      bool       `json:"isAdmin"`
+	IsLocked     bool       `json:"isLocked"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	LastLoginAt  *time.Time `json:"lastLoginAt"`
+	StorageBytes int64      `json:"storageBytes"`
+}
+
+type apiAdminUsersResponse struct {
+	Users     []apiAdminUser `json:"users"`
+	Total     int64          `json:"total"`
+	Page      int            `json:"page"`
+	PageCount int            `json:"pageCount"`
+}
+
+// GET /drasl/api/:version/admin/users?page=...&sort=...
+// One page of the user list, filtered and sorted as on the Admin page.
+// Requires an admin's access token.
+func APIAdminUsers(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		query, err := ParseUserListQuery(c.QueryParams())
+		if err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr(err.Error()))
+		}
+		userList, err := app.ListUsers(query)
+		if err != nil {
+			return err
+		}
+
+		res := apiAdminUsersResponse{
+			Users:     make([]apiAdminUser, 0, len(userList.Entries)),
+			Total:     userList.Total,
+			Page:      query.Page,
+			PageCount: userList.PageCount,
+		}
+		for _, entry := range userList.Entries {
+			res.Users = append(res.Users, apiAdminUser{
+				UUID:         entry.User.UUID,
+				Username:     entry.User.Username,
+				PlayerName:   entry.User.PlayerName,
+				IsAdmin:      entry.User.IsAdmin,
+				IsLocked:     entry.User.IsLocked,
+				CreatedAt:    entry.User.CreatedAt,
+				LastLoginAt:  entry.LastLoginAt,
+				StorageBytes: entry.StorageBytes,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+type apiProfileProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type apiProfileProperties struct {
+	Properties []apiProfileProperty `json:"properties"`
+}
+
+func makeAPIProfileProperties(properties []UserProfileProperty) apiProfileProperties {
+	res := apiProfileProperties{Properties: make([]apiProfileProperty, 0, len(properties))}
+	for _, property := range properties {
+		res.Properties = append(res.Properties, apiProfileProperty{Name: property.Name, Value: property.Value})
+	}
+	return res
+}
+
+// GET /drasl/api/:version/admin/users/:uuid/properties
+// The custom profile properties set on a user, not including those from the
+// config file. Requires an admin's access token.
+func APIAdminUserProfileProperties(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "uuid = ?", c.Param("uuid")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+			}
+			return err
+		}
+		properties, err := app.GetUserProfileProperties(&targetUser)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, makeAPIProfileProperties(properties))
+	})
+}
+
+// PUT /drasl/api/:version/admin/users/:uuid/properties
+// Replace the custom profile properties set on a user. Requires an admin's
+// access token.
+func APIAdminSetUserProfileProperties(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "uuid = ?", c.Param("uuid")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+			}
+			return err
+		}
+
+		req := new(apiProfileProperties)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		properties := make([]UserProfileProperty, 0, len(req.Properties))
+		names := make([]string, 0, len(req.Properties))
+		for _, property := range req.Properties {
+			properties = append(properties, UserProfileProperty{Name: property.Name, Value: property.Value})
+			names = append(names, property.Name)
+		}
+		if err := ValidateUserProfileProperties(properties); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr(err.Error()))
+		}
+		if err := app.SetUserProfileProperties(&targetUser, properties); err != nil {
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionSetProfileProperties, &targetUser, "set "+strings.Join(names, ", ")); err != nil {
+			return err
+		}
+
+		properties, err := app.GetUserProfileProperties(&targetUser)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, makeAPIProfileProperties(properties))
+	})
+}
+
+type apiUserNote struct {
+	ID             uint      `json:"id"`
+	AuthorUsername string    `json:"authorUsername"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+type apiModerationEvent struct {
+	Time          time.Time `json:"time"`
+	Action        string    `json:"action"`
+	ActorUsername string    `json:"actorUsername"`
+	Details       string    `json:"details"`
+}
+
+type apiUserModeration struct {
+	Notes   []apiUserNote        `json:"notes"`
+	History []apiModerationEvent `json:"history"`
+}
+
+func makeAPIUserNote(note *UserNote) apiUserNote {
+	return apiUserNote{
+		ID:             note.ID,
+		AuthorUsername: note.AuthorUsername,
+		Body:           note.Body,
+		CreatedAt:      note.CreatedAt,
+	}
+}
+
+type apiSessionRecord struct {
+	Time              time.Time `json:"time"`
+	ServerAddressHash string    `json:"serverAddressHash"`
+	IP                *string   `json:"ip"`
+}
+
+// GET /drasl/api/:version/admin/users/:uuid/sessions
+// The servers a user has joined, newest first, if SessionHistory is enabled.
+// Requires an admin's access token.
+func APIAdminUserSessions(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+		if !app.Config().SessionHistory.Enable {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Session history is not enabled on this server."))
+		}
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "uuid = ?", c.Param("uuid")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+			}
+			return err
+		}
+		records, err := app.GetSessionHistory(&targetUser, SESSION_HISTORY_COUNT)
+		if err != nil {
+			return err
+		}
+
+		res := make([]apiSessionRecord, 0, len(records))
+		for _, record := range records {
+			res = append(res, apiSessionRecord{
+				Time:              record.CreatedAt,
+				ServerAddressHash: record.ServerAddressHash,
+				IP:                UnmakeNullString(&record.IP),
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// GET /drasl/api/:version/admin/users/:uuid/moderation
+// Staff notes on a user and their moderation history. Requires an admin's
+// access token.
+func APIAdminUserModeration(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "uuid = ?", c.Param("uuid")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+			}
+			return err
+		}
+		notes, err := app.GetUserNotes(&targetUser)
+		if err != nil {
+			return err
+		}
+		history, err := app.GetModerationHistory(&targetUser)
+		if err != nil {
+			return err
+		}
+
+		res := apiUserModeration{
+			Notes:   make([]apiUserNote, 0, len(notes)),
+			History: make([]apiModerationEvent, 0, len(history)),
+		}
+		for _, note := range notes {
+			res.Notes = append(res.Notes, makeAPIUserNote(&note))
+		}
+		for _, event := range history {
+			res.History = append(res.History, apiModerationEvent{
+				Time:          event.Time,
+				Action:        event.Action,
+				ActorUsername: event.ActorUsername,
+				Details:       event.Details,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+type apiAddUserNoteRequest struct {
+	Body string `json:"body"`
+}
+
+// POST /drasl/api/:version/admin/users/:uuid/notes
+// Leave a staff note on a user. Requires an admin's access token.
+func APIAdminAddUserNote(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "uuid = ?", c.Param("uuid")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+			}
+			return err
+		}
+
+		req := new(apiAddUserNoteRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		body := strings.TrimSpace(req.Body)
+		if err := ValidateUserNote(body); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid body: "+err.Error()))
+		}
+		note, err := app.AddUserNote(user, &targetUser, body)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, makeAPIUserNote(note))
+	})
+}
+
+type apiCosmetic struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	URL  string `json:"url"`
+	// UUIDs of the users granted the cosmetic directly
+	Users []string `json:"users"`
+	// Names of the groups granted the cosmetic
+	Groups []string `json:"groups"`
+}
+
+type apiCosmetics struct {
+	Cosmetics []apiCosmetic `json:"cosmetics"`
+}
+
+func makeAPICosmetic(app *App, cosmetic *Cosmetic) (apiCosmetic, error) {
+	capeURL, err := CapeURL(app, cosmetic.TextureHash)
+	if err != nil {
+		return apiCosmetic{}, err
+	}
+	users, groups, err := app.GetCosmeticGrants(cosmetic)
+	if err != nil {
+		return apiCosmetic{}, err
+	}
+	res := apiCosmetic{
+		ID:     cosmetic.UUID,
+		Name:   cosmetic.Name,
+		Kind:   cosmetic.Kind,
+		URL:    capeURL,
+		Users:  make([]string, 0, len(users)),
+		Groups: make([]string, 0, len(groups)),
+	}
+	for _, user := range users {
+		res.Users = append(res.Users, user.UUID)
+	}
+	for _, group := range groups {
+		res.Groups = append(res.Groups, group.Name)
+	}
+	return res, nil
+}
+
+// GET /drasl/api/:version/admin/cosmetics
+// Every cosmetic and who it's granted to. Requires an admin's access token.
+func APIAdminCosmetics(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		cosmetics, err := app.GetCosmetics()
+		if err != nil {
+			return err
+		}
+		res := apiCosmetics{Cosmetics: make([]apiCosmetic, 0, len(cosmetics))}
+		for _, cosmetic := range cosmetics {
+			apiCosmetic, err := makeAPICosmetic(app, &cosmetic)
+			if err != nil {
+				return err
+			}
+			res.Cosmetics = append(res.Cosmetics, apiCosmetic)
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// POST /drasl/api/:version/admin/cosmetics
+// Add a cape from the multipart `file`, called `name`. Requires an admin's
+// access token.
+func APIAdminCreateCosmetic(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		name := c.FormValue("name")
+		file, err := c.FormFile("file")
+		if err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Missing cape file."))
+		}
+		handle, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer handle.Close()
+
+		cosmetic, err := app.CreateCapeCosmetic(name, handle)
+		if errors.Is(err, errCosmeticNameTaken) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("A cosmetic with that name already exists."))
+		}
+		if err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid cosmetic: "+err.Error()))
+		}
+		if err := app.LogAudit(user, AuditActionCreateCosmetic, nil, cosmetic.Name); err != nil {
+			return err
+		}
+
+		res, err := makeAPICosmetic(app, cosmetic)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// The cosmetic named by the `id` path parameter, for admins only
+func withAdminCosmetic(app *App, f func(c echo.Context, user *User, cosmetic *Cosmetic) error) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+		cosmetic, err := app.GetCosmetic(c.Param("id"))
+		if errors.Is(err, errCosmeticNotFound) {
+			return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("Cosmetic not found."))
+		}
+		if err != nil {
+			return err
+		}
+		return f(c, user, cosmetic)
+	})
+}
+
+// DELETE /drasl/api/:version/admin/cosmetics/:id
+// Delete a cosmetic, taking it off anyone wearing it. Requires an admin's
+// access token.
+func APIAdminDeleteCosmetic(app *App) func(c echo.Context) error {
+	return withAdminCosmetic(app, func(c echo.Context, user *User, cosmetic *Cosmetic) error {
+		if err := app.DeleteCosmetic(cosmetic); err != nil {
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionDeleteCosmetic, nil, cosmetic.Name); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+type apiCosmeticGrantRequest struct {
+	// Either the UUID of a user or the name of a group
+	UserUUID *string `json:"userUuid"`
+	Group    *string `json:"group"`
+}
+
+func apiGrantOrRevokeCosmetic(app *App, grant bool) func(c echo.Context) error {
+	return withAdminCosmetic(app, func(c echo.Context, user *User, cosmetic *Cosmetic) error {
+		req := new(apiCosmeticGrantRequest)
+		if err := c.Bind(req); err != nil || (req.UserUUID == nil) == (req.Group == nil) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Give either a userUuid or a group."))
+		}
+
+		action := AuditActionRevokeCosmetic
+		if grant {
+			action = AuditActionGrantCosmetic
+		}
+		if req.UserUUID != nil {
+			var targetUser User
+			if err := app.DB.First(&targetUser, "uuid = ?", *req.UserUUID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("User not found."))
+				}
+				return err
+			}
+			var err error
+			if grant {
+				err = app.GrantCosmetic(cosmetic, &targetUser)
+			} else {
+				err = app.RevokeCosmetic(cosmetic, &targetUser)
+			}
+			if err != nil {
+				return err
+			}
+			if err := app.LogAudit(user, action, &targetUser, cosmetic.Name); err != nil {
+				return err
+			}
+		} else {
+			var group Group
+			if err := app.DB.First(&group, "name = ?", *req.Group).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("Group not found."))
+				}
+				return err
+			}
+			var err error
+			if grant {
+				err = app.GrantCosmeticToGroup(cosmetic, &group)
+			} else {
+				err = app.RevokeCosmeticFromGroup(cosmetic, &group)
+			}
+			if err != nil {
+				return err
+			}
+			if err := app.LogAudit(user, action, nil, cosmetic.Name+" for group "+group.Name); err != nil {
+				return err
+			}
+		}
+
+		res, err := makeAPICosmetic(app, cosmetic)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// POST /drasl/api/:version/admin/cosmetics/:id/grant
+// Grant a cosmetic to a user or a group. Requires an admin's access token.
+func APIAdminGrantCosmetic(app *App) func(c echo.Context) error {
+	return apiGrantOrRevokeCosmetic(app, true)
+}
+
+// POST /drasl/api/:version/admin/cosmetics/:id/revoke
+// Revoke a cosmetic from a user or a group. Members of a group keep it if
+// it's also granted to them some other way. Requires an admin's access token.
+func APIAdminRevokeCosmetic(app *App) func(c echo.Context) error {
+	return apiGrantOrRevokeCosmetic(app, false)
+}
+
+// Same fields as FallbackAPIServer
+type apiFallbackAPIServer struct {
+	Nickname         string   `json:"nickname"`
+	SessionURL       string   `json:"sessionUrl"`
+	AccountURL       string   `json:"accountUrl"`
+	ServicesURL      string   `json:"servicesUrl"`
+	SkinDomains      []string `json:"skinDomains"`
+	CacheTTLSeconds  int      `json:"cacheTtlSeconds"`
+	DenyUnknownUsers bool     `json:"denyUnknownUsers"`
+	ProxyTextures    bool     `json:"proxyTextures"`
+	CACertFile       string   `json:"caCertFile"`
+	PinnedPublicKeys []string `json:"pinnedPublicKeys"`
+	Address          string   `json:"address"`
+	Disabled         bool     `json:"disabled"`
+}
+
+type apiFallbackAPIServers struct {
+	FallbackAPIServers []apiFallbackAPIServer `json:"fallbackApiServers"`
+}
+
+func makeAPIFallbackAPIServers(fallbackAPIServers []FallbackAPIServer) apiFallbackAPIServers {
+	res := apiFallbackAPIServers{FallbackAPIServers: make([]apiFallbackAPIServer, 0, len(fallbackAPIServers))}
+	for _, fallbackAPIServer := range fallbackAPIServers {
+		res.FallbackAPIServers = append(res.FallbackAPIServers, apiFallbackAPIServer(fallbackAPIServer))
+	}
+	return res
+}
+
+// GET /drasl/api/:version/admin/fallback-api-servers
+// The fallback API servers, in the order they're tried. Requires an admin's
+// access token.
+func APIAdminFallbackAPIServers(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+		return c.JSON(http.StatusOK, makeAPIFallbackAPIServers(app.Config().FallbackAPIServers))
+	})
+}
+
+// PUT /drasl/api/:version/admin/fallback-api-servers
+// Replace the fallback API servers. Requires an admin's access token.
+func APIAdminSetFallbackAPIServers(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		req := new(apiFallbackAPIServers)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		fallbackAPIServers := make([]FallbackAPIServer, 0, len(req.FallbackAPIServers))
+		nicknames := make([]string, 0, len(req.FallbackAPIServers))
+		for _, fallbackAPIServer := range req.FallbackAPIServers {
+			fallbackAPIServers = append(fallbackAPIServers, FallbackAPIServer(fallbackAPIServer))
+			nicknames = append(nicknames, fallbackAPIServer.Nickname)
+		}
+		if err := app.SetFallbackAPIServers(fallbackAPIServers); err != nil {
+			var invalidError *InvalidFallbackAPIServersError
+			if errors.As(err, &invalidError) {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr(err.Error()))
+			}
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionUpdateFallbackAPIServers, nil, "set "+strings.Join(nicknames, ", ")); err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, makeAPIFallbackAPIServers(app.Config().FallbackAPIServers))
+	})
+}
+
+type apiTestFallbackAPIServerResponse struct {
+	Reachable bool `json:"reachable"`
+	// Why the server isn't reachable, or null
+	Error *string `json:"error"`
+}
+
+// POST /drasl/api/:version/admin/fallback-api-servers/test
+// Check that a fallback API server, not necessarily one already added, can be
+// reached. Requires an admin's access token.
+func APIAdminTestFallbackAPIServer(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		if !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("You are not an admin."))
+		}
+
+		req := new(apiFallbackAPIServer)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		fallbackAPIServer := FallbackAPIServer(*req)
+		if err := app.ProbeFallbackAPIServer(&fallbackAPIServer); err != nil {
+			return c.JSON(http.StatusOK, apiTestFallbackAPIServerResponse{Reachable: false, Error: Ptr(err.Error())})
+		}
+		return c.JSON(http.StatusOK, apiTestFallbackAPIServerResponse{Reachable: true})
+	})
+}
+
+type apiPlayerSearchResponse struct {
+	Players []Profile `json:"players"`
+	// Pass as `after` to get the next page, or null if this is the last page
+	Next *string `json:"next"`
+}
+
+// GET /drasl/api/:version/players?prefix=...&after=...&limit=...
+// Find players whose name starts with `prefix`. Requires an access token.
+func APIPlayerSearch(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, _ *User) error {
+		if !app.Config().PlayerSearch.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Player search is not allowed on this server."))
+		}
+
+		limit := PLAYER_SEARCH_DEFAULT_LIMIT
+		if limitParam := c.QueryParam("limit"); limitParam != "" {
+			var err error
+			limit, err = strconv.Atoi(limitParam)
+			if err != nil || limit <= 0 {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid limit."))
+			}
+		}
+		if limit > app.Config().PlayerSearch.MaxResults {
+			limit = app.Config().PlayerSearch.MaxResults
+		}
+
+		users, err := SearchPlayers(app.DB, c.QueryParam("prefix"), c.QueryParam("after"), limit)
+		if err != nil {
+			return err
+		}
+
+		res := apiPlayerSearchResponse{Players: make([]Profile, 0, len(users))}
+		for _, user := range users {
+			id, err := UUIDToID(user.UUID)
+			if err != nil {
+				return err
+			}
+			res.Players = append(res.Players, Profile{ID: id, Name: user.PlayerName})
+		}
+		if len(users) == limit {
+			res.Next = &users[len(users)-1].NormalizedPlayerName
+		}
+
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+type apiReportRequest struct {
+	PlayerName string `json:"playerName"`
+	Reason     string `json:"reason"`
+	Details    string `json:"details"`
+}
+
+type apiReportResponse struct {
+	ID         uint      `json:"id"`
+	PlayerName string    `json:"playerName"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// POST /drasl/api/:version/reports
+// Report a player to the admins. Requires an access token.
+func APIReport(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		req := new(apiReportRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+
+		if !app.Config().Reports.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Reports are not allowed on this server."))
+		}
+		if err := ValidateReportReason(req.Reason); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid reason: "+err.Error()))
+		}
+		if err := ValidateReportDetails(req.Details); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid details: "+err.Error()))
+		}
+
+		report, err := app.CreateReport(user, req.PlayerName, req.Reason, req.Details)
+		if err != nil {
+			switch {
+			case errors.Is(err, errReportTargetNotFound):
+				return MakeErrorResponse(&c, http.StatusNotFound, nil, Ptr("Player not found."))
+			case errors.Is(err, errReportSelf):
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("You can't report yourself."))
+			case errors.Is(err, errReportLimit):
+				return MakeErrorResponse(&c, http.StatusTooManyRequests, Ptr("TooManyRequestsException"), Ptr("You've sent too many reports. Try again tomorrow."))
+			}
+			return err
+		}
+
+		return c.JSON(http.StatusOK, apiReportResponse{
+			ID:         report.ID,
+			PlayerName: report.TargetPlayerName,
+			Reason:     report.Reason,
+			Status:     report.Status,
+			CreatedAt:  report.CreatedAt,
+		})
+	})
+}
+
+type apiSession struct {
+	UUID       string    `json:"uuid"`
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	AuthOnly   bool      `json:"authOnly"`
+}
+
+// GET /drasl/api/:version/profile/sessions
+// The launchers signed in to the user's account. Requires an API token with
+// the sessions scope.
+func APIProfileSessions(app *App) func(c echo.Context) error {
+	return withAPIToken(app, APITokenScopeSessions, func(c echo.Context, user *User) error {
+		var clients []Client
+		if err := app.DB.Order("last_used_at DESC").Find(&clients, "user_uuid = ?", user.UUID).Error; err != nil {
+			return err
+		}
+		res := make([]apiSession, 0, len(clients))
+		for _, client := range clients {
+			res = append(res, apiSession{
+				UUID:       client.UUID,
+				Name:       client.Name,
+				CreatedAt:  client.CreatedAt,
+				LastUsedAt: client.LastUsedAt,
+				AuthOnly:   client.AuthOnly,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// PUT /drasl/api/:version/profile/skin
+// Set the user's skin from the multipart `file`. The model is `variant`,
+// "classic" or "slim", or is detected from the skin if `variant` is omitted.
+// Requires an API token with the skin scope.
+func APIProfileSetSkin(app *App) func(c echo.Context) error {
+	return withAPIToken(app, APITokenScopeSkin, func(c echo.Context, user *User) error {
+		if !app.Config().AllowSkins && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Setting a skin is not allowed."))
+		}
+		if user.SkinLocked && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(errSkinLocked.Error()))
+		}
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Missing skin file."))
+		}
+		handle, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer handle.Close()
+		data, err := ReadTextureUpload(handle)
+		if err != nil {
+			return err
+		}
+
+		skinModel := c.FormValue("variant")
+		if skinModel == "" {
+			skinModel, err = DetectSkinModelPNG(bytes.NewReader(data))
+			if err != nil {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid skin: "+err.Error()))
+			}
+		} else if !IsValidSkinModel(skinModel) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid variant."))
+		}
+
+		user.SkinModel = skinModel
+		if err := SetSkinAndSave(app, user, bytes.NewReader(data)); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid skin: "+err.Error()))
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// DELETE /drasl/api/:version/profile/skin
+// Requires an API token with the skin scope.
+func APIProfileDeleteSkin(app *App) func(c echo.Context) error {
+	return withAPIToken(app, APITokenScopeSkin, func(c echo.Context, user *User) error {
+		if user.SkinLocked && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(errSkinLocked.Error()))
+		}
+		if err := SetSkinAndSave(app, user, nil); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// PUT /drasl/api/:version/profile/cape
+// Set the user's cape from the multipart `file`. Requires an API token with
+// the cape scope.
+func APIProfileSetCape(app *App) func(c echo.Context) error {
+	return withAPIToken(app, APITokenScopeCape, func(c echo.Context, user *User) error {
+		if !app.Config().AllowCapes && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Setting a cape is not allowed."))
+		}
+		if user.CapeLocked && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(errCapeLocked.Error()))
+		}
+
+		file, err := c.FormFile("file")
+		if err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Missing cape file."))
+		}
+		handle, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer handle.Close()
+
+		if err := SetCapeAndSave(app, user, handle); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid cape: "+err.Error()))
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
+
+// DELETE /drasl/api/:version/profile/cape
+// Requires an API token with the cape scope.
+func APIProfileDeleteCape(app *App) func(c echo.Context) error {
+	return withAPIToken(app, APITokenScopeCape, func(c echo.Context, user *User) error {
+		if user.CapeLocked && !user.IsAdmin {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(errCapeLocked.Error()))
+		}
+		if err := SetCapeAndSave(app, user, nil); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+}
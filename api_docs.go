@@ -0,0 +1,203 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+The API documentation page at /drasl/api-docs lists the endpoints of the
+instance, with the instance's URLs filled in. It's built from the routes
+registered with Echo, so it stays in sync with GetServer: a route shows up in
+the section its path falls under, unless the feature it belongs to is
+disabled. Routes outside every section, like the web front end, aren't listed,
+and Drasl's own API is listed under its current version only.
+*/
+
+type apiDocsSection struct {
+	Name        string
+	Description string
+	// A route is in the section if its path is under one of Prefixes, which
+	// end in a slash, or is a prefix without the slash
+	Prefixes []string
+	BaseURL  func(app *App) string
+	Enabled  func(config *Config) bool
+}
+
+func apiDocsAlways(config *Config) bool {
+	return true
+}
+
+var apiDocsSections = []apiDocsSection{
+	{
+		Name:        "authlib-injector",
+		Description: "Metadata for authlib-injector. Pass this URL to authlib-injector to use this instance.",
+		Prefixes:    []string{"/authlib-injector/"},
+		BaseURL:     func(app *App) string { return app.AuthlibInjectorURL },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "Authentication",
+		Description: "Yggdrasil authentication, used by launchers to log in.",
+		Prefixes:    []string{"/auth/"},
+		BaseURL:     func(app *App) string { return app.AuthURL },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "Account",
+		Description: "Player name and UUID lookups.",
+		Prefixes:    []string{"/account/"},
+		BaseURL:     func(app *App) string { return app.AccountURL },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "Session",
+		Description: "Joining servers and fetching profiles, used by clients and servers.",
+		Prefixes:    []string{"/session/"},
+		BaseURL:     func(app *App) string { return app.SessionURL },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "Services",
+		Description: "Profile, skin, cape, and key management, used by modern clients.",
+		Prefixes:    []string{"/services/"},
+		BaseURL:     func(app *App) string { return app.ServicesURL },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "Drasl API",
+		Description: "Drasl's own API. See the README for details.",
+		Prefixes:    []string{"/drasl/api/"},
+		BaseURL:     func(app *App) string { return app.FrontEndURL + API_PATH_PREFIX + app.CurrentAPIVersion() },
+		Enabled:     apiDocsAlways,
+	},
+	{
+		Name:        "ely.by skin system",
+		Description: "A subset of the ely.by skin system API.",
+		Prefixes:    []string{"/skins/", "/cloaks/", "/textures/"},
+		BaseURL:     func(app *App) string { return app.FrontEndURL },
+		Enabled:     func(config *Config) bool { return config.ElyByCompatibility.Enable },
+	},
+	{
+		Name:        "Microsoft and Xbox Live sign-in",
+		Description: "Emulated Microsoft and Xbox Live sign-in for patched launchers.",
+		Prefixes:    []string{"/msa/", "/xbl/", "/xsts/"},
+		BaseURL:     func(app *App) string { return app.FrontEndURL },
+		Enabled:     func(config *Config) bool { return config.MSACompatibility.Enable },
+	},
+	{
+		Name:        "Legacy authentication",
+		Description: "The pre-Yggdrasil authentication protocol of Minecraft Alpha and Beta through 1.5.",
+		Prefixes:    []string{"/legacy/", "/game/"},
+		BaseURL:     func(app *App) string { return app.FrontEndURL },
+		Enabled:     func(config *Config) bool { return config.LegacyAuthentication.Enable },
+	},
+}
+
+// Parts of sections that only work when some feature is enabled, by path
+// prefix
+var apiDocsFeatures = []struct {
+	Prefix  string
+	Enabled func(config *Config) bool
+}{
+	{"/drasl/api/:version/admin/users/:uuid/sessions", func(config *Config) bool { return config.SessionHistory.Enable }},
+	{"/drasl/api/:version/challenge-skin", func(config *Config) bool { return config.RegistrationExistingPlayer.Allow }},
+	{"/drasl/api/:version/device/", func(config *Config) bool { return config.DeviceLogin.Allow }},
+	{"/drasl/api/:version/events", func(config *Config) bool { return config.EventStream.Enable }},
+	{"/drasl/api/:version/introspect", func(config *Config) bool { return len(config.TrustedServers) > 0 }},
+	{"/drasl/api/:version/players", func(config *Config) bool { return config.PlayerSearch.Allow }},
+	{"/drasl/api/:version/profile/", func(config *Config) bool { return config.APITokens.Allow }},
+	{"/drasl/api/:version/qr-login", func(config *Config) bool { return config.QRLogin.Allow }},
+	{"/drasl/api/:version/reports", func(config *Config) bool { return config.Reports.Allow }},
+	{"/drasl/api/:version/server/bedrock-link", func(config *Config) bool { return config.Floodgate.Enable }},
+	{"/drasl/api/:version/server/commands", func(config *Config) bool { return config.InGameCommands.Allow && len(config.TrustedServers) > 0 }},
+	{"/drasl/api/:version/server/linked-account", func(config *Config) bool { return config.AccountLinking.Allow }},
+	{"/drasl/api/:version/server/", func(config *Config) bool { return len(config.TrustedServers) > 0 }},
+	{"/drasl/api/:version/statistics", func(config *Config) bool { return config.Statistics.Allow }},
+	{"/account/orders/statistics", func(config *Config) bool { return config.Statistics.Allow }},
+}
+
+type apiDocsRoute struct {
+	Method string
+	Path   string
+	URL    string
+}
+
+type apiDocsSectionRoutes struct {
+	Name        string
+	Description string
+	BaseURL     string
+	Routes      []apiDocsRoute
+}
+
+func apiDocsRouteEnabled(config *Config, path string) bool {
+	for _, feature := range apiDocsFeatures {
+		if strings.HasPrefix(path, feature.Prefix) {
+			return feature.Enabled(config)
+		}
+	}
+	return true
+}
+
+var apiDocsMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// Group the enabled routes among `routes` into the sections of the API
+// documentation
+func (app *App) APIDocs(routes []*echo.Route) []apiDocsSectionRoutes {
+	sections := []apiDocsSectionRoutes{}
+	for _, section := range apiDocsSections {
+		if !section.Enabled(app.Config()) {
+			continue
+		}
+		sectionRoutes := apiDocsSectionRoutes{
+			Name:        section.Name,
+			Description: section.Description,
+			BaseURL:     section.BaseURL(app),
+			Routes:      []apiDocsRoute{},
+		}
+		seen := map[string]bool{}
+		for _, route := range routes {
+			if !apiDocsMethods[route.Method] || !apiDocsRouteEnabled(app.Config(), route.Path) {
+				continue
+			}
+			inSection := false
+			for _, prefix := range section.Prefixes {
+				if strings.HasPrefix(route.Path, prefix) || route.Path == strings.TrimSuffix(prefix, "/") {
+					inSection = true
+					break
+				}
+			}
+			// Versioned routes are listed under the current version only
+			path := strings.Replace(route.Path, API_PATH_PREFIX+":version/", API_PATH_PREFIX+app.CurrentAPIVersion()+"/", 1)
+			key := route.Method + " " + path
+			if !inSection || seen[key] {
+				continue
+			}
+			seen[key] = true
+			sectionRoutes.Routes = append(sectionRoutes.Routes, apiDocsRoute{
+				Method: route.Method,
+				Path:   path,
+				URL:    app.FrontEndURL + path,
+			})
+		}
+		sort.Slice(sectionRoutes.Routes, func(i, j int) bool {
+			a, b := sectionRoutes.Routes[i], sectionRoutes.Routes[j]
+			if a.Path != b.Path {
+				return a.Path < b.Path
+			}
+			return a.Method < b.Method
+		})
+		if len(sectionRoutes.Routes) > 0 {
+			sections = append(sections, sectionRoutes)
+		}
+	}
+	return sections
+}
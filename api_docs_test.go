@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestAPIDocs(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.LegacyAuthentication.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test API docs", ts.testAPIDocs)
+	}
+}
+
+func (ts *TestSuite) testAPIDocs(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/drasl/api-docs", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "https://drasl.example.com/auth/authenticate")
+	assert.Contains(t, body, "https://drasl.example.com/session/session/minecraft/hasJoined")
+	assert.Contains(t, body, "https://drasl.example.com/drasl/api/v2/info")
+	assert.Contains(t, body, "https://drasl.example.com/game/checkserver.jsp")
+
+	// Disabled features and the web front end aren't listed
+	assert.NotContains(t, body, "/drasl/api/v2/qr-login")
+	assert.NotContains(t, body, "/xbl/user/authenticate")
+	assert.NotContains(t, body, "/drasl/profile")
+
+	names := func() []string {
+		names := []string{}
+		for _, section := range ts.App.APIDocs(ts.Server.Routes()) {
+			names = append(names, section.Name)
+		}
+		return names
+	}
+	assert.NotContains(t, names(), "Microsoft and Xbox Live sign-in")
+
+	// Routes are only listed once per section
+	for _, section := range ts.App.APIDocs(ts.Server.Routes()) {
+		if section.Name != "Authentication" {
+			continue
+		}
+		count := 0
+		for _, route := range section.Routes {
+			if route.Method == http.MethodPost && route.Path == "/auth/authenticate" {
+				count += 1
+			}
+		}
+		assert.Equal(t, 1, count)
+		assert.Equal(t, "https://drasl.example.com/auth", section.BaseURL)
+	}
+
+	ts.App.Config().QRLogin.Allow = true
+	defer func() { ts.App.Config().QRLogin.Allow = false }()
+	rec = ts.Get(t, ts.Server, "/drasl/api-docs", nil, nil)
+	assert.Contains(t, rec.Body.String(), "https://drasl.example.com/drasl/api/v2/qr-login")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Requests made with personal API tokens are rate limited per token, and each
+token has a daily quota. Both default to APITokens.RequestsPerMinute and
+APITokens.DailyQuota, and admins can change them for a single token on the
+API Usage page, which also lists the tokens that made the most requests.
+Requests are counted per UTC day in the database, so quotas survive a
+restart; the rate limits are kept in memory. Responses tell the client how
+much of each is left in the X-RateLimit-* and X-Quota-* headers.
+*/
+
+// Days of usage kept for the API Usage page
+const API_TOKEN_USAGE_DAYS = 30
+
+const API_TOKEN_USAGE_PRUNE_INTERVAL = time.Hour
+
+// Number of tokens listed on the API Usage page
+const TOP_API_TOKENS_COUNT = 50
+
+// The token's rate limit, in requests per minute, or 0 if it has none
+func (apiToken *APIToken) EffectiveRequestsPerMinute(app *App) int {
+	if apiToken.RequestsPerMinute.Valid {
+		return int(apiToken.RequestsPerMinute.Int64)
+	}
+	return app.Config().APITokens.RequestsPerMinute
+}
+
+// The token's daily quota, in requests, or 0 if it has none
+func (apiToken *APIToken) EffectiveDailyQuota(app *App) int {
+	if apiToken.DailyQuota.Valid {
+		return int(apiToken.DailyQuota.Int64)
+	}
+	return app.Config().APITokens.DailyQuota
+}
+
+type apiTokenLimiter struct {
+	requestsPerMinute int
+	limiter           *rate.Limiter
+}
+
+// Per-token rate limiters, each allowing a burst of a full minute's requests
+type APITokenLimiters struct {
+	mutex sync.Mutex
+	// Keyed by APIToken.ID
+	limiters map[string]*apiTokenLimiter
+}
+
+func NewAPITokenLimiters() *APITokenLimiters {
+	return &APITokenLimiters{limiters: map[string]*apiTokenLimiter{}}
+}
+
+// Take a request from the token's limit, returning whether there was one
+// left and how many are left now. Changing a token's limit starts counting
+// afresh.
+func (limiters *APITokenLimiters) Allow(apiTokenID string, requestsPerMinute int) (bool, int) {
+	limiters.mutex.Lock()
+	defer limiters.mutex.Unlock()
+
+	entry, ok := limiters.limiters[apiTokenID]
+	if !ok || entry.requestsPerMinute != requestsPerMinute {
+		entry = &apiTokenLimiter{
+			requestsPerMinute: requestsPerMinute,
+			limiter:           rate.NewLimiter(rate.Limit(float64(requestsPerMinute)/60), requestsPerMinute),
+		}
+		limiters.limiters[apiTokenID] = entry
+	}
+	allowed := entry.limiter.Allow()
+	return allowed, int(math.Max(0, math.Floor(entry.limiter.Tokens())))
+}
+
+// Count a request against the token's daily quota, unless it's used up.
+// Returns whether the request is allowed and the number of requests made
+// today, including this one if it is.
+func (app *App) countAPITokenRequest(apiToken *APIToken, dailyQuota int, now time.Time) (bool, int64, error) {
+	quota := int64(math.MaxInt64)
+	if dailyQuota > 0 {
+		quota = int64(dailyQuota)
+	}
+	day := statDay(now)
+
+	// Increment and check in one statement, so concurrent requests can't
+	// go over the quota together
+	var counts []int64
+	err := app.DB.Raw(
+		"INSERT INTO api_token_usages (api_token_id, day, requests) VALUES (?, ?, 1) ON CONFLICT (api_token_id, day) DO UPDATE SET requests = requests + 1 WHERE requests < ? RETURNING requests",
+		apiToken.ID, day, quota,
+	).Scan(&counts).Error
+	if err != nil {
+		return false, 0, err
+	}
+	if len(counts) > 0 {
+		return true, counts[0], nil
+	}
+	return false, quota, nil
+}
+
+// Apply the token's rate limit and daily quota to a request, setting the
+// X-RateLimit-* and X-Quota-* headers. Responds with 429 Too Many Requests
+// and returns false if the token is over either.
+func (app *App) limitAPIToken(c echo.Context, apiToken *APIToken) (bool, error) {
+	header := c.Response().Header()
+	now := time.Now()
+
+	if requestsPerMinute := apiToken.EffectiveRequestsPerMinute(app); requestsPerMinute > 0 {
+		allowed, remaining := app.APITokenLimiters.Allow(apiToken.ID, requestsPerMinute)
+		header.Set("X-RateLimit-Limit", strconv.Itoa(requestsPerMinute))
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			header.Set("Retry-After", strconv.Itoa(int(math.Ceil(60/float64(requestsPerMinute)))))
+			return false, MakeErrorResponse(&c, http.StatusTooManyRequests, nil, Ptr("Too many requests. Try again later."))
+		}
+	}
+
+	dailyQuota := apiToken.EffectiveDailyQuota(app)
+	allowed, used, err := app.countAPITokenRequest(apiToken, dailyQuota, now)
+	if err != nil {
+		return false, err
+	}
+	if dailyQuota > 0 {
+		year, month, day := now.UTC().Date()
+		reset := time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		secondsUntilReset := strconv.Itoa(int(math.Ceil(reset.Sub(now).Seconds())))
+		header.Set("X-Quota-Limit", strconv.Itoa(dailyQuota))
+		header.Set("X-Quota-Remaining", strconv.FormatInt(int64(dailyQuota)-used, 10))
+		header.Set("X-Quota-Reset", secondsUntilReset)
+		if !allowed {
+			header.Set("Retry-After", secondsUntilReset)
+			return false, MakeErrorResponse(&c, http.StatusTooManyRequests, nil, Ptr("This token's daily quota is used up. Try again tomorrow."))
+		}
+	}
+	return true, nil
+}
+
+// Override the token's rate limit and daily quota. A nil limit goes back to
+// the default from the config.
+func (app *App) SetAPITokenLimits(admin *User, apiToken *APIToken, requestsPerMinute *int, dailyQuota *int) error {
+	toNullInt64 := func(value *int) sql.NullInt64 {
+		if value == nil {
+			return sql.NullInt64{}
+		}
+		return sql.NullInt64{Int64: int64(*value), Valid: true}
+	}
+	apiToken.RequestsPerMinute = toNullInt64(requestsPerMinute)
+	apiToken.DailyQuota = toNullInt64(dailyQuota)
+	err := app.DB.Model(&APIToken{}).Where("id = ?", apiToken.ID).Updates(map[string]interface{}{
+		"requests_per_minute": apiToken.RequestsPerMinute,
+		"daily_quota":         apiToken.DailyQuota,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	limitString := func(value *int) string {
+		if value == nil {
+			return "default"
+		}
+		return strconv.Itoa(*value)
+	}
+	details := fmt.Sprintf("token %s: %s requests per minute, daily quota %s", apiToken.Name, limitString(requestsPerMinute), limitString(dailyQuota))
+	return app.LogAudit(admin, AuditActionSetAPITokenLimits, &apiToken.User, details)
+}
+
+// An API token and how many requests it made, for the API Usage page
+type APITokenUsageSummary struct {
+	APIToken APIToken
+	Today    int64
+	// Over the last API_TOKEN_USAGE_DAYS days
+	Total int64
+}
+
+// The tokens that made the most requests today, then over the last
+// API_TOKEN_USAGE_DAYS days
+func (app *App) GetTopAPITokens(now time.Time) ([]APITokenUsageSummary, error) {
+	var rows []struct {
+		APITokenID string
+		Today      int64
+		Total      int64
+	}
+	err := app.DB.Model(&APITokenUsage{}).
+		Select("api_token_id, SUM(CASE WHEN day = ? THEN requests ELSE 0 END) AS today, SUM(requests) AS total", statDay(now)).
+		Where("day > ?", statDay(now.AddDate(0, 0, -API_TOKEN_USAGE_DAYS))).
+		Group("api_token_id").
+		Order("today DESC, total DESC").
+		Limit(TOP_API_TOKENS_COUNT).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.APITokenID)
+	}
+	var apiTokens []APIToken
+	if err := app.DB.Preload("User").Where("id IN ?", ids).Find(&apiTokens).Error; err != nil {
+		return nil, err
+	}
+	apiTokensByID := map[string]APIToken{}
+	for _, apiToken := range apiTokens {
+		apiTokensByID[apiToken.ID] = apiToken
+	}
+
+	summaries := make([]APITokenUsageSummary, 0, len(rows))
+	for _, row := range rows {
+		apiToken, ok := apiTokensByID[row.APITokenID]
+		if !ok {
+			continue
+		}
+		summaries = append(summaries, APITokenUsageSummary{
+			APIToken: apiToken,
+			Today:    row.Today,
+			Total:    row.Total,
+		})
+	}
+	return summaries, nil
+}
+
+// Delete usage older than API_TOKEN_USAGE_DAYS
+func (app *App) PruneAPITokenUsage(now time.Time) error {
+	cutoff := statDay(now.AddDate(0, 0, -API_TOKEN_USAGE_DAYS))
+	return app.DB.Where("day <= ?", cutoff).Delete(&APITokenUsage{}).Error
+}
+
+func (app *App) RunAPITokenUsagePruning() {
+	for {
+		if err := app.PruneAPITokenUsage(time.Now()); err != nil {
+			log.Printf("Couldn't prune API token usage: %s\n", err)
+		}
+		time.Sleep(API_TOKEN_USAGE_PRUNE_INTERVAL)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokenLimits(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		config.APITokens.Allow = true
+		config.APITokens.RequestsPerMinute = 3
+		config.APITokens.DailyQuota = 5
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test API token rate limits and quotas", ts.testAPITokenLimits)
+	}
+}
+
+func (ts *TestSuite) testAPITokenLimits(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	token, apiToken, err := ts.App.CreateAPIToken(&user, "Monitor", []string{APITokenScopeSessions})
+	assert.Nil(t, err)
+	get := func() *http.Response {
+		rec := ts.Get(t, ts.Server, "/drasl/api/v1/profile/sessions", nil, &token)
+		return rec.Result()
+	}
+
+	// A burst of RequestsPerMinute requests, then 429
+	for i := 0; i < 3; i += 1 {
+		res := get()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "3", res.Header.Get("X-RateLimit-Limit"))
+		assert.Equal(t, "5", res.Header.Get("X-Quota-Limit"))
+		assert.Equal(t, strconv.Itoa(4-i), res.Header.Get("X-Quota-Remaining"))
+	}
+	res := get()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "0", res.Header.Get("X-RateLimit-Remaining"))
+	assert.NotEqual(t, "", res.Header.Get("Retry-After"))
+
+	// An admin lifts the rate limit, but the quota still applies
+	assert.Nil(t, ts.App.SetAPITokenLimits(nil, apiToken, Ptr(0), nil))
+	for i := 0; i < 2; i += 1 {
+		res = get()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "", res.Header.Get("X-RateLimit-Limit"))
+	}
+	res = get()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "0", res.Header.Get("X-Quota-Remaining"))
+	assert.Equal(t, res.Header.Get("X-Quota-Reset"), res.Header.Get("Retry-After"))
+
+	// Raise the quota from the API Usage page
+	rec := ts.Get(t, ts.Server, "/drasl/admin/api-usage", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "Monitor"))
+
+	form := url.Values{}
+	form.Set("apiTokenId", apiToken.ID)
+	form.Set("requestsPerMinute", "10")
+	form.Set("dailyQuota", "-1")
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/admin/api-usage")
+	rec = ts.PostForm(t, ts.Server, "/drasl/admin/set-api-token-limits", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "Limits must be whole numbers, or 0 for no limit.", getErrorMessage(rec))
+
+	form.Set("dailyQuota", "100")
+	rec = ts.PostForm(t, ts.Server, "/drasl/admin/set-api-token-limits", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+
+	// The new rate limit starts afresh
+	res = get()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "10", res.Header.Get("X-RateLimit-Limit"))
+	assert.Equal(t, "100", res.Header.Get("X-Quota-Limit"))
+	assert.Equal(t, "94", res.Header.Get("X-Quota-Remaining"))
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionSetAPITokenLimits).Error)
+	assert.Equal(t, "admin", auditLogEntry.ActorUsername)
+
+	topAPITokens, err := ts.App.GetTopAPITokens(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(topAPITokens))
+	assert.Equal(t, int64(6), topAPITokens[0].Today)
+	assert.Equal(t, TEST_USERNAME, topAPITokens[0].APIToken.User.Username)
+
+	// Old usage is pruned
+	assert.Nil(t, ts.App.PruneAPITokenUsage(time.Now().AddDate(0, 0, API_TOKEN_USAGE_DAYS)))
+	topAPITokens, err = ts.App.GetTopAPITokens(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(topAPITokens))
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"lukechampine.com/blake3"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Personal API tokens let users automate changes to their own profile, e.g.
+from a script that rotates their skin, without handing it their password or a
+launcher's access token. Each token is limited to the scopes it was created
+with and can only act on its owner's profile. Only a hash of the token is
+stored, so it's shown once, when it's created.
+*/
+
+const API_TOKEN_PREFIX = "drasl_"
+
+const (
+	APITokenScopeSkin     = "skin"
+	APITokenScopeCape     = "cape"
+	APITokenScopeSessions = "sessions"
+)
+
+var API_TOKEN_SCOPES = []string{APITokenScopeSkin, APITokenScopeCape, APITokenScopeSessions}
+
+const MAX_API_TOKEN_NAME_LENGTH = 64
+
+var errAPITokenLimit = errors.New("too many API tokens")
+var errAPITokenNoScopes = errors.New("no scopes")
+
+func hashAPIToken(token string) string {
+	sum := blake3.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mint a token for `user` with the given scopes, returning the token itself
+// along with its record
+func (app *App) CreateAPIToken(user *User, name string, scopes []string) (string, *APIToken, error) {
+	if len(scopes) == 0 {
+		return "", nil, errAPITokenNoScopes
+	}
+	for _, scope := range scopes {
+		if !Contains(API_TOKEN_SCOPES, scope) {
+			return "", nil, errors.New("invalid scope")
+		}
+	}
+
+	var count int64
+	if err := app.DB.Model(&APIToken{}).Where("user_uuid = ?", user.UUID).Count(&count).Error; err != nil {
+		return "", nil, err
+	}
+	if count >= int64(app.Config().APITokens.MaxPerUser) {
+		return "", nil, errAPITokenLimit
+	}
+
+	secret, err := RandomBase62(32)
+	if err != nil {
+		return "", nil, err
+	}
+	token := API_TOKEN_PREFIX + secret
+	apiToken := APIToken{
+		ID:        uuid.New().String(),
+		UserUUID:  user.UUID,
+		Name:      name,
+		TokenHash: hashAPIToken(token),
+		Scopes:    strings.Join(scopes, ","),
+		CreatedAt: time.Now(),
+	}
+	if err := app.DB.Create(&apiToken).Error; err != nil {
+		return "", nil, err
+	}
+	return token, &apiToken, nil
+}
+
+// Delete one of the user's tokens. Returns gorm.ErrRecordNotFound if they
+// have no token with that ID.
+func (app *App) DeleteAPIToken(user *User, id string) error {
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND user_uuid = ?", id, user.UUID).Delete(&APIToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("api_token_id = ?", id).Delete(&APITokenUsage{}).Error
+	})
+}
+
+func (app *App) GetAPITokens(user *User) ([]APIToken, error) {
+	var apiTokens []APIToken
+	err := app.DB.Order("created_at").Find(&apiTokens, "user_uuid = ?", user.UUID).Error
+	return apiTokens, err
+}
+
+// Authenticate a user using a personal API token with `scope`, and call `f`
+// with a reference to the user
+func withAPIToken(app *App, scope string, f func(c echo.Context, user *User) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().APITokens.Allow {
+			return echo.ErrNotFound
+		}
+
+		token, ok := getBearerToken(c)
+		if !ok || !strings.HasPrefix(token, API_TOKEN_PREFIX) {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
+		}
+		var apiToken APIToken
+		if err := app.DB.Preload("User").First(&apiToken, "token_hash = ?", hashAPIToken(token)).Error; err != nil {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
+		}
+		if apiToken.User.IsLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("This account is locked."))
+		}
+		if !apiToken.HasScope(scope) {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("This token doesn't have the "+scope+" scope."))
+		}
+		if ok, err := app.limitAPIToken(c, &apiToken); !ok || err != nil {
+			return err
+		}
+
+		if now := time.Now(); now.Sub(apiToken.LastUsedAt) >= CLIENT_LAST_USED_RESOLUTION {
+			if err := app.DB.Model(&APIToken{}).Where("id = ?", apiToken.ID).Update("last_used_at", now).Error; err != nil {
+				return err
+			}
+		}
+
+		user := apiToken.User
+		return f(c, &user)
+	}
+}
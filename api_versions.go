@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Drasl's own API is versioned, under /drasl/api/<version>/. While clients move
+from one version to the next, the instance serves both: the API's routes are
+registered once, under /drasl/api/:version, and every version in
+APIVersions.Serve answers them. A route that changes in a later version
+checks APIVersionOf to tell which one was asked for.
+
+Requests to a deprecated version get a Deprecation header (RFC 9745), a
+Sunset header (RFC 8594) if the operator has set APIVersions.Sunset for it,
+and a Link to the same route under the version that replaces it. They're
+also counted per day, route, and client, by User-Agent, and listed on the
+statistics page, so operators can tell who still has to upgrade before they
+stop serving the old version.
+*/
+
+const API_PATH_PREFIX = "/drasl/api/"
+
+// Format of the dates in APIVersions.Sunset
+const API_SUNSET_DATE_FORMAT = "2006-01-02"
+
+// Longest User-Agent recorded for deprecated API usage
+const MAX_DEPRECATED_API_CLIENT_LENGTH = 256
+
+const DEPRECATED_API_USAGE_PRUNE_INTERVAL = time.Hour
+
+type APIVersion struct {
+	Name string
+	// When the version was deprecated in favor of Successor, or the zero
+	// time if it isn't
+	DeprecatedAt time.Time
+	Successor    string
+}
+
+// Oldest first. v2 doesn't differ from v1 yet, so v1 isn't deprecated; set
+// its DeprecatedAt to the release in which v2 first changes a route.
+var API_VERSIONS = []APIVersion{
+	{Name: "v1", Successor: "v2"},
+	{Name: "v2"},
+}
+
+func (version *APIVersion) IsDeprecated() bool {
+	return !version.DeprecatedAt.IsZero()
+}
+
+func GetAPIVersion(name string) (*APIVersion, bool) {
+	for i := range API_VERSIONS {
+		if API_VERSIONS[i].Name == name {
+			return &API_VERSIONS[i], true
+		}
+	}
+	return nil, false
+}
+
+func APIVersionNames() []string {
+	names := make([]string, 0, len(API_VERSIONS))
+	for _, version := range API_VERSIONS {
+		names = append(names, version.Name)
+	}
+	return names
+}
+
+func (app *App) IsAPIVersionServed(name string) bool {
+	return Contains(app.Config().APIVersions.Serve, name)
+}
+
+// The newest version served, which the API documentation page shows
+func (app *App) CurrentAPIVersion() string {
+	return currentAPIVersion(app.Config())
+}
+
+func currentAPIVersion(config *Config) string {
+	for i := len(API_VERSIONS) - 1; i >= 0; i-- {
+		if Contains(config.APIVersions.Serve, API_VERSIONS[i].Name) {
+			return API_VERSIONS[i].Name
+		}
+	}
+	return API_VERSIONS[len(API_VERSIONS)-1].Name
+}
+
+// The version of the API a request was made to
+func APIVersionOf(c echo.Context) string {
+	return c.Param("version")
+}
+
+// Reject versions that aren't served and mark deprecated ones as such
+func makeAPIVersionMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			version, ok := GetAPIVersion(APIVersionOf(c))
+			if !ok || !app.IsAPIVersionServed(version.Name) {
+				return echo.ErrNotFound
+			}
+			if !version.IsDeprecated() {
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("Deprecation", fmt.Sprintf("@%d", version.DeprecatedAt.Unix()))
+			if sunset, ok := app.Config().APIVersions.Sunset[version.Name]; ok {
+				// Validated by CleanConfig
+				sunsetTime := Unwrap(time.Parse(API_SUNSET_DATE_FORMAT, sunset))
+				header.Set("Sunset", sunsetTime.Format(http.TimeFormat))
+			}
+			if app.IsAPIVersionServed(version.Successor) {
+				path := c.Request().URL.Path
+				successorPath := API_PATH_PREFIX + version.Successor + strings.TrimPrefix(path, API_PATH_PREFIX+version.Name)
+				header.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", app.FrontEndURL+successorPath))
+			}
+
+			// Only routes that exist are worth reporting
+			if c.Path() != API_PATH_PREFIX+":version" && c.Path() != API_PATH_PREFIX+":version/*" {
+				route := c.Request().Method + " " + strings.Replace(c.Path(), ":version", version.Name, 1)
+				app.RecordDeprecatedAPIUse(route, c.Request().UserAgent())
+			}
+			return next(c)
+		}
+	}
+}
+
+// Count a request to a deprecated route. Like IncrementStat, errors are only
+// logged.
+func (app *App) RecordDeprecatedAPIUse(route string, userAgent string) {
+	client := userAgent
+	if client == "" {
+		client = "(no User-Agent)"
+	}
+	if len(client) > MAX_DEPRECATED_API_CLIENT_LENGTH {
+		client = client[:MAX_DEPRECATED_API_CLIENT_LENGTH]
+	}
+	err := app.DB.Exec(
+		"INSERT INTO deprecated_api_usages (day, route, client, requests) VALUES (?, ?, ?, 1) ON CONFLICT (day, route, client) DO UPDATE SET requests = requests + 1",
+		statDay(time.Now()), route, client,
+	).Error
+	if err != nil {
+		log.Printf("Couldn't record use of deprecated route %s: %s\n", route, err)
+	}
+}
+
+// Requests by one client to one deprecated route, for the statistics page
+type DeprecatedAPIClient struct {
+	Route    string
+	Client   string
+	Requests int64
+	LastDay  string
+}
+
+// Clients that used deprecated routes since `since`, a day like statDay's,
+// busiest first
+func (app *App) GetDeprecatedAPIClients(since string) ([]DeprecatedAPIClient, error) {
+	var clients []DeprecatedAPIClient
+	err := app.DB.Model(&DeprecatedAPIUsage{}).
+		Select("route, client, SUM(requests) AS requests, MAX(day) AS last_day").
+		Where("day >= ?", since).
+		Group("route, client").
+		Order("requests DESC, route, client").
+		Limit(100).
+		Scan(&clients).Error
+	return clients, err
+}
+
+// Delete usage older than the statistics page shows
+func (app *App) PruneDeprecatedAPIUsage(now time.Time) error {
+	cutoff := statDay(now.AddDate(0, 0, -STATS_DAYS))
+	return app.DB.Where("day <= ?", cutoff).Delete(&DeprecatedAPIUsage{}).Error
+}
+
+func (app *App) RunDeprecatedAPIUsagePruning() {
+	for {
+		if err := app.PruneDeprecatedAPIUsage(time.Now()); err != nil {
+			log.Printf("Couldn't prune deprecated API usage: %s\n", err)
+		}
+		time.Sleep(DEPRECATED_API_USAGE_PRUNE_INTERVAL)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIVersions(t *testing.T) {
+	// No version is deprecated yet, so pretend v1 is
+	v1, ok := GetAPIVersion("v1")
+	assert.True(t, ok)
+	v1.DeprecatedAt = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+	defer func() { v1.DeprecatedAt = time.Time{} }()
+
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		config.APIVersions.Sunset = map[string]string{"v1": "2027-06-01"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test API versions", ts.testAPIVersions)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.APIVersions.Serve = []string{"v2"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test API versions no longer served", ts.testAPIVersionsNotServed)
+	}
+}
+
+func (ts *TestSuite) getWithUserAgent(path string, userAgent string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	ts.Server.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *TestSuite) testAPIVersions(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+
+	// The current version isn't deprecated
+	rec := ts.getWithUserAgent("/drasl/api/v2/info", "status-page/2.0")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("Deprecation"))
+	assert.Equal(t, "", rec.Header().Get("Sunset"))
+
+	// The old one answers the same, and says what replaces it
+	v1, ok := GetAPIVersion("v1")
+	assert.True(t, ok)
+	for i := 0; i < 2; i += 1 {
+		rec = ts.getWithUserAgent("/drasl/api/v1/info", "status-page/1.0")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "@"+strconv.FormatInt(v1.DeprecatedAt.Unix(), 10), rec.Header().Get("Deprecation"))
+		assert.Equal(t, "Tue, 01 Jun 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+		assert.Equal(t, "<"+ts.App.FrontEndURL+"/drasl/api/v2/info>; rel=\"successor-version\"", rec.Header().Get("Link"))
+	}
+
+	rec = ts.getWithUserAgent("/drasl/api/v3/info", "status-page/3.0")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = ts.getWithUserAgent("/drasl/api/v1/nonexistent", "status-page/1.0")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Only existing routes of deprecated versions are counted
+	clients, err := ts.App.GetDeprecatedAPIClients(statDay(time.Now()))
+	assert.Nil(t, err)
+	assert.Equal(t, []DeprecatedAPIClient{{
+		Route:    "GET /drasl/api/v1/info",
+		Client:   "status-page/1.0",
+		Requests: 2,
+		LastDay:  statDay(time.Now()),
+	}}, clients)
+
+	rec = ts.Get(t, ts.Server, "/drasl/admin/stats", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "status-page/1.0"))
+
+	assert.Nil(t, ts.App.PruneDeprecatedAPIUsage(time.Now().AddDate(0, 0, STATS_DAYS)))
+	clients, err = ts.App.GetDeprecatedAPIClients(statDay(time.Now()))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(clients))
+}
+
+func (ts *TestSuite) testAPIVersionsNotServed(t *testing.T) {
+	rec := ts.getWithUserAgent("/drasl/api/v1/info", "status-page/1.0")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = ts.getWithUserAgent("/drasl/api/v2/info", "status-page/2.0")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v2", ts.App.CurrentAPIVersion())
+}
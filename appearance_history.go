@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"os"
+	"time"
+)
+
+/*
+If AppearanceHistory.Enable is set, every change to a user's skin, skin model,
+or cape is recorded as a snapshot of their appearance, and the profile page
+lets them roll back to any snapshot. Only the newest
+AppearanceHistory.MaxSnapshots snapshots of each user are kept. Like library
+skins, snapshots keep their textures around until they're pruned.
+*/
+
+var errAppearanceSnapshotNotFound = errors.New("appearance snapshot not found")
+
+// Whether the snapshot matches the user's current appearance
+func (snapshot *AppearanceSnapshot) Matches(user *User) bool {
+	return snapshot.SkinHash == user.SkinHash &&
+		snapshot.SkinModel == user.SkinModel &&
+		snapshot.CapeHash == user.CapeHash
+}
+
+// Record the user's current appearance, unless it's the same as their newest
+// snapshot, and prune old snapshots. Call after anything that changes a
+// user's skin, skin model, or cape.
+func (app *App) RecordAppearance(userUUID string) error {
+	if !app.Config().AppearanceHistory.Enable {
+		return nil
+	}
+	if err := app.recordAppearance(userUUID); err != nil {
+		return err
+	}
+	return app.pruneAppearanceSnapshots(userUUID)
+}
+
+// Like RecordAppearance, without pruning
+func (app *App) recordAppearance(userUUID string) error {
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", userUUID).Error; err != nil {
+		return err
+	}
+	var newest AppearanceSnapshot
+	err := app.DB.Order("id DESC").First(&newest, "user_uuid = ?", userUUID).Error
+	if err == nil && newest.Matches(&user) {
+		return nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	snapshot := AppearanceSnapshot{
+		UserUUID:  userUUID,
+		SkinHash:  user.SkinHash,
+		SkinModel: user.SkinModel,
+		CapeHash:  user.CapeHash,
+		CreatedAt: time.Now(),
+	}
+	return app.DB.Create(&snapshot).Error
+}
+
+// Delete all but the newest AppearanceHistory.MaxSnapshots snapshots of the
+// user, along with their textures if nothing else uses them
+func (app *App) pruneAppearanceSnapshots(userUUID string) error {
+	var old []AppearanceSnapshot
+	err := app.DB.Where("user_uuid = ?", userUUID).
+		Order("id DESC").
+		Offset(app.Config().AppearanceHistory.MaxSnapshots).
+		Limit(-1).
+		Find(&old).Error
+	if err != nil {
+		return err
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	if err := app.DB.Delete(&old).Error; err != nil {
+		return err
+	}
+	return deleteSnapshotTexturesIfUnused(app, old)
+}
+
+func deleteSnapshotTexturesIfUnused(app *App, snapshots []AppearanceSnapshot) error {
+	for _, snapshot := range snapshots {
+		if err := DeleteSkinIfUnused(app, UnmakeNullString(&snapshot.SkinHash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := DeleteCapeIfUnused(app, UnmakeNullString(&snapshot.CapeHash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) GetAppearanceSnapshots(user *User) ([]AppearanceSnapshot, error) {
+	var snapshots []AppearanceSnapshot
+	err := app.DB.Order("id DESC").Find(&snapshots, "user_uuid = ?", user.UUID).Error
+	return snapshots, err
+}
+
+// Restore the appearance in snapshot `id`, which must be one of the user's
+// own unless they're an admin. Returns the user whose appearance changed.
+func (app *App) RollBackAppearance(user *User, id uint) (*User, error) {
+	var snapshot AppearanceSnapshot
+	if err := app.DB.First(&snapshot, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errAppearanceSnapshotNotFound
+		}
+		return nil, err
+	}
+	if snapshot.UserUUID != user.UUID && !user.IsAdmin {
+		return nil, errAppearanceSnapshotNotFound
+	}
+	if !user.IsAdmin {
+		if user.SkinLocked && (snapshot.SkinHash != user.SkinHash || snapshot.SkinModel != user.SkinModel) {
+			return nil, errSkinLocked
+		}
+		if user.CapeLocked && snapshot.CapeHash != user.CapeHash {
+			return nil, errCapeLocked
+		}
+	}
+
+	// Make sure the current appearance can be restored too. Pruning waits
+	// until the snapshot's textures are in use again.
+	if err := app.recordAppearance(snapshot.UserUUID); err != nil {
+		return nil, err
+	}
+
+	var snapshotUser User
+	if err := app.DB.First(&snapshotUser, "uuid = ?", snapshot.UserUUID).Error; err != nil {
+		return nil, err
+	}
+	oldSkinHash := UnmakeNullString(&snapshotUser.SkinHash)
+	oldCapeHash := UnmakeNullString(&snapshotUser.CapeHash)
+
+	err := app.DB.Model(&snapshotUser).Updates(map[string]interface{}{
+		"skin_hash":  snapshot.SkinHash,
+		"skin_model": snapshot.SkinModel,
+		"cape_hash":  snapshot.CapeHash,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := app.RecordAppearance(snapshotUser.UUID); err != nil {
+		return nil, err
+	}
+
+	// The old textures are only deleted if the snapshot recording them was
+	// just pruned
+	if err := DeleteSkinIfUnused(app, oldSkinHash); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := DeleteCapeIfUnused(app, oldCapeHash); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &snapshotUser, nil
+}
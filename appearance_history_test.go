@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestAppearanceHistory(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.AppearanceHistory.Enable = true
+		config.AppearanceHistory.MaxSnapshots = 3
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test appearance history", ts.testAppearanceHistory)
+	}
+}
+
+func (ts *TestSuite) testAppearanceHistory(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	user.SkinModel = SkinModelSlim
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	redHash := user.SkinHash.String
+	assert.Nil(t, SetCapeAndSave(ts.App, &user, bytes.NewReader(RED_CAPE)))
+	capeHash := user.CapeHash.String
+	user.SkinModel = SkinModelClassic
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(BLUE_SKIN)))
+	blueHash := user.SkinHash.String
+
+	// Saving without changing the appearance doesn't add a snapshot
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(BLUE_SKIN)))
+
+	snapshots, err := ts.App.GetAppearanceSnapshots(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(snapshots))
+	oldest := snapshots[2]
+	assert.Equal(t, redHash, oldest.SkinHash.String)
+	assert.Equal(t, SkinModelSlim, oldest.SkinModel)
+	assert.False(t, oldest.CapeHash.Valid)
+
+	// Textures of old snapshots are kept
+	_, err = os.Stat(GetSkinPath(ts.App, redHash))
+	assert.Nil(t, err)
+
+	rec := ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Appearance History")
+
+	form := url.Values{}
+	form.Set("id", strconv.FormatUint(uint64(oldest.ID), 10))
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+
+	// Other users can't roll back the user's appearance
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, TEST_OTHER_USERNAME)
+	rec = ts.PostForm(t, ts.Server, "/drasl/rollback-appearance", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+	assert.Equal(t, "Snapshot not found.", getErrorMessage(rec))
+
+	rec = ts.PostForm(t, ts.Server, "/drasl/rollback-appearance", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, redHash, user.SkinHash.String)
+	assert.Equal(t, SkinModelSlim, user.SkinModel)
+	assert.False(t, user.CapeHash.Valid)
+
+	// The rollback is itself a snapshot, and the oldest one is pruned
+	snapshots, err = ts.App.GetAppearanceSnapshots(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(snapshots))
+	assert.Equal(t, redHash, snapshots[0].SkinHash.String)
+	assert.NotEqual(t, oldest.ID, snapshots[2].ID)
+	_, err = os.Stat(GetSkinPath(ts.App, blueHash))
+	assert.Nil(t, err)
+	_, err = os.Stat(GetCapePath(ts.App, capeHash))
+	assert.Nil(t, err)
+
+	assert.Nil(t, DeleteUser(ts.App, &user))
+	for _, path := range []string{GetSkinPath(ts.App, redHash), GetSkinPath(ts.App, blueHash), GetCapePath(ts.App, capeHash)} {
+		_, err := os.Stat(path)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 	"net/http"
+	"time"
 )
 
 /*
@@ -27,6 +27,10 @@ var invalidCredentialsBlob []byte = Unwrap(json.Marshal(ErrorResponse{
 	Error:        Ptr("ForbiddenOperationException"),
 	ErrorMessage: Ptr("Invalid credentials. Invalid username or password."),
 }))
+var pendingApprovalBlob []byte = Unwrap(json.Marshal(ErrorResponse{
+	Error:        Ptr("ForbiddenOperationException"),
+	ErrorMessage: Ptr("Your account is waiting for approval by an admin."),
+}))
 var invalidClientTokenBlob []byte = Unwrap(json.Marshal(ErrorResponse{
 	Error: Ptr("ForbiddenOperationException"),
 }))
@@ -54,7 +58,7 @@ func AuthServerInfo(app *App) func(c echo.Context) error {
 		ApplicationDescription: "",
 		SpecificationVersion:   "2.13.34",
 		ImplementationVersion:  "0.1.0",
-		ApplicationOwner:       app.Config.ApplicationOwner,
+		ApplicationOwner:       app.Config().ApplicationOwner,
 	}
 	infoBlob := Unwrap(json.Marshal(info))
 	return func(c echo.Context) error {
@@ -81,6 +85,12 @@ type authenticateResponse struct {
 // https://wiki.vg/Legacy_Mojang_Authentication#Authenticate
 func AuthAuthenticate(app *App) func(c echo.Context) error {
 	return func(c echo.Context) (err error) {
+		throttle := app.AuthenticateThrottle
+		if throttle != nil && !IsRateLimitExempt(app, c.RealIP()) && !throttle.AllowIP(RateLimitKey(app, c.RealIP())) {
+			app.IncrementStat(StatAuthenticateThrottled)
+			return MakeErrorResponse(&c, http.StatusTooManyRequests, Ptr("TooManyRequestsException"), Ptr("Too many requests. Try again later."))
+		}
+
 		req := new(authenticateRequest)
 		if err = c.Bind(req); err != nil {
 			return err
@@ -89,10 +99,12 @@ func AuthAuthenticate(app *App) func(c echo.Context) error {
 		username := req.Username
 		doTransientLogin := TransientLoginEligible(app, username)
 
+		// Like Mojang's API, accept either the account's username or its
+		// player name
 		var user User
-		result := app.DB.Preload("Clients").First(&user, "username = ?", username)
-		if result.Error != nil {
-			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		err = FindUserByUsernameOrPlayerName(app.DB.Preload("Clients"), &user, username)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
 				if doTransientLogin {
 					user, err = MakeTransientUser(app, username)
 					if err != nil {
@@ -107,109 +119,151 @@ func AuthAuthenticate(app *App) func(c echo.Context) error {
 					return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
 				}
 			} else {
-				return result.Error
+				return err
 			}
 		}
 
 		if doTransientLogin {
-			if req.Password != app.Config.TransientUsers.Password {
+			if req.Password != app.Config().TransientUsers.Password {
 				return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
 			}
 		} else {
-			passwordHash, err := HashPassword(req.Password, user.PasswordSalt)
-			if err != nil {
-				return err
-			}
-
-			if !bytes.Equal(passwordHash, user.PasswordHash) {
-				return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
+			if throttle != nil {
+				if delay := throttle.Delay(user.UUID); delay > 0 {
+					app.IncrementStat(StatAuthenticateTarpitted)
+					time.Sleep(delay)
+				}
 			}
-		}
 
-		var client Client
-		if req.ClientToken == nil {
-			clientToken, err := RandomHex(16)
+			passwordOK, err := app.CheckPassword(&user, req.Password)
 			if err != nil {
 				return err
 			}
-			client = Client{
-				UUID:        uuid.New().String(),
-				ClientToken: clientToken,
-				Version:     0,
-			}
-			user.Clients = append(user.Clients, client)
-		} else {
-			clientToken := *req.ClientToken
-			clientExists := false
-			for i := range user.Clients {
-				if user.Clients[i].ClientToken == clientToken {
-					clientExists = true
-					user.Clients[i].Version += 1
-					client = user.Clients[i]
-					break
-				} else {
-					if !app.Config.AllowMultipleAccessTokens {
-						user.Clients[i].Version += 1
-					}
-				}
-			}
 
-			if !clientExists {
-				client = Client{
-					UUID:        uuid.New().String(),
-					ClientToken: clientToken,
-					Version:     0,
+			if !passwordOK {
+				app.IncrementStat(StatAuthenticateFailures)
+				if throttle != nil {
+					throttle.RecordFailure(user.UUID, req.Password)
 				}
-				user.Clients = append(user.Clients, client)
+				return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
+			}
+			if throttle != nil {
+				throttle.RecordSuccess(user.UUID)
 			}
 		}
 
-		// Save changes to user.Clients
-		result = app.DB.Session(&gorm.Session{FullSaveAssociations: true}).Save(&user)
-		if result.Error != nil {
-			return result.Error
+		if user.IsPendingApproval {
+			return c.JSONBlob(http.StatusForbidden, pendingApprovalBlob)
+		}
+		if user.IsLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(LockedMessage(&user, time.Now())))
+		}
+		if allowed, reason := app.ExternalAuthAllows(&user, c.RealIP(), c.Request().UserAgent()); !allowed {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(reason))
 		}
 
-		id, err := UUIDToID(user.UUID)
+		newDevice := !user.HasClient(req.ClientToken)
+		res, err := app.AuthenticateClient(&user, req.ClientToken, req.Agent, req.RequestUser)
 		if err != nil {
 			return err
 		}
+		if newDevice {
+			app.NotifySecurityEvent(&user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		}
+		app.PublishEvent(MakeEvent(EventLogin, &user, c.RealIP()))
+		return c.JSON(http.StatusOK, res)
+	}
+}
 
-		var selectedProfile *Profile
-		var availableProfiles *[]Profile
-		if req.Agent != nil {
-			selectedProfile = &Profile{
-				ID:   id,
-				Name: user.PlayerName,
+// Issue an access token for user, reusing the client identified by
+// clientToken if there is one. user.Clients must be preloaded. Shared by
+// /authenticate and the device login flow.
+func (app *App) AuthenticateClient(user *User, clientToken *string, agent *Agent, requestUser bool) (*authenticateResponse, error) {
+	var client Client
+	if clientToken == nil {
+		token, err := RandomHex(16)
+		if err != nil {
+			return nil, err
+		}
+		client = Client{
+			UUID:        uuid.New().String(),
+			ClientToken: token,
+			Version:     0,
+			LastUsedAt:  time.Now(),
+		}
+		user.Clients = append(user.Clients, client)
+	} else {
+		token := *clientToken
+		clientExists := false
+		for i := range user.Clients {
+			if user.Clients[i].ClientToken == token {
+				clientExists = true
+				user.Clients[i].Version += 1
+				user.Clients[i].LastUsedAt = time.Now()
+				client = user.Clients[i]
+				break
+			} else {
+				if !app.Config().AllowMultipleAccessTokens {
+					user.Clients[i].Version += 1
+				}
 			}
-			availableProfiles = &[]Profile{*selectedProfile}
 		}
 
-		var userResponse *UserResponse
-		if req.RequestUser {
-			userResponse = &UserResponse{
-				ID: id,
-				Properties: []UserProperty{{
-					Name:  "preferredLanguage",
-					Value: user.PreferredLanguage,
-				}},
+		if !clientExists {
+			client = Client{
+				UUID:        uuid.New().String(),
+				ClientToken: token,
+				Version:     0,
+				LastUsedAt:  time.Now(),
 			}
+			user.Clients = append(user.Clients, client)
 		}
+	}
 
-		accessToken, err := app.MakeAccessToken(client)
-		if err != nil {
-			return err
+	// Save changes to user.Clients
+	result := app.DB.Session(&gorm.Session{FullSaveAssociations: true}).Save(user)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	id, err := UUIDToID(user.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var selectedProfile *Profile
+	var availableProfiles *[]Profile
+	if agent != nil {
+		selectedProfile = &Profile{
+			ID:   id,
+			Name: user.PlayerName,
 		}
+		availableProfiles = &[]Profile{*selectedProfile}
+	}
 
-		res := authenticateResponse{
-			ClientToken:       client.ClientToken,
-			AccessToken:       accessToken,
-			SelectedProfile:   selectedProfile,
-			AvailableProfiles: availableProfiles,
-			User:              userResponse,
+	var userResponse *UserResponse
+	if requestUser {
+		userResponse = &UserResponse{
+			ID: id,
+			Properties: []UserProperty{{
+				Name:  "preferredLanguage",
+				Value: user.PreferredLanguage,
+			}},
 		}
-		return c.JSON(http.StatusOK, &res)
 	}
+
+	accessToken, err := app.MakeAccessToken(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authenticateResponse{
+		ClientToken:       client.ClientToken,
+		AccessToken:       accessToken,
+		SelectedProfile:   selectedProfile,
+		AvailableProfiles: availableProfiles,
+		User:              userResponse,
+	}, nil
 }
 
 type refreshRequest struct {
@@ -323,17 +377,20 @@ func AuthSignout(app *App) func(c echo.Context) error {
 		}
 
 		var user User
-		result := app.DB.First(&user, "username = ?", req.Username)
-		if result.Error != nil {
-			return result.Error
+		err := FindUserByUsernameOrPlayerName(app.DB, &user, req.Username)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
+			}
+			return err
 		}
 
-		passwordHash, err := HashPassword(req.Password, user.PasswordSalt)
+		passwordOK, err := app.CheckPassword(&user, req.Password)
 		if err != nil {
 			return err
 		}
 
-		if !bytes.Equal(passwordHash, user.PasswordHash) {
+		if !passwordOK {
 			return c.JSONBlob(http.StatusUnauthorized, invalidCredentialsBlob)
 		}
 
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestAuth(t *testing.T) {
@@ -27,6 +29,179 @@ func TestAuth(t *testing.T) {
 
 		t.Run("Test authenticate with duplicate client token", ts.testDuplicateClientToken)
 	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.AuthenticateThrottle = authenticateThrottleConfig{
+			Enable:            true,
+			RequestsPerMinute: 3,
+			Tarpit: authenticateTarpitConfig{
+				Enable:       true,
+				FreeFailures: 2,
+				MaxDelaySec:  4,
+			},
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test /authenticate throttling", ts.testAuthenticateThrottle)
+	}
+	{
+		// The hook allows TEST_USERNAME and denies everyone else
+		hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req externalAuthRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			res := externalAuthResponse{Allow: req.Username == TEST_USERNAME}
+			if !res.Allow {
+				res.Reason = "Your subscription has lapsed."
+			}
+			json.NewEncoder(w).Encode(res)
+		}))
+		defer hook.Close()
+
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.ExternalAuth.Enable = true
+		config.ExternalAuth.URL = hook.URL
+		config.ExternalAuth.TimeoutSec = DefaultConfig().ExternalAuth.TimeoutSec
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+		ts.CreateTestUser(ts.Server, TEST_OTHER_USERNAME)
+
+		t.Run("Test /authenticate with external auth", ts.testAuthenticateExternalAuth)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.ExternalAuth.Enable = true
+		config.ExternalAuth.Command = []string{"sh", "-c", "echo '{\"allow\": false, \"reason\": \"Not today.\"}'"}
+		config.ExternalAuth.TimeoutSec = DefaultConfig().ExternalAuth.TimeoutSec
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test /authenticate with external auth command", ts.testAuthenticateExternalAuthCommand)
+	}
+}
+
+func (ts *TestSuite) authenticateShouldBeDenied(t *testing.T, username string, reason string) {
+	payload := authenticateRequest{
+		Username: username,
+		Password: TEST_PASSWORD,
+	}
+	rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var response ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "ForbiddenOperationException", *response.Error)
+	assert.Equal(t, reason, *response.ErrorMessage)
+}
+
+func (ts *TestSuite) testAuthenticateExternalAuth(t *testing.T) {
+	ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD)
+	ts.authenticateShouldBeDenied(t, TEST_OTHER_USERNAME, "Your subscription has lapsed.")
+
+	// Wrong passwords are refused before the hook is consulted
+	payload := authenticateRequest{
+		Username: TEST_OTHER_USERNAME,
+		Password: "wrong",
+	}
+	rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// If the hook can't be reached, sign-ins are refused unless AllowOnError
+	// is set
+	ts.App.Config().ExternalAuth.URL = "http://127.0.0.1:1"
+	ts.authenticateShouldBeDenied(t, TEST_USERNAME, "Couldn't verify your account. Try again later.")
+	ts.App.Config().ExternalAuth.AllowOnError = true
+	ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD)
+}
+
+// Make ExternalAuth deny every sign-in with `reason`, until the returned
+// function is called
+func (ts *TestSuite) denyExternalAuth(reason string) func() {
+	oldExternalAuth := ts.App.Config().ExternalAuth
+	ts.App.Config().ExternalAuth = externalAuthConfig{
+		Enable:     true,
+		Command:    []string{"sh", "-c", "echo '{\"allow\": false, \"reason\": \"" + reason + "\"}'"},
+		TimeoutSec: DefaultConfig().ExternalAuth.TimeoutSec,
+	}
+	return func() {
+		ts.App.Config().ExternalAuth = oldExternalAuth
+	}
+}
+
+// Expect a sign-in response like /authenticate's to be refused with
+// `message`
+func signInShouldBeForbidden(t *testing.T, rec *httptest.ResponseRecorder, message string) {
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var response ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "ForbiddenOperationException", *response.Error)
+	assert.Equal(t, message, *response.ErrorMessage)
+}
+
+func (ts *TestSuite) testAuthenticateExternalAuthCommand(t *testing.T) {
+	ts.authenticateShouldBeDenied(t, TEST_USERNAME, "Not today.")
+}
+
+func (ts *TestSuite) testAuthenticateThrottle(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	throttle := ts.App.AuthenticateThrottle
+
+	authenticate := func(password string) *httptest.ResponseRecorder {
+		payload := authenticateRequest{
+			Username: TEST_USERNAME,
+			Password: password,
+		}
+		return ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, authenticate("wrong1").Code)
+	assert.Equal(t, time.Duration(0), throttle.Delay(user.UUID))
+
+	// Repeating the same wrong password, as a launcher stuck in a loop
+	// would, only counts once
+	assert.Equal(t, http.StatusUnauthorized, authenticate("wrong1").Code)
+	assert.Equal(t, time.Duration(0), throttle.Delay(user.UUID))
+
+	assert.Equal(t, http.StatusUnauthorized, authenticate("wrong2").Code)
+	assert.Equal(t, time.Second, throttle.Delay(user.UUID))
+
+	// The delay doubles up to MaxDelaySec
+	throttle.RecordFailure(user.UUID, "wrong3")
+	assert.Equal(t, 2*time.Second, throttle.Delay(user.UUID))
+	throttle.RecordFailure(user.UUID, "wrong4")
+	throttle.RecordFailure(user.UUID, "wrong5")
+	assert.Equal(t, 4*time.Second, throttle.Delay(user.UUID))
+
+	// A successful login clears the failures
+	throttle.RecordSuccess(user.UUID)
+	assert.Equal(t, time.Duration(0), throttle.Delay(user.UUID))
+
+	// Only RequestsPerMinute requests are allowed from one address
+	rec := authenticate(TEST_PASSWORD)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	var response ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "TooManyRequestsException", *response.Error)
+
+	stats, err := ts.App.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), stats.FailedLogins[len(stats.FailedLogins)-1].Count)
+	assert.Equal(t, int64(1), stats.ThrottledLogins[len(stats.ThrottledLogins)-1].Count)
 }
 
 func (ts *TestSuite) testGetServerInfo(t *testing.T) {
@@ -73,6 +248,40 @@ func (ts *TestSuite) testAuthenticate(t *testing.T) {
 		// We did not pass requestUser
 		assert.Nil(t, response.User)
 	}
+	{
+		// Authentication by player name should succeed
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		user.PlayerName = "authPlayerName"
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+		ts.authenticate(t, "authPlayerName", TEST_PASSWORD)
+
+		user.PlayerName = TEST_USERNAME
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+	}
+	{
+		// Authentication by email should succeed once the email is verified
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		user.Email = MakeNullString(Ptr("auth@example.com"))
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+		payload := authenticateRequest{
+			Username: "auth@example.com",
+			Password: TEST_PASSWORD,
+		}
+		rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		user.EmailVerified = true
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+		ts.authenticate(t, "auth@example.com", TEST_PASSWORD)
+
+		user.Email = MakeNullString(nil)
+		user.EmailVerified = false
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+	}
 	{
 		// If we send our own clientToken, the server should use it
 		clientToken := "12345678901234567890123456789012"
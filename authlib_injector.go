@@ -42,10 +42,23 @@ func authlibInjectorSerializeKey(key *rsa.PublicKey) (string, error) {
 	return string(pubPEM[:]), nil
 }
 
-func AuthlibInjectorRoot(app *App) func(c echo.Context) error {
-	skinDomains := make([]string, 0, 1+len(app.Config.FallbackAPIServers))
-	skinDomains = append(skinDomains, app.Config.Domain)
-	for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+// The FallbackAPIServers and their keys can change at runtime, so the response
+// is built for each request
+func authlibInjectorRootResponse(app *App) ([]byte, error) {
+	fallbackAPIServers := app.Config().EnabledFallbackAPIServers()
+	skinDomains := make([]string, 0, 2+len(fallbackAPIServers))
+	skinDomains = append(skinDomains, app.Config().Domain)
+	// Textures are served from TextureBaseURL, or BaseURL, whose host may
+	// differ from Domain
+	textureURL, err := url.Parse(app.TextureURL)
+	if err != nil {
+		return nil, err
+	}
+	textureDomain := textureURL.Hostname()
+	if !Contains(skinDomains, textureDomain) {
+		skinDomains = append(skinDomains, textureDomain)
+	}
+	for _, fallbackAPIServer := range fallbackAPIServers {
 		for _, skinDomain := range fallbackAPIServer.SkinDomains {
 			if !Contains(skinDomains, skinDomain) {
 				skinDomains = append(skinDomains, skinDomain)
@@ -54,32 +67,46 @@ func AuthlibInjectorRoot(app *App) func(c echo.Context) error {
 	}
 
 	signaturePublicKey, err := authlibInjectorSerializeKey(&app.Key.PublicKey)
-	Check(err)
+	if err != nil {
+		return nil, err
+	}
 
-	signaturePublicKeys := make([]string, 0, len(app.ProfilePropertyKeys))
-	for _, key := range app.ProfilePropertyKeys {
+	signaturePublicKeys := make([]string, 0, len(app.ProfilePropertyKeys()))
+	for _, key := range app.ProfilePropertyKeys() {
 		serialized, err := authlibInjectorSerializeKey(&key)
-		Check(err)
+		if err != nil {
+			return nil, err
+		}
 		signaturePublicKeys = append(signaturePublicKeys, serialized)
 	}
 
-	responseBlob := Unwrap(json.Marshal(authlibInjectorResponse{
+	registerURL, err := url.JoinPath(app.FrontEndURL, "drasl/registration")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(authlibInjectorResponse{
 		Meta: authlibInjectorMeta{
 			ImplementationName:    "Drasl",
 			ImplementationVersion: Constants.Version,
 			Links: authlibInjectorLinks{
 				Homepage: app.FrontEndURL,
-				Register: Unwrap(url.JoinPath(app.FrontEndURL, "drasl/registration")),
+				Register: registerURL,
 			},
-			ServerName:              app.Config.InstanceName,
+			ServerName:              app.Config().InstanceName,
 			FeatureEnableProfileKey: true,
 		},
 		SignaturePublickey:  signaturePublicKey,
 		SignaturePublickeys: signaturePublicKeys,
 		SkinDomains:         skinDomains,
-	}))
+	})
+}
 
+func AuthlibInjectorRoot(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
+		responseBlob, err := authlibInjectorRootResponse(app)
+		if err != nil {
+			return err
+		}
 		return c.JSONBlob(http.StatusOK, responseBlob)
 	}
 }
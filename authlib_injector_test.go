@@ -43,6 +43,16 @@ func TestAuthlibInjector(t *testing.T) {
 
 		t.Run("Test /authlib-injector, fallback API server", ts.testAuthlibInjectorRootFallback)
 	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.TextureBaseURL = "https://textures.example.com:8443/"
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test /authlib-injector, TextureBaseURL", ts.testAuthlibInjectorRootTextureBaseURL)
+	}
 }
 
 func (ts *TestSuite) testAuthlibInjectorRoot(t *testing.T) {
@@ -56,7 +66,7 @@ func (ts *TestSuite) testAuthlibInjectorRoot(t *testing.T) {
 	// Just check the important stuff here
 	assert.Equal(t, ts.App.FrontEndURL, response.Meta.Links.Homepage)
 	assert.Equal(t, Unwrap(url.JoinPath(ts.App.FrontEndURL, "drasl/registration")), response.Meta.Links.Register)
-	assert.Equal(t, []string{ts.App.Config.Domain}, response.SkinDomains)
+	assert.Equal(t, []string{ts.App.Config().Domain}, response.SkinDomains)
 }
 
 func (ts *TestSuite) testAuthlibInjectorRootFallback(t *testing.T) {
@@ -67,5 +77,21 @@ func (ts *TestSuite) testAuthlibInjectorRootFallback(t *testing.T) {
 	var response authlibInjectorResponse
 	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
 
-	assert.Equal(t, []string{ts.App.Config.Domain, FALLBACK_SKIN_DOMAIN_A, FALLBACK_SKIN_DOMAIN_B}, response.SkinDomains)
+	assert.Equal(t, []string{ts.App.Config().Domain, FALLBACK_SKIN_DOMAIN_A, FALLBACK_SKIN_DOMAIN_B}, response.SkinDomains)
+}
+
+func (ts *TestSuite) testAuthlibInjectorRootTextureBaseURL(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/authlib-injector", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response authlibInjectorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, []string{ts.App.Config().Domain, "textures.example.com"}, response.SkinDomains)
+
+	// Clients should get textures from TextureBaseURL, the web front end
+	// from BaseURL
+	hash := "deadbeef"
+	assert.Equal(t, "https://textures.example.com:8443/drasl/texture/skin/deadbeef.png", Unwrap(SkinURL(ts.App, hash)))
+	assert.Equal(t, "https://textures.example.com:8443/drasl/texture/cape/deadbeef.png", Unwrap(CapeURL(ts.App, hash)))
+	assert.Equal(t, "https://drasl.example.com/drasl/texture/skin/deadbeef.png", Unwrap(FrontEndSkinURL(ts.App, hash)))
 }
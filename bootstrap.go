@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+Bootstrap seeds the database at startup with the users, invites, and capes
+declared in the Bootstrap section of the config, or in Bootstrap.SeedFile, so
+that deployments managed by NixOS, Terraform, Ansible, and the like come up
+ready to use. Seeding is idempotent: anything that already exists is left
+alone, so it runs on every startup. Users and capes that are missing are
+created again, but an invite is only ever created once, so it can't be
+reused after someone registers with it.
+*/
+
+type BootstrapUser struct {
+	Username string
+	Password string
+	// Path to a file holding the password, e.g. a secret managed by NixOS
+	PasswordFile string
+	IsAdmin      bool
+	// Names of capes to grant the user
+	Capes []string
+}
+
+type BootstrapCape struct {
+	Name string
+	File string
+}
+
+// What a Bootstrap.SeedFile declares
+type bootstrapSeed struct {
+	Users   []BootstrapUser
+	Invites []string
+	Capes   []BootstrapCape
+}
+
+func validateBootstrapSeed(seed *bootstrapSeed) error {
+	for _, user := range seed.Users {
+		if user.Username == "" {
+			return errors.New("Bootstrap.Users must have a Username")
+		}
+		if (user.Password == "") == (user.PasswordFile == "") {
+			return fmt.Errorf("Bootstrap user %s must have either a Password or a PasswordFile", user.Username)
+		}
+	}
+	for _, code := range seed.Invites {
+		if code == "" {
+			return errors.New("Bootstrap.Invites can't be empty")
+		}
+	}
+	for _, cape := range seed.Capes {
+		if cape.Name == "" {
+			return errors.New("Bootstrap.Capes must have a Name")
+		}
+		if cape.File == "" {
+			return fmt.Errorf("Bootstrap cape %s must have a File", cape.Name)
+		}
+	}
+	return nil
+}
+
+// Everything declared in the config, followed by everything declared in the
+// seed file
+func (app *App) getBootstrapSeed() (*bootstrapSeed, error) {
+	seed := bootstrapSeed{
+		Users:   app.Config().Bootstrap.Users,
+		Invites: app.Config().Bootstrap.Invites,
+		Capes:   app.Config().Bootstrap.Capes,
+	}
+	if app.Config().Bootstrap.SeedFile == "" {
+		return &seed, nil
+	}
+
+	var fileSeed bootstrapSeed
+	if _, err := toml.DecodeFile(app.Config().Bootstrap.SeedFile, &fileSeed); err != nil {
+		return nil, fmt.Errorf("couldn't read Bootstrap.SeedFile: %w", err)
+	}
+	if err := validateBootstrapSeed(&fileSeed); err != nil {
+		return nil, fmt.Errorf("invalid Bootstrap.SeedFile: %w", err)
+	}
+	seed.Users = append(append([]BootstrapUser{}, seed.Users...), fileSeed.Users...)
+	seed.Invites = append(append([]string{}, seed.Invites...), fileSeed.Invites...)
+	seed.Capes = append(append([]BootstrapCape{}, seed.Capes...), fileSeed.Capes...)
+	return &seed, nil
+}
+
+func (app *App) bootstrapCape(cape *BootstrapCape) error {
+	var count int64
+	if err := app.DB.Model(&Cosmetic{}).Where("name = ?", cape.Name).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	file, err := os.Open(cape.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := app.CreateCapeCosmetic(cape.Name, file); err != nil {
+		return fmt.Errorf("couldn't create cape %s: %w", cape.Name, err)
+	}
+	log.Printf("Bootstrap: created cape %s\n", cape.Name)
+	return nil
+}
+
+func (app *App) bootstrapUser(bootstrapUser *BootstrapUser) error {
+	var user User
+	err := app.DB.First(&user, "username = ?", bootstrapUser.Username).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		password := bootstrapUser.Password
+		if bootstrapUser.PasswordFile != "" {
+			buf, err := os.ReadFile(bootstrapUser.PasswordFile)
+			if err != nil {
+				return err
+			}
+			password = strings.TrimSpace(string(buf))
+		}
+		created, err := app.RegisterUser(&RegistrationRequest{
+			Username:   bootstrapUser.Username,
+			Password:   password,
+			SkipPolicy: true,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't create user %s: %w", bootstrapUser.Username, err)
+		}
+		user = *created
+		log.Printf("Bootstrap: created user %s\n", user.Username)
+	} else if err != nil {
+		return err
+	}
+
+	if bootstrapUser.IsAdmin && !user.IsAdmin {
+		if err := app.DB.Model(&user).Update("is_admin", true).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, capeName := range bootstrapUser.Capes {
+		var cosmetic Cosmetic
+		if err := app.DB.First(&cosmetic, "name = ?", capeName).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("cape %s of user %s not found", capeName, bootstrapUser.Username)
+			}
+			return err
+		}
+		if err := app.GrantCosmetic(&cosmetic, &user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) bootstrapInvite(code string) error {
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&BootstrappedInvite{}).Where("code = ?", code).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		now := time.Now()
+		if err := tx.Create(&BootstrappedInvite{Code: code, CreatedAt: now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&Invite{Code: code, CreatedAt: now}).Error; err != nil {
+			if IsErrorUniqueFailed(err) {
+				// Someone already made an invite with this code
+				return nil
+			}
+			return err
+		}
+		log.Printf("Bootstrap: created invite %s\n", code)
+		return nil
+	})
+}
+
+// Create whatever is declared in Bootstrap that doesn't exist yet. Capes come
+// first so that users can be granted them.
+func (app *App) ApplyBootstrap() error {
+	seed, err := app.getBootstrapSeed()
+	if err != nil {
+		return err
+	}
+	for _, cape := range seed.Capes {
+		if err := app.bootstrapCape(&cape); err != nil {
+			return err
+		}
+	}
+	for _, user := range seed.Users {
+		if err := app.bootstrapUser(&user); err != nil {
+			return err
+		}
+	}
+	for _, code := range seed.Invites {
+		if err := app.bootstrapInvite(code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
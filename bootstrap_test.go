@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		dir := t.TempDir()
+		capePath := path.Join(dir, "founder.png")
+		assert.Nil(t, os.WriteFile(capePath, RED_CAPE, 0600))
+		passwordPath := path.Join(dir, "password")
+		assert.Nil(t, os.WriteFile(passwordPath, []byte(TEST_PASSWORD+"\n"), 0600))
+		seedPath := path.Join(dir, "seed.toml")
+		seed := `Invites = ["seededinvite"]
+
+[[Users]]
+Username = "seeded"
+PasswordFile = "` + passwordPath + `"
+Capes = ["Founder"]
+`
+		assert.Nil(t, os.WriteFile(seedPath, []byte(seed), 0600))
+
+		config := testConfig()
+		config.Bootstrap.SeedFile = seedPath
+		config.Bootstrap.Users = []BootstrapUser{{Username: "admin", Password: TEST_PASSWORD, IsAdmin: true}}
+		config.Bootstrap.Invites = []string{"configinvite"}
+		config.Bootstrap.Capes = []BootstrapCape{{Name: "Founder", File: capePath}}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test Bootstrap", ts.testBootstrap)
+	}
+}
+
+func (ts *TestSuite) testBootstrap(t *testing.T) {
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", "admin").Error)
+	assert.True(t, admin.IsAdmin)
+	ts.authenticate(t, "admin", TEST_PASSWORD)
+
+	// The password is read from PasswordFile
+	var seeded User
+	assert.Nil(t, ts.App.DB.First(&seeded, "username = ?", "seeded").Error)
+	assert.False(t, seeded.IsAdmin)
+	ts.authenticate(t, "seeded", TEST_PASSWORD)
+
+	cosmetics, err := ts.App.GetEntitledCosmetics(&seeded)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(cosmetics))
+	assert.Equal(t, "Founder", cosmetics[0].Name)
+
+	var invites []Invite
+	assert.Nil(t, ts.App.DB.Order("code").Find(&invites).Error)
+	assert.Equal(t, 2, len(invites))
+	assert.Equal(t, "configinvite", invites[0].Code)
+	assert.Equal(t, "seededinvite", invites[1].Code)
+
+	// Applying it again changes nothing
+	assert.Nil(t, ts.App.ApplyBootstrap())
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&User{}).Count(&count).Error)
+	assert.Equal(t, int64(2), count)
+	assert.Nil(t, ts.App.DB.Model(&Cosmetic{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Used invites aren't created again, but deleted users are
+	assert.Nil(t, ts.App.DB.Delete(&invites[0]).Error)
+	assert.Nil(t, DeleteUser(ts.App, &seeded))
+	assert.Nil(t, ts.App.ApplyBootstrap())
+	assert.Nil(t, ts.App.DB.Model(&Invite{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	assert.Nil(t, ts.App.DB.Model(&User{}).Where("username = ?", "seeded").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strconv"
+)
+
+/*
+Branding lets operators swap the logo, favicon, and accent color of the web
+front end and add a line of text to its footer without making a Theme. The
+shades of the accent color used by the stylesheet are derived from
+Branding.AccentColor.
+*/
+
+func (app *App) LogoURL() string {
+	if app.Config().Branding.LogoFile != "" {
+		return app.FrontEndURL + "/drasl/branding/logo"
+	}
+	return app.FrontEndURL + "/drasl/public/logo.svg"
+}
+
+func (app *App) FaviconURL() string {
+	if app.Config().Branding.FaviconFile != "" {
+		return app.FrontEndURL + "/drasl/branding/favicon"
+	}
+	return app.FrontEndURL + "/drasl/public/icon.png"
+}
+
+type rgb [3]float64
+
+func parseHexColor(hex string) (rgb, error) {
+	var color rgb
+	if len(hex) != 7 || hex[0] != '#' {
+		return color, fmt.Errorf("invalid color %s", hex)
+	}
+	for i := range color {
+		component, err := strconv.ParseUint(hex[1+2*i:3+2*i], 16, 8)
+		if err != nil {
+			return color, err
+		}
+		color[i] = float64(component)
+	}
+	return color, nil
+}
+
+// Move the color fraction `t` of the way towards `target`
+func (color rgb) mix(target float64, t float64) rgb {
+	var mixed rgb
+	for i := range color {
+		mixed[i] = color[i] + (target-color[i])*t
+	}
+	return mixed
+}
+
+func (color rgb) hex(alpha string) string {
+	return fmt.Sprintf("#%02x%02x%02x%s", int(color[0]+0.5), int(color[1]+0.5), int(color[2]+0.5), alpha)
+}
+
+// Stylesheet overriding the accent colors of style.css, or "" if
+// Branding.AccentColor isn't set
+func (app *App) AccentStyle() template.CSS {
+	accent, err := parseHexColor(app.Config().Branding.AccentColor)
+	if err != nil {
+		return ""
+	}
+	return template.CSS(fmt.Sprintf(
+		":root { --accent: %s; --accent-light: %s; --accent-lighter: %s; --accent-dark: %s; --button-shadow-light: %s; --button-shadow-dark: %s; }",
+		accent.hex(""),
+		accent.mix(255, 0.5).hex(""),
+		accent.mix(255, 0.75).hex(""),
+		accent.mix(0, 0.2).hex(""),
+		accent.mix(255, 0.25).hex("bb"),
+		accent.mix(0, 1.0/3).hex("bb"),
+	))
+}
+
+// Branding.FooterText rendered from Markdown
+func (app *App) FooterHTML() (template.HTML, error) {
+	if app.Config().Branding.FooterText == "" {
+		return "", nil
+	}
+	return RenderMarkdown(app.Config().Branding.FooterText)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBranding(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		brandingDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(brandingDirectory)
+		logoPath := filepath.Join(brandingDirectory, "logo.png")
+		Check(os.WriteFile(logoPath, RED_SKIN, 0644))
+
+		config := testConfig()
+		config.Branding.LogoFile = logoPath
+		config.Branding.AccentColor = "#008080"
+		config.Branding.FooterText = "Hosted by [Example](https://example.com)."
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test branding", ts.testBranding)
+	}
+}
+
+func (ts *TestSuite) testBranding(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `src="`+ts.App.FrontEndURL+`/drasl/branding/logo"`)
+	assert.Contains(t, body, "--accent: #008080; --accent-light: #80c0c0;")
+	assert.Contains(t, body, "--accent-dark: #006666;")
+	assert.Contains(t, body, `<a href="https://example.com">Example</a>`)
+	// The favicon isn't branded
+	assert.Contains(t, body, `href="`+ts.App.FrontEndURL+`/drasl/public/icon.png"`)
+
+	rec = ts.Get(t, ts.Server, "/drasl/branding/logo", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, RED_SKIN, rec.Body.Bytes())
+
+	rec = ts.Get(t, ts.Server, "/drasl/branding/favicon", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/info", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response apiInfoResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, ts.App.FrontEndURL+"/drasl/branding/logo", response.Branding.LogoURL)
+	assert.Equal(t, ts.App.FrontEndURL+"/drasl/public/icon.png", response.Branding.FaviconURL)
+	assert.Equal(t, "#008080", *response.Branding.AccentColor)
+	assert.Equal(t, "Hosted by [Example](https://example.com).", *response.Branding.FooterText)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+/*
+Bulk operations on a skin or cape, by hash, across every user who has it, e.g.
+to get rid of an offensive skin that spread by URL. Run by `drasl textures`.
+Clearing or replacing a skin also clears or replaces it in skin libraries and
+appearance history, so that skin rotation or a rollback can't bring it back;
+likewise for a cape in appearance history. Every operation can be run as a
+dry run, which reports what would change without changing anything.
+*/
+
+var textureHashRegex = regexp.MustCompile("^[0-9a-f]{64}$")
+
+const (
+	BulkTextureFind    = "find"
+	BulkTextureClear   = "clear"
+	BulkTextureReplace = "replace"
+)
+
+type BulkTextureReport struct {
+	Action string
+	Kind   string
+	Hash   string
+	// Replacement texture's hash, if replacing
+	NewHash      string
+	Usernames    []string
+	LibrarySkins int64
+	Snapshots    int64
+	DryRun       bool
+}
+
+func getTextureKind(name string) (*textureKind, error) {
+	for i := range TEXTURE_KINDS {
+		if TEXTURE_KINDS[i].Name == name {
+			return &TEXTURE_KINDS[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown texture type %s, must be skin or cape", name)
+}
+
+// Accept either a bare hash or a texture URL like
+// https://drasl.example.com/drasl/texture/skin/<hash>.png
+func ParseTextureHash(hashOrURL string) (string, error) {
+	hash := strings.TrimSuffix(path.Base(hashOrURL), ".png")
+	if !textureHashRegex.MatchString(hash) {
+		return "", fmt.Errorf("Invalid texture hash %s", hashOrURL)
+	}
+	return hash, nil
+}
+
+// Usernames of the users wearing the texture, and how many library skins and
+// appearance snapshots have it
+func (app *App) findTextureReferences(db *gorm.DB, action string, kind *textureKind, hash string) (*BulkTextureReport, error) {
+	report := BulkTextureReport{Action: action, Kind: kind.Name, Hash: hash}
+	err := db.Model(&User{}).Where(kind.Column+" = ?", hash).Order("username").Pluck("username", &report.Usernames).Error
+	if err != nil {
+		return nil, err
+	}
+	if kind.Name == TextureTypeSkin {
+		if err := db.Model(&LibrarySkin{}).Where("skin_hash = ?", hash).Count(&report.LibrarySkins).Error; err != nil {
+			return nil, err
+		}
+	}
+	if err := db.Model(&AppearanceSnapshot{}).Where(kind.Column+" = ?", hash).Count(&report.Snapshots).Error; err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (app *App) deleteTextureIfUnused(kind *textureKind, hash string) error {
+	var err error
+	if kind.Name == TextureTypeSkin {
+		err = DeleteSkinIfUnused(app, &hash)
+	} else {
+		err = DeleteCapeIfUnused(app, &hash)
+	}
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List the users who have the texture
+func (app *App) FindTextureUsers(kindName string, hash string) (*BulkTextureReport, error) {
+	kind, err := getTextureKind(kindName)
+	if err != nil {
+		return nil, err
+	}
+	return app.findTextureReferences(app.DB, BulkTextureFind, kind, hash)
+}
+
+// Take the texture away from everyone who has it. Library skins with it are
+// deleted, and it's cleared from appearance snapshots.
+func (app *App) BulkClearTexture(kindName string, hash string, dryRun bool) (*BulkTextureReport, error) {
+	kind, err := getTextureKind(kindName)
+	if err != nil {
+		return nil, err
+	}
+
+	var report *BulkTextureReport
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		report, err = app.findTextureReferences(tx, BulkTextureClear, kind, hash)
+		if err != nil || dryRun {
+			return err
+		}
+		if err := tx.Model(&User{}).Where(kind.Column+" = ?", hash).Update(kind.Column, nil).Error; err != nil {
+			return err
+		}
+		if kind.Name == TextureTypeSkin {
+			if err := tx.Where("skin_hash = ?", hash).Delete(&LibrarySkin{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&AppearanceSnapshot{}).Where(kind.Column+" = ?", hash).Update(kind.Column, nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	details := fmt.Sprintf("%s %s cleared from %d users", kind.Name, hash, len(report.Usernames))
+	if err := app.LogAudit(nil, AuditActionBulkClearTexture, nil, details); err != nil {
+		return nil, err
+	}
+	return report, app.deleteTextureIfUnused(kind, hash)
+}
+
+// Replace the texture with a new one, read from `reader`, for everyone who
+// has it, including in library skins and appearance snapshots. The new
+// texture is validated like any upload.
+func (app *App) BulkReplaceTexture(kindName string, hash string, reader io.Reader, dryRun bool) (*BulkTextureReport, error) {
+	kind, err := getTextureKind(kindName)
+	if err != nil {
+		return nil, err
+	}
+
+	var validReader io.Reader
+	if kind.Name == TextureTypeSkin {
+		validReader, err = ValidateSkin(app, reader)
+	} else {
+		validReader, err = ValidateCape(app, reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: %s", kind.Name, err)
+	}
+	buf, newHash, err := ReadTexture(app, validReader)
+	if err != nil {
+		return nil, err
+	}
+	if newHash == hash {
+		return nil, fmt.Errorf("The new %s is the same as the old one", kind.Name)
+	}
+
+	newPath := kind.Path(app, newHash)
+	unlock := app.FSMutex.Lock(newPath)
+	defer unlock()
+
+	var report *BulkTextureReport
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		report, err = app.findTextureReferences(tx, BulkTextureReplace, kind, hash)
+		if err != nil || dryRun {
+			return err
+		}
+		if err := tx.Model(&User{}).Where(kind.Column+" = ?", hash).Update(kind.Column, newHash).Error; err != nil {
+			return err
+		}
+		if kind.Name == TextureTypeSkin {
+			if err := tx.Model(&LibrarySkin{}).Where("skin_hash = ?", hash).Update("skin_hash", newHash).Error; err != nil {
+				return err
+			}
+			if err := SaveSkinFingerprint(tx, newHash, buf.Bytes()); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&AppearanceSnapshot{}).Where(kind.Column+" = ?", hash).Update(kind.Column, newHash).Error; err != nil {
+			return err
+		}
+		// Written inside the transaction so that users are never left with
+		// a texture that isn't on disk
+		return writeTextureLocked(newPath, bytes.NewBuffer(buf.Bytes()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.NewHash = newHash
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	details := fmt.Sprintf("%s %s replaced with %s for %d users", kind.Name, hash, newHash, len(report.Usernames))
+	if err := app.LogAudit(nil, AuditActionBulkReplaceTexture, nil, details); err != nil {
+		return nil, err
+	}
+	return report, app.deleteTextureIfUnused(kind, hash)
+}
+
+func (report *BulkTextureReport) String() string {
+	var buf bytes.Buffer
+	for _, username := range report.Usernames {
+		buf.WriteString(username + "\n")
+	}
+
+	var summary string
+	switch {
+	case report.Action == BulkTextureFind:
+		summary = fmt.Sprintf("Found %s %s", report.Kind, report.Hash)
+	case report.Action == BulkTextureClear && report.DryRun:
+		summary = fmt.Sprintf("Would clear %s %s", report.Kind, report.Hash)
+	case report.Action == BulkTextureClear:
+		summary = fmt.Sprintf("Cleared %s %s", report.Kind, report.Hash)
+	case report.Action == BulkTextureReplace && report.DryRun:
+		summary = fmt.Sprintf("Would replace %s %s with %s", report.Kind, report.Hash, report.NewHash)
+	case report.Action == BulkTextureReplace:
+		summary = fmt.Sprintf("Replaced %s %s with %s", report.Kind, report.Hash, report.NewHash)
+	}
+	buf.WriteString(fmt.Sprintf("%s in %d users", summary, len(report.Usernames)))
+	if report.Kind == TextureTypeSkin {
+		buf.WriteString(fmt.Sprintf(", %d library skins", report.LibrarySkins))
+	}
+	buf.WriteString(fmt.Sprintf(", %d appearance snapshots", report.Snapshots))
+	return buf.String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestBulkTextures(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, "bulk1")
+		ts.CreateTestUser(ts.Server, "bulk2")
+		ts.CreateTestUser(ts.Server, "bulk3")
+
+		t.Run("Test bulk texture operations", ts.testBulkTextures)
+	}
+}
+
+func (ts *TestSuite) testBulkTextures(t *testing.T) {
+	var users []User
+	for _, username := range []string{"bulk1", "bulk2", "bulk3"} {
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		users = append(users, user)
+	}
+	// bulk1 and bulk2 share a skin, and bulk1 also has it in their library
+	assert.Nil(t, SetSkinAndSave(ts.App, &users[0], bytes.NewReader(RED_SKIN)))
+	assert.Nil(t, SetSkinAndSave(ts.App, &users[1], bytes.NewReader(RED_SKIN)))
+	assert.Nil(t, SetSkinAndSave(ts.App, &users[2], bytes.NewReader(BLUE_SKIN)))
+	redHash := users[0].SkinHash.String
+	blueHash := users[2].SkinHash.String
+	assert.Nil(t, ts.App.DB.Create(&LibrarySkin{
+		ID:        "bulk-library-skin",
+		UserUUID:  users[0].UUID,
+		SkinHash:  redHash,
+		SkinModel: SkinModelClassic,
+	}).Error)
+
+	hash, err := ParseTextureHash(ts.App.FrontEndURL + "/drasl/texture/skin/" + redHash + ".png")
+	assert.Nil(t, err)
+	assert.Equal(t, redHash, hash)
+	_, err = ParseTextureHash("../../etc/passwd")
+	assert.NotNil(t, err)
+
+	report, err := ts.App.FindTextureUsers("skin", redHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bulk1", "bulk2"}, report.Usernames)
+	assert.Equal(t, int64(1), report.LibrarySkins)
+
+	_, err = ts.App.FindTextureUsers("hat", redHash)
+	assert.NotNil(t, err)
+
+	// A dry run changes nothing
+	report, err = ts.App.BulkClearTexture("skin", redHash, true)
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"bulk1", "bulk2"}, report.Usernames)
+	report, err = ts.App.FindTextureUsers("skin", redHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bulk1", "bulk2"}, report.Usernames)
+
+	// Replace the red skin with the blue one
+	report, err = ts.App.BulkReplaceTexture("skin", redHash, bytes.NewReader(BLUE_SKIN), true)
+	assert.Nil(t, err)
+	assert.Equal(t, blueHash, report.NewHash)
+	_, err = os.Stat(GetSkinPath(ts.App, redHash))
+	assert.Nil(t, err)
+
+	report, err = ts.App.BulkReplaceTexture("skin", redHash, bytes.NewReader(BLUE_SKIN), false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bulk1", "bulk2"}, report.Usernames)
+	for _, user := range users {
+		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+		assert.Equal(t, blueHash, user.SkinHash.String)
+	}
+	var librarySkin LibrarySkin
+	assert.Nil(t, ts.App.DB.First(&librarySkin, "id = ?", "bulk-library-skin").Error)
+	assert.Equal(t, blueHash, librarySkin.SkinHash)
+
+	// The red skin is no longer used anywhere, so it's deleted
+	_, err = os.Stat(GetSkinPath(ts.App, redHash))
+	assert.True(t, os.IsNotExist(err))
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionBulkReplaceTexture).Error)
+	assert.Equal(t, AUDIT_SYSTEM_ACTOR, auditLogEntry.ActorUsername)
+
+	// Now clear it from everyone
+	report, err = ts.App.BulkClearTexture("skin", blueHash, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"bulk1", "bulk2", "bulk3"}, report.Usernames)
+	for _, user := range users {
+		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+		assert.False(t, user.SkinHash.Valid)
+	}
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&LibrarySkin{}).Where("skin_hash = ?", blueHash).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	assert.Nil(t, ts.App.DB.Model(&AppearanceSnapshot{}).Where("skin_hash = ?", blueHash).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	_, err = os.Stat(GetSkinPath(ts.App, blueHash))
+	assert.True(t, os.IsNotExist(err))
+
+	// The replacement is validated like any upload
+	_, err = ts.App.BulkReplaceTexture("cape", redHash, bytes.NewReader(RED_SKIN), false)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+Large HD skins and capes can be uploaded from the profile page in chunks, each
+small enough to get past BodyLimit, with progress shown as they go. A chunk
+that fails can be sent again, and an interrupted upload picks up where the
+server left off. Chunks are written to a temporary file in the state
+directory until the profile form is submitted with the upload's ID. The temp
+files of all pending uploads together are bounded by
+ChunkedUploads.MaxTotalSizeMiB, and uploads that haven't received a chunk in
+ChunkedUploads.ExpireSec are deleted. Like the texture queue, pending uploads
+are kept in memory and are lost on restart.
+*/
+
+const CHUNKED_UPLOAD_CLEANUP_INTERVAL = time.Minute
+
+var errUploadNotFound = errors.New("Upload not found.")
+var errUploadTooLarge = fmt.Errorf("Uploads can't be larger than %d bytes.", int64(MAX_TEXTURE_FILE_SIZE))
+var errUploadStorageFull = errors.New("The server is too busy to accept this upload. Please try again in a moment.")
+var errUploadIncomplete = errors.New("The upload isn't finished yet.")
+
+// Returned along with the upload when a chunk doesn't start where the
+// upload left off, so the client can resume from Received
+var errUploadWrongOffset = errors.New("That chunk doesn't continue the upload.")
+
+type ChunkedUpload struct {
+	ID           string
+	UserUUID     string
+	TextureType  string
+	Size         int64
+	Received     int64
+	LastActiveAt time.Time
+}
+
+type ChunkedUploads struct {
+	app *App
+	dir string
+	// Guards uploads and the temp files' contents
+	mutex   sync.Mutex
+	uploads map[string]*ChunkedUpload
+}
+
+func chunkedUploadsDirectory(config *Config) string {
+	return path.Join(config.StateDirectory, "uploads")
+}
+
+// Uploads don't survive a restart, so any temp files left over from the last
+// run are deleted
+func NewChunkedUploads(app *App) (*ChunkedUploads, error) {
+	dir := chunkedUploadsDirectory(app.Config())
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &ChunkedUploads{
+		app:     app,
+		dir:     dir,
+		uploads: map[string]*ChunkedUpload{},
+	}, nil
+}
+
+func (uploads *ChunkedUploads) ChunkSize() int64 {
+	return int64(uploads.app.Config().ChunkedUploads.ChunkSizeKiB) * 1024
+}
+
+func (uploads *ChunkedUploads) filePath(id string) string {
+	return path.Join(uploads.dir, id)
+}
+
+// Call with the mutex held
+func (uploads *ChunkedUploads) remove(upload *ChunkedUpload) {
+	delete(uploads.uploads, upload.ID)
+	if err := os.Remove(uploads.filePath(upload.ID)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't delete upload %s: %s\n", upload.ID, err)
+	}
+}
+
+// Call with the mutex held
+func (uploads *ChunkedUploads) get(user *User, id string) (*ChunkedUpload, error) {
+	upload, ok := uploads.uploads[id]
+	if !ok || upload.UserUUID != user.UUID {
+		return nil, errUploadNotFound
+	}
+	return upload, nil
+}
+
+// Start an upload of `size` bytes. A user has at most one pending upload of
+// each texture type; starting another replaces it.
+func (uploads *ChunkedUploads) Start(user *User, textureType string, size int64) (*ChunkedUpload, error) {
+	if size <= 0 || size > MAX_TEXTURE_FILE_SIZE {
+		return nil, errUploadTooLarge
+	}
+
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	var totalSize int64 = 0
+	for _, upload := range uploads.uploads {
+		if upload.UserUUID == user.UUID && upload.TextureType == textureType {
+			uploads.remove(upload)
+			continue
+		}
+		totalSize += upload.Size
+	}
+	if totalSize+size > int64(uploads.app.Config().ChunkedUploads.MaxTotalSizeMiB)*1024*1024 {
+		return nil, errUploadStorageFull
+	}
+
+	id, err := RandomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(uploads.filePath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+
+	upload := &ChunkedUpload{
+		ID:           id,
+		UserUUID:     user.UUID,
+		TextureType:  textureType,
+		Size:         size,
+		Received:     0,
+		LastActiveAt: time.Now(),
+	}
+	uploads.uploads[id] = upload
+	return upload, nil
+}
+
+// Append `chunk`, which must start at `offset`, to the upload
+func (uploads *ChunkedUploads) Append(user *User, id string, offset int64, chunk []byte) (ChunkedUpload, error) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	upload, err := uploads.get(user, id)
+	if err != nil {
+		return ChunkedUpload{}, err
+	}
+	if offset != upload.Received {
+		return *upload, errUploadWrongOffset
+	}
+	if upload.Received+int64(len(chunk)) > upload.Size {
+		return *upload, errUploadTooLarge
+	}
+
+	file, err := os.OpenFile(uploads.filePath(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return *upload, err
+	}
+	_, err = file.Write(chunk)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Drop whatever part of the chunk made it to disk so the chunk can
+		// be sent again
+		if truncateErr := os.Truncate(uploads.filePath(id), upload.Received); truncateErr != nil {
+			uploads.remove(upload)
+		}
+		return *upload, err
+	}
+
+	upload.Received += int64(len(chunk))
+	upload.LastActiveAt = time.Now()
+	return *upload, nil
+}
+
+func (uploads *ChunkedUploads) Status(user *User, id string) (ChunkedUpload, error) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	upload, err := uploads.get(user, id)
+	if err != nil {
+		return ChunkedUpload{}, err
+	}
+	return *upload, nil
+}
+
+// The contents of a finished upload of the given texture type. The upload is
+// kept until Remove is called, so it can be used again if the rest of the
+// form needs fixing.
+func (uploads *ChunkedUploads) Read(user *User, id string, textureType string) ([]byte, error) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	upload, err := uploads.get(user, id)
+	if err != nil || upload.TextureType != textureType {
+		return nil, errUploadNotFound
+	}
+	if upload.Received != upload.Size {
+		return nil, errUploadIncomplete
+	}
+	upload.LastActiveAt = time.Now()
+	return os.ReadFile(uploads.filePath(id))
+}
+
+func (uploads *ChunkedUploads) Remove(user *User, id string) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	if upload, err := uploads.get(user, id); err == nil {
+		uploads.remove(upload)
+	}
+}
+
+// Delete uploads that haven't been touched in ChunkedUploads.ExpireSec
+func (uploads *ChunkedUploads) RemoveExpired(now time.Time) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	expiry := time.Duration(uploads.app.Config().ChunkedUploads.ExpireSec) * time.Second
+	for _, upload := range uploads.uploads {
+		if now.Sub(upload.LastActiveAt) > expiry {
+			uploads.remove(upload)
+		}
+	}
+}
+
+func (app *App) RunChunkedUploadCleanup() {
+	for {
+		app.ChunkedUploads.RemoveExpired(time.Now())
+		time.Sleep(CHUNKED_UPLOAD_CLEANUP_INTERVAL)
+	}
+}
+
+type chunkedUploadResponse struct {
+	ID        string `json:"id,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Received  int64  `json:"received"`
+	ChunkSize int64  `json:"chunkSize,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+func makeChunkedUploadResponse(uploads *ChunkedUploads, upload *ChunkedUpload) chunkedUploadResponse {
+	return chunkedUploadResponse{
+		ID:        upload.ID,
+		Size:      upload.Size,
+		Received:  upload.Received,
+		ChunkSize: uploads.ChunkSize(),
+	}
+}
+
+func chunkedUploadErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, errUploadNotFound):
+		return c.JSON(http.StatusNotFound, chunkedUploadResponse{Message: err.Error()})
+	case errors.Is(err, errUploadTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, chunkedUploadResponse{Message: err.Error()})
+	case errors.Is(err, errUploadStorageFull):
+		return c.JSON(http.StatusServiceUnavailable, chunkedUploadResponse{Message: err.Error()})
+	}
+	return err
+}
+
+// POST /drasl/uploads
+func FrontStartChunkedUpload(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		if app.ChunkedUploads == nil {
+			return c.JSON(http.StatusNotFound, chunkedUploadResponse{Message: "Chunked uploads are not enabled on this server."})
+		}
+
+		textureType := c.FormValue("textureType")
+		if textureType != TextureTypeSkin && textureType != TextureTypeCape {
+			return c.JSON(http.StatusBadRequest, chunkedUploadResponse{Message: "Invalid texture type."})
+		}
+		size, err := strconv.ParseInt(c.FormValue("size"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, chunkedUploadResponse{Message: "Invalid size."})
+		}
+
+		upload, err := app.ChunkedUploads.Start(user, textureType, size)
+		if err != nil {
+			return chunkedUploadErrorResponse(c, err)
+		}
+		return c.JSON(http.StatusOK, makeChunkedUploadResponse(app.ChunkedUploads, upload))
+	})
+}
+
+// GET /drasl/uploads/:id
+func FrontChunkedUploadStatus(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		if app.ChunkedUploads == nil {
+			return c.JSON(http.StatusNotFound, chunkedUploadResponse{Message: errUploadNotFound.Error()})
+		}
+		upload, err := app.ChunkedUploads.Status(user, c.Param("id"))
+		if err != nil {
+			return chunkedUploadErrorResponse(c, err)
+		}
+		return c.JSON(http.StatusOK, makeChunkedUploadResponse(app.ChunkedUploads, &upload))
+	})
+}
+
+// PUT /drasl/uploads/:id?offset=<offset>
+func FrontAppendChunkedUpload(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		if app.ChunkedUploads == nil {
+			return c.JSON(http.StatusNotFound, chunkedUploadResponse{Message: errUploadNotFound.Error()})
+		}
+		offset, err := strconv.ParseInt(c.QueryParam("offset"), 10, 64)
+		if err != nil || offset < 0 {
+			return c.JSON(http.StatusBadRequest, chunkedUploadResponse{Message: "Invalid offset."})
+		}
+
+		// Read the chunk before taking the lock, so a slow client doesn't
+		// hold up everyone else's uploads
+		chunkSize := app.ChunkedUploads.ChunkSize()
+		chunk, err := io.ReadAll(io.LimitReader(c.Request().Body, chunkSize+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(chunk)) > chunkSize {
+			return c.JSON(http.StatusRequestEntityTooLarge, chunkedUploadResponse{Message: fmt.Sprintf("Chunks can't be larger than %d bytes.", chunkSize)})
+		}
+
+		upload, err := app.ChunkedUploads.Append(user, c.Param("id"), offset, chunk)
+		if errors.Is(err, errUploadWrongOffset) {
+			response := makeChunkedUploadResponse(app.ChunkedUploads, &upload)
+			response.Message = err.Error()
+			return c.JSON(http.StatusConflict, response)
+		}
+		if err != nil {
+			return chunkedUploadErrorResponse(c, err)
+		}
+		return c.JSON(http.StatusOK, makeChunkedUploadResponse(app.ChunkedUploads, &upload))
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChunkedUploads(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.ChunkedUploads.Enable = true
+		config.ChunkedUploads.ChunkSizeKiB = 1
+		config.ChunkedUploads.MaxTotalSizeMiB = 1
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test chunked uploads", ts.testChunkedUploads)
+		t.Run("Test chunked upload expiry", ts.testChunkedUploadExpiry)
+	}
+}
+
+func (ts *TestSuite) startChunkedUpload(t *testing.T, cookie *http.Cookie, textureType string, size int) (*httptest.ResponseRecorder, chunkedUploadResponse) {
+	form := url.Values{}
+	form.Set("textureType", textureType)
+	form.Set("size", strconv.Itoa(size))
+	rec := ts.PostForm(t, ts.Server, "/drasl/uploads", form, []http.Cookie{*cookie}, nil)
+	var response chunkedUploadResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	return rec, response
+}
+
+func (ts *TestSuite) appendChunk(t *testing.T, cookie *http.Cookie, id string, offset int, chunk []byte) (*httptest.ResponseRecorder, chunkedUploadResponse) {
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/drasl/uploads/%s?offset=%d", id, offset), bytes.NewReader(chunk))
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	ts.Server.ServeHTTP(rec, req)
+	var response chunkedUploadResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	return rec, response
+}
+
+func (ts *TestSuite) testChunkedUploads(t *testing.T) {
+	username := "chunked"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, "chunkedOther")
+
+	{
+		// Uploads must fit in MAX_TEXTURE_FILE_SIZE...
+		rec, _ := ts.startChunkedUpload(t, browserTokenCookie, TextureTypeSkin, MAX_TEXTURE_FILE_SIZE+1)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+		// ...and in the space left for temp files
+		rec, _ = ts.startChunkedUpload(t, browserTokenCookie, TextureTypeSkin, 2*1024*1024)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		rec, _ = ts.startChunkedUpload(t, browserTokenCookie, "hat", len(RED_SKIN))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	rec, upload := ts.startChunkedUpload(t, browserTokenCookie, TextureTypeSkin, len(RED_SKIN))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(len(RED_SKIN)), upload.Size)
+	assert.Equal(t, int64(0), upload.Received)
+	assert.Equal(t, int64(1024), upload.ChunkSize)
+
+	half := len(RED_SKIN) / 2
+	rec, status := ts.appendChunk(t, browserTokenCookie, upload.ID, 0, RED_SKIN[:half])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(half), status.Received)
+
+	{
+		// A chunk sent twice is refused, with where the upload left off
+		rec, status := ts.appendChunk(t, browserTokenCookie, upload.ID, 0, RED_SKIN[:half])
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, int64(half), status.Received)
+
+		// Chunks can't be larger than ChunkSizeKiB
+		rec, _ = ts.appendChunk(t, browserTokenCookie, upload.ID, half, make([]byte, 1025))
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+		// Other users can't see or add to the upload
+		rec = ts.Get(t, ts.Server, "/drasl/uploads/"+upload.ID, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec, _ = ts.appendChunk(t, otherBrowserTokenCookie, upload.ID, half, RED_SKIN[half:])
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+
+	rec = ts.Get(t, ts.Server, "/drasl/uploads/"+upload.ID, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+	assert.Equal(t, int64(half), status.Received)
+
+	rec, status = ts.appendChunk(t, browserTokenCookie, upload.ID, half, RED_SKIN[half:])
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(len(RED_SKIN)), status.Received)
+
+	// Nothing past the declared size
+	rec, _ = ts.appendChunk(t, browserTokenCookie, upload.ID, len(RED_SKIN), []byte{0})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	{
+		// The upload can't be used as a cape
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("capeUploadId", upload.ID)
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Your uploaded cape has expired. Please upload it again.", ts.App.FrontEndURL+"/drasl/profile")
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("skinUploadId", upload.ID)
+	writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec = ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+	ts.updateShouldSucceed(t, rec)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	assert.NotNil(t, UnmakeNullString(&user.SkinHash))
+
+	// The upload is removed once it's been used
+	rec = ts.Get(t, ts.Server, "/drasl/uploads/"+upload.ID, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	_, err := os.Stat(ts.App.ChunkedUploads.filePath(upload.ID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func (ts *TestSuite) testChunkedUploadExpiry(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, "chunkedExpiry")
+
+	rec, upload := ts.startChunkedUpload(t, browserTokenCookie, TextureTypeCape, len(RED_CAPE))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = ts.appendChunk(t, browserTokenCookie, upload.ID, 0, RED_CAPE[:10])
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	ts.App.ChunkedUploads.RemoveExpired(time.Now())
+	_, err := os.Stat(ts.App.ChunkedUploads.filePath(upload.ID))
+	assert.Nil(t, err)
+
+	expiry := time.Duration(ts.App.Config().ChunkedUploads.ExpireSec) * time.Second
+	ts.App.ChunkedUploads.RemoveExpired(time.Now().Add(expiry + time.Second))
+	_, err = os.Stat(ts.App.ChunkedUploads.filePath(upload.ID))
+	assert.True(t, os.IsNotExist(err))
+
+	rec = ts.Get(t, ts.Server, "/drasl/uploads/"+upload.ID, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
@@ -10,7 +12,12 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/image/webp"
+	"golang.org/x/net/http/httpproxy"
 	"gorm.io/gorm"
+	"image"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"log"
@@ -28,6 +35,8 @@ import (
 
 type ConstantsType struct {
 	ConfigDirectory     string
+	DataDirectory       string
+	StateDirectory      string
 	MaxPlayerNameLength int
 	MaxUsernameLength   int
 	Version             string
@@ -36,10 +45,14 @@ type ConstantsType struct {
 	RepositoryURL       string
 }
 
+var defaultDirectories = DefaultDirectories()
+
 var Constants = &ConstantsType{
 	MaxUsernameLength:   999,
 	MaxPlayerNameLength: 999,
-	ConfigDirectory:     DEFAULT_CONFIG_DIRECTORY,
+	ConfigDirectory:     defaultDirectories.Config,
+	DataDirectory:       defaultDirectories.Data,
+	StateDirectory:      defaultDirectories.State,
 	Version:             VERSION,
 	License:             LICENSE,
 	LicenseURL:          LICENSE_URL,
@@ -51,22 +64,34 @@ type CachedResponse struct {
 	BodyBytes  []byte
 }
 
-func (app *App) CachedGet(url string, ttl int) (CachedResponse, error) {
+// GET `url` from `fallbackAPIServer`, or from the request cache if the
+// server's CacheTTLSeconds allows. `cache` is FallbackCacheProfile or
+// FallbackCacheUUID, for the metrics.
+func (app *App) CachedGet(ctx context.Context, fallbackAPIServer *FallbackAPIServer, cache string, url string) (CachedResponse, error) {
+	ttl := fallbackAPIServer.CacheTTLSeconds
 	if ttl > 0 {
 		cachedResponse, found := app.RequestCache.Get(url)
+		app.Metrics.RecordCacheLookup(cache, found)
 		if found {
 			return cachedResponse.(CachedResponse), nil
 		}
 	}
 
-	res, err := MakeHTTPClient().Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return CachedResponse{}, err
+	}
+	start := time.Now()
+	res, err := app.MakeHTTPClient().Do(req)
 	if err != nil {
+		app.Metrics.ObserveFallbackLatency(fallbackAPIServer.Nickname, time.Since(start))
 		return CachedResponse{}, err
 	}
 	defer res.Body.Close()
 
 	buf := new(bytes.Buffer)
 	_, err = buf.ReadFrom(res.Body)
+	app.Metrics.ObserveFallbackLatency(fallbackAPIServer.Nickname, time.Since(start))
 
 	response := CachedResponse{
 		StatusCode: res.StatusCode,
@@ -101,17 +126,17 @@ func IsErrorUniqueFailedField(err error, field string) bool {
 }
 
 func GetSkinPath(app *App, hash string) string {
-	dir := path.Join(app.Config.StateDirectory, "skin")
+	dir := path.Join(app.Config().StateDirectory, "skin")
 	return path.Join(dir, fmt.Sprintf("%s.png", hash))
 }
 
 func GetCapePath(app *App, hash string) string {
-	dir := path.Join(app.Config.StateDirectory, "cape")
+	dir := path.Join(app.Config().StateDirectory, "cape")
 	return path.Join(dir, fmt.Sprintf("%s.png", hash))
 }
 
 func IsDefaultAdmin(app *App, user *User) bool {
-	return Contains(app.Config.DefaultAdmins, user.Username)
+	return Contains(app.Config().DefaultAdmins, user.Username)
 }
 
 type Profile struct {
@@ -147,35 +172,160 @@ func IsYggdrasilPath(path_ string) bool {
 		return false
 	}
 
+	// Drasl's own JSON API under /drasl/api/ is treated like the Yggdrasil
+	// API so that errors come back as JSON rather than web pages
 	split := strings.Split(path_, "/")
 	if len(split) >= 2 && split[1] == "drasl" {
-		return false
+		return len(split) >= 3 && split[2] == "api"
 	}
 
 	return true
 }
 
+// Textures are decoded in full to be re-encoded, so this bounds the memory a
+// single upload can use even if SkinSizeLimit is 0. 4096 × 4096 pixels take
+// 64 MiB as NRGBA.
+const MAX_TEXTURE_PIXELS = 4096 * 4096
+
+// The most compressed image data read from an upload
+const MAX_TEXTURE_FILE_SIZE = 10e6
+
+var errTextureFormat = errors.New("texture must be a PNG, JPEG, or WebP image")
+var errTextureAnimated = errors.New("texture must not be animated")
+
+func checkTextureSize(app *App, config image.Config) error {
+	if app.Config().SkinSizeLimit > 0 && config.Width > app.Config().SkinSizeLimit {
+		return fmt.Errorf("texture must not be greater than %d pixels wide", app.Config().SkinSizeLimit)
+	}
+	if config.Width*config.Height > MAX_TEXTURE_PIXELS {
+		return errors.New("texture is too large")
+	}
+	return nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Whether a PNG is an APNG, i.e. has an acTL chunk before its image data
+func isAnimatedPNG(data []byte) bool {
+	offset := len(pngSignature)
+	for offset+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		chunkType := string(data[offset+4 : offset+8])
+		switch chunkType {
+		case "acTL":
+			return true
+		case "IDAT", "IEND":
+			return false
+		}
+		if length > len(data) {
+			return false
+		}
+		// Length, type, data, and CRC
+		offset += 12 + length
+	}
+	return false
+}
+
+// Whether a WebP has the animation flag set in its VP8X chunk, which, if
+// present, comes right after the RIFF header
+func isAnimatedWebP(data []byte) bool {
+	const animationBit = 1 << 1
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&animationBit != 0
+}
+
+// Read an uploaded texture and the dimensions from its header. Skins and capes
+// may be uploaded as PNG, JPEG, or WebP, but not animated, and are always
+// stored as PNG.
+func readTextureConfig(reader io.Reader) ([]byte, image.Config, error) {
+	data, err := io.ReadAll(io.LimitReader(reader, MAX_TEXTURE_FILE_SIZE))
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+
+	var config image.Config
+	switch {
+	case bytes.HasPrefix(data, pngSignature):
+		if isAnimatedPNG(data) {
+			return nil, image.Config{}, errTextureAnimated
+		}
+		config, err = png.DecodeConfig(bytes.NewReader(data))
+	case bytes.HasPrefix(data, []byte("\xff\xd8")):
+		config, err = jpeg.DecodeConfig(bytes.NewReader(data))
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		if isAnimatedWebP(data) {
+			return nil, image.Config{}, errTextureAnimated
+		}
+		config, err = webp.DecodeConfig(bytes.NewReader(data))
+	default:
+		return nil, image.Config{}, errTextureFormat
+	}
+	if err != nil {
+		return nil, image.Config{}, err
+	}
+	return data, config, nil
+}
+
+// Decode a texture read by readTextureConfig, as NRGBA. Fully transparent
+// pixels are made transparent black, since their color can't be seen but may
+// still carry data.
+func decodeTexture(data []byte) (*image.NRGBA, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := decoded.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(img, img.Bounds(), decoded, bounds.Min, draw.Src)
+	for i := 0; i < len(img.Pix); i += 4 {
+		if img.Pix[i+3] == 0 {
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2] = 0, 0, 0
+		}
+	}
+	return img, nil
+}
+
+// Encode a texture as a plain PNG, without any ancillary chunks
+func encodeTexture(img *image.NRGBA) (io.Reader, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// Check an uploaded skin and re-encode it as PNG, so that only the image
+// itself is stored and served
 func ValidateSkin(app *App, reader io.Reader) (io.Reader, error) {
-	var header bytes.Buffer
-	config, err := png.DecodeConfig(io.TeeReader(reader, &header))
+	data, config, err := readTextureConfig(reader)
 	if err != nil {
 		return nil, err
 	}
 
-	if config.Width != config.Height {
+	isLegacy := app.Config().ConvertLegacySkins && config.Width == 2*config.Height
+	if config.Width != config.Height && !isLegacy {
 		return nil, errors.New("texture must be square")
 	}
+	if isLegacy && config.Width%64 != 0 {
+		return nil, errors.New("legacy skin's width must be a multiple of 64")
+	}
 
-	if app.Config.SkinSizeLimit > 0 && config.Width > app.Config.SkinSizeLimit {
-		return nil, fmt.Errorf("texture must not be greater than %d pixels wide", app.Config.SkinSizeLimit)
+	if err := checkTextureSize(app, config); err != nil {
+		return nil, err
 	}
 
-	return io.MultiReader(&header, reader), nil
+	img, err := decodeTexture(data)
+	if err != nil {
+		return nil, err
+	}
+	if isLegacy {
+		img = ConvertLegacySkin(img)
+	}
+	return encodeTexture(img)
 }
 
+// Check an uploaded cape and re-encode it, like ValidateSkin
 func ValidateCape(app *App, reader io.Reader) (io.Reader, error) {
-	var header bytes.Buffer
-	config, err := png.DecodeConfig(io.TeeReader(reader, &header))
+	data, config, err := readTextureConfig(reader)
 	if err != nil {
 		return nil, err
 	}
@@ -184,15 +334,19 @@ func ValidateCape(app *App, reader io.Reader) (io.Reader, error) {
 		return nil, errors.New("cape's width must be twice its height")
 	}
 
-	if app.Config.SkinSizeLimit > 0 && config.Width > app.Config.SkinSizeLimit {
-		return nil, fmt.Errorf("texture must not be greater than %d pixels wide", app.Config.SkinSizeLimit)
+	if err := checkTextureSize(app, config); err != nil {
+		return nil, err
 	}
 
-	return io.MultiReader(&header, reader), nil
+	img, err := decodeTexture(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTexture(img)
 }
 
 func ReadTexture(app *App, reader io.Reader) (*bytes.Buffer, string, error) {
-	limitedReader := io.LimitReader(reader, 10e6)
+	limitedReader := io.LimitReader(reader, MAX_TEXTURE_FILE_SIZE)
 
 	// It's fine to read the whole skin into memory here; they will almost
 	// always be <1MiB, and it's nice to know the filename before writing it to
@@ -208,28 +362,23 @@ func ReadTexture(app *App, reader io.Reader) (*bytes.Buffer, string, error) {
 	return buf, hash, nil
 }
 
-func WriteSkin(app *App, hash string, buf *bytes.Buffer) error {
-	// DB state -> FS state
-	skinPath := GetSkinPath(app, hash)
-
-	// Make sure we are the only one writing to `skinPath`
-	unlock := app.FSMutex.Lock(skinPath)
-	defer unlock()
-
-	_, err := os.Stat(skinPath)
+// Write a texture to `texturePath` unless it's already there. The caller
+// must hold the FSMutex lock for `texturePath`.
+func writeTextureLocked(texturePath string, buf *bytes.Buffer) error {
+	_, err := os.Stat(texturePath)
 	if err == nil {
-		// We're good, skin already exists
+		// We're good, texture already exists
 		return nil
 	}
 	if !os.IsNotExist(err) {
 		return err
 	}
-	err = os.MkdirAll(path.Dir(skinPath), os.ModePerm)
+	err = os.MkdirAll(path.Dir(texturePath), os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	dest, err := os.Create(skinPath)
+	dest, err := os.Create(texturePath)
 	if err != nil {
 		return err
 	}
@@ -243,39 +392,29 @@ func WriteSkin(app *App, hash string, buf *bytes.Buffer) error {
 	return nil
 }
 
-func WriteCape(app *App, hash string, buf *bytes.Buffer) error {
+func WriteSkin(app *App, hash string, buf *bytes.Buffer) error {
 	// DB state -> FS state
-	capePath := GetCapePath(app, hash)
+	skinPath := GetSkinPath(app, hash)
 
-	// Make sure we are the only one writing to `capePath`
-	unlock := app.FSMutex.Lock(capePath)
+	// Make sure we are the only one writing to `skinPath`
+	unlock := app.FSMutex.Lock(skinPath)
 	defer unlock()
 
-	_, err := os.Stat(capePath)
-	if err == nil {
-		// We're good, cape already exists
-		return nil
-	}
-	if !os.IsNotExist(err) {
-		return err
-	}
-	err = os.MkdirAll(path.Dir(capePath), os.ModePerm)
-	if err != nil {
+	if err := SaveSkinFingerprint(app.DB, hash, buf.Bytes()); err != nil {
 		return err
 	}
+	return writeTextureLocked(skinPath, buf)
+}
 
-	dest, err := os.Create(capePath)
-	if err != nil {
-		return err
-	}
-	defer dest.Close()
+func WriteCape(app *App, hash string, buf *bytes.Buffer) error {
+	// DB state -> FS state
+	capePath := GetCapePath(app, hash)
 
-	_, err = buf.WriteTo(dest)
-	if err != nil {
-		return err
-	}
+	// Make sure we are the only one writing to `capePath`
+	unlock := app.FSMutex.Lock(capePath)
+	defer unlock()
 
-	return nil
+	return writeTextureLocked(capePath, buf)
 }
 
 func SetSkinAndSave(app *App, user *User, reader io.Reader) error {
@@ -315,7 +454,7 @@ func SetSkinAndSave(app *App, user *User, reader io.Reader) error {
 		return err
 	}
 
-	return nil
+	return app.RecordAppearance(user.UUID)
 }
 
 func SetCapeAndSave(app *App, user *User, reader io.Reader) error {
@@ -355,90 +494,149 @@ func SetCapeAndSave(app *App, user *User, reader io.Reader) error {
 		return err
 	}
 
-	return nil
+	return app.RecordAppearance(user.UUID)
 }
 
 // Delete skin if not in use
 func DeleteSkinIfUnused(app *App, hash *string) error {
+	return deleteUnusedTexture(app, &SKIN_TEXTURE_KIND, hash)
+}
+
+// Delete cape if not in use
+func DeleteCapeIfUnused(app *App, hash *string) error {
+	return deleteUnusedTexture(app, &CAPE_TEXTURE_KIND, hash)
+}
+
+// Textures are kept as long as anything refers to them, including skins in
+// users' libraries, appearance snapshots, gift codes, and cosmetics
+func deleteUnusedTexture(app *App, kind *textureKind, hash *string) error {
 	if hash == nil {
 		return nil
 	}
 
-	path := GetSkinPath(app, *hash)
+	path := kind.Path(app, *hash)
 	unlock := app.FSMutex.Lock(path)
 	defer unlock()
 
-	var inUse bool
-
-	err := app.DB.Model(User{}).
-		Select("count(*) > 0").
-		Where("skin_hash = ?", *hash).
-		Find(&inUse).
-		Error
+	references, err := app.CountTextureReferences(kind, *hash)
 	if err != nil {
 		return err
 	}
 
-	if !inUse {
+	if references == 0 {
 		err := os.Remove(path)
 		if err != nil {
 			return err
 		}
+		err = app.DB.Where("kind = ? AND hash = ?", kind.Name, *hash).Delete(&TextureSize{}).Error
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Delete cape if not in use
-func DeleteCapeIfUnused(app *App, hash *string) error {
-	if hash == nil {
-		return nil
+// Delete the user and everything that belongs to them in a single
+// transaction. Their skins and capes, including those in their library and
+// appearance history, are deleted only once the transaction has committed,
+// and only if nothing else still uses them.
+func DeleteUser(app *App, user *User) error {
+	// Forget any skin or cape still queued for the user, so it isn't set
+	// once they're gone
+	if app.TextureQueue != nil {
+		app.TextureQueue.Drop(user.UUID)
 	}
 
-	path := GetCapePath(app, *hash)
-	unlock := app.FSMutex.Lock(path)
-	defer unlock()
-
-	var inUse bool
-
-	err := app.DB.Model(User{}).
-		Select("count(*) > 0").
-		Where("cape_hash = ?", *hash).
-		Find(&inUse).
-		Error
-	if err != nil {
-		return err
+	var skinHashes []string
+	var capeHashes []string
+	addHash := func(hashes *[]string, hash *string) {
+		if hash != nil && !Contains(*hashes, *hash) {
+			*hashes = append(*hashes, *hash)
+		}
 	}
 
-	if !inUse {
-		err := os.Remove(path)
-		if err != nil {
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		// The user may have changed their skin or cape since they were
+		// loaded, so read their textures again inside the transaction
+		var current User
+		if err := tx.First(&current, "uuid = ?", user.UUID).Error; err != nil {
 			return err
 		}
-	}
+		addHash(&skinHashes, UnmakeNullString(&current.SkinHash))
+		addHash(&capeHashes, UnmakeNullString(&current.CapeHash))
 
-	return nil
-}
+		var librarySkins []LibrarySkin
+		if err := tx.Where("user_uuid = ?", user.UUID).Find(&librarySkins).Error; err != nil {
+			return err
+		}
+		for _, librarySkin := range librarySkins {
+			addHash(&skinHashes, &librarySkin.SkinHash)
+		}
+		var snapshots []AppearanceSnapshot
+		if err := tx.Where("user_uuid = ?", user.UUID).Find(&snapshots).Error; err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			addHash(&skinHashes, UnmakeNullString(&snapshot.SkinHash))
+			addHash(&capeHashes, UnmakeNullString(&snapshot.CapeHash))
+		}
 
-func DeleteUser(app *App, user *User) error {
-	oldSkinHash := UnmakeNullString(&user.SkinHash)
-	oldCapeHash := UnmakeNullString(&user.CapeHash)
-	err := app.DB.Delete(&user).Error
+		apiTokenIDs := tx.Model(&APIToken{}).Select("id").Where("user_uuid = ?", user.UUID)
+		if err := tx.Where("api_token_id IN (?)", apiTokenIDs).Delete(&APITokenUsage{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&Client{},
+			&DeviceAuthorization{},
+			&GroupMembership{},
+			&QRLogin{},
+			&GiftCodeRedemption{},
+			&BedrockLink{},
+			&BedrockLinkCode{},
+			&LinkedAccount{},
+			&ProfileImport{},
+			&UserNote{},
+			&SessionRecord{},
+			&APIToken{},
+			&UserProfileProperty{},
+			&Entitlement{},
+			&LibrarySkin{},
+			&AppearanceSnapshot{},
+			&PendingInGameCommand{},
+		} {
+			if err := tx.Where("user_uuid = ?", user.UUID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("target_uuid = ?", user.UUID).Delete(&Report{}).Error; err != nil {
+			return err
+		}
+		// Reports the user filed still matter for moderating their targets,
+		// so keep them but forget who sent them
+		if err := tx.Model(&Report{}).Where("reporter_uuid = ?", user.UUID).Update("reporter_uuid", "").Error; err != nil {
+			return err
+		}
+		return tx.Delete(&User{}, "uuid = ?", user.UUID).Error
+	})
 	if err != nil {
 		return err
 	}
 
-	err = DeleteSkinIfUnused(app, oldSkinHash)
-	if err != nil {
-		return err
+	// Try every texture even if one can't be deleted, so that one failure
+	// doesn't leave the rest behind
+	var firstErr error
+	for _, hash := range skinHashes {
+		if err := DeleteSkinIfUnused(app, &hash); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
 	}
-
-	err = DeleteCapeIfUnused(app, oldCapeHash)
-	if err != nil {
-		return err
+	for _, hash := range capeHashes {
+		if err := DeleteCapeIfUnused(app, &hash); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
 	}
-
-	return nil
+	return firstErr
 }
 
 func StripQueryParam(urlString string, param string) (string, error) {
@@ -455,6 +653,53 @@ func StripQueryParam(urlString string, param string) (string, error) {
 	return parsedURL.String(), nil
 }
 
+// Find a user by username or, failing that, by player name, and then by
+// verified email. A username takes precedence over another user's identical
+// player name.
+func FindUserByUsernameOrPlayerName(db *gorm.DB, user *User, identifier string) error {
+	// Start a new session so the conditions of the first query don't carry
+	// over into the second
+	db = db.Session(&gorm.Session{})
+	normalized := NormalizeName(identifier)
+	err := db.First(user, "normalized_username = ?", normalized).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = db.First(user, "normalized_player_name = ?", normalized).Error
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) && strings.Contains(identifier, "@") {
+		return findUserByVerifiedEmail(db, user, identifier)
+	}
+	return err
+}
+
+// Emails aren't unique, so an address shared by several users identifies
+// none of them. Encrypted emails can't be queried by value, so with
+// DataEncryption every verified address is decrypted and compared instead.
+func findUserByVerifiedEmail(db *gorm.DB, user *User, email string) error {
+	plain := db.Session(&gorm.Session{NewDB: true})
+	var matches []User
+	if getDataCipher(db) == nil {
+		err := plain.Select("uuid").Limit(2).Find(&matches, "email = ? AND email_verified = ?", email, true).Error
+		if err != nil {
+			return err
+		}
+	} else {
+		var verified []User
+		err := plain.Select("uuid", "email").Find(&verified, "email_verified = ?", true).Error
+		if err != nil {
+			return err
+		}
+		for _, candidate := range verified {
+			if candidate.Email.String == email {
+				matches = append(matches, candidate)
+			}
+		}
+	}
+	if len(matches) != 1 {
+		return gorm.ErrRecordNotFound
+	}
+	return db.First(user, "uuid = ?", matches[0].UUID).Error
+}
+
 func (app *App) InvalidateUser(user *User) error {
 	result := app.DB.Model(Client{}).Where("user_uuid = ?", user.UUID).Update("version", gorm.Expr("version + ?", 1))
 	return result.Error
@@ -462,6 +707,7 @@ func (app *App) InvalidateUser(user *User) error {
 
 func (app *App) SetIsLocked(db *gorm.DB, user *User, isLocked bool) error {
 	user.IsLocked = isLocked
+	user.LockedUntil = sql.NullTime{}
 	if isLocked {
 		user.BrowserToken = MakeNullString(nil)
 		err := app.InvalidateUser(user)
@@ -472,6 +718,67 @@ func (app *App) SetIsLocked(db *gorm.DB, user *User, isLocked bool) error {
 	return nil
 }
 
+type pendingApprovalWebhookPayload struct {
+	Event      string `json:"event"`
+	UUID       string `json:"uuid"`
+	Username   string `json:"username"`
+	PlayerName string `json:"playerName"`
+	AdminURL   string `json:"adminUrl"`
+}
+
+// Let admins know that a new user is waiting for approval, via
+// RegistrationApprovalWebhook and by email to admins with a verified address.
+// Failures are logged rather than returned since the registration itself has
+// already succeeded.
+func (app *App) NotifyPendingApproval(user *User) {
+	adminURL := app.FrontEndURL + "/drasl/admin"
+
+	if app.Config().RegistrationApprovalWebhook != "" {
+		payload := pendingApprovalWebhookPayload{
+			Event:      "registration-pending-approval",
+			UUID:       user.UUID,
+			Username:   user.Username,
+			PlayerName: user.PlayerName,
+			AdminURL:   adminURL,
+		}
+		body, err := json.Marshal(payload)
+		if err == nil {
+			var res *http.Response
+			res, err = app.MakeHTTPClient().Post(app.Config().RegistrationApprovalWebhook, "application/json", bytes.NewReader(body))
+			if err == nil {
+				res.Body.Close()
+				if res.StatusCode < 200 || res.StatusCode >= 300 {
+					err = fmt.Errorf("webhook responded with status %d", res.StatusCode)
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("Couldn't send approval webhook for %s: %s\n", user.Username, err)
+		}
+	}
+
+	if app.Mailer != nil {
+		var admins []User
+		if err := app.DB.Find(&admins, "is_admin AND email IS NOT NULL AND email_verified").Error; err != nil {
+			log.Printf("Couldn't look up admins to notify about %s: %s\n", user.Username, err)
+			return
+		}
+		for _, admin := range admins {
+			err := app.Mailer.Send(&EmailMessage{
+				To:      admin.Email.String,
+				Subject: fmt.Sprintf("%s is waiting for approval on %s", user.Username, app.Config().InstanceName),
+				Body: fmt.Sprintf(
+					"A new user, %s, registered on %s and is waiting for approval. Approve or reject them on the admin page:\n\n%s\n",
+					user.Username, app.Config().InstanceName, adminURL,
+				),
+			})
+			if err != nil {
+				log.Printf("Couldn't email %s about %s: %s\n", admin.Username, user.Username, err)
+			}
+		}
+	}
+}
+
 func (app *App) CreateInvite() (Invite, error) {
 	code, err := RandomBase62(8)
 	if err != nil {
@@ -509,6 +816,27 @@ type texturesValue struct {
 	Textures    textureMap `json:"textures"`
 }
 
+// The textures value as Mojang served it in the 1.7 and 1.8 era, which some
+// old clients and skin mods still parse by hand
+type legacyTexturesValue struct {
+	Timestamp   int64      `json:"timestamp"`
+	ProfileID   string     `json:"profileId"`
+	ProfileName string     `json:"profileName"`
+	IsPublic    bool       `json:"isPublic"`
+	Textures    textureMap `json:"textures"`
+}
+
+// Compatibility profiles for the textures property. The modern profile only
+// signs the property when asked to and timestamps it in nanoseconds. The
+// legacy profile always signs it, timestamps it in milliseconds, and includes
+// the `isPublic` field old clients expect before the textures.
+const (
+	TEXTURES_PROFILE_MODERN = "modern"
+	TEXTURES_PROFILE_LEGACY = "legacy"
+)
+
+var TEXTURES_PROFILES = []string{TEXTURES_PROFILE_MODERN, TEXTURES_PROFILE_LEGACY}
+
 type SessionProfileProperty struct {
 	Name      string  `json:"name"`
 	Value     string  `json:"value"`
@@ -552,7 +880,7 @@ func GetFallbackSkinTexturesProperty(app *App, user *User) (*SessionProfilePrope
 		fallbackPlayer = user.FallbackPlayer
 	}
 
-	for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+	for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
 		var id string
 		if fallbackPlayerIsUUID {
 			// If we have the UUID already, use it
@@ -565,7 +893,7 @@ func GetFallbackSkinTexturesProperty(app *App, user *User) (*SessionProfilePrope
 				log.Println(err)
 				continue
 			}
-			res, err := app.CachedGet(reqURL, fallbackAPIServer.CacheTTLSeconds)
+			res, err := app.CachedGet(context.Background(), &fallbackAPIServer, FallbackCacheUUID, reqURL)
 			if err != nil {
 				log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
 				continue
@@ -590,7 +918,7 @@ func GetFallbackSkinTexturesProperty(app *App, user *User) (*SessionProfilePrope
 			continue
 		}
 
-		res, err := app.CachedGet(reqURL+"?unsigned=false", fallbackAPIServer.CacheTTLSeconds)
+		res, err := app.CachedGet(context.Background(), &fallbackAPIServer, FallbackCacheProfile, reqURL+"?unsigned=false")
 		if err != nil {
 			log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
 			continue
@@ -618,7 +946,16 @@ func GetFallbackSkinTexturesProperty(app *App, user *User) (*SessionProfilePrope
 		if texturesProperty == nil {
 			continue
 		}
-		return texturesProperty, nil
+		proxiedProperty := app.ProxyFallbackTextures(&fallbackAPIServer, *texturesProperty)
+		allowed, err := app.texturesPropertyAllowed(&proxiedProperty)
+		if err != nil {
+			log.Printf("Received invalid textures property from fallback API server at %s\n", reqURL)
+			continue
+		}
+		if !allowed {
+			continue
+		}
+		return &proxiedProperty, nil
 	}
 
 	return nil, nil
@@ -652,7 +989,7 @@ func ChooseFileForUser(app *App, user *User, glob string) (*string, error) {
 var slimSkinRegex = regexp.MustCompile(".*slim\\.png$")
 
 func GetDefaultSkinTexture(app *App, user *User) *texture {
-	defaultSkinDirectory := path.Join(app.Config.StateDirectory, "default-skin")
+	defaultSkinDirectory := path.Join(app.Config().StateDirectory, "default-skin")
 	defaultSkinGlob := path.Join(defaultSkinDirectory, "*.png")
 
 	defaultSkinPath, err := ChooseFileForUser(app, user, defaultSkinGlob)
@@ -670,7 +1007,7 @@ func GetDefaultSkinTexture(app *App, user *User) *texture {
 		return nil
 	}
 
-	defaultSkinURL, err := url.JoinPath(app.FrontEndURL, "drasl/texture/default-skin/"+filename)
+	defaultSkinURL, err := url.JoinPath(app.TextureURL, "drasl/texture/default-skin/"+filename)
 	if err != nil {
 		log.Printf("Error generating default skin URL for file %s\n", *defaultSkinPath)
 		return nil
@@ -690,7 +1027,7 @@ func GetDefaultSkinTexture(app *App, user *User) *texture {
 }
 
 func GetDefaultCapeTexture(app *App, user *User) *texture {
-	defaultCapeDirectory := path.Join(app.Config.StateDirectory, "default-cape")
+	defaultCapeDirectory := path.Join(app.Config().StateDirectory, "default-cape")
 	defaultCapeGlob := path.Join(defaultCapeDirectory, "*.png")
 
 	defaultCapePath, err := ChooseFileForUser(app, user, defaultCapeGlob)
@@ -708,7 +1045,7 @@ func GetDefaultCapeTexture(app *App, user *User) *texture {
 		return nil
 	}
 
-	defaultCapeURL, err := url.JoinPath(app.FrontEndURL, "drasl/texture/default-cape/"+filename)
+	defaultCapeURL, err := url.JoinPath(app.TextureURL, "drasl/texture/default-cape/"+filename)
 	if err != nil {
 		log.Printf("Error generating default cape URL for file %s\n", *defaultCapePath)
 		return nil
@@ -719,32 +1056,15 @@ func GetDefaultCapeTexture(app *App, user *User) *texture {
 	}
 }
 
-func GetSkinTexturesProperty(app *App, user *User, sign bool) (SessionProfileProperty, error) {
-	id, err := UUIDToID(user.UUID)
-	if err != nil {
-		return SessionProfileProperty{}, err
-	}
-	if !user.SkinHash.Valid && !user.CapeHash.Valid && app.Config.ForwardSkins {
-		// If the user has neither a skin nor a cape, try getting a skin from
-		// Fallback API servers
-		fallbackProperty, err := GetFallbackSkinTexturesProperty(app, user)
-		if err != nil {
-			return SessionProfileProperty{}, nil
-		}
-		if fallbackProperty != nil {
-			if !sign {
-				fallbackProperty.Signature = nil
-			}
-			return *fallbackProperty, nil
-		}
-	}
-
+// The user's skin and cape, or their defaults. Nil if a texture URL can't be
+// generated.
+func GetTextureMap(app *App, user *User) *textureMap {
 	var skinTexture *texture
 	if user.SkinHash.Valid {
 		skinURL, err := SkinURL(app, user.SkinHash.String)
 		if err != nil {
 			log.Printf("Error generating skin URL for user %s: %s\n", user.Username, err)
-			return SessionProfileProperty{}, nil
+			return nil
 		}
 		skinTexture = &texture{
 			URL: skinURL,
@@ -761,7 +1081,7 @@ func GetSkinTexturesProperty(app *App, user *User, sign bool) (SessionProfilePro
 		capeURL, err := CapeURL(app, user.CapeHash.String)
 		if err != nil {
 			log.Printf("Error generating cape URL for user %s: %s\n", user.Username, err)
-			return SessionProfileProperty{}, nil
+			return nil
 		}
 		capeTexture = &texture{
 			URL: capeURL,
@@ -770,16 +1090,56 @@ func GetSkinTexturesProperty(app *App, user *User, sign bool) (SessionProfilePro
 		capeTexture = GetDefaultCapeTexture(app, user)
 	}
 
-	texturesValue := texturesValue{
-		Timestamp:   time.Now().UnixNano(),
-		ProfileID:   id,
-		ProfileName: user.PlayerName,
-		Textures: textureMap{
-			Skin: skinTexture,
-			Cape: capeTexture,
-		},
+	return &textureMap{
+		Skin: skinTexture,
+		Cape: capeTexture,
+	}
+}
+
+func GetSkinTexturesProperty(app *App, user *User, sign bool, texturesProfile string) (SessionProfileProperty, error) {
+	id, err := UUIDToID(user.UUID)
+	if err != nil {
+		return SessionProfileProperty{}, err
+	}
+	if texturesProfile == TEXTURES_PROFILE_LEGACY {
+		sign = true
+	}
+	if !user.SkinHash.Valid && !user.CapeHash.Valid && app.Config().ForwardSkins {
+		// If the user has neither a skin nor a cape, try getting a skin from
+		// Fallback API servers
+		fallbackProperty, err := GetFallbackSkinTexturesProperty(app, user)
+		if err != nil {
+			return SessionProfileProperty{}, nil
+		}
+		if fallbackProperty != nil {
+			if !sign {
+				fallbackProperty.Signature = nil
+			}
+			return *fallbackProperty, nil
+		}
+	}
+
+	textures := GetTextureMap(app, user)
+	if textures == nil {
+		return SessionProfileProperty{}, nil
+	}
+	var texturesValueBlob []byte
+	if texturesProfile == TEXTURES_PROFILE_LEGACY {
+		texturesValueBlob, err = json.Marshal(legacyTexturesValue{
+			Timestamp:   time.Now().UnixMilli(),
+			ProfileID:   id,
+			ProfileName: user.PlayerName,
+			IsPublic:    true,
+			Textures:    *textures,
+		})
+	} else {
+		texturesValueBlob, err = json.Marshal(texturesValue{
+			Timestamp:   time.Now().UnixNano(),
+			ProfileID:   id,
+			ProfileName: user.PlayerName,
+			Textures:    *textures,
+		})
 	}
-	texturesValueBlob, err := json.Marshal(texturesValue)
 	if err != nil {
 		return SessionProfileProperty{}, err
 	}
@@ -803,6 +1163,212 @@ func GetSkinTexturesProperty(app *App, user *User, sign bool) (SessionProfilePro
 	}, nil
 }
 
-func MakeHTTPClient() *http.Client {
-	return &http.Client{Timeout: 30 * time.Second}
+// The transport for requests Drasl makes to other servers. If
+// OutboundProxy.URL is set, requests go through the proxy, except to hosts
+// in OutboundProxy.NoProxy; otherwise, the HTTP_PROXY, HTTPS_PROXY, and
+// NO_PROXY environment variables are respected. Requests to fallback API
+// servers use their TLS and DNS settings; see fallback_transport.go.
+func MakeHTTPTransport(config *Config) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.OutboundProxy.URL != "" {
+		proxyFunc := (&httpproxy.Config{
+			HTTPProxy:  config.OutboundProxy.URL,
+			HTTPSProxy: config.OutboundProxy.URL,
+			NoProxy:    strings.Join(config.OutboundProxy.NoProxy, ","),
+		}).ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+	return &tracingTransport{base: &fallbackAPIServerTransport{
+		config:     config,
+		base:       transport,
+		transports: map[string]*http.Transport{},
+	}}
+}
+
+func makeHTTPClient(transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+}
+
+func (app *App) MakeHTTPClient() *http.Client {
+	return makeHTTPClient(app.HTTPTransport)
+}
+
+func (app *App) GetAnnouncement() (*Announcement, error) {
+	var announcement Announcement
+	result := app.DB.Limit(1).Find(&announcement, 1)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 || announcement.Markdown == "" {
+		return nil, nil
+	}
+	return &announcement, nil
+}
+
+// Record an action taken by `actor` in the audit log. `target` may be nil.
+// A nil actor is Drasl itself, e.g. for actions taken by background jobs
+func (app *App) LogAudit(actor *User, action string, target *User, details string) error {
+	entry := AuditLogEntry{
+		ActorUsername: AUDIT_SYSTEM_ACTOR,
+		Action:        action,
+		TargetUUID:    MakeNullString(nil),
+		Details:       details,
+	}
+	if actor != nil {
+		entry.ActorUUID = actor.UUID
+		entry.ActorUsername = actor.Username
+	}
+	if target != nil {
+		entry.TargetUUID = MakeNullString(&target.UUID)
+		entry.TargetUsername = target.Username
+	}
+	log.Printf("Audit: %s %s %s %s", entry.ActorUsername, entry.Action, entry.TargetUsername, entry.Details)
+	return app.DB.Create(&entry).Error
+}
+
+func (app *App) GetAuditLog(limit int) ([]AuditLogEntry, error) {
+	var entries []AuditLogEntry
+	err := app.DB.Order("id desc").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+func (app *App) GetGroupMembers(group *Group) ([]User, error) {
+	var users []User
+	err := app.DB.
+		Joins("JOIN group_memberships ON group_memberships.user_uuid = users.uuid").
+		Where("group_memberships.group_id = ?", group.ID).
+		Order("users.username").
+		Find(&users).Error
+	return users, err
+}
+
+func (app *App) DeleteGroup(group *Group) error {
+	cosmetics, err := app.getGroupCosmetics(group)
+	if err != nil {
+		return err
+	}
+	members, err := app.GetGroupMembers(group)
+	if err != nil {
+		return err
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.ID).Delete(&GroupMembership{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&GroupEntitlement{}).Error; err != nil {
+			return err
+		}
+		memberUUIDs := make([]string, 0, len(members))
+		for _, member := range members {
+			memberUUIDs = append(memberUUIDs, member.UUID)
+		}
+		if err := TouchUsers(tx, memberUUIDs...); err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil {
+		return err
+	}
+	return app.takeOffGroupCosmetics(cosmetics, members)
+}
+
+// Lock or unlock every member of a group. Admins are never locked this way,
+// so a group can't lock out every admin. Returns the number of users
+// changed.
+func (app *App) SetGroupIsLocked(group *Group, isLocked bool) (int, error) {
+	members, err := app.GetGroupMembers(group)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		for _, member := range PtrSlice(members) {
+			if member.IsLocked == isLocked || (isLocked && member.IsAdmin) {
+				continue
+			}
+			if err := app.SetIsLocked(tx, member, isLocked); err != nil {
+				return err
+			}
+			if err := tx.Save(member).Error; err != nil {
+				return err
+			}
+			count += 1
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Set the cape of every member of a group. A nil reader removes their capes.
+func (app *App) SetGroupCape(group *Group, reader io.Reader) error {
+	members, err := app.GetGroupMembers(group)
+	if err != nil {
+		return err
+	}
+
+	var buf *bytes.Buffer
+	var hash *string
+	if reader != nil {
+		validCapeHandle, err := ValidateCape(app, reader)
+		if err != nil {
+			return err
+		}
+		var capeHash string
+		buf, capeHash, err = ReadTexture(app, validCapeHandle)
+		if err != nil {
+			return err
+		}
+		hash = &capeHash
+	}
+
+	oldCapeHashes := make([]*string, 0, len(members))
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		for _, member := range PtrSlice(members) {
+			oldCapeHashes = append(oldCapeHashes, UnmakeNullString(&member.CapeHash))
+			member.CapeHash = MakeNullString(hash)
+			if err := tx.Save(member).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if buf != nil {
+		if err := WriteCape(app, *hash, buf); err != nil {
+			return err
+		}
+	}
+	for _, member := range members {
+		if err := app.RecordAppearance(member.UUID); err != nil {
+			return err
+		}
+	}
+	for _, oldCapeHash := range oldCapeHashes {
+		if !PtrEquals(oldCapeHash, hash) {
+			if err := DeleteCapeIfUnused(app, oldCapeHash); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) SetAnnouncement(markdown string) error {
+	if strings.TrimSpace(markdown) == "" {
+		return app.DB.Delete(&Announcement{}, 1).Error
+	}
+	return app.DB.Save(&Announcement{
+		ID:        1,
+		Markdown:  markdown,
+		UpdatedAt: time.Now(),
+	}).Error
 }
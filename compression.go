@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+Compression of responses. Web pages, JSON, and other text responses are
+compressed with gzip or deflate, whichever the client prefers to accept,
+once they reach Compression.MinSizeBytes. Whether a response is compressed is
+decided by its Content-Type, so skins, capes, and other PNGs, which are
+already compressed, are sent as they are.
+*/
+
+// Content types worth compressing
+func isCompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" ||
+		mediaType == "application/javascript" ||
+		mediaType == "application/xml" ||
+		mediaType == "image/svg+xml"
+}
+
+// The encoding to compress a response with, "gzip" or "deflate", or "" if
+// the client accepts neither
+func negotiateEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		ok := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if q, err := strconv.ParseFloat(value, 64); name == "q" && err == nil && q == 0 {
+				ok = false
+			}
+		}
+		accepted[coding] = ok
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if ok, present := accepted[encoding]; (present && ok) || (!present && accepted["*"]) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// Buffers the start of the response until it's clear whether it's worth
+// compressing
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+	code     int
+	buffer   bytes.Buffer
+	decided  bool
+	writer   io.WriteCloser
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// Send the headers, compressed or not, and whatever has been buffered
+func (w *compressResponseWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	compressible := header.Get("Content-Encoding") == "" && isCompressibleContentType(header.Get("Content-Type"))
+	if compressible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if compress && compressible {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == "gzip" {
+			w.writer, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+		} else {
+			w.writer, _ = zlib.NewWriterLevel(w.ResponseWriter, w.level)
+		}
+	}
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.writer != nil {
+		_, err = w.writer.Write(w.buffer.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buffer.Bytes())
+	}
+	w.buffer.Reset()
+	return err
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.writer != nil {
+			return w.writer.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buffer.Write(b)
+	if w.buffer.Len() >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flushing sends whatever has been buffered; a response that's flushed before
+// it reaches MinSizeBytes isn't compressed
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if flusher, ok := w.writer.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("response doesn't support hijacking")
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressResponseWriter) Close() error {
+	if !w.decided && (w.code != 0 || w.buffer.Len() > 0) {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+func makeCompressionMiddleware(app *App) echo.MiddlewareFunc {
+	level := app.Config().Compression.Level
+	minSize := app.Config().Compression.MinSizeBytes
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method == http.MethodHead {
+				return next(c)
+			}
+			encoding := negotiateEncoding(c.Request().Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				return next(c)
+			}
+
+			res := c.Response()
+			original := res.Writer
+			writer := &compressResponseWriter{
+				ResponseWriter: original,
+				encoding:       encoding,
+				level:          level,
+				minSize:        minSize,
+			}
+			res.Writer = writer
+			defer func() {
+				writer.Close()
+				res.Writer = original
+			}()
+			return next(c)
+		}
+	}
+}
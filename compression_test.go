@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Compression.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test negotiating an encoding", ts.testNegotiateEncoding)
+		t.Run("Test compressing responses", ts.testCompression)
+	}
+}
+
+func (ts *TestSuite) testNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, deflate, br"))
+	assert.Equal(t, "deflate", negotiateEncoding("deflate"))
+	assert.Equal(t, "deflate", negotiateEncoding("gzip;q=0, deflate"))
+	assert.Equal(t, "gzip", negotiateEncoding("*"))
+	assert.Equal(t, "deflate", negotiateEncoding("*, gzip;q=0"))
+	assert.Equal(t, "", negotiateEncoding("br"))
+	assert.Equal(t, "", negotiateEncoding(""))
+}
+
+func (ts *TestSuite) testCompression(t *testing.T) {
+	get := func(path string, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Web pages are compressed with whichever encoding the client accepts
+	rec := get("/", "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Header().Values("Vary"), "Accept-Encoding")
+	body := Unwrap(io.ReadAll(Unwrap(gzip.NewReader(rec.Body))))
+	assert.True(t, strings.Contains(string(body), "<html"))
+
+	rec = get("/", "deflate")
+	assert.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+	body = Unwrap(io.ReadAll(Unwrap(zlib.NewReader(rec.Body))))
+	assert.True(t, strings.Contains(string(body), "<html"))
+
+	rec = get("/", "")
+	assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+	assert.True(t, strings.Contains(rec.Body.String(), "<html"))
+
+	// Responses smaller than MinSizeBytes aren't
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	rec = get("/users/profiles/minecraft/"+user.PlayerName, "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+
+	// Neither are PNG textures, which are already compressed
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	rec = get("/drasl/texture/skin/"+user.SkinHash.String+".png", "gzip")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("Content-Encoding"))
+	assert.True(t, bytes.HasPrefix(rec.Body.Bytes(), []byte("\x89PNG")))
+}
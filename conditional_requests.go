@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Conditional requests on the JSON profile routes. Plugins and mods that poll
+players' profiles can send the ETag or Last-Modified of a previous response
+back in If-None-Match or If-Modified-Since and get a 304 Not Modified while
+the profile hasn't changed, instead of a freshly built and signed profile.
+A profile's last-modified time is the latest of the user's UpdatedAt, the
+UpdatedAt of their custom profile properties, and the time Drasl started,
+since a restart may come with a changed config file. Changes that affect a
+profile without saving the user, like joining a group, touch the user with
+TouchUsers.
+*/
+
+// Mark the profiles of the users with the given UUIDs as changed
+func TouchUsers(tx *gorm.DB, userUUIDs ...string) error {
+	if len(userUUIDs) == 0 {
+		return nil
+	}
+	return tx.Model(&User{}).Where("uuid IN ?", userUUIDs).UpdateColumn("updated_at", time.Now()).Error
+}
+
+// When anything in user's profile last changed
+func (app *App) ProfileLastModified(user *User) (time.Time, error) {
+	lastModified := processStartTime
+	if user.UpdatedAt.After(lastModified) {
+		lastModified = user.UpdatedAt
+	}
+	var properties []UserProfileProperty
+	err := app.DB.Where("user_uuid = ?", user.UUID).Order("updated_at desc").Limit(1).Find(&properties).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(properties) > 0 && properties[0].UpdatedAt.After(lastModified) {
+		lastModified = properties[0].UpdatedAt
+	}
+	return lastModified, nil
+}
+
+// A weak ETag, since the signed parts of a profile differ between otherwise
+// identical responses. variant tells apart the responses a route can build
+// from the same profile, e.g. signed and unsigned ones.
+func profileETag(user *User, lastModified time.Time, variant string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\n%d\n%s", user.UUID, lastModified.UnixNano(), variant)))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the ETag and Last-Modified of a response built from user's profile and,
+// if the request's copy is still current, respond 304 Not Modified and return
+// true. Profiles whose textures may be forwarded from a fallback API server
+// aren't cached, since their last-modified time isn't known.
+func (app *App) ProfileNotModified(c echo.Context, user *User, variant string) (bool, error) {
+	if app.Config().ForwardSkins && !user.SkinHash.Valid && !user.CapeHash.Valid {
+		return false, nil
+	}
+	lastModified, err := app.ProfileLastModified(user)
+	if err != nil {
+		return false, err
+	}
+	etag := profileETag(user, lastModified, variant)
+	// Last-Modified only has a resolution of seconds
+	lastModified = lastModified.UTC().Truncate(time.Second)
+
+	header := c.Response().Header()
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	header.Set("Cache-Control", "no-cache")
+
+	// If-None-Match takes precedence over If-Modified-Since
+	// https://www.rfc-editor.org/rfc/rfc9110#section-13.1.3
+	if ifNoneMatch := c.Request().Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagMatches(ifNoneMatch, etag) {
+			return true, c.NoContent(http.StatusNotModified)
+		}
+		return false, nil
+	}
+	if ifModifiedSince := c.Request().Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err == nil && !lastModified.After(since) {
+			return true, c.NoContent(http.StatusNotModified)
+		}
+	}
+	return false, nil
+}
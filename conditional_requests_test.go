@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test conditional requests for profiles", ts.testConditionalRequests)
+	}
+}
+
+func (ts *TestSuite) getConditional(t *testing.T, server *echo.Echo, path string, header string, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *TestSuite) testConditionalRequests(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	profilePath := "/session/minecraft/profile/" + Unwrap(UUIDToID(user.UUID))
+
+	// A profile whose skin may be forwarded from a fallback API server isn't
+	// cached
+	rec := ts.getConditional(t, ts.Server, profilePath, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", rec.Header().Get("ETag"))
+
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+
+	rec = ts.getConditional(t, ts.Server, profilePath, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	assert.NotEqual(t, "", etag)
+	assert.NotEqual(t, "", lastModified)
+
+	rec = ts.getConditional(t, ts.Server, profilePath, "If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, 0, rec.Body.Len())
+	rec = ts.getConditional(t, ts.Server, profilePath, "If-Modified-Since", lastModified)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+
+	// Signed and unsigned profiles are different representations
+	rec = ts.getConditional(t, ts.Server, profilePath+"?unsigned=false", "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Changing the profile's properties changes the ETag
+	assert.Nil(t, ts.App.SetUserProfileProperties(&user, []UserProfileProperty{{Name: "pronouns", Value: "they/them"}}))
+	rec = ts.getConditional(t, ts.Server, profilePath, "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+
+	// So does changing the user
+	etag = rec.Header().Get("ETag")
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	user.SkinModel = SkinModelSlim
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+	rec = ts.getConditional(t, ts.Server, profilePath, "If-None-Match", etag)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A copy from before the last change isn't current
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	rec = ts.getConditional(t, ts.Server, profilePath, "If-Modified-Since", past)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	namePath := "/users/profiles/minecraft/" + user.PlayerName
+	rec = ts.getConditional(t, ts.Server, namePath, "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = ts.getConditional(t, ts.Server, namePath, "If-None-Match", rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
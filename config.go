@@ -1,24 +1,200 @@
 package main
 
 import (
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/dgraph-io/ristretto"
 	"log"
+	"net"
+	"net/mail"
 	"net/url"
 	"os"
-	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 )
 
+type accessLogConfig struct {
+	Enable              bool
+	File                string
+	MaxSizeMiB          int
+	RotateIntervalHours int
+	MaxBackups          int
+	ExcludeTextures     bool
+}
+
+type appearanceHistoryConfig struct {
+	Enable       bool
+	MaxSnapshots int
+}
+
+type apiTokensConfig struct {
+	Allow      bool
+	MaxPerUser int
+	// Defaults for each token, which admins can change per token. 0 means
+	// no limit.
+	RequestsPerMinute int
+	DailyQuota        int
+}
+
+type apiVersionsConfig struct {
+	// Versions of /drasl/api to serve, from API_VERSIONS
+	Serve []string
+	// Dates, like "2027-01-01", after which deprecated versions may no
+	// longer be served, by version
+	Sunset map[string]string
+}
+
+type texturesCompatibilityConfig struct {
+	// TEXTURES_PROFILE_MODERN or TEXTURES_PROFILE_LEGACY
+	Profile string
+}
+
+type elyByCompatibilityConfig struct {
+	Enable bool
+}
+
+type msaCompatibilityConfig struct {
+	Enable bool
+}
+
+type legacyAuthenticationConfig struct {
+	Enable bool
+}
+
+type floodgateConfig struct {
+	Enable            bool
+	UsernamePrefix    string
+	LinkCodeExpireSec int
+}
+
+type inGameCommandsConfig struct {
+	Allow         bool
+	CodeExpireSec int
+}
+
+type httpServerConfig struct {
+	ReadTimeoutSec       int
+	ReadHeaderTimeoutSec int
+	WriteTimeoutSec      int
+	IdleTimeoutSec       int
+	MaxHeaderKiB         int
+	EnableHTTP2          bool
+	MaxConcurrentStreams int
+}
+
+type externalAuthConfig struct {
+	Enable       bool
+	URL          string
+	Command      []string
+	TimeoutSec   int
+	AllowOnError bool
+}
+
+type errorReportingConfig struct {
+	Enable      bool
+	DSN         string
+	Environment string
+}
+
+type diagnosticsConfig struct {
+	Enable        bool
+	ListenAddress string
+	Token         string
+}
+
+type tracingConfig struct {
+	Enable       bool
+	OTLPEndpoint string
+	Headers      map[string]string
+	ServiceName  string
+	SampleRatio  float64
+}
+
+type eventStreamToken struct {
+	Token string
+	// Event types the token may receive. Empty means all of them.
+	Events []string
+}
+
+type eventStreamConfig struct {
+	Enable       bool
+	KeepaliveSec int
+	Tokens       []eventStreamToken
+}
+
+type outboundProxyConfig struct {
+	URL     string
+	NoProxy []string
+}
+
+type playerNameChangeConfig struct {
+	CooldownDays                  int
+	AvailabilityRequestsPerMinute int
+}
+
+type playerSearchConfig struct {
+	Allow      bool
+	MaxResults int
+}
+
+type profileImportConfig struct {
+	Allow bool
+}
+
+type reportsConfig struct {
+	Allow     bool
+	MaxPerDay int
+}
+
+type qrLoginConfig struct {
+	Allow     bool
+	ExpireSec int
+}
+
 type rateLimitConfig struct {
 	Enable            bool
 	RequestsPerSecond float64
+	IPv6PrefixLength  int
+	ExemptCIDRs       []string
+}
+
+type accountLinkingConfig struct {
+	Allow bool
+}
+
+type adminRestrictionsConfig struct {
+	AllowedCIDRs []string
+	DeniedCIDRs  []string
+}
+
+type authenticateTarpitConfig struct {
+	Enable       bool
+	FreeFailures int
+	MaxDelaySec  int
+}
+
+type authenticateThrottleConfig struct {
+	Enable            bool
+	RequestsPerMinute int
+	Tarpit            authenticateTarpitConfig
+}
+
+type brandingConfig struct {
+	LogoFile    string
+	FaviconFile string
+	AccentColor string
+	FooterText  string
+}
+
+type bootstrapConfig struct {
+	// Path to a TOML file declaring more Users, Invites, and Capes
+	SeedFile string
+	Users    []BootstrapUser
+	Invites  []string
+	Capes    []BootstrapCape
 }
 
 type bodyLimitConfig struct {
@@ -26,6 +202,102 @@ type bodyLimitConfig struct {
 	SizeLimitKiB int
 }
 
+type compressionConfig struct {
+	Enable       bool
+	Level        int
+	MinSizeBytes int
+}
+
+type maintenanceConfig struct {
+	Enable  bool
+	Message string
+}
+
+type readOnlyConfig struct {
+	Enable  bool
+	Message string
+}
+
+type dataEncryptionConfig struct {
+	KeyFile          string
+	PreviousKeyFiles []string
+}
+
+type deviceLoginConfig struct {
+	Allow           bool
+	ExpireSec       int
+	PollIntervalSec int
+}
+
+type emailConfig struct {
+	Enable               bool
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	From                 string
+	MessagesPerSecond    float64
+	NotifyNewDevice      bool
+	NotifyPasswordChange bool
+	NotifyEmailChange    bool
+}
+
+type securityHeadersConfig struct {
+	Enable                bool
+	ContentSecurityPolicy string
+	ReportOnly            bool
+	FrameAncestors        string
+	HSTSMaxAgeSec         int
+	HSTSIncludeSubdomains bool
+	ReferrerPolicy        string
+}
+
+type sessionHistoryConfig struct {
+	Enable        bool
+	RetentionDays int
+}
+
+type registrationRestrictionsConfig struct {
+	AllowedEmailDomains []string
+	DeniedEmailDomains  []string
+	DenyDisposableEmail bool
+	AllowedCIDRs        []string
+	DeniedCIDRs         []string
+}
+
+// A page shown on the web front end, rendered from a Markdown file, or a link
+// to somewhere else
+type CustomPage struct {
+	Name string
+	// The page is served at /drasl/pages/<Slug>
+	Slug string
+	File string
+	URL  string
+}
+
+// A custom property added to player profiles; see profile_properties.go
+type ProfileProperty struct {
+	Name  string
+	Value string
+	// If set, only members of the group with this name get the property
+	Group string
+}
+
+type termsOfServiceConfig struct {
+	// Slug of the CustomPage with the terms
+	Page              string
+	Version           string
+	RequireAcceptance bool
+}
+
+// A Minecraft server or proxy allowed to use the session introspection API
+type TrustedServer struct {
+	Nickname string
+	Token    string
+	// Proxies can read the forwarding secrets of every backend
+	IsProxy bool
+}
+
 type FallbackAPIServer struct {
 	Nickname         string
 	SessionURL       string
@@ -34,6 +306,61 @@ type FallbackAPIServer struct {
 	SkinDomains      []string
 	CacheTTLSeconds  int
 	DenyUnknownUsers bool
+	ProxyTextures    bool
+	// Trust only the CAs in this PEM file for the server's hosts
+	CACertFile string
+	// Base64-encoded SHA-256 hashes of public keys, one of which must be in
+	// the server's certificate chain
+	PinnedPublicKeys []string
+	// IP address to connect to instead of looking up the server's hosts
+	Address string
+	// Kept in the list, but not used
+	Disabled bool
+}
+
+// The FallbackAPIServers that aren't Disabled, in order
+func (config *Config) EnabledFallbackAPIServers() []FallbackAPIServer {
+	enabled := make([]FallbackAPIServer, 0, len(config.FallbackAPIServers))
+	for _, fallbackAPIServer := range config.FallbackAPIServers {
+		if !fallbackAPIServer.Disabled {
+			enabled = append(enabled, fallbackAPIServer)
+		}
+	}
+	return enabled
+}
+
+type skinRotationConfig struct {
+	Allow           bool
+	MaxLibrarySkins int
+}
+
+type statisticsConfig struct {
+	Allow bool
+}
+
+type chunkedUploadsConfig struct {
+	Enable          bool
+	ChunkSizeKiB    int
+	MaxTotalSizeMiB int
+	ExpireSec       int
+}
+
+type textureQueueConfig struct {
+	Enable    bool
+	Workers   int
+	QueueSize int
+}
+
+type textureCheckConfig struct {
+	Enable            bool
+	IntervalHours     int
+	Repair            bool
+	BackupDirectories []string
+}
+
+type twoPersonApprovalConfig struct {
+	Enable    bool
+	ExpireSec int
 }
 
 type transientUsersConfig struct {
@@ -46,55 +373,156 @@ type registrationNewPlayerConfig struct {
 	Allow             bool
 	AllowChoosingUUID bool
 	RequireInvite     bool
+	RequireApproval   bool
+}
+
+// An API server whose players can register with their existing UUID
+type registrationExistingPlayerSource struct {
+	Nickname   string
+	SessionURL string
+	AccountURL string
+	SetSkinURL string
 }
 
 type registrationExistingPlayerConfig struct {
-	Allow                   bool
+	Allow bool
+	// A single source can be configured directly, for compatibility with
+	// older configs. Further sources go in Sources.
 	Nickname                string
 	SessionURL              string
 	AccountURL              string
 	SetSkinURL              string
+	Sources                 []registrationExistingPlayerSource
 	RequireSkinVerification bool
+	ChallengeExpireSec      int
 	RequireInvite           bool
+	RequireApproval         bool
+}
+
+// All configured sources, starting with the one set directly on
+// RegistrationExistingPlayer, if any
+func (config registrationExistingPlayerConfig) AllSources() []registrationExistingPlayerSource {
+	sources := make([]registrationExistingPlayerSource, 0, len(config.Sources)+1)
+	if config.Nickname != "" || config.SessionURL != "" || config.AccountURL != "" {
+		sources = append(sources, registrationExistingPlayerSource{
+			Nickname:   config.Nickname,
+			SessionURL: config.SessionURL,
+			AccountURL: config.AccountURL,
+			SetSkinURL: config.SetSkinURL,
+		})
+	}
+	return append(sources, config.Sources...)
 }
 
 type Config struct {
-	AllowCapes                 bool
-	AllowChangingPlayerName    bool
-	AllowMultipleAccessTokens  bool
-	AllowSkins                 bool
-	ApplicationOwner           string
-	BaseURL                    string
-	BodyLimit                  bodyLimitConfig
-	DataDirectory              string
-	DefaultAdmins              []string
-	DefaultPreferredLanguage   string
-	Domain                     string
-	EnableBackgroundEffect     bool
-	FallbackAPIServers         []FallbackAPIServer
-	ForwardSkins               bool
-	InstanceName               string
-	ListenAddress              string
-	LogRequests                bool
-	MinPasswordLength          int
-	RateLimit                  rateLimitConfig
-	RegistrationExistingPlayer registrationExistingPlayerConfig
-	RegistrationNewPlayer      registrationNewPlayerConfig
-	RequestCache               ristretto.Config
-	SignPublicKeys             bool
-	SkinSizeLimit              int
-	OfflineSkins               bool
-	StateDirectory             string
-	TestMode                   bool
-	TokenExpireSec             int
-	TokenStaleSec              int
-	TransientUsers             transientUsersConfig
-	ValidPlayerNameRegex       string
+	AccessLog                   accessLogConfig
+	AccountLinking              accountLinkingConfig
+	AdminRestrictions           adminRestrictionsConfig
+	APITokens                   apiTokensConfig
+	APIVersions                 apiVersionsConfig
+	AppearanceHistory           appearanceHistoryConfig
+	AllowCapes                  bool
+	AllowChangingPlayerName     bool
+	AllowChangingUsername       bool
+	AllowMultipleAccessTokens   bool
+	AllowUnicodePlayerNames     bool
+	AllowSkins                  bool
+	AllowedTextureDomains       []string
+	ApplicationOwner            string
+	AuthenticateThrottle        authenticateThrottleConfig
+	BaseURL                     string
+	BodyLimit                   bodyLimitConfig
+	Bootstrap                   bootstrapConfig
+	Branding                    brandingConfig
+	ChunkedUploads              chunkedUploadsConfig
+	Compression                 compressionConfig
+	ConvertLegacySkins          bool
+	CustomPages                 []CustomPage
+	DataDirectory               string
+	DataEncryption              dataEncryptionConfig
+	DefaultAdmins               []string
+	DefaultPreferredLanguage    string
+	DeviceLogin                 deviceLoginConfig
+	Diagnostics                 diagnosticsConfig
+	Domain                      string
+	ElyByCompatibility          elyByCompatibilityConfig
+	Email                       emailConfig
+	EnableBackgroundEffect      bool
+	ErrorReporting              errorReportingConfig
+	EventStream                 eventStreamConfig
+	ExternalAuth                externalAuthConfig
+	FallbackAPIServers          []FallbackAPIServer
+	Floodgate                   floodgateConfig
+	ForwardSkins                bool
+	HTTPServer                  httpServerConfig
+	InGameCommands              inGameCommandsConfig
+	InstanceName                string
+	LegacyAuthentication        legacyAuthenticationConfig
+	ListenAddress               string
+	LogRequests                 bool
+	MSACompatibility            msaCompatibilityConfig
+	Maintenance                 maintenanceConfig
+	MaxPlayerNameLength         int
+	MinPasswordLength           int
+	MinPasswordStrength         int
+	MinPlayerNameLength         int
+	MojangCompatiblePlayerNames bool
+	OutboundProxy               outboundProxyConfig
+	PlayerNameChange            playerNameChangeConfig
+	PlayerSearch                playerSearchConfig
+	ProfileImport               profileImportConfig
+	ProfileProperties           []ProfileProperty
+	QRLogin                     qrLoginConfig
+	RateLimit                   rateLimitConfig
+	ReadOnly                    readOnlyConfig
+	RegistrationApprovalWebhook string
+	Reports                     reportsConfig
+	RegistrationExistingPlayer  registrationExistingPlayerConfig
+	RegistrationNewPlayer       registrationNewPlayerConfig
+	RegistrationRestrictions    registrationRestrictionsConfig
+	RequestCache                ristretto.Config
+	RequireSignedProfiles       bool
+	SecureCookies               bool
+	SecurityHeaders             securityHeadersConfig
+	SessionHistory              sessionHistoryConfig
+	SignPublicKeys              bool
+	SigningKeyFile              string
+	SkinRotation                skinRotationConfig
+	SkinSizeLimit               int
+	OfflineSkins                bool
+	StateDirectory              string
+	Statistics                  statisticsConfig
+	Tenants                     []string
+	TermsOfService              termsOfServiceConfig
+	TestMode                    bool
+	TextureBaseURL              string
+	TextureCheck                textureCheckConfig
+	TextureQueue                textureQueueConfig
+	TexturesCompatibility       texturesCompatibilityConfig
+	Theme                       string
+	TokenExpireSec              int
+	TokenStaleSec               int
+	Tracing                     tracingConfig
+	TransientUsers              transientUsersConfig
+	TrustedProxies              []string
+	TrustedServers              []TrustedServer
+	TwoPersonApproval           twoPersonApprovalConfig
+	ValidPlayerNameRegex        string
 }
 
+// The web front end uses a few inline scripts, and shows generated skins and
+// QR codes as data: URLs
+const DEFAULT_CONTENT_SECURITY_POLICY = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data: blob:; object-src 'none'; base-uri 'self'; form-action 'self'"
+
+var customPageSlugRegex = regexp.MustCompile("^[a-z0-9-]+$")
+
+var accentColorRegex = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
 var defaultRateLimitConfig = rateLimitConfig{
 	Enable:            true,
 	RequestsPerSecond: 5,
+	IPv6PrefixLength:  64,
+	ExemptCIDRs:       []string{},
 }
 var defaultBodyLimitConfig = bodyLimitConfig{
 	Enable:       true,
@@ -103,47 +531,250 @@ var defaultBodyLimitConfig = bodyLimitConfig{
 
 func DefaultConfig() Config {
 	return Config{
-		AllowCapes:               true,
-		AllowChangingPlayerName:  true,
-		AllowSkins:               true,
-		ApplicationOwner:         "Anonymous",
-		BaseURL:                  "",
-		BodyLimit:                defaultBodyLimitConfig,
-		DataDirectory:            DEFAULT_DATA_DIRECTORY,
+		AccessLog: accessLogConfig{
+			Enable:              false,
+			File:                "",
+			MaxSizeMiB:          100,
+			RotateIntervalHours: 0,
+			MaxBackups:          5,
+			ExcludeTextures:     false,
+		},
+		AccountLinking: accountLinkingConfig{
+			Allow: false,
+		},
+		APITokens: apiTokensConfig{
+			Allow:             false,
+			MaxPerUser:        10,
+			RequestsPerMinute: 60,
+			DailyQuota:        10000,
+		},
+		APIVersions: apiVersionsConfig{
+			Serve:  APIVersionNames(),
+			Sunset: map[string]string{},
+		},
+		AppearanceHistory: appearanceHistoryConfig{
+			Enable:       false,
+			MaxSnapshots: 20,
+		},
+		AllowCapes:              true,
+		AllowChangingPlayerName: true,
+		AllowChangingUsername:   false,
+		AllowSkins:              true,
+		AllowUnicodePlayerNames: true,
+		ApplicationOwner:        "Anonymous",
+		AuthenticateThrottle: authenticateThrottleConfig{
+			Enable:            true,
+			RequestsPerMinute: 20,
+			Tarpit: authenticateTarpitConfig{
+				Enable:       false,
+				FreeFailures: 5,
+				MaxDelaySec:  30,
+			},
+		},
+		BaseURL:   "",
+		BodyLimit: defaultBodyLimitConfig,
+		Branding: brandingConfig{
+			LogoFile:    "",
+			FaviconFile: "",
+			AccentColor: "",
+			FooterText:  "",
+		},
+		ChunkedUploads: chunkedUploadsConfig{
+			Enable:          false,
+			ChunkSizeKiB:    1024,
+			MaxTotalSizeMiB: 100,
+			ExpireSec:       3600,
+		},
+		Compression: compressionConfig{
+			Enable:       false,
+			Level:        6,
+			MinSizeBytes: 1024,
+		},
+		ConvertLegacySkins:       true,
+		DataDirectory:            Constants.DataDirectory,
 		DefaultAdmins:            []string{},
 		DefaultPreferredLanguage: "en",
-		Domain:                   "",
-		EnableBackgroundEffect:   true,
-		ForwardSkins:             true,
-		InstanceName:             "Drasl",
-		ListenAddress:            "0.0.0.0:25585",
-		LogRequests:              true,
-		MinPasswordLength:        8,
-		OfflineSkins:             true,
-		RateLimit:                defaultRateLimitConfig,
-		RegistrationExistingPlayer: registrationExistingPlayerConfig{
+		DeviceLogin: deviceLoginConfig{
+			Allow:           false,
+			ExpireSec:       600,
+			PollIntervalSec: 5,
+		},
+		Diagnostics: diagnosticsConfig{
+			Enable:        false,
+			ListenAddress: "127.0.0.1:6060",
+			Token:         "",
+		},
+		Domain: "",
+		ElyByCompatibility: elyByCompatibilityConfig{
+			Enable: false,
+		},
+		Email: emailConfig{
+			Enable:               false,
+			SMTPPort:             587,
+			MessagesPerSecond:    1,
+			NotifyNewDevice:      true,
+			NotifyPasswordChange: true,
+			NotifyEmailChange:    true,
+		},
+		EnableBackgroundEffect: true,
+		ErrorReporting: errorReportingConfig{
+			Enable:      false,
+			DSN:         "",
+			Environment: "",
+		},
+		EventStream: eventStreamConfig{
+			Enable:       false,
+			KeepaliveSec: 30,
+		},
+		ExternalAuth: externalAuthConfig{
+			Enable:     false,
+			TimeoutSec: 10,
+		},
+		Floodgate: floodgateConfig{
+			Enable:            false,
+			UsernamePrefix:    ".",
+			LinkCodeExpireSec: 600,
+		},
+		InGameCommands: inGameCommandsConfig{
+			Allow:         false,
+			CodeExpireSec: 300,
+		},
+		ForwardSkins: true,
+		HTTPServer: httpServerConfig{
+			ReadTimeoutSec:       0,
+			ReadHeaderTimeoutSec: 10,
+			WriteTimeoutSec:      0,
+			IdleTimeoutSec:       120,
+			MaxHeaderKiB:         1024,
+			EnableHTTP2:          false,
+			MaxConcurrentStreams: 250,
+		},
+		InstanceName: "Drasl",
+		LegacyAuthentication: legacyAuthenticationConfig{
+			Enable: false,
+		},
+		ListenAddress: "0.0.0.0:25585",
+		LogRequests:   true,
+		MSACompatibility: msaCompatibilityConfig{
+			Enable: false,
+		},
+		Maintenance: maintenanceConfig{
+			Enable:  false,
+			Message: "This server is down for maintenance. Please try again later.",
+		},
+		MaxPlayerNameLength:         Constants.MaxPlayerNameLength,
+		MinPasswordLength:           8,
+		MinPasswordStrength:         0,
+		MinPlayerNameLength:         1,
+		MojangCompatiblePlayerNames: false,
+		OfflineSkins:                true,
+		OutboundProxy: outboundProxyConfig{
+			URL:     "",
+			NoProxy: []string{},
+		},
+		PlayerNameChange: playerNameChangeConfig{
+			CooldownDays:                  0,
+			AvailabilityRequestsPerMinute: 60,
+		},
+		PlayerSearch: playerSearchConfig{
+			Allow:      false,
+			MaxResults: 100,
+		},
+		ProfileImport: profileImportConfig{
 			Allow: false,
 		},
+		QRLogin: qrLoginConfig{
+			Allow:     false,
+			ExpireSec: 120,
+		},
+		RateLimit: defaultRateLimitConfig,
+		ReadOnly: readOnlyConfig{
+			Enable:  false,
+			Message: "This server is in read-only mode. Changes can't be saved right now.",
+		},
+		RegistrationExistingPlayer: registrationExistingPlayerConfig{
+			Allow:              false,
+			ChallengeExpireSec: 3600,
+		},
 		RegistrationNewPlayer: registrationNewPlayerConfig{
 			Allow:             true,
 			AllowChoosingUUID: false,
 			RequireInvite:     false,
 		},
+		Reports: reportsConfig{
+			Allow:     false,
+			MaxPerDay: 5,
+		},
 		RequestCache: ristretto.Config{
 			// Defaults from https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-config
 			NumCounters: 1e7,
 			MaxCost:     1 << 30, // 1 GiB
 			BufferItems: 64,
 		},
+		RequireSignedProfiles: false,
+		SecureCookies:         true,
+		SecurityHeaders: securityHeadersConfig{
+			Enable:                true,
+			ContentSecurityPolicy: DEFAULT_CONTENT_SECURITY_POLICY,
+			ReportOnly:            false,
+			FrameAncestors:        "'none'",
+			HSTSMaxAgeSec:         0,
+			HSTSIncludeSubdomains: false,
+			ReferrerPolicy:        "same-origin",
+		},
+		SessionHistory: sessionHistoryConfig{
+			Enable:        false,
+			RetentionDays: 30,
+		},
 		SignPublicKeys: true,
+		SkinRotation: skinRotationConfig{
+			Allow:           false,
+			MaxLibrarySkins: 10,
+		},
 		SkinSizeLimit:  128,
-		StateDirectory: DEFAULT_STATE_DIRECTORY,
+		StateDirectory: Constants.StateDirectory,
+		Statistics: statisticsConfig{
+			Allow: false,
+		},
+		Tenants: []string{},
+		TermsOfService: termsOfServiceConfig{
+			Page:              "",
+			Version:           "",
+			RequireAcceptance: false,
+		},
 		TestMode:       false,
+		TextureBaseURL: "",
+		TextureCheck: textureCheckConfig{
+			Enable:            false,
+			IntervalHours:     24,
+			Repair:            false,
+			BackupDirectories: []string{},
+		},
+		TextureQueue: textureQueueConfig{
+			Enable:    false,
+			Workers:   4,
+			QueueSize: 256,
+		},
+		TexturesCompatibility: texturesCompatibilityConfig{
+			Profile: TEXTURES_PROFILE_MODERN,
+		},
+		Theme:          "",
 		TokenExpireSec: 0,
 		TokenStaleSec:  0,
+		Tracing: tracingConfig{
+			Enable:       false,
+			OTLPEndpoint: "http://localhost:4318",
+			Headers:      map[string]string{},
+			ServiceName:  "drasl",
+			SampleRatio:  1,
+		},
 		TransientUsers: transientUsersConfig{
 			Allow: false,
 		},
+		TwoPersonApproval: twoPersonApprovalConfig{
+			Enable:    false,
+			ExpireSec: 86400,
+		},
 		ValidPlayerNameRegex: "^[a-zA-Z0-9_]+$",
 	}
 }
@@ -156,13 +787,45 @@ func CleanConfig(config *Config) error {
 		return fmt.Errorf("Invalid BaseURL: %s", err)
 	}
 	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	if config.TextureBaseURL != "" {
+		textureBaseURL, err := url.Parse(config.TextureBaseURL)
+		if err != nil {
+			return fmt.Errorf("Invalid TextureBaseURL: %s", err)
+		}
+		if textureBaseURL.Hostname() == "" {
+			return fmt.Errorf("Invalid TextureBaseURL %s: must be an absolute URL. Example: https://textures.drasl.example.com", config.TextureBaseURL)
+		}
+		config.TextureBaseURL = strings.TrimRight(config.TextureBaseURL, "/")
+	}
 
 	if !IsValidPreferredLanguage(config.DefaultPreferredLanguage) {
 		return fmt.Errorf("Invalid DefaultPreferredLanguage %s", config.DefaultPreferredLanguage)
 	}
+	if config.DeviceLogin.Allow {
+		if config.DeviceLogin.ExpireSec <= 0 {
+			return fmt.Errorf("Invalid DeviceLogin.ExpireSec %d: must be positive", config.DeviceLogin.ExpireSec)
+		}
+		if config.DeviceLogin.PollIntervalSec <= 0 {
+			return fmt.Errorf("Invalid DeviceLogin.PollIntervalSec %d: must be positive", config.DeviceLogin.PollIntervalSec)
+		}
+	}
 	if config.Domain == "" {
 		return errors.New("Domain must be set to a valid fully qualified domain name")
 	}
+	if config.Email.Enable {
+		if config.Email.SMTPHost == "" {
+			return errors.New("Email.SMTPHost must be set")
+		}
+		if config.Email.SMTPPort <= 0 {
+			return fmt.Errorf("Invalid Email.SMTPPort %d", config.Email.SMTPPort)
+		}
+		if _, err := mail.ParseAddress(config.Email.From); err != nil {
+			return fmt.Errorf("Invalid Email.From: %s", err)
+		}
+		if config.Email.MessagesPerSecond <= 0 {
+			return errors.New("Email.MessagesPerSecond must be greater than 0")
+		}
+	}
 	if config.InstanceName == "" {
 		return errors.New("InstanceName must be set")
 	}
@@ -172,30 +835,410 @@ func CleanConfig(config *Config) error {
 	if _, err := os.Open(config.DataDirectory); err != nil {
 		return fmt.Errorf("Couldn't open DataDirectory: %s", err)
 	}
-	if config.RegistrationExistingPlayer.Allow {
-		if config.RegistrationExistingPlayer.Nickname == "" {
-			return errors.New("RegistrationExistingPlayer.Nickname must be set")
+	if config.MinPasswordStrength < 0 || config.MinPasswordStrength > 4 {
+		return fmt.Errorf("Invalid MinPasswordStrength %d: must be between 0 and 4", config.MinPasswordStrength)
+	}
+	if config.MinPlayerNameLength < 1 {
+		return fmt.Errorf("Invalid MinPlayerNameLength %d: must be at least 1", config.MinPlayerNameLength)
+	}
+	if config.MaxPlayerNameLength < config.MinPlayerNameLength || config.MaxPlayerNameLength > Constants.MaxPlayerNameLength {
+		return fmt.Errorf("Invalid MaxPlayerNameLength %d: must be between MinPlayerNameLength and %d", config.MaxPlayerNameLength, Constants.MaxPlayerNameLength)
+	}
+	if _, err := regexp.Compile(config.ValidPlayerNameRegex); err != nil {
+		return fmt.Errorf("Invalid ValidPlayerNameRegex: %s", err)
+	}
+	if _, err := ParseCIDRs(config.RegistrationRestrictions.AllowedCIDRs); err != nil {
+		return fmt.Errorf("Invalid RegistrationRestrictions.AllowedCIDRs: %s", err)
+	}
+	if _, err := ParseCIDRs(config.RegistrationRestrictions.DeniedCIDRs); err != nil {
+		return fmt.Errorf("Invalid RegistrationRestrictions.DeniedCIDRs: %s", err)
+	}
+	if _, err := ParseCIDRs(config.AdminRestrictions.AllowedCIDRs); err != nil {
+		return fmt.Errorf("Invalid AdminRestrictions.AllowedCIDRs: %s", err)
+	}
+	if _, err := ParseCIDRs(config.AdminRestrictions.DeniedCIDRs); err != nil {
+		return fmt.Errorf("Invalid AdminRestrictions.DeniedCIDRs: %s", err)
+	}
+	if _, err := ParseCIDRs(config.TrustedProxies); err != nil {
+		return fmt.Errorf("Invalid TrustedProxies: %s", err)
+	}
+	if config.AuthenticateThrottle.Enable {
+		if config.AuthenticateThrottle.RequestsPerMinute <= 0 {
+			return fmt.Errorf("Invalid AuthenticateThrottle.RequestsPerMinute %d: must be positive", config.AuthenticateThrottle.RequestsPerMinute)
+		}
+		if config.AuthenticateThrottle.Tarpit.Enable {
+			if config.AuthenticateThrottle.Tarpit.FreeFailures < 0 {
+				return fmt.Errorf("Invalid AuthenticateThrottle.Tarpit.FreeFailures %d: must not be negative", config.AuthenticateThrottle.Tarpit.FreeFailures)
+			}
+			if config.AuthenticateThrottle.Tarpit.MaxDelaySec <= 0 {
+				return fmt.Errorf("Invalid AuthenticateThrottle.Tarpit.MaxDelaySec %d: must be positive", config.AuthenticateThrottle.Tarpit.MaxDelaySec)
+			}
+		}
+	}
+	if _, err := LoadDataCipher(&config.DataEncryption); err != nil {
+		return fmt.Errorf("Invalid DataEncryption: %s", err)
+	}
+	if config.TextureCheck.Enable && config.TextureCheck.IntervalHours <= 0 {
+		return fmt.Errorf("Invalid TextureCheck.IntervalHours %d: must be positive", config.TextureCheck.IntervalHours)
+	}
+	if config.Compression.Level < 1 || config.Compression.Level > 9 {
+		return fmt.Errorf("Invalid Compression.Level %d: must be between 1 and 9", config.Compression.Level)
+	}
+	if config.Compression.MinSizeBytes < 0 {
+		return fmt.Errorf("Invalid Compression.MinSizeBytes %d: must not be negative", config.Compression.MinSizeBytes)
+	}
+	for name, value := range map[string]int{
+		"ReadTimeoutSec":       config.HTTPServer.ReadTimeoutSec,
+		"ReadHeaderTimeoutSec": config.HTTPServer.ReadHeaderTimeoutSec,
+		"WriteTimeoutSec":      config.HTTPServer.WriteTimeoutSec,
+		"IdleTimeoutSec":       config.HTTPServer.IdleTimeoutSec,
+	} {
+		if value < 0 {
+			return fmt.Errorf("Invalid HTTPServer.%s %d: must not be negative", name, value)
+		}
+	}
+	if config.HTTPServer.MaxHeaderKiB < 1 {
+		return fmt.Errorf("Invalid HTTPServer.MaxHeaderKiB %d: must be positive", config.HTTPServer.MaxHeaderKiB)
+	}
+	if config.HTTPServer.EnableHTTP2 && config.HTTPServer.MaxConcurrentStreams < 1 {
+		return fmt.Errorf("Invalid HTTPServer.MaxConcurrentStreams %d: must be positive", config.HTTPServer.MaxConcurrentStreams)
+	}
+	if config.SecurityHeaders.HSTSMaxAgeSec < 0 {
+		return fmt.Errorf("Invalid SecurityHeaders.HSTSMaxAgeSec %d: must not be negative", config.SecurityHeaders.HSTSMaxAgeSec)
+	}
+	if config.OutboundProxy.URL != "" {
+		proxyURL, err := url.Parse(config.OutboundProxy.URL)
+		if err != nil {
+			return fmt.Errorf("Invalid OutboundProxy.URL %s: %s", config.OutboundProxy.URL, err)
+		}
+		if !Contains([]string{"http", "https", "socks5", "socks5h"}, proxyURL.Scheme) || proxyURL.Host == "" {
+			return fmt.Errorf("Invalid OutboundProxy.URL %s: must be an http, https, socks5, or socks5h URL", config.OutboundProxy.URL)
+		}
+	}
+	if config.PlayerNameChange.CooldownDays < 0 {
+		return fmt.Errorf("Invalid PlayerNameChange.CooldownDays %d: must not be negative", config.PlayerNameChange.CooldownDays)
+	}
+	if config.PlayerNameChange.AvailabilityRequestsPerMinute < 0 {
+		return fmt.Errorf("Invalid PlayerNameChange.AvailabilityRequestsPerMinute %d: must not be negative", config.PlayerNameChange.AvailabilityRequestsPerMinute)
+	}
+	if config.PlayerSearch.MaxResults <= 0 {
+		return fmt.Errorf("Invalid PlayerSearch.MaxResults %d: must be positive", config.PlayerSearch.MaxResults)
+	}
+	if config.EventStream.Enable {
+		if config.EventStream.KeepaliveSec <= 0 {
+			return fmt.Errorf("Invalid EventStream.KeepaliveSec %d: must be positive", config.EventStream.KeepaliveSec)
+		}
+		tokens := map[string]bool{}
+		for _, token := range config.EventStream.Tokens {
+			if len(token.Token) < 16 {
+				return errors.New("EventStream.Tokens must be at least 16 characters long")
+			}
+			if tokens[token.Token] {
+				return errors.New("Duplicate EventStream.Tokens")
+			}
+			tokens[token.Token] = true
+			for _, eventType := range token.Events {
+				if !Contains(EVENT_TYPES, eventType) {
+					return fmt.Errorf("Invalid event type %s in EventStream.Tokens: must be one of %s", eventType, strings.Join(EVENT_TYPES, ", "))
+				}
+			}
+		}
+	}
+	if config.ExternalAuth.Enable {
+		if (config.ExternalAuth.URL == "") == (len(config.ExternalAuth.Command) == 0) {
+			return errors.New("Exactly one of ExternalAuth.URL and ExternalAuth.Command must be set")
+		}
+		if config.ExternalAuth.URL != "" {
+			externalAuthURL, err := url.Parse(config.ExternalAuth.URL)
+			if err != nil || (externalAuthURL.Scheme != "http" && externalAuthURL.Scheme != "https") {
+				return fmt.Errorf("Invalid ExternalAuth.URL %s: must be an http or https URL", config.ExternalAuth.URL)
+			}
+		}
+		if config.ExternalAuth.TimeoutSec <= 0 {
+			return fmt.Errorf("Invalid ExternalAuth.TimeoutSec %d: must be positive", config.ExternalAuth.TimeoutSec)
+		}
+	}
+	trustedServerNicknames := map[string]bool{}
+	trustedServerTokens := map[string]bool{}
+	for _, trustedServer := range config.TrustedServers {
+		if trustedServer.Nickname == "" {
+			return errors.New("TrustedServers must have a Nickname")
+		}
+		if trustedServerNicknames[trustedServer.Nickname] {
+			return fmt.Errorf("Duplicate TrustedServers Nickname %s", trustedServer.Nickname)
+		}
+		trustedServerNicknames[trustedServer.Nickname] = true
+		if len(trustedServer.Token) < 16 {
+			return fmt.Errorf("Token of TrustedServer %s must be at least 16 characters long", trustedServer.Nickname)
+		}
+		if trustedServerTokens[trustedServer.Token] {
+			return errors.New("Duplicate TrustedServers Token")
+		}
+		trustedServerTokens[trustedServer.Token] = true
+	}
+	profileProperties := map[ProfileProperty]bool{}
+	for _, property := range config.ProfileProperties {
+		if err := ValidateProfilePropertyName(property.Name); err != nil {
+			return fmt.Errorf("Invalid ProfileProperties Name %s: %s", property.Name, err)
+		}
+		if err := ValidateProfilePropertyValue(property.Value); err != nil {
+			return fmt.Errorf("Invalid Value of ProfileProperty %s: %s", property.Name, err)
+		}
+		key := ProfileProperty{Name: property.Name, Group: strings.ToLower(property.Group)}
+		if profileProperties[key] {
+			return fmt.Errorf("Duplicate ProfileProperties Name %s for the same Group", property.Name)
+		}
+		profileProperties[key] = true
+	}
+	if config.Floodgate.Enable && config.Floodgate.LinkCodeExpireSec <= 0 {
+		return fmt.Errorf("Invalid Floodgate.LinkCodeExpireSec %d: must be positive", config.Floodgate.LinkCodeExpireSec)
+	}
+	if config.InGameCommands.Allow && config.InGameCommands.CodeExpireSec <= 0 {
+		return fmt.Errorf("Invalid InGameCommands.CodeExpireSec %d: must be positive", config.InGameCommands.CodeExpireSec)
+	}
+	if !Contains(TEXTURES_PROFILES, config.TexturesCompatibility.Profile) {
+		return fmt.Errorf("Invalid TexturesCompatibility.Profile %s: must be one of %s", config.TexturesCompatibility.Profile, strings.Join(TEXTURES_PROFILES, ", "))
+	}
+	if config.MSACompatibility.Enable && !config.DeviceLogin.Allow {
+		return errors.New("MSACompatibility requires DeviceLogin.Allow")
+	}
+	if config.AccessLog.Enable {
+		if config.AccessLog.File == "" {
+			return errors.New("AccessLog.File must be set")
 		}
-		if config.RegistrationExistingPlayer.SessionURL == "" {
-			return errors.New("RegistrationExistingPlayer.SessionURL must be set. Example: https://sessionserver.mojang.com")
+		if config.AccessLog.MaxSizeMiB < 0 {
+			return fmt.Errorf("Invalid AccessLog.MaxSizeMiB %d: must not be negative", config.AccessLog.MaxSizeMiB)
 		}
-		if _, err := url.Parse(config.RegistrationExistingPlayer.SessionURL); err != nil {
-			return fmt.Errorf("Invalid RegistrationExistingPlayer.SessionURL: %s", err)
+		if config.AccessLog.RotateIntervalHours < 0 {
+			return fmt.Errorf("Invalid AccessLog.RotateIntervalHours %d: must not be negative", config.AccessLog.RotateIntervalHours)
+		}
+		if config.AccessLog.MaxBackups <= 0 {
+			return fmt.Errorf("Invalid AccessLog.MaxBackups %d: must be positive", config.AccessLog.MaxBackups)
+		}
+	}
+	if config.ErrorReporting.Enable {
+		if _, _, err := parseDSN(config.ErrorReporting.DSN); err != nil {
+			return fmt.Errorf("Invalid ErrorReporting.DSN: %s", err)
+		}
+	}
+	if config.Diagnostics.Enable {
+		if config.Diagnostics.ListenAddress == "" {
+			return errors.New("Diagnostics.ListenAddress must be set. Example: 127.0.0.1:6060")
+		}
+		if config.Diagnostics.ListenAddress == config.ListenAddress {
+			return errors.New("Diagnostics.ListenAddress must be different from ListenAddress")
+		}
+		if len(config.Diagnostics.Token) < 16 {
+			return errors.New("Diagnostics.Token must be set to at least 16 characters")
+		}
+	}
+	if config.RateLimit.Enable && config.RateLimit.RequestsPerSecond <= 0 {
+		return fmt.Errorf("Invalid RateLimit.RequestsPerSecond %v: must be positive", config.RateLimit.RequestsPerSecond)
+	}
+	if config.RateLimit.IPv6PrefixLength < 1 || config.RateLimit.IPv6PrefixLength > 128 {
+		return fmt.Errorf("Invalid RateLimit.IPv6PrefixLength %d: must be between 1 and 128", config.RateLimit.IPv6PrefixLength)
+	}
+	if _, err := ParseCIDRs(config.RateLimit.ExemptCIDRs); err != nil {
+		return fmt.Errorf("Invalid RateLimit.ExemptCIDRs: %s", err)
+	}
+	if config.SkinSizeLimit < 0 {
+		return fmt.Errorf("Invalid SkinSizeLimit %d: must not be negative", config.SkinSizeLimit)
+	}
+	if config.Tracing.Enable {
+		endpoint, err := url.Parse(config.Tracing.OTLPEndpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return fmt.Errorf("Invalid Tracing.OTLPEndpoint %s: must be an http or https URL", config.Tracing.OTLPEndpoint)
+		}
+		if config.Tracing.ServiceName == "" {
+			return errors.New("Tracing.ServiceName must be set")
+		}
+		if config.Tracing.SampleRatio < 0 || config.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("Invalid Tracing.SampleRatio %v: must be between 0 and 1", config.Tracing.SampleRatio)
+		}
+	}
+	if config.APITokens.Allow && config.APITokens.MaxPerUser <= 0 {
+		return fmt.Errorf("Invalid APITokens.MaxPerUser %d: must be positive", config.APITokens.MaxPerUser)
+	}
+	if config.APITokens.RequestsPerMinute < 0 {
+		return fmt.Errorf("Invalid APITokens.RequestsPerMinute %d: must not be negative", config.APITokens.RequestsPerMinute)
+	}
+	if config.APITokens.DailyQuota < 0 {
+		return fmt.Errorf("Invalid APITokens.DailyQuota %d: must not be negative", config.APITokens.DailyQuota)
+	}
+	if len(config.APIVersions.Serve) == 0 {
+		return errors.New("APIVersions.Serve must include at least one version")
+	}
+	for _, name := range config.APIVersions.Serve {
+		if _, ok := GetAPIVersion(name); !ok {
+			return fmt.Errorf("Invalid API version %s in APIVersions.Serve: must be one of %s", name, strings.Join(APIVersionNames(), ", "))
+		}
+	}
+	for name, sunset := range config.APIVersions.Sunset {
+		version, ok := GetAPIVersion(name)
+		if !ok || !version.IsDeprecated() {
+			return fmt.Errorf("Invalid API version %s in APIVersions.Sunset: only deprecated versions can have a sunset date", name)
+		}
+		if _, err := time.Parse(API_SUNSET_DATE_FORMAT, sunset); err != nil {
+			return fmt.Errorf("Invalid APIVersions.Sunset date %s for %s: must be like 2027-01-01", sunset, name)
+		}
+	}
+	if config.AppearanceHistory.Enable && config.AppearanceHistory.MaxSnapshots <= 0 {
+		return fmt.Errorf("Invalid AppearanceHistory.MaxSnapshots %d: must be positive", config.AppearanceHistory.MaxSnapshots)
+	}
+	if config.SkinRotation.Allow && config.SkinRotation.MaxLibrarySkins <= 0 {
+		return fmt.Errorf("Invalid SkinRotation.MaxLibrarySkins %d: must be positive", config.SkinRotation.MaxLibrarySkins)
+	}
+	if config.Branding.AccentColor != "" && !accentColorRegex.MatchString(config.Branding.AccentColor) {
+		return fmt.Errorf("Invalid Branding.AccentColor %s: must be a hex color like #008080", config.Branding.AccentColor)
+	}
+	if err := validateBootstrapSeed(&bootstrapSeed{
+		Users:   config.Bootstrap.Users,
+		Invites: config.Bootstrap.Invites,
+		Capes:   config.Bootstrap.Capes,
+	}); err != nil {
+		return err
+	}
+	for _, domain := range config.AllowedTextureDomains {
+		if domain == "" {
+			return errors.New("AllowedTextureDomains can't contain a blank domain")
+		}
+	}
+	customPageSlugs := map[string]bool{}
+	for _, page := range config.CustomPages {
+		if page.Name == "" {
+			return errors.New("CustomPages must have a Name")
+		}
+		if (page.File == "") == (page.URL == "") {
+			return fmt.Errorf("CustomPage %s must have either a File or a URL", page.Name)
+		}
+		if page.File != "" {
+			if !customPageSlugRegex.MatchString(page.Slug) {
+				return fmt.Errorf("Invalid Slug of CustomPage %s: must be made of lowercase letters, digits, and hyphens", page.Name)
+			}
+			if customPageSlugs[page.Slug] {
+				return fmt.Errorf("Duplicate CustomPages Slug %s", page.Slug)
+			}
+			customPageSlugs[page.Slug] = true
+		}
+	}
+	if config.TermsOfService.Page != "" && !customPageSlugs[config.TermsOfService.Page] {
+		return fmt.Errorf("TermsOfService.Page %s must be the Slug of one of the CustomPages", config.TermsOfService.Page)
+	}
+	if config.TermsOfService.RequireAcceptance {
+		if config.TermsOfService.Page == "" {
+			return errors.New("TermsOfService.RequireAcceptance requires TermsOfService.Page")
+		}
+		if config.TermsOfService.Version == "" {
+			return errors.New("TermsOfService.RequireAcceptance requires TermsOfService.Version")
+		}
+	}
+	if config.TextureQueue.Enable {
+		if config.TextureQueue.Workers <= 0 {
+			return fmt.Errorf("Invalid TextureQueue.Workers %d: must be positive", config.TextureQueue.Workers)
+		}
+		if config.TextureQueue.QueueSize <= 0 {
+			return fmt.Errorf("Invalid TextureQueue.QueueSize %d: must be positive", config.TextureQueue.QueueSize)
+		}
+	}
+	if config.ChunkedUploads.Enable {
+		if config.ChunkedUploads.ChunkSizeKiB <= 0 {
+			return fmt.Errorf("Invalid ChunkedUploads.ChunkSizeKiB %d: must be positive", config.ChunkedUploads.ChunkSizeKiB)
+		}
+		if config.BodyLimit.Enable && config.ChunkedUploads.ChunkSizeKiB >= config.BodyLimit.SizeLimitKiB {
+			return fmt.Errorf("Invalid ChunkedUploads.ChunkSizeKiB %d: must be less than BodyLimit.SizeLimitKiB", config.ChunkedUploads.ChunkSizeKiB)
+		}
+		if config.ChunkedUploads.MaxTotalSizeMiB <= 0 {
+			return fmt.Errorf("Invalid ChunkedUploads.MaxTotalSizeMiB %d: must be positive", config.ChunkedUploads.MaxTotalSizeMiB)
+		}
+		if config.ChunkedUploads.ExpireSec <= 0 {
+			return fmt.Errorf("Invalid ChunkedUploads.ExpireSec %d: must be positive", config.ChunkedUploads.ExpireSec)
+		}
+	}
+	if config.ProfileImport.Allow && !config.AccountLinking.Allow {
+		return errors.New("ProfileImport.Allow requires AccountLinking.Allow")
+	}
+	if config.Reports.Allow && config.Reports.MaxPerDay <= 0 {
+		return fmt.Errorf("Invalid Reports.MaxPerDay %d: must be positive", config.Reports.MaxPerDay)
+	}
+	if config.SessionHistory.Enable && config.SessionHistory.RetentionDays <= 0 {
+		return fmt.Errorf("Invalid SessionHistory.RetentionDays %d: must be positive", config.SessionHistory.RetentionDays)
+	}
+	if config.TwoPersonApproval.Enable && config.TwoPersonApproval.ExpireSec <= 0 {
+		return fmt.Errorf("Invalid TwoPersonApproval.ExpireSec %d: must be positive", config.TwoPersonApproval.ExpireSec)
+	}
+	if config.QRLogin.Allow && config.QRLogin.ExpireSec <= 0 {
+		return fmt.Errorf("Invalid QRLogin.ExpireSec %d: must be positive", config.QRLogin.ExpireSec)
+	}
+	if config.Maintenance.Enable && config.Maintenance.Message == "" {
+		return errors.New("Maintenance.Message must be set")
+	}
+	if config.ReadOnly.Enable && config.ReadOnly.Message == "" {
+		return errors.New("ReadOnly.Message must be set")
+	}
+	if config.Theme != "" {
+		if strings.ContainsAny(config.Theme, "/\\") || config.Theme == "." || config.Theme == ".." {
+			return fmt.Errorf("Invalid Theme %s: must be a directory name, not a path", config.Theme)
+		}
+		themeDirectory := GetThemeDirectory(config)
+		if info, err := os.Stat(themeDirectory); err != nil || !info.IsDir() {
+			return fmt.Errorf("Couldn't open theme directory %s", themeDirectory)
+		}
+	}
+	if config.RegistrationExistingPlayer.Allow || config.AccountLinking.Allow {
+		if len(config.RegistrationExistingPlayer.AllSources()) == 0 {
+			return errors.New("RegistrationExistingPlayer.Nickname must be set, or at least one [[RegistrationExistingPlayer.Sources]] must be configured")
 		}
 		config.RegistrationExistingPlayer.SessionURL = strings.TrimRight(config.RegistrationExistingPlayer.SessionURL, "/")
+		config.RegistrationExistingPlayer.AccountURL = strings.TrimRight(config.RegistrationExistingPlayer.AccountURL, "/")
+		for _, source := range PtrSlice(config.RegistrationExistingPlayer.Sources) {
+			source.SessionURL = strings.TrimRight(source.SessionURL, "/")
+			source.AccountURL = strings.TrimRight(source.AccountURL, "/")
+		}
+
+		if (config.RegistrationExistingPlayer.RequireSkinVerification || config.AccountLinking.Allow) && config.RegistrationExistingPlayer.ChallengeExpireSec <= 0 {
+			return fmt.Errorf("Invalid RegistrationExistingPlayer.ChallengeExpireSec %d: must be positive", config.RegistrationExistingPlayer.ChallengeExpireSec)
+		}
+
+		nicknames := make(map[string]bool)
+		for _, source := range config.RegistrationExistingPlayer.AllSources() {
+			if source.Nickname == "" {
+				return errors.New("RegistrationExistingPlayer source Nickname must be set")
+			}
+			if nicknames[source.Nickname] {
+				return fmt.Errorf("Duplicate RegistrationExistingPlayer source Nickname %s", source.Nickname)
+			}
+			nicknames[source.Nickname] = true
 
-		if config.RegistrationExistingPlayer.AccountURL == "" {
-			return errors.New("RegistrationExistingPlayer.AccountURL must be set. Example: https://api.mojang.com")
+			if source.SessionURL == "" {
+				return fmt.Errorf("RegistrationExistingPlayer source %s SessionURL must be set. Example: https://sessionserver.mojang.com", source.Nickname)
+			}
+			if _, err := url.Parse(source.SessionURL); err != nil {
+				return fmt.Errorf("Invalid RegistrationExistingPlayer source %s SessionURL: %s", source.Nickname, err)
+			}
+
+			if source.AccountURL == "" {
+				return fmt.Errorf("RegistrationExistingPlayer source %s AccountURL must be set. Example: https://api.mojang.com", source.Nickname)
+			}
+			if _, err := url.Parse(source.AccountURL); err != nil {
+				return fmt.Errorf("Invalid RegistrationExistingPlayer source %s AccountURL: %s", source.Nickname, err)
+			}
 		}
-		if _, err := url.Parse(config.RegistrationExistingPlayer.AccountURL); err != nil {
-			return fmt.Errorf("Invalid RegistrationExistingPlayer.AccountURL: %s", err)
+	}
+	if config.RegistrationApprovalWebhook != "" {
+		webhookURL, err := url.Parse(config.RegistrationApprovalWebhook)
+		if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") {
+			return fmt.Errorf("Invalid RegistrationApprovalWebhook %s: must be an http or https URL", config.RegistrationApprovalWebhook)
 		}
-		config.RegistrationExistingPlayer.AccountURL = strings.TrimRight(config.RegistrationExistingPlayer.AccountURL, "/")
 	}
+	fallbackAPIServerNicknames := map[string]bool{}
 	for _, fallbackAPIServer := range PtrSlice(config.FallbackAPIServers) {
 		if fallbackAPIServer.Nickname == "" {
 			return errors.New("FallbackAPIServer Nickname must be set")
 		}
+		if fallbackAPIServerNicknames[fallbackAPIServer.Nickname] {
+			return fmt.Errorf("Duplicate FallbackAPIServer Nickname %s", fallbackAPIServer.Nickname)
+		}
+		fallbackAPIServerNicknames[fallbackAPIServer.Nickname] = true
 
 		if fallbackAPIServer.AccountURL == "" {
 			return errors.New("FallbackAPIServer AccountURL must be set")
@@ -225,6 +1268,22 @@ func CleanConfig(config *Config) error {
 				return fmt.Errorf("SkinDomain can't be blank for FallbackAPIServer \"%s\"", fallbackAPIServer.Nickname)
 			}
 		}
+		if fallbackAPIServer.ProxyTextures && len(fallbackAPIServer.SkinDomains) == 0 {
+			return fmt.Errorf("SkinDomains must be set to use ProxyTextures for FallbackAPIServer \"%s\"", fallbackAPIServer.Nickname)
+		}
+		if fallbackAPIServer.CACertFile != "" {
+			if _, err := LoadCACertFile(fallbackAPIServer.CACertFile); err != nil {
+				return fmt.Errorf("Invalid CACertFile for FallbackAPIServer \"%s\": %s", fallbackAPIServer.Nickname, err)
+			}
+		}
+		for _, pin := range fallbackAPIServer.PinnedPublicKeys {
+			if err := ValidatePublicKeyPin(pin); err != nil {
+				return fmt.Errorf("Invalid PinnedPublicKeys entry %s for FallbackAPIServer \"%s\": %s", pin, fallbackAPIServer.Nickname, err)
+			}
+		}
+		if fallbackAPIServer.Address != "" && net.ParseIP(fallbackAPIServer.Address) == nil {
+			return fmt.Errorf("Invalid Address %s for FallbackAPIServer \"%s\": must be an IP address", fallbackAPIServer.Address, fallbackAPIServer.Nickname)
+		}
 	}
 	return nil
 }
@@ -247,13 +1306,17 @@ RequireInvite = true
 `
 
 func ReadOrCreateConfig(path string) *Config {
-	config := DefaultConfig()
-
 	_, err := os.Stat(path)
 	if err != nil {
 		// File doesn't exist? Try to create it
 
-		log.Println("Config file at", path, "doesn't exist, creating it with template values.")
+		if isTerminal(os.Stdin) {
+			log.Println("Config file at", path, "doesn't exist, running setup.")
+			Check(SetupWizard(os.Stdin, os.Stdout, path))
+			return Unwrap(ReadConfig(path))
+		}
+
+		log.Println("Config file at", path, "doesn't exist, creating it with template values. Run `drasl setup` to be guided through creating it instead.")
 		dir := filepath.Dir(path)
 		err := os.MkdirAll(dir, 0755)
 		Check(err)
@@ -265,8 +1328,20 @@ func ReadOrCreateConfig(path string) *Config {
 		Check(err)
 	}
 
+	config, err := ReadConfig(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
+}
+
+func ReadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+
 	metadata, err := toml.DecodeFile(path, &config)
-	Check(err)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, key := range metadata.Undecoded() {
 		log.Println("Warning: unknown config option", strings.Join(key, "."))
@@ -276,27 +1351,8 @@ func ReadOrCreateConfig(path string) *Config {
 
 	err = CleanConfig(&config)
 	if err != nil {
-		log.Fatal(fmt.Errorf("Error in config: %s", err))
+		return nil, fmt.Errorf("Error in config: %s", err)
 	}
 
-	return &config
-}
-
-func ReadOrCreateKey(config *Config) *rsa.PrivateKey {
-	path := path.Join(config.StateDirectory, "key.pkcs8")
-
-	der, err := os.ReadFile(path)
-	if err == nil {
-		key := Unwrap(x509.ParsePKCS8PrivateKey(der))
-
-		return key.(*rsa.PrivateKey)
-	} else {
-		key := Unwrap(rsa.GenerateKey(rand.Reader, 4096))
-
-		der := Unwrap(x509.MarshalPKCS8PrivateKey(key))
-		err = os.WriteFile(path, der, 0600)
-		Check(err)
-
-		return key
-	}
+	return &config, nil
 }
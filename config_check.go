@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/*
+`drasl check-config <file>` validates a config file without starting the
+server, e.g. in a deployment pipeline before restarting Drasl. Besides
+everything CleanConfig checks, it makes sure the files and commands the config
+refers to can be used, which otherwise only shows up at startup or when the
+file is first needed, and it reads the configs of any Tenants.
+*/
+
+type ConfigCheckResult struct {
+	Errors []string
+	// Unknown options, which are ignored at startup
+	Warnings []string
+}
+
+func (result *ConfigCheckResult) addError(format string, a ...interface{}) {
+	result.Errors = append(result.Errors, fmt.Sprintf(format, a...))
+}
+
+func checkReadableFile(name string, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't open %s: %s", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s %s is a directory", name, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Couldn't open %s: %s", name, err)
+	}
+	return f.Close()
+}
+
+// Check the files and commands config refers to. config must have been
+// cleaned.
+func checkConfigReferences(config *Config, result *ConfigCheckResult) {
+	if config.Branding.LogoFile != "" {
+		if err := checkReadableFile("Branding.LogoFile", config.Branding.LogoFile); err != nil {
+			result.addError("%s", err)
+		}
+	}
+	if config.Branding.FaviconFile != "" {
+		if err := checkReadableFile("Branding.FaviconFile", config.Branding.FaviconFile); err != nil {
+			result.addError("%s", err)
+		}
+	}
+	if config.ExternalAuth.Enable && len(config.ExternalAuth.Command) > 0 {
+		if _, err := exec.LookPath(config.ExternalAuth.Command[0]); err != nil {
+			result.addError("Invalid ExternalAuth.Command: %s", err)
+		}
+	}
+	for _, backupDirectory := range config.TextureCheck.BackupDirectories {
+		if info, err := os.Stat(backupDirectory); err != nil || !info.IsDir() {
+			result.addError("Couldn't open TextureCheck.BackupDirectories directory %s", backupDirectory)
+		}
+	}
+	if len(config.Tenants) > 0 {
+		if _, err := ReadTenantConfigs(config); err != nil {
+			result.addError("%s", err)
+		}
+	}
+}
+
+// Parse and validate the config file at path, collecting every problem found
+func CheckConfigFile(path string) *ConfigCheckResult {
+	result := ConfigCheckResult{}
+
+	config := DefaultConfig()
+	metadata, err := toml.DecodeFile(path, &config)
+	if err != nil {
+		var parseError toml.ParseError
+		if errors.As(err, &parseError) {
+			result.addError("%s", strings.TrimSpace(parseError.ErrorWithPosition()))
+		} else {
+			result.addError("%s", err)
+		}
+		return &result
+	}
+	for _, key := range metadata.Undecoded() {
+		result.Warnings = append(result.Warnings, "Unknown config option "+key.String())
+	}
+
+	if err := CleanConfig(&config); err != nil {
+		result.addError("%s", err)
+		return &result
+	}
+	checkConfigReferences(&config, &result)
+	return &result
+}
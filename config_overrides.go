@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+Some options of the config file can be changed on the Admin settings page
+without restarting Drasl. Each changed option is stored in the database as a
+ConfigOverride, and the overrides are applied on top of the config file at
+startup. Only options that are read whenever they're needed, rather than once
+at startup, can be overridden. Setting an option back to its value in the
+config file removes its override, so it follows the config file again.
+*/
+
+// Options that can be changed on the Admin settings page
+var CONFIG_OVERRIDE_KEYS = []string{
+	"AllowCapes",
+	"AllowSkins",
+	"FallbackAPIServers",
+	"RateLimit.Enable",
+	"RateLimit.RequestsPerSecond",
+	"RegistrationExistingPlayer.Allow",
+	"RegistrationExistingPlayer.RequireApproval",
+	"RegistrationExistingPlayer.RequireInvite",
+	"RegistrationNewPlayer.Allow",
+	"RegistrationNewPlayer.AllowChoosingUUID",
+	"RegistrationNewPlayer.RequireApproval",
+	"RegistrationNewPlayer.RequireInvite",
+	"SkinSizeLimit",
+}
+
+// The field of config named by key, e.g. "RateLimit.Enable"
+func configField(config *Config, key string) (reflect.Value, error) {
+	value := reflect.ValueOf(config).Elem()
+	for _, name := range strings.Split(key, ".") {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("Unknown config option %s", key)
+		}
+		value = value.FieldByName(name)
+		if !value.IsValid() {
+			return reflect.Value{}, fmt.Errorf("Unknown config option %s", key)
+		}
+	}
+	return value, nil
+}
+
+func configFieldsEqual(a reflect.Value, b reflect.Value) bool {
+	// An empty list in the config file is nil, but an empty list from the
+	// settings page may not be
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func GetConfigOverrides(db *gorm.DB) ([]ConfigOverride, error) {
+	var overrides []ConfigOverride
+	if err := db.Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// Apply overrides to a copy of fileConfig and check the result
+func ApplyConfigOverrides(fileConfig *Config, overrides []ConfigOverride) (*Config, error) {
+	config := *fileConfig
+	for _, override := range overrides {
+		if !Contains(CONFIG_OVERRIDE_KEYS, override.Key) {
+			return nil, fmt.Errorf("%s can't be changed on the Admin settings page", override.Key)
+		}
+		field, err := configField(&config, override.Key)
+		if err != nil {
+			return nil, err
+		}
+		// Decoding into a slice would reuse fileConfig's array
+		field.Set(reflect.Zero(field.Type()))
+		if err := json.Unmarshal([]byte(override.Value), field.Addr().Interface()); err != nil {
+			return nil, fmt.Errorf("Invalid value for %s: %s", override.Key, err)
+		}
+	}
+	if err := CleanConfig(&config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// The overrides needed for the options in CONFIG_OVERRIDE_KEYS to have their
+// values in settings, and the config they result in. Returns an error if the
+// result isn't a valid config.
+func (app *App) MakeConfigOverrides(settings *Config) ([]ConfigOverride, *Config, error) {
+	overrides := make([]ConfigOverride, 0)
+	for _, key := range CONFIG_OVERRIDE_KEYS {
+		field, err := configField(settings, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileField, err := configField(&app.FileConfig, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if configFieldsEqual(field, fileField) {
+			continue
+		}
+		value, err := json.Marshal(field.Interface())
+		if err != nil {
+			return nil, nil, err
+		}
+		overrides = append(overrides, ConfigOverride{Key: key, Value: string(value)})
+	}
+	config, err := ApplyConfigOverrides(&app.FileConfig, overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	return overrides, config, nil
+}
+
+// Replace the stored overrides and switch to config, as returned by
+// MakeConfigOverrides. Returns the options whose values changed.
+func (app *App) SetConfigOverrides(overrides []ConfigOverride, config *Config) ([]string, error) {
+	currentConfig := app.Config()
+	changed := make([]string, 0)
+	for _, key := range CONFIG_OVERRIDE_KEYS {
+		field, err := configField(config, key)
+		if err != nil {
+			return nil, err
+		}
+		currentField, err := configField(currentConfig, key)
+		if err != nil {
+			return nil, err
+		}
+		if !configFieldsEqual(field, currentField) {
+			changed = append(changed, key)
+		}
+	}
+
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("true").Delete(&ConfigOverride{}).Error; err != nil {
+			return err
+		}
+		for _, override := range overrides {
+			override.UpdatedAt = time.Now()
+			if err := tx.Create(&override).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Trust the keys of any new fallback API servers. The current keys are
+	// copied, since requests may be reading them.
+	keys := app.publicKeys.Load()
+	profilePropertyKeys := append([]rsa.PublicKey{}, keys.ProfileProperty...)
+	playerCertificateKeys := append([]rsa.PublicKey{}, keys.PlayerCertificate...)
+	for _, fallbackAPIServer := range config.FallbackAPIServers {
+		isNew := true
+		for _, current := range currentConfig.FallbackAPIServers {
+			if current.ServicesURL == fallbackAPIServer.ServicesURL {
+				isNew = false
+				break
+			}
+		}
+		if !isNew {
+			continue
+		}
+		publicKeysRes, err := fetchFallbackAPIServerPublicKeys(app.MakeHTTPClient(), &fallbackAPIServer)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		profilePropertyKeys, playerCertificateKeys = addFallbackAPIServerPublicKeys(publicKeysRes, profilePropertyKeys, playerCertificateKeys)
+	}
+
+	app.publicKeys.Store(&signingPublicKeys{
+		PlayerCertificate: playerCertificateKeys,
+		ProfileProperty:   profilePropertyKeys,
+	})
+	app.config.Store(config)
+	return changed, nil
+}
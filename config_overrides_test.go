@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConfigOverrides(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test settings while serving requests", ts.testSettingsWhileServing)
+	}
+}
+
+// Changing settings swaps the config out from under requests being served.
+// Run with -race to check that's safe.
+func (ts *TestSuite) testSettingsWhileServing(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/admin/settings"
+
+	username := "settingsServingAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, path := range []string{"/drasl/registration", "/drasl/api/v2/info", "/authlib-injector"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				ts.Server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+			}
+		}(path)
+	}
+
+	fileConfig := ts.App.FileConfig
+	for i := 0; i < 10; i += 1 {
+		form := settingsForm(ts.App.Config(), returnURL)
+		form.Set("skinSizeLimit", strconv.Itoa(128+i))
+		if i%2 == 0 {
+			form.Set("registrationNewPlayerAllow", "")
+		}
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, 128+i, ts.App.Config().SkinSizeLimit)
+	}
+	close(done)
+	wg.Wait()
+
+	rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", settingsForm(&fileConfig, returnURL), []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, "", getErrorMessage(rec))
+	assert.Equal(t, 0, len(Unwrap(GetConfigOverrides(ts.App.DB))))
+}
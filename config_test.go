@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"testing"
+	"time"
 )
 
 func configTestConfig(stateDirectory string) *Config {
@@ -34,6 +39,15 @@ func TestConfig(t *testing.T) {
 	config.BaseURL = ":an invalid URL"
 	assert.NotNil(t, CleanConfig(config))
 
+	config = configTestConfig(sd)
+	config.TextureBaseURL = "https://textures.drasl.example.com/"
+	assert.Nil(t, CleanConfig(config))
+	assert.Equal(t, "https://textures.drasl.example.com", config.TextureBaseURL)
+
+	config = configTestConfig(sd)
+	config.TextureBaseURL = "textures.drasl.example.com"
+	assert.NotNil(t, CleanConfig(config))
+
 	config = configTestConfig(sd)
 	config.DefaultPreferredLanguage = "xx"
 	assert.NotNil(t, CleanConfig(config))
@@ -59,6 +73,161 @@ func TestConfig(t *testing.T) {
 	config.StateDirectory = "/tmp/DraslInvalidStateDirectoryNothingHere"
 	assert.Nil(t, CleanConfig(config))
 
+	config = configTestConfig(sd)
+	config.MinPasswordStrength = 5
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.MinPlayerNameLength = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.MinPlayerNameLength = 10
+	config.MaxPlayerNameLength = 5
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.MaxPlayerNameLength = Constants.MaxPlayerNameLength + 1
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ValidPlayerNameRegex = "(unclosed"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Email.Enable = true
+	config.Email.SMTPHost = "smtp.example.com"
+	config.Email.From = "Drasl <drasl@example.com>"
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Email.Enable = true
+	config.Email.From = "drasl@example.com"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Email.Enable = true
+	config.Email.SMTPHost = "smtp.example.com"
+	config.Email.From = "not an address"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Email.Enable = true
+	config.Email.SMTPHost = "smtp.example.com"
+	config.Email.From = "drasl@example.com"
+	config.Email.MessagesPerSecond = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.DeviceLogin.Allow = true
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.DeviceLogin.Allow = true
+	config.DeviceLogin.ExpireSec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.DeviceLogin.Allow = true
+	config.DeviceLogin.PollIntervalSec = -1
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.QRLogin.Allow = true
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.QRLogin.Allow = true
+	config.QRLogin.ExpireSec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.RegistrationApprovalWebhook = "ftp://example.com/hook"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.AuthenticateThrottle.Enable = true
+	config.AuthenticateThrottle.RequestsPerMinute = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.AuthenticateThrottle.Enable = true
+	config.AuthenticateThrottle.Tarpit.Enable = true
+	config.AuthenticateThrottle.Tarpit.MaxDelaySec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.SecurityHeaders.HSTSMaxAgeSec = -1
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Compression.Level = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.RateLimit.IPv6PrefixLength = 129
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.RateLimit.ExemptCIDRs = []string{"not a range"}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.HTTPServer.WriteTimeoutSec = -1
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.HTTPServer.MaxHeaderKiB = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.HTTPServer.EnableHTTP2 = true
+	config.HTTPServer.MaxConcurrentStreams = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.AdminRestrictions.AllowedCIDRs = []string{"192.0.2.0/24", "2001:db8::/32"}
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.AdminRestrictions.DeniedCIDRs = []string{"not a range"}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TrustedProxies = []string{"127.0.0.1"}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.RegistrationRestrictions.AllowedCIDRs = []string{"192.0.2.0/24", "2001:db8::/32"}
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.RegistrationRestrictions.DeniedCIDRs = []string{"192.0.2.1"}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Maintenance.Enable = true
+	config.Maintenance.Message = ""
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ReadOnly.Enable = true
+	config.ReadOnly.Message = ""
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Theme = "nonexistent"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Theme = "../escape"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	assert.Nil(t, os.MkdirAll(path.Join(sd, "themes", "example"), 0700))
+	config.Theme = "example"
+	assert.Nil(t, CleanConfig(config))
+
 	config = configTestConfig(sd)
 	config.RegistrationExistingPlayer.Allow = true
 	config.RegistrationExistingPlayer.Nickname = "Example"
@@ -73,6 +242,22 @@ func TestConfig(t *testing.T) {
 	config.RegistrationExistingPlayer.Nickname = ""
 	assert.NotNil(t, CleanConfig(config))
 
+	config = configTestConfig(sd)
+	config.RegistrationExistingPlayer.Allow = true
+	config.RegistrationExistingPlayer.Sources = []registrationExistingPlayerSource{
+		{Nickname: "A", SessionURL: "https://a.example.com/", AccountURL: "https://a.example.com"},
+		{Nickname: "B", SessionURL: "https://b.example.com", AccountURL: "https://b.example.com"},
+	}
+	assert.Nil(t, CleanConfig(config))
+	assert.Equal(t, "https://a.example.com", config.RegistrationExistingPlayer.Sources[0].SessionURL)
+
+	config.RegistrationExistingPlayer.Sources[1].Nickname = "A"
+	assert.NotNil(t, CleanConfig(config))
+
+	config.RegistrationExistingPlayer.Sources[1].Nickname = "B"
+	config.RegistrationExistingPlayer.Sources[1].AccountURL = ""
+	assert.NotNil(t, CleanConfig(config))
+
 	config = configTestConfig(sd)
 	config.RegistrationExistingPlayer.Allow = true
 	config.RegistrationExistingPlayer.SessionURL = ""
@@ -83,6 +268,40 @@ func TestConfig(t *testing.T) {
 	config.RegistrationExistingPlayer.AccountURL = ""
 	assert.NotNil(t, CleanConfig(config))
 
+	// Account linking needs the existing player sources even if registration
+	// from them isn't allowed
+	config = configTestConfig(sd)
+	config.AccountLinking.Allow = true
+	config.RegistrationExistingPlayer.Nickname = ""
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ProfileImport.Allow = true
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Reports.Allow = true
+	config.Reports.MaxPerDay = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.SessionHistory.Enable = true
+	config.SessionHistory.RetentionDays = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TwoPersonApproval.Enable = true
+	config.TwoPersonApproval.ExpireSec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.OutboundProxy.URL = "socks5://127.0.0.1:1080"
+	assert.Nil(t, CleanConfig(config))
+	config.OutboundProxy.URL = "ftp://127.0.0.1:21"
+	assert.NotNil(t, CleanConfig(config))
+	config.OutboundProxy.URL = "127.0.0.1:8080"
+	assert.NotNil(t, CleanConfig(config))
+
 	config = configTestConfig(sd)
 	testFallbackAPIServer := FallbackAPIServer{
 		Nickname:    "Nickname",
@@ -102,6 +321,24 @@ func TestConfig(t *testing.T) {
 	config.FallbackAPIServers = []FallbackAPIServer{fb}
 	assert.NotNil(t, CleanConfig(config))
 
+	fb = testFallbackAPIServer
+	fb.CACertFile = path.Join(sd, "missing.pem")
+	config.FallbackAPIServers = []FallbackAPIServer{fb}
+	assert.NotNil(t, CleanConfig(config))
+
+	fb = testFallbackAPIServer
+	fb.PinnedPublicKeys = []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+	config.FallbackAPIServers = []FallbackAPIServer{fb}
+	assert.Nil(t, CleanConfig(config))
+	fb.PinnedPublicKeys = []string{"not a hash"}
+	config.FallbackAPIServers = []FallbackAPIServer{fb}
+	assert.NotNil(t, CleanConfig(config))
+
+	fb = testFallbackAPIServer
+	fb.Address = "drasl.example.com"
+	config.FallbackAPIServers = []FallbackAPIServer{fb}
+	assert.NotNil(t, CleanConfig(config))
+
 	fb = testFallbackAPIServer
 	fb.SessionURL = ""
 	config.FallbackAPIServers = []FallbackAPIServer{fb}
@@ -132,8 +369,288 @@ func TestConfig(t *testing.T) {
 	config.FallbackAPIServers = []FallbackAPIServer{fb}
 	assert.NotNil(t, CleanConfig(config))
 
+	fb = testFallbackAPIServer
+	fb.ProxyTextures = true
+	fb.SkinDomains = []string{}
+	config.FallbackAPIServers = []FallbackAPIServer{fb}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.DataEncryption.KeyFile = path.Join(sd, "nonexistent-key")
+	assert.NotNil(t, CleanConfig(config))
+
+	keyFile := path.Join(sd, "short-key")
+	assert.Nil(t, os.WriteFile(keyFile, []byte("c2hvcnQ=\n"), 0600))
+	config = configTestConfig(sd)
+	config.DataEncryption.KeyFile = keyFile
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.EventStream.Enable = true
+	config.EventStream.Tokens = []eventStreamToken{{Token: "short"}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.EventStream.Enable = true
+	config.EventStream.Tokens = []eventStreamToken{{Token: "0123456789abcdef", Events: []string{"teleport"}}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ExternalAuth.Enable = true
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ExternalAuth.Enable = true
+	config.ExternalAuth.URL = "https://auth.example.com/check"
+	config.ExternalAuth.Command = []string{"/usr/local/bin/check-auth"}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ExternalAuth.Enable = true
+	config.ExternalAuth.URL = "ftp://auth.example.com/check"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TrustedServers = []TrustedServer{{Nickname: "proxy", Token: "short"}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TrustedServers = []TrustedServer{
+		{Nickname: "proxy", Token: "0123456789abcdef"},
+		{Nickname: "proxy", Token: "fedcba9876543210"},
+	}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Floodgate.Enable = true
+	config.Floodgate.LinkCodeExpireSec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.InGameCommands.Allow = true
+	config.InGameCommands.CodeExpireSec = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TexturesCompatibility.Profile = "ancient"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.MSACompatibility.Enable = true
+	config.DeviceLogin.Allow = false
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ErrorReporting.Enable = true
+	config.ErrorReporting.DSN = "https://sentry.example.com/42"
+	assert.NotNil(t, CleanConfig(config))
+	config.ErrorReporting.DSN = "https://key@sentry.example.com/42"
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Diagnostics.Enable = true
+	assert.NotNil(t, CleanConfig(config))
+	config.Diagnostics.Token = "0123456789abcdef"
+	assert.Nil(t, CleanConfig(config))
+	config.Diagnostics.ListenAddress = config.ListenAddress
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Tracing.Enable = true
+	config.Tracing.OTLPEndpoint = "localhost:4318"
+	assert.NotNil(t, CleanConfig(config))
+	config.Tracing.OTLPEndpoint = "http://localhost:4318"
+	config.Tracing.SampleRatio = 1.5
+	assert.NotNil(t, CleanConfig(config))
+	config.Tracing.SampleRatio = 0.1
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.AccessLog.Enable = true
+	assert.NotNil(t, CleanConfig(config))
+	config.AccessLog.File = "/var/log/drasl/access.log"
+	assert.Nil(t, CleanConfig(config))
+	config.AccessLog.MaxBackups = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.APITokens.Allow = true
+	config.APITokens.MaxPerUser = 0
+	assert.NotNil(t, CleanConfig(config))
+	config.APITokens.MaxPerUser = 1
+	config.APITokens.RequestsPerMinute = -1
+	assert.NotNil(t, CleanConfig(config))
+	config.APITokens.RequestsPerMinute = 0
+	config.APITokens.DailyQuota = -1
+	assert.NotNil(t, CleanConfig(config))
+	config.APITokens.DailyQuota = 0
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.APIVersions.Serve = []string{}
+	assert.NotNil(t, CleanConfig(config))
+	config.APIVersions.Serve = []string{"v1", "v9"}
+	assert.NotNil(t, CleanConfig(config))
+	config.APIVersions.Serve = []string{"v2"}
+	config.APIVersions.Sunset = map[string]string{"v2": "2027-06-01"}
+	assert.NotNil(t, CleanConfig(config))
+	config.APIVersions.Sunset = map[string]string{"v1": "2027-06-01"}
+	assert.NotNil(t, CleanConfig(config))
+	v1, ok := GetAPIVersion("v1")
+	assert.True(t, ok)
+	v1.DeprecatedAt = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+	config.APIVersions.Sunset = map[string]string{"v1": "June 2027"}
+	assert.NotNil(t, CleanConfig(config))
+	config.APIVersions.Sunset = map[string]string{"v1": "2027-06-01"}
+	assert.Nil(t, CleanConfig(config))
+	v1.DeprecatedAt = time.Time{}
+
+	config = configTestConfig(sd)
+	config.AppearanceHistory.Enable = true
+	config.AppearanceHistory.MaxSnapshots = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.SkinRotation.Allow = true
+	config.SkinRotation.MaxLibrarySkins = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Branding.AccentColor = "teal"
+	assert.NotNil(t, CleanConfig(config))
+	config.Branding.AccentColor = "#008080"
+	assert.Nil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.Bootstrap.Users = []BootstrapUser{{Username: "admin"}}
+	assert.NotNil(t, CleanConfig(config))
+	config.Bootstrap.Users[0].Password = "hunter2"
+	assert.Nil(t, CleanConfig(config))
+	config.Bootstrap.Users[0].PasswordFile = "/run/secrets/drasl-admin"
+	assert.NotNil(t, CleanConfig(config))
+	config.Bootstrap.Users[0].Password = ""
+	config.Bootstrap.Capes = []BootstrapCape{{Name: "Founder"}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.CustomPages = []CustomPage{{Name: "Rules", Slug: "Rules!", File: "/etc/drasl/rules.md"}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.CustomPages = []CustomPage{{Name: "Rules", Slug: "rules", File: "/etc/drasl/rules.md", URL: "https://example.com"}}
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.CustomPages = []CustomPage{{Name: "Terms", Slug: "terms", File: "/etc/drasl/terms.md"}}
+	config.TermsOfService.Page = "terms"
+	config.TermsOfService.RequireAcceptance = true
+	assert.NotNil(t, CleanConfig(config))
+	config.TermsOfService.Version = "1"
+	assert.Nil(t, CleanConfig(config))
+	config.TermsOfService.Page = "privacy"
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TextureQueue.Enable = true
+	config.TextureQueue.Workers = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TextureQueue.Enable = true
+	config.TextureQueue.QueueSize = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ChunkedUploads.Enable = true
+	config.ChunkedUploads.ChunkSizeKiB = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.ChunkedUploads.Enable = true
+	config.BodyLimit.Enable = true
+	config.BodyLimit.SizeLimitKiB = config.ChunkedUploads.ChunkSizeKiB
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.PlayerSearch.MaxResults = 0
+	assert.NotNil(t, CleanConfig(config))
+
+	config = configTestConfig(sd)
+	config.TextureCheck.Enable = true
+	config.TextureCheck.IntervalHours = 0
+	assert.NotNil(t, CleanConfig(config))
+
 	// Test that TEMPLATE_CONFIG_FILE is valid
 	var templateConfig Config
 	_, err := toml.Decode(TEMPLATE_CONFIG_FILE, &templateConfig)
 	assert.Nil(t, err)
 }
+
+func TestOutboundProxy(t *testing.T) {
+	proxied := make([]string, 0)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests through an HTTP proxy carry the whole URL
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	client := makeHTTPClient(MakeHTTPTransport(&Config{OutboundProxy: outboundProxyConfig{
+		URL:     proxy.URL,
+		NoProxy: []string{".internal.example.com"},
+	}}))
+
+	res, err := client.Get("http://textures.example.com/texture/abc")
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	assert.Equal(t, []string{"http://textures.example.com/texture/abc"}, proxied)
+
+	// Hosts in NoProxy are requested directly, and don't exist here
+	_, err = client.Get("http://drasl.internal.example.com/")
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(proxied))
+}
+
+func TestCheckConfigFile(t *testing.T) {
+	sd := Unwrap(os.MkdirTemp("", "tmp"))
+	defer os.RemoveAll(sd)
+
+	write := func(contents string) string {
+		configPath := path.Join(sd, "config.toml")
+		assert.Nil(t, os.WriteFile(configPath, []byte(contents), 0644))
+		return configPath
+	}
+	base := fmt.Sprintf("Domain = \"drasl.example.com\"\nBaseURL = \"https://drasl.example.com\"\nStateDirectory = %q\nDataDirectory = \".\"\n", sd)
+
+	result := CheckConfigFile(write(base))
+	assert.Empty(t, result.Errors)
+	assert.Empty(t, result.Warnings)
+
+	// Syntax errors point at the line
+	result = CheckConfigFile(write(base + "InstanceName = \"Drasl\n"))
+	assert.Equal(t, 1, len(result.Errors))
+	assert.Contains(t, result.Errors[0], "line 5")
+
+	// Unknown options are only warnings
+	result = CheckConfigFile(write(base + "NotAnOption = true\n"))
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []string{"Unknown config option NotAnOption"}, result.Warnings)
+
+	result = CheckConfigFile(write(base + "ValidPlayerNameRegex = \"[\"\n"))
+	assert.Equal(t, 1, len(result.Errors))
+
+	// Every missing file is reported
+	result = CheckConfigFile(write(base + `
+[Branding]
+LogoFile = "/nonexistent/logo.png"
+FaviconFile = "/nonexistent/favicon.ico"
+
+[TextureCheck]
+BackupDirectories = ["/nonexistent/backup"]
+`))
+	assert.Equal(t, 3, len(result.Errors))
+
+	result = CheckConfigFile(write(base + "[DataEncryption]\nKeyFile = \"/nonexistent/data.key\"\n"))
+	assert.Equal(t, 1, len(result.Errors))
+}
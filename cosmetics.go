@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Cosmetics are capes, and maybe other extras in the future, that admins grant
+to users, either one at a time or to every member of a group. A user can wear
+any cape they're entitled to from their profile page or from a launcher, even
+if AllowCapes is off, and loses it when it's revoked. Launchers see a user's
+cosmetics in the capes of /minecraft/profile and in /entitlements.
+*/
+
+const COSMETIC_KIND_CAPE = "cape"
+
+const MAX_COSMETIC_NAME_LENGTH = 64
+
+var errCosmeticNotFound = errors.New("cosmetic not found")
+var errCosmeticNotEntitled = errors.New("not entitled to cosmetic")
+var errCosmeticNameTaken = errors.New("cosmetic name taken")
+
+type Cosmetic struct {
+	UUID        string `gorm:"primaryKey"`
+	Name        string `gorm:"unique;not null;type:text collate nocase"`
+	Kind        string `gorm:"not null"`
+	TextureHash string `gorm:"not null;index"`
+	CreatedAt   time.Time
+}
+
+// A cosmetic granted to a single user
+type Entitlement struct {
+	CosmeticUUID string `gorm:"primaryKey"`
+	UserUUID     string `gorm:"primaryKey;index"`
+	CreatedAt    time.Time
+}
+
+// A cosmetic granted to every member of a group
+type GroupEntitlement struct {
+	CosmeticUUID string `gorm:"primaryKey"`
+	GroupID      uint   `gorm:"primaryKey;index"`
+	CreatedAt    time.Time
+}
+
+func ValidateCosmeticName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("can't be blank")
+	}
+	if utf8.RuneCountInString(name) > MAX_COSMETIC_NAME_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_COSMETIC_NAME_LENGTH)
+	}
+	return nil
+}
+
+// Add a cape, read from `capeReader`, that can be granted to users
+func (app *App) CreateCapeCosmetic(name string, capeReader io.Reader) (*Cosmetic, error) {
+	if err := ValidateCosmeticName(name); err != nil {
+		return nil, err
+	}
+
+	validCapeHandle, err := ValidateCape(app, capeReader)
+	if err != nil {
+		return nil, err
+	}
+	buf, capeHash, err := ReadTexture(app, validCapeHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	cosmetic := Cosmetic{
+		UUID:        uuid.New().String(),
+		Name:        name,
+		Kind:        COSMETIC_KIND_CAPE,
+		TextureHash: capeHash,
+		CreatedAt:   time.Now(),
+	}
+	if err := WriteCape(app, capeHash, buf); err != nil {
+		return nil, err
+	}
+	if err := app.DB.Create(&cosmetic).Error; err != nil {
+		if cleanupErr := DeleteCapeIfUnused(app, &capeHash); cleanupErr != nil && !os.IsNotExist(cleanupErr) {
+			return nil, cleanupErr
+		}
+		if IsErrorUniqueFailed(err) {
+			return nil, errCosmeticNameTaken
+		}
+		return nil, err
+	}
+	return &cosmetic, nil
+}
+
+func (app *App) GetCosmetic(cosmeticUUID string) (*Cosmetic, error) {
+	var cosmetic Cosmetic
+	if err := app.DB.First(&cosmetic, "uuid = ?", cosmeticUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errCosmeticNotFound
+		}
+		return nil, err
+	}
+	return &cosmetic, nil
+}
+
+func (app *App) GetCosmetics() ([]Cosmetic, error) {
+	var cosmetics []Cosmetic
+	err := app.DB.Order("name").Find(&cosmetics).Error
+	return cosmetics, err
+}
+
+// Delete a cosmetic and every grant of it. Users wearing it lose it too.
+func (app *App) DeleteCosmetic(cosmetic *Cosmetic) error {
+	var wearers []User
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("cosmetic_uuid = ?", cosmetic.UUID).Delete(&Entitlement{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("cosmetic_uuid = ?", cosmetic.UUID).Delete(&GroupEntitlement{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(cosmetic).Error; err != nil {
+			return err
+		}
+		return tx.Where("cape_hash = ?", cosmetic.TextureHash).Find(&wearers).Error
+	})
+	if err != nil {
+		return err
+	}
+	for _, wearer := range wearers {
+		if err := app.takeOffCosmeticIfNotEntitled(&wearer, cosmetic); err != nil {
+			return err
+		}
+	}
+	if err := DeleteCapeIfUnused(app, &cosmetic.TextureHash); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// The cosmetics granted to user, directly or through one of their groups
+func (app *App) GetEntitledCosmetics(user *User) ([]Cosmetic, error) {
+	var cosmetics []Cosmetic
+	err := app.DB.
+		Where("uuid IN (?)", app.DB.Model(&Entitlement{}).Select("cosmetic_uuid").Where("user_uuid = ?", user.UUID)).
+		Or("uuid IN (?)", app.DB.Model(&GroupEntitlement{}).
+			Select("cosmetic_uuid").
+			Joins("JOIN group_memberships ON group_memberships.group_id = group_entitlements.group_id").
+			Where("group_memberships.user_uuid = ?", user.UUID)).
+		Order("name").
+		Find(&cosmetics).Error
+	return cosmetics, err
+}
+
+func (app *App) IsEntitled(user *User, cosmetic *Cosmetic) (bool, error) {
+	cosmetics, err := app.GetEntitledCosmetics(user)
+	if err != nil {
+		return false, err
+	}
+	for _, entitled := range cosmetics {
+		if entitled.UUID == cosmetic.UUID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (app *App) GrantCosmetic(cosmetic *Cosmetic, user *User) error {
+	entitlement := Entitlement{
+		CosmeticUUID: cosmetic.UUID,
+		UserUUID:     user.UUID,
+		CreatedAt:    time.Now(),
+	}
+	return app.DB.Where(&Entitlement{CosmeticUUID: cosmetic.UUID, UserUUID: user.UUID}).FirstOrCreate(&entitlement).Error
+}
+
+func (app *App) RevokeCosmetic(cosmetic *Cosmetic, user *User) error {
+	err := app.DB.Where("cosmetic_uuid = ? AND user_uuid = ?", cosmetic.UUID, user.UUID).Delete(&Entitlement{}).Error
+	if err != nil {
+		return err
+	}
+	return app.takeOffCosmeticIfNotEntitled(user, cosmetic)
+}
+
+func (app *App) GrantCosmeticToGroup(cosmetic *Cosmetic, group *Group) error {
+	entitlement := GroupEntitlement{
+		CosmeticUUID: cosmetic.UUID,
+		GroupID:      group.ID,
+		CreatedAt:    time.Now(),
+	}
+	return app.DB.Where(&GroupEntitlement{CosmeticUUID: cosmetic.UUID, GroupID: group.ID}).FirstOrCreate(&entitlement).Error
+}
+
+func (app *App) RevokeCosmeticFromGroup(cosmetic *Cosmetic, group *Group) error {
+	err := app.DB.Where("cosmetic_uuid = ? AND group_id = ?", cosmetic.UUID, group.ID).Delete(&GroupEntitlement{}).Error
+	if err != nil {
+		return err
+	}
+	members, err := app.GetGroupMembers(group)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err := app.takeOffCosmeticIfNotEntitled(&member, cosmetic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The users granted a cosmetic directly, and the groups granted it
+func (app *App) GetCosmeticGrants(cosmetic *Cosmetic) ([]User, []Group, error) {
+	var users []User
+	err := app.DB.
+		Joins("JOIN entitlements ON entitlements.user_uuid = users.uuid").
+		Where("entitlements.cosmetic_uuid = ?", cosmetic.UUID).
+		Order("users.username").
+		Find(&users).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	var groups []Group
+	err = app.DB.
+		Joins("JOIN group_entitlements ON group_entitlements.group_id = groups.id").
+		Where("group_entitlements.cosmetic_uuid = ?", cosmetic.UUID).
+		Order("groups.name").
+		Find(&groups).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, groups, nil
+}
+
+// Put on a cosmetic user is entitled to
+func (app *App) WearCosmetic(user *User, cosmetic *Cosmetic) error {
+	if user.CapeLocked {
+		return errCapeLocked
+	}
+	entitled, err := app.IsEntitled(user, cosmetic)
+	if err != nil {
+		return err
+	}
+	if !entitled {
+		return errCosmeticNotEntitled
+	}
+	oldCapeHash := UnmakeNullString(&user.CapeHash)
+	user.CapeHash = MakeNullString(&cosmetic.TextureHash)
+	if err := app.DB.Save(user).Error; err != nil {
+		return err
+	}
+	if err := app.RecordAppearance(user.UUID); err != nil {
+		return err
+	}
+	if !PtrEquals(oldCapeHash, &cosmetic.TextureHash) {
+		if err := DeleteCapeIfUnused(app, oldCapeHash); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove the cape of a user wearing cosmetic who is no longer entitled to it
+func (app *App) takeOffCosmeticIfNotEntitled(user *User, cosmetic *Cosmetic) error {
+	if !PtrEquals(UnmakeNullString(&user.CapeHash), &cosmetic.TextureHash) {
+		return nil
+	}
+	entitled, err := app.IsEntitled(user, cosmetic)
+	if err != nil {
+		return err
+	}
+	if entitled {
+		return nil
+	}
+	return SetCapeAndSave(app, user, nil)
+}
+
+func (app *App) getGroupCosmetics(group *Group) ([]Cosmetic, error) {
+	var cosmetics []Cosmetic
+	err := app.DB.
+		Where("uuid IN (?)", app.DB.Model(&GroupEntitlement{}).Select("cosmetic_uuid").Where("group_id = ?", group.ID)).
+		Find(&cosmetics).Error
+	return cosmetics, err
+}
+
+// Take the cosmetics of a group off users who have left it, or of every
+// member of a group that's been deleted. cosmetics should be looked up before
+// the users leave.
+func (app *App) takeOffGroupCosmetics(cosmetics []Cosmetic, users []User) error {
+	for _, cosmetic := range cosmetics {
+		for _, user := range users {
+			if err := app.takeOffCosmeticIfNotEntitled(&user, &cosmetic); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
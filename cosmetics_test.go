@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestCosmetics(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.AllowCapes = false
+		config.DefaultAdmins = []string{"Bob"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test cosmetics", ts.testCosmetics)
+	}
+}
+
+func (ts *TestSuite) createCosmetic(t *testing.T, name string, cape []byte, accessToken *string) *apiCosmetic {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	assert.Nil(t, writer.WriteField("name", name))
+	part, err := writer.CreateFormFile("file", "cape.png")
+	assert.Nil(t, err)
+	_, err = part.Write(cape)
+	assert.Nil(t, err)
+	rec := ts.PostMultipart(t, ts.Server, "/drasl/api/v1/admin/cosmetics", body, writer, nil, accessToken)
+	if rec.Code != http.StatusOK {
+		return nil
+	}
+	var cosmetic apiCosmetic
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&cosmetic))
+	return &cosmetic
+}
+
+func (ts *TestSuite) getServicesProfileCapes(t *testing.T, accessToken *string) []ServicesProfileCape {
+	rec := ts.Get(t, ts.Server, "/minecraft/profile", nil, accessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var profile ServicesProfile
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&profile))
+	return profile.Capes
+}
+
+func (ts *TestSuite) testCosmetics(t *testing.T) {
+	ts.CreateTestUser(ts.Server, "Bob")
+	browserTokenCookie := ts.CreateTestUser(ts.Server, "Alice")
+	var alice User
+	assert.Nil(t, ts.App.DB.First(&alice, "username = ?", "Alice").Error)
+
+	accessToken := ts.authenticate(t, "Bob", TEST_PASSWORD).AccessToken
+	aliceAccessToken := ts.authenticate(t, "Alice", TEST_PASSWORD).AccessToken
+
+	// Only admins can manage cosmetics
+	assert.Nil(t, ts.createCosmetic(t, "Red", RED_CAPE, &aliceAccessToken))
+
+	red := ts.createCosmetic(t, "Red", RED_CAPE, &accessToken)
+	assert.NotNil(t, red)
+	blue := ts.createCosmetic(t, "Blue", BLUE_CAPE, &accessToken)
+	assert.NotNil(t, blue)
+	assert.Nil(t, ts.createCosmetic(t, "red", BLUE_CAPE, &accessToken))
+
+	{
+		// Nothing is granted yet
+		assert.Equal(t, 0, len(ts.getServicesProfileCapes(t, &aliceAccessToken)))
+		rec := ts.PutJSON(t, ts.Server, "/minecraft/profile/capes/active", showCapeRequest{CapeID: red.ID}, nil, &aliceAccessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+	{
+		// Grant a cape to a user, who can wear it even though AllowCapes is
+		// off
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/cosmetics/"+red.ID+"/grant", apiCosmeticGrantRequest{UserUUID: &alice.UUID}, nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var cosmetic apiCosmetic
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&cosmetic))
+		assert.Equal(t, []string{alice.UUID}, cosmetic.Users)
+
+		capes := ts.getServicesProfileCapes(t, &aliceAccessToken)
+		assert.Equal(t, 1, len(capes))
+		assert.Equal(t, "Red", capes[0].Alias)
+		assert.Equal(t, "INACTIVE", capes[0].State)
+
+		rec = ts.PutJSON(t, ts.Server, "/minecraft/profile/capes/active", showCapeRequest{CapeID: red.ID}, nil, &aliceAccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var profile ServicesProfile
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&profile))
+		assert.Equal(t, "ACTIVE", profile.Capes[0].State)
+		assert.Nil(t, ts.App.DB.First(&alice, "uuid = ?", alice.UUID).Error)
+		assert.True(t, alice.CapeHash.Valid)
+	}
+	{
+		// Grant a cape to a group, and wear it from the profile page
+		group := Group{Name: "Blues"}
+		assert.Nil(t, ts.App.DB.Create(&group).Error)
+		assert.Nil(t, ts.App.DB.Create(&GroupMembership{GroupID: group.ID, UserUUID: alice.UUID}).Error)
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/cosmetics/"+blue.ID+"/grant", apiCosmeticGrantRequest{Group: Ptr("blues")}, nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		form := url.Values{}
+		form.Set("cosmeticId", blue.ID)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec = ts.PostForm(t, ts.Server, "/drasl/wear-cosmetic", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		capes := ts.getServicesProfileCapes(t, &aliceAccessToken)
+		assert.Equal(t, []string{"Blue", "Red"}, []string{capes[0].Alias, capes[1].Alias})
+		assert.Equal(t, "ACTIVE", capes[0].State)
+	}
+	{
+		// The entitlements list the game and the cosmetics, signed with our
+		// key
+		rec := ts.Get(t, ts.Server, "/entitlements/mcstore", nil, &aliceAccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response entitlementsResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		names := make([]string, 0, len(response.Items))
+		for _, item := range response.Items {
+			names = append(names, item.Name)
+		}
+		assert.Equal(t, []string{"product_minecraft", "game_minecraft", "cape", "cape"}, names)
+		assert.Equal(t, "Blue", response.Items[2].Alias)
+
+		var claims entitlementClaims
+		_, err := jwt.ParseWithClaims(response.Signature, &claims, func(token *jwt.Token) (interface{}, error) {
+			return &ts.App.Key.PublicKey, nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, alice.UUID, claims.Subject)
+		assert.Equal(t, 4, len(claims.Entitlements))
+	}
+	{
+		// Revoking the group's cape takes it off
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/cosmetics/"+blue.ID+"/revoke", apiCosmeticGrantRequest{Group: Ptr("Blues")}, nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, ts.App.DB.First(&alice, "uuid = ?", alice.UUID).Error)
+		assert.False(t, alice.CapeHash.Valid)
+		assert.Equal(t, 1, len(ts.getServicesProfileCapes(t, &aliceAccessToken)))
+
+		// Either a user or a group must be given
+		rec = ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/cosmetics/"+blue.ID+"/revoke", apiCosmeticGrantRequest{}, nil, &accessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+	{
+		// Deleting a cosmetic takes it off its wearers
+		rec := ts.PutJSON(t, ts.Server, "/minecraft/profile/capes/active", showCapeRequest{CapeID: red.ID}, nil, &aliceAccessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		req := ts.Get(t, ts.Server, "/drasl/api/v1/admin/cosmetics", nil, &accessToken)
+		var response apiCosmetics
+		assert.Nil(t, json.NewDecoder(req.Body).Decode(&response))
+		assert.Equal(t, 2, len(response.Cosmetics))
+
+		assert.Nil(t, ts.App.DeleteCosmetic(Unwrap(ts.App.GetCosmetic(red.ID))))
+		assert.Nil(t, ts.App.DB.First(&alice, "uuid = ?", alice.UUID).Error)
+		assert.False(t, alice.CapeHash.Valid)
+		assert.Equal(t, 0, len(ts.getServicesProfileCapes(t, &aliceAccessToken)))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"html/template"
+	"os"
+	"time"
+)
+
+/*
+CustomPages are extra pages linked from the footer of the web front end, like
+a privacy policy or community rules. Pages with a File are rendered from
+Markdown when they're requested, so they can be edited without restarting
+Drasl; pages with a URL just link elsewhere.
+
+If TermsOfService.RequireAcceptance is set, registering requires accepting the
+page named by TermsOfService.Page, and the version accepted is recorded on the
+user. When TermsOfService.Version changes, users are asked to accept the new
+terms the next time they use the web front end.
+*/
+
+// The CustomPage with a File served at /drasl/pages/<slug>, or nil
+func (app *App) GetCustomPage(slug string) *CustomPage {
+	for i := range app.Config().CustomPages {
+		page := &app.Config().CustomPages[i]
+		if page.File != "" && page.Slug == slug {
+			return page
+		}
+	}
+	return nil
+}
+
+func CustomPageURL(app *App, page CustomPage) string {
+	if page.URL != "" {
+		return page.URL
+	}
+	return app.FrontEndURL + "/drasl/pages/" + page.Slug
+}
+
+func (app *App) RenderCustomPage(page *CustomPage) (template.HTML, error) {
+	markdown, err := os.ReadFile(page.File)
+	if err != nil {
+		return "", err
+	}
+	return RenderMarkdown(string(markdown))
+}
+
+// URL of the terms of service, or "" if there are none
+func (app *App) TermsOfServiceURL() string {
+	page := app.GetCustomPage(app.Config().TermsOfService.Page)
+	if page == nil {
+		return ""
+	}
+	return CustomPageURL(app, *page)
+}
+
+// Whether the user has yet to accept the current terms of service
+func (app *App) MustAcceptTerms(user *User) bool {
+	if !app.Config().TermsOfService.RequireAcceptance {
+		return false
+	}
+	return !user.AcceptedTermsVersion.Valid || user.AcceptedTermsVersion.String != app.Config().TermsOfService.Version
+}
+
+func (app *App) AcceptTerms(user *User) error {
+	user.AcceptedTermsVersion = MakeNullString(&app.Config().TermsOfService.Version)
+	user.AcceptedTermsAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return app.DB.Model(user).Updates(map[string]interface{}{
+		"accepted_terms_version": user.AcceptedTermsVersion,
+		"accepted_terms_at":      user.AcceptedTermsAt,
+	}).Error
+}
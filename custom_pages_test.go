@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCustomPages(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		pagesDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(pagesDirectory)
+		termsPath := filepath.Join(pagesDirectory, "terms.md")
+		Check(os.WriteFile(termsPath, []byte("Be **nice**.\n\n<script>alert(1)</script>\n"), 0644))
+
+		config := testConfig()
+		config.CustomPages = []CustomPage{
+			{Name: "Terms of Service", Slug: "terms", File: termsPath},
+			{Name: "Discord", URL: "https://discord.example.com"},
+		}
+		config.TermsOfService = termsOfServiceConfig{
+			Page:              "terms",
+			Version:           "1",
+			RequireAcceptance: true,
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test custom pages", ts.testCustomPages)
+		t.Run("Test terms of service", ts.testTermsOfService)
+	}
+}
+
+func (ts *TestSuite) testCustomPages(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/drasl/pages/terms", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, "<strong>nice</strong>")
+	assert.NotContains(t, body, "<script>alert(1)</script>")
+	assert.Contains(t, body, `href="https://discord.example.com"`)
+
+	// Link pages aren't served
+	rec = ts.Get(t, ts.Server, "/drasl/pages/discord", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func (ts *TestSuite) testTermsOfService(t *testing.T) {
+	form := url.Values{}
+	form.Set("username", TEST_USERNAME)
+	form.Set("password", TEST_PASSWORD)
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
+	rec := ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	assert.Equal(t, "You must accept the Terms of Service.", getErrorMessage(rec))
+
+	form.Set("acceptTerms", "on")
+	rec = ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	assert.Equal(t, "", getErrorMessage(rec))
+	browserTokenCookie := getCookie(rec, "browserToken")
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, "1", user.AcceptedTermsVersion.String)
+	assert.True(t, user.AcceptedTermsAt.Valid)
+
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.NotContains(t, rec.Body.String(), "/drasl/accept-terms")
+
+	// Users are asked to accept new versions of the terms
+	ts.App.Config().TermsOfService.Version = "2"
+	defer func() { ts.App.Config().TermsOfService.Version = "1" }()
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Contains(t, rec.Body.String(), "/drasl/accept-terms")
+
+	form = url.Values{}
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec = ts.PostForm(t, ts.Server, "/drasl/accept-terms", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, "You must accept the Terms of Service.", getErrorMessage(rec))
+
+	form.Set("acceptTerms", "on")
+	rec = ts.PostForm(t, ts.Server, "/drasl/accept-terms", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, "2", user.AcceptedTermsVersion.String)
+
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.NotContains(t, rec.Body.String(), "/drasl/accept-terms")
+}
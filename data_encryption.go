@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"lukechampine.com/blake3"
+	"os"
+	"strings"
+)
+
+/*
+Optional application-level encryption of sensitive database columns, for
+when the database file or its backups are stored somewhere less trusted than
+the key. Values are encrypted with AES-256-GCM just before they're written
+and decrypted as they're read, so the rest of Drasl only ever sees
+plaintext. Encrypted columns can't be searched, so only columns that are
+never queried by value are encrypted.
+*/
+
+// Encrypted values look like drasl-enc:<key ID>:<base64 nonce and ciphertext>
+const ENCRYPTED_VALUE_PREFIX = "drasl-enc:"
+
+const DATA_KEY_BYTES = 32
+
+type dataKey struct {
+	ID   string
+	AEAD cipher.AEAD
+}
+
+type DataCipher struct {
+	current *dataKey
+	// By ID, including the current key
+	keys map[string]*dataKey
+}
+
+func readDataKey(keyFile string) (*dataKey, error) {
+	contents, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("%s must contain a base64-encoded key: %s", keyFile, err)
+	}
+	if len(key) != DATA_KEY_BYTES {
+		return nil, fmt.Errorf("%s must contain a %d-byte key", keyFile, DATA_KEY_BYTES)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// Identifies the key without revealing it
+	sum := blake3.Sum256(key)
+	return &dataKey{ID: hex.EncodeToString(sum[:4]), AEAD: aead}, nil
+}
+
+// Returns nil if DataEncryption isn't configured
+func LoadDataCipher(config *dataEncryptionConfig) (*DataCipher, error) {
+	if config.KeyFile == "" {
+		return nil, nil
+	}
+	current, err := readDataKey(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	dataCipher := DataCipher{
+		current: current,
+		keys:    map[string]*dataKey{current.ID: current},
+	}
+	for _, keyFile := range config.PreviousKeyFiles {
+		key, err := readDataKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		dataCipher.keys[key.ID] = key
+	}
+	return &dataCipher, nil
+}
+
+func (dataCipher *DataCipher) Encrypt(plaintext string) (string, error) {
+	aead := dataCipher.current.AEAD
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return ENCRYPTED_VALUE_PREFIX + dataCipher.current.ID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Values that aren't encrypted, e.g. ones written before encryption was
+// turned on, are returned as they are
+func (dataCipher *DataCipher) Decrypt(value string) (string, error) {
+	if !strings.HasPrefix(value, ENCRYPTED_VALUE_PREFIX) {
+		return value, nil
+	}
+	keyID, encoded, found := strings.Cut(strings.TrimPrefix(value, ENCRYPTED_VALUE_PREFIX), ":")
+	if !found {
+		return "", errors.New("malformed encrypted value")
+	}
+	key, ok := dataCipher.keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("value was encrypted with unknown key %s; add it to DataEncryption.PreviousKeyFiles", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	nonceSize := key.AEAD.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("malformed encrypted value")
+	}
+	plaintext, err := key.AEAD.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+type dataCipherContextKey struct{}
+
+// Make the hooks of models with sensitive columns encrypt and decrypt them
+// for every query made through db
+func WithDataCipher(db *gorm.DB, dataCipher *DataCipher) *gorm.DB {
+	return db.WithContext(context.WithValue(context.Background(), dataCipherContextKey{}, dataCipher))
+}
+
+// app.DB with ctx, e.g. a request's context so its queries are traced.
+// gorm's WithContext would replace the context carrying the DataCipher.
+func (app *App) DBWithContext(ctx context.Context) *gorm.DB {
+	if dataCipher := getDataCipher(app.DB); dataCipher != nil {
+		ctx = context.WithValue(ctx, dataCipherContextKey{}, dataCipher)
+	}
+	return app.DB.WithContext(ctx)
+}
+
+func getDataCipher(tx *gorm.DB) *DataCipher {
+	if tx.Statement.Context == nil {
+		return nil
+	}
+	dataCipher, _ := tx.Statement.Context.Value(dataCipherContextKey{}).(*DataCipher)
+	return dataCipher
+}
+
+func encryptColumns(tx *gorm.DB, columns []*sql.NullString) error {
+	dataCipher := getDataCipher(tx)
+	if dataCipher == nil {
+		return nil
+	}
+	for _, column := range columns {
+		// Never encrypt twice, e.g. if a previous save failed before the
+		// value could be decrypted again
+		if !column.Valid || strings.HasPrefix(column.String, ENCRYPTED_VALUE_PREFIX) {
+			continue
+		}
+		encrypted, err := dataCipher.Encrypt(column.String)
+		if err != nil {
+			return err
+		}
+		column.String = encrypted
+	}
+	return nil
+}
+
+func decryptColumns(tx *gorm.DB, columns []*sql.NullString) error {
+	dataCipher := getDataCipher(tx)
+	if dataCipher == nil {
+		return nil
+	}
+	for _, column := range columns {
+		if !column.Valid {
+			continue
+		}
+		decrypted, err := dataCipher.Decrypt(column.String)
+		if err != nil {
+			return err
+		}
+		column.String = decrypted
+	}
+	return nil
+}
+
+// Re-encrypt every sensitive column with the current key, including values
+// that were written before encryption was turned on. Returns the number of
+// users updated; their session records are re-encrypted too.
+func RotateDataKey(db *gorm.DB) (int, error) {
+	if getDataCipher(db) == nil {
+		return 0, errors.New("DataEncryption.KeyFile is not set")
+	}
+	count := 0
+	var users []User
+	result := db.FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+		for i := range users {
+			// Loading the user decrypted its columns; saving them encrypts
+			// them again with the current key
+			if err := db.Model(&users[i]).Select("email", "join_ip").Updates(&users[i]).Error; err != nil {
+				return err
+			}
+			count += 1
+		}
+		return nil
+	})
+	if result.Error != nil {
+		return count, result.Error
+	}
+
+	var records []SessionRecord
+	result = db.FindInBatches(&records, 100, func(tx *gorm.DB, batch int) error {
+		for i := range records {
+			if err := db.Model(&records[i]).Select("ip").Updates(&records[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return count, result.Error
+}
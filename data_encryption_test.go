@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const TEST_EMAIL = "player@example.com"
+
+func writeTestDataKey(directory string, name string) string {
+	key := make([]byte, DATA_KEY_BYTES)
+	Unwrap(rand.Read(key))
+	keyFile := path.Join(directory, name)
+	Check(os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	return keyFile
+}
+
+func TestDataEncryption(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		keyDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(keyDirectory)
+
+		config := testConfig()
+		config.DataEncryption.KeyFile = writeTestDataKey(keyDirectory, "data.key")
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test encrypting sensitive columns", ts.testEncryptSensitiveColumns)
+		t.Run("Test rotating the data key", func(t *testing.T) { ts.testRotateDataKey(t, keyDirectory) })
+	}
+}
+
+func (ts *TestSuite) getRawEmail(t *testing.T) string {
+	var email string
+	assert.Nil(t, ts.App.DB.Raw("SELECT email FROM users WHERE username = ?", TEST_USERNAME).Scan(&email).Error)
+	return email
+}
+
+func (ts *TestSuite) testEncryptSensitiveColumns(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	user.Email = MakeNullString(Ptr(TEST_EMAIL))
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	// The saved struct should still hold the plaintext
+	assert.Equal(t, TEST_EMAIL, user.Email.String)
+
+	rawEmail := ts.getRawEmail(t)
+	assert.True(t, strings.HasPrefix(rawEmail, ENCRYPTED_VALUE_PREFIX))
+	assert.NotContains(t, rawEmail, TEST_EMAIL)
+
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, TEST_EMAIL, user.Email.String)
+
+	// Values written before encryption was enabled are still readable
+	assert.Nil(t, ts.App.DB.Exec("UPDATE users SET email = ? WHERE username = ?", TEST_EMAIL, TEST_USERNAME).Error)
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, TEST_EMAIL, user.Email.String)
+
+	// Encrypted emails can still be used to sign in once verified
+	user.EmailVerified = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+	assert.True(t, strings.HasPrefix(ts.getRawEmail(t), ENCRYPTED_VALUE_PREFIX))
+	var found User
+	assert.Nil(t, FindUserByUsernameOrPlayerName(ts.App.DB, &found, TEST_EMAIL))
+	assert.Equal(t, user.UUID, found.UUID)
+}
+
+func (ts *TestSuite) testRotateDataKey(t *testing.T, keyDirectory string) {
+	oldKeyFile := ts.Config.DataEncryption.KeyFile
+	newKeyFile := writeTestDataKey(keyDirectory, "new-data.key")
+	newKey := Unwrap(readDataKey(newKeyFile))
+
+	dataCipher, err := LoadDataCipher(&dataEncryptionConfig{
+		KeyFile:          newKeyFile,
+		PreviousKeyFiles: []string{oldKeyFile},
+	})
+	assert.Nil(t, err)
+	db := WithDataCipher(ts.App.DB, dataCipher)
+
+	count, err := RotateDataKey(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	rawEmail := ts.getRawEmail(t)
+	assert.True(t, strings.HasPrefix(rawEmail, ENCRYPTED_VALUE_PREFIX+newKey.ID+":"))
+
+	// The new key alone is enough to read the data
+	dataCipher = Unwrap(LoadDataCipher(&dataEncryptionConfig{KeyFile: newKeyFile}))
+	var user User
+	assert.Nil(t, WithDataCipher(ts.App.DB, dataCipher).First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, TEST_EMAIL, user.Email.String)
+
+	// The old key isn't
+	dataCipher = Unwrap(LoadDataCipher(&dataEncryptionConfig{KeyFile: oldKeyFile}))
+	assert.NotNil(t, WithDataCipher(ts.App.DB, dataCipher).First(&user, "username = ?", TEST_USERNAME).Error)
+}
@@ -26,7 +26,7 @@ func OpenDB(config *Config) (*gorm.DB, error) {
 	return db, nil
 }
 
-const CURRENT_USER_VERSION = 2
+const CURRENT_USER_VERSION = 3
 
 func setUserVersion(tx *gorm.DB, userVersion uint) error {
 	return tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", userVersion)).Error
@@ -82,6 +82,44 @@ func migrate(db *gorm.DB, alreadyExisted bool) error {
 			}
 			userVersion += 1
 		}
+		if userVersion == 2 {
+			// Version 2 to 3
+			// Add case-insensitive NormalizedUsername and NormalizedPlayerName
+			// columns. AutoMigrate adds their unique indices below.
+			if err := tx.Migrator().AddColumn(&User{}, "normalized_username"); err != nil {
+				return err
+			}
+			if err := tx.Migrator().AddColumn(&User{}, "normalized_player_name"); err != nil {
+				return err
+			}
+			var users []User
+			if err := tx.Find(&users).Error; err != nil {
+				return err
+			}
+			usernames := make(map[string]string, len(users))
+			playerNames := make(map[string]string, len(users))
+			for _, user := range users {
+				normalizedUsername := NormalizeName(user.Username)
+				if other, ok := usernames[normalizedUsername]; ok {
+					return fmt.Errorf("usernames %s and %s differ only in case, rename one of them and try again", other, user.Username)
+				}
+				usernames[normalizedUsername] = user.Username
+
+				normalizedPlayerName := NormalizeName(user.PlayerName)
+				if other, ok := playerNames[normalizedPlayerName]; ok {
+					return fmt.Errorf("player names %s and %s differ only in case, rename one of them and try again", other, user.PlayerName)
+				}
+				playerNames[normalizedPlayerName] = user.PlayerName
+
+				if err := tx.Model(&user).UpdateColumns(map[string]interface{}{
+					"normalized_username":    normalizedUsername,
+					"normalized_player_name": normalizedPlayerName,
+				}).Error; err != nil {
+					return err
+				}
+			}
+			userVersion += 1
+		}
 
 		err := tx.AutoMigrate(&User{})
 		if err != nil {
@@ -98,6 +136,176 @@ func migrate(db *gorm.DB, alreadyExisted bool) error {
 			return err
 		}
 
+		err = tx.AutoMigrate(&Announcement{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&AuditLogEntry{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&Group{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&GroupMembership{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&DailyStat{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&DailyActivePlayer{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&ErrorLogEntry{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&DeviceAuthorization{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&QRLogin{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&GiftCode{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&GiftCodeRedemption{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&ForwardingSecret{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&BedrockLink{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&BedrockLinkCode{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&APIToken{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&LibrarySkin{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&AppearanceSnapshot{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&ConfigOverride{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&UserProfileProperty{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&Cosmetic{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&Entitlement{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&GroupEntitlement{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&LinkedAccount{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&ProfileImport{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&Report{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&UserNote{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&PendingAdminAction{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&SessionRecord{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&BootstrappedInvite{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&SkinFingerprint{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&APITokenUsage{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&DeprecatedAPIUsage{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&PendingInGameCommand{})
+		if err != nil {
+			return err
+		}
+
+		err = tx.AutoMigrate(&TextureSize{})
+		if err != nil {
+			return err
+		}
+
 		if err := setUserVersion(tx, userVersion); err != nil {
 			return err
 		}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+/*
+The device login flow lets a launcher sign in without ever seeing the user's
+password, in the style of OAuth 2.0 device authorization (RFC 8628). The
+launcher requests a device code and a short user code, shows the user code
+to the player, and polls for credentials while the player approves the
+request in the web UI.
+*/
+
+// Consonants only, so user codes can't spell words, and without letters that
+// are easily confused with each other
+const DEVICE_USER_CODE_ALPHABET = "BCDFGHJKLMNPQRSTVWXZ"
+const DEVICE_USER_CODE_LENGTH = 8
+
+const (
+	DeviceLoginErrorNotAllowed           = "device_login_not_allowed"
+	DeviceLoginErrorAuthorizationPending = "authorization_pending"
+	DeviceLoginErrorSlowDown             = "slow_down"
+	DeviceLoginErrorAccessDenied         = "access_denied"
+	DeviceLoginErrorExpiredToken         = "expired_token"
+)
+
+var DEVICE_LOGIN_ERROR_MESSAGES = map[string]string{
+	DeviceLoginErrorAuthorizationPending: "Waiting for the request to be approved.",
+	DeviceLoginErrorSlowDown:             "Polling too frequently.",
+	DeviceLoginErrorAccessDenied:         "The request was denied.",
+	DeviceLoginErrorExpiredToken:         "The device code has expired. Please start over.",
+}
+
+// `length` random letters from DEVICE_USER_CODE_ALPHABET
+func randomUserCodeLetters(length int) ([]byte, error) {
+	code := make([]byte, 0, length)
+	buf := make([]byte, 1)
+	// Reject bytes past the largest multiple of the alphabet size so each
+	// letter is equally likely
+	limit := 256 - 256%len(DEVICE_USER_CODE_ALPHABET)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		if int(buf[0]) >= limit {
+			continue
+		}
+		code = append(code, DEVICE_USER_CODE_ALPHABET[int(buf[0])%len(DEVICE_USER_CODE_ALPHABET)])
+	}
+	return code, nil
+}
+
+func makeDeviceUserCode() (string, error) {
+	code, err := randomUserCodeLetters(DEVICE_USER_CODE_LENGTH)
+	if err != nil {
+		return "", err
+	}
+	half := DEVICE_USER_CODE_LENGTH / 2
+	return string(code[:half]) + "-" + string(code[half:]), nil
+}
+
+// Users may type the code in lowercase or without the dash
+func NormalizeDeviceUserCode(userCode string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(userCode) {
+		if strings.ContainsRune(DEVICE_USER_CODE_ALPHABET, r) {
+			b.WriteRune(r)
+		}
+	}
+	code := b.String()
+	if len(code) != DEVICE_USER_CODE_LENGTH {
+		return code
+	}
+	half := DEVICE_USER_CODE_LENGTH / 2
+	return code[:half] + "-" + code[half:]
+}
+
+func (app *App) CreateDeviceAuthorization() (*DeviceAuthorization, error) {
+	now := time.Now()
+	if err := app.DB.Where("expires_at < ?", now).Delete(&DeviceAuthorization{}).Error; err != nil {
+		return nil, err
+	}
+
+	deviceCode, err := RandomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	userCode, err := makeDeviceUserCode()
+	if err != nil {
+		return nil, err
+	}
+
+	deviceAuthorization := DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ExpiresAt:  now.Add(time.Duration(app.Config().DeviceLogin.ExpireSec) * time.Second),
+	}
+	if err := app.DB.Create(&deviceAuthorization).Error; err != nil {
+		return nil, err
+	}
+	return &deviceAuthorization, nil
+}
+
+// Find an unexpired request that is still waiting for a user to approve or
+// deny it
+func (app *App) GetPendingDeviceAuthorization(userCode string) (*DeviceAuthorization, error) {
+	var deviceAuthorization DeviceAuthorization
+	err := app.DB.First(
+		&deviceAuthorization,
+		"user_code = ? AND user_uuid IS NULL AND NOT denied AND expires_at > ?",
+		NormalizeDeviceUserCode(userCode), time.Now(),
+	).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deviceAuthorization, nil
+}
+
+var errDeviceAuthorizationNotFound = errors.New("device authorization not found")
+
+// Look up the request for deviceCode as the launcher polls for it. Approved
+// and denied requests are deleted once the launcher has seen the outcome.
+func (app *App) PollDeviceAuthorization(deviceCode string) (*DeviceAuthorization, error) {
+	var deviceAuthorization DeviceAuthorization
+	err := app.DB.First(&deviceAuthorization, "device_code = ?", deviceCode).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errDeviceAuthorizationNotFound
+		}
+		return nil, err
+	}
+	if time.Now().After(deviceAuthorization.ExpiresAt) {
+		return nil, errDeviceAuthorizationNotFound
+	}
+	return &deviceAuthorization, nil
+}
+
+// Poll for the outcome of the request for deviceCode. Returns the user who
+// approved it, exactly once, or else one of the DeviceLoginError codes.
+func (app *App) RedeemDeviceAuthorization(deviceCode string) (*User, string, error) {
+	deviceAuthorization, err := app.PollDeviceAuthorization(deviceCode)
+	if err != nil {
+		if errors.Is(err, errDeviceAuthorizationNotFound) {
+			return nil, DeviceLoginErrorExpiredToken, nil
+		}
+		return nil, "", err
+	}
+
+	if deviceAuthorization.Denied {
+		if err := app.DB.Delete(deviceAuthorization).Error; err != nil {
+			return nil, "", err
+		}
+		return nil, DeviceLoginErrorAccessDenied, nil
+	}
+
+	if !deviceAuthorization.UserUUID.Valid {
+		now := time.Now()
+		tooSoon := now.Sub(deviceAuthorization.LastPolledAt) < time.Duration(app.Config().DeviceLogin.PollIntervalSec)*time.Second
+		// Only touch last_polled_at so we don't clobber a concurrent approval
+		if err := app.DB.Model(&DeviceAuthorization{}).Where("device_code = ?", deviceAuthorization.DeviceCode).Update("last_polled_at", now).Error; err != nil {
+			return nil, "", err
+		}
+		if tooSoon {
+			return nil, DeviceLoginErrorSlowDown, nil
+		}
+		return nil, DeviceLoginErrorAuthorizationPending, nil
+	}
+
+	// Only one poll may collect the credentials
+	result := app.DB.Delete(deviceAuthorization)
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, DeviceLoginErrorExpiredToken, nil
+	}
+
+	var user User
+	if err := app.DB.Preload("Clients").First(&user, "uuid = ?", deviceAuthorization.UserUUID.String).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, DeviceLoginErrorExpiredToken, nil
+		}
+		return nil, "", err
+	}
+	return &user, "", nil
+}
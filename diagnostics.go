@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"time"
+)
+
+/*
+If Diagnostics.Enable is set, a second listener on Diagnostics.ListenAddress
+serves Go's pprof profiles, a dump of every goroutine's stack, runtime
+statistics as JSON, and Prometheus metrics; see metrics.go. Every request must
+carry Diagnostics.Token, either as a bearer token or in the `token` query
+parameter, which `go tool pprof` can pass along. The listener is kept apart
+from the main one so it can be bound to localhost or a private network.
+*/
+
+var processStartTime = time.Now()
+
+type diagnosticsRuntimeResponse struct {
+	Version      string `json:"version"`
+	GoVersion    string `json:"goVersion"`
+	UptimeSec    int64  `json:"uptimeSec"`
+	NumCPU       int    `json:"numCPU"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumGoroutine int    `json:"numGoroutine"`
+	Memory       struct {
+		HeapAllocBytes  uint64 `json:"heapAllocBytes"`
+		HeapInuseBytes  uint64 `json:"heapInuseBytes"`
+		HeapObjects     uint64 `json:"heapObjects"`
+		StackInuseBytes uint64 `json:"stackInuseBytes"`
+		SysBytes        uint64 `json:"sysBytes"`
+		TotalAllocBytes uint64 `json:"totalAllocBytes"`
+	} `json:"memory"`
+	GC struct {
+		NumGC        uint32     `json:"numGC"`
+		PauseTotalNs uint64     `json:"pauseTotalNs"`
+		LastGC       *time.Time `json:"lastGC"`
+		NextGCBytes  uint64     `json:"nextGCBytes"`
+		CPUFraction  float64    `json:"cpuFraction"`
+		LastPauseNs  uint64     `json:"lastPauseNs"`
+	} `json:"gc"`
+}
+
+func withDiagnosticsToken(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, ok := getBearerToken(c)
+			if !ok {
+				token = c.QueryParam("token")
+			}
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(app.Config().Diagnostics.Token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
+			}
+			return next(c)
+		}
+	}
+}
+
+// GET /debug/runtime
+func DiagnosticsRuntime(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		var memStats runtime.MemStats
+		runtime.ReadMemStats(&memStats)
+
+		res := diagnosticsRuntimeResponse{
+			Version:      Constants.Version,
+			GoVersion:    runtime.Version(),
+			UptimeSec:    int64(time.Since(processStartTime).Seconds()),
+			NumCPU:       runtime.NumCPU(),
+			GOMAXPROCS:   runtime.GOMAXPROCS(0),
+			NumGoroutine: runtime.NumGoroutine(),
+		}
+		res.Memory.HeapAllocBytes = memStats.HeapAlloc
+		res.Memory.HeapInuseBytes = memStats.HeapInuse
+		res.Memory.HeapObjects = memStats.HeapObjects
+		res.Memory.StackInuseBytes = memStats.StackInuse
+		res.Memory.SysBytes = memStats.Sys
+		res.Memory.TotalAllocBytes = memStats.TotalAlloc
+		res.GC.NumGC = memStats.NumGC
+		res.GC.PauseTotalNs = memStats.PauseTotalNs
+		if memStats.LastGC != 0 {
+			res.GC.LastGC = Ptr(time.Unix(0, int64(memStats.LastGC)).UTC())
+			res.GC.LastPauseNs = memStats.PauseNs[(memStats.NumGC+255)%256]
+		}
+		res.GC.NextGCBytes = memStats.NextGC
+		res.GC.CPUFraction = memStats.GCCPUFraction
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+// GET /debug/goroutines
+// The stack of every goroutine, in the same format as an unrecovered panic
+func DiagnosticsGoroutines(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+		c.Response().WriteHeader(http.StatusOK)
+		return runtimepprof.Lookup("goroutine").WriteTo(c.Response(), 2)
+	}
+}
+
+func GetDiagnosticsServer(app *App) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = app.Config().TestMode
+	e.Use(withDiagnosticsToken(app))
+
+	e.GET("/metrics", DiagnosticsMetrics(app))
+	e.GET("/debug/runtime", DiagnosticsRuntime(app))
+	e.GET("/debug/goroutines", DiagnosticsGoroutines(app))
+
+	// pprof.Index also serves the named profiles, e.g. /debug/pprof/heap
+	e.GET("/debug/pprof/", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	e.GET("/debug/pprof/*", echo.WrapHandler(http.HandlerFunc(pprof.Index)))
+	e.GET("/debug/pprof/cmdline", echo.WrapHandler(http.HandlerFunc(pprof.Cmdline)))
+	e.GET("/debug/pprof/profile", echo.WrapHandler(http.HandlerFunc(pprof.Profile)))
+	e.GET("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	e.POST("/debug/pprof/symbol", echo.WrapHandler(http.HandlerFunc(pprof.Symbol)))
+	e.GET("/debug/pprof/trace", echo.WrapHandler(http.HandlerFunc(pprof.Trace)))
+	return e
+}
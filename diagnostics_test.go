@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Diagnostics.Enable = true
+		config.Diagnostics.Token = "diagnostics-token-0123456789"
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test diagnostics", ts.testDiagnostics)
+		t.Run("Test metrics", ts.testMetrics)
+	}
+}
+
+func (ts *TestSuite) testDiagnostics(t *testing.T) {
+	e := GetDiagnosticsServer(ts.App)
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	token := ts.Config.Diagnostics.Token
+
+	// Every route needs the token
+	for _, path := range []string{"/metrics", "/debug/runtime", "/debug/goroutines", "/debug/pprof/", "/debug/pprof/heap"} {
+		assert.Equal(t, http.StatusUnauthorized, get(path, "").Code, path)
+		assert.Equal(t, http.StatusUnauthorized, get(path, "wrong-token").Code, path)
+	}
+
+	rec := get("/debug/runtime", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res diagnosticsRuntimeResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, Constants.Version, res.Version)
+	assert.True(t, res.NumGoroutine > 0)
+	assert.True(t, res.Memory.HeapAllocBytes > 0)
+
+	rec = get("/debug/goroutines", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "TestDiagnostics")
+
+	rec = get("/debug/pprof/", token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "goroutine")
+
+	// The token can also be passed as a query parameter, for go tool pprof
+	rec = get("/debug/pprof/heap?token="+token, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/octet-stream", rec.Header().Get(echo.HeaderContentType))
+
+	// The main listener doesn't serve any of it
+	rec = ts.Get(t, ts.Server, "/debug/pprof/", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func (ts *TestSuite) testMetrics(t *testing.T) {
+	e := GetDiagnosticsServer(ts.App)
+	getMetrics := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Authorization", "Bearer "+ts.Config.Diagnostics.Token)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	// A cached lookup is timed once and then found in the cache
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	fallbackAPIServer := FallbackAPIServer{Nickname: "Upstream", CacheTTLSeconds: 60}
+	for i := 0; i < 2; i++ {
+		_, err := ts.App.CachedGet(context.Background(), &fallbackAPIServer, FallbackCacheUUID, upstream.URL+"/users/profiles/minecraft/Test")
+		assert.Nil(t, err)
+		ts.App.RequestCache.Wait()
+	}
+
+	// Skins served by Drasl are counted
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "browser_token = ?", browserTokenCookie.Value).Error)
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(BLUE_SKIN)))
+	rec := ts.Get(t, ts.Server, "/drasl/texture/skin/"+user.SkinHash.String+".png", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	skinSize := rec.Body.Len()
+
+	metrics := getMetrics()
+	assert.Contains(t, metrics, "# TYPE drasl_fallback_request_duration_seconds histogram\n")
+	assert.Contains(t, metrics, `drasl_fallback_request_duration_seconds_bucket{server="Upstream",le="+Inf"} 1`+"\n")
+	assert.Contains(t, metrics, `drasl_fallback_request_duration_seconds_count{server="Upstream"} 1`+"\n")
+	assert.Contains(t, metrics, `drasl_fallback_cache_lookups_total{cache="uuid",result="hit"} 1`+"\n")
+	assert.Contains(t, metrics, `drasl_fallback_cache_lookups_total{cache="uuid",result="miss"} 1`+"\n")
+	assert.Contains(t, metrics, `drasl_texture_responses_total{type="skin"} 1`+"\n")
+	assert.Contains(t, metrics, fmt.Sprintf(`drasl_texture_response_bytes_total{type="skin"} %d`, skinSize)+"\n")
+}
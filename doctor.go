@@ -0,0 +1,283 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Environment checks run by `drasl doctor`. Nothing is changed: the database
+isn't migrated, a missing key isn't generated, and no mail is sent. Checks for
+features that aren't enabled are skipped. BaseURL is checked by requesting
+/drasl/api/<version>/info through it, so Drasl must already be running, behind its
+reverse proxy if it has one.
+*/
+
+const DOCTOR_TIMEOUT = 10 * time.Second
+
+const (
+	DOCTOR_PASS = "PASS"
+	DOCTOR_WARN = "WARN"
+	DOCTOR_FAIL = "FAIL"
+	DOCTOR_SKIP = "SKIP"
+)
+
+type DoctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+func (check *DoctorCheck) String() string {
+	s := fmt.Sprintf("[%s] %s", check.Status, check.Name)
+	if check.Detail != "" {
+		s += ": " + check.Detail
+	}
+	return s
+}
+
+type DoctorReport struct {
+	Checks []DoctorCheck
+}
+
+func (report *DoctorReport) add(name string, status string, detail string) {
+	report.Checks = append(report.Checks, DoctorCheck{Name: name, Status: status, Detail: detail})
+}
+
+func (report *DoctorReport) Failed() int {
+	failed := 0
+	for _, check := range report.Checks {
+		if check.Status == DOCTOR_FAIL {
+			failed += 1
+		}
+	}
+	return failed
+}
+
+func (report *DoctorReport) String() string {
+	lines := make([]string, 0, len(report.Checks)+1)
+	for _, check := range report.Checks {
+		lines = append(lines, check.String())
+	}
+	if failed := report.Failed(); failed > 0 {
+		lines = append(lines, fmt.Sprintf("%d of %d checks failed.", failed, len(report.Checks)))
+	} else {
+		lines = append(lines, "All checks passed.")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func doctorCheckKey(config *Config, report *DoctorReport) {
+	const name = "Signing key"
+	keyPath := SigningKeyPath(config)
+	_, err := ReadSigningKey(keyPath)
+	if errors.Is(err, os.ErrNotExist) && config.SigningKeyFile == "" {
+		report.add(name, DOCTOR_WARN, keyPath+" doesn't exist yet and will be generated on startup")
+		return
+	}
+	if err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	report.add(name, DOCTOR_PASS, keyPath)
+}
+
+func doctorCheckDataEncryption(config *Config, report *DoctorReport) {
+	const name = "Data encryption keys"
+	if config.DataEncryption.KeyFile == "" {
+		report.add(name, DOCTOR_SKIP, "DataEncryption.KeyFile isn't set")
+		return
+	}
+	if _, err := LoadDataCipher(&config.DataEncryption); err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	report.add(name, DOCTOR_PASS, config.DataEncryption.KeyFile)
+}
+
+func doctorCheckDatabase(config *Config, report *DoctorReport) {
+	const name = "Database schema"
+	dbPath := path.Join(config.StateDirectory, "drasl.db")
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		report.add(name, DOCTOR_WARN, dbPath+" doesn't exist yet and will be created on startup")
+		return
+	}
+
+	// Open read-only, so the check doesn't migrate or create anything
+	db, err := gorm.Open(sqlite.Open("file:"+dbPath+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var userVersion uint
+	if err := db.Raw("PRAGMA user_version;").Scan(&userVersion).Error; err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	switch {
+	case userVersion > CURRENT_USER_VERSION:
+		report.add(name, DOCTOR_FAIL, fmt.Sprintf("version %d is newer than this version of Drasl supports (%d)", userVersion, CURRENT_USER_VERSION))
+	case userVersion < CURRENT_USER_VERSION:
+		report.add(name, DOCTOR_WARN, fmt.Sprintf("version %d will be migrated to version %d on startup; back up %s first", userVersion, CURRENT_USER_VERSION, dbPath))
+	default:
+		report.add(name, DOCTOR_PASS, fmt.Sprintf("version %d", userVersion))
+	}
+}
+
+// Check that Drasl can create files in the directory, or create the
+// directory if it doesn't exist yet
+func doctorCheckWritable(directory string) error {
+	for {
+		info, err := os.Stat(directory)
+		if os.IsNotExist(err) {
+			parent := path.Dir(directory)
+			if parent == directory {
+				return err
+			}
+			directory = parent
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s isn't a directory", directory)
+		}
+		break
+	}
+	f, err := os.CreateTemp(directory, ".drasl-doctor-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func doctorCheckTextureDirectories(config *Config, report *DoctorReport) {
+	for _, kind := range TEXTURE_KINDS {
+		name := fmt.Sprintf("Texture directory (%s)", kind.Name)
+		directory := path.Join(config.StateDirectory, kind.Name)
+		if err := doctorCheckWritable(directory); err != nil {
+			report.add(name, DOCTOR_FAIL, err.Error())
+			continue
+		}
+		report.add(name, DOCTOR_PASS, directory+" is writable")
+	}
+}
+
+func doctorCheckBaseURL(config *Config, report *DoctorReport, client *http.Client) {
+	const name = "BaseURL"
+	infoURL, err := url.JoinPath(config.BaseURL, "drasl/api", currentAPIVersion(config), "info")
+	if err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	res, err := client.Get(infoURL)
+	if err != nil {
+		report.add(name, DOCTOR_FAIL, fmt.Sprintf("%s; is Drasl running and reachable at BaseURL?", err))
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		report.add(name, DOCTOR_FAIL, fmt.Sprintf("%s responded with %s; is BaseURL served by Drasl?", infoURL, res.Status))
+		return
+	}
+	report.add(name, DOCTOR_PASS, config.BaseURL+" is served by Drasl")
+}
+
+func doctorCheckSMTP(config *Config, report *DoctorReport) {
+	const name = "SMTP server"
+	if !config.Email.Enable {
+		report.add(name, DOCTOR_SKIP, "Email.Enable isn't set")
+		return
+	}
+	err := func() error {
+		addr := net.JoinHostPort(config.Email.SMTPHost, strconv.Itoa(config.Email.SMTPPort))
+		conn, err := net.DialTimeout("tcp", addr, DOCTOR_TIMEOUT)
+		if err != nil {
+			return err
+		}
+		if err := conn.SetDeadline(time.Now().Add(DOCTOR_TIMEOUT)); err != nil {
+			conn.Close()
+			return err
+		}
+		client, err := smtp.NewClient(conn, config.Email.SMTPHost)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		defer client.Close()
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: config.Email.SMTPHost}); err != nil {
+				return err
+			}
+		}
+		if config.Email.SMTPUsername != "" {
+			auth := smtp.PlainAuth("", config.Email.SMTPUsername, config.Email.SMTPPassword, config.Email.SMTPHost)
+			if err := client.Auth(auth); err != nil {
+				return fmt.Errorf("couldn't log in: %w", err)
+			}
+		}
+		return client.Quit()
+	}()
+	if err != nil {
+		report.add(name, DOCTOR_FAIL, err.Error())
+		return
+	}
+	report.add(name, DOCTOR_PASS, config.Email.SMTPHost)
+}
+
+func doctorCheckFallbackAPIServers(config *Config, report *DoctorReport, client *http.Client) {
+	if len(config.FallbackAPIServers) == 0 {
+		report.add("Fallback API servers", DOCTOR_SKIP, "no FallbackAPIServers are configured")
+		return
+	}
+	for _, fallbackAPIServer := range config.FallbackAPIServers {
+		name := fmt.Sprintf("Fallback API server %s", fallbackAPIServer.Nickname)
+		if fallbackAPIServer.Disabled {
+			report.add(name, DOCTOR_SKIP, "disabled")
+			continue
+		}
+		if err := ProbeFallbackAPIServer(client, &fallbackAPIServer); err != nil {
+			report.add(name, DOCTOR_FAIL, err.Error())
+			continue
+		}
+		report.add(name, DOCTOR_PASS, "reachable")
+	}
+}
+
+// Check the environment described by config
+func Doctor(config *Config) *DoctorReport {
+	report := DoctorReport{}
+	client := &http.Client{Timeout: DOCTOR_TIMEOUT}
+
+	doctorCheckKey(config, &report)
+	doctorCheckDataEncryption(config, &report)
+	doctorCheckDatabase(config, &report)
+	doctorCheckTextureDirectories(config, &report)
+	doctorCheckBaseURL(config, &report, client)
+	doctorCheckSMTP(config, &report)
+	// Drasl reaches fallback API servers through OutboundProxy, if set
+	fallbackClient := &http.Client{Timeout: DOCTOR_TIMEOUT, Transport: MakeHTTPTransport(config)}
+	doctorCheckFallbackAPIServers(config, &report, fallbackClient)
+	return &report
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDoctor(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test doctor", ts.testDoctor)
+	}
+}
+
+func doctorStatuses(report *DoctorReport) map[string]string {
+	statuses := map[string]string{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func (ts *TestSuite) testDoctor(t *testing.T) {
+	drasl := httptest.NewServer(ts.Server)
+	defer drasl.Close()
+	fallback := httptest.NewServer(http.NotFoundHandler())
+	defer fallback.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	config := *ts.Config
+	config.BaseURL = drasl.URL
+	config.FallbackAPIServers = []FallbackAPIServer{
+		{Nickname: "Up", SessionURL: fallback.URL, AccountURL: fallback.URL},
+		{Nickname: "Down", SessionURL: down.URL},
+	}
+
+	report := Doctor(&config)
+	assert.Equal(t, map[string]string{
+		"Signing key":              DOCTOR_PASS,
+		"Data encryption keys":     DOCTOR_SKIP,
+		"Database schema":          DOCTOR_PASS,
+		"Texture directory (skin)": DOCTOR_PASS,
+		"Texture directory (cape)": DOCTOR_PASS,
+		"BaseURL":                  DOCTOR_PASS,
+		"SMTP server":              DOCTOR_SKIP,
+		"Fallback API server Up":   DOCTOR_PASS,
+		"Fallback API server Down": DOCTOR_FAIL,
+	}, doctorStatuses(report))
+	assert.Equal(t, 1, report.Failed())
+	assert.Contains(t, report.String(), "1 of 9 checks failed.")
+
+	// Nothing is listening for SMTP or at BaseURL
+	config.Email.Enable = true
+	config.Email.SMTPHost = "127.0.0.1"
+	listener := Unwrap(net.Listen("tcp", "127.0.0.1:0"))
+	config.Email.SMTPPort = listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+	config.BaseURL = down.URL
+	config.FallbackAPIServers = []FallbackAPIServer{}
+	report = Doctor(&config)
+	assert.Equal(t, DOCTOR_FAIL, doctorStatuses(report)["SMTP server"])
+	assert.Equal(t, DOCTOR_FAIL, doctorStatuses(report)["BaseURL"])
+	assert.Equal(t, DOCTOR_SKIP, doctorStatuses(report)["Fallback API servers"])
+
+	// A corrupt key, no database yet, and a file where the skin directory
+	// should be
+	stateDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+	defer os.RemoveAll(stateDirectory)
+	assert.Nil(t, os.WriteFile(path.Join(stateDirectory, "key.pkcs8"), []byte("not a key"), 0600))
+	assert.Nil(t, os.WriteFile(path.Join(stateDirectory, "skin"), []byte{}, 0600))
+	config.StateDirectory = stateDirectory
+	report = Doctor(&config)
+	assert.Equal(t, DOCTOR_FAIL, doctorStatuses(report)["Signing key"])
+	assert.Equal(t, DOCTOR_WARN, doctorStatuses(report)["Database schema"])
+	assert.Equal(t, DOCTOR_FAIL, doctorStatuses(report)["Texture directory (skin)"])
+	assert.Equal(t, DOCTOR_PASS, doctorStatuses(report)["Texture directory (cape)"])
+
+	// A SigningKeyFile isn't generated on startup, so it has to exist
+	config.SigningKeyFile = path.Join(stateDirectory, "missing.pkcs8")
+	report = Doctor(&config)
+	assert.Equal(t, DOCTOR_FAIL, doctorStatuses(report)["Signing key"])
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+/*
+A subset of the ely.by skin system API, for mods and clients hard-coded
+against skinsystem.ely.by. Players are looked up by player name, given either
+in the path or, in the older style, as a `name` query parameter. Fallback API
+servers aren't consulted.
+https://docs.ely.by/en/skins-system.html
+*/
+
+// The player named in the request, or nil if there's no such player
+func elyByFindUser(app *App, c echo.Context) (*User, error) {
+	playerName := c.Param("playerName")
+	if playerName == "" {
+		playerName = c.QueryParam("name")
+	}
+	playerName = strings.TrimSuffix(playerName, ".png")
+
+	var user User
+	if err := app.DB.First(&user, "player_name = ?", playerName).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Redirect to one of the player's textures, or their default one
+func elyByTextureRedirect(app *App, getTexture func(*textureMap) *texture) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().ElyByCompatibility.Enable {
+			return echo.ErrNotFound
+		}
+		user, err := elyByFindUser(app, c)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return echo.ErrNotFound
+		}
+		textures := GetTextureMap(app, user)
+		if textures == nil || getTexture(textures) == nil {
+			return echo.ErrNotFound
+		}
+		return c.Redirect(http.StatusFound, getTexture(textures).URL)
+	}
+}
+
+// GET /skins/:playerName
+func ElyBySkin(app *App) func(c echo.Context) error {
+	return elyByTextureRedirect(app, func(textures *textureMap) *texture {
+		return textures.Skin
+	})
+}
+
+// GET /cloaks/:playerName
+func ElyByCape(app *App) func(c echo.Context) error {
+	return elyByTextureRedirect(app, func(textures *textureMap) *texture {
+		return textures.Cape
+	})
+}
+
+// GET /textures/:playerName
+// The contents of the player's textures property, unencoded and unsigned
+func ElyByTextures(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().ElyByCompatibility.Enable {
+			return echo.ErrNotFound
+		}
+		user, err := elyByFindUser(app, c)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNoContent)
+		}
+		if notModified, err := app.ProfileNotModified(c, user, "ely.by textures"); notModified || err != nil {
+			return err
+		}
+		textures := GetTextureMap(app, user)
+		if textures == nil || (textures.Skin == nil && textures.Cape == nil) {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return c.JSON(http.StatusOK, textures)
+	}
+}
+
+// GET /textures/signed/:playerName
+// The player's profile with a signed textures property, as
+// /session/minecraft/profile/:id returns it
+func ElyBySignedTextures(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().ElyByCompatibility.Enable {
+			return echo.ErrNotFound
+		}
+		user, err := elyByFindUser(app, c)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return c.NoContent(http.StatusNoContent)
+		}
+		if notModified, err := app.ProfileNotModified(c, user, "ely.by signed textures"); notModified || err != nil {
+			return err
+		}
+		profile, err := fullProfile(app, user, user.UUID, true, app.Config().TexturesCompatibility.Profile)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, profile)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestElyBy(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.ElyByCompatibility.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test ely.by skin system", ts.testElyBySkinSystem)
+	}
+}
+
+func (ts *TestSuite) testElyBySkinSystem(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	// Without a skin or cape
+	rec := ts.Get(t, ts.Server, "/skins/"+TEST_USERNAME+".png", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = ts.Get(t, ts.Server, "/textures/"+TEST_USERNAME, nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	skinURL := Unwrap(SkinURL(ts.App, user.SkinHash.String))
+
+	// Both the path and the older query parameter style work
+	for _, path := range []string{"/skins/" + TEST_USERNAME + ".png", "/skins/" + TEST_USERNAME, "/skins?name=" + TEST_USERNAME} {
+		rec = ts.Get(t, ts.Server, path, nil, nil)
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, skinURL, rec.Header().Get("Location"))
+	}
+	rec = ts.Get(t, ts.Server, "/cloaks/"+TEST_USERNAME+".png", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = ts.Get(t, ts.Server, "/textures/"+TEST_USERNAME, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var textures textureMap
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&textures))
+	assert.Equal(t, skinURL, textures.Skin.URL)
+	assert.Nil(t, textures.Cape)
+
+	rec = ts.Get(t, ts.Server, "/textures/signed/"+TEST_USERNAME, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var profile SessionProfileResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&profile))
+	assert.Equal(t, Unwrap(UUIDToID(user.UUID)), profile.ID)
+	assert.NotNil(t, profile.Properties[0].Signature)
+
+	rec = ts.Get(t, ts.Server, "/textures/signed/nobody", nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = ts.Get(t, ts.Server, "/skins/nobody.png", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"lukechampine.com/blake3"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+Email is optional. When `Email.Enable` is set, users can add an email address
+to their account and verify it, and admins can send announcements to every
+user with a verified address who hasn't unsubscribed.
+*/
+
+type EmailMessage struct {
+	To      string
+	Subject string
+	Body    string
+	// If set, added as a List-Unsubscribe header
+	UnsubscribeURL string
+}
+
+type Mailer interface {
+	Send(message *EmailMessage) error
+}
+
+// How long sending one email may take, from connecting to the SMTP server
+// until it has accepted the message
+const SMTP_TIMEOUT = 30 * time.Second
+
+type SMTPMailer struct {
+	Config *emailConfig
+}
+
+func (mailer *SMTPMailer) Send(message *EmailMessage) error {
+	var buf bytes.Buffer
+	header := func(name string, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", mailer.Config.From)
+	header("To", message.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	if message.UnsubscribeURL != "" {
+		header("List-Unsubscribe", "<"+message.UnsubscribeURL+">")
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	from, err := mail.ParseAddress(mailer.Config.From)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if mailer.Config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", mailer.Config.SMTPUsername, mailer.Config.SMTPPassword, mailer.Config.SMTPHost)
+	}
+	addr := mailer.Config.SMTPHost + ":" + strconv.Itoa(mailer.Config.SMTPPort)
+
+	// Like smtp.SendMail, but giving up on a slow or unreachable server
+	conn, err := net.DialTimeout("tcp", addr, SMTP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT)); err != nil {
+		return err
+	}
+	client, err := smtp.NewClient(conn, mailer.Config.SMTPHost)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: mailer.Config.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server doesn't support AUTH")
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := client.Rcpt(message.To); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return errors.New("not a valid email address")
+	}
+	return nil
+}
+
+// A token proving the link it's part of was generated by this instance.
+// `purpose` keeps tokens for one kind of link from working for another.
+func makeEmailToken(app *App, purpose string, parts ...string) string {
+	preimage := bytes.Join([][]byte{
+		[]byte(purpose),
+		[]byte(strings.Join(parts, "\x00")),
+		app.KeyB3Sum512,
+	}, []byte{0})
+	sum := blake3.Sum256(preimage)
+	return hex.EncodeToString(sum[:])
+}
+
+func EmailVerificationURL(app *App, user *User, email string) (string, error) {
+	base, err := url.Parse(app.FrontEndURL + "/drasl/verify-email")
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("user", user.UUID)
+	query.Set("email", email)
+	query.Set("token", makeEmailToken(app, "verify-email", user.UUID, email))
+	base.RawQuery = query.Encode()
+	return base.String(), nil
+}
+
+func UnsubscribeURL(app *App, user *User) (string, error) {
+	base, err := url.Parse(app.FrontEndURL + "/drasl/unsubscribe")
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("user", user.UUID)
+	query.Set("token", makeEmailToken(app, "unsubscribe", user.UUID))
+	base.RawQuery = query.Encode()
+	return base.String(), nil
+}
+
+func (app *App) SendVerificationEmail(user *User) error {
+	email := UnmakeNullString(&user.Email)
+	if app.Mailer == nil || email == nil {
+		return nil
+	}
+	verificationURL, err := EmailVerificationURL(app, user, *email)
+	if err != nil {
+		return err
+	}
+	return app.Mailer.Send(&EmailMessage{
+		To:      *email,
+		Subject: fmt.Sprintf("Verify your email address for %s", app.Config().InstanceName),
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nTo verify this email address for your %s account, open this link:\n\n%s\n\nIf you didn't add this address, you can ignore this message.\n",
+			user.Username, app.Config().InstanceName, verificationURL,
+		),
+	})
+}
+
+// Fields available in an email announcement's subject and body templates
+type announcementEmailData struct {
+	Username     string
+	PlayerName   string
+	InstanceName string
+}
+
+type AnnouncementEmail struct {
+	Subject *template.Template
+	Body    *template.Template
+}
+
+func ParseAnnouncementEmail(subject string, body string) (*AnnouncementEmail, error) {
+	subjectTemplate, err := template.New("subject").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject: %s", err)
+	}
+	bodyTemplate, err := template.New("body").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body: %s", err)
+	}
+	return &AnnouncementEmail{Subject: subjectTemplate, Body: bodyTemplate}, nil
+}
+
+func (announcement *AnnouncementEmail) Render(app *App, user *User) (*EmailMessage, error) {
+	data := announcementEmailData{
+		Username:     user.Username,
+		PlayerName:   user.PlayerName,
+		InstanceName: app.Config().InstanceName,
+	}
+	var subject bytes.Buffer
+	if err := announcement.Subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := announcement.Body.Execute(&body, data); err != nil {
+		return nil, err
+	}
+	unsubscribeURL, err := UnsubscribeURL(app, user)
+	if err != nil {
+		return nil, err
+	}
+	body.WriteString("\n\n--\nTo stop receiving announcements from " + app.Config().InstanceName + ", open this link:\n" + unsubscribeURL + "\n")
+
+	return &EmailMessage{
+		To:             user.Email.String,
+		Subject:        strings.TrimSpace(subject.String()),
+		Body:           body.String(),
+		UnsubscribeURL: unsubscribeURL,
+	}, nil
+}
+
+// Users who should receive email announcements, optionally limited to the
+// members of a group
+func (app *App) GetAnnouncementRecipients(group *Group) ([]User, error) {
+	db := app.DB.Where("users.email IS NOT NULL AND users.email_verified AND NOT users.email_opt_out")
+	if group != nil {
+		db = db.
+			Joins("JOIN group_memberships ON group_memberships.user_uuid = users.uuid").
+			Where("group_memberships.group_id = ?", group.ID)
+	}
+	var users []User
+	err := db.Order("users.username").Find(&users).Error
+	return users, err
+}
+
+// Send an announcement to each recipient in turn, no faster than
+// Email.MessagesPerSecond. Failures are logged and don't stop the rest.
+func (app *App) SendAnnouncementEmail(announcement *AnnouncementEmail, recipients []User) {
+	interval := time.Duration(float64(time.Second) / app.Config().Email.MessagesPerSecond)
+	for i, recipient := range PtrSlice(recipients) {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		message, err := announcement.Render(app, recipient)
+		if err == nil {
+			err = app.Mailer.Send(message)
+		}
+		if err != nil {
+			log.Printf("Couldn't send announcement email to %s: %s\n", recipient.Username, err)
+		}
+	}
+	log.Printf("Finished sending announcement email to %d users\n", len(recipients))
+}
+
+type SecurityEvent string
+
+const (
+	SecurityEventNewDevice      SecurityEvent = "new-device"
+	SecurityEventPasswordChange SecurityEvent = "password-change"
+	SecurityEventEmailChange    SecurityEvent = "email-change"
+)
+
+func (app *App) securityEventEnabled(event SecurityEvent) bool {
+	switch event {
+	case SecurityEventNewDevice:
+		return app.Config().Email.NotifyNewDevice
+	case SecurityEventPasswordChange:
+		return app.Config().Email.NotifyPasswordChange
+	case SecurityEventEmailChange:
+		return app.Config().Email.NotifyEmailChange
+	}
+	return false
+}
+
+// Tell a user about a sign-in or change to their account, so they notice if
+// it wasn't them. Only verified addresses are notified. ip and userAgent
+// identify the device responsible. The email is sent in the background, so a
+// slow mail server doesn't hold up sign-ins, and failures are only logged;
+// the action has already happened.
+func (app *App) NotifySecurityEvent(user *User, event SecurityEvent, ip string, userAgent string) {
+	if app.Mailer == nil || !app.securityEventEnabled(event) {
+		return
+	}
+	if !user.Email.Valid || !user.EmailVerified || user.SecurityNotificationsOptOut {
+		return
+	}
+
+	var subject, summary string
+	switch event {
+	case SecurityEventNewDevice:
+		subject = fmt.Sprintf("New sign-in to your %s account", app.Config().InstanceName)
+		summary = "Your account was just signed in to from a device we haven't seen before."
+	case SecurityEventPasswordChange:
+		subject = fmt.Sprintf("Your %s password was changed", app.Config().InstanceName)
+		summary = "The password for your account was just changed."
+	case SecurityEventEmailChange:
+		subject = fmt.Sprintf("Your %s email address was changed", app.Config().InstanceName)
+		summary = "The email address for your account was just changed. This address will no longer receive email about it."
+	}
+	if userAgent == "" {
+		userAgent = "Unknown"
+	}
+
+	message := EmailMessage{
+		To:      user.Email.String,
+		Subject: subject,
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n%s\n\nTime: %s\nIP address: %s\nDevice: %s\n\nIf this was you, you can ignore this message. If not, change your password and review the game clients signed in to your account:\n\n%s\n\nYou can turn these notifications off on the same page.\n",
+			user.Username, summary, time.Now().UTC().Format(time.RFC1123), ip, userAgent, app.FrontEndURL+"/drasl/profile",
+		),
+	}
+	username := user.Username
+	app.securityEmails.Add(1)
+	go func() {
+		defer app.securityEmails.Done()
+		if err := app.Mailer.Send(&message); err != nil {
+			log.Printf("Couldn't send %s notification email to %s: %s\n", event, username, err)
+		}
+	}()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/*
+If ErrorReporting.Enable is set, unexpected errors, the ones that end up in the
+error log on the Admin statistics page, and panics in handlers are sent to a
+Sentry-compatible server, e.g. Sentry itself or GlitchTip, along with the
+request they happened in. Credentials are scrubbed first: sensitive headers
+and query parameters are replaced with "[Filtered]", request bodies are never
+sent, and anything that looks like a token is removed from error messages.
+Events are sent in the background, so reporting never holds up a response.
+*/
+
+const ERROR_REPORTING_TIMEOUT = 10 * time.Second
+
+const FILTERED = "[Filtered]"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Proxy-Authorization": true,
+	"Set-Cookie":          true,
+}
+
+var sensitiveParamWords = []string{"auth", "code", "key", "password", "secret", "session", "token"}
+
+// JWTs, API tokens, and the long hex strings used for access and browser
+// tokens
+var tokenRegex = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|` + API_TOKEN_PREFIX + `[A-Za-z0-9]+|[0-9a-fA-F]{40,}`)
+
+type ErrorReporter struct {
+	StoreURL    string
+	Auth        string
+	Environment string
+	Client      *http.Client
+}
+
+// Parse a DSN like https://<key>@sentry.example.com/<project>
+func parseDSN(dsn string) (storeURL string, auth string, err error) {
+	parsed, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", "", errors.New("must be an http or https URL")
+	}
+	if parsed.User == nil || parsed.User.Username() == "" {
+		return "", "", errors.New("missing public key")
+	}
+	path := strings.TrimRight(parsed.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return "", "", errors.New("missing project ID")
+	}
+	prefix, projectID := path[:i], path[i+1:]
+
+	storeURL = fmt.Sprintf("%s://%s%s/api/%s/store/", parsed.Scheme, parsed.Host, prefix, projectID)
+	auth = fmt.Sprintf("Sentry sentry_version=7, sentry_client=drasl/%s, sentry_key=%s", Constants.Version, parsed.User.Username())
+	if secret, ok := parsed.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+	return storeURL, auth, nil
+}
+
+func NewErrorReporter(config *errorReportingConfig) (*ErrorReporter, error) {
+	storeURL, auth, err := parseDSN(config.DSN)
+	if err != nil {
+		return nil, err
+	}
+	return &ErrorReporter{
+		StoreURL:    storeURL,
+		Auth:        auth,
+		Environment: config.Environment,
+		Client:      &http.Client{Timeout: ERROR_REPORTING_TIMEOUT},
+	}, nil
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers"`
+}
+
+type sentryEvent struct {
+	EventID     string `json:"event_id"`
+	Timestamp   string `json:"timestamp"`
+	Platform    string `json:"platform"`
+	Level       string `json:"level"`
+	Logger      string `json:"logger"`
+	ServerName  string `json:"server_name,omitempty"`
+	Release     string `json:"release"`
+	Environment string `json:"environment,omitempty"`
+	Transaction string `json:"transaction"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Request sentryRequest     `json:"request"`
+	Tags    map[string]string `json:"tags"`
+}
+
+// A panic recovered by makeRecoverMiddleware
+type panicError struct {
+	Value  interface{}
+	Frames []sentryFrame
+}
+
+func (err *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
+// Frames of the calling goroutine's stack, oldest first, as Sentry expects
+func callerFrames(skip int) []sentryFrame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	reversed := []sentryFrame{}
+	for {
+		frame, more := frames.Next()
+		reversed = append(reversed, sentryFrame{
+			Function: frame.Function,
+			Filename: frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "main."),
+		})
+		if !more {
+			break
+		}
+	}
+	result := make([]sentryFrame, len(reversed))
+	for i, frame := range reversed {
+		result[len(reversed)-1-i] = frame
+	}
+	return result
+}
+
+// Turn panics in handlers into errors, so they're reported like any other
+// unexpected error
+func makeRecoverMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				if value := recover(); value != nil {
+					if value == http.ErrAbortHandler {
+						panic(value)
+					}
+					// Skip runtime.Callers, callerFrames, this function,
+					// and the runtime's panic machinery
+					err = &panicError{Value: value, Frames: callerFrames(3)}
+				}
+			}()
+			return next(c)
+		}
+	}
+}
+
+func scrubText(s string) string {
+	return tokenRegex.ReplaceAllString(s, FILTERED)
+}
+
+func isSensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveParamWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func scrubQuery(query url.Values) string {
+	scrubbed := url.Values{}
+	for name, values := range query {
+		for _, value := range values {
+			if isSensitiveParam(name) {
+				value = FILTERED
+			}
+			scrubbed.Add(name, scrubText(value))
+		}
+	}
+	// Brackets survive encoding as %5B and %5D; keep them readable
+	return strings.NewReplacer("%5BFiltered%5D", FILTERED).Replace(scrubbed.Encode())
+}
+
+func makeSentryEvent(app *App, err error, c echo.Context) (*sentryEvent, error) {
+	eventID, randomErr := RandomHex(16)
+	if randomErr != nil {
+		return nil, randomErr
+	}
+	req := c.Request()
+
+	event := sentryEvent{
+		EventID:     eventID,
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Platform:    "go",
+		Level:       "error",
+		Logger:      "drasl",
+		Release:     "drasl@" + Constants.Version,
+		Environment: app.ErrorReporter.Environment,
+		Transaction: req.Method + " " + c.Path(),
+		Tags:        map[string]string{"instance": app.Config().InstanceName},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		event.ServerName = hostname
+	}
+
+	exception := sentryException{
+		Type:  fmt.Sprintf("%T", err),
+		Value: scrubText(err.Error()),
+	}
+	var panicErr *panicError
+	if errors.As(err, &panicErr) {
+		event.Level = "fatal"
+		exception.Type = "panic"
+		exception.Stacktrace = &sentryStacktrace{Frames: panicErr.Frames}
+	}
+	event.Exception.Values = []sentryException{exception}
+
+	event.Request = sentryRequest{
+		URL:         app.FrontEndURL + req.URL.Path,
+		Method:      req.Method,
+		QueryString: scrubQuery(req.URL.Query()),
+		Headers:     map[string]string{},
+	}
+	for name, values := range req.Header {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+			value = FILTERED
+		}
+		event.Request.Headers[name] = scrubText(value)
+	}
+	return &event, nil
+}
+
+// Send the event in the background
+func (reporter *ErrorReporter) Send(event *sentryEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Couldn't report error: %s\n", err)
+		return
+	}
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, reporter.StoreURL, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Couldn't report error: %s\n", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", reporter.Auth)
+		res, err := reporter.Client.Do(req)
+		if err != nil {
+			log.Printf("Couldn't report error: %s\n", err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			log.Printf("Couldn't report error: error reporting server responded with %s\n", res.Status)
+		}
+	}()
+}
+
+// Report an unexpected error to ErrorReporting.DSN, if it's set
+func (app *App) ReportError(err error, c echo.Context) {
+	if app.ErrorReporter == nil {
+		return
+	}
+	event, err := makeSentryEvent(app, err, c)
+	if err != nil {
+		log.Printf("Couldn't report error: %s\n", err)
+		return
+	}
+	app.ErrorReporter.Send(event)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorReporting(t *testing.T) {
+	t.Run("Test parseDSN", testParseDSN)
+	{
+		ts := &TestSuite{}
+
+		events := make(chan *http.Request, 4)
+		bodies := make(chan sentryEvent, 4)
+		sentry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var event sentryEvent
+			if err := json.NewDecoder(r.Body).Decode(&event); err == nil {
+				bodies <- event
+			}
+			events <- r
+		}))
+		defer sentry.Close()
+
+		config := testConfig()
+		config.ErrorReporting.Enable = true
+		config.ErrorReporting.DSN = strings.Replace(sentry.URL, "http://", "http://publickey@", 1) + "/42"
+		config.ErrorReporting.Environment = "test"
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.Server.GET("/test/error", func(c echo.Context) error {
+			return errors.New("couldn't use token " + strings.Repeat("ab", 32))
+		})
+		ts.Server.GET("/test/panic", func(c echo.Context) error {
+			panic("boom")
+		})
+
+		receive := func(t *testing.T) (*http.Request, sentryEvent) {
+			select {
+			case event := <-bodies:
+				return <-events, event
+			case <-time.After(5 * time.Second):
+				t.Fatal("No event was reported")
+				return nil, sentryEvent{}
+			}
+		}
+
+		t.Run("Test reporting errors", func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test/error?accessToken=secret123&username=foo", nil)
+			req.Header.Set("Authorization", "Bearer secret456")
+			rec := httptest.NewRecorder()
+			ts.Server.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+			sentryReq, event := receive(t)
+			assert.Equal(t, "/api/42/store/", sentryReq.URL.Path)
+			assert.Contains(t, sentryReq.Header.Get("X-Sentry-Auth"), "sentry_key=publickey")
+			assert.Equal(t, "error", event.Level)
+			assert.Equal(t, "test", event.Environment)
+			assert.Equal(t, "GET /test/error", event.Transaction)
+			assert.Equal(t, "couldn't use token [Filtered]", event.Exception.Values[0].Value)
+			assert.Equal(t, "https://drasl.example.com/test/error", event.Request.URL)
+			assert.Equal(t, "accessToken=[Filtered]&username=foo", event.Request.QueryString)
+			assert.Equal(t, FILTERED, event.Request.Headers["Authorization"])
+		})
+
+		t.Run("Test reporting panics", func(t *testing.T) {
+			rec := ts.Get(t, ts.Server, "/test/panic", nil, nil)
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+			_, event := receive(t)
+			assert.Equal(t, "fatal", event.Level)
+			exception := event.Exception.Values[0]
+			assert.Equal(t, "panic", exception.Type)
+			assert.Equal(t, "panic: boom", exception.Value)
+			frames := exception.Stacktrace.Frames
+			assert.Contains(t, frames[len(frames)-1].Function, "TestErrorReporting")
+		})
+	}
+}
+
+func testParseDSN(t *testing.T) {
+	storeURL, auth, err := parseDSN("https://key@sentry.example.com/prefix/7")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://sentry.example.com/prefix/api/7/store/", storeURL)
+	assert.Contains(t, auth, "sentry_key=key")
+	assert.NotContains(t, auth, "sentry_secret")
+
+	_, _, err = parseDSN("https://sentry.example.com/7")
+	assert.NotNil(t, err)
+	_, _, err = parseDSN("https://key@sentry.example.com/")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Live account and session events for dashboards and moderation bots.
+Handlers publish events to app.Events as they happen, and clients holding one
+of the EventStream.Tokens receive them as Server-Sent Events from
+/drasl/api/v2/events. Events aren't stored; a client only sees what happens
+while it's connected.
+*/
+
+const (
+	// Sign-in from a launcher, through /authenticate, device login, or QR
+	// code login
+	EventLogin string = "login"
+	// Sign-in to the web interface
+	EventWebLogin     string = "web-login"
+	EventJoin         string = "join"
+	EventRegistration string = "registration"
+)
+
+var EVENT_TYPES = []string{EventLogin, EventWebLogin, EventJoin, EventRegistration}
+
+// Events are dropped for subscribers that fall this far behind, so a slow
+// client can't hold up the request that published the event
+const EVENT_BUFFER_SIZE = 64
+
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	UUID       string    `json:"uuid"`
+	Username   string    `json:"username"`
+	PlayerName string    `json:"playerName"`
+	IP         string    `json:"ip,omitempty"`
+	// For joins, the server the player joined
+	ServerID string `json:"serverId,omitempty"`
+}
+
+func MakeEvent(eventType string, user *User, ip string) Event {
+	return Event{
+		Type:       eventType,
+		Time:       time.Now(),
+		UUID:       user.UUID,
+		Username:   user.Username,
+		PlayerName: user.PlayerName,
+		IP:         ip,
+	}
+}
+
+type eventSubscriber struct {
+	Types   map[string]bool
+	Channel chan Event
+}
+
+type EventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[*eventSubscriber]bool
+}
+
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: map[*eventSubscriber]bool{}}
+}
+
+// Receive events of the given types until unsubscribe is called
+func (broker *EventBroker) Subscribe(types []string) (<-chan Event, func()) {
+	subscriber := &eventSubscriber{
+		Types:   map[string]bool{},
+		Channel: make(chan Event, EVENT_BUFFER_SIZE),
+	}
+	for _, eventType := range types {
+		subscriber.Types[eventType] = true
+	}
+
+	broker.mutex.Lock()
+	broker.subscribers[subscriber] = true
+	broker.mutex.Unlock()
+
+	unsubscribe := func() {
+		broker.mutex.Lock()
+		delete(broker.subscribers, subscriber)
+		broker.mutex.Unlock()
+	}
+	return subscriber.Channel, unsubscribe
+}
+
+func (broker *EventBroker) Publish(event Event) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	for subscriber := range broker.subscribers {
+		if !subscriber.Types[event.Type] {
+			continue
+		}
+		select {
+		case subscriber.Channel <- event:
+		default:
+		}
+	}
+}
+
+// Publish an event if EventStream.Enable is set
+func (app *App) PublishEvent(event Event) {
+	if app.Events == nil {
+		return
+	}
+	app.Events.Publish(event)
+}
+
+// The event stream token in the Authorization header, if it's valid
+func getEventStreamToken(app *App, c echo.Context) *eventStreamToken {
+	bearerToken, ok := getBearerToken(c)
+	if !ok {
+		return nil
+	}
+	for i := range app.Config().EventStream.Tokens {
+		token := &app.Config().EventStream.Tokens[i]
+		if subtle.ConstantTimeCompare([]byte(bearerToken), []byte(token.Token)) == 1 {
+			return token
+		}
+	}
+	return nil
+}
+
+// GET /drasl/api/:version/events?types=...
+// Stream events as Server-Sent Events. `types` is a comma-separated list of
+// event types to receive; by default, every type the token is allowed.
+func APIEvents(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().EventStream.Enable {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("The event stream is not enabled on this server."))
+		}
+		token := getEventStreamToken(app, c)
+		if token == nil {
+			return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
+		}
+
+		allowedTypes := token.Events
+		if len(allowedTypes) == 0 {
+			allowedTypes = EVENT_TYPES
+		}
+		types := allowedTypes
+		if typesParam := c.QueryParam("types"); typesParam != "" {
+			types = []string{}
+			for _, eventType := range strings.Split(typesParam, ",") {
+				if !Contains(EVENT_TYPES, eventType) {
+					return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr(fmt.Sprintf("Unknown event type %s.", eventType)))
+				}
+				if !Contains(allowedTypes, eventType) {
+					return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr(fmt.Sprintf("This token can't receive %s events.", eventType)))
+				}
+				types = append(types, eventType)
+			}
+		}
+
+		events, unsubscribe := app.Events.Subscribe(types)
+		defer unsubscribe()
+
+		res := c.Response()
+		res.Header().Set(echo.HeaderContentType, "text/event-stream")
+		res.Header().Set(echo.HeaderCacheControl, "no-cache")
+		// Ask nginx not to buffer the stream
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+
+		// Lets clients know they're subscribed
+		if _, err := fmt.Fprint(res, ": connected\n\n"); err != nil {
+			return nil
+		}
+		res.Flush()
+
+		keepalive := time.NewTicker(time.Duration(app.Config().EventStream.KeepaliveSec) * time.Second)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(res, ": keepalive\n\n"); err != nil {
+					return nil
+				}
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+				if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+					return nil
+				}
+			}
+			res.Flush()
+		}
+	}
+}
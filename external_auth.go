@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"time"
+)
+
+/*
+An operator-provided check consulted by /authenticate after the password has
+been verified, and by other ways of signing in a launcher before they issue a
+token, e.g. to require an active subscription or forum membership.
+The check is either an HTTP endpoint, which receives the request as a JSON
+POST body, or a command, which receives it on stdin. Either way, it answers
+with a JSON externalAuthResponse.
+*/
+
+type externalAuthRequest struct {
+	UUID       string `json:"uuid"`
+	Username   string `json:"username"`
+	PlayerName string `json:"playerName"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"userAgent"`
+}
+
+type externalAuthResponse struct {
+	Allow bool `json:"allow"`
+	// Shown to the player when they're denied
+	Reason string `json:"reason"`
+}
+
+func (app *App) callExternalAuthURL(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.Config().ExternalAuth.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := app.MakeHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with status %d", app.Config().ExternalAuth.URL, res.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1e6))
+}
+
+func (app *App) callExternalAuthCommand(ctx context.Context, body []byte) ([]byte, error) {
+	command := app.Config().ExternalAuth.Command
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	return cmd.Output()
+}
+
+// Ask ExternalAuth whether user may sign in. A nil response means the check
+// couldn't be made, in which case err says why.
+func (app *App) CheckExternalAuth(user *User, ip string, userAgent string) (*externalAuthResponse, error) {
+	body, err := json.Marshal(externalAuthRequest{
+		UUID:       user.UUID,
+		Username:   user.Username,
+		PlayerName: user.PlayerName,
+		IP:         ip,
+		UserAgent:  userAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(app.Config().ExternalAuth.TimeoutSec)*time.Second)
+	defer cancel()
+
+	var output []byte
+	if app.Config().ExternalAuth.URL != "" {
+		output, err = app.callExternalAuthURL(ctx, body)
+	} else {
+		output, err = app.callExternalAuthCommand(ctx, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res externalAuthResponse
+	if err := json.Unmarshal(output, &res); err != nil {
+		return nil, fmt.Errorf("invalid response: %s", err)
+	}
+	return &res, nil
+}
+
+// Whether user may sign in according to ExternalAuth, and the message to show
+// them if not. Always allowed if ExternalAuth is disabled.
+func (app *App) ExternalAuthAllows(user *User, ip string, userAgent string) (bool, string) {
+	if !app.Config().ExternalAuth.Enable {
+		return true, ""
+	}
+	res, err := app.CheckExternalAuth(user, ip, userAgent)
+	if err != nil {
+		log.Printf("Couldn't check external authentication for %s: %s\n", user.Username, err)
+		if app.Config().ExternalAuth.AllowOnError {
+			return true, ""
+		}
+		return false, "Couldn't verify your account. Try again later."
+	}
+	if !res.Allow {
+		reason := res.Reason
+		if reason == "" {
+			reason = "You aren't allowed to sign in."
+		}
+		return false, reason
+	}
+	return true, ""
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+Management of the FallbackAPIServers from the Admin page and the admin API.
+Servers can be added, removed, reordered, and disabled without restarting
+Drasl. The resulting list is stored as a ConfigOverride, replacing the
+FallbackAPIServers of the config file; see config_overrides.go.
+*/
+
+const FALLBACK_API_SERVER_PROBE_TIMEOUT = 10 * time.Second
+
+var errFallbackAPIServerNotFound = errors.New("fallback API server not found")
+
+// The new FallbackAPIServers aren't valid
+type InvalidFallbackAPIServersError struct {
+	Err error
+}
+
+func (err *InvalidFallbackAPIServersError) Error() string {
+	return err.Err.Error()
+}
+
+// Check that each of fallbackAPIServer's URLs responds. Any response short of
+// a server error means the server is up.
+func ProbeFallbackAPIServer(client *http.Client, fallbackAPIServer *FallbackAPIServer) error {
+	var problems []string
+	for _, serverURL := range []string{fallbackAPIServer.SessionURL, fallbackAPIServer.AccountURL, fallbackAPIServer.ServicesURL} {
+		if serverURL == "" {
+			continue
+		}
+		res, err := client.Get(serverURL)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode >= 500 {
+			problems = append(problems, fmt.Sprintf("%s responded with %s", serverURL, res.Status))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (app *App) ProbeFallbackAPIServer(fallbackAPIServer *FallbackAPIServer) error {
+	client := app.MakeHTTPClient()
+	client.Timeout = FALLBACK_API_SERVER_PROBE_TIMEOUT
+	return ProbeFallbackAPIServer(client, fallbackAPIServer)
+}
+
+func (app *App) GetFallbackAPIServer(nickname string) (*FallbackAPIServer, error) {
+	for _, fallbackAPIServer := range app.Config().FallbackAPIServers {
+		if fallbackAPIServer.Nickname == nickname {
+			return &fallbackAPIServer, nil
+		}
+	}
+	return nil, errFallbackAPIServerNotFound
+}
+
+// Replace the FallbackAPIServers, which are tried in the given order. Returns
+// an *InvalidFallbackAPIServersError if they aren't valid.
+func (app *App) SetFallbackAPIServers(fallbackAPIServers []FallbackAPIServer) error {
+	settings := *app.Config()
+	settings.FallbackAPIServers = fallbackAPIServers
+	overrides, config, err := app.MakeConfigOverrides(&settings)
+	if err != nil {
+		return &InvalidFallbackAPIServersError{Err: err}
+	}
+	_, err = app.SetConfigOverrides(overrides, config)
+	return err
+}
+
+// A copy of the FallbackAPIServers and the index of the one named nickname
+func (app *App) findFallbackAPIServer(nickname string) ([]FallbackAPIServer, int, error) {
+	current := app.Config().FallbackAPIServers
+	fallbackAPIServers := make([]FallbackAPIServer, len(current))
+	copy(fallbackAPIServers, current)
+	for i, fallbackAPIServer := range fallbackAPIServers {
+		if fallbackAPIServer.Nickname == nickname {
+			return fallbackAPIServers, i, nil
+		}
+	}
+	return nil, -1, errFallbackAPIServerNotFound
+}
+
+// Add a FallbackAPIServer, to be tried after the others
+func (app *App) AddFallbackAPIServer(fallbackAPIServer FallbackAPIServer) error {
+	current := app.Config().FallbackAPIServers
+	fallbackAPIServers := make([]FallbackAPIServer, 0, len(current)+1)
+	fallbackAPIServers = append(fallbackAPIServers, current...)
+	return app.SetFallbackAPIServers(append(fallbackAPIServers, fallbackAPIServer))
+}
+
+func (app *App) RemoveFallbackAPIServer(nickname string) error {
+	fallbackAPIServers, i, err := app.findFallbackAPIServer(nickname)
+	if err != nil {
+		return err
+	}
+	return app.SetFallbackAPIServers(append(fallbackAPIServers[:i], fallbackAPIServers[i+1:]...))
+}
+
+// Move a FallbackAPIServer up (offset -1) or down (offset 1) in the order
+// they're tried. Moving past either end of the list does nothing.
+func (app *App) MoveFallbackAPIServer(nickname string, offset int) error {
+	fallbackAPIServers, i, err := app.findFallbackAPIServer(nickname)
+	if err != nil {
+		return err
+	}
+	j := i + offset
+	if j < 0 || j >= len(fallbackAPIServers) {
+		return nil
+	}
+	fallbackAPIServers[i], fallbackAPIServers[j] = fallbackAPIServers[j], fallbackAPIServers[i]
+	return app.SetFallbackAPIServers(fallbackAPIServers)
+}
+
+func (app *App) SetFallbackAPIServerDisabled(nickname string, disabled bool) error {
+	fallbackAPIServers, i, err := app.findFallbackAPIServer(nickname)
+	if err != nil {
+		return err
+	}
+	fallbackAPIServers[i].Disabled = disabled
+	return app.SetFallbackAPIServers(fallbackAPIServers)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestFallbackAPIServers(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"Bob"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test managing fallback API servers on the Admin page", ts.testFrontFallbackAPIServers)
+		t.Run("Test /drasl/api/v1/admin/fallback-api-servers", ts.testAPIAdminFallbackAPIServers)
+	}
+}
+
+func fallbackAPIServerNicknames(fallbackAPIServers []FallbackAPIServer) []string {
+	nicknames := make([]string, 0, len(fallbackAPIServers))
+	for _, fallbackAPIServer := range fallbackAPIServers {
+		nicknames = append(nicknames, fallbackAPIServer.Nickname)
+	}
+	return nicknames
+}
+
+func (ts *TestSuite) postFallbackAPIServersAction(t *testing.T, cookie *http.Cookie, action string, nickname string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/admin/fallback-api-servers")
+	form.Set("action", action)
+	form.Set("nickname", nickname)
+	return ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", form, []http.Cookie{*cookie}, nil)
+}
+
+func (ts *TestSuite) testFrontFallbackAPIServers(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, "Bob")
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, "Alice")
+
+	rec := ts.Get(t, ts.Server, "/drasl/admin/fallback-api-servers", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	aux := ts.ToFallbackAPIServer(ts.AuxApp, "Aux")
+	newServerForm := func(action string, nickname string) url.Values {
+		form := url.Values{}
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/admin/fallback-api-servers")
+		form.Set("action", action)
+		form.Set("nickname", nickname)
+		form.Set("sessionUrl", aux.SessionURL)
+		form.Set("accountUrl", aux.AccountURL)
+		form.Set("servicesUrl", aux.ServicesURL)
+		form.Set("skinDomains", "localhost, textures.example.com")
+		form.Set("cacheTtlSeconds", "60")
+		return form
+	}
+	{
+		// Non-admins should not be able to add servers
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", newServerForm("add", "Aux"), []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+		assert.Equal(t, 0, len(ts.App.Config().FallbackAPIServers))
+	}
+	{
+		// Testing a new server shouldn't add it
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", newServerForm("test-new", "Aux"), []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Aux is reachable.")
+		assert.Equal(t, 0, len(ts.App.Config().FallbackAPIServers))
+
+		form := newServerForm("test-new", "Down")
+		form.Set("sessionUrl", "http://localhost:1")
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Couldn&#39;t reach Down")
+	}
+	{
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", newServerForm("add", "Aux"), []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", newServerForm("add", "Aux 2"), []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+
+		assert.Equal(t, []string{"Aux", "Aux 2"}, fallbackAPIServerNicknames(ts.App.Config().FallbackAPIServers))
+		assert.Equal(t, []string{"localhost", "textures.example.com"}, ts.App.Config().FallbackAPIServers[0].SkinDomains)
+		assert.Equal(t, 60, ts.App.Config().FallbackAPIServers[0].CacheTTLSeconds)
+
+		// Nicknames must be unique
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/fallback-api-servers", newServerForm("add", "Aux"), []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Duplicate FallbackAPIServer Nickname Aux")
+
+		// New servers are used right away
+		rec = ts.Get(t, ts.Server, "/authlib-injector", nil, nil)
+		assert.Contains(t, rec.Body.String(), "textures.example.com")
+	}
+	{
+		rec := ts.postFallbackAPIServersAction(t, browserTokenCookie, "test", "Aux")
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		rec = ts.postFallbackAPIServersAction(t, browserTokenCookie, "down", "Aux")
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, []string{"Aux 2", "Aux"}, fallbackAPIServerNicknames(ts.App.Config().FallbackAPIServers))
+
+		// Moving past the end does nothing
+		ts.postFallbackAPIServersAction(t, browserTokenCookie, "down", "Aux")
+		assert.Equal(t, []string{"Aux 2", "Aux"}, fallbackAPIServerNicknames(ts.App.Config().FallbackAPIServers))
+
+		ts.postFallbackAPIServersAction(t, browserTokenCookie, "disable", "Aux 2")
+		assert.True(t, ts.App.Config().FallbackAPIServers[0].Disabled)
+		assert.Equal(t, []string{"Aux"}, fallbackAPIServerNicknames(ts.App.Config().EnabledFallbackAPIServers()))
+		ts.postFallbackAPIServersAction(t, browserTokenCookie, "enable", "Aux 2")
+		assert.False(t, ts.App.Config().FallbackAPIServers[0].Disabled)
+
+		ts.postFallbackAPIServersAction(t, browserTokenCookie, "remove", "Aux 2")
+		assert.Equal(t, []string{"Aux"}, fallbackAPIServerNicknames(ts.App.Config().FallbackAPIServers))
+
+		rec = ts.postFallbackAPIServersAction(t, browserTokenCookie, "remove", "Nonexistent")
+		assert.Equal(t, "Fallback API server not found.", getErrorMessage(rec))
+
+		// The list is kept across restarts
+		overrides := Unwrap(GetConfigOverrides(ts.App.DB))
+		config := Unwrap(ApplyConfigOverrides(&ts.App.FileConfig, overrides))
+		assert.Equal(t, []string{"Aux"}, fallbackAPIServerNicknames(config.FallbackAPIServers))
+	}
+}
+
+func (ts *TestSuite) putFallbackAPIServers(t *testing.T, payload interface{}, accessToken *string) *httptest.ResponseRecorder {
+	body := Unwrap(json.Marshal(payload))
+	req := httptest.NewRequest(http.MethodPut, "/drasl/api/v1/admin/fallback-api-servers", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+*accessToken)
+	rec := httptest.NewRecorder()
+	ts.Server.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *TestSuite) testAPIAdminFallbackAPIServers(t *testing.T) {
+	accessToken := ts.authenticate(t, "Bob", TEST_PASSWORD).AccessToken
+	otherAccessToken := ts.authenticate(t, "Alice", TEST_PASSWORD).AccessToken
+
+	rec := ts.Get(t, ts.Server, "/drasl/api/v1/admin/fallback-api-servers", nil, &otherAccessToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/fallback-api-servers", nil, &accessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response apiFallbackAPIServers
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, 1, len(response.FallbackAPIServers))
+	assert.Equal(t, "Aux", response.FallbackAPIServers[0].Nickname)
+
+	aux := apiFallbackAPIServer(ts.ToFallbackAPIServer(ts.AuxApp, "Aux"))
+	disabled := apiFallbackAPIServer(ts.ToFallbackAPIServer(ts.AuxApp, "Disabled"))
+	disabled.Disabled = true
+	{
+		rec := ts.putFallbackAPIServers(t, apiFallbackAPIServers{FallbackAPIServers: []apiFallbackAPIServer{disabled, aux}}, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{"Disabled", "Aux"}, fallbackAPIServerNicknames(ts.App.Config().FallbackAPIServers))
+		assert.Equal(t, []string{"Aux"}, fallbackAPIServerNicknames(ts.App.Config().EnabledFallbackAPIServers()))
+
+		rec = ts.putFallbackAPIServers(t, apiFallbackAPIServers{FallbackAPIServers: []apiFallbackAPIServer{{Nickname: "Missing URLs"}}}, &accessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		var errorResponse ErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+		assert.Equal(t, "FallbackAPIServer AccountURL must be set", *errorResponse.ErrorMessage)
+		assert.Equal(t, 2, len(ts.App.Config().FallbackAPIServers))
+	}
+	{
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/fallback-api-servers/test", aux, nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response apiTestFallbackAPIServerResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.True(t, response.Reachable)
+		assert.Nil(t, response.Error)
+
+		down := aux
+		down.SessionURL = "http://localhost:1"
+		rec = ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/fallback-api-servers/test", down, nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.False(t, response.Reachable)
+		assert.NotNil(t, response.Error)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"io"
+	"log"
+	"lukechampine.com/blake3"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+/*
+Proxying of textures from fallback API servers. If a FallbackAPIServer has
+ProxyTextures set, skins and capes in the profiles it returns are downloaded
+once, stored under StateDirectory/fallback-texture, and served from
+TextureBaseURL like any other texture, so Minecraft clients never have to
+reach the fallback server's skin domains. The rewritten textures property is
+re-signed with Drasl's own key.
+*/
+
+// Whether host is one of skinDomains, using authlib-injector's rules: a
+// domain starting with "." matches any subdomain
+func MatchesSkinDomain(host string, skinDomains []string) bool {
+	for _, skinDomain := range skinDomains {
+		if strings.HasPrefix(skinDomain, ".") {
+			if strings.HasSuffix(host, skinDomain) {
+				return true
+			}
+		} else if host == skinDomain {
+			return true
+		}
+	}
+	return false
+}
+
+// Fallback textures are stored by the hash of their original URL, so they
+// only need to be downloaded once. Mojang's texture URLs already contain the
+// hash of the texture, so the contents behind a URL never change.
+func fallbackTextureFilename(textureURL string) string {
+	sum := blake3.Sum256([]byte(textureURL))
+	return hex.EncodeToString(sum[:]) + ".png"
+}
+
+func GetFallbackTexturePath(app *App, filename string) string {
+	return path.Join(app.Config().StateDirectory, "fallback-texture", filename)
+}
+
+func FallbackTextureURL(app *App, filename string) (string, error) {
+	return url.JoinPath(app.TextureURL, "drasl/texture/fallback/"+filename)
+}
+
+// Download the texture at textureURL, unless it's already stored, and return
+// the URL to serve it from
+func (app *App) proxyFallbackTexture(textureURL string) (string, error) {
+	filename := fallbackTextureFilename(textureURL)
+	texturePath := GetFallbackTexturePath(app, filename)
+
+	unlock := app.FSMutex.Lock(texturePath)
+	defer unlock()
+
+	_, err := os.Stat(texturePath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if os.IsNotExist(err) {
+		res, err := app.GetTexture(textureURL)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("request to %s resulted in status code %d", textureURL, res.StatusCode)
+		}
+
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(io.LimitReader(res.Body, 10e6)); err != nil {
+			return "", err
+		}
+		if _, err := png.DecodeConfig(bytes.NewReader(buf.Bytes())); err != nil {
+			return "", fmt.Errorf("texture at %s is not a valid PNG: %s", textureURL, err)
+		}
+
+		if err := os.MkdirAll(path.Dir(texturePath), os.ModePerm); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(texturePath, buf.Bytes(), 0644); err != nil {
+			return "", err
+		}
+	}
+
+	return FallbackTextureURL(app, filename)
+}
+
+// Point the skin and cape in a textures property from fallbackAPIServer to
+// local copies. Textures outside the server's SkinDomains or
+// AllowedTextureDomains are left alone.
+// If anything goes wrong, the property is returned unchanged, since the
+// original textures are still better than none.
+func (app *App) ProxyFallbackTextures(fallbackAPIServer *FallbackAPIServer, property SessionProfileProperty) SessionProfileProperty {
+	if !fallbackAPIServer.ProxyTextures || property.Name != "textures" {
+		return property
+	}
+
+	valueBlob, err := base64.StdEncoding.DecodeString(property.Value)
+	if err != nil {
+		log.Printf("Received invalid textures property from fallback API server %s\n", fallbackAPIServer.Nickname)
+		return property
+	}
+	var value texturesValue
+	if err := json.Unmarshal(valueBlob, &value); err != nil {
+		log.Printf("Received invalid textures property from fallback API server %s\n", fallbackAPIServer.Nickname)
+		return property
+	}
+
+	changed := false
+	for _, texture := range []*texture{value.Textures.Skin, value.Textures.Cape} {
+		if texture == nil {
+			continue
+		}
+		textureURL, err := url.Parse(texture.URL)
+		if err != nil || !MatchesSkinDomain(textureURL.Hostname(), fallbackAPIServer.SkinDomains) || !app.IsAllowedTextureURL(texture.URL) {
+			continue
+		}
+		localURL, err := app.proxyFallbackTexture(texture.URL)
+		if err != nil {
+			log.Printf("Couldn't proxy texture from fallback API server %s: %s\n", fallbackAPIServer.Nickname, err)
+			return property
+		}
+		texture.URL = localURL
+		changed = true
+	}
+	if !changed {
+		return property
+	}
+
+	newValueBlob, err := json.Marshal(value)
+	if err != nil {
+		return property
+	}
+	newProperty := SessionProfileProperty{
+		Name:  property.Name,
+		Value: base64.StdEncoding.EncodeToString(newValueBlob),
+	}
+	if property.Signature != nil {
+		// The fallback server's signature no longer matches, so sign it
+		// ourselves
+		signature, err := SignSHA1(app, []byte(newProperty.Value))
+		if err != nil {
+			log.Printf("Couldn't sign proxied textures property: %s\n", err)
+			return property
+		}
+		newProperty.Signature = Ptr(base64.StdEncoding.EncodeToString(signature))
+	}
+	return newProperty
+}
+
+// Apply ProxyFallbackTextures to a whole profile returned by
+// fallbackAPIServer
+func (app *App) ProxyFallbackProfileTextures(fallbackAPIServer *FallbackAPIServer, profile *SessionProfileResponse) {
+	for i := range profile.Properties {
+		profile.Properties[i] = app.ProxyFallbackTextures(fallbackAPIServer, profile.Properties[i])
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+TLS and DNS settings for individual fallback API servers, for self-hosted
+Drasl or ely.by servers with private CAs. Requests to the hosts of a
+FallbackAPIServer's URLs and SkinDomains can trust the CAs in its CACertFile
+instead of the system's, require one of its PinnedPublicKeys in the
+certificate chain, and connect to its Address instead of looking the host up
+in DNS. Other requests, and servers without any of these settings, use the
+usual transport.
+*/
+
+// Whether fallbackAPIServer needs a transport of its own
+func (fallbackAPIServer *FallbackAPIServer) HasTransportSettings() bool {
+	return fallbackAPIServer.CACertFile != "" || len(fallbackAPIServer.PinnedPublicKeys) > 0 || fallbackAPIServer.Address != ""
+}
+
+// Whether requests to host go to fallbackAPIServer
+func (fallbackAPIServer *FallbackAPIServer) ServesHost(host string) bool {
+	for _, serverURL := range []string{fallbackAPIServer.AccountURL, fallbackAPIServer.SessionURL, fallbackAPIServer.ServicesURL} {
+		parsed, err := url.Parse(serverURL)
+		if err == nil && parsed.Hostname() == host {
+			return true
+		}
+	}
+	return MatchesSkinDomain(host, fallbackAPIServer.SkinDomains)
+}
+
+// The base64-encoded SHA-256 hash of a certificate's public key, the format
+// of PinnedPublicKeys
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func LoadCACertFile(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+func ValidatePublicKeyPin(pin string) error {
+	sum, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(sum) != sha256.Size {
+		return errors.New("must be a base64-encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// Dispatches each request to the transport of the fallback API server it's
+// for, if that server has transport settings
+type fallbackAPIServerTransport struct {
+	config *Config
+	base   *http.Transport
+	mutex  sync.Mutex
+	// Keyed by the server's settings, so a server whose settings change gets
+	// a new transport
+	transports map[string]*http.Transport
+}
+
+func (transport *fallbackAPIServerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, fallbackAPIServer := range PtrSlice(transport.config.FallbackAPIServers) {
+		if !fallbackAPIServer.HasTransportSettings() || !fallbackAPIServer.ServesHost(req.URL.Hostname()) {
+			continue
+		}
+		serverTransport, err := transport.get(fallbackAPIServer)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't set up the connection to fallback API server %s: %s", fallbackAPIServer.Nickname, err)
+		}
+		return serverTransport.RoundTrip(req)
+	}
+	return transport.base.RoundTrip(req)
+}
+
+func (transport *fallbackAPIServerTransport) get(fallbackAPIServer *FallbackAPIServer) (*http.Transport, error) {
+	key := strings.Join([]string{
+		fallbackAPIServer.Nickname,
+		fallbackAPIServer.CACertFile,
+		strings.Join(fallbackAPIServer.PinnedPublicKeys, ","),
+		fallbackAPIServer.Address,
+	}, "\n")
+
+	transport.mutex.Lock()
+	defer transport.mutex.Unlock()
+	if serverTransport, ok := transport.transports[key]; ok {
+		return serverTransport, nil
+	}
+	serverTransport, err := makeFallbackAPIServerTransport(transport.base, fallbackAPIServer)
+	if err != nil {
+		return nil, err
+	}
+	transport.transports[key] = serverTransport
+	return serverTransport, nil
+}
+
+func makeFallbackAPIServerTransport(base *http.Transport, fallbackAPIServer *FallbackAPIServer) (*http.Transport, error) {
+	serverTransport := base.Clone()
+	tlsConfig := &tls.Config{}
+	if base.TLSClientConfig != nil {
+		tlsConfig = base.TLSClientConfig.Clone()
+	}
+
+	if fallbackAPIServer.CACertFile != "" {
+		pool, err := LoadCACertFile(fallbackAPIServer.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(fallbackAPIServer.PinnedPublicKeys) > 0 {
+		pins := fallbackAPIServer.PinnedPublicKeys
+		// Runs after the usual verification, so the pinned key must be part
+		// of a chain the server's certificate was verified against
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, chain := range state.VerifiedChains {
+				for _, cert := range chain {
+					if Contains(pins, PublicKeyPin(cert)) {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("certificate of %s doesn't match any of the PinnedPublicKeys", state.ServerName)
+		}
+	}
+	serverTransport.TLSClientConfig = tlsConfig
+
+	if fallbackAPIServer.Address != "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		serverTransport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			// Connections to a proxy are left alone
+			if fallbackAPIServer.ServesHost(host) {
+				addr = net.JoinHostPort(fallbackAPIServer.Address, port)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return serverTransport, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFallbackAPIServerTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sd := Unwrap(os.MkdirTemp("", "tmp"))
+	defer os.RemoveAll(sd)
+	caCertFile := path.Join(sd, "ca.pem")
+	assert.Nil(t, os.WriteFile(caCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+
+	get := func(fallbackAPIServer FallbackAPIServer, reqURL string) error {
+		config := testConfig()
+		config.FallbackAPIServers = []FallbackAPIServer{fallbackAPIServer}
+		res, err := makeHTTPClient(MakeHTTPTransport(config)).Get(reqURL)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+		return nil
+	}
+	fallbackAPIServer := FallbackAPIServer{
+		Nickname:    "Private",
+		SessionURL:  server.URL,
+		AccountURL:  server.URL,
+		ServicesURL: server.URL,
+	}
+
+	// The server's certificate isn't trusted by the system
+	assert.NotNil(t, get(fallbackAPIServer, server.URL))
+
+	withCA := fallbackAPIServer
+	withCA.CACertFile = caCertFile
+	assert.Nil(t, get(withCA, server.URL))
+
+	withPin := withCA
+	withPin.PinnedPublicKeys = []string{PublicKeyPin(server.Certificate())}
+	assert.Nil(t, get(withPin, server.URL))
+	withPin.PinnedPublicKeys = []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}
+	assert.NotNil(t, get(withPin, server.URL))
+
+	// The test certificate is valid for example.com, which is pinned to the
+	// test server's address instead of being looked up
+	serverURL := Unwrap(url.Parse(server.URL))
+	pinnedURL := "https://example.com:" + serverURL.Port()
+	withAddress := withCA
+	withAddress.SessionURL = pinnedURL
+	withAddress.AccountURL = pinnedURL
+	withAddress.ServicesURL = pinnedURL
+	withAddress.Address = serverURL.Hostname()
+	assert.Nil(t, get(withAddress, pinnedURL+"/session/minecraft/profile"))
+}
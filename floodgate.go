@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Support for Bedrock players joining through Geyser and Floodgate. Floodgate
+gives each Bedrock player a UUID whose upper 64 bits are zero and whose lower
+64 bits are their Xbox user ID (XUID), and a player name starting with
+Floodgate.UsernamePrefix. A user can link their Bedrock identity to their
+Drasl account by entering a code, shown on their profile page, on a Bedrock
+server; the server reports the code and the player's XUID to
+/drasl/api/v2/server/bedrock-link. Afterwards, looking up the player's
+Floodgate UUID returns the linked account's profile, so they have the same
+skin and cape on Java and Bedrock.
+*/
+
+const FLOODGATE_UUID_PREFIX = "00000000-0000-0000-"
+
+const BEDROCK_LINK_CODE_LENGTH = 8
+
+var errBedrockLinkCodeNotFound = errors.New("Bedrock link code not found")
+var errBedrockAccountAlreadyLinked = errors.New("Bedrock account already linked")
+
+// Whether uuid is one Floodgate made up for a Bedrock player
+func IsFloodgateUUID(uuid string) bool {
+	return strings.HasPrefix(uuid, FLOODGATE_UUID_PREFIX)
+}
+
+func FloodgateUUIDToXUID(uuid string) (string, error) {
+	if !IsFloodgateUUID(uuid) || len(uuid) != 36 {
+		return "", errors.New("not a Floodgate UUID")
+	}
+	xuid, err := strconv.ParseUint(strings.ReplaceAll(uuid[len(FLOODGATE_UUID_PREFIX):], "-", ""), 16, 64)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(xuid, 10), nil
+}
+
+func XUIDToFloodgateUUID(xuid string) (string, error) {
+	n, err := strconv.ParseUint(xuid, 10, 64)
+	if err != nil {
+		return "", errors.New("invalid XUID")
+	}
+	hex := fmt.Sprintf("%016x", n)
+	return FLOODGATE_UUID_PREFIX + hex[0:4] + "-" + hex[4:], nil
+}
+
+func (app *App) CreateBedrockLinkCode(user *User) (*BedrockLinkCode, error) {
+	now := time.Now()
+	if err := app.DB.Where("expires_at < ?", now).Delete(&BedrockLinkCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	code, err := randomUserCodeLetters(BEDROCK_LINK_CODE_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	linkCode := BedrockLinkCode{
+		Code:      string(code),
+		UserUUID:  user.UUID,
+		ExpiresAt: now.Add(time.Duration(app.Config().Floodgate.LinkCodeExpireSec) * time.Second),
+	}
+	if err := app.DB.Create(&linkCode).Error; err != nil {
+		return nil, err
+	}
+	return &linkCode, nil
+}
+
+// Link the Bedrock player to the account that created the code, replacing
+// any link the account already had. The code can only be used once.
+func (app *App) ClaimBedrockLinkCode(code string, xuid string, gamertag string) (*User, error) {
+	if _, err := XUIDToFloodgateUUID(xuid); err != nil {
+		return nil, err
+	}
+
+	var user User
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var linkCode BedrockLinkCode
+		err := tx.First(&linkCode, "code = ? AND expires_at > ?", strings.ToUpper(code), time.Now()).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errBedrockLinkCodeNotFound
+			}
+			return err
+		}
+		if err := tx.First(&user, "uuid = ?", linkCode.UserUUID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errBedrockLinkCodeNotFound
+			}
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&BedrockLink{}).Where("xuid = ? AND user_uuid != ?", xuid, user.UUID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return errBedrockAccountAlreadyLinked
+		}
+
+		if err := tx.Delete(&linkCode).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_uuid = ?", user.UUID).Delete(&BedrockLink{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&BedrockLink{
+			XUID:      xuid,
+			UserUUID:  user.UUID,
+			Gamertag:  gamertag,
+			CreatedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// The user's Bedrock link, or nil if they haven't linked a Bedrock account
+func (app *App) GetBedrockLink(user *User) (*BedrockLink, error) {
+	var link BedrockLink
+	if err := app.DB.First(&link, "user_uuid = ?", user.UUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (app *App) UnlinkBedrock(user *User) error {
+	return app.DB.Where("user_uuid = ?", user.UUID).Delete(&BedrockLink{}).Error
+}
+
+// The user linked to the Bedrock player with the given XUID, if any
+func (app *App) FindUserByXUID(xuid string) (*User, *BedrockLink, error) {
+	var link BedrockLink
+	if err := app.DB.First(&link, "xuid = ?", xuid).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", link.UserUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return &user, &link, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Central management of player info forwarding secrets for proxy networks.
+Instead of copying a secret into the config of a proxy and each of its
+backends by hand, admins create one per backend on the Admin page, and the
+servers fetch them from /drasl/api/v2/server/forwarding-secrets using their
+TrustedServers token. Rotating a secret only takes effect once the servers
+fetch it again.
+
+Proxies that don't speak modern forwarding themselves can also have Drasl
+verify a player's join and sign the player info for a backend, in the format
+Velocity sends it; see MakeModernForwardingData.
+*/
+
+const MAX_BACKEND_NAME_LENGTH = 64
+
+var errForwardingSecretExists = errors.New("forwarding secret already exists")
+
+func ValidateBackendName(backend string) error {
+	if strings.TrimSpace(backend) == "" {
+		return errors.New("can't be blank")
+	}
+	if utf8.RuneCountInString(backend) > MAX_BACKEND_NAME_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_BACKEND_NAME_LENGTH)
+	}
+	return nil
+}
+
+func (app *App) CreateForwardingSecret(backend string) (*ForwardingSecret, error) {
+	if err := ValidateBackendName(backend); err != nil {
+		return nil, err
+	}
+	secret, err := RandomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	forwardingSecret := ForwardingSecret{
+		Backend:   backend,
+		Secret:    secret,
+		CreatedAt: now,
+		RotatedAt: now,
+	}
+	if err := app.DB.Create(&forwardingSecret).Error; err != nil {
+		if IsErrorUniqueFailed(err) {
+			return nil, errForwardingSecretExists
+		}
+		return nil, err
+	}
+	return &forwardingSecret, nil
+}
+
+// Replace the backend's secret with a new one
+func (app *App) RotateForwardingSecret(backend string) (*ForwardingSecret, error) {
+	var forwardingSecret ForwardingSecret
+	if err := app.DB.First(&forwardingSecret, "backend = ?", backend).Error; err != nil {
+		return nil, err
+	}
+	secret, err := RandomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	forwardingSecret.Secret = secret
+	forwardingSecret.RotatedAt = time.Now()
+	if err := app.DB.Save(&forwardingSecret).Error; err != nil {
+		return nil, err
+	}
+	return &forwardingSecret, nil
+}
+
+func (app *App) DeleteForwardingSecret(backend string) error {
+	result := app.DB.Delete(&ForwardingSecret{}, "backend = ?", backend)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (app *App) GetForwardingSecrets() ([]ForwardingSecret, error) {
+	var forwardingSecrets []ForwardingSecret
+	err := app.DB.Order("backend").Find(&forwardingSecrets).Error
+	return forwardingSecrets, err
+}
+
+// Whether trustedServer may read and verify the backend's secret: proxies
+// can use every backend's, and backends only their own
+func (trustedServer *TrustedServer) CanUseForwardingSecret(backend string) bool {
+	return trustedServer.IsProxy || trustedServer.Nickname == backend
+}
+
+// Whether secret is the backend's current secret
+func (app *App) VerifyForwardingSecret(backend string, secret string) (bool, error) {
+	var forwardingSecret ForwardingSecret
+	if err := app.DB.First(&forwardingSecret, "backend = ?", backend).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(forwardingSecret.Secret)) == 1, nil
+}
+
+// The version of Velocity's modern forwarding data made by
+// MakeModernForwardingData. Backends that ask for a later version accept
+// this one too.
+const MODERN_FORWARDING_VERSION = 1
+
+func writeVarInt(buf *bytes.Buffer, value int) {
+	varInt := make([]byte, binary.MaxVarintLen32)
+	buf.Write(varInt[:binary.PutUvarint(varInt, uint64(uint32(value)))])
+}
+
+func writeMinecraftString(buf *bytes.Buffer, value string) {
+	writeVarInt(buf, len(value))
+	buf.WriteString(value)
+}
+
+// The player info in `profile`, connecting from `clientAddress`, as Velocity
+// forwards it to a backend: an HMAC-SHA256 signature made with the backend's
+// secret, followed by the data. A proxy answers the backend's
+// velocity:player_info login plugin request with it.
+func (app *App) MakeModernForwardingData(backend string, clientAddress string, profile *SessionProfileResponse) ([]byte, error) {
+	var forwardingSecret ForwardingSecret
+	if err := app.DB.First(&forwardingSecret, "backend = ?", backend).Error; err != nil {
+		return nil, err
+	}
+	uuid, err := hex.DecodeString(profile.ID)
+	if err != nil || len(uuid) != 16 {
+		return nil, fmt.Errorf("Invalid profile ID %s", profile.ID)
+	}
+
+	var data bytes.Buffer
+	writeVarInt(&data, MODERN_FORWARDING_VERSION)
+	writeMinecraftString(&data, clientAddress)
+	data.Write(uuid)
+	writeMinecraftString(&data, profile.Name)
+	writeVarInt(&data, len(profile.Properties))
+	for _, property := range profile.Properties {
+		writeMinecraftString(&data, property.Name)
+		writeMinecraftString(&data, property.Value)
+		if property.Signature == nil {
+			data.WriteByte(0)
+		} else {
+			data.WriteByte(1)
+			writeMinecraftString(&data, *property.Signature)
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(forwardingSecret.Secret))
+	mac.Write(data.Bytes())
+	return append(mac.Sum(nil), data.Bytes()...), nil
+}
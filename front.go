@@ -2,13 +2,13 @@ package main
 
 import (
 	"bytes"
-	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/yuin/goldmark"
 	"gorm.io/gorm"
 	"html/template"
 	"image"
@@ -20,8 +20,11 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"path"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 /*
@@ -30,6 +33,13 @@ Web front end for creating user accounts, changing passwords, skins, player name
 
 const BROWSER_TOKEN_AGE_SEC = 24 * 60 * 60
 
+// How long a success, warning, or error message waits to be shown
+const MESSAGE_AGE_SEC = 5 * 60
+
+// How long a browser is remembered as one the user has signed in from before,
+// for new-device notifications
+const KNOWN_DEVICE_AGE_SEC = 365 * 24 * 60 * 60
+
 // Must be in a region of the skin that supports translucency
 const SKIN_WINDOW_X_MIN = 40
 const SKIN_WINDOW_X_MAX = 48
@@ -47,28 +57,43 @@ func NewTemplate(app *App) *Template {
 		Templates: make(map[string]*template.Template),
 	}
 
-	templateDir := path.Join(app.Config.DataDirectory, "view")
-
 	names := []string{
 		"root",
 		"profile",
 		"registration",
 		"challenge-skin",
 		"admin",
+		"maintenance",
+		"delete-user",
+		"group",
+		"stats",
+		"admin-email",
+		"admin-settings",
+		"admin-fallback-api-servers",
+		"admin-reports",
+		"admin-api-usage",
+		"device",
+		"qr-login",
+		"qr-login-claim",
+		"custom-page",
+		"api-docs",
 	}
 
 	funcMap := template.FuncMap{
-		"UserSkinURL":    UserSkinURL,
-		"InviteURL":      InviteURL,
-		"IsDefaultAdmin": IsDefaultAdmin,
+		"UserSkinURL":               UserSkinURL,
+		"InviteURL":                 InviteURL,
+		"IsDefaultAdmin":            IsDefaultAdmin,
+		"FormatBytes":               FormatBytes,
+		"RegistrationRequiresEmail": RegistrationRequiresEmail,
+		"CustomPageURL":             CustomPageURL,
 	}
 
 	for _, name := range names {
 		tmpl := Unwrap(template.New("").Funcs(funcMap).ParseFiles(
-			path.Join(templateDir, "layout.tmpl"),
-			path.Join(templateDir, name+".tmpl"),
-			path.Join(templateDir, "header.tmpl"),
-			path.Join(templateDir, "footer.tmpl"),
+			GetThemedPath(app, "view", "layout.tmpl"),
+			GetThemedPath(app, "view", name+".tmpl"),
+			GetThemedPath(app, "view", "header.tmpl"),
+			GetThemedPath(app, "view", "footer.tmpl"),
 		))
 		t.Templates[name] = tmpl
 	}
@@ -76,43 +101,64 @@ func NewTemplate(app *App) *Template {
 	return t
 }
 
+// Render Markdown to HTML. Raw HTML in the input is omitted.
+func RenderMarkdown(markdown string) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(markdown), &buf); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+func getAnnouncementHTML(app *App) (template.HTML, error) {
+	announcement, err := app.GetAnnouncement()
+	if err != nil || announcement == nil {
+		return "", err
+	}
+	return RenderMarkdown(announcement.Markdown)
+}
+
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
 	return t.Templates[name].ExecuteTemplate(w, "base", data)
 }
 
-func setSuccessMessage(c *echo.Context, message string) {
+// Set a cookie for the web front end. A negative maxAge deletes it.
+func setCookie(app *App, c *echo.Context, name string, value string, maxAge int) {
 	(*c).SetCookie(&http.Cookie{
-		Name:     "successMessage",
-		Value:    url.QueryEscape(message),
-		Path:     "/",
+		Name:     name,
+		Value:    value,
+		MaxAge:   maxAge,
+		Path:     app.CookiePath,
+		Secure:   app.Config().SecureCookies,
 		SameSite: http.SameSiteStrictMode,
 		HttpOnly: true,
 	})
 }
 
+// Messages are shown on the next page the user loads, then deleted
+func setMessageCookie(app *App, c *echo.Context, name string, message string) {
+	if message == "" {
+		setCookie(app, c, name, "", -1)
+	} else {
+		setCookie(app, c, name, url.QueryEscape(message), MESSAGE_AGE_SEC)
+	}
+}
+
+func setSuccessMessage(app *App, c *echo.Context, message string) {
+	setMessageCookie(app, c, "successMessage", message)
+}
+
 // Set a warning message
-func setWarningMessage(c *echo.Context, message string) {
-	(*c).SetCookie(&http.Cookie{
-		Name:     "warningMessage",
-		Value:    url.QueryEscape(message),
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-		HttpOnly: true,
-	})
+func setWarningMessage(app *App, c *echo.Context, message string) {
+	setMessageCookie(app, c, "warningMessage", message)
 }
 
 // Set an error message cookie
-func setErrorMessage(c *echo.Context, message string) {
-	(*c).SetCookie(&http.Cookie{
-		Name:     "errorMessage",
-		Value:    url.QueryEscape(message),
-		Path:     "/",
-		SameSite: http.SameSiteStrictMode,
-		HttpOnly: true,
-	})
+func setErrorMessage(app *App, c *echo.Context, message string) {
+	setMessageCookie(app, c, "errorMessage", message)
 }
 
-func lastSuccessMessage(c *echo.Context) string {
+func lastSuccessMessage(app *App, c *echo.Context) string {
 	cookie, err := (*c).Cookie("successMessage")
 	if err != nil || cookie.Value == "" {
 		return ""
@@ -121,11 +167,11 @@ func lastSuccessMessage(c *echo.Context) string {
 	if err != nil {
 		return ""
 	}
-	setSuccessMessage(c, "")
+	setSuccessMessage(app, c, "")
 	return decoded
 }
 
-func lastWarningMessage(c *echo.Context) string {
+func lastWarningMessage(app *App, c *echo.Context) string {
 	cookie, err := (*c).Cookie("warningMessage")
 	if err != nil || cookie.Value == "" {
 		return ""
@@ -134,12 +180,12 @@ func lastWarningMessage(c *echo.Context) string {
 	if err != nil {
 		return ""
 	}
-	setWarningMessage(c, "")
+	setWarningMessage(app, c, "")
 	return decoded
 }
 
 // Read and clear the error message cookie
-func lastErrorMessage(c *echo.Context) string {
+func lastErrorMessage(app *App, c *echo.Context) string {
 	cookie, err := (*c).Cookie("errorMessage")
 	if err != nil || cookie.Value == "" {
 		return ""
@@ -148,7 +194,7 @@ func lastErrorMessage(c *echo.Context) string {
 	if err != nil {
 		return ""
 	}
-	setErrorMessage(c, "")
+	setErrorMessage(app, c, "")
 	return decoded
 }
 
@@ -172,7 +218,7 @@ func withBrowserAuthentication(app *App, requireLogin bool, f func(c echo.Contex
 		var user User
 		if err != nil || cookie.Value == "" {
 			if requireLogin {
-				setErrorMessage(&c, "You are not logged in.")
+				setErrorMessage(app, &c, "You are not logged in.")
 				return c.Redirect(http.StatusSeeOther, returnURL)
 			}
 			return f(c, nil)
@@ -181,32 +227,85 @@ func withBrowserAuthentication(app *App, requireLogin bool, f func(c echo.Contex
 			if result.Error != nil {
 				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 					if requireLogin {
-						c.SetCookie(&http.Cookie{
-							Name:     "browserToken",
-							Value:    "",
-							MaxAge:   -1,
-							Path:     "/",
-							SameSite: http.SameSiteStrictMode,
-							HttpOnly: true,
-						})
-						setErrorMessage(&c, "You are not logged in.")
+						setCookie(app, &c, "browserToken", "", -1)
+						setErrorMessage(app, &c, "You are not logged in.")
 						return c.Redirect(http.StatusSeeOther, returnURL)
 					}
 					return f(c, nil)
 				}
 				return err
 			}
+
+			impersonatedUser, err := getImpersonatedUser(app, &c, &user)
+			if err != nil {
+				return err
+			}
+			if impersonatedUser != nil {
+				if c.Request().Method != http.MethodGet {
+					err := app.LogAudit(&user, AuditActionImpersonationRequest, impersonatedUser, c.Request().Method+" "+c.Path())
+					if err != nil {
+						return err
+					}
+				}
+				return f(c, impersonatedUser)
+			}
 			return f(c, &user)
 		}
 	}
 }
 
+// How long an admin can stay signed in as another user before they are
+// returned to their own account
+const IMPERSONATION_MAX_AGE_SEC = 60 * 60
+
+func setImpersonationCookie(app *App, c *echo.Context, userUUID string) {
+	maxAge := IMPERSONATION_MAX_AGE_SEC
+	if userUUID == "" {
+		maxAge = -1
+	}
+	setCookie(app, c, "impersonate", userUUID, maxAge)
+}
+
+// Remember that user has signed in from this browser. Returns whether they
+// had already.
+func markKnownDevice(app *App, c *echo.Context, user *User) bool {
+	token := makeEmailToken(app, "known-device", user.UUID)
+	cookie, err := (*c).Cookie("knownDevice")
+	known := err == nil && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) == 1
+	setCookie(app, c, "knownDevice", token, KNOWN_DEVICE_AGE_SEC)
+	return known
+}
+
+// If `admin` is signed in as another user, return that user with
+// ImpersonatedBy set. The `impersonate` cookie is only honored while its
+// owner is still an admin.
+func getImpersonatedUser(app *App, c *echo.Context, admin *User) (*User, error) {
+	cookie, err := (*c).Cookie("impersonate")
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	if !admin.IsAdmin {
+		setImpersonationCookie(app, c, "")
+		return nil, nil
+	}
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", cookie.Value).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setImpersonationCookie(app, c, "")
+			return nil, nil
+		}
+		return nil, err
+	}
+	user.ImpersonatedBy = admin
+	return &user, nil
+}
+
 func withBrowserAdmin(app *App, f func(c echo.Context, user *User) error) func(c echo.Context) error {
 	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
 		if !user.IsAdmin {
-			setErrorMessage(&c, "You are not an admin.")
+			setErrorMessage(app, &c, "You are not an admin.")
 			return c.Redirect(http.StatusSeeOther, returnURL)
 		}
 
@@ -223,20 +322,50 @@ func FrontRoot(app *App) func(c echo.Context) error {
 		SuccessMessage string
 		WarningMessage string
 		ErrorMessage   string
+		Announcement   template.HTML
 	}
 
 	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
+		announcement, err := getAnnouncementHTML(app)
+		if err != nil {
+			return err
+		}
 		return c.Render(http.StatusOK, "root", rootContext{
 			App:            app,
 			User:           user,
 			URL:            c.Request().URL.RequestURI(),
-			SuccessMessage: lastSuccessMessage(&c),
-			WarningMessage: lastWarningMessage(&c),
-			ErrorMessage:   lastErrorMessage(&c),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Announcement:   announcement,
 		})
 	})
 }
 
+// Shown in place of any page of the web front end when maintenance mode is
+// enabled, unless the user is an admin
+func FrontMaintenance(app *App) func(c echo.Context) error {
+	type maintenanceContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+	}
+
+	return func(c echo.Context) error {
+		return c.Render(http.StatusServiceUnavailable, "maintenance", maintenanceContext{
+			App:            app,
+			User:           nil,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+		})
+	}
+}
+
 type webManifestIcon struct {
 	Src   string `json:"src"`
 	Type  string `json:"type"`
@@ -264,6 +393,51 @@ func FrontWebManifest(app *App) func(c echo.Context) error {
 	}
 }
 
+// GET /drasl/api-docs
+func FrontAPIDocs(app *App, e *echo.Echo) func(c echo.Context) error {
+	type apiDocsContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		Sections       []apiDocsSectionRoutes
+	}
+
+	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
+		return c.Render(http.StatusOK, "api-docs", apiDocsContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Sections:       app.APIDocs(e.Routes()),
+		})
+	})
+}
+
+// GET /drasl/branding/logo
+func FrontBrandingLogo(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if app.Config().Branding.LogoFile == "" {
+			return echo.ErrNotFound
+		}
+		return c.File(app.Config().Branding.LogoFile)
+	}
+}
+
+// GET /drasl/branding/favicon
+func FrontBrandingFavicon(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if app.Config().Branding.FaviconFile == "" {
+			return echo.ErrNotFound
+		}
+		return c.File(app.Config().Branding.FaviconFile)
+	}
+}
+
 // GET /registration
 func FrontRegistration(app *App) func(c echo.Context) error {
 	type context struct {
@@ -282,16 +456,75 @@ func FrontRegistration(app *App) func(c echo.Context) error {
 			App:            app,
 			User:           user,
 			URL:            c.Request().URL.RequestURI(),
-			SuccessMessage: lastSuccessMessage(&c),
-			WarningMessage: lastWarningMessage(&c),
-			ErrorMessage:   lastErrorMessage(&c),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
 			InviteCode:     inviteCode,
 		})
 	})
 }
 
+// GET /drasl/pages/:slug
+func FrontCustomPage(app *App) func(c echo.Context) error {
+	type customPageContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		Page           *CustomPage
+		HTML           template.HTML
+	}
+
+	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
+		page := app.GetCustomPage(c.Param("slug"))
+		if page == nil {
+			return echo.ErrNotFound
+		}
+		html, err := app.RenderCustomPage(page)
+		if err != nil {
+			return err
+		}
+		return c.Render(http.StatusOK, "custom-page", customPageContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Page:           page,
+			HTML:           html,
+		})
+	})
+}
+
+// POST /drasl/accept-terms
+func FrontAcceptTerms(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().TermsOfService.RequireAcceptance {
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if c.FormValue("acceptTerms") != "on" {
+			setErrorMessage(app, &c, "You must accept the Terms of Service.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err := app.AcceptTerms(user); err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Terms of Service accepted.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
 // GET /drasl/admin
 func FrontAdmin(app *App) func(c echo.Context) error {
+	type giftCodeBatch struct {
+		Name      string
+		GiftCodes []GiftCode
+	}
 	type userEntry struct {
 		User    User
 		SkinURL *string
@@ -303,130 +536,1988 @@ func FrontAdmin(app *App) func(c echo.Context) error {
 		SuccessMessage string
 		WarningMessage string
 		ErrorMessage   string
-		Users          []User
-		Invites        []Invite
+		Users          []UserListEntry
+		UserList       *UserList
+		// Links to the neighboring pages of the user list, if any
+		PreviousUsersURL    string
+		NextUsersURL        string
+		Invites             []Invite
+		AuditLog            []AuditLogEntry
+		Groups              []Group
+		PendingUsers        []User
+		PendingAdminActions []PendingAdminAction
+		// Results for the "player" query parameter
+		PlayerSearch        string
+		PlayerSearchResults []User
+		GiftCodeBatches     []giftCodeBatch
+		ForwardingSecrets   []ForwardingSecret
 	}
 
 	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
-		var users []User
-		result := app.DB.Find(&users)
-		if result.Error != nil {
-			return result.Error
+		errorMessage := lastErrorMessage(app, &c)
+		userListQuery, err := ParseUserListQuery(c.QueryParams())
+		if err != nil {
+			errorMessage = err.Error()
+			userListQuery, _ = ParseUserListQuery(url.Values{})
+		}
+		userList, err := app.ListUsers(userListQuery)
+		if err != nil {
+			return err
+		}
+		usersURL := func(page int) string {
+			return app.FrontEndURL + "/drasl/admin?" + userListQuery.Values(page).Encode() + "#users"
+		}
+		var previousUsersURL, nextUsersURL string
+		if userListQuery.Page > 1 {
+			previousUsersURL = usersURL(userListQuery.Page - 1)
+		}
+		if userListQuery.Page < userList.PageCount {
+			nextUsersURL = usersURL(userListQuery.Page + 1)
+		}
+
+		pendingUsers := make([]User, 0)
+		if err := app.DB.Find(&pendingUsers, "is_pending_approval").Error; err != nil {
+			return err
+		}
+
+		var pendingAdminActions []PendingAdminAction
+		if app.Config().TwoPersonApproval.Enable {
+			pendingAdminActions, err = app.GetPendingAdminActions()
+			if err != nil {
+				return err
+			}
 		}
 
 		var invites []Invite
-		result = app.DB.Find(&invites)
+		result := app.DB.Find(&invites)
 		if result.Error != nil {
 			return result.Error
 		}
 
+		auditLog, err := app.GetAuditLog(50)
+		if err != nil {
+			return err
+		}
+
+		var groups []Group
+		if err := app.DB.Order("name").Find(&groups).Error; err != nil {
+			return err
+		}
+
+		forwardingSecrets, err := app.GetForwardingSecrets()
+		if err != nil {
+			return err
+		}
+
+		giftCodes, err := app.GetGiftCodes()
+		if err != nil {
+			return err
+		}
+		// GetGiftCodes sorts by name, so each batch is contiguous
+		giftCodeBatches := make([]giftCodeBatch, 0)
+		for _, giftCode := range giftCodes {
+			if len(giftCodeBatches) == 0 || giftCodeBatches[len(giftCodeBatches)-1].Name != giftCode.Name {
+				giftCodeBatches = append(giftCodeBatches, giftCodeBatch{Name: giftCode.Name})
+			}
+			batch := &giftCodeBatches[len(giftCodeBatches)-1]
+			batch.GiftCodes = append(batch.GiftCodes, giftCode)
+		}
+
+		playerSearch := c.QueryParam("player")
+		var playerSearchResults []User
+		if playerSearch != "" {
+			playerSearchResults, err = SearchPlayers(app.DB, playerSearch, "", app.Config().PlayerSearch.MaxResults)
+			if err != nil {
+				return err
+			}
+		}
+
 		return c.Render(http.StatusOK, "admin", adminContext{
-			App:            app,
-			User:           user,
-			URL:            c.Request().URL.RequestURI(),
-			SuccessMessage: lastSuccessMessage(&c),
-			WarningMessage: lastWarningMessage(&c),
-			ErrorMessage:   lastErrorMessage(&c),
-			Users:          users,
-			Invites:        invites,
+			App:                 app,
+			User:                user,
+			URL:                 c.Request().URL.RequestURI(),
+			SuccessMessage:      lastSuccessMessage(app, &c),
+			WarningMessage:      lastWarningMessage(app, &c),
+			ErrorMessage:        errorMessage,
+			Users:               userList.Entries,
+			UserList:            userList,
+			PreviousUsersURL:    previousUsersURL,
+			NextUsersURL:        nextUsersURL,
+			Invites:             invites,
+			AuditLog:            auditLog,
+			Groups:              groups,
+			PendingUsers:        pendingUsers,
+			PendingAdminActions: pendingAdminActions,
+			PlayerSearch:        playerSearch,
+			PlayerSearchResults: playerSearchResults,
+			GiftCodeBatches:     giftCodeBatches,
+			ForwardingSecrets:   forwardingSecrets,
 		})
 	})
 }
 
-// POST /drasl/admin/delete-invite
-func FrontDeleteInvite(app *App) func(c echo.Context) error {
-	returnURL := Unwrap(url.JoinPath(app.FrontEndURL, "drasl/admin"))
-
+// POST /drasl/admin/approve-user
+func FrontApproveUser(app *App) func(c echo.Context) error {
 	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
-		inviteCode := c.FormValue("inviteCode")
+		returnURL := getReturnURL(app, &c)
 
-		var invite Invite
-		result := app.DB.Where("code = ?", inviteCode).Delete(&invite)
-		if result.Error != nil {
-			return result.Error
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ? AND is_pending_approval", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		targetUser.IsPendingApproval = false
+		if err := app.DB.Save(&targetUser).Error; err != nil {
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionApproveUser, &targetUser, ""); err != nil {
+			return err
 		}
 
+		setSuccessMessage(app, &c, fmt.Sprintf("Approved %s.", targetUser.Username))
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
 
-// POST /drasl/admin/update-users
-func FrontUpdateUsers(app *App) func(c echo.Context) error {
+// POST /drasl/admin/reject-user
+func FrontRejectUser(app *App) func(c echo.Context) error {
 	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
-		var users []User
-		result := app.DB.Find(&users)
-		if result.Error != nil {
-			return result.Error
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ? AND is_pending_approval", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
 		}
 
-		tx := app.DB.Begin()
-		defer tx.Rollback()
+		// Log before deleting so the entry can still name the user
+		if err := app.LogAudit(user, AuditActionRejectUser, &targetUser, ""); err != nil {
+			return err
+		}
+		if err := DeleteUser(app, &targetUser); err != nil {
+			return err
+		}
 
-		anyUnlockedAdmins := false
-		for _, user := range users {
-			shouldBeAdmin := c.FormValue("admin-"+user.Username) == "on"
-			if IsDefaultAdmin(app, &user) {
-				shouldBeAdmin = true
-			}
+		setSuccessMessage(app, &c, fmt.Sprintf("Rejected %s. Their account has been deleted.", targetUser.Username))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
 
-			shouldBeLocked := c.FormValue("locked-"+user.Username) == "on"
-			if shouldBeAdmin && !shouldBeLocked {
-				anyUnlockedAdmins = true
-			}
-			if user.IsAdmin != shouldBeAdmin || user.IsLocked != shouldBeLocked {
-				user.IsAdmin = shouldBeAdmin
-				err := app.SetIsLocked(tx, &user, shouldBeLocked)
-				if err != nil {
-					return err
-				}
-				tx.Save(user)
+// POST /drasl/admin/suspend-user
+func FrontSuspendUser(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
 			}
+			return err
 		}
 
-		if !anyUnlockedAdmins {
-			setErrorMessage(&c, "There must be at least one unlocked admin account.")
+		count, err := strconv.Atoi(c.FormValue("duration"))
+		if err != nil || count < 1 {
+			setErrorMessage(app, &c, "Invalid duration.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		var duration time.Duration
+		switch c.FormValue("unit") {
+		case "hours":
+			duration = time.Duration(count) * time.Hour
+		case "days":
+			duration = time.Duration(count) * 24 * time.Hour
+		default:
+			setErrorMessage(app, &c, "Invalid duration.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if duration > MAX_SUSPENSION_DURATION {
+			setErrorMessage(app, &c, "Suspensions can't be longer than a year. Lock the account instead.")
 			return c.Redirect(http.StatusSeeOther, returnURL)
 		}
 
-		tx.Commit()
+		until := time.Now().Add(duration)
+		err = app.SuspendUser(user, &targetUser, until, strings.TrimSpace(c.FormValue("reason")))
+		if errors.Is(err, errSuspendAdmin) {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		} else if err != nil {
+			return err
+		}
 
-		setSuccessMessage(&c, "Changes saved.")
+		setSuccessMessage(app, &c, fmt.Sprintf("Suspended %s for %s.", targetUser.Username, FormatRemaining(duration)))
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
 
-// POST /drasl/admin/new-invite
-func FrontNewInvite(app *App) func(c echo.Context) error {
+// POST /drasl/admin/set-texture-locks
+func FrontSetTextureLocks(app *App) func(c echo.Context) error {
 	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
-		_, err := app.CreateInvite()
-		if err != nil {
-			setErrorMessage(&c, "Error creating new invite.")
-			return c.Redirect(http.StatusSeeOther, returnURL)
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		skinLocked := c.FormValue("skinLocked") == "on"
+		capeLocked := c.FormValue("capeLocked") == "on"
+		if err := app.SetTextureLocks(user, &targetUser, skinLocked, capeLocked); err != nil {
+			return err
 		}
 
+		setSuccessMessage(app, &c, fmt.Sprintf("Updated skin and cape locks of %s.", targetUser.Username))
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
 
-// GET /profile
-func FrontProfile(app *App) func(c echo.Context) error {
-	type profileContext struct {
-		App            *App
-		User           *User
-		URL            string
-		SuccessMessage string
-		WarningMessage string
-		ErrorMessage   string
-		ProfileUser    *User
-		ProfileUserID  string
-		SkinURL        *string
-		CapeURL        *string
-		AdminView      bool
-	}
+// POST /drasl/admin/add-user-note
+func FrontAddUserNote(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		body := strings.TrimSpace(c.FormValue("body"))
+		if err := ValidateUserNote(body); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid note: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if _, err := app.AddUserNote(user, &targetUser, body); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Note added.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/delete-user-note
+func FrontDeleteUserNote(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var targetUser User
+		if err := app.DB.First(&targetUser, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		id, err := strconv.ParseUint(c.FormValue("noteId"), 10, 0)
+		if err != nil {
+			setErrorMessage(app, &c, "Note not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		err = app.DeleteUserNote(&targetUser, uint(id))
+		if errors.Is(err, errUserNoteNotFound) {
+			setErrorMessage(app, &c, "Note not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		} else if err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Note deleted.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// Record a destructive action for a second admin to approve, instead of
+// doing it
+func requestAdminAction(app *App, c *echo.Context, user *User, action string, target string, targetUUID string, returnURL string) error {
+	if _, err := app.RequestAdminAction(user, action, target, targetUUID); err != nil {
+		return err
+	}
+	setSuccessMessage(app, c, "Another admin has to approve this on the Admin page before it happens.")
+	return (*c).Redirect(http.StatusSeeOther, returnURL)
+}
+
+// POST /drasl/admin/approve-action
+func FrontApproveAdminAction(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		id, err := strconv.ParseUint(c.FormValue("id"), 10, 0)
+		if err != nil {
+			setErrorMessage(app, &c, errPendingAdminActionNotFound.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		message, err := app.ApproveAdminAction(user, uint(id))
+		if errors.Is(err, errPendingAdminActionNotFound) || errors.Is(err, errApproveOwnAdminAction) || errors.Is(err, errAdminActionTargetNotFound) {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		} else if err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, message)
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/cancel-action
+func FrontCancelAdminAction(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		id, err := strconv.ParseUint(c.FormValue("id"), 10, 0)
+		if err != nil {
+			setErrorMessage(app, &c, errPendingAdminActionNotFound.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		err = app.CancelAdminAction(user, uint(id))
+		if errors.Is(err, errPendingAdminActionNotFound) {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		} else if err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Request cancelled.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/delete-invite
+func FrontDeleteInvite(app *App) func(c echo.Context) error {
+	returnURL := Unwrap(url.JoinPath(app.FrontEndURL, "drasl/admin"))
+
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		inviteCode := c.FormValue("inviteCode")
+
+		var invite Invite
+		result := app.DB.Where("code = ?", inviteCode).Delete(&invite)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/update-users
+func FrontUpdateUsers(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var users []User
+		result := app.DB.Find(&users)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		tx := app.DB.Begin()
+		defer tx.Rollback()
+
+		// The form lists the users on one page of the user list. Users
+		// not on it are left alone.
+		formParams, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+		listedUsernames, onlyListed := formParams["user"]
+
+		anyUnlockedAdmins := false
+		for _, user := range users {
+			if onlyListed && !Contains(listedUsernames, user.Username) {
+				if user.IsAdmin && !user.IsLocked {
+					anyUnlockedAdmins = true
+				}
+				continue
+			}
+
+			shouldBeAdmin := c.FormValue("admin-"+user.Username) == "on"
+			if IsDefaultAdmin(app, &user) {
+				shouldBeAdmin = true
+			}
+
+			shouldBeLocked := c.FormValue("locked-"+user.Username) == "on"
+			if shouldBeAdmin && !shouldBeLocked {
+				anyUnlockedAdmins = true
+			}
+			if user.IsAdmin != shouldBeAdmin || user.IsLocked != shouldBeLocked {
+				user.IsAdmin = shouldBeAdmin
+				// Leave a suspension alone unless the lock changes
+				if user.IsLocked != shouldBeLocked {
+					err := app.SetIsLocked(tx, &user, shouldBeLocked)
+					if err != nil {
+						return err
+					}
+				}
+				tx.Save(&user)
+			}
+		}
+
+		if !anyUnlockedAdmins {
+			setErrorMessage(app, &c, "There must be at least one unlocked admin account.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		tx.Commit()
+
+		setSuccessMessage(app, &c, "Changes saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/update-announcement
+func FrontUpdateAnnouncement(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		markdown := c.FormValue("announcement")
+		err := app.SetAnnouncement(markdown)
+		if err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Announcement saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+type adminSettingsContext struct {
+	App            *App
+	User           *User
+	URL            string
+	SuccessMessage string
+	WarningMessage string
+	ErrorMessage   string
+	Announcement   *Announcement
+	// Options whose values differ from the config file
+	Overridden map[string]bool
+}
+
+// GET /drasl/admin/settings
+func FrontAdminSettings(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		announcement, err := app.GetAnnouncement()
+		if err != nil {
+			return err
+		}
+		overrides, err := GetConfigOverrides(app.DB)
+		if err != nil {
+			return err
+		}
+		overridden := make(map[string]bool, len(overrides))
+		for _, override := range overrides {
+			overridden[override.Key] = true
+		}
+
+		return c.Render(http.StatusOK, "admin-settings", adminSettingsContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Announcement:   announcement,
+			Overridden:     overridden,
+		})
+	})
+}
+
+// POST /drasl/admin/update-settings
+func FrontUpdateSettings(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		settings := *app.Config()
+		settings.RegistrationNewPlayer.Allow = c.FormValue("registrationNewPlayerAllow") == "on"
+		settings.RegistrationNewPlayer.AllowChoosingUUID = c.FormValue("registrationNewPlayerAllowChoosingUUID") == "on"
+		settings.RegistrationNewPlayer.RequireInvite = c.FormValue("registrationNewPlayerRequireInvite") == "on"
+		settings.RegistrationNewPlayer.RequireApproval = c.FormValue("registrationNewPlayerRequireApproval") == "on"
+		settings.RegistrationExistingPlayer.Allow = c.FormValue("registrationExistingPlayerAllow") == "on"
+		settings.RegistrationExistingPlayer.RequireInvite = c.FormValue("registrationExistingPlayerRequireInvite") == "on"
+		settings.RegistrationExistingPlayer.RequireApproval = c.FormValue("registrationExistingPlayerRequireApproval") == "on"
+		settings.RateLimit.Enable = c.FormValue("rateLimitEnable") == "on"
+		settings.AllowSkins = c.FormValue("allowSkins") == "on"
+		settings.AllowCapes = c.FormValue("allowCapes") == "on"
+
+		requestsPerSecond, err := strconv.ParseFloat(c.FormValue("rateLimitRequestsPerSecond"), 64)
+		if err != nil {
+			setErrorMessage(app, &c, "Requests per second must be a number.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		settings.RateLimit.RequestsPerSecond = requestsPerSecond
+		skinSizeLimit, err := strconv.Atoi(c.FormValue("skinSizeLimit"))
+		if err != nil {
+			setErrorMessage(app, &c, "Skin size limit must be a whole number.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		settings.SkinSizeLimit = skinSizeLimit
+
+		overrides, config, err := app.MakeConfigOverrides(&settings)
+		if err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid settings: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		changed, err := app.SetConfigOverrides(overrides, config)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			if err := app.LogAudit(user, AuditActionUpdateSettings, nil, strings.Join(changed, ", ")); err != nil {
+				return err
+			}
+		}
+
+		setSuccessMessage(app, &c, "Settings saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+type adminFallbackAPIServersContext struct {
+	App                *App
+	User               *User
+	URL                string
+	SuccessMessage     string
+	WarningMessage     string
+	ErrorMessage       string
+	FallbackAPIServers []FallbackAPIServer
+	// The new server form, as last submitted
+	New            FallbackAPIServer
+	NewSkinDomains string
+}
+
+func renderAdminFallbackAPIServers(app *App, c echo.Context, user *User, ctx adminFallbackAPIServersContext) error {
+	ctx.App = app
+	ctx.User = user
+	ctx.URL = c.Request().URL.RequestURI()
+	ctx.FallbackAPIServers = app.Config().FallbackAPIServers
+	return c.Render(http.StatusOK, "admin-fallback-api-servers", ctx)
+}
+
+// GET /drasl/admin/fallback-api-servers
+func FrontAdminFallbackAPIServers(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		return renderAdminFallbackAPIServers(app, c, user, adminFallbackAPIServersContext{
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+		})
+	})
+}
+
+// The FallbackAPIServer described by the new server form
+func parseFallbackAPIServerForm(c echo.Context) (FallbackAPIServer, error) {
+	fallbackAPIServer := FallbackAPIServer{
+		Nickname:         strings.TrimSpace(c.FormValue("nickname")),
+		SessionURL:       strings.TrimSpace(c.FormValue("sessionUrl")),
+		AccountURL:       strings.TrimSpace(c.FormValue("accountUrl")),
+		ServicesURL:      strings.TrimSpace(c.FormValue("servicesUrl")),
+		SkinDomains:      strings.FieldsFunc(c.FormValue("skinDomains"), func(r rune) bool { return r == ',' || unicode.IsSpace(r) }),
+		DenyUnknownUsers: c.FormValue("denyUnknownUsers") == "on",
+		ProxyTextures:    c.FormValue("proxyTextures") == "on",
+	}
+	if cacheTTLSeconds := c.FormValue("cacheTtlSeconds"); cacheTTLSeconds != "" {
+		var err error
+		fallbackAPIServer.CacheTTLSeconds, err = strconv.Atoi(cacheTTLSeconds)
+		if err != nil || fallbackAPIServer.CacheTTLSeconds < 0 {
+			return fallbackAPIServer, errors.New("Cache time must be a whole number of seconds.")
+		}
+	}
+	return fallbackAPIServer, nil
+}
+
+// POST /drasl/admin/fallback-api-servers
+func FrontUpdateFallbackAPIServers(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+		action := c.FormValue("action")
+
+		if action == "add" || action == "test-new" {
+			fallbackAPIServer, err := parseFallbackAPIServerForm(c)
+			ctx := adminFallbackAPIServersContext{
+				New:            fallbackAPIServer,
+				NewSkinDomains: c.FormValue("skinDomains"),
+			}
+			if err != nil {
+				ctx.ErrorMessage = err.Error()
+				return renderAdminFallbackAPIServers(app, c, user, ctx)
+			}
+			if action == "test-new" {
+				if err := app.ProbeFallbackAPIServer(&fallbackAPIServer); err != nil {
+					ctx.ErrorMessage = fmt.Sprintf("Couldn't reach %s: %s", fallbackAPIServer.Nickname, err)
+				} else {
+					ctx.SuccessMessage = fmt.Sprintf("%s is reachable.", fallbackAPIServer.Nickname)
+				}
+				return renderAdminFallbackAPIServers(app, c, user, ctx)
+			}
+			if err := app.AddFallbackAPIServer(fallbackAPIServer); err != nil {
+				var invalidError *InvalidFallbackAPIServersError
+				if errors.As(err, &invalidError) {
+					ctx.ErrorMessage = fmt.Sprintf("Invalid fallback API server: %s", err)
+					return renderAdminFallbackAPIServers(app, c, user, ctx)
+				}
+				return err
+			}
+			if err := app.LogAudit(user, AuditActionUpdateFallbackAPIServers, nil, "add "+fallbackAPIServer.Nickname); err != nil {
+				return err
+			}
+			setSuccessMessage(app, &c, fmt.Sprintf("Added %s.", fallbackAPIServer.Nickname))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		nickname := c.FormValue("nickname")
+		var err error
+		var successMessage string
+		switch action {
+		case "test":
+			var fallbackAPIServer *FallbackAPIServer
+			fallbackAPIServer, err = app.GetFallbackAPIServer(nickname)
+			if err == nil {
+				if probeErr := app.ProbeFallbackAPIServer(fallbackAPIServer); probeErr != nil {
+					setErrorMessage(app, &c, fmt.Sprintf("Couldn't reach %s: %s", nickname, probeErr))
+					return c.Redirect(http.StatusSeeOther, returnURL)
+				}
+				setSuccessMessage(app, &c, fmt.Sprintf("%s is reachable.", nickname))
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+		case "up":
+			err = app.MoveFallbackAPIServer(nickname, -1)
+			successMessage = fmt.Sprintf("Moved %s up.", nickname)
+		case "down":
+			err = app.MoveFallbackAPIServer(nickname, 1)
+			successMessage = fmt.Sprintf("Moved %s down.", nickname)
+		case "enable":
+			err = app.SetFallbackAPIServerDisabled(nickname, false)
+			successMessage = fmt.Sprintf("Enabled %s.", nickname)
+		case "disable":
+			err = app.SetFallbackAPIServerDisabled(nickname, true)
+			successMessage = fmt.Sprintf("Disabled %s.", nickname)
+		case "remove":
+			err = app.RemoveFallbackAPIServer(nickname)
+			successMessage = fmt.Sprintf("Removed %s.", nickname)
+		default:
+			setErrorMessage(app, &c, "Unknown action.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err != nil {
+			var invalidError *InvalidFallbackAPIServersError
+			if errors.Is(err, errFallbackAPIServerNotFound) {
+				setErrorMessage(app, &c, "Fallback API server not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			} else if errors.As(err, &invalidError) {
+				setErrorMessage(app, &c, fmt.Sprintf("Invalid fallback API servers: %s", err))
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionUpdateFallbackAPIServers, nil, action+" "+nickname); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, successMessage)
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/new-invite
+func FrontNewInvite(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		_, err := app.CreateInvite()
+		if err != nil {
+			setErrorMessage(app, &c, "Error creating new invite.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// Look up the group named by the `group` form value or query parameter
+func getGroup(app *App, c *echo.Context) (*Group, error) {
+	name := (*c).FormValue("group")
+	if name == "" {
+		name = (*c).QueryParam("group")
+	}
+	var group Group
+	if err := app.DB.First(&group, "name = ?", name).Error; err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func groupURL(app *App, group *Group) string {
+	return app.FrontEndURL + "/drasl/admin/group?group=" + url.QueryEscape(group.Name)
+}
+
+// withBrowserAdmin, plus the group being acted on
+func withGroup(app *App, f func(c echo.Context, user *User, group *Group) error) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		group, err := getGroup(app, &c)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "Group not found.")
+				return c.Redirect(http.StatusSeeOther, app.FrontEndURL+"/drasl/admin")
+			}
+			return err
+		}
+		return f(c, user, group)
+	})
+}
+
+type adminEmailContext struct {
+	App            *App
+	User           *User
+	URL            string
+	SuccessMessage string
+	WarningMessage string
+	ErrorMessage   string
+	Groups         []Group
+	Subject        string
+	Body           string
+	GroupName      string
+	RecipientCount int
+	Preview        []EmailMessage
+}
+
+// GET /drasl/admin/email
+func FrontAdminEmail(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		var groups []Group
+		if err := app.DB.Order("name").Find(&groups).Error; err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "admin-email", adminEmailContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Groups:         groups,
+			RecipientCount: -1,
+		})
+	})
+}
+
+// How many rendered messages to show when previewing an email announcement
+const EMAIL_PREVIEW_COUNT = 3
+
+// POST /drasl/admin/email
+func FrontSendAdminEmail(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		var groups []Group
+		if err := app.DB.Order("name").Find(&groups).Error; err != nil {
+			return err
+		}
+
+		ctx := adminEmailContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			Groups:         groups,
+			Subject:        c.FormValue("subject"),
+			Body:           c.FormValue("body"),
+			GroupName:      c.FormValue("group"),
+			RecipientCount: -1,
+		}
+		// Errors and previews re-render the form so nothing typed is lost
+		renderError := func(message string) error {
+			ctx.ErrorMessage = message
+			return c.Render(http.StatusOK, "admin-email", ctx)
+		}
+
+		if app.Mailer == nil {
+			return renderError("Email is not enabled on this server.")
+		}
+		if strings.TrimSpace(ctx.Subject) == "" || strings.TrimSpace(ctx.Body) == "" {
+			return renderError("Subject and body can't be blank.")
+		}
+
+		announcement, err := ParseAnnouncementEmail(ctx.Subject, ctx.Body)
+		if err != nil {
+			return renderError(fmt.Sprintf("Invalid template: %s", err))
+		}
+
+		var group *Group
+		if ctx.GroupName != "" {
+			group, err = getGroup(app, &c)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return renderError("Group not found.")
+				}
+				return err
+			}
+		}
+
+		recipients, err := app.GetAnnouncementRecipients(group)
+		if err != nil {
+			return err
+		}
+		ctx.RecipientCount = len(recipients)
+
+		if c.FormValue("action") != "send" {
+			for _, recipient := range PtrSlice(recipients) {
+				if len(ctx.Preview) >= EMAIL_PREVIEW_COUNT {
+					break
+				}
+				message, err := announcement.Render(app, recipient)
+				if err != nil {
+					return renderError(fmt.Sprintf("Invalid template: %s", err))
+				}
+				ctx.Preview = append(ctx.Preview, *message)
+			}
+			return c.Render(http.StatusOK, "admin-email", ctx)
+		}
+
+		if len(recipients) == 0 {
+			return renderError("No users would receive this email.")
+		}
+		// Make sure every message renders before sending any of them
+		for _, recipient := range PtrSlice(recipients) {
+			if _, err := announcement.Render(app, recipient); err != nil {
+				return renderError(fmt.Sprintf("Invalid template: %s", err))
+			}
+		}
+
+		details := fmt.Sprintf("%q to %d users", ctx.Subject, len(recipients))
+		if group != nil {
+			details += " in group " + group.Name
+		}
+		if err := app.LogAudit(user, AuditActionSendEmail, nil, details); err != nil {
+			return err
+		}
+		go app.SendAnnouncementEmail(announcement, recipients)
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Sending the email to %d users.", len(recipients)))
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL+"/drasl/admin")
+	})
+}
+
+// GET /drasl/admin/stats
+func FrontStats(app *App) func(c echo.Context) error {
+	type statsContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		Stats          *Stats
+	}
+
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		stats, err := app.GetStats()
+		if err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "stats", statsContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Stats:          stats,
+		})
+	})
+}
+
+// GET /drasl/admin/api-usage
+func FrontAdminAPIUsage(app *App) func(c echo.Context) error {
+	type adminAPIUsageContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		Days           int
+		TopAPITokens   []APITokenUsageSummary
+	}
+
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		if !app.Config().APITokens.Allow {
+			return echo.ErrNotFound
+		}
+
+		topAPITokens, err := app.GetTopAPITokens(time.Now())
+		if err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "admin-api-usage", adminAPIUsageContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Days:           API_TOKEN_USAGE_DAYS,
+			TopAPITokens:   topAPITokens,
+		})
+	})
+}
+
+// POST /drasl/admin/set-api-token-limits
+func FrontSetAPITokenLimits(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var apiToken APIToken
+		if err := app.DB.Preload("User").First(&apiToken, "id = ?", c.FormValue("apiTokenId")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "API token not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		// A blank field goes back to the default
+		parseLimit := func(name string) (*int, error) {
+			value := strings.TrimSpace(c.FormValue(name))
+			if value == "" {
+				return nil, nil
+			}
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return nil, errors.New("Limits must be whole numbers, or 0 for no limit.")
+			}
+			return &limit, nil
+		}
+		requestsPerMinute, err := parseLimit("requestsPerMinute")
+		if err != nil {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		dailyQuota, err := parseLimit("dailyQuota")
+		if err != nil {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if err := app.SetAPITokenLimits(user, &apiToken, requestsPerMinute, dailyQuota); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Updated the limits of %s's token %s.", apiToken.User.Username, apiToken.Name))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// GET /drasl/admin/reports
+func FrontAdminReports(app *App) func(c echo.Context) error {
+	type adminReportsContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		OpenReports    []ReportWithUsers
+		ClosedReports  []ReportWithUsers
+		// Search for similar skins; see skin_similarity.go
+		SimilarTo         string
+		MaxDistance       int
+		MaxMaxDistance    int
+		SimilarSkins      []SimilarSkin
+		SimilarSkinsError string
+	}
+
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		openReports, closedReports, err := app.GetReports()
+		if err != nil {
+			return err
+		}
+
+		similarTo := c.QueryParam("similarTo")
+		maxDistance := DEFAULT_SKIN_SIMILARITY_DISTANCE
+		var similarSkins []SimilarSkin
+		var similarSkinsError string
+		if similarTo != "" {
+			if maxDistanceParam := c.QueryParam("maxDistance"); maxDistanceParam != "" {
+				maxDistance, err = strconv.Atoi(maxDistanceParam)
+				if err != nil {
+					maxDistance = -1
+				}
+			}
+			skinHash, err := app.ResolveSkinQuery(similarTo)
+			if err == nil {
+				similarSkins, err = app.FindSimilarSkins(skinHash, maxDistance)
+			}
+			var searchError *SkinSearchError
+			if errors.As(err, &searchError) {
+				similarSkinsError = searchError.Message
+			} else if err != nil {
+				return err
+			}
+		}
+
+		return c.Render(http.StatusOK, "admin-reports", adminReportsContext{
+			App:               app,
+			User:              user,
+			URL:               c.Request().URL.RequestURI(),
+			SuccessMessage:    lastSuccessMessage(app, &c),
+			WarningMessage:    lastWarningMessage(app, &c),
+			ErrorMessage:      lastErrorMessage(app, &c),
+			OpenReports:       openReports,
+			ClosedReports:     closedReports,
+			SimilarTo:         similarTo,
+			MaxDistance:       maxDistance,
+			MaxMaxDistance:    MAX_SKIN_SIMILARITY_DISTANCE,
+			SimilarSkins:      similarSkins,
+			SimilarSkinsError: similarSkinsError,
+		})
+	})
+}
+
+// POST /drasl/admin/resolve-report
+func FrontResolveReport(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		id, err := strconv.ParseUint(c.FormValue("reportId"), 10, 0)
+		if err != nil {
+			setErrorMessage(app, &c, "Report not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		report, err := app.GetReport(uint(id))
+		if errors.Is(err, errReportNotFound) {
+			setErrorMessage(app, &c, "Report not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		} else if err != nil {
+			return err
+		}
+
+		action := c.FormValue("action")
+		err = app.ResolveReport(user, report, action)
+		switch {
+		case errors.Is(err, errReportClosed):
+			setErrorMessage(app, &c, "That report has already been closed.")
+		case errors.Is(err, errReportTargetNotFound):
+			setErrorMessage(app, &c, "The reported player no longer exists.")
+		case err != nil:
+			setErrorMessage(app, &c, err.Error())
+		case action == ReportActionDismiss:
+			setSuccessMessage(app, &c, fmt.Sprintf("Dismissed the report about %s.", report.TargetPlayerName))
+		default:
+			setSuccessMessage(app, &c, fmt.Sprintf("Resolved the report about %s.", report.TargetPlayerName))
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// GET /drasl/admin/group
+func FrontGroup(app *App) func(c echo.Context) error {
+	type groupContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		Group          *Group
+		Members        []User
+	}
+
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		members, err := app.GetGroupMembers(group)
+		if err != nil {
+			return err
+		}
+
+		return c.Render(http.StatusOK, "group", groupContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			Group:          group,
+			Members:        members,
+		})
+	})
+}
+
+// GET /drasl/admin/group/export
+func FrontExportGroup(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		members, err := app.GetGroupMembers(group)
+		if err != nil {
+			return err
+		}
+
+		var builder strings.Builder
+		for _, member := range members {
+			builder.WriteString(member.UUID)
+			builder.WriteString("\n")
+		}
+
+		c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.txt\"", url.PathEscape(group.Name)))
+		return c.String(http.StatusOK, builder.String())
+	})
+}
+
+// POST /drasl/admin/new-group
+func FrontNewGroup(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		name := strings.TrimSpace(c.FormValue("group"))
+		if err := ValidateGroupName(name); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid group name: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		group := Group{
+			Name:      name,
+			CreatedAt: time.Now(),
+		}
+		if err := app.DB.Create(&group).Error; err != nil {
+			if IsErrorUniqueFailed(err) {
+				setErrorMessage(app, &c, "That group already exists.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, groupURL(app, &group))
+	})
+}
+
+// POST /drasl/admin/delete-group
+func FrontDeleteGroup(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		if app.Config().TwoPersonApproval.Enable {
+			return requestAdminAction(app, &c, user, AdminActionDeleteGroup, group.Name, "", app.FrontEndURL+"/drasl/admin")
+		}
+		if err := app.DeleteGroup(group); err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, fmt.Sprintf("Group %s deleted.", group.Name))
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL+"/drasl/admin")
+	})
+}
+
+// POST /drasl/admin/group/add-member
+func FrontAddGroupMember(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		returnURL := groupURL(app, group)
+
+		var member User
+		if err := app.DB.First(&member, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		membership := GroupMembership{
+			GroupID:  group.ID,
+			UserUUID: member.UUID,
+		}
+		if err := app.DB.Create(&membership).Error; err != nil {
+			if IsErrorUniqueFailed(err) {
+				setErrorMessage(app, &c, fmt.Sprintf("%s is already in this group.", member.Username))
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+		if err := TouchUsers(app.DB, member.UUID); err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/group/remove-member
+func FrontRemoveGroupMember(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		returnURL := groupURL(app, group)
+
+		var member User
+		if err := app.DB.First(&member, "username = ?", c.FormValue("username")).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		cosmetics, err := app.getGroupCosmetics(group)
+		if err != nil {
+			return err
+		}
+		err = app.DB.Where("group_id = ? AND user_uuid = ?", group.ID, member.UUID).Delete(&GroupMembership{}).Error
+		if err != nil {
+			return err
+		}
+		if err := TouchUsers(app.DB, member.UUID); err != nil {
+			return err
+		}
+		if err := app.takeOffGroupCosmetics(cosmetics, []User{member}); err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/group/set-locked
+func FrontSetGroupLocked(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		returnURL := groupURL(app, group)
+
+		isLocked := c.FormValue("locked") == "on"
+		count, err := app.SetGroupIsLocked(group, isLocked)
+		if err != nil {
+			return err
+		}
+
+		action := AuditActionGroupUnlock
+		message := fmt.Sprintf("Unlocked %d users.", count)
+		if isLocked {
+			action = AuditActionGroupLock
+			message = fmt.Sprintf("Locked %d users. Admins are never locked from here.", count)
+		}
+		if err := app.LogAudit(user, action, nil, group.Name); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, message)
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/group/set-cape
+func FrontSetGroupCape(app *App) func(c echo.Context) error {
+	return withGroup(app, func(c echo.Context, user *User, group *Group) error {
+		returnURL := groupURL(app, group)
+
+		deleteCape := c.FormValue("deleteCape") == "on"
+		capeFile, capeFileErr := c.FormFile("capeFile")
+
+		var capeReader io.Reader
+		action := AuditActionGroupDeleteCape
+		if !deleteCape {
+			if capeFileErr != nil {
+				setErrorMessage(app, &c, "Choose a cape to give to the group.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			capeHandle, err := capeFile.Open()
+			if err != nil {
+				return err
+			}
+			defer capeHandle.Close()
+			capeReader = capeHandle
+			action = AuditActionGroupSetCape
+		}
+
+		if err := app.SetGroupCape(group, capeReader); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Error using that cape: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err := app.LogAudit(user, action, nil, group.Name); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Changes saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/new-gift-codes
+func FrontNewGiftCodes(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		name := strings.TrimSpace(c.FormValue("name"))
+		if name == "" || len(name) > MAX_GROUP_NAME_LENGTH {
+			setErrorMessage(app, &c, fmt.Sprintf("Name the codes with at most %d characters.", MAX_GROUP_NAME_LENGTH))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		count, err := strconv.Atoi(c.FormValue("count"))
+		if err != nil || count <= 0 || count > MAX_GIFT_CODE_BATCH_SIZE {
+			setErrorMessage(app, &c, fmt.Sprintf("Choose between 1 and %d codes.", MAX_GIFT_CODE_BATCH_SIZE))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		maxUses := 0
+		if maxUsesString := c.FormValue("maxUses"); maxUsesString != "" {
+			maxUses, err = strconv.Atoi(maxUsesString)
+			if err != nil || maxUses < 0 {
+				setErrorMessage(app, &c, "Invalid number of uses.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+		}
+		var expiresAt *time.Time
+		if expireDaysString := c.FormValue("expireDays"); expireDaysString != "" {
+			expireDays, err := strconv.Atoi(expireDaysString)
+			if err != nil || expireDays <= 0 {
+				setErrorMessage(app, &c, "Invalid number of days.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			expiresAt = Ptr(time.Now().AddDate(0, 0, expireDays))
+		}
+
+		capeFile, err := c.FormFile("capeFile")
+		if err != nil {
+			setErrorMessage(app, &c, "Choose a cape to give away.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		capeHandle, err := capeFile.Open()
+		if err != nil {
+			return err
+		}
+		defer capeHandle.Close()
+
+		giftCodes, err := app.CreateGiftCodes(name, capeHandle, count, maxUses, expiresAt)
+		if err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Error creating gift codes: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err := app.LogAudit(user, AuditActionCreateGiftCodes, nil, fmt.Sprintf("%s (%d)", name, len(giftCodes))); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Created %d gift codes.", len(giftCodes)))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// GET /drasl/admin/gift-codes/export
+// Download a batch of codes, one per line, e.g. to print or hand out
+func FrontExportGiftCodes(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		name := c.QueryParam("name")
+		var codes []string
+		if err := app.DB.Model(&GiftCode{}).Where("name = ?", name).Order("code").Pluck("code", &codes).Error; err != nil {
+			return err
+		}
+
+		var builder strings.Builder
+		for _, code := range codes {
+			builder.WriteString(code)
+			builder.WriteString("\n")
+		}
+
+		c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.txt\"", url.PathEscape(name)))
+		return c.String(http.StatusOK, builder.String())
+	})
+}
+
+// POST /drasl/admin/delete-gift-codes
+func FrontDeleteGiftCodes(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		name := c.FormValue("name")
+		if app.Config().TwoPersonApproval.Enable {
+			return requestAdminAction(app, &c, user, AdminActionDeleteGiftCodes, name, "", returnURL)
+		}
+		count, err := app.DeleteGiftCodes(name)
+		if err != nil {
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionDeleteGiftCodes, nil, fmt.Sprintf("%s (%d)", name, count)); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Deleted %d gift codes.", count))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/new-forwarding-secret
+func FrontNewForwardingSecret(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		backend := strings.TrimSpace(c.FormValue("backend"))
+		if err := ValidateBackendName(backend); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid backend name: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if _, err := app.CreateForwardingSecret(backend); err != nil {
+			if errors.Is(err, errForwardingSecretExists) {
+				setErrorMessage(app, &c, "That backend already has a forwarding secret.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionCreateForwardingSecret, nil, backend); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Created a forwarding secret for %s.", backend))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/rotate-forwarding-secret
+func FrontRotateForwardingSecret(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		backend := c.FormValue("backend")
+		if app.Config().TwoPersonApproval.Enable {
+			return requestAdminAction(app, &c, user, AdminActionRotateForwardingSecret, backend, "", returnURL)
+		}
+		if _, err := app.RotateForwardingSecret(backend); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "Forwarding secret not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionRotateForwardingSecret, nil, backend); err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, fmt.Sprintf("Rotated the forwarding secret for %s.", backend))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/admin/delete-forwarding-secret
+func FrontDeleteForwardingSecret(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		backend := c.FormValue("backend")
+		if app.Config().TwoPersonApproval.Enable {
+			return requestAdminAction(app, &c, user, AdminActionDeleteForwardingSecret, backend, "", returnURL)
+		}
+		if err := app.DeleteForwardingSecret(backend); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "Forwarding secret not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+		if err := app.LogAudit(user, AuditActionDeleteForwardingSecret, nil, backend); err != nil {
+			return err
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/bedrock-link-code
+// Make a code the user can enter on a Bedrock server to link their Bedrock
+// account
+func FrontBedrockLinkCode(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if !app.Config().Floodgate.Enable {
+			setErrorMessage(app, &c, "Bedrock accounts are not supported on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		linkCode, err := app.CreateBedrockLinkCode(user)
+		if err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, fmt.Sprintf(
+			"Your link code is %s. Enter it on a Bedrock server within %d minutes.",
+			linkCode.Code, app.Config().Floodgate.LinkCodeExpireSec/60,
+		))
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/bedrock-unlink
+func FrontBedrockUnlink(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if err := app.UnlinkBedrock(user); err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Your Bedrock account has been unlinked.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/link-account
+// Link the account whose ownership the user proved with the challenge skin
+func FrontLinkAccount(app *App) func(c echo.Context) error {
+	profileURL := Unwrap(url.JoinPath(app.FrontEndURL, "drasl/profile"))
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if !app.Config().AccountLinking.Allow {
+			setErrorMessage(app, &c, "Linking accounts is not allowed.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		source := getExistingPlayerSource(app, c.FormValue("source"))
+		if source == nil {
+			setErrorMessage(app, &c, "Unknown account provider.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		linkedAccount, err := app.LinkAccount(user, source, c.FormValue("username"), c.FormValue("challengeToken"))
+		if err != nil {
+			switch {
+			case errors.Is(err, errLinkedAccountTaken):
+				setErrorMessage(app, &c, "That account is already linked to another Drasl account.")
+			case errors.Is(err, errChallengeSkinMismatch):
+				setErrorMessage(app, &c, "Couldn't verify your skin, maybe try again?")
+			case errors.Is(err, errChallengeExpired):
+				setErrorMessage(app, &c, "The verification skin has expired. Please start over.")
+			default:
+				setErrorMessage(app, &c, fmt.Sprintf("Couldn't verify the account: %s", err))
+			}
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		setSuccessMessage(app, &c, fmt.Sprintf("Linked the %s account %s.", linkedAccount.Source, linkedAccount.PlayerName))
+		return c.Redirect(http.StatusSeeOther, profileURL)
+	})
+}
+
+// POST /drasl/unlink-account
+func FrontUnlinkAccount(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if err := app.UnlinkAccount(user); err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Your account has been unlinked.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/import-profile
+// Copy the skin and cape of the user's linked account onto their profile
+func FrontImportProfile(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().ProfileImport.Allow {
+			setErrorMessage(app, &c, "Importing profiles is not allowed.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		importSkin := c.FormValue("importSkin") == "on"
+		importCape := c.FormValue("importCape") == "on"
+		if !importSkin && !importCape {
+			setErrorMessage(app, &c, "Choose a skin or cape to import.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		profileImport, err := app.ImportLinkedProfile(user, importSkin, importCape)
+		if err != nil {
+			switch {
+			case errors.Is(err, errProfileImportNotLinked):
+				setErrorMessage(app, &c, "Link an account before importing its profile.")
+			case errors.Is(err, errProfileImportUnavailable):
+				setErrorMessage(app, &c, "Couldn't find your linked account. Try again later.")
+			case errors.Is(err, errSkinNotAllowed), errors.Is(err, errCapeNotAllowed),
+				errors.Is(err, errSkinLocked), errors.Is(err, errCapeLocked):
+				setErrorMessage(app, &c, err.Error())
+			default:
+				setErrorMessage(app, &c, fmt.Sprintf("Couldn't import your profile: %s", err))
+			}
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if !profileImport.ImportedSkin && !profileImport.ImportedCape {
+			setWarningMessage(app, &c, fmt.Sprintf("The %s account %s has nothing to import.", profileImport.Source, profileImport.PlayerName))
+		} else {
+			setSuccessMessage(app, &c, fmt.Sprintf("Imported your profile from the %s account %s.", profileImport.Source, profileImport.PlayerName))
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/redeem-gift-code
+func FrontRedeemGiftCode(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		_, err := app.RedeemGiftCode(user, c.FormValue("code"))
+		switch {
+		case errors.Is(err, errCapeLocked):
+			setErrorMessage(app, &c, err.Error())
+		case errors.Is(err, errGiftCodeNotFound):
+			setErrorMessage(app, &c, "That code doesn't exist or has expired.")
+		case errors.Is(err, errGiftCodeUsedUp):
+			setErrorMessage(app, &c, "That code has already been used up.")
+		case errors.Is(err, errGiftCodeAlreadyRedeemed):
+			setErrorMessage(app, &c, "You have already redeemed that code.")
+		case err != nil:
+			return err
+		default:
+			setSuccessMessage(app, &c, "Code redeemed! Enjoy your new cape.")
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/report
+func FrontReport(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().Reports.Allow {
+			setErrorMessage(app, &c, "Reports are not allowed.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		reason := c.FormValue("reason")
+		if err := ValidateReportReason(reason); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid reason: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		details := c.FormValue("details")
+		if err := ValidateReportDetails(details); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid details: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		_, err := app.CreateReport(user, c.FormValue("playerName"), reason, details)
+		switch {
+		case errors.Is(err, errReportTargetNotFound):
+			setErrorMessage(app, &c, "Player not found.")
+		case errors.Is(err, errReportSelf):
+			setErrorMessage(app, &c, "You can't report yourself.")
+		case errors.Is(err, errReportLimit):
+			setErrorMessage(app, &c, "You've sent too many reports. Try again tomorrow.")
+		case err != nil:
+			return err
+		default:
+			setSuccessMessage(app, &c, "Thanks, your report has been sent to the admins.")
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/wear-cosmetic
+// Put on one of the capes the user has been granted
+func FrontWearCosmetic(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		cosmetic, err := app.GetCosmetic(c.FormValue("cosmeticId"))
+		if err == nil {
+			err = app.WearCosmetic(user, cosmetic)
+		}
+		switch {
+		case errors.Is(err, errCosmeticNotFound), errors.Is(err, errCosmeticNotEntitled):
+			setErrorMessage(app, &c, "You don't have that cape.")
+		case errors.Is(err, errCapeLocked):
+			setErrorMessage(app, &c, err.Error())
+		case err != nil:
+			return err
+		default:
+			setSuccessMessage(app, &c, "Cape changed.")
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/new-api-token
+// Mint a personal API token for the user. The token is only shown once.
+func FrontNewAPIToken(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		// A token would outlast the impersonation
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if !app.Config().APITokens.Allow {
+			setErrorMessage(app, &c, "API tokens are not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		name := c.FormValue("name")
+		if utf8.RuneCountInString(name) > MAX_API_TOKEN_NAME_LENGTH {
+			setErrorMessage(app, &c, fmt.Sprintf("Token name can't be longer than %d characters.", MAX_API_TOKEN_NAME_LENGTH))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		formParams, err := c.FormParams()
+		if err != nil {
+			return err
+		}
+
+		token, _, err := app.CreateAPIToken(user, name, formParams["scope"])
+		switch {
+		case errors.Is(err, errAPITokenNoScopes):
+			setErrorMessage(app, &c, "Choose at least one scope.")
+		case errors.Is(err, errAPITokenLimit):
+			setErrorMessage(app, &c, fmt.Sprintf("You can't have more than %d API tokens.", app.Config().APITokens.MaxPerUser))
+		case err != nil:
+			return err
+		default:
+			setSuccessMessage(app, &c, fmt.Sprintf("Your new API token is %s. Copy it now; it won't be shown again.", token))
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/delete-api-token
+func FrontDeleteAPIToken(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		err := app.DeleteAPIToken(user, c.FormValue("id"))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setErrorMessage(app, &c, "API token not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "API token deleted.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/save-library-skin
+// Save the user's current skin to their skin library
+func FrontSaveLibrarySkin(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().SkinRotation.Allow {
+			setErrorMessage(app, &c, "Skin rotation is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		name := c.FormValue("name")
+		if utf8.RuneCountInString(name) > MAX_LIBRARY_SKIN_NAME_LENGTH {
+			setErrorMessage(app, &c, fmt.Sprintf("Skin name can't be longer than %d characters.", MAX_LIBRARY_SKIN_NAME_LENGTH))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		_, err := app.SaveLibrarySkin(user, name, c.FormValue("date"))
+		switch {
+		case errors.Is(err, errNoSkin):
+			setErrorMessage(app, &c, "You don't have a skin to save.")
+		case errors.Is(err, errInvalidLibrarySkinDate):
+			setErrorMessage(app, &c, "Invalid date: must be a month and day like 12-25.")
+		case errors.Is(err, errLibraryFull):
+			setErrorMessage(app, &c, fmt.Sprintf("You can't save more than %d skins.", app.Config().SkinRotation.MaxLibrarySkins))
+		case err != nil:
+			return err
+		default:
+			setSuccessMessage(app, &c, "Skin saved to your library.")
+		}
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/delete-library-skin
+func FrontDeleteLibrarySkin(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		err := app.DeleteLibrarySkin(user, c.FormValue("id"))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setErrorMessage(app, &c, "Skin not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Skin removed from your library.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/update-skin-rotation
+func FrontUpdateSkinRotation(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().SkinRotation.Allow {
+			setErrorMessage(app, &c, "Skin rotation is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		user.RotateSkinsDaily = c.FormValue("rotateSkinsDaily") == "on"
+		if err := app.DB.Save(user).Error; err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Skin rotation updated.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/rollback-appearance
+// Restore the skin, skin model, and cape from an appearance snapshot
+func FrontRollBackAppearance(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().AppearanceHistory.Enable {
+			setErrorMessage(app, &c, "Appearance history is not enabled on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		id, err := strconv.ParseUint(c.FormValue("id"), 10, 0)
+		if err != nil {
+			setErrorMessage(app, &c, "Snapshot not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		_, err = app.RollBackAppearance(user, uint(id))
+		if errors.Is(err, errAppearanceSnapshotNotFound) {
+			setErrorMessage(app, &c, "Snapshot not found.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if errors.Is(err, errSkinLocked) || errors.Is(err, errCapeLocked) {
+			setErrorMessage(app, &c, err.Error())
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if err != nil {
+			return err
+		}
+		setSuccessMessage(app, &c, "Appearance restored.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+type profileAppearanceSnapshot struct {
+	AppearanceSnapshot
+	SkinURL *string
+	CapeURL *string
+	// Whether it's the user's current appearance
+	Current bool
+}
+
+type profileLibrarySkin struct {
+	LibrarySkin
+	URL string
+}
+
+type profileCosmetic struct {
+	Cosmetic
+	URL     string
+	Wearing bool
+}
+
+// What was entered in the profile page's update form and what was wrong with
+// it, kept in a cookie so that the form can be shown again as it was after
+// a failed update
+type profileForm struct {
+	ProfileUserUUID   string
+	Username          string
+	PlayerName        string
+	FallbackPlayer    string
+	PreferredLanguage string
+	SkinModel         string
+	SkinURL           string
+	CapeURL           string
+	// Finished chunked uploads, kept so they needn't be uploaded again
+	SkinUploadID string
+	CapeUploadID string
+	// Keyed by field: "newUsername", "playerName", "fallbackPlayer",
+	// "preferredLanguage", "skin", or "cape"
+	Errors map[string]string
+}
+
+func setProfileForm(app *App, c *echo.Context, form *profileForm) error {
+	buf, err := json.Marshal(form)
+	if err != nil {
+		return err
+	}
+	setMessageCookie(app, c, "profileForm", string(buf))
+	return nil
+}
+
+// The form as it was entered in the last failed update of profileUser, or
+// otherwise the form filled in from profileUser
+func lastProfileForm(app *App, c *echo.Context, profileUser *User) profileForm {
+	form := profileForm{
+		ProfileUserUUID:   profileUser.UUID,
+		Username:          profileUser.Username,
+		PlayerName:        profileUser.PlayerName,
+		FallbackPlayer:    profileUser.FallbackPlayer,
+		PreferredLanguage: profileUser.PreferredLanguage,
+		SkinModel:         profileUser.SkinModel,
+	}
+	cookie, err := (*c).Cookie("profileForm")
+	if err != nil || cookie.Value == "" {
+		return form
+	}
+	setMessageCookie(app, c, "profileForm", "")
+	decoded, err := url.QueryUnescape(cookie.Value)
+	if err != nil {
+		return form
+	}
+	var last profileForm
+	if err := json.Unmarshal([]byte(decoded), &last); err != nil || last.ProfileUserUUID != profileUser.UUID {
+		return form
+	}
+	return last
+}
+
+// GET /profile
+func FrontProfile(app *App) func(c echo.Context) error {
+	type profileContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		ProfileUser    *User
+		ProfileUserID  string
+		// The update form, as it was entered if the last update failed
+		Form          profileForm
+		SkinURL       *string
+		CapeURL       *string
+		AdminView     bool
+		Announcement  template.HTML
+		Clients       []Client
+		BedrockLink   *BedrockLink
+		LinkedAccount *LinkedAccount
+		ProfileImport *ProfileImport
+		APITokens     []APIToken
+		LibrarySkins  []profileLibrarySkin
+		Cosmetics     []profileCosmetic
+		// Newest first
+		AppearanceSnapshots []profileAppearanceSnapshot
+		// Nil unless the texture queue has something to report
+		SkinStatus    *TextureStatus
+		CapeStatus    *TextureStatus
+		RecentServers []RecentServer
+		// Only for admins
+		Notes             []UserNote
+		ModerationHistory []ModerationEvent
+		SessionHistory    []SessionRecord
+	}
 
 	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
 		var profileUser *User
@@ -436,14 +2527,14 @@ func FrontProfile(app *App) func(c echo.Context) error {
 			profileUser = user
 		} else {
 			if !user.IsAdmin {
-				setErrorMessage(&c, "You are not an admin.")
+				setErrorMessage(app, &c, "You are not an admin.")
 				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
 			}
 			var profileUserStruct User
 			result := app.DB.First(&profileUserStruct, "username = ?", profileUsername)
 			profileUser = &profileUserStruct
 			if result.Error != nil {
-				setErrorMessage(&c, "User not found.")
+				setErrorMessage(app, &c, "User not found.")
 				returnURL, err := url.JoinPath(app.FrontEndURL, "drasl/admin")
 				if err != nil {
 					return err
@@ -455,7 +2546,7 @@ func FrontProfile(app *App) func(c echo.Context) error {
 
 		var skinURL *string
 		if profileUser.SkinHash.Valid {
-			url, err := SkinURL(app, profileUser.SkinHash.String)
+			url, err := FrontEndSkinURL(app, profileUser.SkinHash.String)
 			if err != nil {
 				return err
 			}
@@ -464,90 +2555,412 @@ func FrontProfile(app *App) func(c echo.Context) error {
 
 		var capeURL *string
 		if profileUser.CapeHash.Valid {
-			url, err := CapeURL(app, profileUser.CapeHash.String)
+			url, err := FrontEndCapeURL(app, profileUser.CapeHash.String)
+			if err != nil {
+				return err
+			}
+			capeURL = &url
+		}
+
+		id, err := UUIDToID(profileUser.UUID)
+		if err != nil {
+			return err
+		}
+
+		announcement, err := getAnnouncementHTML(app)
+		if err != nil {
+			return err
+		}
+
+		var clients []Client
+		if err := app.DB.Order("last_used_at DESC").Find(&clients, "user_uuid = ?", profileUser.UUID).Error; err != nil {
+			return err
+		}
+
+		var bedrockLink *BedrockLink
+		if app.Config().Floodgate.Enable {
+			bedrockLink, err = app.GetBedrockLink(profileUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		var linkedAccount *LinkedAccount
+		if app.Config().AccountLinking.Allow {
+			linkedAccount, err = app.GetLinkedAccount(profileUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		var profileImport *ProfileImport
+		if app.Config().ProfileImport.Allow {
+			profileImport, err = app.GetLatestProfileImport(profileUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		var apiTokens []APIToken
+		if app.Config().APITokens.Allow && !adminView {
+			apiTokens, err = app.GetAPITokens(profileUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		var librarySkins []profileLibrarySkin
+		if app.Config().SkinRotation.Allow && !adminView {
+			saved, err := app.GetLibrarySkins(profileUser)
+			if err != nil {
+				return err
+			}
+			for _, librarySkin := range saved {
+				url, err := FrontEndSkinURL(app, librarySkin.SkinHash)
+				if err != nil {
+					return err
+				}
+				librarySkins = append(librarySkins, profileLibrarySkin{LibrarySkin: librarySkin, URL: url})
+			}
+		}
+
+		var cosmetics []profileCosmetic
+		if !adminView {
+			entitled, err := app.GetEntitledCosmetics(profileUser)
+			if err != nil {
+				return err
+			}
+			for _, cosmetic := range entitled {
+				url, err := FrontEndCapeURL(app, cosmetic.TextureHash)
+				if err != nil {
+					return err
+				}
+				cosmetics = append(cosmetics, profileCosmetic{
+					Cosmetic: cosmetic,
+					URL:      url,
+					Wearing:  PtrEquals(UnmakeNullString(&profileUser.CapeHash), &cosmetic.TextureHash),
+				})
+			}
+		}
+
+		var appearanceSnapshots []profileAppearanceSnapshot
+		if app.Config().AppearanceHistory.Enable {
+			snapshots, err := app.GetAppearanceSnapshots(profileUser)
+			if err != nil {
+				return err
+			}
+			for _, snapshot := range snapshots {
+				entry := profileAppearanceSnapshot{
+					AppearanceSnapshot: snapshot,
+					Current:            snapshot.Matches(profileUser),
+				}
+				if snapshot.SkinHash.Valid {
+					url, err := FrontEndSkinURL(app, snapshot.SkinHash.String)
+					if err != nil {
+						return err
+					}
+					entry.SkinURL = &url
+				}
+				if snapshot.CapeHash.Valid {
+					url, err := FrontEndCapeURL(app, snapshot.CapeHash.String)
+					if err != nil {
+						return err
+					}
+					entry.CapeURL = &url
+				}
+				appearanceSnapshots = append(appearanceSnapshots, entry)
+			}
+		}
+
+		var skinStatus, capeStatus *TextureStatus
+		if app.TextureQueue != nil {
+			skinStatus = app.TextureQueue.Status(profileUser, TextureTypeSkin)
+			capeStatus = app.TextureQueue.Status(profileUser, TextureTypeCape)
+		}
+
+		var recentServers []RecentServer
+		if app.Config().SessionHistory.Enable && !adminView {
+			recentServers, err = app.GetRecentServers(profileUser)
+			if err != nil {
+				return err
+			}
+		}
+
+		var notes []UserNote
+		var moderationHistory []ModerationEvent
+		var sessionHistory []SessionRecord
+		if adminView {
+			notes, err = app.GetUserNotes(profileUser)
 			if err != nil {
 				return err
 			}
-			capeURL = &url
+			moderationHistory, err = app.GetModerationHistory(profileUser)
+			if err != nil {
+				return err
+			}
+			if app.Config().SessionHistory.Enable {
+				sessionHistory, err = app.GetSessionHistory(profileUser, SESSION_HISTORY_COUNT)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return c.Render(http.StatusOK, "profile", profileContext{
+			App:                 app,
+			User:                user,
+			URL:                 c.Request().URL.RequestURI(),
+			SuccessMessage:      lastSuccessMessage(app, &c),
+			WarningMessage:      lastWarningMessage(app, &c),
+			ErrorMessage:        lastErrorMessage(app, &c),
+			ProfileUser:         profileUser,
+			ProfileUserID:       id,
+			Form:                lastProfileForm(app, &c, profileUser),
+			SkinURL:             skinURL,
+			CapeURL:             capeURL,
+			AdminView:           adminView,
+			Announcement:        announcement,
+			Clients:             clients,
+			BedrockLink:         bedrockLink,
+			LinkedAccount:       linkedAccount,
+			ProfileImport:       profileImport,
+			APITokens:           apiTokens,
+			LibrarySkins:        librarySkins,
+			Cosmetics:           cosmetics,
+			AppearanceSnapshots: appearanceSnapshots,
+			SkinStatus:          skinStatus,
+			CapeStatus:          capeStatus,
+			RecentServers:       recentServers,
+			Notes:               notes,
+			ModerationHistory:   moderationHistory,
+			SessionHistory:      sessionHistory,
+		})
+	})
+}
+
+// Find a Yggdrasil client that user may manage: one of their own, or anyone's
+// if they are an admin
+func getManagedClient(app *App, user *User, clientUUID string) (*Client, error) {
+	var client Client
+	if err := app.DB.First(&client, "uuid = ?", clientUUID).Error; err != nil {
+		return nil, err
+	}
+	if client.UserUUID != user.UUID && !user.IsAdmin {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &client, nil
+}
+
+// POST /drasl/update-client
+func FrontUpdateClient(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		client, err := getManagedClient(app, user, c.FormValue("clientUuid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "Client not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
+		}
+
+		name := strings.TrimSpace(c.FormValue("name"))
+		if utf8.RuneCountInString(name) > MAX_CLIENT_NAME_LENGTH {
+			setErrorMessage(app, &c, fmt.Sprintf("Client name can't be longer than %d characters.", MAX_CLIENT_NAME_LENGTH))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		err = app.DB.Model(&Client{}).Where("uuid = ?", client.UUID).Updates(map[string]interface{}{
+			"name":      name,
+			"auth_only": c.FormValue("authOnly") == "on",
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Client updated.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/revoke-client
+// Sign a client out by deleting it, invalidating its access tokens
+func FrontRevokeClient(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		client, err := getManagedClient(app, user, c.FormValue("clientUuid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "Client not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return err
 		}
 
-		id, err := UUIDToID(profileUser.UUID)
-		if err != nil {
+		if err := app.DB.Delete(client).Error; err != nil {
 			return err
 		}
 
-		return c.Render(http.StatusOK, "profile", profileContext{
-			App:            app,
-			User:           user,
-			URL:            c.Request().URL.RequestURI(),
-			SuccessMessage: lastSuccessMessage(&c),
-			WarningMessage: lastWarningMessage(&c),
-			ErrorMessage:   lastErrorMessage(&c),
-			ProfileUser:    profileUser,
-			ProfileUserID:  id,
-			SkinURL:        skinURL,
-			CapeURL:        capeURL,
-			AdminView:      adminView,
-		})
+		setSuccessMessage(app, &c, "Client signed out.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
 
+// The contents of a finished chunked upload for FrontUpdate. Returns a
+// message for the user if it can't be used.
+func readChunkedUpload(app *App, user *User, id string, textureType string) (string, []byte, error) {
+	if app.ChunkedUploads == nil {
+		return "Chunked uploads are not enabled on this server.", nil, nil
+	}
+	data, err := app.ChunkedUploads.Read(user, id, textureType)
+	if errors.Is(err, errUploadNotFound) {
+		return fmt.Sprintf("Your uploaded %s has expired. Please upload it again.", textureType), nil, nil
+	}
+	if errors.Is(err, errUploadIncomplete) {
+		return err.Error(), nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return "", data, nil
+}
+
 // POST /update
 func FrontUpdate(app *App) func(c echo.Context) error {
 	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
 		profileUsername := c.FormValue("username")
+		newUsername := c.FormValue("newUsername")
 		playerName := c.FormValue("playerName")
 		fallbackPlayer := c.FormValue("fallbackPlayer")
-		password := c.FormValue("password")
 		preferredLanguage := c.FormValue("preferredLanguage")
 		skinModel := c.FormValue("skinModel")
+		detectSkinModel := c.FormValue("detectSkinModel") == "on"
 		skinURL := c.FormValue("skinUrl")
 		deleteSkin := c.FormValue("deleteSkin") == "on"
 		capeURL := c.FormValue("capeUrl")
 		deleteCape := c.FormValue("deleteCape") == "on"
+		// Set if the texture was sent beforehand with a chunked upload
+		skinUploadID := c.FormValue("skinUploadId")
+		capeUploadID := c.FormValue("capeUploadId")
 
 		var profileUser *User
 		if profileUsername == "" || profileUsername == user.Username {
 			profileUser = user
 		} else {
 			if !user.IsAdmin {
-				setErrorMessage(&c, "You are not an admin.")
+				setErrorMessage(app, &c, "You are not an admin.")
 				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
 			}
 			var profileUserStruct User
 			result := app.DB.First(&profileUserStruct, "username = ?", profileUsername)
 			profileUser = &profileUserStruct
 			if result.Error != nil {
-				setErrorMessage(&c, "User not found.")
+				setErrorMessage(app, &c, "User not found.")
 				return c.Redirect(http.StatusSeeOther, returnURL)
 			}
 		}
 
-		if playerName != "" && playerName != profileUser.PlayerName {
-			if err := ValidatePlayerName(app, playerName); err != nil {
-				setErrorMessage(&c, fmt.Sprintf("Invalid player name: %s", err))
-				return c.Redirect(http.StatusSeeOther, returnURL)
+		form := profileForm{
+			ProfileUserUUID:   profileUser.UUID,
+			Username:          newUsername,
+			PlayerName:        playerName,
+			FallbackPlayer:    fallbackPlayer,
+			PreferredLanguage: preferredLanguage,
+			SkinModel:         skinModel,
+			SkinURL:           skinURL,
+			CapeURL:           capeURL,
+			SkinUploadID:      skinUploadID,
+			CapeUploadID:      capeUploadID,
+			Errors:            map[string]string{},
+		}
+		if form.Username == "" {
+			form.Username = profileUser.Username
+		}
+		if form.PlayerName == "" {
+			form.PlayerName = profileUser.PlayerName
+		}
+		if form.PreferredLanguage == "" {
+			form.PreferredLanguage = profileUser.PreferredLanguage
+		}
+		if form.SkinModel == "" {
+			form.SkinModel = profileUser.SkinModel
+		}
+
+		// Every field is checked before anything is saved, so that an update
+		// either succeeds entirely or changes nothing. The problems are
+		// collected in the order the fields appear on the form.
+		var errorMessages []string
+		fieldError := func(field string, message string) {
+			form.Errors[field] = message
+			errorMessages = append(errorMessages, message)
+		}
+		// Show the form again as it was entered, with what was wrong with it
+		failUpdate := func() error {
+			if err := setProfileForm(app, &c, &form); err != nil {
+				return err
 			}
-			if !app.Config.AllowChangingPlayerName && !user.IsAdmin {
-				setErrorMessage(&c, "Changing your player name is not allowed.")
-				return c.Redirect(http.StatusSeeOther, returnURL)
+			if len(errorMessages) == 1 {
+				setErrorMessage(app, &c, errorMessages[0])
+			} else {
+				setErrorMessage(app, &c, "Nothing was saved. Please fix the problems below and try again.")
 			}
-			offlineUUID, err := OfflineUUID(playerName)
-			if err != nil {
-				return err
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if newUsername != "" && newUsername != profileUser.Username {
+			if err := ValidateUsername(app, newUsername); err != nil {
+				fieldError("newUsername", fmt.Sprintf("Invalid username: %s", err))
+			} else if !app.Config().AllowChangingUsername && !user.IsAdmin {
+				fieldError("newUsername", "Changing your username is not allowed.")
+			} else {
+				// Users can log in with either their username or their
+				// player name, so a username may not be another user's
+				// player name
+				var count int64
+				if err := app.DB.Model(&User{}).Where("normalized_player_name = ? AND uuid != ?", NormalizeName(newUsername), profileUser.UUID).Count(&count).Error; err != nil {
+					return err
+				}
+				if count > 0 {
+					fieldError("newUsername", "That username is taken.")
+				} else {
+					profileUser.Username = newUsername
+				}
+			}
+		}
+
+		if playerName != "" && playerName != profileUser.PlayerName {
+			if err := ValidatePlayerName(app, playerName); err != nil {
+				fieldError("playerName", fmt.Sprintf("Invalid player name: %s", err))
+			} else if err := CheckPlayerNameChange(app, user, profileUser, playerName); err != nil {
+				switch {
+				case errors.Is(err, errPlayerNameChangeNotAllowed):
+					fieldError("playerName", "Changing your player name is not allowed.")
+				case errors.Is(err, errPlayerNameChangeCooldown):
+					fieldError("playerName", fmt.Sprintf("You can't change your player name again until %s.", PlayerNameChangeAvailableAt(app, profileUser).Format(time.DateOnly)))
+				case errors.Is(err, errPlayerNameTaken):
+					fieldError("playerName", "That player name is taken.")
+				default:
+					return err
+				}
+			} else {
+				offlineUUID, err := OfflineUUID(playerName)
+				if err != nil {
+					return err
+				}
+				profileUser.PlayerName = playerName
+				profileUser.OfflineUUID = offlineUUID
+				profileUser.NameLastChangedAt = time.Now()
 			}
-			profileUser.PlayerName = playerName
-			profileUser.OfflineUUID = offlineUUID
-			profileUser.NameLastChangedAt = time.Now()
 		}
 
 		if fallbackPlayer != profileUser.FallbackPlayer {
 			if fallbackPlayer != "" {
 				if err := ValidatePlayerNameOrUUID(app, fallbackPlayer); err != nil {
-					setErrorMessage(&c, fmt.Sprintf("Invalid fallback player: %s", err))
-					return c.Redirect(http.StatusSeeOther, returnURL)
+					fieldError("fallbackPlayer", fmt.Sprintf("Invalid fallback player: %s", err))
 				}
 			}
 			profileUser.FallbackPlayer = fallbackPlayer
@@ -555,29 +2968,23 @@ func FrontUpdate(app *App) func(c echo.Context) error {
 
 		if preferredLanguage != "" {
 			if !IsValidPreferredLanguage(preferredLanguage) {
-				setErrorMessage(&c, "Invalid preferred language.")
-				return c.Redirect(http.StatusSeeOther, returnURL)
+				fieldError("preferredLanguage", "Invalid preferred language.")
 			}
 			profileUser.PreferredLanguage = preferredLanguage
 		}
 
-		if password != "" {
-			if err := ValidatePassword(app, password); err != nil {
-				setErrorMessage(&c, fmt.Sprintf("Invalid password: %s", err))
-				return c.Redirect(http.StatusSeeOther, returnURL)
-			}
-			passwordSalt := make([]byte, 16)
-			_, err := rand.Read(passwordSalt)
-			if err != nil {
-				return err
-			}
-			profileUser.PasswordSalt = passwordSalt
+		skinFile, skinFileErr := c.FormFile("skinFile")
+		capeFile, capeFileErr := c.FormFile("capeFile")
 
-			passwordHash, err := HashPassword(password, passwordSalt)
-			if err != nil {
-				return err
+		if !user.IsAdmin {
+			changingSkin := skinFileErr == nil || skinURL != "" || skinUploadID != "" || deleteSkin || (skinModel != "" && skinModel != profileUser.SkinModel)
+			if changingSkin && profileUser.SkinLocked {
+				fieldError("skin", errSkinLocked.Error())
+			}
+			changingCape := capeFileErr == nil || capeURL != "" || capeUploadID != "" || deleteCape
+			if changingCape && profileUser.CapeLocked {
+				fieldError("cape", errCapeLocked.Error())
 			}
-			profileUser.PasswordHash = passwordHash
 		}
 
 		if skinModel != "" {
@@ -590,147 +2997,519 @@ func FrontUpdate(app *App) func(c echo.Context) error {
 		// Skin and cape updates are done as follows:
 		// 1. Validate with ValidateSkin/ValidateCape
 		// 2. Read the texture into memory and hash it with ReadTexture
-		// 3. Update the database
-		// 4. If the database updated successfully:
-		//    - Acquire a lock to the texture file
-		//    - If the texture file doesn't exist, write it to disk
-		//    - Delete the old texture if it's unused
+		// 3. Lock the new texture file and, in a transaction, update the
+		//    database and write the texture to disk if it doesn't exist
+		//    already
+		// 4. Delete the old texture if it's unused
+		//
+		// A texture that can't be written rolls back the whole update.
 		//
-		// Any update should happen first to the DB, then to the filesystem. We
-		// don't attempt to roll back changes to the DB if we fail to write to
-		// the filesystem.
+		// If the texture queue is enabled, the upload is only read into memory
+		// here, and the queue's workers do the rest.
 
 		// Skin
-		skinFile, skinFileErr := c.FormFile("skinFile")
-
 		var skinBuf *bytes.Buffer
+		// If the texture queue is enabled, the raw upload
+		var queuedSkin []byte
 		oldSkinHash := UnmakeNullString(&profileUser.SkinHash)
 
-		if skinFileErr == nil || skinURL != "" {
-			// The user is setting a new skin
-			if !app.Config.AllowSkins && !user.IsAdmin {
-				setErrorMessage(&c, "Setting a skin is not allowed.")
-				return c.Redirect(http.StatusSeeOther, returnURL)
+		// Read the new skin. Returns a message for the user if it can't be
+		// used.
+		readSkin := func() (string, error) {
+			if !app.Config().AllowSkins && !user.IsAdmin {
+				return "Setting a skin is not allowed.", nil
 			}
 
 			var skinReader io.Reader
-			if skinFileErr == nil {
+			if skinUploadID != "" {
+				message, data, err := readChunkedUpload(app, user, skinUploadID, TextureTypeSkin)
+				if message != "" || err != nil {
+					return message, err
+				}
+				skinReader = bytes.NewReader(data)
+			} else if skinFileErr == nil {
 				// We have a file upload
-				var err error
 				skinHandle, err := skinFile.Open()
 				if err != nil {
-					return err
+					return "", err
 				}
 				defer skinHandle.Close()
 				skinReader = skinHandle
 			} else {
 				// Else, we have a URL
-				res, err := MakeHTTPClient().Get(skinURL)
+				res, err := app.GetTexture(skinURL)
+				if errors.Is(err, errTextureDomainNotAllowed) {
+					return "Skins can't be downloaded from that domain.", nil
+				}
 				if err != nil {
-					setErrorMessage(&c, "Couldn't download skin from that URL.")
-					return c.Redirect(http.StatusSeeOther, returnURL)
+					return "Couldn't download skin from that URL.", nil
 				}
 				defer res.Body.Close()
 				skinReader = res.Body
 			}
 
-			validSkinHandle, err := ValidateSkin(app, skinReader)
-			if err != nil {
-				setErrorMessage(&c, fmt.Sprintf("Error using that skin: %s", err))
-				return c.Redirect(http.StatusSeeOther, returnURL)
-			}
-			var hash string
-			skinBuf, hash, err = ReadTexture(app, validSkinHandle)
-			if err != nil {
-				return err
-			}
-			profileUser.SkinHash = MakeNullString(&hash)
-		} else if deleteSkin {
-			profileUser.SkinHash = MakeNullString(nil)
+			if app.TextureQueue != nil {
+				// Leave the rest to the texture queue
+				var err error
+				queuedSkin, err = ReadTextureUpload(skinReader)
+				return "", err
+			}
+			validSkinHandle, err := ValidateSkin(app, skinReader)
+			if err != nil {
+				return fmt.Sprintf("Error using that skin: %s", err), nil
+			}
+			var hash string
+			skinBuf, hash, err = ReadTexture(app, validSkinHandle)
+			if err != nil {
+				return "", err
+			}
+			profileUser.SkinHash = MakeNullString(&hash)
+			if detectSkinModel {
+				profileUser.SkinModel, err = DetectSkinModelPNG(bytes.NewReader(skinBuf.Bytes()))
+				if err != nil {
+					return "", err
+				}
+			}
+			return "", nil
+		}
+
+		if _, locked := form.Errors["skin"]; !locked && (skinFileErr == nil || skinURL != "" || skinUploadID != "") {
+			// The user is setting a new skin
+			message, err := readSkin()
+			if err != nil {
+				return err
+			}
+			if message != "" {
+				fieldError("skin", message)
+			}
+		} else if deleteSkin && app.TextureQueue == nil {
+			profileUser.SkinHash = MakeNullString(nil)
+		}
+
+		// Cape
+		var capeBuf *bytes.Buffer
+		var queuedCape []byte
+		oldCapeHash := UnmakeNullString(&profileUser.CapeHash)
+
+		readCape := func() (string, error) {
+			if !app.Config().AllowCapes && !user.IsAdmin {
+				return "Setting a cape is not allowed.", nil
+			}
+
+			var capeReader io.Reader
+			if capeUploadID != "" {
+				message, data, err := readChunkedUpload(app, user, capeUploadID, TextureTypeCape)
+				if message != "" || err != nil {
+					return message, err
+				}
+				capeReader = bytes.NewReader(data)
+			} else if capeFileErr == nil {
+				capeHandle, err := capeFile.Open()
+				if err != nil {
+					return "", err
+				}
+				defer capeHandle.Close()
+				capeReader = capeHandle
+			} else {
+				res, err := app.GetTexture(capeURL)
+				if errors.Is(err, errTextureDomainNotAllowed) {
+					return "Capes can't be downloaded from that domain.", nil
+				}
+				if err != nil {
+					return "Couldn't download cape from that URL.", nil
+				}
+				defer res.Body.Close()
+				capeReader = res.Body
+			}
+
+			if app.TextureQueue != nil {
+				var err error
+				queuedCape, err = ReadTextureUpload(capeReader)
+				return "", err
+			}
+			validCapeHandle, err := ValidateCape(app, capeReader)
+			if err != nil {
+				return fmt.Sprintf("Error using that cape: %s", err), nil
+			}
+			var hash string
+			capeBuf, hash, err = ReadTexture(app, validCapeHandle)
+			if err != nil {
+				return "", err
+			}
+			profileUser.CapeHash = MakeNullString(&hash)
+			return "", nil
+		}
+
+		if _, locked := form.Errors["cape"]; !locked && (capeFileErr == nil || capeURL != "" || capeUploadID != "") {
+			message, err := readCape()
+			if err != nil {
+				return err
+			}
+			if message != "" {
+				fieldError("cape", message)
+			}
+		} else if deleteCape && app.TextureQueue == nil {
+			profileUser.CapeHash = MakeNullString(nil)
+		}
+
+		if len(errorMessages) > 0 {
+			return failUpdate()
+		}
+
+		newSkinHash := UnmakeNullString(&profileUser.SkinHash)
+		newCapeHash := UnmakeNullString(&profileUser.CapeHash)
+		skinChanged := !PtrEquals(oldSkinHash, newSkinHash)
+		capeChanged := !PtrEquals(oldCapeHash, newCapeHash)
+
+		// Nothing may delete the new textures until the transaction is done
+		var unlocks []func()
+		if skinChanged && newSkinHash != nil {
+			unlocks = append(unlocks, app.FSMutex.Lock(GetSkinPath(app, *newSkinHash)))
+		}
+		if capeChanged && newCapeHash != nil {
+			unlocks = append(unlocks, app.FSMutex.Lock(GetCapePath(app, *newCapeHash)))
+		}
+
+		errWriteSkin := errors.New("Error saving the skin.")
+		errWriteCape := errors.New("Error saving the cape.")
+		err := app.DB.Transaction(func(tx *gorm.DB) error {
+			db := tx
+			if app.TextureQueue != nil {
+				// The texture queue may change these at any time
+				db = db.Omit("skin_hash", "cape_hash")
+			}
+			if err := db.Save(profileUser).Error; err != nil {
+				return err
+			}
+			if skinChanged && newSkinHash != nil {
+				if err := SaveSkinFingerprint(tx, *newSkinHash, skinBuf.Bytes()); err != nil {
+					return err
+				}
+				if err := writeTextureLocked(GetSkinPath(app, *newSkinHash), skinBuf); err != nil {
+					return errWriteSkin
+				}
+			}
+			if capeChanged && newCapeHash != nil {
+				if err := writeTextureLocked(GetCapePath(app, *newCapeHash), capeBuf); err != nil {
+					return errWriteCape
+				}
+			}
+			return nil
+		})
+		for _, unlock := range unlocks {
+			unlock()
+		}
+		if err != nil {
+			// A texture written before the transaction failed may be unused
+			if skinChanged {
+				DeleteSkinIfUnused(app, newSkinHash)
+			}
+			if capeChanged {
+				DeleteCapeIfUnused(app, newCapeHash)
+			}
+			switch {
+			case errors.Is(err, errWriteSkin):
+				fieldError("skin", err.Error())
+			case errors.Is(err, errWriteCape):
+				fieldError("cape", err.Error())
+			case IsErrorUniqueFailedField(err, "users.username") ||
+				IsErrorUniqueFailedField(err, "users.normalized_username"):
+				fieldError("newUsername", "That username is taken.")
+			case IsErrorUniqueFailed(err):
+				fieldError("playerName", "That player name is taken.")
+			default:
+				return err
+			}
+			return failUpdate()
+		}
+
+		if skinChanged {
+			DeleteSkinIfUnused(app, oldSkinHash)
+		}
+		if capeChanged {
+			DeleteCapeIfUnused(app, oldCapeHash)
+		}
+		if app.ChunkedUploads != nil {
+			for _, uploadID := range []string{skinUploadID, capeUploadID} {
+				if uploadID != "" {
+					app.ChunkedUploads.Remove(user, uploadID)
+				}
+			}
+		}
+
+		if err := app.RecordAppearance(profileUser.UUID); err != nil {
+			return err
+		}
+
+		if app.TextureQueue != nil {
+			queued := false
+			for _, texture := range []struct {
+				TextureType string
+				Data        []byte
+				Delete      bool
+			}{
+				{TextureTypeSkin, queuedSkin, deleteSkin},
+				{TextureTypeCape, queuedCape, deleteCape},
+			} {
+				if texture.Data != nil {
+					err := app.TextureQueue.Enqueue(profileUser, texture.TextureType, texture.Data, detectSkinModel)
+					if errors.Is(err, ErrTextureQueueFull) {
+						// Everything else has been saved, so only the
+						// texture is shown as a problem
+						form.Errors[texture.TextureType] = "The server is too busy to process this. Please try again in a moment."
+						if err := setProfileForm(app, &c, &form); err != nil {
+							return err
+						}
+						setErrorMessage(app, &c, fmt.Sprintf("Other changes were saved, but the server is too busy to process your %s. Please try again in a moment.", texture.TextureType))
+						return c.Redirect(http.StatusSeeOther, returnURL)
+					}
+					if err != nil {
+						return err
+					}
+					queued = true
+				} else if texture.Delete {
+					if err := app.TextureQueue.Delete(profileUser, texture.TextureType); err != nil {
+						return err
+					}
+				}
+			}
+			if queued {
+				setSuccessMessage(app, &c, "Changes saved. New textures will appear once they've been processed.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+		}
+
+		setSuccessMessage(app, &c, "Changes saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/update-email
+func FrontUpdateEmail(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().Email.Enable {
+			setErrorMessage(app, &c, "Email is not enabled on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		profileUsername := c.FormValue("username")
+		email := strings.TrimSpace(c.FormValue("email"))
+		receiveAnnouncements := c.FormValue("receiveAnnouncements") == "on"
+		receiveSecurityNotifications := c.FormValue("receiveSecurityNotifications") == "on"
+
+		var profileUser *User
+		if profileUsername == "" || profileUsername == user.Username {
+			profileUser = user
+		} else {
+			if !user.IsAdmin {
+				setErrorMessage(app, &c, "You are not an admin.")
+				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+			}
+			var profileUserStruct User
+			result := app.DB.First(&profileUserStruct, "username = ?", profileUsername)
+			profileUser = &profileUserStruct
+			if result.Error != nil {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+		}
+
+		// The old address is told about the change
+		oldUser := *profileUser
+
+		emailChanged := email != profileUser.Email.String
+		if emailChanged {
+			if email == "" {
+				profileUser.Email = MakeNullString(nil)
+			} else {
+				if err := ValidateEmail(email); err != nil {
+					setErrorMessage(app, &c, fmt.Sprintf("Invalid email: %s", err))
+					return c.Redirect(http.StatusSeeOther, returnURL)
+				}
+				profileUser.Email = MakeNullString(&email)
+			}
+			profileUser.EmailVerified = false
+		}
+		profileUser.EmailOptOut = !receiveAnnouncements
+		profileUser.SecurityNotificationsOptOut = !receiveSecurityNotifications
+
+		if err := app.DB.Save(profileUser).Error; err != nil {
+			return err
+		}
+
+		if emailChanged {
+			app.NotifySecurityEvent(&oldUser, SecurityEventEmailChange, c.RealIP(), c.Request().UserAgent())
+		}
+
+		if emailChanged && email != "" {
+			if err := app.SendVerificationEmail(profileUser); err != nil {
+				log.Printf("Couldn't send verification email to %s: %s\n", profileUser.Username, err)
+				setWarningMessage(app, &c, "Changes saved, but the verification email couldn't be sent. Try again later.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			setSuccessMessage(app, &c, "Changes saved. Check your inbox for a link to verify your email address.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		setSuccessMessage(app, &c, "Changes saved.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// Find the user a link from an email was made for, or nil if the link's token
+// is invalid
+func getEmailLinkUser(app *App, c *echo.Context, purpose string, parts ...string) (*User, error) {
+	userUUID := (*c).QueryParam("user")
+	token := (*c).QueryParam("token")
+	expected := makeEmailToken(app, purpose, append([]string{userUUID}, parts...)...)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+		return nil, nil
+	}
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", userUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GET /drasl/verify-email
+func FrontVerifyEmail(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		email := c.QueryParam("email")
+		user, err := getEmailLinkUser(app, &c, "verify-email", email)
+		if err != nil {
+			return err
+		}
+		if user == nil || user.Email.String != email {
+			setErrorMessage(app, &c, "This verification link is invalid.")
+			return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+		}
+
+		user.EmailVerified = true
+		if err := app.DB.Save(user).Error; err != nil {
+			return err
+		}
+
+		setSuccessMessage(app, &c, "Email address verified.")
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+	}
+}
+
+// GET /drasl/unsubscribe
+func FrontUnsubscribe(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		user, err := getEmailLinkUser(app, &c, "unsubscribe")
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			setErrorMessage(app, &c, "This unsubscribe link is invalid.")
+			return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+		}
+
+		user.EmailOptOut = true
+		if err := app.DB.Save(user).Error; err != nil {
+			return err
 		}
 
-		// Cape
-		capeFile, capeFileErr := c.FormFile("capeFile")
+		setSuccessMessage(app, &c, "You won't receive any more announcement emails.")
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+	}
+}
 
-		var capeBuf *bytes.Buffer
-		oldCapeHash := UnmakeNullString(&profileUser.CapeHash)
+// POST /drasl/change-password
+func FrontChangePassword(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
 
-		if capeFileErr == nil || capeURL != "" {
-			if !app.Config.AllowCapes && !user.IsAdmin {
-				setErrorMessage(&c, "Setting a cape is not allowed.")
-				return c.Redirect(http.StatusSeeOther, returnURL)
-			}
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
 
-			var capeReader io.Reader
-			if capeFileErr == nil {
-				var err error
-				capeHandle, err := capeFile.Open()
-				if err != nil {
-					return err
-				}
-				defer capeHandle.Close()
-				capeReader = capeHandle
-			} else {
-				res, err := MakeHTTPClient().Get(capeURL)
-				if err != nil {
-					setErrorMessage(&c, "Couldn't download cape from that URL.")
-					return c.Redirect(http.StatusSeeOther, returnURL)
-				}
-				defer res.Body.Close()
-				capeReader = res.Body
-			}
+		profileUsername := c.FormValue("username")
+		currentPassword := c.FormValue("currentPassword")
+		password := c.FormValue("password")
 
-			validCapeHandle, err := ValidateCape(app, capeReader)
-			if err != nil {
-				setErrorMessage(&c, fmt.Sprintf("Error using that cape: %s", err))
-				return c.Redirect(http.StatusSeeOther, returnURL)
+		var profileUser *User
+		if profileUsername == "" || profileUsername == user.Username {
+			profileUser = user
+		} else {
+			if !user.IsAdmin {
+				setErrorMessage(app, &c, "You are not an admin.")
+				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
 			}
-			var hash string
-			capeBuf, hash, err = ReadTexture(app, validCapeHandle)
-			if err != nil {
-				return err
+			var profileUserStruct User
+			result := app.DB.First(&profileUserStruct, "username = ?", profileUsername)
+			profileUser = &profileUserStruct
+			if result.Error != nil {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
 			}
-			profileUser.CapeHash = MakeNullString(&hash)
-		} else if deleteCape {
-			profileUser.CapeHash = MakeNullString(nil)
 		}
 
-		newSkinHash := UnmakeNullString(&profileUser.SkinHash)
-		newCapeHash := UnmakeNullString(&profileUser.CapeHash)
-
-		err := app.DB.Save(&profileUser).Error
+		// Admins changing someone else's password confirm with their own
+		currentPasswordOK, err := app.CheckPassword(user, currentPassword)
 		if err != nil {
-			if IsErrorUniqueFailed(err) {
-				setErrorMessage(&c, "That player name is taken.")
-				return c.Redirect(http.StatusSeeOther, returnURL)
-			}
 			return err
 		}
+		if !currentPasswordOK {
+			setErrorMessage(app, &c, "Incorrect current password!")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
 
-		if !PtrEquals(oldSkinHash, newSkinHash) {
-			if newSkinHash != nil {
-				err = WriteSkin(app, *newSkinHash, skinBuf)
-				if err != nil {
-					setErrorMessage(&c, "Error saving the skin.")
-					return c.Redirect(http.StatusSeeOther, returnURL)
-				}
-			}
+		if err := ValidatePassword(app, password); err != nil {
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid password: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
 
-			DeleteSkinIfUnused(app, oldSkinHash)
+		if err := SetPassword(profileUser, password); err != nil {
+			return err
 		}
-		if !PtrEquals(oldCapeHash, newCapeHash) {
-			if newCapeHash != nil {
-				err = WriteCape(app, *newCapeHash, capeBuf)
-				if err != nil {
-					setErrorMessage(&c, "Error saving the cape.")
-					return c.Redirect(http.StatusSeeOther, returnURL)
-				}
+
+		// Log out every other browser session and invalidate all access
+		// tokens. If the user changed their own password, keep them logged in
+		// under a new browser token.
+		var browserToken string
+		if profileUser == user {
+			browserToken, err = RandomHex(32)
+			if err != nil {
+				return err
 			}
+			profileUser.BrowserToken = MakeNullString(&browserToken)
+		} else {
+			profileUser.BrowserToken = MakeNullString(nil)
+		}
 
-			DeleteCapeIfUnused(app, oldCapeHash)
+		tx := app.DB.Begin()
+		defer tx.Rollback()
+
+		if err := tx.Save(profileUser).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(Client{}).Where("user_uuid = ?", profileUser.UUID).Update("version", gorm.Expr("version + ?", 1)).Error; err != nil {
+			return err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
 		}
 
-		setSuccessMessage(&c, "Changes saved.")
+		if profileUser == user {
+			setCookie(app, &c, "browserToken", browserToken, BROWSER_TOKEN_AGE_SEC)
+		}
+
+		app.NotifySecurityEvent(profileUser, SecurityEventPasswordChange, c.RealIP(), c.Request().UserAgent())
+
+		setSuccessMessage(app, &c, "Password changed.")
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
@@ -739,20 +3518,100 @@ func FrontUpdate(app *App) func(c echo.Context) error {
 func FrontLogout(app *App) func(c echo.Context) error {
 	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
 		returnURL := app.FrontEndURL
-		c.SetCookie(&http.Cookie{
-			Name:     "browserToken",
-			Value:    "",
-			MaxAge:   -1,
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-			HttpOnly: true,
-		})
+		if user.ImpersonatedBy != nil {
+			// Log out the admin, not the user they're signed in as
+			setImpersonationCookie(app, &c, "")
+			admin := user.ImpersonatedBy
+			if err := app.LogAudit(admin, AuditActionImpersonationStop, user, ""); err != nil {
+				return err
+			}
+			user = admin
+		}
+		setCookie(app, &c, "browserToken", "", -1)
 		user.BrowserToken = MakeNullString(nil)
 		app.DB.Save(user)
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
 }
 
+// POST /drasl/admin/impersonate
+func FrontImpersonate(app *App) func(c echo.Context) error {
+	return withBrowserAdmin(app, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		var targetUser User
+		result := app.DB.First(&targetUser, "username = ?", c.FormValue("username"))
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			return result.Error
+		}
+		if targetUser.IsAdmin {
+			setErrorMessage(app, &c, "You can't sign in as another admin.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		if err := app.LogAudit(user, AuditActionImpersonationStart, &targetUser, ""); err != nil {
+			return err
+		}
+		setImpersonationCookie(app, &c, targetUser.UUID)
+
+		setSuccessMessage(app, &c, fmt.Sprintf("You are now signed in as %s.", targetUser.Username))
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL+"/drasl/profile")
+	})
+}
+
+// POST /drasl/stop-impersonating
+func FrontStopImpersonating(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		if user.ImpersonatedBy == nil {
+			setErrorMessage(app, &c, "You are not signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, getReturnURL(app, &c))
+		}
+
+		if err := app.LogAudit(user.ImpersonatedBy, AuditActionImpersonationStop, user, ""); err != nil {
+			return err
+		}
+		setImpersonationCookie(app, &c, "")
+
+		setSuccessMessage(app, &c, fmt.Sprintf("You are no longer signed in as %s.", user.Username))
+		return c.Redirect(http.StatusSeeOther, app.FrontEndURL+"/drasl/admin")
+	})
+}
+
+// Challenge tokens record when they were issued so that challenges can expire
+// after RegistrationExistingPlayer.ChallengeExpireSec. The timestamp is part
+// of the token, and so of the challenge, so it can't be changed without
+// invalidating the skin.
+func makeChallengeToken() (string, error) {
+	random, err := RandomHex(32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%d", random, time.Now().Unix()), nil
+}
+
+// When a challenge token expires, or false if it isn't a valid token
+func challengeTokenExpiresAt(app *App, token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return time.Time{}, false
+	}
+	issuedAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	expireSec := time.Duration(app.Config().RegistrationExistingPlayer.ChallengeExpireSec) * time.Second
+	return time.Unix(issuedAt, 0).Add(expireSec), true
+}
+
+func challengeTokenExpired(app *App, token string) bool {
+	expiresAt, ok := challengeTokenExpiresAt(app, token)
+	return !ok || !time.Now().Before(expiresAt)
+}
+
 func getChallenge(app *App, username string, token string) []byte {
 	// This challenge is nice because:
 	// - it doesn't depend on any serverside state
@@ -772,6 +3631,56 @@ func getChallenge(app *App, username string, token string) []byte {
 	return sum[:]
 }
 
+func loadVerificationSkin(app *App) *image.NRGBA {
+	verification_skin_path := GetThemedPath(app, "assets", "verification-skin.png")
+	verification_skin_file := Unwrap(os.Open(verification_skin_path))
+
+	verification_rgba := Unwrap(png.Decode(verification_skin_file))
+
+	verification_img, ok := verification_rgba.(*image.NRGBA)
+	if !ok {
+		log.Fatal("Invalid verification skin!")
+	}
+	return verification_img
+}
+
+// Make a PNG skin for `username` to set on their existing account, with the
+// challenge for `challengeToken` embedded into it
+func makeChallengeSkin(app *App, verificationSkin *image.NRGBA, username string, challengeToken string) ([]byte, error) {
+	// challenge is a 512-bit, 64 byte checksum
+	challenge := getChallenge(app, username, challengeToken)
+
+	// Embed the challenge into a skin
+	skinSize := 64
+	img := image.NewNRGBA(image.Rectangle{image.Point{0, 0}, image.Point{skinSize, skinSize}})
+
+	challengeByte := 0
+	for y := 0; y < skinSize; y += 1 {
+		for x := 0; x < skinSize; x += 1 {
+			var col color.NRGBA
+			if SKIN_WINDOW_Y_MIN <= y && y < SKIN_WINDOW_Y_MAX && SKIN_WINDOW_X_MIN <= x && x < SKIN_WINDOW_X_MAX {
+				col = color.NRGBA{
+					challenge[challengeByte],
+					challenge[challengeByte+1],
+					challenge[challengeByte+2],
+					challenge[challengeByte+3],
+				}
+				challengeByte += 4
+			} else {
+				col = verificationSkin.At(x, y).(color.NRGBA)
+			}
+			img.SetNRGBA(x, y, col)
+		}
+	}
+
+	var imgBuffer bytes.Buffer
+	err := png.Encode(&imgBuffer, img)
+	if err != nil {
+		return nil, err
+	}
+	return imgBuffer.Bytes(), nil
+}
+
 // GET /challenge-skin
 func FrontChallengeSkin(app *App) func(c echo.Context) error {
 	type challengeSkinContext struct {
@@ -783,101 +3692,133 @@ func FrontChallengeSkin(app *App) func(c echo.Context) error {
 		ErrorMessage         string
 		Username             string
 		RegistrationProvider string
+		Source               *registrationExistingPlayerSource
 		SkinBase64           string
 		SkinFilename         string
 		ChallengeToken       string
+		ExpiresAt            time.Time
 		InviteCode           string
+		// Linking the account to the logged-in user's rather than registering
+		Link bool
 	}
 
-	verification_skin_path := path.Join(app.Config.DataDirectory, "assets", "verification-skin.png")
-	verification_skin_file := Unwrap(os.Open(verification_skin_path))
-
-	verification_rgba := Unwrap(png.Decode(verification_skin_file))
-
-	verification_img, ok := verification_rgba.(*image.NRGBA)
-	if !ok {
-		log.Fatal("Invalid verification skin!")
-	}
+	verificationSkin := loadVerificationSkin(app)
 
 	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
 		username := c.QueryParam("username")
 		if err := ValidateUsername(app, username); err != nil {
-			setErrorMessage(&c, fmt.Sprintf("Invalid username: %s", err))
+			setErrorMessage(app, &c, fmt.Sprintf("Invalid username: %s", err))
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		source := getExistingPlayerSource(app, c.QueryParam("source"))
+		if source == nil {
+			setErrorMessage(app, &c, "Unknown account provider.")
 			return c.Redirect(http.StatusSeeOther, returnURL)
 		}
 
 		inviteCode := c.QueryParam("inviteCode")
 
+		link := c.QueryParam("link") == "true"
+		if link && (user == nil || !app.Config().AccountLinking.Allow) {
+			setErrorMessage(app, &c, "Linking accounts is not allowed.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
+		// Reuse the browser's challenge token, so reloading the page doesn't
+		// change the skin, unless it has expired
 		var challengeToken string
 		cookie, err := c.Cookie("challengeToken")
-		if err != nil || cookie.Value == "" {
-			challengeToken, err = RandomHex(32)
+		if err != nil || challengeTokenExpired(app, cookie.Value) {
+			challengeToken, err = makeChallengeToken()
 			if err != nil {
 				return err
 			}
-			c.SetCookie(&http.Cookie{
-				Name:     "challengeToken",
-				Value:    challengeToken,
-				MaxAge:   BROWSER_TOKEN_AGE_SEC,
-				Path:     "/",
-				SameSite: http.SameSiteStrictMode,
-				HttpOnly: true,
-			})
+			setCookie(app, &c, "challengeToken", challengeToken, app.Config().RegistrationExistingPlayer.ChallengeExpireSec)
 		} else {
 			challengeToken = cookie.Value
 		}
+		expiresAt, _ := challengeTokenExpiresAt(app, challengeToken)
 
-		// challenge is a 512-bit, 64 byte checksum
-		challenge := getChallenge(app, username, challengeToken)
-
-		// Embed the challenge into a skin
-		skinSize := 64
-		img := image.NewNRGBA(image.Rectangle{image.Point{0, 0}, image.Point{skinSize, skinSize}})
-
-		challengeByte := 0
-		for y := 0; y < skinSize; y += 1 {
-			for x := 0; x < skinSize; x += 1 {
-				var col color.NRGBA
-				if SKIN_WINDOW_Y_MIN <= y && y < SKIN_WINDOW_Y_MAX && SKIN_WINDOW_X_MIN <= x && x < SKIN_WINDOW_X_MAX {
-					col = color.NRGBA{
-						challenge[challengeByte],
-						challenge[challengeByte+1],
-						challenge[challengeByte+2],
-						challenge[challengeByte+3],
-					}
-					challengeByte += 4
-				} else {
-					col = verification_img.At(x, y).(color.NRGBA)
-				}
-				img.SetNRGBA(x, y, col)
-			}
-		}
-
-		var imgBuffer bytes.Buffer
-		err = png.Encode(&imgBuffer, img)
+		challengeSkin, err := makeChallengeSkin(app, verificationSkin, username, challengeToken)
 		if err != nil {
 			return err
 		}
 
-		skinBase64 := base64.StdEncoding.EncodeToString(imgBuffer.Bytes())
+		skinBase64 := base64.StdEncoding.EncodeToString(challengeSkin)
 		return c.Render(http.StatusOK, "challenge-skin", challengeSkinContext{
 			App:            app,
 			User:           user,
 			URL:            c.Request().URL.RequestURI(),
-			SuccessMessage: lastSuccessMessage(&c),
-			WarningMessage: lastWarningMessage(&c),
-			ErrorMessage:   lastErrorMessage(&c),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
 			Username:       username,
+			Source:         source,
 			SkinBase64:     skinBase64,
 			SkinFilename:   username + "-challenge.png",
 			ChallengeToken: challengeToken,
+			ExpiresAt:      expiresAt,
 			InviteCode:     inviteCode,
+			Link:           link,
 		})
 	})
 }
 
+type challengeSkinStatusResponse struct {
+	Verified bool   `json:"verified"`
+	Expired  bool   `json:"expired"`
+	Message  string `json:"message"`
+}
+
+// GET /drasl/challenge-skin/status
+// Polled by the challenge skin page so users can tell when the API server has
+// picked up their new skin.
+func FrontChallengeSkinStatus(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		source := getExistingPlayerSource(app, c.QueryParam("source"))
+		if source == nil {
+			return c.JSON(http.StatusBadRequest, challengeSkinStatusResponse{Message: "Unknown account provider."})
+		}
+
+		var challengeToken string
+		if cookie, err := c.Cookie("challengeToken"); err == nil {
+			challengeToken = cookie.Value
+		}
+
+		// Linking an account always requires verification
+		link := c.QueryParam("link") == "true"
+		verifySkin := link || app.Config().RegistrationExistingPlayer.RequireSkinVerification
+		_, err := validateChallenge(app, source, c.QueryParam("username"), challengeToken, verifySkin)
+		switch {
+		case err == nil:
+			message := "Your skin has been verified. You can register now."
+			if link {
+				message = "Your skin has been verified. You can link your account now."
+			}
+			return c.JSON(http.StatusOK, challengeSkinStatusResponse{
+				Verified: true,
+				Message:  message,
+			})
+		case errors.Is(err, errChallengeExpired):
+			return c.JSON(http.StatusOK, challengeSkinStatusResponse{
+				Expired: true,
+				Message: "This verification skin has expired. Reload the page to get a new one.",
+			})
+		case errors.Is(err, errChallengeSkinMismatch):
+			return c.JSON(http.StatusOK, challengeSkinStatusResponse{
+				Message: fmt.Sprintf("Waiting for your new skin to show up on %s. This can take a few minutes.", source.Nickname),
+			})
+		default:
+			return c.JSON(http.StatusOK, challengeSkinStatusResponse{
+				Message: fmt.Sprintf("Couldn't check your skin yet: %s", err),
+			})
+		}
+	}
+}
+
 // type registrationUsernameToIDResponse struct {
 // 	Name string `json:"name"`
 // 	ID   string `json:"id"`
@@ -888,8 +3829,52 @@ type proxiedAccountDetails struct {
 	UUID     string
 }
 
-func validateChallenge(app *App, username string, challengeToken string) (*proxiedAccountDetails, error) {
-	base, err := url.Parse(app.Config.RegistrationExistingPlayer.AccountURL)
+// Find the existing-player registration source with the given nickname, or
+// nil if there is none. If only one source is configured, the nickname may be
+// omitted.
+func getExistingPlayerSource(app *App, nickname string) *registrationExistingPlayerSource {
+	sources := app.Config().RegistrationExistingPlayer.AllSources()
+	if nickname == "" && len(sources) == 1 {
+		return &sources[0]
+	}
+	for _, source := range PtrSlice(sources) {
+		if source.Nickname == nickname {
+			return source
+		}
+	}
+	return nil
+}
+
+var errChallengeSkinMismatch = errors.New("skin does not match")
+var errChallengeExpired = errors.New("the verification skin has expired, please start over")
+
+// How many times to try each request to the registration server before giving
+// up, in case it's briefly unavailable
+const CHALLENGE_REQUEST_ATTEMPTS = 3
+
+func getWithRetry(app *App, url string) (*http.Response, error) {
+	var res *http.Response
+	var err error
+	for attempt := 1; attempt <= CHALLENGE_REQUEST_ATTEMPTS; attempt += 1 {
+		res, err = app.MakeHTTPClient().Get(url)
+		if err == nil && res.StatusCode < 500 {
+			return res, nil
+		}
+		if err == nil {
+			res.Body.Close()
+			err = fmt.Errorf("status code %d", res.StatusCode)
+		}
+		if attempt < CHALLENGE_REQUEST_ATTEMPTS {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+	}
+	return nil, err
+}
+
+// Look up the player `username` on `source` and, if `verifySkin`, check that
+// they've set the challenge skin for `challengeToken`
+func validateChallenge(app *App, source *registrationExistingPlayerSource, username string, challengeToken string, verifySkin bool) (*proxiedAccountDetails, error) {
+	base, err := url.Parse(source.AccountURL)
 	if err != nil {
 		return nil, err
 	}
@@ -898,7 +3883,7 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 		return nil, err
 	}
 
-	res, err := MakeHTTPClient().Get(base.String())
+	res, err := getWithRetry(app, base.String())
 	if err != nil {
 		log.Printf("Couldn't access registration server at %s: %s\n", base.String(), err)
 		return nil, err
@@ -916,17 +3901,18 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 		return nil, err
 	}
 
-	base, err = url.Parse(app.Config.RegistrationExistingPlayer.SessionURL)
+	base, err = url.Parse(source.SessionURL)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid SessionURL %s: %s", app.Config.RegistrationExistingPlayer.SessionURL, err)
+		return nil, fmt.Errorf("Invalid SessionURL %s: %s", source.SessionURL, err)
 	}
 	base.Path, err = url.JoinPath(base.Path, "session/minecraft/profile/"+idRes.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err = MakeHTTPClient().Get(base.String())
+	res, err = getWithRetry(app, base.String())
 	if err != nil {
+		log.Printf("Couldn't access registration server at %s: %s\n", base.String(), err)
 		return nil, err
 	}
 	defer res.Body.Close()
@@ -951,7 +3937,7 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 		Username: profileRes.Name,
 		UUID:     accountUUID,
 	}
-	if !app.Config.RegistrationExistingPlayer.RequireSkinVerification {
+	if !verifySkin {
 		return &details, nil
 	}
 
@@ -971,26 +3957,36 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 			if texture.Textures.Skin == nil {
 				return nil, errors.New("player does not have a skin")
 			}
-			res, err = MakeHTTPClient().Get(texture.Textures.Skin.URL)
+			res, err = getWithRetry(app, texture.Textures.Skin.URL)
 			if err != nil {
 				return nil, err
 			}
 			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("couldn't download skin, status code %d", res.StatusCode)
+			}
 
-			rgba_img, err := png.Decode(res.Body)
+			// API servers may re-encode skins, so convert whatever we get
+			// back rather than requiring the exact format we generated
+			img, err := png.Decode(res.Body)
 			if err != nil {
 				return nil, err
 			}
-			img, ok := rgba_img.(*image.NRGBA)
-			if !ok {
-				return nil, errors.New("invalid image")
+			bounds := img.Bounds()
+			if bounds.Dx() < SKIN_WINDOW_X_MAX || bounds.Dy() < SKIN_WINDOW_Y_MAX {
+				return nil, errChallengeSkinMismatch
 			}
 
 			challenge := make([]byte, 64)
 			challengeByte := 0
 			for y := SKIN_WINDOW_Y_MIN; y < SKIN_WINDOW_Y_MAX; y += 1 {
 				for x := SKIN_WINDOW_X_MIN; x < SKIN_WINDOW_X_MAX; x += 1 {
-					c := img.NRGBAAt(x, y)
+					c := color.NRGBAModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					if c.A == 0 {
+						// Servers, including Drasl, may discard the color of
+						// transparent pixels
+						c = color.NRGBA{}
+					}
 					challenge[challengeByte] = c.R
 					challenge[challengeByte+1] = c.G
 					challenge[challengeByte+2] = c.B
@@ -1001,13 +3997,17 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 			}
 
 			correctChallenge := getChallenge(app, username, challengeToken)
+			for i := 0; i < len(correctChallenge); i += 4 {
+				if correctChallenge[i+3] == 0 {
+					correctChallenge[i], correctChallenge[i+1], correctChallenge[i+2] = 0, 0, 0
+				}
+			}
 
 			if !bytes.Equal(challenge, correctChallenge) {
-				return nil, errors.New("skin does not match")
+				return nil, errChallengeSkinMismatch
 			}
-
-			if err != nil {
-				return nil, err
+			if challengeTokenExpired(app, challengeToken) {
+				return nil, errChallengeExpired
 			}
 
 			return &details, nil
@@ -1021,285 +4021,519 @@ func validateChallenge(app *App, username string, challengeToken string) (*proxi
 func FrontRegister(app *App) func(c echo.Context) error {
 	returnURL := Unwrap(url.JoinPath(app.FrontEndURL, "drasl/profile"))
 	return func(c echo.Context) error {
+		failureURL := getReturnURL(app, &c)
+		noInviteFailureURL, err := StripQueryParam(failureURL, "invite")
+		if err != nil {
+			return err
+		}
+
+		if c.FormValue("email") != "" {
+			setErrorMessage(app, &c, "You are now covered in bee stings.")
+			return c.Redirect(http.StatusSeeOther, failureURL)
+		}
+
+		browserToken, err := RandomHex(32)
+		if err != nil {
+			return err
+		}
+
+		_, err = app.RegisterUser(&RegistrationRequest{
+			Username:       c.FormValue("username"),
+			Password:       c.FormValue("password"),
+			Email:          strings.TrimSpace(c.FormValue("emailAddress")),
+			ChosenUUID:     c.FormValue("uuid"),
+			InviteCode:     c.FormValue("inviteCode"),
+			ExistingPlayer: c.FormValue("existingPlayer") == "on",
+			Source:         c.FormValue("source"),
+			ChallengeToken: c.FormValue("challengeToken"),
+			IP:             c.RealIP(),
+			BrowserToken:   &browserToken,
+			AcceptedTerms:  c.FormValue("acceptTerms") == "on",
+		})
+		if err != nil {
+			var registrationError *RegistrationError
+			if errors.As(err, &registrationError) {
+				setErrorMessage(app, &c, registrationError.Message)
+				if registrationError.Code == RegistrationErrorInviteNotFound {
+					return c.Redirect(http.StatusSeeOther, noInviteFailureURL)
+				}
+				return c.Redirect(http.StatusSeeOther, failureURL)
+			}
+			return err
+		}
+
+		setCookie(app, &c, "browserToken", browserToken, BROWSER_TOKEN_AGE_SEC)
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	}
+}
+
+// POST /login
+func FrontLogin(app *App) func(c echo.Context) error {
+	returnURL := app.FrontEndURL + "/drasl/profile"
+	return func(c echo.Context) error {
+		failureURL := getReturnURL(app, &c)
+
 		username := c.FormValue("username")
-		honeypot := c.FormValue("email")
 		password := c.FormValue("password")
-		chosenUUID := c.FormValue("uuid")
-		existingPlayer := c.FormValue("existingPlayer") == "on"
-		challengeToken := c.FormValue("challengeToken")
-		inviteCode := c.FormValue("inviteCode")
 
-		failureURL := getReturnURL(app, &c)
-		noInviteFailureURL, err := StripQueryParam(failureURL, "invite")
+		if TransientLoginEligible(app, username) {
+			setErrorMessage(app, &c, "Transient accounts cannot access the web interface.")
+			return c.Redirect(http.StatusSeeOther, failureURL)
+		}
+
+		var user User
+		err := FindUserByUsernameOrPlayerName(app.DB, &user, username)
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "User not found!")
+				return c.Redirect(http.StatusSeeOther, failureURL)
+			}
 			return err
 		}
 
-		if honeypot != "" {
-			setErrorMessage(&c, "You are now covered in bee stings.")
+		if user.IsLocked {
+			setErrorMessage(app, &c, LockedMessage(&user, time.Now()))
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
 
-		if err := ValidateUsername(app, username); err != nil {
-			setErrorMessage(&c, fmt.Sprintf("Invalid username: %s", err))
+		passwordOK, err := app.CheckPassword(&user, password)
+		if err != nil {
+			return err
+		}
+
+		if !passwordOK {
+			setErrorMessage(app, &c, "Incorrect password!")
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
-		if err := ValidatePassword(app, password); err != nil {
-			setErrorMessage(&c, fmt.Sprintf("Invalid password: %s", err))
+
+		if app.Config().Maintenance.Enable && !user.IsAdmin {
+			setErrorMessage(app, &c, "Only admins can log in during maintenance.")
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
 
-		var accountUUID string
-		var invite Invite
-		inviteUsed := false
-		if existingPlayer {
-			// Registration from an existing account on another server
-			if !app.Config.RegistrationExistingPlayer.Allow {
-				setErrorMessage(&c, "Registration from an existing account is not allowed.")
-				return c.Redirect(http.StatusSeeOther, failureURL)
-			}
+		browserToken, err := RandomHex(32)
+		if err != nil {
+			return err
+		}
 
-			if app.Config.RegistrationExistingPlayer.RequireInvite {
-				result := app.DB.First(&invite, "code = ?", inviteCode)
-				if result.Error != nil {
-					if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-						setErrorMessage(&c, "Invite not found!")
-						return c.Redirect(http.StatusSeeOther, noInviteFailureURL)
-					}
-					return result.Error
-				}
-				inviteUsed = true
-			}
+		setCookie(app, &c, "browserToken", browserToken, BROWSER_TOKEN_AGE_SEC)
+
+		user.BrowserToken = MakeNullString(&browserToken)
+		app.DB.Save(&user)
+
+		if !markKnownDevice(app, &c, &user) {
+			app.NotifySecurityEvent(&user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		}
+		app.PublishEvent(MakeEvent(EventWebLogin, &user, c.RealIP()))
+
+		// Pages that ask the user to log in, like /drasl/device, can send
+		// them back afterwards
+		if destination := c.FormValue("destination"); isLocalURL(destination) {
+			return c.Redirect(http.StatusSeeOther, destination)
+		}
+
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	}
+}
+
+// Whether u is a path on this server, so that redirecting to it can't send
+// the user elsewhere
+func isLocalURL(u string) bool {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.Contains(u, "\\") {
+		return false
+	}
+	parsed, err := url.Parse(u)
+	return err == nil && parsed.Scheme == "" && parsed.Host == ""
+}
+
+// GET /drasl/device
+func FrontDevice(app *App) func(c echo.Context) error {
+	type deviceContext struct {
+		App                 *App
+		User                *User
+		URL                 string
+		SuccessMessage      string
+		WarningMessage      string
+		ErrorMessage        string
+		UserCode            string
+		DeviceAuthorization *DeviceAuthorization
+	}
+
+	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
+		errorMessage := lastErrorMessage(app, &c)
+		if !app.Config().DeviceLogin.Allow {
+			setErrorMessage(app, &c, "Device login is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+		}
 
-			// Verify skin challenge
-			details, err := validateChallenge(app, username, challengeToken)
+		userCode := NormalizeDeviceUserCode(c.QueryParam("code"))
+		var deviceAuthorization *DeviceAuthorization
+		if user != nil && userCode != "" {
+			var err error
+			deviceAuthorization, err = app.GetPendingDeviceAuthorization(userCode)
 			if err != nil {
-				var message string
-				if app.Config.RegistrationExistingPlayer.RequireSkinVerification {
-					message = fmt.Sprintf("Couldn't verify your skin, maybe try again: %s", err)
-				} else {
-					message = fmt.Sprintf("Couldn't find your account, maybe try again: %s", err)
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
 				}
-				setErrorMessage(&c, message)
-				return c.Redirect(http.StatusSeeOther, failureURL)
-			}
-			username = details.Username
-			if err := ValidateUsername(app, username); err != nil {
-				setErrorMessage(&c, fmt.Sprintf("Invalid username: %s", err))
-				return c.Redirect(http.StatusSeeOther, failureURL)
-			}
-			accountUUID = details.UUID
-		} else {
-			// New player registration
-			if !app.Config.RegistrationNewPlayer.Allow {
-				setErrorMessage(&c, "Registration without some existing account is not allowed.")
-				return c.Redirect(http.StatusSeeOther, failureURL)
+				errorMessage = "That code is invalid or has expired. Check the code shown on your device."
 			}
+		}
 
-			if app.Config.RegistrationNewPlayer.RequireInvite {
-				result := app.DB.First(&invite, "code = ?", inviteCode)
-				if result.Error != nil {
-					if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-						setErrorMessage(&c, "Invite not found!")
-						return c.Redirect(http.StatusSeeOther, noInviteFailureURL)
-					}
-					return result.Error
-				}
-				inviteUsed = true
-			}
+		return c.Render(http.StatusOK, "device", deviceContext{
+			App:                 app,
+			User:                user,
+			URL:                 c.Request().URL.RequestURI(),
+			SuccessMessage:      lastSuccessMessage(app, &c),
+			WarningMessage:      lastWarningMessage(app, &c),
+			ErrorMessage:        errorMessage,
+			UserCode:            userCode,
+			DeviceAuthorization: deviceAuthorization,
+		})
+	})
+}
 
-			if chosenUUID == "" {
-				accountUUID = uuid.New().String()
-			} else {
-				if !app.Config.RegistrationNewPlayer.AllowChoosingUUID {
-					setErrorMessage(&c, "Choosing a UUID is not allowed.")
-					return c.Redirect(http.StatusSeeOther, failureURL)
-				}
-				chosenUUIDStruct, err := uuid.Parse(chosenUUID)
-				if err != nil {
-					message := fmt.Sprintf("Invalid UUID: %s", err)
-					setErrorMessage(&c, message)
-					return c.Redirect(http.StatusSeeOther, failureURL)
-				}
-				accountUUID = chosenUUIDStruct.String()
-			}
+// POST /drasl/device/approve
+func FrontApproveDevice(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().DeviceLogin.Allow {
+			setErrorMessage(app, &c, "Device login is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't sign in a device while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
 		}
 
-		passwordSalt := make([]byte, 16)
-		_, err = rand.Read(passwordSalt)
+		deviceAuthorization, err := app.GetPendingDeviceAuthorization(c.FormValue("userCode"))
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "That code is invalid or has expired.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
 			return err
 		}
 
-		passwordHash, err := HashPassword(password, passwordSalt)
-		if err != nil {
+		deviceAuthorization.UserUUID = MakeNullString(&user.UUID)
+		if err := app.DB.Save(deviceAuthorization).Error; err != nil {
 			return err
 		}
 
-		browserToken, err := RandomHex(32)
+		setSuccessMessage(app, &c, "Device signed in. You can return to it now.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// POST /drasl/device/deny
+func FrontDenyDevice(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		deviceAuthorization, err := app.GetPendingDeviceAuthorization(c.FormValue("userCode"))
 		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				setErrorMessage(app, &c, "That code is invalid or has expired.")
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
 			return err
 		}
 
-		offlineUUID, err := OfflineUUID(username)
-		if err != nil {
+		deviceAuthorization.Denied = true
+		if err := app.DB.Save(deviceAuthorization).Error; err != nil {
 			return err
 		}
 
-		user := User{
-			IsAdmin:           Contains(app.Config.DefaultAdmins, username),
-			UUID:              accountUUID,
-			Username:          username,
-			PasswordSalt:      passwordSalt,
-			PasswordHash:      passwordHash,
-			Clients:           []Client{},
-			PlayerName:        username,
-			OfflineUUID:       offlineUUID,
-			FallbackPlayer:    accountUUID,
-			PreferredLanguage: app.Config.DefaultPreferredLanguage,
-			SkinModel:         SkinModelClassic,
-			BrowserToken:      MakeNullString(&browserToken),
-			CreatedAt:         time.Now(),
-			NameLastChangedAt: time.Now(),
-		}
+		setSuccessMessage(app, &c, "Request denied.")
+		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
 
-		tx := app.DB.Begin()
-		defer tx.Rollback()
+// POST /drasl/qr-login
+// Show a QR code that signs another device in as the current user
+func FrontQRLogin(app *App) func(c echo.Context) error {
+	type qrLoginContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		QRLogin        *QRLogin
+		ClaimURL       string
+		QRCodeBase64   string
+	}
 
-		result := tx.Create(&user)
-		if result.Error != nil {
-			if IsErrorUniqueFailedField(result.Error, "users.username") ||
-				IsErrorUniqueFailedField(result.Error, "users.player_name") {
-				setErrorMessage(&c, "That username is taken.")
-				return c.Redirect(http.StatusSeeOther, failureURL)
-			} else if IsErrorUniqueFailedField(result.Error, "users.uuid") {
-				setErrorMessage(&c, "That UUID is taken.")
-				return c.Redirect(http.StatusSeeOther, failureURL)
-			}
-			return result.Error
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		if !app.Config().QRLogin.Allow {
+			setErrorMessage(app, &c, "QR code login is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't sign in another device while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
 		}
 
-		if inviteUsed {
-			result = tx.Delete(&invite)
-			if result.Error != nil {
-				return result.Error
-			}
+		qrLogin, err := app.CreateQRLogin(user)
+		if err != nil {
+			return err
+		}
+		claimURL, err := QRLoginURL(app, qrLogin)
+		if err != nil {
+			return err
+		}
+		qrCode, err := MakeQRCode(claimURL)
+		if err != nil {
+			return err
 		}
 
-		result = tx.Commit()
+		return c.Render(http.StatusOK, "qr-login", qrLoginContext{
+			App:            app,
+			User:           user,
+			URL:            returnURL,
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			QRLogin:        qrLogin,
+			ClaimURL:       claimURL,
+			QRCodeBase64:   base64.StdEncoding.EncodeToString(qrCode),
+		})
+	})
+}
+
+// POST /drasl/qr-login/cancel
+func FrontCancelQRLogin(app *App) func(c echo.Context) error {
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		returnURL := getReturnURL(app, &c)
+
+		result := app.DB.Where("token = ? AND user_uuid = ?", c.FormValue("token"), user.UUID).Delete(&QRLogin{})
 		if result.Error != nil {
 			return result.Error
 		}
 
-		c.SetCookie(&http.Cookie{
-			Name:     "browserToken",
-			Value:    browserToken,
-			MaxAge:   BROWSER_TOKEN_AGE_SEC,
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-			HttpOnly: true,
-		})
-
+		setSuccessMessage(app, &c, "QR code cancelled.")
 		return c.Redirect(http.StatusSeeOther, returnURL)
+	})
+}
+
+// GET /drasl/qr-login/claim
+// Opened on the second device by scanning the QR code. Nothing happens until
+// the user confirms.
+func FrontQRLoginClaimConfirmation(app *App) func(c echo.Context) error {
+	type qrLoginClaimContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		TargetUser     *User
+		QRLogin        *QRLogin
 	}
+
+	return withBrowserAuthentication(app, false, func(c echo.Context, user *User) error {
+		if !app.Config().QRLogin.Allow {
+			setErrorMessage(app, &c, "QR code login is not allowed on this server.")
+			return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+		}
+
+		qrLogin, targetUser, err := app.GetQRLogin(c.QueryParam("token"))
+		if err != nil {
+			if errors.Is(err, errQRLoginNotFound) {
+				setErrorMessage(app, &c, "That QR code is invalid or has expired.")
+				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+			}
+			return err
+		}
+
+		return c.Render(http.StatusOK, "qr-login-claim", qrLoginClaimContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			TargetUser:     targetUser,
+			QRLogin:        qrLogin,
+		})
+	})
 }
 
-// POST /login
-func FrontLogin(app *App) func(c echo.Context) error {
+// POST /drasl/qr-login/claim
+func FrontQRLoginClaim(app *App) func(c echo.Context) error {
 	returnURL := app.FrontEndURL + "/drasl/profile"
 	return func(c echo.Context) error {
-		failureURL := getReturnURL(app, &c)
-
-		username := c.FormValue("username")
-		password := c.FormValue("password")
+		failureURL := app.FrontEndURL
 
-		if TransientLoginEligible(app, username) {
-			setErrorMessage(&c, "Transient accounts cannot access the web interface.")
+		if !app.Config().QRLogin.Allow {
+			setErrorMessage(app, &c, "QR code login is not allowed on this server.")
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
 
-		var user User
-		result := app.DB.First(&user, "username = ?", username)
-		if result.Error != nil {
-			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-				setErrorMessage(&c, "User not found!")
+		user, err := app.ClaimQRLogin(c.FormValue("token"))
+		if err != nil {
+			if errors.Is(err, errQRLoginNotFound) {
+				setErrorMessage(app, &c, "That QR code is invalid or has expired.")
 				return c.Redirect(http.StatusSeeOther, failureURL)
 			}
-			return result.Error
+			return err
 		}
 
 		if user.IsLocked {
-			setErrorMessage(&c, "Account is locked.")
+			setErrorMessage(app, &c, LockedMessage(user, time.Now()))
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
-
-		passwordHash, err := HashPassword(password, user.PasswordSalt)
-		if err != nil {
-			return err
-		}
-
-		if !bytes.Equal(passwordHash, user.PasswordHash) {
-			setErrorMessage(&c, "Incorrect password!")
+		if app.Config().Maintenance.Enable && !user.IsAdmin {
+			setErrorMessage(app, &c, "Only admins can log in during maintenance.")
 			return c.Redirect(http.StatusSeeOther, failureURL)
 		}
 
-		browserToken, err := RandomHex(32)
-		if err != nil {
-			return err
+		// Share the browser session that showed the QR code; starting a new
+		// one would sign that browser out
+		var browserToken string
+		if user.BrowserToken.Valid {
+			browserToken = user.BrowserToken.String
+		} else {
+			browserToken, err = RandomHex(32)
+			if err != nil {
+				return err
+			}
+			user.BrowserToken = MakeNullString(&browserToken)
+			if err := app.DB.Save(user).Error; err != nil {
+				return err
+			}
 		}
 
-		c.SetCookie(&http.Cookie{
-			Name:     "browserToken",
-			Value:    browserToken,
-			MaxAge:   BROWSER_TOKEN_AGE_SEC,
-			Path:     "/",
-			SameSite: http.SameSiteStrictMode,
-			HttpOnly: true,
-		})
+		setCookie(app, &c, "browserToken", browserToken, BROWSER_TOKEN_AGE_SEC)
 
-		user.BrowserToken = MakeNullString(&browserToken)
-		app.DB.Save(&user)
+		if !markKnownDevice(app, &c, user) {
+			app.NotifySecurityEvent(user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		}
 
+		setSuccessMessage(app, &c, fmt.Sprintf("Signed in as %s.", user.Username))
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	}
 }
 
-// POST /delete-user
+// GET /drasl/delete-user
+func FrontDeleteUserConfirmation(app *App) func(c echo.Context) error {
+	type deleteUserContext struct {
+		App            *App
+		User           *User
+		URL            string
+		SuccessMessage string
+		WarningMessage string
+		ErrorMessage   string
+		TargetUser     *User
+		AdminView      bool
+	}
+
+	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
+		var targetUser *User
+		targetUsername := c.QueryParam("user")
+		adminView := false
+		if targetUsername == "" || targetUsername == user.Username {
+			targetUser = user
+		} else {
+			if !user.IsAdmin {
+				setErrorMessage(app, &c, "You are not an admin.")
+				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
+			}
+			var targetUserStruct User
+			result := app.DB.First(&targetUserStruct, "username = ?", targetUsername)
+			targetUser = &targetUserStruct
+			if result.Error != nil {
+				setErrorMessage(app, &c, "User not found.")
+				returnURL, err := url.JoinPath(app.FrontEndURL, "drasl/admin")
+				if err != nil {
+					return err
+				}
+				return c.Redirect(http.StatusSeeOther, returnURL)
+			}
+			adminView = true
+		}
+
+		return c.Render(http.StatusOK, "delete-user", deleteUserContext{
+			App:            app,
+			User:           user,
+			URL:            c.Request().URL.RequestURI(),
+			SuccessMessage: lastSuccessMessage(app, &c),
+			WarningMessage: lastWarningMessage(app, &c),
+			ErrorMessage:   lastErrorMessage(app, &c),
+			TargetUser:     targetUser,
+			AdminView:      adminView,
+		})
+	})
+}
+
+// POST /drasl/delete-user
 func FrontDeleteUser(app *App) func(c echo.Context) error {
 	return withBrowserAuthentication(app, true, func(c echo.Context, user *User) error {
 		returnURL := getReturnURL(app, &c)
 
+		if user.ImpersonatedBy != nil {
+			setErrorMessage(app, &c, "You can't do that while signed in as another user.")
+			return c.Redirect(http.StatusSeeOther, returnURL)
+		}
+
 		var targetUser *User
 		targetUsername := c.FormValue("username")
 		if targetUsername == "" || targetUsername == user.Username {
 			targetUser = user
 		} else {
 			if !user.IsAdmin {
-				setErrorMessage(&c, "You are not an admin.")
+				setErrorMessage(app, &c, "You are not an admin.")
 				return c.Redirect(http.StatusSeeOther, app.FrontEndURL)
 			}
 			var targetUserStruct User
 			result := app.DB.First(&targetUserStruct, "username = ?", targetUsername)
 			targetUser = &targetUserStruct
 			if result.Error != nil {
-				setErrorMessage(&c, "User not found.")
+				setErrorMessage(app, &c, "User not found.")
 				return c.Redirect(http.StatusSeeOther, returnURL)
 			}
 		}
 
-		DeleteUser(app, targetUser)
+		// On failure, go back to the confirmation page
+		failureURL, err := url.JoinPath(app.FrontEndURL, "drasl/delete-user")
+		if err != nil {
+			return err
+		}
+		failureURL += "?user=" + url.QueryEscape(targetUser.Username)
+
+		// The user doing the deleting must re-enter their own password, even
+		// if they are an admin deleting someone else's account
+		password := c.FormValue("password")
+		passwordOK, err := app.CheckPassword(user, password)
+		if err != nil {
+			return err
+		}
+		if !passwordOK {
+			setErrorMessage(app, &c, "Incorrect password!")
+			return c.Redirect(http.StatusSeeOther, failureURL)
+		}
+
+		if c.FormValue("confirmUsername") != targetUser.Username {
+			setErrorMessage(app, &c, "Username confirmation doesn't match.")
+			return c.Redirect(http.StatusSeeOther, failureURL)
+		}
+
+		if targetUser != user && app.Config().TwoPersonApproval.Enable {
+			return requestAdminAction(app, &c, user, AdminActionDeleteUser, targetUser.Username, targetUser.UUID, returnURL)
+		}
+
+		err = DeleteUser(app, targetUser)
+		if err != nil {
+			return err
+		}
 
 		if targetUser == user {
-			c.SetCookie(&http.Cookie{
-				Name:     "browserToken",
-				Value:    "",
-				MaxAge:   -1,
-				Path:     "/",
-				SameSite: http.SameSiteStrictMode,
-				HttpOnly: true,
-			})
+			setCookie(app, &c, "browserToken", "", -1)
 		}
-		setSuccessMessage(&c, "Account deleted")
+		setSuccessMessage(app, &c, "Account deleted")
 
 		return c.Redirect(http.StatusSeeOther, returnURL)
 	})
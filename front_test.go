@@ -3,19 +3,23 @@ package main
 import (
 	"bytes"
 	"encoding/base64"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 	"html"
-	"lukechampine.com/blake3"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var FAKE_BROWSER_TOKEN = "deadbeef"
@@ -36,6 +40,7 @@ func setupRegistrationExistingPlayerTS(requireSkinVerification bool, requireInvi
 		SessionURL:              ts.AuxApp.SessionURL,
 		AccountURL:              ts.AuxApp.AccountURL,
 		RequireSkinVerification: requireSkinVerification,
+		ChallengeExpireSec:      DefaultConfig().RegistrationExistingPlayer.ChallengeExpireSec,
 		RequireInvite:           requireInvite,
 	}
 	config.FallbackAPIServers = []FallbackAPIServer{
@@ -75,6 +80,28 @@ func (ts *TestSuite) testPublic(t *testing.T) {
 	ts.testStatusOK(t, "/drasl/public/icon.png")
 }
 
+func (ts *TestSuite) testSecurityHeaders(t *testing.T) {
+	for _, path := range []string{"/", "/drasl/public/style.css", "/drasl/texture/skin/nonexistent.png"} {
+		rec := ts.Get(t, ts.Server, path, nil, nil)
+		assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+		assert.Equal(t, DEFAULT_CONTENT_SECURITY_POLICY+"; frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"))
+		assert.Equal(t, "DENY", rec.Header().Get("X-Frame-Options"))
+		assert.Equal(t, "same-origin", rec.Header().Get("Referrer-Policy"))
+		assert.Equal(t, "", rec.Header().Get("Strict-Transport-Security"))
+	}
+
+	// The Yggdrasil API doesn't need them
+	rec := ts.Get(t, ts.Server, "/authlib-injector", nil, nil)
+	assert.Equal(t, "", rec.Header().Get("Content-Security-Policy"))
+}
+
+func (ts *TestSuite) testSecurityHeadersReportOnly(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/", nil, nil)
+	assert.Equal(t, DEFAULT_CONTENT_SECURITY_POLICY, rec.Header().Get("Content-Security-Policy-Report-Only"))
+	assert.Equal(t, "frame-ancestors 'none'", rec.Header().Get("Content-Security-Policy"))
+	assert.Equal(t, "max-age=31536000; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+}
+
 func getErrorMessage(rec *httptest.ResponseRecorder) string {
 	return Unwrap(url.QueryUnescape(getCookie(rec, "errorMessage").Value))
 }
@@ -132,12 +159,17 @@ func TestFront(t *testing.T) {
 
 		t.Run("Test public pages and assets", ts.testPublic)
 		t.Run("Test web app manifest", ts.testWebManifest)
+		t.Run("Test security headers", ts.testSecurityHeaders)
 		t.Run("Test registration as new player", ts.testRegistrationNewPlayer)
 		t.Run("Test registration as new player, chosen UUID, chosen UUID not allowed", ts.testRegistrationNewPlayerChosenUUIDNotAllowed)
 		t.Run("Test profile update", ts.testUpdate)
+		t.Run("Test profile update is all or nothing", ts.testUpdateAllOrNothing)
+		t.Run("Test password change", ts.testChangePassword)
 		t.Run("Test creating/deleting invites", ts.testNewInviteDeleteInvite)
 		t.Run("Test login, logout", ts.testLoginLogout)
+		t.Run("Test managing game clients", ts.testClients)
 		t.Run("Test delete account", ts.testDeleteAccount)
+		t.Run("Test delete account cleanup", ts.testDeleteAccountCleanup)
 	}
 	{
 		ts := &TestSuite{}
@@ -157,6 +189,79 @@ func TestFront(t *testing.T) {
 		ts.Setup(config)
 		defer ts.Teardown()
 		t.Run("Test admin", ts.testAdmin)
+		t.Run("Test announcement", ts.testAnnouncement)
+		t.Run("Test impersonation", ts.testImpersonation)
+		t.Run("Test groups", ts.testGroups)
+		t.Run("Test gift codes", ts.testGiftCodes)
+		t.Run("Test statistics", ts.testStats)
+		t.Run("Test settings", ts.testSettings)
+	}
+	{
+		// Theme
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Theme = "test-theme"
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test theme overrides", ts.testTheme)
+	}
+	{
+		// Email enabled
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Email.Enable = true
+		config.Email.SMTPHost = "localhost"
+		config.Email.From = "drasl@example.com"
+		config.Email.MessagesPerSecond = 1000
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test email", ts.testEmail)
+		t.Run("Test security notifications", ts.testSecurityNotifications)
+	}
+	{
+		// QR code login
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.QRLogin.Allow = true
+		config.QRLogin.ExpireSec = DefaultConfig().QRLogin.ExpireSec
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test QR code login", ts.testQRLogin)
+	}
+	{
+		// Maintenance mode
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Maintenance.Enable = true
+		config.DefaultAdmins = []string{"maintenanceAdmin"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test maintenance mode", ts.testMaintenance)
+	}
+	{
+		// Read-only mode
+		ts := &TestSuite{}
+
+		sd := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(sd)
+		config := configTestConfig(sd)
+		config.ReadOnly.Enable = true
+		// The default message should be enough
+		if err := CleanConfig(config); err != nil {
+			t.Fatal(err)
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test read-only mode", ts.testReadOnly)
 	}
 	{
 		// Choosing UUID allowed
@@ -174,14 +279,14 @@ func TestFront(t *testing.T) {
 		ts := &TestSuite{}
 
 		config := testConfig()
-		config.RateLimit = rateLimitConfig{
-			Enable:            true,
-			RequestsPerSecond: 2,
-		}
+		config.RateLimit.Enable = true
+		config.RateLimit.RequestsPerSecond = 2
+		config.RateLimit.ExemptCIDRs = []string{"198.51.100.0/24"}
 		ts.Setup(config)
 		defer ts.Teardown()
 
 		t.Run("Test rate limiting", ts.testRateLimit)
+		t.Run("Test rate limiting IPv6 subnets and exempt ranges", ts.testRateLimitBuckets)
 	}
 	{
 		// Low body limit
@@ -211,6 +316,117 @@ func TestFront(t *testing.T) {
 
 		t.Run("Test registration as existing player, with skin verification", ts.testRegistrationExistingPlayerWithVerification)
 	}
+	{
+		// Approval required, new player
+		ts := &TestSuite{}
+
+		webhookRequests := make(chan pendingApprovalWebhookPayload, 4)
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload pendingApprovalWebhookPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+				webhookRequests <- payload
+			}
+		}))
+		defer webhook.Close()
+
+		config := testConfig()
+		config.RegistrationNewPlayer.RequireApproval = true
+		config.RegistrationApprovalWebhook = webhook.URL
+		config.DefaultAdmins = []string{"approvalAdmin"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test registration as new player, approval required", func(t *testing.T) {
+			ts.testRegistrationNewPlayerApproval(t, webhookRequests)
+		})
+	}
+	{
+		// Registration restrictions
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.RegistrationRestrictions = registrationRestrictionsConfig{
+			AllowedEmailDomains: []string{"example.com"},
+			DeniedEmailDomains:  []string{"banned.example.com"},
+			DenyDisposableEmail: true,
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test registration restrictions", ts.testRegistrationRestrictions)
+	}
+	{
+		// Report-only Content Security Policy, HSTS
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.SecurityHeaders.ReportOnly = true
+		config.SecurityHeaders.HSTSMaxAgeSec = 31536000
+		config.SecurityHeaders.HSTSIncludeSubdomains = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test report-only security headers", ts.testSecurityHeadersReportOnly)
+	}
+	{
+		// Plain HTTP, BaseURL with a path
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.BaseURL = "http://drasl.example.com/prefix"
+		config.SecureCookies = false
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test cookie attributes", ts.testCookieAttributes)
+	}
+	{
+		// Admin restrictions behind a trusted proxy
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.AdminRestrictions = adminRestrictionsConfig{
+			AllowedCIDRs: []string{"198.51.100.0/24"},
+			DeniedCIDRs:  []string{"198.51.100.13/32"},
+		}
+		// httptest requests come from 192.0.2.1
+		config.TrustedProxies = []string{"192.0.2.1/32"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test admin restrictions", ts.testAdminRestrictions)
+	}
+	{
+		// Registration as existing player allowed, several sources
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		config.RegistrationNewPlayer.Allow = false
+		config.RegistrationExistingPlayer = registrationExistingPlayerConfig{
+			Allow: true,
+			Sources: []registrationExistingPlayerSource{
+				{
+					Nickname:   "Unreachable",
+					SessionURL: "https://unreachable.invalid",
+					AccountURL: "https://unreachable.invalid",
+				},
+				{
+					Nickname:   "Aux",
+					SessionURL: ts.AuxApp.SessionURL,
+					AccountURL: ts.AuxApp.AccountURL,
+				},
+			},
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.AuxServer, EXISTING_USERNAME)
+
+		t.Run("Test registration as existing player, several sources", ts.testRegistrationExistingPlayerSources)
+	}
 	{
 		// Invite required, new player
 		ts := &TestSuite{}
@@ -229,6 +445,176 @@ func TestFront(t *testing.T) {
 
 		t.Run("Test registration as existing player, with skin verification, invite only", ts.testRegistrationExistingPlayerInvite)
 	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.TextureQueue.Enable = true
+		config.TextureQueue.Workers = 2
+		config.TextureQueue.QueueSize = 8
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test profile update with the texture queue", ts.testUpdateTextureQueue)
+	}
+}
+
+func (ts *TestSuite) testTheme(t *testing.T) {
+	themeDirectory := GetThemeDirectory(ts.App.Config())
+	assert.Nil(t, os.MkdirAll(path.Join(themeDirectory, "view"), 0700))
+	assert.Nil(t, os.MkdirAll(path.Join(themeDirectory, "public"), 0700))
+
+	footer := `{{ define "footer" }}<small>Themed footer</small>{{ end }}`
+	assert.Nil(t, os.WriteFile(path.Join(themeDirectory, "view", "footer.tmpl"), []byte(footer), 0600))
+	style := "body { background: purple; }"
+	assert.Nil(t, os.WriteFile(path.Join(themeDirectory, "public", "style.css"), []byte(style), 0600))
+
+	// Templates are parsed at startup
+	ts.Server.Renderer = NewTemplate(ts.App)
+
+	rec := ts.Get(t, ts.Server, "/", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Themed footer")
+
+	// Themed static assets should override the defaults
+	rec = ts.Get(t, ts.Server, "/drasl/public/style.css", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, style, rec.Body.String())
+
+	// Anything the theme doesn't provide should fall back to the defaults
+	rec = ts.Get(t, ts.Server, "/drasl/public/logo.svg", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = ts.Get(t, ts.Server, "/drasl/public/nonexistent.css", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func (ts *TestSuite) testMaintenance(t *testing.T) {
+	adminUsername := "maintenanceAdmin"
+	username := "maintenanceUser"
+
+	// Registration is blocked, so create the users directly
+	ts.InsertTestUser(ts.App, adminUsername)
+	ts.InsertTestUser(ts.App, username)
+
+	// Front end should show the maintenance page
+	rec := ts.Get(t, ts.Server, "/", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), html.EscapeString(ts.App.Config().Maintenance.Message))
+	rec = ts.Get(t, ts.Server, "/drasl/registration", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// Static assets should still be served
+	rec = ts.Get(t, ts.Server, "/drasl/public/style.css", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Registration should be blocked
+	form := url.Values{}
+	form.Set("username", "maintenanceNew")
+	form.Set("password", TEST_PASSWORD)
+	rec = ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&User{}).Where("username = ?", "maintenanceNew").Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	// Yggdrasil routes should return an error
+	payload := authenticateRequest{
+		Username: username,
+		Password: TEST_PASSWORD,
+	}
+	rec = ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var errorResponse ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, ts.App.Config().Maintenance.Message, *errorResponse.ErrorMessage)
+
+	// Non-admins can't log in
+	form = url.Values{}
+	form.Set("username", username)
+	form.Set("password", TEST_PASSWORD)
+	rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	ts.loginShouldFail(t, rec, "Only admins can log in during maintenance.")
+
+	// Admins can log in and use the front end
+	form = url.Values{}
+	form.Set("username", adminUsername)
+	form.Set("password", TEST_PASSWORD)
+	rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	ts.loginShouldSucceed(t, rec)
+	browserTokenCookie := getCookie(rec, "browserToken")
+
+	rec = ts.Get(t, ts.Server, "/drasl/admin", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func (ts *TestSuite) testReadOnly(t *testing.T) {
+	username := "readOnly"
+	user := ts.InsertTestUser(ts.App, username)
+
+	// Logging in should still work
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", TEST_PASSWORD)
+	rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	ts.loginShouldSucceed(t, rec)
+	browserTokenCookie := getCookie(rec, "browserToken")
+
+	assert.Equal(t, "This server is in read-only mode. Changes can't be saved right now.", ts.App.Config().ReadOnly.Message)
+
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), html.EscapeString(ts.App.Config().ReadOnly.Message))
+
+	// Registration should be rejected
+	form = url.Values{}
+	form.Set("username", "readOnlyNew")
+	form.Set("password", TEST_PASSWORD)
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
+	rec = ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	ts.registrationShouldFail(t, rec, ts.App.Config().ReadOnly.Message, ts.App.FrontEndURL+"/drasl/registration")
+
+	// Profile updates should be rejected
+	form = url.Values{}
+	form.Set("playerName", "readOnlyRenamed")
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec = ts.PostForm(t, ts.Server, "/drasl/update", form, []http.Cookie{*browserTokenCookie}, nil)
+	ts.updateShouldFail(t, rec, ts.App.Config().ReadOnly.Message, ts.App.FrontEndURL+"/drasl/profile")
+	assert.Nil(t, ts.App.DB.First(user, "uuid = ?", user.UUID).Error)
+	assert.Equal(t, username, user.PlayerName)
+
+	// Yggdrasil authentication should still work
+	payload := authenticateRequest{
+		Username: username,
+		Password: TEST_PASSWORD,
+	}
+	rec = ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var authenticateRes authenticateResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&authenticateRes))
+
+	// But changing the player name through the services API should not
+	req := httptest.NewRequest(http.MethodPut, "/minecraft/profile/name/readOnlyRenamed", nil)
+	req.Header.Add("Authorization", "Bearer "+authenticateRes.AccessToken)
+	rec = httptest.NewRecorder()
+	ts.Server.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	var errorResponse ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, ts.App.Config().ReadOnly.Message, *errorResponse.ErrorMessage)
+
+	// Nor should confirming an in-game command, which can set a new password
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands/confirm", apiServerConfirmCommandRequest{UUID: user.UUID}, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, ts.App.Config().ReadOnly.Message, *errorResponse.ErrorMessage)
+
+	// Nor should linking a Bedrock account
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/bedrock-link", apiBedrockLinkRequest{Code: "ABCDEF", XUID: "1", Gamertag: "readOnly"}, nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, ts.App.Config().ReadOnly.Message, *errorResponse.ErrorMessage)
 }
 
 func (ts *TestSuite) testRateLimit(t *testing.T) {
@@ -254,6 +640,34 @@ func (ts *TestSuite) testRateLimit(t *testing.T) {
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
 
+func (ts *TestSuite) testRateLimitBuckets(t *testing.T) {
+	login := func(ip string) string {
+		form := url.Values{}
+		form.Set("username", "")
+		form.Set("password", "")
+		req := httptest.NewRequest(http.MethodPost, "/drasl/login", strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Real-IP", ip)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		return getErrorMessage(rec)
+	}
+
+	// Addresses in the same /64 share a bucket
+	assert.Equal(t, "User not found!", login("2001:db8:1:2::1"))
+	assert.Equal(t, "User not found!", login("2001:db8:1:2::2"))
+	assert.Equal(t, "Too many requests. Try again later.", login("2001:db8:1:2:ffff::3"))
+	assert.Equal(t, "User not found!", login("2001:db8:1:3::1"))
+
+	assert.Equal(t, "2001:db8:1:2::/64", RateLimitKey(ts.App, "2001:db8:1:2:abcd::1"))
+	assert.Equal(t, "203.0.113.7", RateLimitKey(ts.App, "203.0.113.7"))
+
+	// Exempt ranges are never limited
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, "User not found!", login("198.51.100.7"))
+	}
+}
+
 func (ts *TestSuite) testBodyLimit(t *testing.T) {
 	form := url.Values{}
 	form.Set("bogus", Unwrap(RandomHex(2048)))
@@ -396,7 +810,7 @@ func (ts *TestSuite) testRegistrationNewPlayerChosenUUIDNotAllowed(t *testing.T)
 
 	uuid := "11111111-2222-3333-4444-555555555555"
 
-	ts.App.Config.RegistrationNewPlayer.AllowChoosingUUID = false
+	ts.App.Config().RegistrationNewPlayer.AllowChoosingUUID = false
 
 	returnURL := ts.App.FrontEndURL + "/drasl/registration"
 	form := url.Values{}
@@ -516,10 +930,14 @@ func (ts *TestSuite) testRegistrationNewPlayerInvite(t *testing.T) {
 }
 
 func (ts *TestSuite) solveSkinChallenge(t *testing.T, username string) *http.Cookie {
+	return ts.solveSkinChallengeAt(t, "/drasl/challenge-skin?username="+username, username, nil)
+}
+
+// Solve the challenge on the page at `path`, e.g. with extra query
+// parameters, as the browser with `cookies`
+func (ts *TestSuite) solveSkinChallengeAt(t *testing.T, path string, username string, cookies []http.Cookie) *http.Cookie {
 	// Get challenge skin
-	req := httptest.NewRequest(http.MethodGet, "/drasl/challenge-skin?username="+username, nil)
-	rec := httptest.NewRecorder()
-	ts.Server.ServeHTTP(rec, req)
+	rec := ts.Get(t, ts.Server, path, cookies, nil)
 	assert.Equal(t, http.StatusOK, rec.Code)
 	challengeToken := getCookie(rec, "challengeToken")
 	assert.NotEqual(t, "", challengeToken.Value)
@@ -652,6 +1070,11 @@ func (ts *TestSuite) testLoginLogout(t *testing.T) {
 		rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
 		ts.loginShouldSucceed(t, rec)
 		browserTokenCookie := getCookie(rec, "browserToken")
+		assert.Equal(t, "/", browserTokenCookie.Path)
+		assert.Equal(t, BROWSER_TOKEN_AGE_SEC, browserTokenCookie.MaxAge)
+		assert.True(t, browserTokenCookie.Secure)
+		assert.True(t, browserTokenCookie.HttpOnly)
+		assert.Equal(t, http.SameSiteStrictMode, browserTokenCookie.SameSite)
 
 		// The BrowserToken we get should match the one in the database
 		var user User
@@ -675,6 +1098,29 @@ func (ts *TestSuite) testLoginLogout(t *testing.T) {
 		assert.Nil(t, result.Error)
 		assert.Nil(t, UnmakeNullString(&user.BrowserToken))
 	}
+	{
+		// Login should be case-insensitive
+		form := url.Values{}
+		form.Set("username", strings.ToUpper(username))
+		form.Set("password", TEST_PASSWORD)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
+		rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+		ts.loginShouldSucceed(t, rec)
+	}
+	{
+		// Login with the player name instead of the username should succeed
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		user.PlayerName = "loginLogoutPlayer"
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+		form := url.Values{}
+		form.Set("username", "loginLogoutPlayer")
+		form.Set("password", TEST_PASSWORD)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
+		rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+		ts.loginShouldSucceed(t, rec)
+	}
 	{
 		// Login with incorrect password should fail
 		form := url.Values{}
@@ -779,6 +1225,35 @@ func (ts *TestSuite) testRegistrationExistingPlayerWithVerification(t *testing.T
 	}
 	{
 		challengeToken := ts.solveSkinChallenge(t, username)
+		{
+			// The challenge page can check whether the skin is verified
+			rec := ts.Get(t, ts.Server, "/drasl/challenge-skin/status?username="+username, []http.Cookie{*challengeToken}, nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			var status challengeSkinStatusResponse
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+			assert.True(t, status.Verified)
+
+			wrongToken := http.Cookie{Name: "challengeToken", Value: "deadbeef." + strconv.FormatInt(time.Now().Unix(), 10)}
+			rec = ts.Get(t, ts.Server, "/drasl/challenge-skin/status?username="+username, []http.Cookie{wrongToken}, nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Nil(t, json.NewDecoder(rec.Body).Decode(&status))
+			assert.False(t, status.Verified)
+			assert.False(t, status.Expired)
+		}
+		{
+			// Registration should fail once the challenge has expired
+			ts.App.Config().RegistrationExistingPlayer.ChallengeExpireSec = -1
+			form := url.Values{}
+			form.Set("username", username)
+			form.Set("password", TEST_PASSWORD)
+			form.Set("existingPlayer", "on")
+			form.Set("challengeToken", challengeToken.Value)
+			form.Set("returnUrl", returnURL)
+			rec := ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+			ts.App.Config().RegistrationExistingPlayer.ChallengeExpireSec = DefaultConfig().RegistrationExistingPlayer.ChallengeExpireSec
+
+			ts.registrationShouldFail(t, rec, "Couldn't verify your skin, maybe try again: the verification skin has expired, please start over", returnURL)
+		}
 		{
 			// Registration should fail if we give the wrong challenge token
 			form := url.Values{}
@@ -865,11 +1340,8 @@ func (ts *TestSuite) testUpdate(t *testing.T) {
 	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
 	takenBrowserTokenCookie := ts.CreateTestUser(ts.Server, takenUsername)
 
-	sum := blake3.Sum256(RED_SKIN)
-	redSkinHash := hex.EncodeToString(sum[:])
-
-	sum = blake3.Sum256(RED_CAPE)
-	redCapeHash := hex.EncodeToString(sum[:])
+	redSkinHash := storedTextureHash(t, ts.App, ValidateSkin, RED_SKIN)
+	redCapeHash := storedTextureHash(t, ts.App, ValidateCape, RED_CAPE)
 
 	var user User
 	result := ts.App.DB.First(&user, "username = ?", username)
@@ -886,7 +1358,6 @@ func (ts *TestSuite) testUpdate(t *testing.T) {
 		writer.WriteField("playerName", "newTestUpdate")
 		writer.WriteField("fallbackPlayer", "newTestUpdate")
 		writer.WriteField("preferredLanguage", "es")
-		writer.WriteField("password", "newpassword")
 		writer.WriteField("skinModel", "slim")
 		skinFileField, err := writer.CreateFormFile("skinFile", "redSkin.png")
 		assert.Nil(t, err)
@@ -911,10 +1382,10 @@ func (ts *TestSuite) testUpdate(t *testing.T) {
 		assert.Equal(t, redSkinHash, *UnmakeNullString(&updatedUser.SkinHash))
 		assert.Equal(t, redCapeHash, *UnmakeNullString(&updatedUser.CapeHash))
 
-		// Make sure we can log in with the new password
+		// Make sure we can still log in
 		form := url.Values{}
 		form.Set("username", username)
-		form.Set("password", "newpassword")
+		form.Set("password", TEST_PASSWORD)
 		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
 		rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
 		ts.loginShouldSucceed(t, rec)
@@ -1005,65 +1476,336 @@ func (ts *TestSuite) testUpdate(t *testing.T) {
 		ts.updateShouldFail(t, rec, "That player name is taken.", ts.App.FrontEndURL+"/drasl/profile")
 	}
 	{
-		// Setting an invalid password should fail
+		// Non-admin should not be able to change their username
 		body := &bytes.Buffer{}
 		writer := multipart.NewWriter(body)
-		writer.WriteField("password", "short")
+		writer.WriteField("newUsername", "testUpdateRenamed")
 		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
 		assert.Nil(t, writer.Close())
-		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
-		ts.updateShouldFail(t, rec, "Invalid password: password must be longer than 8 characters", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*takenBrowserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Changing your username is not allowed.", ts.App.FrontEndURL+"/drasl/profile")
 	}
-}
-
-func (ts *TestSuite) testUpdateSkinsCapesNotAllowed(t *testing.T) {
-	username := "updateNoSkinCape"
-	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
 	{
+		// Changing to another user's username or player name should fail
 		body := &bytes.Buffer{}
 		writer := multipart.NewWriter(body)
+		writer.WriteField("newUsername", takenUsername)
 		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
-		writer.WriteField("skinModel", "classic")
-		skinFileField, err := writer.CreateFormFile("skinFile", "redSkin.png")
-		assert.Nil(t, err)
-		_, err = skinFileField.Write(RED_SKIN)
-		assert.Nil(t, err)
-
 		assert.Nil(t, writer.Close())
 		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
-		ts.updateShouldFail(t, rec, "Setting a skin is not allowed.", ts.App.FrontEndURL+"/drasl/profile")
-
-		// The user should not have a skin set
-		var user User
-		result := ts.App.DB.First(&user, "username = ?", username)
-		assert.Nil(t, result.Error)
-		assert.Nil(t, UnmakeNullString(&user.SkinHash))
+		ts.updateShouldFail(t, rec, "That username is taken.", ts.App.FrontEndURL+"/drasl/profile")
 	}
 	{
+		// Admin can change their username, and can then log in with it
+		newUsername := "testUpdateRenamed"
 		body := &bytes.Buffer{}
 		writer := multipart.NewWriter(body)
+		writer.WriteField("newUsername", newUsername)
 		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
-		capeFileField, err := writer.CreateFormFile("capeFile", "redCape.png")
-		assert.Nil(t, err)
-		_, err = capeFileField.Write(RED_CAPE)
-		assert.Nil(t, err)
-
 		assert.Nil(t, writer.Close())
 		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
-		ts.updateShouldFail(t, rec, "Setting a cape is not allowed.", ts.App.FrontEndURL+"/drasl/profile")
+		ts.updateShouldSucceed(t, rec)
 
-		// The user should not have a cape set
-		var user User
-		result := ts.App.DB.First(&user, "username = ?", username)
-		assert.Nil(t, result.Error)
-		assert.Nil(t, UnmakeNullString(&user.CapeHash))
+		var updatedUser User
+		assert.Nil(t, ts.App.DB.First(&updatedUser, "uuid = ?", user.UUID).Error)
+		assert.Equal(t, newUsername, updatedUser.Username)
+
+		form := url.Values{}
+		form.Set("username", newUsername)
+		form.Set("password", TEST_PASSWORD)
+		rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+		ts.loginShouldSucceed(t, rec)
 	}
 }
 
-func (ts *TestSuite) testDeleteAccount(t *testing.T) {
-	usernameA := "deleteA"
-	usernameB := "deleteB"
-
+func (ts *TestSuite) testChangePassword(t *testing.T) {
+	username := "changePassword"
+	otherUsername := "changePasswordOther"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+	returnURL := ts.App.FrontEndURL + "/drasl/profile"
+
+	// Get an access token for the Yggdrasil API
+	payload := authenticateRequest{
+		Username: username,
+		Password: TEST_PASSWORD,
+	}
+	rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var authenticateRes authenticateResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&authenticateRes))
+	assert.NotNil(t, ts.App.GetClient(authenticateRes.AccessToken, StalePolicyAllow))
+
+	{
+		// Incorrect current password should fail
+		form := url.Values{}
+		form.Set("currentPassword", "incorrect")
+		form.Set("password", "newpassword")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Incorrect current password!", returnURL)
+	}
+	{
+		// Too-short new password should fail
+		form := url.Values{}
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", "short")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Invalid password: password must be longer than 8 characters", returnURL)
+	}
+	{
+		// Weak new password should fail if MinPasswordStrength is set
+		ts.App.Config().MinPasswordStrength = 4
+		form := url.Values{}
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", "password1")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.App.Config().MinPasswordStrength = 0
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Contains(t, getErrorMessage(rec), "Invalid password: password is too weak")
+	}
+	{
+		// Non-admin should not be able to change another user's password
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", "newpassword")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "You are not an admin.", ts.App.FrontEndURL)
+	}
+	{
+		// Successful password change
+		form := url.Values{}
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", "newpassword")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+
+		// The user should stay logged in under a new browser token
+		newBrowserTokenCookie := getCookie(rec, "browserToken")
+		assert.NotEqual(t, "", newBrowserTokenCookie.Value)
+		assert.NotEqual(t, browserTokenCookie.Value, newBrowserTokenCookie.Value)
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "You are not logged in.", getErrorMessage(rec))
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*newBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Access tokens should be invalidated
+		assert.Nil(t, ts.App.GetClient(authenticateRes.AccessToken, StalePolicyAllow))
+
+		// Old password should no longer work, new one should
+		form = url.Values{}
+		form.Set("username", username)
+		form.Set("password", TEST_PASSWORD)
+		rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+		ts.loginShouldFail(t, rec, "Incorrect password!")
+		form.Set("password", "newpassword")
+		rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+		ts.loginShouldSucceed(t, rec)
+	}
+}
+
+func (ts *TestSuite) testUpdateAllOrNothing(t *testing.T) {
+	username := "allOrNothing"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	returnURL := ts.App.FrontEndURL + "/drasl/profile"
+
+	update := func(fields map[string]string, withSkin bool) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		for name, value := range fields {
+			writer.WriteField(name, value)
+		}
+		if withSkin {
+			skinFileField, err := writer.CreateFormFile("skinFile", "redSkin.png")
+			assert.Nil(t, err)
+			_, err = skinFileField.Write(RED_SKIN)
+			assert.Nil(t, err)
+		}
+		writer.WriteField("returnUrl", returnURL)
+		return ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+	}
+
+	{
+		// A valid skin and player name aren't saved alongside an invalid
+		// preferred language
+		rec := update(map[string]string{
+			"playerName":        "allOrNothingNew",
+			"preferredLanguage": "xx",
+		}, true)
+		ts.updateShouldFail(t, rec, "Invalid preferred language.", returnURL)
+
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.Equal(t, username, user.PlayerName)
+		assert.Equal(t, "en", user.PreferredLanguage)
+		assert.False(t, user.SkinHash.Valid)
+
+		// The profile page shows the form again as it was entered, with the
+		// problem next to the field
+		formCookie := getCookie(rec, "profileForm")
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie, *formCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `value="allOrNothingNew"`)
+		assert.Contains(t, rec.Body.String(), `<span class="error-message">Invalid preferred language.</span>`)
+
+		// ...but only once
+		assert.Equal(t, "", getCookie(rec, "profileForm").Value)
+	}
+	{
+		// Every problem is reported at once
+		rec := update(map[string]string{
+			"playerName":        "",
+			"newUsername":       "a name that is much too long to be a username",
+			"preferredLanguage": "xx",
+		}, false)
+		ts.updateShouldFail(t, rec, "Nothing was saved. Please fix the problems below and try again.", returnURL)
+
+		var form profileForm
+		assert.Nil(t, json.Unmarshal([]byte(Unwrap(url.QueryUnescape(getCookie(rec, "profileForm").Value))), &form))
+		assert.Contains(t, form.Errors, "newUsername")
+		assert.Contains(t, form.Errors, "preferredLanguage")
+		assert.NotContains(t, form.Errors, "playerName")
+	}
+	{
+		// A taken player name found only when saving is reported on its
+		// field too
+		ts.CreateTestUser(ts.Server, "allOrNothingTaken")
+		rec := update(map[string]string{"playerName": "allOrNothingTaken"}, false)
+		ts.updateShouldFail(t, rec, "That player name is taken.", returnURL)
+
+		var form profileForm
+		assert.Nil(t, json.Unmarshal([]byte(Unwrap(url.QueryUnescape(getCookie(rec, "profileForm").Value))), &form))
+		assert.Equal(t, "That player name is taken.", form.Errors["playerName"])
+	}
+	{
+		// Once the problems are fixed, everything is saved together
+		rec := update(map[string]string{
+			"playerName":        "allOrNothingNew",
+			"preferredLanguage": "es",
+		}, true)
+		ts.updateShouldSucceed(t, rec)
+
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.Equal(t, "allOrNothingNew", user.PlayerName)
+		assert.Equal(t, "es", user.PreferredLanguage)
+		assert.True(t, user.SkinHash.Valid)
+	}
+}
+
+func (ts *TestSuite) testUpdateSkinsCapesNotAllowed(t *testing.T) {
+	username := "updateNoSkinCape"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	{
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		writer.WriteField("skinModel", "classic")
+		skinFileField, err := writer.CreateFormFile("skinFile", "redSkin.png")
+		assert.Nil(t, err)
+		_, err = skinFileField.Write(RED_SKIN)
+		assert.Nil(t, err)
+
+		assert.Nil(t, writer.Close())
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Setting a skin is not allowed.", ts.App.FrontEndURL+"/drasl/profile")
+
+		// The user should not have a skin set
+		var user User
+		result := ts.App.DB.First(&user, "username = ?", username)
+		assert.Nil(t, result.Error)
+		assert.Nil(t, UnmakeNullString(&user.SkinHash))
+	}
+	{
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		capeFileField, err := writer.CreateFormFile("capeFile", "redCape.png")
+		assert.Nil(t, err)
+		_, err = capeFileField.Write(RED_CAPE)
+		assert.Nil(t, err)
+
+		assert.Nil(t, writer.Close())
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Setting a cape is not allowed.", ts.App.FrontEndURL+"/drasl/profile")
+
+		// The user should not have a cape set
+		var user User
+		result := ts.App.DB.First(&user, "username = ?", username)
+		assert.Nil(t, result.Error)
+		assert.Nil(t, UnmakeNullString(&user.CapeHash))
+	}
+}
+
+func (ts *TestSuite) testUpdateTextureQueue(t *testing.T) {
+	username := "updateTextureQueue"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+
+	updateSkin := func(skin []byte, playerName string) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		writer.WriteField("playerName", playerName)
+		writer.WriteField("skinModel", "slim")
+		writer.WriteField("detectSkinModel", "on")
+		skinFileField, err := writer.CreateFormFile("skinFile", "skin.png")
+		assert.Nil(t, err)
+		_, err = skinFileField.Write(skin)
+		assert.Nil(t, err)
+
+		assert.Nil(t, writer.Close())
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+	}
+
+	updateSkin(RED_SKIN, "TextureQueue")
+
+	// The skin is set once it's processed, and other changes are saved
+	// right away
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	assert.Equal(t, "TextureQueue", user.PlayerName)
+	assert.Eventually(t, func() bool {
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		return user.SkinHash.Valid
+	}, 5*time.Second, 10*time.Millisecond)
+	// RED_SKIN's arms are 4 pixels wide
+	assert.Equal(t, SkinModelClassic, user.SkinModel)
+	_, err := os.Stat(GetSkinPath(ts.App, user.SkinHash.String))
+	assert.Nil(t, err)
+
+	// An invalid skin is reported on the profile page, and the old skin is
+	// kept
+	updateSkin([]byte("not a PNG"), "TextureQueue")
+	assert.Eventually(t, func() bool {
+		rec := ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+		return strings.Contains(rec.Body.String(), "The new skin couldn't be used: texture must be a PNG, JPEG, or WebP image.")
+	}, 5*time.Second, 10*time.Millisecond)
+	oldSkinHash := user.SkinHash.String
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	assert.Equal(t, oldSkinHash, user.SkinHash.String)
+
+	// Deleting the skin takes effect right away
+	form := url.Values{}
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	form.Set("deleteSkin", "on")
+	rec := ts.PostForm(t, ts.Server, "/drasl/update", form, []http.Cookie{*browserTokenCookie}, nil)
+	ts.updateShouldSucceed(t, rec)
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	assert.False(t, user.SkinHash.Valid)
+	_, err = os.Stat(GetSkinPath(ts.App, oldSkinHash))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func (ts *TestSuite) testDeleteAccount(t *testing.T) {
+	usernameA := "deleteA"
+	usernameB := "deleteB"
+
 	ts.CreateTestUser(ts.Server, usernameA)
 	{
 		var user User
@@ -1094,8 +1836,35 @@ func (ts *TestSuite) testDeleteAccount(t *testing.T) {
 		err = SetCapeAndSave(ts.App, &otherUser, validCapeHandle)
 		assert.Nil(t, err)
 
+		// Confirmation page should be shown
+		rec := ts.Get(t, ts.Server, "/drasl/delete-user", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		confirmationURL := ts.App.FrontEndURL + "/drasl/delete-user?user=" + usernameB
+
+		// Delete account with incorrect password should fail
+		form := url.Values{}
+		form.Set("password", "incorrect")
+		form.Set("confirmUsername", usernameB)
+		rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Incorrect password!", confirmationURL)
+
+		// Delete account with mismatched username confirmation should fail
+		form = url.Values{}
+		form.Set("password", TEST_PASSWORD)
+		form.Set("confirmUsername", usernameA)
+		rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Username confirmation doesn't match.", confirmationURL)
+
+		// Check that usernameB still exists
+		result = ts.App.DB.First(&otherUser, "username = ?", usernameB)
+		assert.Nil(t, result.Error)
+
 		// Delete account usernameB
-		rec := ts.PostForm(t, ts.Server, "/drasl/delete-user", url.Values{}, []http.Cookie{*browserTokenCookie}, nil)
+		form = url.Values{}
+		form.Set("password", TEST_PASSWORD)
+		form.Set("confirmUsername", usernameB)
+		rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", form, []http.Cookie{*browserTokenCookie}, nil)
 		assert.Equal(t, http.StatusSeeOther, rec.Code)
 		assert.Equal(t, "", getErrorMessage(rec))
 		assert.Equal(t, ts.App.FrontEndURL, rec.Header().Get("Location"))
@@ -1136,7 +1905,10 @@ func (ts *TestSuite) testDeleteAccount(t *testing.T) {
 		blueCapeHash := *UnmakeNullString(&otherUser.CapeHash)
 
 		// Delete account usernameB
-		rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", url.Values{}, []http.Cookie{*browserTokenCookie}, nil)
+		form = url.Values{}
+		form.Set("password", TEST_PASSWORD)
+		form.Set("confirmUsername", usernameB)
+		rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", form, []http.Cookie{*browserTokenCookie}, nil)
 		assert.Equal(t, http.StatusSeeOther, rec.Code)
 		assert.Equal(t, "", getErrorMessage(rec))
 		assert.Equal(t, ts.App.FrontEndURL, rec.Header().Get("Location"))
@@ -1156,6 +1928,54 @@ func (ts *TestSuite) testDeleteAccount(t *testing.T) {
 	}
 }
 
+func (ts *TestSuite) testDeleteAccountCleanup(t *testing.T) {
+	username := "deleteCleanup"
+	otherUsername := "deleteCleanupOther"
+	ts.CreateTestUser(ts.Server, username)
+	ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	var otherUser User
+	assert.Nil(t, ts.App.DB.First(&otherUser, "username = ?", otherUsername).Error)
+
+	// The other user shares the red skin
+	assert.Nil(t, SetSkinAndSave(ts.App, &otherUser, bytes.NewReader(RED_SKIN)))
+	redSkinHash := *UnmakeNullString(&otherUser.SkinHash)
+
+	// The user saves the red skin to their library and then wears it
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	_, err := ts.App.SaveLibrarySkin(&user, "Red", "")
+	assert.Nil(t, err)
+
+	// Load the user, then change their skin behind their back, so the
+	// struct passed to DeleteUser is stale
+	var staleUser User
+	assert.Nil(t, ts.App.DB.First(&staleUser, "uuid = ?", user.UUID).Error)
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(BLUE_SKIN)))
+	blueSkinHash := *UnmakeNullString(&user.SkinHash)
+
+	// Signing in creates a client, which should be deleted along with the
+	// user
+	ts.authenticate(t, username, TEST_PASSWORD)
+
+	assert.Nil(t, DeleteUser(ts.App, &staleUser))
+
+	assert.True(t, errors.Is(ts.App.DB.First(&User{}, "uuid = ?", user.UUID).Error, gorm.ErrRecordNotFound))
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&Client{}).Where("user_uuid = ?", user.UUID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	assert.Nil(t, ts.App.DB.Model(&LibrarySkin{}).Where("user_uuid = ?", user.UUID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	// The skin the user wore when they were deleted is gone, but the one
+	// the other user still wears is kept
+	_, err = os.Stat(GetSkinPath(ts.App, blueSkinHash))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(GetSkinPath(ts.App, redSkinHash))
+	assert.Nil(t, err)
+}
+
 // Admin
 func (ts *TestSuite) testAdmin(t *testing.T) {
 	returnURL := ts.App.FrontEndURL + "/drasl/admin"
@@ -1207,13 +2027,1307 @@ func (ts *TestSuite) testAdmin(t *testing.T) {
 	assert.NotEqual(t, "", otherBrowserTokenCookie.Value)
 	assert.Nil(t, UnmakeNullString(&other.BrowserToken))
 
-	// Delete `otherUser`
+	// Updating one page of the user list leaves users on other pages alone
+	form = url.Values{}
+	form.Set("returnUrl", returnURL)
+	form.Add("user", username)
+	form.Set("admin-"+username, "on")
+	rec = ts.PostForm(t, ts.Server, "/drasl/admin/update-users", form, []http.Cookie{*browserTokenCookie}, nil)
+
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+	result = ts.App.DB.First(&other, "username = ?", otherUsername)
+	assert.Nil(t, result.Error)
+	assert.True(t, other.IsAdmin)
+	assert.True(t, other.IsLocked)
+
+	// Delete `otherUser`, confirming with the admin's own password
 	form = url.Values{}
 	form.Set("returnUrl", returnURL)
 	form.Set("username", otherUsername)
+	form.Set("password", TEST_PASSWORD)
+	form.Set("confirmUsername", otherUsername)
 	rec = ts.PostForm(t, ts.Server, "/drasl/delete-user", form, []http.Cookie{*browserTokenCookie}, nil)
 
 	assert.Equal(t, http.StatusSeeOther, rec.Code)
 	assert.Equal(t, "", getErrorMessage(rec))
 	assert.Equal(t, returnURL, rec.Header().Get("Location"))
 }
+
+func (ts *TestSuite) testImpersonation(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/admin"
+
+	username := "impersonationAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+
+	otherUsername := "impersonationOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	var otherUser User
+	assert.Nil(t, ts.App.DB.First(&otherUser, "username = ?", otherUsername).Error)
+
+	{
+		// Non-admins should not be able to impersonate
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/impersonate", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+
+		// A forged impersonate cookie should be ignored
+		impersonateCookie := http.Cookie{Name: "impersonate", Value: user.UUID}
+		rec = ts.Get(t, ts.Server, "/drasl/admin", []http.Cookie{*otherBrowserTokenCookie, impersonateCookie}, nil)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+	}
+	{
+		// Admins can't impersonate other admins
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/impersonate", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "You can't sign in as another admin.", getErrorMessage(rec))
+	}
+	{
+		// Successful impersonation
+		form := url.Values{}
+		form.Set("username", otherUsername)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/impersonate", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, ts.App.FrontEndURL+"/drasl/profile", rec.Header().Get("Location"))
+		impersonateCookie := getCookie(rec, "impersonate")
+		assert.Equal(t, otherUser.UUID, impersonateCookie.Value)
+		cookies := []http.Cookie{*browserTokenCookie, *impersonateCookie}
+
+		// The profile page should be the other user's, with a warning
+		rec = ts.Get(t, ts.Server, "/drasl/profile", cookies, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "You are signed in as "+otherUsername)
+
+		// Changes are made to the other user and recorded in the audit log
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("preferredLanguage", "es")
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		assert.Nil(t, writer.Close())
+		rec = ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, cookies, nil)
+		ts.updateShouldSucceed(t, rec)
+		assert.Nil(t, ts.App.DB.First(&otherUser, "username = ?", otherUsername).Error)
+		assert.Equal(t, "es", otherUser.PreferredLanguage)
+
+		// Changing the password is not allowed
+		form = url.Values{}
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", "newpassword")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec = ts.PostForm(t, ts.Server, "/drasl/change-password", form, cookies, nil)
+		ts.updateShouldFail(t, rec, "You can't do that while signed in as another user.", ts.App.FrontEndURL+"/drasl/profile")
+
+		// Nor is creating an API token, which would outlast the impersonation
+		form = url.Values{}
+		form.Set("name", "impersonated")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec = ts.PostForm(t, ts.Server, "/drasl/new-api-token", form, cookies, nil)
+		ts.updateShouldFail(t, rec, "You can't do that while signed in as another user.", ts.App.FrontEndURL+"/drasl/profile")
+		var apiTokenCount int64
+		assert.Nil(t, ts.App.DB.Model(&APIToken{}).Where("user_uuid = ?", otherUser.UUID).Count(&apiTokenCount).Error)
+		assert.Equal(t, int64(0), apiTokenCount)
+
+		// Nor is linking a Bedrock or external account
+		form = url.Values{}
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec = ts.PostForm(t, ts.Server, "/drasl/bedrock-link-code", form, cookies, nil)
+		ts.updateShouldFail(t, rec, "You can't do that while signed in as another user.", ts.App.FrontEndURL+"/drasl/profile")
+
+		form = url.Values{}
+		form.Set("source", "impersonated")
+		form.Set("username", "impersonated")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec = ts.PostForm(t, ts.Server, "/drasl/link-account", form, cookies, nil)
+		ts.updateShouldFail(t, rec, "You can't do that while signed in as another user.", ts.App.FrontEndURL+"/drasl/profile")
+
+		// Stop impersonating
+		rec = ts.PostForm(t, ts.Server, "/drasl/stop-impersonating", url.Values{}, cookies, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, returnURL, rec.Header().Get("Location"))
+		assert.Equal(t, "", getCookie(rec, "impersonate").Value)
+
+		// The other user's session should be untouched
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		auditLog, err := ts.App.GetAuditLog(10)
+		assert.Nil(t, err)
+		actions := make([]string, 0, len(auditLog))
+		for _, entry := range auditLog {
+			assert.Equal(t, username, entry.ActorUsername)
+			assert.Equal(t, otherUsername, entry.TargetUsername)
+			actions = append(actions, entry.Action)
+		}
+		assert.Contains(t, actions, AuditActionImpersonationStart)
+		assert.Contains(t, actions, AuditActionImpersonationRequest)
+		assert.Contains(t, actions, AuditActionImpersonationStop)
+	}
+}
+
+func (ts *TestSuite) testGroups(t *testing.T) {
+	adminURL := ts.App.FrontEndURL + "/drasl/admin"
+	groupURL := ts.App.FrontEndURL + "/drasl/admin/group?group=staff"
+
+	username := "groupsAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	memberUsername := "groupsMember"
+	memberBrowserTokenCookie := ts.CreateTestUser(ts.Server, memberUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	{
+		// Non-admins can't create groups
+		form := url.Values{}
+		form.Set("group", "staff")
+		form.Set("returnUrl", adminURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/new-group", form, []http.Cookie{*memberBrowserTokenCookie}, nil)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+	}
+	{
+		// Create a group and add both users
+		form := url.Values{}
+		form.Set("group", "staff")
+		form.Set("returnUrl", adminURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/new-group", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, groupURL, rec.Header().Get("Location"))
+
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/new-group", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "That group already exists.", getErrorMessage(rec))
+
+		for _, name := range []string{username, memberUsername} {
+			form := url.Values{}
+			form.Set("group", "staff")
+			form.Set("username", name)
+			rec := ts.PostForm(t, ts.Server, "/drasl/admin/group/add-member", form, []http.Cookie{*browserTokenCookie}, nil)
+			assert.Equal(t, http.StatusSeeOther, rec.Code)
+			assert.Equal(t, "", getErrorMessage(rec))
+		}
+
+		rec = ts.Get(t, ts.Server, "/drasl/admin/group?group=staff", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), memberUsername)
+	}
+	{
+		// Export UUIDs
+		var member User
+		assert.Nil(t, ts.App.DB.First(&member, "username = ?", memberUsername).Error)
+		rec := ts.Get(t, ts.Server, "/drasl/admin/group/export?group=staff", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), member.UUID+"\n")
+		assert.Contains(t, rec.Body.String(), user.UUID+"\n")
+	}
+	{
+		// Give the group a cape
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("group", "staff")
+		capeFileField, err := writer.CreateFormFile("capeFile", "redCape.png")
+		assert.Nil(t, err)
+		_, err = capeFileField.Write(RED_CAPE)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/admin/group/set-cape", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		redCapeHash := storedTextureHash(t, ts.App, ValidateCape, RED_CAPE)
+		var member User
+		assert.Nil(t, ts.App.DB.First(&member, "username = ?", memberUsername).Error)
+		assert.Equal(t, redCapeHash, *UnmakeNullString(&member.CapeHash))
+
+		// Then remove it
+		form := url.Values{}
+		form.Set("group", "staff")
+		form.Set("deleteCape", "on")
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/group/set-cape", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Nil(t, ts.App.DB.First(&member, "username = ?", memberUsername).Error)
+		assert.Nil(t, UnmakeNullString(&member.CapeHash))
+	}
+	{
+		// Locking the group locks members but not admins
+		form := url.Values{}
+		form.Set("group", "staff")
+		form.Set("locked", "on")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/group/set-locked", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		var member User
+		assert.Nil(t, ts.App.DB.First(&member, "username = ?", memberUsername).Error)
+		assert.True(t, member.IsLocked)
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.False(t, user.IsLocked)
+
+		form.Del("locked")
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/group/set-locked", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Nil(t, ts.App.DB.First(&member, "username = ?", memberUsername).Error)
+		assert.False(t, member.IsLocked)
+	}
+	{
+		// Deleting the group removes its memberships
+		form := url.Values{}
+		form.Set("group", "staff")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/delete-group", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, adminURL, rec.Header().Get("Location"))
+
+		var count int64
+		assert.Nil(t, ts.App.DB.Model(&GroupMembership{}).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	}
+}
+
+func (ts *TestSuite) testStats(t *testing.T) {
+	username := "statsAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherUsername := "statsOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	today := statDay(time.Now())
+	statsBefore, err := ts.App.GetStats()
+	assert.Nil(t, err)
+	todayBefore := statsBefore.Joins[len(statsBefore.Joins)-1]
+	assert.Equal(t, today, todayBefore.Day)
+
+	// Join a server twice
+	payload := authenticateRequest{
+		Username: username,
+		Password: TEST_PASSWORD,
+	}
+	rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var authenticateRes authenticateResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&authenticateRes))
+	for i := 0; i < 2; i++ {
+		joinPayload := sessionJoinRequest{
+			AccessToken:     authenticateRes.AccessToken,
+			SelectedProfile: user.UUID,
+			ServerID:        "statsServer",
+		}
+		rec = ts.PostJSON(t, ts.Server, "/session/minecraft/join", joinPayload, nil, nil)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	stats, err := ts.App.GetStats()
+	assert.Nil(t, err)
+	assert.Equal(t, todayBefore.Count+2, stats.Joins[len(stats.Joins)-1].Count)
+	assert.Equal(t, statsBefore.ActivePlayers[len(statsBefore.ActivePlayers)-1].Count+1, stats.ActivePlayers[len(stats.ActivePlayers)-1].Count)
+	assert.True(t, stats.Registrations[len(stats.Registrations)-1].Count >= 2)
+
+	// Only admins can view the dashboard
+	rec = ts.Get(t, ts.Server, "/drasl/admin/stats", []http.Cookie{*otherBrowserTokenCookie}, nil)
+	assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+	rec = ts.Get(t, ts.Server, "/drasl/admin/stats", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func (ts *TestSuite) testAnnouncement(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/admin"
+
+	username := "announcementAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+
+	otherUsername := "announcementOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	result := ts.App.DB.First(&user, "username = ?", username)
+	assert.Nil(t, result.Error)
+	user.IsAdmin = true
+	result = ts.App.DB.Save(&user)
+	assert.Nil(t, result.Error)
+
+	{
+		// Non-admins should not be able to set the announcement
+		form := url.Values{}
+		form.Set("returnUrl", returnURL)
+		form.Set("announcement", "Hacked")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-announcement", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+
+		announcement, err := ts.App.GetAnnouncement()
+		assert.Nil(t, err)
+		assert.Nil(t, announcement)
+	}
+	{
+		form := url.Values{}
+		form.Set("returnUrl", returnURL)
+		form.Set("announcement", "Server **restarts** at noon. <script>alert(1)</script>")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-announcement", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, returnURL, rec.Header().Get("Location"))
+
+		// Announcement should be rendered as Markdown, without raw HTML
+		rec = ts.Get(t, ts.Server, "/", nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<strong>restarts</strong>")
+		assert.NotContains(t, rec.Body.String(), "<script>alert(1)</script>")
+
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "<strong>restarts</strong>")
+	}
+	{
+		// Blank announcement should clear it
+		form := url.Values{}
+		form.Set("returnUrl", returnURL)
+		form.Set("announcement", "  ")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-announcement", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		announcement, err := ts.App.GetAnnouncement()
+		assert.Nil(t, err)
+		assert.Nil(t, announcement)
+
+		rec = ts.Get(t, ts.Server, "/", nil, nil)
+		assert.NotContains(t, rec.Body.String(), "<strong>restarts</strong>")
+	}
+}
+
+// Records messages instead of sending them
+type testMailer struct {
+	mutex    sync.Mutex
+	Messages []EmailMessage
+}
+
+func (mailer *testMailer) Send(message *EmailMessage) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.Messages = append(mailer.Messages, *message)
+	return nil
+}
+
+func (mailer *testMailer) Count() int {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	return len(mailer.Messages)
+}
+
+func (mailer *testMailer) Last() EmailMessage {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	return mailer.Messages[len(mailer.Messages)-1]
+}
+
+// Doesn't return until unblocked, like an unresponsive mail server
+type blockingMailer struct {
+	unblock chan struct{}
+}
+
+func (mailer *blockingMailer) Send(message *EmailMessage) error {
+	<-mailer.unblock
+	return nil
+}
+
+func (ts *TestSuite) testEmail(t *testing.T) {
+	mailer := &testMailer{}
+	ts.App.Mailer = mailer
+
+	username := "emailAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherUsername := "emailOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	{
+		// Invalid email addresses should be rejected
+		form := url.Values{}
+		form.Set("email", "not an address")
+		form.Set("receiveAnnouncements", "on")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-email", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Invalid email: not a valid email address", ts.App.FrontEndURL+"/drasl/profile")
+	}
+	{
+		// Setting an email address should send a verification link
+		form := url.Values{}
+		form.Set("email", "other@example.com")
+		form.Set("receiveAnnouncements", "on")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-email", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+		assert.Equal(t, 1, mailer.Count())
+		assert.Equal(t, "other@example.com", mailer.Last().To)
+	}
+
+	var other User
+	assert.Nil(t, ts.App.DB.First(&other, "username = ?", otherUsername).Error)
+	assert.Equal(t, "other@example.com", other.Email.String)
+	assert.False(t, other.EmailVerified)
+
+	// Unverified addresses don't receive announcements
+	recipients, err := ts.App.GetAnnouncementRecipients(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(recipients))
+
+	{
+		// A tampered verification link shouldn't work
+		rec := ts.Get(t, ts.Server, "/drasl/verify-email?user="+other.UUID+"&email=other%40example.com&token=deadbeef", nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "This verification link is invalid.", getErrorMessage(rec))
+	}
+	{
+		verificationURL, err := EmailVerificationURL(ts.App, &other, "other@example.com")
+		assert.Nil(t, err)
+		rec := ts.Get(t, ts.Server, strings.TrimPrefix(verificationURL, ts.App.FrontEndURL), nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		assert.Nil(t, ts.App.DB.First(&other, "username = ?", otherUsername).Error)
+		assert.True(t, other.EmailVerified)
+	}
+	{
+		// Non-admins can't email users
+		form := url.Values{}
+		form.Set("subject", "Hello")
+		form.Set("body", "Hello")
+		form.Set("action", "send")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/email", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+	}
+	{
+		// Preview shouldn't send anything
+		form := url.Values{}
+		form.Set("subject", "News for {{ .PlayerName }}")
+		form.Set("body", "Hello, {{ .Username }}!")
+		form.Set("action", "preview")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/email", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "This email would be sent to 1 users.")
+		assert.Contains(t, rec.Body.String(), "News for emailOther")
+		assert.Contains(t, rec.Body.String(), "Hello, emailOther!")
+		assert.Equal(t, 1, mailer.Count())
+	}
+	{
+		// Invalid templates should be rejected
+		form := url.Values{}
+		form.Set("subject", "News")
+		form.Set("body", "Hello, {{ .Username }")
+		form.Set("action", "send")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/email", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "Invalid template")
+		assert.Equal(t, 1, mailer.Count())
+	}
+	{
+		form := url.Values{}
+		form.Set("subject", "News")
+		form.Set("body", "Hello, {{ .Username }}!")
+		form.Set("action", "send")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/email", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, ts.App.FrontEndURL+"/drasl/admin", rec.Header().Get("Location"))
+
+		assert.Eventually(t, func() bool { return mailer.Count() == 2 }, 5*time.Second, 10*time.Millisecond)
+		message := mailer.Last()
+		assert.Equal(t, "other@example.com", message.To)
+		assert.Equal(t, "News", message.Subject)
+		assert.True(t, strings.HasPrefix(message.Body, "Hello, emailOther!"))
+		assert.NotEqual(t, "", message.UnsubscribeURL)
+
+		var entry AuditLogEntry
+		assert.Nil(t, ts.App.DB.Last(&entry, "action = ?", AuditActionSendEmail).Error)
+		assert.Equal(t, username, entry.ActorUsername)
+
+		// The unsubscribe link should opt the user out
+		rec = ts.Get(t, ts.Server, strings.TrimPrefix(message.UnsubscribeURL, ts.App.FrontEndURL), nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		recipients, err := ts.App.GetAnnouncementRecipients(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(recipients))
+	}
+	{
+		// Clearing the email address should remove it
+		form := url.Values{}
+		form.Set("email", "")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-email", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+
+		assert.Nil(t, ts.App.DB.First(&other, "username = ?", otherUsername).Error)
+		assert.False(t, other.Email.Valid)
+		assert.False(t, other.EmailVerified)
+	}
+}
+
+func (ts *TestSuite) testSecurityNotifications(t *testing.T) {
+	mailer := &testMailer{}
+	ts.App.Mailer = mailer
+	// Notifications are sent in the background
+	sentCount := func() int {
+		ts.App.securityEmails.Wait()
+		return mailer.Count()
+	}
+
+	username := "securityNotifications"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.Email = MakeNullString(Ptr("security@example.com"))
+	user.EmailVerified = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	login := func(cookies []http.Cookie) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("password", TEST_PASSWORD)
+		rec := ts.PostForm(t, ts.Server, "/drasl/login", form, cookies, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		return rec
+	}
+
+	var knownDeviceCookie *http.Cookie
+	{
+		// Logging in from a new browser should send a notification
+		rec := login(nil)
+		assert.Equal(t, 1, sentCount())
+		message := mailer.Last()
+		assert.Equal(t, "security@example.com", message.To)
+		assert.Equal(t, "New sign-in to your Drasl account", message.Subject)
+		assert.Contains(t, message.Body, "IP address: 192.0.2.1")
+		knownDeviceCookie = getCookie(rec, "knownDevice")
+		assert.NotEqual(t, "", knownDeviceCookie.Value)
+		browserTokenCookie = getCookie(rec, "browserToken")
+
+		// ...but not from the same browser again
+		rec = login([]http.Cookie{*knownDeviceCookie})
+		assert.Equal(t, 1, sentCount())
+		browserTokenCookie = getCookie(rec, "browserToken")
+	}
+	{
+		// Same for launchers, identified by their client token
+		payload := authenticateRequest{
+			Username:    username,
+			Password:    TEST_PASSWORD,
+			ClientToken: Ptr("security-notifications-client"),
+		}
+		rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, sentCount())
+		assert.Equal(t, "New sign-in to your Drasl account", mailer.Last().Subject)
+
+		rec = ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, sentCount())
+	}
+	{
+		// A slow mail server shouldn't hold up signing in
+		mailer := &blockingMailer{unblock: make(chan struct{})}
+		ts.App.Mailer = mailer
+		payload := authenticateRequest{
+			Username:    username,
+			Password:    TEST_PASSWORD,
+			ClientToken: Ptr("security-notifications-blocked-client"),
+		}
+		rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		close(mailer.unblock)
+		ts.App.securityEmails.Wait()
+	}
+	ts.App.Mailer = mailer
+	{
+		// Changing the password should send a notification
+		form := url.Values{}
+		form.Set("currentPassword", TEST_PASSWORD)
+		form.Set("password", TEST_PASSWORD)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/change-password", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+		assert.Equal(t, 3, sentCount())
+		assert.Equal(t, "Your Drasl password was changed", mailer.Last().Subject)
+		browserTokenCookie = getCookie(rec, "browserToken")
+	}
+	{
+		// Changing the email address should notify the old address, then
+		// send a verification link to the new one
+		form := url.Values{}
+		form.Set("email", "security-new@example.com")
+		form.Set("receiveSecurityNotifications", "on")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-email", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+		assert.Equal(t, 5, sentCount())
+		mailer.mutex.Lock()
+		messages := map[string]EmailMessage{}
+		for _, message := range mailer.Messages[3:] {
+			messages[message.To] = message
+		}
+		mailer.mutex.Unlock()
+		assert.Equal(t, "Your Drasl email address was changed", messages["security@example.com"].Subject)
+		assert.Contains(t, messages, "security-new@example.com")
+	}
+	{
+		// Users who opt out shouldn't be notified
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		user.EmailVerified = true
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+		form := url.Values{}
+		form.Set("email", "security-new@example.com")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-email", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.True(t, user.SecurityNotificationsOptOut)
+
+		login(nil)
+		assert.Equal(t, 5, sentCount())
+	}
+}
+
+func (ts *TestSuite) testRegistrationNewPlayerApproval(t *testing.T, webhookRequests chan pendingApprovalWebhookPayload) {
+	adminURL := ts.App.FrontEndURL + "/drasl/admin"
+
+	register := func(username string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("password", TEST_PASSWORD)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/registration")
+		return ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	}
+	authenticate := func(username string) *httptest.ResponseRecorder {
+		payload := authenticateRequest{
+			Username: username,
+			Password: TEST_PASSWORD,
+		}
+		return ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	}
+
+	// Default admins don't need approval
+	adminUsername := "approvalAdmin"
+	rec := register(adminUsername)
+	ts.registrationShouldSucceed(t, rec)
+	adminBrowserTokenCookie := getCookie(rec, "browserToken")
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", adminUsername).Error)
+	assert.False(t, admin.IsPendingApproval)
+
+	usernameA := "approvalA"
+	rec = register(usernameA)
+	ts.registrationShouldSucceed(t, rec)
+	browserTokenCookie := getCookie(rec, "browserToken")
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", usernameA).Error)
+	assert.True(t, user.IsPendingApproval)
+
+	select {
+	case payload := <-webhookRequests:
+		assert.Equal(t, usernameA, payload.Username)
+		assert.Equal(t, user.UUID, payload.UUID)
+		assert.Equal(t, adminURL, payload.AdminURL)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Approval webhook wasn't called")
+	}
+
+	// Pending users can sign in to the web interface and see their status...
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Your account is waiting for approval by an admin.")
+
+	// ...but can't sign in to Minecraft
+	rec = authenticate(usernameA)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var errorResponse ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, "Your account is waiting for approval by an admin.", *errorResponse.ErrorMessage)
+
+	// Admins see the queue
+	rec = ts.Get(t, ts.Server, "/drasl/admin", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Awaiting Approval")
+
+	{
+		// Non-admins can't approve users
+		form := url.Values{}
+		form.Set("username", usernameA)
+		form.Set("returnUrl", adminURL)
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/approve-user", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+	}
+	{
+		form := url.Values{}
+		form.Set("username", usernameA)
+		form.Set("returnUrl", adminURL)
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/approve-user", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, adminURL, rec.Header().Get("Location"))
+
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", usernameA).Error)
+		assert.False(t, user.IsPendingApproval)
+
+		rec = authenticate(usernameA)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var entry AuditLogEntry
+		assert.Nil(t, ts.App.DB.Last(&entry, "action = ?", AuditActionApproveUser).Error)
+		assert.Equal(t, usernameA, entry.TargetUsername)
+	}
+	{
+		// Approved users can't be rejected
+		form := url.Values{}
+		form.Set("username", usernameA)
+		form.Set("returnUrl", adminURL)
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/reject-user", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, "User not found.", getErrorMessage(rec))
+	}
+	{
+		// Rejecting a user deletes their account
+		usernameB := "approvalB"
+		rec = register(usernameB)
+		ts.registrationShouldSucceed(t, rec)
+		<-webhookRequests
+
+		form := url.Values{}
+		form.Set("username", usernameB)
+		form.Set("returnUrl", adminURL)
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/reject-user", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		var count int64
+		assert.Nil(t, ts.App.DB.Model(&User{}).Where("username = ?", usernameB).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	}
+}
+
+func (ts *TestSuite) testCookieAttributes(t *testing.T) {
+	form := url.Values{}
+	form.Set("username", "nonexistent")
+	form.Set("password", TEST_PASSWORD)
+	rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	errorMessageCookie := getCookie(rec, "errorMessage")
+	assert.Equal(t, "User not found!", Unwrap(url.QueryUnescape(errorMessageCookie.Value)))
+	assert.Equal(t, "/prefix/", errorMessageCookie.Path)
+	assert.Equal(t, MESSAGE_AGE_SEC, errorMessageCookie.MaxAge)
+	assert.False(t, errorMessageCookie.Secure)
+	assert.True(t, errorMessageCookie.HttpOnly)
+
+	// Showing the message should delete the cookie
+	rec = ts.Get(t, ts.Server, "/", []http.Cookie{*errorMessageCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	errorMessageCookie = getCookie(rec, "errorMessage")
+	assert.Equal(t, "", errorMessageCookie.Value)
+	assert.True(t, errorMessageCookie.MaxAge < 0)
+	assert.Equal(t, "/prefix/", errorMessageCookie.Path)
+}
+
+func (ts *TestSuite) testAdminRestrictions(t *testing.T) {
+	username := "adminRestrictions"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	get := func(path string, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.AddCookie(browserTokenCookie)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The proxy itself isn't in AllowedCIDRs
+	assert.Equal(t, http.StatusNotFound, get("/drasl/admin", "").Code)
+	assert.Equal(t, http.StatusNotFound, get("/drasl/admin", "203.0.113.7").Code)
+	assert.Equal(t, http.StatusNotFound, get("/drasl/admin/stats", "198.51.100.13").Code)
+
+	assert.Equal(t, http.StatusOK, get("/drasl/admin", "198.51.100.7").Code)
+	assert.Equal(t, http.StatusOK, get("/drasl/admin/stats", "198.51.100.7").Code)
+
+	// Only the address added by the trusted proxy counts, not one the
+	// client claims
+	assert.Equal(t, http.StatusNotFound, get("/drasl/admin", "198.51.100.7, 203.0.113.7").Code)
+
+	// Other pages aren't affected
+	assert.Equal(t, http.StatusOK, get("/drasl/profile", "203.0.113.7").Code)
+}
+
+func (ts *TestSuite) testRegistrationRestrictions(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/registration"
+	register := func(username string, email string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("password", TEST_PASSWORD)
+		form.Set("emailAddress", email)
+		form.Set("returnUrl", returnURL)
+		return ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	}
+
+	rec := ts.Get(t, ts.Server, "/drasl/registration", nil, nil)
+	assert.Contains(t, rec.Body.String(), `name="emailAddress"`)
+
+	ts.registrationShouldFail(t, register("restrictedA", ""), "Invalid email: an email address is required", returnURL)
+	ts.registrationShouldFail(t, register("restrictedA", "a@example.org"), "Invalid email: addresses from that domain aren't allowed", returnURL)
+	ts.registrationShouldFail(t, register("restrictedA", "a@banned.example.com"), "Invalid email: addresses from that domain aren't allowed", returnURL)
+
+	// Disposable domains are denied even when they'd otherwise be allowed
+	ts.App.Config().RegistrationRestrictions.AllowedEmailDomains = nil
+	ts.registrationShouldFail(t, register("restrictedA", "a@mailinator.com"), "Invalid email: disposable email addresses aren't allowed", returnURL)
+	ts.registrationShouldFail(t, register("restrictedA", "a@sub.yopmail.com"), "Invalid email: disposable email addresses aren't allowed", returnURL)
+	ts.App.Config().RegistrationRestrictions.AllowedEmailDomains = []string{"example.com"}
+
+	// httptest requests come from 192.0.2.1
+	ts.App.RegistrationDeniedNets = Unwrap(ParseCIDRs([]string{"192.0.2.0/24"}))
+	ts.registrationShouldFail(t, register("restrictedA", "a@mail.example.com"), "Can't register: registration isn't allowed from your network", returnURL)
+	ts.App.RegistrationDeniedNets = nil
+
+	ts.App.RegistrationAllowedNets = Unwrap(ParseCIDRs([]string{"198.51.100.0/24", "2001:db8::/32"}))
+	ts.registrationShouldFail(t, register("restrictedA", "a@mail.example.com"), "Can't register: registration isn't allowed from your network", returnURL)
+	ts.App.RegistrationAllowedNets = Unwrap(ParseCIDRs([]string{"192.0.2.0/24"}))
+
+	rec = register("restrictedA", "a@mail.example.com")
+	ts.registrationShouldSucceed(t, rec)
+	ts.App.RegistrationAllowedNets = nil
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", "restrictedA").Error)
+	assert.Equal(t, "a@mail.example.com", user.Email.String)
+	assert.False(t, user.EmailVerified)
+}
+
+func (ts *TestSuite) testRegistrationExistingPlayerSources(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/registration"
+	register := func(source string) *httptest.ResponseRecorder {
+		form := url.Values{}
+		form.Set("username", EXISTING_USERNAME)
+		form.Set("password", TEST_PASSWORD)
+		form.Set("existingPlayer", "on")
+		if source != "" {
+			form.Set("source", source)
+		}
+		form.Set("returnUrl", returnURL)
+		return ts.PostForm(t, ts.Server, "/drasl/register", form, nil, nil)
+	}
+
+	// Users choose a source on the registration page
+	rec := ts.Get(t, ts.Server, "/drasl/registration", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<option value="Unreachable">`)
+	assert.Contains(t, rec.Body.String(), `<option value="Aux">`)
+
+	// With more than one source, one must be chosen
+	ts.registrationShouldFail(t, register(""), "Unknown account provider.", returnURL)
+	ts.registrationShouldFail(t, register("Nonexistent"), "Unknown account provider.", returnURL)
+
+	// The account is looked up on the chosen source only
+	rec = register("Unreachable")
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Contains(t, getErrorMessage(rec), "Couldn't find your account")
+
+	rec = register("Aux")
+	ts.registrationShouldSucceed(t, rec)
+
+	var auxUser User
+	assert.Nil(t, ts.AuxApp.DB.First(&auxUser, "username = ?", EXISTING_USERNAME).Error)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", EXISTING_USERNAME).Error)
+	assert.Equal(t, auxUser.UUID, user.UUID)
+}
+
+func (ts *TestSuite) showQRLogin(t *testing.T, browserTokenCookie *http.Cookie) string {
+	form := url.Values{}
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec := ts.PostForm(t, ts.Server, "/drasl/qr-login", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "data:image/png;base64,")
+
+	match := regexp.MustCompile("token=([0-9a-f]{64})").FindStringSubmatch(rec.Body.String())
+	assert.Equal(t, 2, len(match))
+	return match[1]
+}
+
+func (ts *TestSuite) testQRLogin(t *testing.T) {
+	username := "qrLogin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+
+	{
+		token := ts.showQRLogin(t, browserTokenCookie)
+
+		// Opening the link shouldn't sign in by itself
+		rec := ts.Get(t, ts.Server, "/drasl/qr-login/claim?token="+token, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), username)
+		assert.Equal(t, "", getCookie(rec, "browserToken").Value)
+
+		form := url.Values{}
+		form.Set("token", token)
+		rec = ts.PostForm(t, ts.Server, "/drasl/qr-login/claim", form, nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, ts.App.FrontEndURL+"/drasl/profile", rec.Header().Get("Location"))
+
+		// The new device shares the session of the one that showed the code,
+		// which stays signed in
+		assert.Equal(t, browserTokenCookie.Value, getCookie(rec, "browserToken").Value)
+		rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// The code can only be used once
+		rec = ts.PostForm(t, ts.Server, "/drasl/qr-login/claim", form, nil, nil)
+		assert.Equal(t, "That QR code is invalid or has expired.", getErrorMessage(rec))
+	}
+	{
+		// An expired code should fail
+		token := ts.showQRLogin(t, browserTokenCookie)
+		assert.Nil(t, ts.App.DB.Model(&QRLogin{}).Where("token = ?", token).Update("expires_at", time.Now().Add(-time.Second)).Error)
+
+		rec := ts.Get(t, ts.Server, "/drasl/qr-login/claim?token="+token, nil, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "That QR code is invalid or has expired.", getErrorMessage(rec))
+
+		form := url.Values{}
+		form.Set("token", token)
+		rec = ts.PostForm(t, ts.Server, "/drasl/qr-login/claim", form, nil, nil)
+		assert.Equal(t, "That QR code is invalid or has expired.", getErrorMessage(rec))
+	}
+	{
+		// A cancelled code should fail
+		token := ts.showQRLogin(t, browserTokenCookie)
+
+		form := url.Values{}
+		form.Set("token", token)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/qr-login/cancel", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		rec = ts.PostForm(t, ts.Server, "/drasl/qr-login/claim", form, nil, nil)
+		assert.Equal(t, "That QR code is invalid or has expired.", getErrorMessage(rec))
+	}
+	{
+		// A launcher can exchange the code for Yggdrasil credentials
+		token := ts.showQRLogin(t, browserTokenCookie)
+
+		payload := apiQRLoginRequest{
+			Token: token,
+			Agent: &Agent{Name: "Minecraft", Version: 1},
+		}
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/qr-login", payload, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var response authenticateResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.NotNil(t, ts.App.GetClient(response.AccessToken, StalePolicyDeny))
+
+		rec = ts.PostJSON(t, ts.Server, "/drasl/api/v1/qr-login", payload, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+	{
+		// ...unless the ExternalAuth check refuses the user
+		payload := apiQRLoginRequest{Token: ts.showQRLogin(t, browserTokenCookie)}
+		allowExternalAuth := ts.denyExternalAuth("Not today.")
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/qr-login", payload, nil, nil)
+		signInShouldBeForbidden(t, rec, "Not today.")
+		allowExternalAuth()
+
+		// ...or the user was locked after showing the code
+		payload = apiQRLoginRequest{Token: ts.showQRLogin(t, browserTokenCookie)}
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.Nil(t, ts.App.SetIsLocked(ts.App.DB, &user, true))
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+		rec = ts.PostJSON(t, ts.Server, "/drasl/api/v1/qr-login", payload, nil, nil)
+		signInShouldBeForbidden(t, rec, "Account is locked.")
+	}
+}
+
+func (ts *TestSuite) testClients(t *testing.T) {
+	username := "clients"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, "clientsOther")
+
+	accessToken := ts.authenticate(t, username, TEST_PASSWORD).AccessToken
+	client := ts.App.GetClient(accessToken, StalePolicyDeny)
+	assert.NotNil(t, client)
+	assert.False(t, client.LastUsedAt.IsZero())
+
+	returnURL := ts.App.FrontEndURL + "/drasl/profile"
+	{
+		// The client should be listed on the profile page
+		rec := ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), client.UUID)
+	}
+	{
+		// Name the client and make it auth-only
+		form := url.Values{}
+		form.Set("clientUuid", client.UUID)
+		form.Set("name", "Laptop")
+		form.Set("authOnly", "on")
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/update-client", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+
+		var updated Client
+		assert.Nil(t, ts.App.DB.First(&updated, "uuid = ?", client.UUID).Error)
+		assert.Equal(t, "Laptop", updated.Name)
+		assert.True(t, updated.AuthOnly)
+	}
+	{
+		// Other users can't touch the client
+		form := url.Values{}
+		form.Set("clientUuid", client.UUID)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/revoke-client", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		ts.updateShouldFail(t, rec, "Client not found.", returnURL)
+		assert.NotNil(t, ts.App.GetClient(accessToken, StalePolicyDeny))
+	}
+	{
+		// Revoking the client should invalidate its access token
+		form := url.Values{}
+		form.Set("clientUuid", client.UUID)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/revoke-client", form, []http.Cookie{*browserTokenCookie}, nil)
+		ts.updateShouldSucceed(t, rec)
+		assert.Nil(t, ts.App.GetClient(accessToken, StalePolicyDeny))
+	}
+}
+
+func (ts *TestSuite) testGiftCodes(t *testing.T) {
+	adminURL := ts.App.FrontEndURL + "/drasl/admin"
+	profileURL := ts.App.FrontEndURL + "/drasl/profile"
+
+	adminUsername := "giftCodesAdmin"
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, adminUsername)
+	username := "giftCodesPlayer"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherUsername := "giftCodesOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", adminUsername).Error)
+	admin.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&admin).Error)
+
+	redeem := func(code string, cookie *http.Cookie) string {
+		form := url.Values{}
+		form.Set("code", code)
+		form.Set("returnUrl", profileURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/redeem-gift-code", form, []http.Cookie{*cookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, profileURL, rec.Header().Get("Location"))
+		return getErrorMessage(rec)
+	}
+
+	{
+		// Create a batch of single-use codes from the admin page
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		assert.Nil(t, writer.WriteField("name", "Summer"))
+		assert.Nil(t, writer.WriteField("count", "2"))
+		assert.Nil(t, writer.WriteField("maxUses", "1"))
+		assert.Nil(t, writer.WriteField("returnUrl", adminURL))
+		capeFileField, err := writer.CreateFormFile("capeFile", "cape.png")
+		assert.Nil(t, err)
+		_, err = capeFileField.Write(RED_CAPE)
+		assert.Nil(t, err)
+		assert.Nil(t, writer.Close())
+
+		// Non-admins can't
+		rec := ts.PostMultipart(t, ts.Server, "/drasl/admin/new-gift-codes", bytes.NewBuffer(body.Bytes()), writer, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+
+		rec = ts.PostMultipart(t, ts.Server, "/drasl/admin/new-gift-codes", body, writer, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, adminURL, rec.Header().Get("Location"))
+	}
+	giftCodes, err := ts.App.GetGiftCodes()
+	assert.Nil(t, err)
+	assert.Len(t, giftCodes, 2)
+	capeHash := giftCodes[0].CapeHash
+
+	rec := ts.Get(t, ts.Server, "/drasl/admin", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), giftCodes[0].Code)
+
+	rec = ts.Get(t, ts.Server, "/drasl/admin/gift-codes/export?name=Summer", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), giftCodes[0].Code+"\n")
+	assert.Contains(t, rec.Body.String(), giftCodes[1].Code+"\n")
+
+	{
+		// Codes are accepted in lowercase and without dashes
+		code := strings.ToLower(strings.ReplaceAll(giftCodes[0].Code, "-", ""))
+		assert.Equal(t, "", redeem(code, browserTokenCookie))
+
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+		assert.Equal(t, capeHash, *UnmakeNullString(&user.CapeHash))
+
+		assert.Equal(t, "You have already redeemed that code.", redeem(giftCodes[0].Code, browserTokenCookie))
+		assert.Equal(t, "That code has already been used up.", redeem(giftCodes[0].Code, otherBrowserTokenCookie))
+		assert.Equal(t, "That code doesn't exist or has expired.", redeem("AAAA-AAAA-AAAA", otherBrowserTokenCookie))
+	}
+	{
+		// Expired codes can't be redeemed
+		expiresAt := time.Now().Add(-time.Minute)
+		expiredCodes, err := ts.App.CreateGiftCodes("Expired", bytes.NewReader(RED_CAPE), 1, 0, &expiresAt)
+		assert.Nil(t, err)
+		assert.Equal(t, "That code doesn't exist or has expired.", redeem(expiredCodes[0].Code, otherBrowserTokenCookie))
+		_, err = ts.App.DeleteGiftCodes("Expired")
+		assert.Nil(t, err)
+	}
+	{
+		// Deleting the codes keeps the cape of players who redeemed them
+		form := url.Values{}
+		form.Set("name", "Summer")
+		form.Set("returnUrl", adminURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/delete-gift-codes", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		giftCodes, err := ts.App.GetGiftCodes()
+		assert.Nil(t, err)
+		assert.Empty(t, giftCodes)
+		_, err = os.Stat(GetCapePath(ts.App, capeHash))
+		assert.Nil(t, err)
+
+		var redemptions int64
+		assert.Nil(t, ts.App.DB.Model(&GiftCodeRedemption{}).Count(&redemptions).Error)
+		assert.Equal(t, int64(0), redemptions)
+	}
+}
+
+// The settings form as submitted without changes
+func settingsForm(config *Config, returnURL string) url.Values {
+	checkbox := func(checked bool) string {
+		if checked {
+			return "on"
+		}
+		return ""
+	}
+	form := url.Values{}
+	form.Set("returnUrl", returnURL)
+	form.Set("registrationNewPlayerAllow", checkbox(config.RegistrationNewPlayer.Allow))
+	form.Set("registrationNewPlayerAllowChoosingUUID", checkbox(config.RegistrationNewPlayer.AllowChoosingUUID))
+	form.Set("registrationNewPlayerRequireInvite", checkbox(config.RegistrationNewPlayer.RequireInvite))
+	form.Set("registrationNewPlayerRequireApproval", checkbox(config.RegistrationNewPlayer.RequireApproval))
+	form.Set("registrationExistingPlayerAllow", checkbox(config.RegistrationExistingPlayer.Allow))
+	form.Set("registrationExistingPlayerRequireInvite", checkbox(config.RegistrationExistingPlayer.RequireInvite))
+	form.Set("registrationExistingPlayerRequireApproval", checkbox(config.RegistrationExistingPlayer.RequireApproval))
+	form.Set("rateLimitEnable", checkbox(config.RateLimit.Enable))
+	form.Set("rateLimitRequestsPerSecond", strconv.FormatFloat(config.RateLimit.RequestsPerSecond, 'f', -1, 64))
+	form.Set("allowSkins", checkbox(config.AllowSkins))
+	form.Set("allowCapes", checkbox(config.AllowCapes))
+	form.Set("skinSizeLimit", strconv.Itoa(config.SkinSizeLimit))
+	return form
+}
+
+func (ts *TestSuite) testSettings(t *testing.T) {
+	returnURL := ts.App.FrontEndURL + "/drasl/admin/settings"
+
+	username := "settingsAdmin"
+	browserTokenCookie := ts.CreateTestUser(ts.Server, username)
+	otherUsername := "settingsOther"
+	otherBrowserTokenCookie := ts.CreateTestUser(ts.Server, otherUsername)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", username).Error)
+	user.IsAdmin = true
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	rec := ts.Get(t, ts.Server, "/drasl/admin/settings", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	fileConfig := ts.App.FileConfig
+	{
+		// Non-admins should not be able to change settings
+		form := settingsForm(ts.App.Config(), returnURL)
+		form.Set("allowCapes", "")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", form, []http.Cookie{*otherBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+		assert.True(t, ts.App.Config().AllowCapes)
+	}
+	{
+		// Invalid settings should be rejected
+		form := settingsForm(ts.App.Config(), returnURL)
+		form.Set("rateLimitEnable", "on")
+		form.Set("rateLimitRequestsPerSecond", "0")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "Invalid settings: Invalid RateLimit.RequestsPerSecond 0: must be positive", getErrorMessage(rec))
+		assert.False(t, ts.App.Config().RateLimit.Enable)
+	}
+	{
+		form := settingsForm(ts.App.Config(), returnURL)
+		form.Set("allowCapes", "")
+		form.Set("skinSizeLimit", "256")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Equal(t, returnURL, rec.Header().Get("Location"))
+
+		// The changes apply right away and are stored as overrides
+		assert.False(t, ts.App.Config().AllowCapes)
+		assert.Equal(t, 256, ts.App.Config().SkinSizeLimit)
+		assert.Equal(t, fileConfig.SkinSizeLimit, ts.App.FileConfig.SkinSizeLimit)
+		overrides := Unwrap(GetConfigOverrides(ts.App.DB))
+		assert.Equal(t, 2, len(overrides))
+
+		auditLog := Unwrap(ts.App.GetAuditLog(1))
+		assert.Equal(t, AuditActionUpdateSettings, auditLog[0].Action)
+		assert.Equal(t, "AllowCapes, SkinSizeLimit", auditLog[0].Details)
+
+		// They're applied on top of the config file at startup
+		config := Unwrap(ApplyConfigOverrides(&ts.App.FileConfig, overrides))
+		assert.False(t, config.AllowCapes)
+		assert.Equal(t, 256, config.SkinSizeLimit)
+	}
+	{
+		// Setting options back to their values in the config file removes
+		// their overrides
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/update-settings", settingsForm(&fileConfig, returnURL), []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.True(t, ts.App.Config().AllowCapes)
+		assert.Equal(t, fileConfig.SkinSizeLimit, ts.App.Config().SkinSizeLimit)
+		assert.Equal(t, 0, len(Unwrap(GetConfigOverrides(ts.App.DB))))
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"lukechampine.com/blake3"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Integrity checks for texture storage. Skins and capes are stored as
+<StateDirectory>/{skin,cape}/<blake3 hash>.png and referenced by hash from
+users, library skins, appearance snapshots, gift codes, and cosmetics, so a
+file is corrupt if its contents don't hash to its name, and a reference is
+dangling if its file is missing or corrupt. Run by `drasl fsck` and, if
+TextureCheck.Enable is set, periodically.
+*/
+
+// A column that refers to textures of some kind by hash
+type textureReference struct {
+	Model  any
+	Column string
+	// Extra condition a row must meet, e.g. to pick out capes among cosmetics
+	Where string
+	Args  []any
+	// Whether the column can be cleared if its texture is lost. Library
+	// skins, gift codes, and cosmetics can't be without a texture, so they
+	// are left for an admin to replace.
+	Nullable bool
+}
+
+func (reference *textureReference) query(db *gorm.DB) *gorm.DB {
+	query := db.Model(reference.Model)
+	if reference.Where != "" {
+		query = query.Where(reference.Where, reference.Args...)
+	}
+	return query
+}
+
+type textureKind struct {
+	Name string
+	// Column of users and appearance snapshots
+	Column     string
+	Path       func(app *App, hash string) string
+	References []textureReference
+}
+
+var SKIN_TEXTURE_KIND = textureKind{
+	Name:   "skin",
+	Column: "skin_hash",
+	Path:   GetSkinPath,
+	References: []textureReference{
+		{Model: &User{}, Column: "skin_hash", Nullable: true},
+		{Model: &LibrarySkin{}, Column: "skin_hash"},
+		{Model: &AppearanceSnapshot{}, Column: "skin_hash", Nullable: true},
+	},
+}
+
+var CAPE_TEXTURE_KIND = textureKind{
+	Name:   "cape",
+	Column: "cape_hash",
+	Path:   GetCapePath,
+	References: []textureReference{
+		{Model: &User{}, Column: "cape_hash", Nullable: true},
+		{Model: &GiftCode{}, Column: "cape_hash"},
+		{Model: &Cosmetic{}, Column: "texture_hash", Where: "kind = ?", Args: []any{COSMETIC_KIND_CAPE}},
+		{Model: &AppearanceSnapshot{}, Column: "cape_hash", Nullable: true},
+	},
+}
+
+var TEXTURE_KINDS = []textureKind{SKIN_TEXTURE_KIND, CAPE_TEXTURE_KIND}
+
+// Count everything that refers to the texture
+func (app *App) CountTextureReferences(kind *textureKind, hash string) (int64, error) {
+	var total int64
+	for i := range kind.References {
+		reference := &kind.References[i]
+		var count int64
+		err := reference.query(app.DB).Where(reference.Column+" = ?", hash).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Hashes of all textures of the kind that something refers to
+func (app *App) referencedTextureHashes(kind *textureKind) ([]string, error) {
+	seen := map[string]bool{}
+	hashes := []string{}
+	for i := range kind.References {
+		reference := &kind.References[i]
+		var referenceHashes []string
+		err := reference.query(app.DB).Where(reference.Column+" IS NOT NULL").Distinct().Pluck(reference.Column, &referenceHashes).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range referenceHashes {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	return hashes, nil
+}
+
+type FsckProblem struct {
+	Kind string
+	Hash string
+	// Whether the file is missing, as opposed to corrupt
+	Missing bool
+	// Number of users, library skins, etc. referencing the texture
+	References int64
+	Restored   bool
+	Cleared    bool
+}
+
+func (problem *FsckProblem) String() string {
+	var s string
+	if problem.Missing {
+		s = fmt.Sprintf("%s %s is missing", problem.Kind, problem.Hash)
+	} else {
+		s = fmt.Sprintf("%s %s is corrupt", problem.Kind, problem.Hash)
+	}
+	if problem.References > 0 {
+		s += fmt.Sprintf(", referenced %d times", problem.References)
+	}
+	if problem.Restored {
+		s += "; restored from backup"
+	} else if problem.Cleared {
+		s += "; references cleared"
+	}
+	return s
+}
+
+type FsckReport struct {
+	Checked  int
+	Problems []FsckProblem
+}
+
+func hashTextureFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := blake3.New(32, nil)
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Look for an intact copy of the texture in TextureCheck.BackupDirectories,
+// which are laid out like StateDirectory
+func (app *App) findTextureBackup(kind *textureKind, hash string) ([]byte, error) {
+	for _, backupDirectory := range app.Config().TextureCheck.BackupDirectories {
+		backupPath := path.Join(backupDirectory, kind.Name, hash+".png")
+		contents, err := os.ReadFile(backupPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sum := blake3.Sum256(contents)
+		if hex.EncodeToString(sum[:]) == hash {
+			return contents, nil
+		}
+	}
+	return nil, nil
+}
+
+func (app *App) repairTexture(kind *textureKind, problem *FsckProblem) error {
+	texturePath := kind.Path(app, problem.Hash)
+	unlock := app.FSMutex.Lock(texturePath)
+	defer unlock()
+
+	contents, err := app.findTextureBackup(kind, problem.Hash)
+	if err != nil {
+		return err
+	}
+	if contents != nil {
+		if err := os.MkdirAll(path.Dir(texturePath), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.WriteFile(texturePath, contents, 0644); err != nil {
+			return err
+		}
+		problem.Restored = true
+		return nil
+	}
+
+	// No backup, so the texture is lost. Players fall back to the default
+	// skin or no cape.
+	if !problem.Missing {
+		if err := os.Remove(texturePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for i := range kind.References {
+		reference := &kind.References[i]
+		if !reference.Nullable {
+			continue
+		}
+		err := reference.query(app.DB).Where(reference.Column+" = ?", problem.Hash).Update(reference.Column, nil).Error
+		if err != nil {
+			return err
+		}
+	}
+	problem.Cleared = true
+	return nil
+}
+
+// Check every texture file and every texture reference. If repair is set,
+// restore missing and corrupt textures from backups, or clear references to
+// them if there's no backup.
+func (app *App) Fsck(repair bool) (*FsckReport, error) {
+	report := FsckReport{}
+	for i := range TEXTURE_KINDS {
+		kind := &TEXTURE_KINDS[i]
+		problems := map[string]*FsckProblem{}
+
+		directory := path.Join(app.Config().StateDirectory, kind.Name)
+		entries, err := os.ReadDir(directory)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !strings.HasSuffix(name, ".png") {
+				continue
+			}
+			hash := strings.TrimSuffix(name, ".png")
+			actualHash, err := hashTextureFile(path.Join(directory, name))
+			if err != nil {
+				return nil, err
+			}
+			report.Checked += 1
+			if actualHash != hash {
+				problems[hash] = &FsckProblem{Kind: kind.Name, Hash: hash}
+			}
+		}
+
+		hashes, err := app.referencedTextureHashes(kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range hashes {
+			if _, err := os.Stat(kind.Path(app, hash)); os.IsNotExist(err) {
+				problems[hash] = &FsckProblem{Kind: kind.Name, Hash: hash, Missing: true}
+			} else if err != nil {
+				return nil, err
+			}
+		}
+
+		problemHashes := make([]string, 0, len(problems))
+		for hash := range problems {
+			problemHashes = append(problemHashes, hash)
+		}
+		sort.Strings(problemHashes)
+		for _, hash := range problemHashes {
+			problem := problems[hash]
+			problem.References, err = app.CountTextureReferences(kind, hash)
+			if err != nil {
+				return nil, err
+			}
+			if repair {
+				if err := app.repairTexture(kind, problem); err != nil {
+					return nil, err
+				}
+			}
+			report.Problems = append(report.Problems, *problem)
+		}
+	}
+	return &report, nil
+}
+
+func (report *FsckReport) String() string {
+	var buf bytes.Buffer
+	for _, problem := range report.Problems {
+		buf.WriteString(problem.String() + "\n")
+	}
+	buf.WriteString(fmt.Sprintf("Checked %d texture files, found %d problems", report.Checked, len(report.Problems)))
+	return buf.String()
+}
+
+// Run Fsck every TextureCheck.IntervalHours, logging any problems found
+func (app *App) RunScheduledFsck() {
+	interval := time.Duration(app.Config().TextureCheck.IntervalHours) * time.Hour
+	for {
+		report, err := app.Fsck(app.Config().TextureCheck.Repair)
+		if err != nil {
+			log.Printf("Couldn't check texture storage: %s\n", err)
+		} else if len(report.Problems) > 0 {
+			log.Println(report.String())
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"lukechampine.com/blake3"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		backupDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(backupDirectory)
+
+		config := testConfig()
+		config.TextureCheck.BackupDirectories = []string{backupDirectory}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test fsck", func(t *testing.T) { ts.testFsck(t, backupDirectory) })
+	}
+}
+
+func (ts *TestSuite) testFsck(t *testing.T, backupDirectory string) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	assert.Nil(t, SetCapeAndSave(ts.App, &user, bytes.NewReader(RED_CAPE)))
+	skinHash := user.SkinHash.String
+	capeHash := user.CapeHash.String
+	skinPath := GetSkinPath(ts.App, skinHash)
+	capePath := GetCapePath(ts.App, capeHash)
+
+	report, err := ts.App.Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Checked)
+	assert.Empty(t, report.Problems)
+
+	// Back up the skin, then corrupt it and delete the cape
+	assert.Nil(t, os.MkdirAll(path.Join(backupDirectory, "skin"), 0700))
+	assert.Nil(t, os.WriteFile(path.Join(backupDirectory, "skin", skinHash+".png"), RED_SKIN, 0600))
+	assert.Nil(t, os.WriteFile(skinPath, BLUE_SKIN, 0600))
+	assert.Nil(t, os.Remove(capePath))
+
+	report, err = ts.App.Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Checked)
+	assert.Equal(t, []FsckProblem{
+		{Kind: "skin", Hash: skinHash, References: 1},
+		{Kind: "cape", Hash: capeHash, Missing: true, References: 1},
+	}, report.Problems)
+
+	// Without repair, nothing should change
+	assert.Equal(t, BLUE_SKIN, Unwrap(os.ReadFile(skinPath)))
+
+	report, err = ts.App.Fsck(true)
+	assert.Nil(t, err)
+	assert.Len(t, report.Problems, 2)
+	assert.True(t, report.Problems[0].Restored)
+	assert.True(t, report.Problems[1].Cleared)
+
+	// The skin should be restored from the backup, and the reference to the
+	// cape cleared
+	assert.Equal(t, RED_SKIN, Unwrap(os.ReadFile(skinPath)))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, skinHash, user.SkinHash.String)
+	assert.False(t, user.CapeHash.Valid)
+
+	report, err = ts.App.Fsck(false)
+	assert.Nil(t, err)
+	assert.Empty(t, report.Problems)
+
+	// Textures are also referenced from outside the users table, e.g. by
+	// skins in users' libraries
+	sum := blake3.Sum256(BLUE_SKIN)
+	libraryHash := hex.EncodeToString(sum[:])
+	assert.Nil(t, ts.App.DB.Create(&LibrarySkin{
+		ID:        "fsck-library-skin",
+		UserUUID:  user.UUID,
+		SkinHash:  libraryHash,
+		SkinModel: SkinModelClassic,
+	}).Error)
+	report, err = ts.App.Fsck(false)
+	assert.Nil(t, err)
+	assert.Equal(t, []FsckProblem{
+		{Kind: "skin", Hash: libraryHash, Missing: true, References: 1},
+	}, report.Problems)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"gorm.io/gorm"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+Gift codes let admins hand out capes for events and giveaways. An admin mints
+a batch of codes for a cape, optionally limiting how many times each code can
+be used and until when, and players redeem a code from their profile page to
+get the cape.
+*/
+
+// Long enough that codes can't be guessed, even with the rate limiter off
+const GIFT_CODE_LENGTH = 12
+
+// Limits the size of a batch so a typo can't fill the database
+const MAX_GIFT_CODE_BATCH_SIZE = 1000
+
+var errGiftCodeNotFound = errors.New("gift code not found")
+var errGiftCodeUsedUp = errors.New("gift code used up")
+var errGiftCodeAlreadyRedeemed = errors.New("gift code already redeemed")
+
+func makeGiftCode() (string, error) {
+	code, err := randomUserCodeLetters(GIFT_CODE_LENGTH)
+	if err != nil {
+		return "", err
+	}
+	return string(code[0:4]) + "-" + string(code[4:8]) + "-" + string(code[8:12]), nil
+}
+
+// Players may type the code in lowercase or without the dashes
+func NormalizeGiftCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if strings.ContainsRune(DEVICE_USER_CODE_ALPHABET, r) {
+			b.WriteRune(r)
+		}
+	}
+	normalized := b.String()
+	if len(normalized) != GIFT_CODE_LENGTH {
+		return normalized
+	}
+	return normalized[0:4] + "-" + normalized[4:8] + "-" + normalized[8:12]
+}
+
+// Mint `count` codes for the cape read from `capeReader`. Each code can be
+// redeemed `maxUses` times, or any number of times if `maxUses` is 0, until
+// `expiresAt`, if it's not nil.
+func (app *App) CreateGiftCodes(name string, capeReader io.Reader, count int, maxUses int, expiresAt *time.Time) ([]GiftCode, error) {
+	if count <= 0 || count > MAX_GIFT_CODE_BATCH_SIZE {
+		return nil, errors.New("invalid number of codes")
+	}
+	if maxUses < 0 {
+		return nil, errors.New("invalid number of uses")
+	}
+
+	validCapeHandle, err := ValidateCape(app, capeReader)
+	if err != nil {
+		return nil, err
+	}
+	buf, capeHash, err := ReadTexture(app, validCapeHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	nullExpiresAt := sql.NullTime{}
+	if expiresAt != nil {
+		nullExpiresAt = sql.NullTime{Time: *expiresAt, Valid: true}
+	}
+
+	giftCodes := make([]GiftCode, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := makeGiftCode()
+		if err != nil {
+			return nil, err
+		}
+		giftCodes = append(giftCodes, GiftCode{
+			Code:      code,
+			Name:      name,
+			CapeHash:  capeHash,
+			MaxUses:   maxUses,
+			ExpiresAt: nullExpiresAt,
+			CreatedAt: time.Now(),
+		})
+	}
+
+	if err := WriteCape(app, capeHash, buf); err != nil {
+		return nil, err
+	}
+	if err := app.DB.Create(&giftCodes).Error; err != nil {
+		return nil, err
+	}
+	return giftCodes, nil
+}
+
+// Delete every code in the batch called `name`
+func (app *App) DeleteGiftCodes(name string) (int64, error) {
+	var capeHashes []string
+	if err := app.DB.Model(&GiftCode{}).Where("name = ?", name).Distinct().Pluck("cape_hash", &capeHashes).Error; err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		codes := tx.Model(&GiftCode{}).Select("code").Where("name = ?", name)
+		if err := tx.Where("code IN (?)", codes).Delete(&GiftCodeRedemption{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("name = ?", name).Delete(&GiftCode{})
+		count = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, capeHash := range capeHashes {
+		if err := DeleteCapeIfUnused(app, &capeHash); err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (app *App) GetGiftCodes() ([]GiftCode, error) {
+	var giftCodes []GiftCode
+	err := app.DB.Order("name, created_at, code").Find(&giftCodes).Error
+	return giftCodes, err
+}
+
+// Give the code's cape to `user`. Expired codes are treated as nonexistent.
+func (app *App) RedeemGiftCode(user *User, code string) (*GiftCode, error) {
+	if user.CapeLocked {
+		return nil, errCapeLocked
+	}
+	code = NormalizeGiftCode(code)
+	oldCapeHash := UnmakeNullString(&user.CapeHash)
+
+	var giftCode GiftCode
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.First(&giftCode, "code = ? AND (expires_at IS NULL OR expires_at > ?)", code, time.Now()).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errGiftCodeNotFound
+			}
+			return err
+		}
+
+		var redeemed int64
+		if err := tx.Model(&GiftCodeRedemption{}).Where("code = ? AND user_uuid = ?", code, user.UUID).Count(&redeemed).Error; err != nil {
+			return err
+		}
+		if redeemed > 0 {
+			return errGiftCodeAlreadyRedeemed
+		}
+
+		// Check and count the use in one statement so concurrent
+		// redemptions can't exceed MaxUses
+		result := tx.Model(&GiftCode{}).
+			Where("code = ? AND (max_uses = 0 OR uses < max_uses)", code).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errGiftCodeUsedUp
+		}
+
+		if err := tx.Create(&GiftCodeRedemption{Code: code, UserUUID: user.UUID, RedeemedAt: time.Now()}).Error; err != nil {
+			return err
+		}
+
+		user.CapeHash = MakeNullString(&giftCode.CapeHash)
+		return tx.Save(user).Error
+	})
+	if err != nil {
+		user.CapeHash = MakeNullString(oldCapeHash)
+		return nil, err
+	}
+
+	if err := app.RecordAppearance(user.UUID); err != nil {
+		return nil, err
+	}
+	if !PtrEquals(oldCapeHash, &giftCode.CapeHash) {
+		if err := DeleteCapeIfUnused(app, oldCapeHash); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return &giftCode, nil
+}
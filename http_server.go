@@ -0,0 +1,45 @@
+package main
+
+import (
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+Tuning of the HTTP server Drasl listens with, from HTTPServer. Timeouts keep
+slow or idle clients from holding connections open forever, at the risk of
+cutting off launchers on slow connections if they're too short. Drasl is
+usually served over plain HTTP behind a reverse proxy, so HTTP/2 is HTTP/2
+over cleartext (h2c), for proxies that can talk to their upstreams with it.
+*/
+
+func MakeHTTPServer(config *Config, handler http.Handler) *http.Server {
+	serverConfig := &config.HTTPServer
+	seconds := func(sec int) time.Duration {
+		return time.Duration(sec) * time.Second
+	}
+	if serverConfig.EnableHTTP2 {
+		handler = h2c.NewHandler(handler, &http2.Server{
+			MaxConcurrentStreams: uint32(serverConfig.MaxConcurrentStreams),
+			IdleTimeout:          seconds(serverConfig.IdleTimeoutSec),
+		})
+	}
+	return &http.Server{
+		Addr:              config.ListenAddress,
+		Handler:           handler,
+		ReadTimeout:       seconds(serverConfig.ReadTimeoutSec),
+		ReadHeaderTimeout: seconds(serverConfig.ReadHeaderTimeoutSec),
+		WriteTimeout:      seconds(serverConfig.WriteTimeoutSec),
+		IdleTimeout:       seconds(serverConfig.IdleTimeoutSec),
+		MaxHeaderBytes:    serverConfig.MaxHeaderKiB * 1024,
+	}
+}
+
+func listenAndServe(config *Config, handler http.Handler) {
+	server := MakeHTTPServer(config, handler)
+	log.Printf("Listening on %s\n", server.Addr)
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	config := testConfig()
+	config.HTTPServer.ReadTimeoutSec = 30
+	server := MakeHTTPServer(config, handler)
+	assert.Equal(t, 30*time.Second, server.ReadTimeout)
+	assert.Equal(t, 10*time.Second, server.ReadHeaderTimeout)
+	assert.Equal(t, time.Duration(0), server.WriteTimeout)
+	assert.Equal(t, 1024*1024, server.MaxHeaderBytes)
+
+	serve := func(server *http.Server) string {
+		listener := Unwrap(net.Listen("tcp", "127.0.0.1:0"))
+		go server.Serve(listener)
+		t.Cleanup(func() { server.Close() })
+		return "http://" + listener.Addr().String() + "/"
+	}
+
+	// HTTP/2 over cleartext, as a reverse proxy would speak it
+	h2cClient := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+
+	serverURL := serve(MakeHTTPServer(config, handler))
+	res, err := http.Get(serverURL)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/1.1", res.Header.Get("X-Proto"))
+	_, err = h2cClient.Get(serverURL)
+	assert.NotNil(t, err)
+
+	config.HTTPServer.EnableHTTP2 = true
+	serverURL = serve(MakeHTTPServer(config, handler))
+	res, err = h2cClient.Get(serverURL)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/2.0", res.Header.Get("X-Proto"))
+
+	// HTTP/1.1 still works
+	res, err = http.Get(serverURL)
+	assert.Nil(t, err)
+	res.Body.Close()
+	assert.Equal(t, "HTTP/1.1", res.Header.Get("X-Proto"))
+}
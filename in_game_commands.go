@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+/*
+Account commands that players run in game, for servers whose players rarely
+visit the website. A companion plugin on a server in TrustedServers reports
+the command to /drasl/api/v2/server/commands, along with the server ID the
+player joined it with, and Drasl answers with a short confirmation code for
+the plugin to show the player. The player confirms by entering the code, e.g.
+`/drasl confirm ABCDEF`, and the plugin sends it, along with the new password
+if they're changing it, to /drasl/api/v2/server/commands/confirm. Confirming
+must happen on the same server, before the code expires, within a few
+attempts. A player has at most one command waiting; starting another replaces
+it.
+*/
+
+const (
+	// Set a new password, which also logs the player out everywhere
+	InGameCommandChangePassword string = "change-password"
+	// Invalidate every access token and browser session, so launchers and
+	// browsers have to sign in again
+	InGameCommandRegenerateTokens string = "regenerate-tokens"
+)
+
+var IN_GAME_COMMANDS = []string{InGameCommandChangePassword, InGameCommandRegenerateTokens}
+
+const IN_GAME_COMMAND_CODE_LENGTH = 6
+
+// Wrong codes allowed before the command is cancelled
+const MAX_IN_GAME_COMMAND_ATTEMPTS = 5
+
+// Why a command couldn't be carried out, meant to be shown to the player
+type InGameCommandError struct {
+	Message string
+}
+
+func (err *InGameCommandError) Error() string {
+	return err.Message
+}
+
+var errInGameCommandNotFound = &InGameCommandError{Message: "You have no command waiting to be confirmed, or its code has expired."}
+var errInGameCommandWrongCode = &InGameCommandError{Message: "That confirmation code is wrong."}
+
+// Start a command for the user, to be confirmed with the returned command's
+// Code on the same trusted server
+func (app *App) StartInGameCommand(user *User, action string, trustedServer *TrustedServer) (*PendingInGameCommand, error) {
+	now := time.Now()
+	if err := app.DB.Where("expires_at < ?", now).Delete(&PendingInGameCommand{}).Error; err != nil {
+		return nil, err
+	}
+
+	code, err := randomUserCodeLetters(IN_GAME_COMMAND_CODE_LENGTH)
+	if err != nil {
+		return nil, err
+	}
+	command := PendingInGameCommand{
+		UserUUID:      user.UUID,
+		Action:        action,
+		Code:          string(code),
+		TrustedServer: trustedServer.Nickname,
+		ExpiresAt:     now.Add(time.Duration(app.Config().InGameCommands.CodeExpireSec) * time.Second),
+	}
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_uuid = ?", user.UUID).Delete(&PendingInGameCommand{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&command).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+// The user's command waiting to be confirmed on the trusted server, if its
+// code is right. A wrong code counts against MAX_IN_GAME_COMMAND_ATTEMPTS.
+func (app *App) checkInGameCommandCode(user *User, code string, trustedServer *TrustedServer) (*PendingInGameCommand, error) {
+	var command PendingInGameCommand
+	err := app.DB.First(&command, "user_uuid = ? AND trusted_server = ? AND expires_at > ?", user.UUID, trustedServer.Nickname, time.Now()).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errInGameCommandNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if command.Code != strings.ToUpper(strings.TrimSpace(code)) {
+		command.Attempts += 1
+		if command.Attempts >= MAX_IN_GAME_COMMAND_ATTEMPTS {
+			err = app.DB.Delete(&command).Error
+		} else {
+			err = app.DB.Model(&command).Update("attempts", command.Attempts).Error
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, errInGameCommandWrongCode
+	}
+	return &command, nil
+}
+
+// Carry out the user's command once they've confirmed it with its code.
+// `password` is the new password, for InGameCommandChangePassword. Either
+// command logs the user out of every launcher and browser.
+func (app *App) ConfirmInGameCommand(user *User, code string, password string, trustedServer *TrustedServer) (*PendingInGameCommand, error) {
+	command, err := app.checkInGameCommandCode(user, code, trustedServer)
+	if err != nil {
+		return nil, err
+	}
+
+	if command.Action == InGameCommandChangePassword {
+		if err := ValidatePassword(app, password); err != nil {
+			return nil, &InGameCommandError{Message: "Invalid password: " + err.Error() + "."}
+		}
+		if err := SetPassword(user, password); err != nil {
+			return nil, err
+		}
+	}
+	user.BrowserToken = MakeNullString(nil)
+
+	err = app.DB.Transaction(func(tx *gorm.DB) error {
+		// Each code can only be used once, even by requests made at the same
+		// time
+		result := tx.Delete(command)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInGameCommandNotFound
+		}
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return tx.Model(Client{}).Where("user_uuid = ?", user.UUID).Update("version", gorm.Expr("version + ?", 1)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return command, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestInGameCommands(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.InGameCommands.Allow = true
+		config.TrustedServers = []TrustedServer{
+			{Nickname: "survival", Token: "survival-token-0123456789"},
+			{Nickname: "creative", Token: "creative-token-0123456789"},
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test in-game commands", ts.testInGameCommands)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.TrustedServers = []TrustedServer{{Nickname: "survival", Token: "survival-token-0123456789"}}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test in-game commands not allowed", ts.testInGameCommandsNotAllowed)
+	}
+}
+
+func (ts *TestSuite) startInGameCommand(t *testing.T, uuid string, action string, serverID string, serverToken string) apiServerCommandResponse {
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: uuid, Action: action, ServerID: serverID}, nil, &serverToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response apiServerCommandResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	return response
+}
+
+func (ts *TestSuite) testInGameCommands(t *testing.T) {
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	authenticateRes := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD)
+
+	survivalToken := "survival-token-0123456789"
+	creativeToken := "creative-token-0123456789"
+	confirm := func(code string, password string, serverToken string) (int, string) {
+		rec := ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands/confirm", apiServerConfirmCommandRequest{
+			UUID:     user.UUID,
+			Code:     code,
+			Password: password,
+		}, nil, &serverToken)
+		if rec.Code == http.StatusOK {
+			return rec.Code, ""
+		}
+		var response ErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		return rec.Code, *response.ErrorMessage
+	}
+
+	// Only the known actions, and only for players with an account
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: user.UUID, Action: "delete-account"}, nil, &survivalToken)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: "00000000-0000-0000-0000-000000000000", Action: InGameCommandChangePassword}, nil, &survivalToken)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: user.UUID, Action: InGameCommandChangePassword}, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Only the server the player joined can start a command for them
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: user.UUID, Action: InGameCommandChangePassword}, nil, &survivalToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	serverID := "survival-server-id"
+	rec = ts.PostJSON(t, ts.Server, "/session/minecraft/join", sessionJoinRequest{
+		AccessToken:     authenticateRes.AccessToken,
+		SelectedProfile: Unwrap(UUIDToID(user.UUID)),
+		ServerID:        serverID,
+	}, nil, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: user.UUID, Action: InGameCommandChangePassword, ServerID: "creative-server-id"}, nil, &creativeToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	command := ts.startInGameCommand(t, user.UUID, InGameCommandChangePassword, serverID, survivalToken)
+	assert.Equal(t, InGameCommandChangePassword, command.Action)
+	assert.Equal(t, IN_GAME_COMMAND_CODE_LENGTH, len(command.Code))
+
+	// The code only works on the server the command was run on
+	status, message := confirm(command.Code, "hunter2-but-longer", creativeToken)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInGameCommandNotFound.Message, message)
+
+	status, message = confirm(command.Code, "", survivalToken)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Contains(t, message, "Invalid password")
+
+	status, message = confirm(command.Code, "hunter2-but-longer", survivalToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "", message)
+
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	ok, err := ts.App.CheckPassword(&user, "hunter2-but-longer")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.False(t, user.BrowserToken.Valid)
+	// The launcher has to sign in again
+	assert.Nil(t, ts.App.GetClient(authenticateRes.AccessToken, StalePolicyDeny))
+	authenticateRes = ts.authenticate(t, TEST_USERNAME, "hunter2-but-longer")
+
+	// Each code works once
+	status, message = confirm(command.Code, "hunter2-but-longer", survivalToken)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInGameCommandNotFound.Message, message)
+
+	// Too many wrong codes cancel the command
+	command = ts.startInGameCommand(t, user.UUID, InGameCommandRegenerateTokens, serverID, survivalToken)
+	for i := 0; i < MAX_IN_GAME_COMMAND_ATTEMPTS; i += 1 {
+		status, message = confirm("WRONG", "", survivalToken)
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, errInGameCommandWrongCode.Message, message)
+	}
+	status, message = confirm(command.Code, "", survivalToken)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, errInGameCommandNotFound.Message, message)
+
+	// Regenerating tokens leaves the password alone
+	command = ts.startInGameCommand(t, user.UUID, InGameCommandRegenerateTokens, serverID, survivalToken)
+	status, _ = confirm(command.Code, "", survivalToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	ok, err = ts.App.CheckPassword(&user, "hunter2-but-longer")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, ts.App.GetClient(authenticateRes.AccessToken, StalePolicyDeny))
+}
+
+func (ts *TestSuite) testInGameCommandsNotAllowed(t *testing.T) {
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	serverToken := "survival-token-0123456789"
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v2/server/commands", apiServerCommandRequest{UUID: user.UUID, Action: InGameCommandChangePassword}, nil, &serverToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
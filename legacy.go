@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+The legacy, pre-Yggdrasil authentication protocol used by Minecraft Alpha
+and Beta, 1.0 through 1.5, and some old mod launchers. Responses are plain
+text. The launcher logs in at /legacy/login and passes the session ID it gets
+to the game, which joins a server through /game/joinserver.jsp; the server
+then checks the join through /game/checkserver.jsp. Session IDs are ordinary
+access tokens, so they can also be used with the Yggdrasil API.
+https://c4k3.github.io/wiki.vg/Legacy_Authentication.html
+*/
+
+// Sent in place of the game version and download ticket, which launchers of
+// the time used to decide whether to update the game
+const LEGACY_LOGIN_VERSION = "1343825972000:deprecated"
+
+const (
+	legacyBadLogin      = "Bad login"
+	legacyJoinOK        = "OK"
+	legacyCheckJoined   = "YES"
+	legacyCheckNotFound = "NO"
+)
+
+// The access token in a session ID passed to /game/joinserver.jsp. Clients
+// since 1.3 send `token:<accessToken>:<profile ID>`, older ones only the
+// token.
+func legacySessionIDToAccessToken(sessionID string) string {
+	if strings.HasPrefix(sessionID, "token:") {
+		split := strings.Split(sessionID, ":")
+		if len(split) == 3 {
+			return split[1]
+		}
+	}
+	return sessionID
+}
+
+// POST /legacy/login
+// Form parameters user, password, and version. Only the request body is read,
+// so passwords don't end up in URLs and access logs.
+func LegacyLogin(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().LegacyAuthentication.Enable {
+			return echo.ErrNotFound
+		}
+
+		throttle := app.AuthenticateThrottle
+		if throttle != nil && !IsRateLimitExempt(app, c.RealIP()) && !throttle.AllowIP(RateLimitKey(app, c.RealIP())) {
+			app.IncrementStat(StatAuthenticateThrottled)
+			return c.String(http.StatusTooManyRequests, "Too many requests. Try again later.")
+		}
+
+		username := c.Request().PostFormValue("user")
+		password := c.Request().PostFormValue("password")
+
+		var user User
+		err := FindUserByUsernameOrPlayerName(app.DB.Preload("Clients"), &user, username)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.String(http.StatusOK, legacyBadLogin)
+			}
+			return err
+		}
+
+		if throttle != nil {
+			if delay := throttle.Delay(user.UUID); delay > 0 {
+				app.IncrementStat(StatAuthenticateTarpitted)
+				time.Sleep(delay)
+			}
+		}
+		passwordOK, err := app.CheckPassword(&user, password)
+		if err != nil {
+			return err
+		}
+		if !passwordOK {
+			app.IncrementStat(StatAuthenticateFailures)
+			if throttle != nil {
+				throttle.RecordFailure(user.UUID, password)
+			}
+			return c.String(http.StatusOK, legacyBadLogin)
+		}
+		if throttle != nil {
+			throttle.RecordSuccess(user.UUID)
+		}
+
+		if user.IsPendingApproval {
+			return c.String(http.StatusOK, "Your account is waiting for approval by an admin.")
+		}
+		if user.IsLocked {
+			return c.String(http.StatusOK, LockedMessage(&user, time.Now()))
+		}
+		if allowed, reason := app.ExternalAuthAllows(&user, c.RealIP(), c.Request().UserAgent()); !allowed {
+			return c.String(http.StatusOK, reason)
+		}
+
+		// Legacy launchers have no client token, so every login is a new
+		// client
+		res, err := app.AuthenticateClient(&user, nil, nil, false)
+		if err != nil {
+			return err
+		}
+		id, err := UUIDToID(user.UUID)
+		if err != nil {
+			return err
+		}
+		app.NotifySecurityEvent(&user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+		app.PublishEvent(MakeEvent(EventLogin, &user, c.RealIP()))
+		return c.String(http.StatusOK, fmt.Sprintf("%s:%s:%s:%s", LEGACY_LOGIN_VERSION, user.PlayerName, res.AccessToken, id))
+	}
+}
+
+// GET /game/joinserver.jsp?user=...&sessionId=...&serverId=...
+func LegacyJoinServer(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().LegacyAuthentication.Enable {
+			return echo.ErrNotFound
+		}
+
+		accessToken := legacySessionIDToAccessToken(c.QueryParam("sessionId"))
+		client := app.GetClient(accessToken, StalePolicyDeny)
+		if client == nil || !strings.EqualFold(client.User.PlayerName, c.QueryParam("user")) {
+			return c.String(http.StatusOK, legacyBadLogin)
+		}
+
+		if err := app.JoinServer(&client.User, c.QueryParam("serverId"), c.RealIP()); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, legacyJoinOK)
+	}
+}
+
+// GET /game/checkserver.jsp?user=...&serverId=...
+func LegacyCheckServer(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().LegacyAuthentication.Enable {
+			return echo.ErrNotFound
+		}
+
+		var user User
+		result := app.DB.First(&user, "player_name = ?", c.QueryParam("user"))
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return c.String(http.StatusOK, legacyCheckNotFound)
+			}
+			return result.Error
+		}
+		if !user.ServerID.Valid || user.ServerID.String != c.QueryParam("serverId") {
+			return c.String(http.StatusOK, legacyCheckNotFound)
+		}
+		if err := app.RecordSession(&user, c.RealIP(), sessionPlayerIP(c, &user)); err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, legacyCheckJoined)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLegacy(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test legacy authentication disabled", ts.testLegacyDisabled)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.LegacyAuthentication.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test legacy login, join, and check", ts.testLegacyJoin)
+	}
+}
+
+func (ts *TestSuite) testLegacyDisabled(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/game/checkserver.jsp?user=Steve&serverId=abc", nil, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func (ts *TestSuite) testLegacyJoin(t *testing.T) {
+	form := url.Values{}
+	form.Set("user", TEST_USERNAME)
+	form.Set("password", "wrong password")
+	form.Set("version", "13")
+	rec := ts.PostForm(t, ts.Server, "/legacy/login", form, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Bad login", rec.Body.String())
+
+	// Credentials are only accepted in the request body
+	form.Set("password", TEST_PASSWORD)
+	rec = ts.Get(t, ts.Server, "/legacy/login?"+form.Encode(), nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = ts.PostForm(t, ts.Server, "/legacy/login?"+form.Encode(), url.Values{}, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "Bad login", rec.Body.String())
+
+	rec = ts.PostForm(t, ts.Server, "/legacy/login", form, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	// <version>:deprecated:<player name>:<session ID>:<profile ID>
+	split := strings.Split(rec.Body.String(), ":")
+	assert.Equal(t, 5, len(split))
+	assert.Equal(t, TEST_USERNAME, split[2])
+	sessionID := split[3]
+	profileID := split[4]
+
+	// The session ID is an ordinary access token
+	assert.NotNil(t, ts.App.GetClient(sessionID, StalePolicyDeny))
+
+	rec = ts.Get(t, ts.Server, "/game/checkserver.jsp?user="+TEST_USERNAME+"&serverId=legacy-server", nil, nil)
+	assert.Equal(t, "NO", rec.Body.String())
+
+	rec = ts.Get(t, ts.Server, "/game/joinserver.jsp?user="+TEST_USERNAME+"&sessionId=invalid&serverId=legacy-server", nil, nil)
+	assert.Equal(t, "Bad login", rec.Body.String())
+
+	// Clients since 1.3 send the session ID as token:<access token>:<profile ID>
+	query := url.Values{}
+	query.Set("user", TEST_USERNAME)
+	query.Set("sessionId", "token:"+sessionID+":"+profileID)
+	query.Set("serverId", "legacy-server")
+	rec = ts.Get(t, ts.Server, "/game/joinserver.jsp?"+query.Encode(), nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "OK", rec.Body.String())
+
+	rec = ts.Get(t, ts.Server, "/game/checkserver.jsp?user="+TEST_USERNAME+"&serverId=legacy-server", nil, nil)
+	assert.Equal(t, "YES", rec.Body.String())
+	rec = ts.Get(t, ts.Server, "/game/checkserver.jsp?user="+TEST_USERNAME+"&serverId=other-server", nil, nil)
+	assert.Equal(t, "NO", rec.Body.String())
+
+	// Locked users can't log in
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, ts.App.SetIsLocked(ts.App.DB, &user, true))
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+	rec = ts.PostForm(t, ts.Server, "/legacy/login", form, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, LockedMessage(&user, time.Now()), rec.Body.String())
+}
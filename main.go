@@ -14,12 +14,16 @@ import (
 	"gorm.io/gorm"
 	"log"
 	"lukechampine.com/blake3"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var DEBUG = os.Getenv("DRASL_DEBUG") != ""
@@ -30,30 +34,99 @@ var bodyDump = middleware.BodyDump(func(c echo.Context, reqBody, resBody []byte)
 })
 
 type App struct {
-	FrontEndURL            string
-	AuthURL                string
-	AccountURL             string
-	ServicesURL            string
-	SessionURL             string
-	AuthlibInjectorURL     string
-	DB                     *gorm.DB
-	FSMutex                KeyedMutex
-	RequestCache           *ristretto.Cache
-	Config                 *Config
+	FrontEndURL string
+	// TextureBaseURL, or BaseURL if it's not set
+	TextureURL         string
+	AuthURL            string
+	AccountURL         string
+	ServicesURL        string
+	SessionURL         string
+	AuthlibInjectorURL string
+	DB                 *gorm.DB
+	FSMutex            KeyedMutex
+	RequestCache       *ristretto.Cache
+	// The config in use, read with Config. Replaced as a whole, never
+	// modified, when ConfigOverrides change.
+	config atomic.Pointer[Config]
+	// Config as read from the config file, before any ConfigOverrides
+	FileConfig             Config
 	TransientUsernameRegex *regexp.Regexp
 	ValidPlayerNameRegex   *regexp.Regexp
-	Constants              *ConstantsType
-	PlayerCertificateKeys  []rsa.PublicKey
-	ProfilePropertyKeys    []rsa.PublicKey
-	Key                    *rsa.PrivateKey
-	KeyB3Sum512            []byte
-	SkinMutex              *sync.Mutex
+	// Parsed from RegistrationRestrictions
+	RegistrationAllowedNets []*net.IPNet
+	RegistrationDeniedNets  []*net.IPNet
+	// Parsed from AdminRestrictions
+	AdminAllowedNets []*net.IPNet
+	AdminDeniedNets  []*net.IPNet
+	// Parsed RateLimit.ExemptCIDRs
+	RateLimitExemptNets []*net.IPNet
+	// Path of BaseURL, so cookies are only sent to this instance
+	CookiePath string
+	// Nil unless AuthenticateThrottle.Enable is set
+	AuthenticateThrottle *AuthenticateThrottle
+	Constants            *ConstantsType
+	// Read with PlayerCertificateKeys and ProfilePropertyKeys. Replaced as a
+	// whole when ConfigOverrides add fallback API servers.
+	publicKeys  atomic.Pointer[signingPublicKeys]
+	Key         *rsa.PrivateKey
+	KeyB3Sum512 []byte
+	SkinMutex   *sync.Mutex
+	Mailer      Mailer
+	// Used by MakeHTTPClient
+	HTTPTransport http.RoundTripper
+	// Per-user limit on /minecraft/profile/name/:playerName/available. Nil
+	// if PlayerNameChange.AvailabilityRequestsPerMinute is 0.
+	NameAvailabilityLimiter *middleware.RateLimiterMemoryStore
+	// Nil unless EventStream.Enable is set
+	Events *EventBroker
+	// Nil unless TextureQueue.Enable is set
+	TextureQueue *TextureQueue
+	// Nil unless ChunkedUploads.Enable is set
+	ChunkedUploads *ChunkedUploads
+	// Nil unless APITokens.Allow is set
+	APITokenLimiters *APITokenLimiters
+	// Nil unless AccessLog.Enable is set
+	AccessLog *RotatingFile
+	// Nil unless ErrorReporting.Enable is set
+	ErrorReporter *ErrorReporter
+	// Nil unless Tracing.Enable is set
+	Tracer *Tracer
+	// Served on the Diagnostics listener
+	Metrics *Metrics
+	// Security notification emails still being sent
+	securityEmails sync.WaitGroup
+}
+
+// The config in use. Requests may be served while it's replaced, so keep the
+// result rather than calling Config again if several options have to agree.
+func (app *App) Config() *Config {
+	return app.config.Load()
+}
+
+// Keys that player certificates and profile properties may be signed with:
+// ours and those of the fallback API servers
+type signingPublicKeys struct {
+	PlayerCertificate []rsa.PublicKey
+	ProfileProperty   []rsa.PublicKey
+}
+
+func (app *App) PlayerCertificateKeys() []rsa.PublicKey {
+	return app.publicKeys.Load().PlayerCertificate
+}
+
+func (app *App) ProfilePropertyKeys() []rsa.PublicKey {
+	return app.publicKeys.Load().ProfileProperty
 }
 
 func (app *App) LogError(err error, c *echo.Context) {
-	if err != nil && !app.Config.TestMode {
+	if err == nil {
+		return
+	}
+	if !app.Config().TestMode {
 		log.Println("Unexpected error in "+(*c).Request().Method+" "+(*c).Path()+":", err)
 	}
+	app.RecordError((*c).Request().Method, (*c).Path(), err)
+	app.ReportError(err, *c)
 }
 
 func (app *App) HandleError(err error, c echo.Context) {
@@ -86,23 +159,80 @@ func (app *App) HandleError(err error, c echo.Context) {
 	c.String(http.StatusInternalServerError, "Internal server error")
 }
 
+// A RateLimiterStore that follows changes to RateLimit.RequestsPerSecond made
+// on the Admin settings page. Changing the rate starts counting afresh.
+type rateLimiterStore struct {
+	app               *App
+	mutex             sync.Mutex
+	requestsPerSecond float64
+	store             middleware.RateLimiterStore
+}
+
+func (store *rateLimiterStore) Allow(identifier string) (bool, error) {
+	store.mutex.Lock()
+	if store.store == nil || store.requestsPerSecond != store.app.Config().RateLimit.RequestsPerSecond {
+		store.requestsPerSecond = store.app.Config().RateLimit.RequestsPerSecond
+		store.store = middleware.NewRateLimiterMemoryStore(rate.Limit(store.requestsPerSecond))
+	}
+	memoryStore := store.store
+	store.mutex.Unlock()
+	return memoryStore.Allow(identifier)
+}
+
 func makeRateLimiter(app *App) echo.MiddlewareFunc {
-	requestsPerSecond := rate.Limit(app.Config.RateLimit.RequestsPerSecond)
 	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
 		Skipper: func(c echo.Context) bool {
+			if !app.Config().RateLimit.Enable || IsRateLimitExempt(app, c.RealIP()) {
+				return true
+			}
 			switch c.Path() {
 			case "/",
+				"/drasl/api/:version/challenge-skin",
+				"/drasl/api/:version/device/code",
+				"/drasl/api/:version/device/token",
+				"/drasl/api/:version/qr-login",
+				"/drasl/api/:version/register",
+				"/drasl/api/:version/reports",
+				"/drasl/api/:version/server/commands",
+				"/drasl/api/:version/server/commands/confirm",
+				"/drasl/bedrock-link-code",
+				"/drasl/bedrock-unlink",
+				"/drasl/challenge-skin/status",
+				"/drasl/change-password",
+				"/drasl/delete-api-token",
+				"/drasl/delete-library-skin",
 				"/drasl/delete-user",
+				"/drasl/device/approve",
+				"/drasl/device/deny",
+				"/drasl/import-profile",
+				"/drasl/link-account",
 				"/drasl/login",
 				"/drasl/logout",
+				"/drasl/new-api-token",
+				"/drasl/qr-login",
+				"/drasl/qr-login/claim",
+				"/drasl/redeem-gift-code",
 				"/drasl/register",
-				"/drasl/update":
+				"/drasl/report",
+				"/drasl/revoke-client",
+				"/drasl/rollback-appearance",
+				"/drasl/save-library-skin",
+				"/drasl/unlink-account",
+				"/drasl/update",
+				"/drasl/update-client",
+				"/drasl/update-email",
+				"/drasl/update-skin-rotation",
+				"/drasl/uploads",
+				"/drasl/wear-cosmetic":
 				return false
 			default:
 				return true
 			}
 		},
-		Store: middleware.NewRateLimiterMemoryStore(requestsPerSecond),
+		Store: &rateLimiterStore{app: app},
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return RateLimitKey(app, c.RealIP()), nil
+		},
 		DenyHandler: func(c echo.Context, identifier string, err error) error {
 			path := c.Path()
 			if IsYggdrasilPath(path) {
@@ -112,18 +242,245 @@ func makeRateLimiter(app *App) echo.MiddlewareFunc {
 					Internal: err,
 				}
 			} else {
-				setErrorMessage(&c, "Too many requests. Try again later.")
+				setErrorMessage(app, &c, "Too many requests. Try again later.")
 				return c.Redirect(http.StatusSeeOther, getReturnURL(app, &c))
 			}
 		},
 	})
 }
 
+// In maintenance mode, only admins can use the web front end, and the
+// Yggdrasil API is unavailable except for the authlib-injector metadata.
+func makeMaintenanceMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Path() {
+			case "/authlib-injector",
+				"/authlib-injector/",
+				"/drasl/branding/favicon",
+				"/drasl/branding/logo",
+				"/drasl/login",
+				"/drasl/logout",
+				"/drasl/manifest.webmanifest",
+				"/drasl/public/*",
+				"/drasl/texture/cape/*",
+				"/drasl/texture/skin/*",
+				"/drasl/texture/default-cape/*",
+				"/drasl/texture/default-skin/*",
+				"/drasl/texture/fallback/*":
+				return next(c)
+			}
+
+			if IsYggdrasilPath(c.Request().URL.Path) {
+				return MakeErrorResponse(&c, http.StatusServiceUnavailable, Ptr("ServiceUnavailableException"), Ptr(app.Config().Maintenance.Message))
+			}
+
+			cookie, err := c.Cookie("browserToken")
+			if err == nil && cookie.Value != "" {
+				var user User
+				result := app.DB.First(&user, "browser_token = ?", cookie.Value)
+				if result.Error == nil && user.IsAdmin {
+					return next(c)
+				}
+				if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return result.Error
+				}
+			}
+
+			return FrontMaintenance(app)(c)
+		}
+	}
+}
+
+// Hide the admin pages from clients outside AdminRestrictions, as if they
+// didn't exist
+func makeAdminRestrictionsMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if IsAdminPath(c.Request().URL.Path) && !IsAdminIPAllowed(app, c.RealIP()) {
+				return echo.ErrNotFound
+			}
+			return next(c)
+		}
+	}
+}
+
+// Set SecurityHeaders on web pages and textures. The Yggdrasil and JSON APIs
+// aren't loaded by browsers, so they're left alone.
+func makeSecurityHeadersMiddleware(app *App) echo.MiddlewareFunc {
+	config := &app.Config().SecurityHeaders
+
+	// frame-ancestors is ignored in a report-only policy, so it's always
+	// enforced
+	enforcedPolicy := ""
+	reportOnlyPolicy := ""
+	if config.ReportOnly {
+		reportOnlyPolicy = config.ContentSecurityPolicy
+	} else {
+		enforcedPolicy = config.ContentSecurityPolicy
+	}
+	if config.FrameAncestors != "" {
+		if enforcedPolicy != "" {
+			enforcedPolicy += "; "
+		}
+		enforcedPolicy += "frame-ancestors " + config.FrameAncestors
+	}
+
+	frameOptions := ""
+	switch config.FrameAncestors {
+	case "'none'":
+		frameOptions = "DENY"
+	case "'self'":
+		frameOptions = "SAMEORIGIN"
+	}
+
+	hsts := ""
+	if config.HSTSMaxAgeSec > 0 {
+		hsts = fmt.Sprintf("max-age=%d", config.HSTSMaxAgeSec)
+		if config.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if IsYggdrasilPath(c.Request().URL.Path) {
+				return next(c)
+			}
+			header := c.Response().Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			if enforcedPolicy != "" {
+				header.Set("Content-Security-Policy", enforcedPolicy)
+			}
+			if reportOnlyPolicy != "" {
+				header.Set("Content-Security-Policy-Report-Only", reportOnlyPolicy)
+			}
+			if frameOptions != "" {
+				header.Set("X-Frame-Options", frameOptions)
+			}
+			if config.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", config.ReferrerPolicy)
+			}
+			if hsts != "" {
+				header.Set("Strict-Transport-Security", hsts)
+			}
+			return next(c)
+		}
+	}
+}
+
+// In read-only mode, reject any request that would modify a user, an invite,
+// or a texture. Authentication and profile lookups continue to work.
+func makeReadOnlyMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Method == http.MethodGet {
+				return next(c)
+			}
+			switch c.Path() {
+			case "/drasl/accept-terms",
+				"/drasl/admin/add-user-note",
+				"/drasl/admin/approve-action",
+				"/drasl/admin/approve-user",
+				"/drasl/admin/cancel-action",
+				"/drasl/admin/delete-forwarding-secret",
+				"/drasl/admin/delete-gift-codes",
+				"/drasl/admin/delete-group",
+				"/drasl/admin/delete-invite",
+				"/drasl/admin/delete-user-note",
+				"/drasl/admin/email",
+				"/drasl/admin/fallback-api-servers",
+				"/drasl/admin/group/add-member",
+				"/drasl/admin/group/remove-member",
+				"/drasl/admin/group/set-cape",
+				"/drasl/admin/group/set-locked",
+				"/drasl/admin/new-forwarding-secret",
+				"/drasl/admin/new-gift-codes",
+				"/drasl/admin/new-group",
+				"/drasl/admin/new-invite",
+				"/drasl/admin/reject-user",
+				"/drasl/admin/resolve-report",
+				"/drasl/admin/rotate-forwarding-secret",
+				"/drasl/admin/set-api-token-limits",
+				"/drasl/admin/set-texture-locks",
+				"/drasl/admin/suspend-user",
+				"/drasl/admin/update-announcement",
+				"/drasl/admin/update-settings",
+				"/drasl/admin/update-users",
+				"/drasl/api/:version/admin/cosmetics",
+				"/drasl/api/:version/admin/cosmetics/:id",
+				"/drasl/api/:version/admin/cosmetics/:id/grant",
+				"/drasl/api/:version/admin/cosmetics/:id/revoke",
+				"/drasl/api/:version/admin/fallback-api-servers",
+				"/drasl/api/:version/admin/users/:uuid/notes",
+				"/drasl/api/:version/admin/users/:uuid/properties",
+				"/drasl/api/:version/profile/cape",
+				"/drasl/api/:version/profile/skin",
+				"/drasl/api/:version/register",
+				"/drasl/api/:version/reports",
+				"/drasl/api/:version/server/bedrock-link",
+				"/drasl/api/:version/server/commands/confirm",
+				"/drasl/bedrock-link-code",
+				"/drasl/bedrock-unlink",
+				"/drasl/change-password",
+				"/drasl/delete-api-token",
+				"/drasl/delete-library-skin",
+				"/drasl/delete-user",
+				"/drasl/import-profile",
+				"/drasl/link-account",
+				"/drasl/new-api-token",
+				"/drasl/redeem-gift-code",
+				"/drasl/register",
+				"/drasl/report",
+				"/drasl/revoke-client",
+				"/drasl/rollback-appearance",
+				"/drasl/save-library-skin",
+				"/drasl/unlink-account",
+				"/drasl/update",
+				"/drasl/update-client",
+				"/drasl/update-email",
+				"/drasl/update-skin-rotation",
+				"/drasl/uploads",
+				"/drasl/uploads/:id",
+				"/drasl/wear-cosmetic",
+				"/minecraft/profile/capes/active",
+				"/minecraft/profile/skins/active",
+				"/minecraft/profile/skins",
+				"/minecraft/profile/name/:playerName",
+				"/services/minecraft/profile/capes/active",
+				"/services/minecraft/profile/skins/active",
+				"/services/minecraft/profile/skins",
+				"/services/minecraft/profile/name/:playerName":
+				if IsYggdrasilPath(c.Path()) {
+					return MakeErrorResponse(&c, http.StatusServiceUnavailable, Ptr("ServiceUnavailableException"), Ptr(app.Config().ReadOnly.Message))
+				}
+				setErrorMessage(app, &c, app.Config().ReadOnly.Message)
+				return c.Redirect(http.StatusSeeOther, getReturnURL(app, &c))
+			default:
+				return next(c)
+			}
+		}
+	}
+}
+
 func GetServer(app *App) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
-	e.HidePort = app.Config.TestMode
+	e.HidePort = app.Config().TestMode
 	e.HTTPErrorHandler = app.HandleError
+	if len(app.Config().TrustedProxies) > 0 {
+		// Only believe X-Forwarded-For when the request comes through one
+		// of our proxies
+		trustOptions := []echo.TrustOption{
+			echo.TrustLoopback(false),
+			echo.TrustLinkLocal(false),
+			echo.TrustPrivateNet(false),
+		}
+		for _, ipNet := range Unwrap(ParseCIDRs(app.Config().TrustedProxies)) {
+			trustOptions = append(trustOptions, echo.TrustIPRange(ipNet))
+		}
+		e.IPExtractor = echo.ExtractIPFromXFFHeader(trustOptions...)
+	}
 
 	e.Pre(middleware.Rewrite(map[string]string{
 		"/authlib-injector/authserver/*":        "/auth/$1",
@@ -131,25 +488,50 @@ func GetServer(app *App) *echo.Echo {
 		"/authlib-injector/sessionserver/*":     "/session/$1",
 		"/authlib-injector/minecraftservices/*": "/services/$1",
 	}))
+	if app.Tracer != nil {
+		e.Use(makeTracingMiddleware(app))
+	}
+	if app.ErrorReporter != nil {
+		e.Use(makeRecoverMiddleware())
+	}
 	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			c.Response().Header().Set("X-Authlib-Injector-API-Location", app.AuthlibInjectorURL)
 			return next(c)
 		}
 	})
-	if app.Config.LogRequests {
+	e.Use(makeTextureMetricsMiddleware(app))
+	if app.Config().Compression.Enable {
+		e.Use(makeCompressionMiddleware(app))
+	}
+	if app.Config().LogRequests {
 		e.Use(middleware.Logger())
 	}
+	if app.AccessLog != nil {
+		e.Use(makeAccessLogMiddleware(app))
+	}
 	if DEBUG {
 		e.Use(bodyDump)
 	}
-	if app.Config.RateLimit.Enable {
-		e.Use(makeRateLimiter(app))
+	if app.Config().SecurityHeaders.Enable {
+		e.Use(makeSecurityHeadersMiddleware(app))
+	}
+	if len(app.AdminAllowedNets) > 0 || len(app.AdminDeniedNets) > 0 {
+		e.Use(makeAdminRestrictionsMiddleware(app))
 	}
-	if app.Config.BodyLimit.Enable {
-		limit := fmt.Sprintf("%dKIB", app.Config.BodyLimit.SizeLimitKiB)
+	// RateLimit can be changed on the Admin settings page, so the rate limiter
+	// is always installed
+	e.Use(makeRateLimiter(app))
+	if app.Config().BodyLimit.Enable {
+		limit := fmt.Sprintf("%dKIB", app.Config().BodyLimit.SizeLimitKiB)
 		e.Use(middleware.BodyLimit(limit))
 	}
+	if app.Config().Maintenance.Enable {
+		e.Use(makeMaintenanceMiddleware(app))
+	}
+	if app.Config().ReadOnly.Enable {
+		e.Use(makeReadOnlyMiddleware(app))
+	}
 
 	// Front
 	t := NewTemplate(app)
@@ -157,22 +539,142 @@ func GetServer(app *App) *echo.Echo {
 	e.GET("/", FrontRoot(app))
 	e.GET("/drasl/manifest.webmanifest", FrontWebManifest(app))
 	e.GET("/drasl/admin", FrontAdmin(app))
+	e.GET("/drasl/admin/api-usage", FrontAdminAPIUsage(app))
+	e.GET("/drasl/admin/email", FrontAdminEmail(app))
+	e.GET("/drasl/admin/fallback-api-servers", FrontAdminFallbackAPIServers(app))
+	e.GET("/drasl/admin/gift-codes/export", FrontExportGiftCodes(app))
+	e.GET("/drasl/admin/group", FrontGroup(app))
+	e.GET("/drasl/admin/group/export", FrontExportGroup(app))
+	e.GET("/drasl/admin/reports", FrontAdminReports(app))
+	e.GET("/drasl/admin/settings", FrontAdminSettings(app))
+	e.GET("/drasl/admin/stats", FrontStats(app))
+	e.GET("/drasl/api-docs", FrontAPIDocs(app, e))
+	e.GET("/drasl/branding/favicon", FrontBrandingFavicon(app))
+	e.GET("/drasl/branding/logo", FrontBrandingLogo(app))
 	e.GET("/drasl/challenge-skin", FrontChallengeSkin(app))
+	e.GET("/drasl/challenge-skin/status", FrontChallengeSkinStatus(app))
+	e.GET("/drasl/delete-user", FrontDeleteUserConfirmation(app))
+	e.GET("/drasl/device", FrontDevice(app))
+	e.GET("/drasl/pages/:slug", FrontCustomPage(app))
 	e.GET("/drasl/profile", FrontProfile(app))
+	e.GET("/drasl/qr-login/claim", FrontQRLoginClaimConfirmation(app))
 	e.GET("/drasl/registration", FrontRegistration(app))
+	e.GET("/drasl/unsubscribe", FrontUnsubscribe(app))
+	e.GET("/drasl/uploads/:id", FrontChunkedUploadStatus(app))
+	e.GET("/drasl/verify-email", FrontVerifyEmail(app))
+	e.POST("/drasl/accept-terms", FrontAcceptTerms(app))
+	e.POST("/drasl/admin/add-user-note", FrontAddUserNote(app))
+	e.POST("/drasl/admin/approve-action", FrontApproveAdminAction(app))
+	e.POST("/drasl/admin/approve-user", FrontApproveUser(app))
+	e.POST("/drasl/admin/cancel-action", FrontCancelAdminAction(app))
+	e.POST("/drasl/admin/delete-forwarding-secret", FrontDeleteForwardingSecret(app))
+	e.POST("/drasl/admin/delete-gift-codes", FrontDeleteGiftCodes(app))
+	e.POST("/drasl/admin/delete-group", FrontDeleteGroup(app))
+	e.POST("/drasl/admin/email", FrontSendAdminEmail(app))
+	e.POST("/drasl/admin/fallback-api-servers", FrontUpdateFallbackAPIServers(app))
 	e.POST("/drasl/admin/delete-invite", FrontDeleteInvite(app))
+	e.POST("/drasl/admin/delete-user-note", FrontDeleteUserNote(app))
+	e.POST("/drasl/admin/group/add-member", FrontAddGroupMember(app))
+	e.POST("/drasl/admin/group/remove-member", FrontRemoveGroupMember(app))
+	e.POST("/drasl/admin/group/set-cape", FrontSetGroupCape(app))
+	e.POST("/drasl/admin/group/set-locked", FrontSetGroupLocked(app))
+	e.POST("/drasl/admin/impersonate", FrontImpersonate(app))
+	e.POST("/drasl/admin/new-forwarding-secret", FrontNewForwardingSecret(app))
+	e.POST("/drasl/admin/new-gift-codes", FrontNewGiftCodes(app))
+	e.POST("/drasl/admin/new-group", FrontNewGroup(app))
 	e.POST("/drasl/admin/new-invite", FrontNewInvite(app))
+	e.POST("/drasl/admin/reject-user", FrontRejectUser(app))
+	e.POST("/drasl/admin/resolve-report", FrontResolveReport(app))
+	e.POST("/drasl/admin/rotate-forwarding-secret", FrontRotateForwardingSecret(app))
+	e.POST("/drasl/admin/set-api-token-limits", FrontSetAPITokenLimits(app))
+	e.POST("/drasl/admin/set-texture-locks", FrontSetTextureLocks(app))
+	e.POST("/drasl/admin/suspend-user", FrontSuspendUser(app))
+	e.POST("/drasl/admin/update-announcement", FrontUpdateAnnouncement(app))
+	e.POST("/drasl/admin/update-settings", FrontUpdateSettings(app))
 	e.POST("/drasl/admin/update-users", FrontUpdateUsers(app))
+	e.POST("/drasl/bedrock-link-code", FrontBedrockLinkCode(app))
+	e.POST("/drasl/bedrock-unlink", FrontBedrockUnlink(app))
+	e.POST("/drasl/change-password", FrontChangePassword(app))
+	e.POST("/drasl/delete-api-token", FrontDeleteAPIToken(app))
+	e.POST("/drasl/delete-library-skin", FrontDeleteLibrarySkin(app))
 	e.POST("/drasl/delete-user", FrontDeleteUser(app))
+	e.POST("/drasl/device/approve", FrontApproveDevice(app))
+	e.POST("/drasl/device/deny", FrontDenyDevice(app))
+	e.POST("/drasl/import-profile", FrontImportProfile(app))
+	e.POST("/drasl/link-account", FrontLinkAccount(app))
 	e.POST("/drasl/login", FrontLogin(app))
 	e.POST("/drasl/logout", FrontLogout(app))
+	e.POST("/drasl/new-api-token", FrontNewAPIToken(app))
+	e.POST("/drasl/qr-login", FrontQRLogin(app))
+	e.POST("/drasl/qr-login/cancel", FrontCancelQRLogin(app))
+	e.POST("/drasl/qr-login/claim", FrontQRLoginClaim(app))
+	e.POST("/drasl/redeem-gift-code", FrontRedeemGiftCode(app))
 	e.POST("/drasl/register", FrontRegister(app))
+	e.POST("/drasl/report", FrontReport(app))
+	e.POST("/drasl/revoke-client", FrontRevokeClient(app))
+	e.POST("/drasl/rollback-appearance", FrontRollBackAppearance(app))
+	e.POST("/drasl/save-library-skin", FrontSaveLibrarySkin(app))
+	e.POST("/drasl/stop-impersonating", FrontStopImpersonating(app))
+	e.POST("/drasl/unlink-account", FrontUnlinkAccount(app))
 	e.POST("/drasl/update", FrontUpdate(app))
-	e.Static("/drasl/public", path.Join(app.Config.DataDirectory, "public"))
-	e.Static("/drasl/texture/cape", path.Join(app.Config.StateDirectory, "cape"))
-	e.Static("/drasl/texture/skin", path.Join(app.Config.StateDirectory, "skin"))
-	e.Static("/drasl/texture/default-cape", path.Join(app.Config.StateDirectory, "default-cape"))
-	e.Static("/drasl/texture/default-skin", path.Join(app.Config.StateDirectory, "default-skin"))
+	e.POST("/drasl/update-client", FrontUpdateClient(app))
+	e.POST("/drasl/update-email", FrontUpdateEmail(app))
+	e.POST("/drasl/update-skin-rotation", FrontUpdateSkinRotation(app))
+	e.POST("/drasl/uploads", FrontStartChunkedUpload(app))
+	e.POST("/drasl/wear-cosmetic", FrontWearCosmetic(app))
+	e.PUT("/drasl/uploads/:id", FrontAppendChunkedUpload(app))
+	e.GET("/drasl/public/*", ThemedStatic(app, "public"))
+	e.Static("/drasl/texture/cape", path.Join(app.Config().StateDirectory, "cape"))
+	e.Static("/drasl/texture/skin", path.Join(app.Config().StateDirectory, "skin"))
+	e.Static("/drasl/texture/default-cape", path.Join(app.Config().StateDirectory, "default-cape"))
+	e.Static("/drasl/texture/default-skin", path.Join(app.Config().StateDirectory, "default-skin"))
+	e.Static("/drasl/texture/fallback", path.Join(app.Config().StateDirectory, "fallback-texture"))
+
+	// Drasl API, under each version served; see api_versions.go
+	api := e.Group("/drasl/api/:version", makeAPIVersionMiddleware(app))
+	api.GET("/challenge-skin", APIChallengeSkin(app))
+	api.GET("/admin/cosmetics", APIAdminCosmetics(app))
+	api.POST("/admin/cosmetics", APIAdminCreateCosmetic(app))
+	api.DELETE("/admin/cosmetics/:id", APIAdminDeleteCosmetic(app))
+	api.POST("/admin/cosmetics/:id/grant", APIAdminGrantCosmetic(app))
+	api.POST("/admin/cosmetics/:id/revoke", APIAdminRevokeCosmetic(app))
+	api.GET("/admin/fallback-api-servers", APIAdminFallbackAPIServers(app))
+	api.PUT("/admin/fallback-api-servers", APIAdminSetFallbackAPIServers(app))
+	api.POST("/admin/fallback-api-servers/test", APIAdminTestFallbackAPIServer(app))
+	api.GET("/admin/users", APIAdminUsers(app))
+	api.GET("/admin/users/:uuid/properties", APIAdminUserProfileProperties(app))
+	api.GET("/admin/users/:uuid/moderation", APIAdminUserModeration(app))
+	api.POST("/admin/users/:uuid/notes", APIAdminAddUserNote(app))
+	api.GET("/admin/users/:uuid/sessions", APIAdminUserSessions(app))
+	api.PUT("/admin/users/:uuid/properties", APIAdminSetUserProfileProperties(app))
+	api.POST("/device/code", APIDeviceCode(app))
+	api.POST("/device/token", APIDeviceToken(app))
+	api.GET("/events", APIEvents(app))
+	api.GET("/info", APIInfo(app))
+	api.POST("/introspect", APIIntrospect(app))
+	api.GET("/players", APIPlayerSearch(app))
+	api.PUT("/profile/cape", APIProfileSetCape(app))
+	api.DELETE("/profile/cape", APIProfileDeleteCape(app))
+	api.GET("/profile/sessions", APIProfileSessions(app))
+	api.GET("/public-key.pem", APIPublicKeyPEM(app))
+	api.GET("/public-keys", APIPublicKeys(app))
+	api.PUT("/profile/skin", APIProfileSetSkin(app))
+	api.DELETE("/profile/skin", APIProfileDeleteSkin(app))
+	api.POST("/qr-login", APIQRLogin(app))
+	api.GET("/register", APIRegistrationOptions(app))
+	api.POST("/register", APIRegister(app))
+	api.POST("/reports", APIReport(app))
+	api.GET("/server/bedrock-link", APIServerGetBedrockLink(app))
+	api.POST("/server/bedrock-link", APIServerBedrockLink(app))
+	api.POST("/server/commands", APIServerStartCommand(app))
+	api.POST("/server/commands/confirm", APIServerConfirmCommand(app))
+	api.GET("/server/forwarding-secrets", APIServerForwardingSecrets(app))
+	api.POST("/server/forwarding-secrets/verify", APIServerVerifyForwardingSecret(app))
+	api.POST("/server/introspect", APIServerIntrospect(app))
+	api.GET("/server/joined", APIServerJoined(app))
+	api.GET("/server/linked-account", APIServerLinkedAccount(app))
+	api.POST("/server/modern-forwarding", APIServerModernForwarding(app))
+	api.GET("/statistics", APIStatistics(app))
 
 	// authlib-injector
 	e.GET("/authlib-injector", AuthlibInjectorRoot(app))
@@ -202,14 +704,17 @@ func GetServer(app *App) *echo.Echo {
 	accountVerifySecurityLocation := AccountVerifySecurityLocation(app)
 	accountPlayerNameToID := AccountPlayerNameToID(app)
 	accountPlayerNamesToIDs := AccountPlayerNamesToIDs(app)
+	accountOrdersStatistics := AccountOrdersStatistics(app)
 
 	e.GET("/user/security/location", accountVerifySecurityLocation)
 	e.GET("/users/profiles/minecraft/:playerName", accountPlayerNameToID)
 	e.POST("/profiles/minecraft", accountPlayerNamesToIDs)
+	e.POST("/orders/statistics", accountOrdersStatistics)
 
 	e.GET("/account/user/security/location", accountVerifySecurityLocation)
 	e.GET("/account/users/profiles/minecraft/:playerName", accountPlayerNameToID)
 	e.POST("/account/profiles/minecraft", accountPlayerNamesToIDs)
+	e.POST("/account/orders/statistics", accountOrdersStatistics)
 
 	// Session
 	sessionHasJoined := SessionHasJoined(app)
@@ -226,10 +731,34 @@ func GetServer(app *App) *echo.Echo {
 	e.GET("/session/session/minecraft/profile/:id", sessionProfile)
 	e.GET("/session/blockedservers", sessionBlockedServers)
 
+	// ely.by skin system
+	elyBySkin := ElyBySkin(app)
+	elyByCape := ElyByCape(app)
+	e.GET("/skins", elyBySkin)
+	e.GET("/skins/:playerName", elyBySkin)
+	e.GET("/cloaks", elyByCape)
+	e.GET("/cloaks/:playerName", elyByCape)
+	e.GET("/textures/:playerName", ElyByTextures(app))
+	e.GET("/textures/signed/:playerName", ElyBySignedTextures(app))
+
+	// Microsoft and Xbox Live sign-in
+	xboxAuthenticate := XboxAuthenticate(app)
+	e.POST("/msa/:tenant/oauth2/v2.0/devicecode", MSADeviceCode(app))
+	e.POST("/msa/:tenant/oauth2/v2.0/token", MSAToken(app))
+	e.POST("/xbl/user/authenticate", xboxAuthenticate)
+	e.POST("/xsts/xsts/authorize", xboxAuthenticate)
+
+	// Legacy authentication
+	e.POST("/legacy/login", LegacyLogin(app))
+	e.GET("/game/joinserver.jsp", LegacyJoinServer(app))
+	e.GET("/game/checkserver.jsp", LegacyCheckServer(app))
+
 	// Services
 	servicesPlayerAttributes := ServicesPlayerAttributes(app)
 	servicesPlayerCertificates := ServicesPlayerCertificates(app)
 	servicesDeleteCape := ServicesHideCape(app)
+	servicesShowCape := ServicesShowCape(app)
+	servicesEntitlements := ServicesEntitlements(app)
 	servicesDeleteSkin := ServicesResetSkin(app)
 	servicesProfileInformation := ServicesProfileInformation(app)
 	servicesNameAvailability := ServicesNameAvailability(app)
@@ -239,11 +768,16 @@ func GetServer(app *App) *echo.Echo {
 	servicesUploadSkin := ServicesUploadSkin(app)
 	servicesChangeName := ServicesChangeName(app)
 	servicesPublicKeys := ServicesPublicKeys(app)
+	servicesLoginWithXbox := ServicesLoginWithXbox(app)
 
 	e.GET("/player/attributes", servicesPlayerAttributes)
 	e.POST("/player/certificates", servicesPlayerCertificates)
 	e.DELETE("/minecraft/profile/capes/active", servicesDeleteCape)
+	e.PUT("/minecraft/profile/capes/active", servicesShowCape)
 	e.DELETE("/minecraft/profile/skins/active", servicesDeleteSkin)
+	e.GET("/entitlements", servicesEntitlements)
+	e.GET("/entitlements/license", servicesEntitlements)
+	e.GET("/entitlements/mcstore", servicesEntitlements)
 	e.GET("/minecraft/profile", servicesProfileInformation)
 	e.GET("/minecraft/profile/name/:playerName/available", servicesNameAvailability)
 	e.GET("/minecraft/profile/namechange", servicesNameChange)
@@ -252,12 +786,17 @@ func GetServer(app *App) *echo.Echo {
 	e.POST("/minecraft/profile/skins", servicesUploadSkin)
 	e.PUT("/minecraft/profile/name/:playerName", servicesChangeName)
 	e.GET("/publickeys", servicesPublicKeys)
+	e.POST("/authentication/login_with_xbox", servicesLoginWithXbox)
 	e.POST("/minecraft/profile/lookup/bulk/byname", accountPlayerNamesToIDs)
 
 	e.GET("/services/player/attributes", servicesPlayerAttributes)
 	e.POST("/services/player/certificates", servicesPlayerCertificates)
 	e.DELETE("/services/minecraft/profile/capes/active", servicesDeleteCape)
+	e.PUT("/services/minecraft/profile/capes/active", servicesShowCape)
 	e.DELETE("/services/minecraft/profile/skins/active", servicesDeleteSkin)
+	e.GET("/services/entitlements", servicesEntitlements)
+	e.GET("/services/entitlements/license", servicesEntitlements)
+	e.GET("/services/entitlements/mcstore", servicesEntitlements)
 	e.GET("/services/minecraft/profile", servicesProfileInformation)
 	e.GET("/services/minecraft/profile/name/:playerName/available", servicesNameAvailability)
 	e.GET("/services/minecraft/profile/namechange", servicesNameChange)
@@ -266,11 +805,61 @@ func GetServer(app *App) *echo.Echo {
 	e.POST("/services/minecraft/profile/skins", servicesUploadSkin)
 	e.PUT("/services/minecraft/profile/name/:playerName", servicesChangeName)
 	e.GET("/services/publickeys", servicesPublicKeys)
+	e.POST("/services/authentication/login_with_xbox", servicesLoginWithXbox)
 	e.POST("/services/minecraft/profile/lookup/bulk/byname", accountPlayerNamesToIDs)
 
 	return e
 }
 
+// GET a fallback API server's /publickeys
+func fetchFallbackAPIServerPublicKeys(client *http.Client, fallbackAPIServer *FallbackAPIServer) (*PublicKeysResponse, error) {
+	reqURL, err := url.JoinPath(fallbackAPIServer.ServicesURL, "publickeys")
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Get(reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't access fallback API server at %s: %s", reqURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Request to fallback API server at %s resulted in status code %d", reqURL, res.StatusCode)
+	}
+
+	var publicKeysRes PublicKeysResponse
+	err = json.NewDecoder(res.Body).Decode(&publicKeysRes)
+	if err != nil {
+		return nil, fmt.Errorf("Received invalid response from fallback API server at %s", reqURL)
+	}
+	return &publicKeysRes, nil
+}
+
+// Add the keys in publicKeysRes that aren't already trusted
+func addFallbackAPIServerPublicKeys(publicKeysRes *PublicKeysResponse, profilePropertyKeys []rsa.PublicKey, playerCertificateKeys []rsa.PublicKey) ([]rsa.PublicKey, []rsa.PublicKey) {
+	for _, serializedKey := range publicKeysRes.ProfilePropertyKeys {
+		publicKey, err := SerializedKeyToPublicKey(serializedKey)
+		if err != nil {
+			log.Printf("Received invalid profile property key from fallback API server: %s\n", err)
+			continue
+		}
+		if !ContainsPublicKey(profilePropertyKeys, publicKey) {
+			profilePropertyKeys = append(profilePropertyKeys, *publicKey)
+		}
+	}
+	for _, serializedKey := range publicKeysRes.PlayerCertificateKeys {
+		publicKey, err := SerializedKeyToPublicKey(serializedKey)
+		if err != nil {
+			log.Printf("Received invalid player certificate key from fallback API server: %s\n", err)
+			continue
+		}
+		if !ContainsPublicKey(playerCertificateKeys, publicKey) {
+			playerCertificateKeys = append(playerCertificateKeys, *publicKey)
+		}
+	}
+	return profilePropertyKeys, playerCertificateKeys
+}
+
 func setup(config *Config) *App {
 	_, err := os.Stat(config.StateDirectory)
 	if err != nil {
@@ -283,13 +872,32 @@ func setup(config *Config) *App {
 		}
 	}
 
-	key := ReadOrCreateKey(config)
+	key, err := ReadOrCreateKey(config)
+	if err != nil {
+		log.Fatalf("Couldn't read the signing key: %s", err)
+	}
 	keyBytes := Unwrap(x509.MarshalPKCS8PrivateKey(key))
 	sum := blake3.Sum512(keyBytes)
 	keyB3Sum512 := sum[:]
 
 	db, err := OpenDB(config)
 	Check(err)
+	dataCipher := Unwrap(LoadDataCipher(&config.DataEncryption))
+	if dataCipher != nil {
+		db = WithDataCipher(db, dataCipher)
+	}
+
+	// Apply the settings changed on the Admin settings page. If the config
+	// file has since changed so they no longer make sense, use the config
+	// file as it is.
+	fileConfig := *config
+	overrides, err := GetConfigOverrides(db)
+	Check(err)
+	if overriddenConfig, err := ApplyConfigOverrides(&fileConfig, overrides); err != nil {
+		log.Printf("Ignoring the settings changed on the Admin page: %s\n", err)
+	} else {
+		*config = *overriddenConfig
+	}
 
 	// https://pkg.go.dev/github.com/dgraph-io/ristretto#readme-config
 	cache := Unwrap(ristretto.NewCache(&config.RequestCache))
@@ -300,73 +908,118 @@ func setup(config *Config) *App {
 		transientUsernameRegex = regexp.MustCompile(config.TransientUsers.UsernameRegex)
 	}
 	validPlayerNameRegex := regexp.MustCompile(config.ValidPlayerNameRegex)
+	registrationAllowedNets := Unwrap(ParseCIDRs(config.RegistrationRestrictions.AllowedCIDRs))
+	registrationDeniedNets := Unwrap(ParseCIDRs(config.RegistrationRestrictions.DeniedCIDRs))
+	adminAllowedNets := Unwrap(ParseCIDRs(config.AdminRestrictions.AllowedCIDRs))
+	adminDeniedNets := Unwrap(ParseCIDRs(config.AdminRestrictions.DeniedCIDRs))
+	rateLimitExemptNets := Unwrap(ParseCIDRs(config.RateLimit.ExemptCIDRs))
 
 	playerCertificateKeys := make([]rsa.PublicKey, 0, 1)
 	profilePropertyKeys := make([]rsa.PublicKey, 0, 1)
 	profilePropertyKeys = append(profilePropertyKeys, key.PublicKey)
 	playerCertificateKeys = append(playerCertificateKeys, key.PublicKey)
 
+	httpTransport := MakeHTTPTransport(config)
 	for _, fallbackAPIServer := range config.FallbackAPIServers {
-		reqURL := Unwrap(url.JoinPath(fallbackAPIServer.ServicesURL, "publickeys"))
-		res, err := MakeHTTPClient().Get(reqURL)
+		publicKeysRes, err := fetchFallbackAPIServerPublicKeys(makeHTTPClient(httpTransport), &fallbackAPIServer)
 		if err != nil {
-			log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
+			log.Println(err)
 			continue
 		}
-		defer res.Body.Close()
+		profilePropertyKeys, playerCertificateKeys = addFallbackAPIServerPublicKeys(publicKeysRes, profilePropertyKeys, playerCertificateKeys)
+	}
 
-		if res.StatusCode != http.StatusOK {
-			log.Printf("Request to fallback API server at %s resulted in status code %d\n", reqURL, res.StatusCode)
-			continue
-		}
+	textureURL := config.BaseURL
+	if config.TextureBaseURL != "" {
+		textureURL = config.TextureBaseURL
+	}
+
+	cookiePath := Unwrap(url.Parse(config.BaseURL)).Path
+	if !strings.HasSuffix(cookiePath, "/") {
+		cookiePath += "/"
+	}
+
+	app := &App{
+		RequestCache:            cache,
+		FileConfig:              fileConfig,
+		TransientUsernameRegex:  transientUsernameRegex,
+		ValidPlayerNameRegex:    validPlayerNameRegex,
+		RegistrationAllowedNets: registrationAllowedNets,
+		RegistrationDeniedNets:  registrationDeniedNets,
+		AdminAllowedNets:        adminAllowedNets,
+		AdminDeniedNets:         adminDeniedNets,
+		RateLimitExemptNets:     rateLimitExemptNets,
+		CookiePath:              cookiePath,
+		Constants:               Constants,
+		DB:                      db,
+		FSMutex:                 KeyedMutex{},
+		Key:                     key,
+		KeyB3Sum512:             keyB3Sum512,
+		HTTPTransport:           httpTransport,
+		FrontEndURL:             config.BaseURL,
+		TextureURL:              textureURL,
+		AccountURL:              Unwrap(url.JoinPath(config.BaseURL, "account")),
+		AuthURL:                 Unwrap(url.JoinPath(config.BaseURL, "auth")),
+		ServicesURL:             Unwrap(url.JoinPath(config.BaseURL, "services")),
+		SessionURL:              Unwrap(url.JoinPath(config.BaseURL, "session")),
+		AuthlibInjectorURL:      Unwrap(url.JoinPath(config.BaseURL, "authlib-injector")),
+		Metrics:                 NewMetrics(),
+	}
+
+	app.config.Store(config)
+	app.publicKeys.Store(&signingPublicKeys{
+		PlayerCertificate: playerCertificateKeys,
+		ProfileProperty:   profilePropertyKeys,
+	})
+
+	if config.Email.Enable {
+		app.Mailer = &SMTPMailer{Config: &config.Email}
+	}
+
+	if config.EventStream.Enable {
+		app.Events = NewEventBroker()
+	}
+
+	if config.TextureQueue.Enable {
+		app.TextureQueue = NewTextureQueue(app)
+	}
+
+	if config.ChunkedUploads.Enable {
+		app.ChunkedUploads = Unwrap(NewChunkedUploads(app))
+	}
+
+	if config.APITokens.Allow {
+		app.APITokenLimiters = NewAPITokenLimiters()
+	}
+
+	if config.ErrorReporting.Enable {
+		app.ErrorReporter = Unwrap(NewErrorReporter(&config.ErrorReporting))
+	}
+
+	if config.Tracing.Enable {
+		app.Tracer = Unwrap(NewTracer(&config.Tracing))
+		Check(InstrumentDB(app.DB))
+	}
 
-		var publicKeysRes PublicKeysResponse
-		err = json.NewDecoder(res.Body).Decode(&publicKeysRes)
+	if config.AccessLog.Enable {
+		accessLog, err := OpenAccessLog(&config.AccessLog)
 		if err != nil {
-			log.Printf("Received invalid response from fallback API server at %s\n", reqURL)
-			continue
+			log.Fatal(fmt.Sprintf("Couldn't open AccessLog.File %s: %s", config.AccessLog.File, err))
 		}
+		app.AccessLog = accessLog
+	}
 
-		for _, serializedKey := range publicKeysRes.ProfilePropertyKeys {
-			publicKey, err := SerializedKeyToPublicKey(serializedKey)
-			if err != nil {
-				log.Printf("Received invalid profile property key from fallback API server at %s: %s\n", reqURL, err)
-				continue
-			}
-			if !ContainsPublicKey(profilePropertyKeys, publicKey) {
-				profilePropertyKeys = append(profilePropertyKeys, *publicKey)
-			}
-		}
-		for _, serializedKey := range publicKeysRes.PlayerCertificateKeys {
-			publicKey, err := SerializedKeyToPublicKey(serializedKey)
-			if err != nil {
-				log.Printf("Received invalid player certificate key from fallback API server at %s: %s\n", reqURL, err)
-				continue
-			}
-			if !ContainsPublicKey(playerCertificateKeys, publicKey) {
-				playerCertificateKeys = append(playerCertificateKeys, *publicKey)
-			}
-		}
+	if config.AuthenticateThrottle.Enable {
+		app.AuthenticateThrottle = NewAuthenticateThrottle(&config.AuthenticateThrottle, keyB3Sum512)
 	}
 
-	app := &App{
-		RequestCache:           cache,
-		Config:                 config,
-		TransientUsernameRegex: transientUsernameRegex,
-		ValidPlayerNameRegex:   validPlayerNameRegex,
-		Constants:              Constants,
-		DB:                     db,
-		FSMutex:                KeyedMutex{},
-		Key:                    key,
-		KeyB3Sum512:            keyB3Sum512,
-		FrontEndURL:            config.BaseURL,
-		PlayerCertificateKeys:  playerCertificateKeys,
-		ProfilePropertyKeys:    profilePropertyKeys,
-		AccountURL:             Unwrap(url.JoinPath(config.BaseURL, "account")),
-		AuthURL:                Unwrap(url.JoinPath(config.BaseURL, "auth")),
-		ServicesURL:            Unwrap(url.JoinPath(config.BaseURL, "services")),
-		SessionURL:             Unwrap(url.JoinPath(config.BaseURL, "session")),
-		AuthlibInjectorURL:     Unwrap(url.JoinPath(config.BaseURL, "authlib-injector")),
+	if config.PlayerNameChange.AvailabilityRequestsPerMinute > 0 {
+		requestsPerMinute := config.PlayerNameChange.AvailabilityRequestsPerMinute
+		app.NameAvailabilityLimiter = middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(requestsPerMinute) / 60),
+			Burst:     requestsPerMinute,
+			ExpiresIn: 3 * time.Minute,
+		})
 	}
 
 	// Post-setup
@@ -375,54 +1028,310 @@ func setup(config *Config) *App {
 	err = app.DB.Table("users").Where("username in (?)", config.DefaultAdmins).Updates(map[string]interface{}{"is_admin": true}).Error
 	Check(err)
 
-	// Print an initial invite link if necessary
-	if !app.Config.TestMode {
-		newPlayerInvite := app.Config.RegistrationNewPlayer.Allow && config.RegistrationNewPlayer.RequireInvite
-		existingPlayerInvite := app.Config.RegistrationExistingPlayer.Allow && config.RegistrationExistingPlayer.RequireInvite
-		if newPlayerInvite || existingPlayerInvite {
-			var count int64
-			Check(app.DB.Model(&User{}).Count(&count).Error)
-			if count == 0 {
-				// No users, print an initial invite link to the console
-				var invite Invite
-				result := app.DB.First(&invite)
-				if result.Error != nil {
-					if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-						// No invites yet, so create one
-						invite, err = app.CreateInvite()
-						Check(err)
-					} else {
-						log.Fatal(result.Error)
-					}
-				}
-				log.Println("No users found! Here's an invite URL:", Unwrap(InviteURL(app, &invite)))
-			}
-		}
+	if err := app.ApplyBootstrap(); err != nil {
+		log.Fatalf("Couldn't apply Bootstrap: %s", err)
 	}
 
 	return app
 }
 
+// Print an initial invite link if there are no users yet and registering
+// needs an invite
+func (app *App) LogInitialInvite() {
+	if app.Config().TestMode {
+		return
+	}
+	newPlayerInvite := app.Config().RegistrationNewPlayer.Allow && app.Config().RegistrationNewPlayer.RequireInvite
+	existingPlayerInvite := app.Config().RegistrationExistingPlayer.Allow && app.Config().RegistrationExistingPlayer.RequireInvite
+	if !newPlayerInvite && !existingPlayerInvite {
+		return
+	}
+	var count int64
+	Check(app.DB.Model(&User{}).Count(&count).Error)
+	if count > 0 {
+		return
+	}
+	// No users, print an initial invite link to the console
+	var invite Invite
+	result := app.DB.First(&invite)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			// No invites yet, so create one
+			var err error
+			invite, err = app.CreateInvite()
+			Check(err)
+		} else {
+			log.Fatal(result.Error)
+		}
+	}
+	log.Println("No users found! Here's an invite URL:", Unwrap(InviteURL(app, &invite)))
+}
+
+// drasl rotate-data-key
+func rotateDataKey(config *Config) {
+	db, err := OpenDB(config)
+	Check(err)
+	dataCipher, err := LoadDataCipher(&config.DataEncryption)
+	Check(err)
+	if dataCipher == nil {
+		log.Fatal("DataEncryption.KeyFile must be set to rotate the data key")
+	}
+	count, err := RotateDataKey(WithDataCipher(db, dataCipher))
+	Check(err)
+	log.Printf("Re-encrypted the sensitive columns of %d users. Keys in DataEncryption.PreviousKeyFiles are no longer needed.\n", count)
+}
+
+// drasl key generate|show-public|rotate [file]
+func keyCommand(config *Config, args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: drasl key generate|show-public|rotate [file]")
+	}
+	keyPath := SigningKeyPath(config)
+	if len(args) > 1 {
+		keyPath = args[1]
+	}
+
+	switch args[0] {
+	case "generate":
+		if _, err := os.Stat(keyPath); err == nil {
+			log.Fatalf("%s already exists. Replace it with `drasl key rotate` instead.", keyPath)
+		}
+		key, err := GenerateSigningKey()
+		Check(err)
+		Check(WriteSigningKey(keyPath, key))
+		log.Printf("Wrote a new signing key to %s.\n", keyPath)
+	case "show-public":
+		key, err := ReadSigningKey(keyPath)
+		Check(err)
+		publicKey, err := authlibInjectorSerializeKey(&key.PublicKey)
+		Check(err)
+		fmt.Print(publicKey)
+	case "rotate":
+		_, err := RotateSigningKey(keyPath)
+		Check(err)
+		log.Printf("Wrote a new signing key to %s and kept the old one at %s.old. Restart Drasl to start using it. Players using authlib-injector have to restart their game to get the new public key.\n", keyPath, keyPath)
+	default:
+		log.Fatalf("Unknown key command %s", args[0])
+	}
+}
+
+// drasl check-config [-strict] [file]
+func checkConfig(defaultPath string, args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	strict := flags.Bool("strict", false, "Treat unknown config options as errors")
+	Check(flags.Parse(args))
+
+	configPath := defaultPath
+	if flags.NArg() > 0 {
+		configPath = flags.Arg(0)
+	}
+
+	result := CheckConfigFile(configPath)
+	for _, warning := range result.Warnings {
+		fmt.Println("Warning:", warning)
+	}
+	for _, err := range result.Errors {
+		fmt.Println("Error:", err)
+	}
+	if len(result.Errors) > 0 || (*strict && len(result.Warnings) > 0) {
+		fmt.Printf("%s is not valid.\n", configPath)
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid.\n", configPath)
+}
+
+// drasl doctor
+func doctor(config *Config) {
+	report := Doctor(config)
+	fmt.Println(report.String())
+	if report.Failed() > 0 {
+		os.Exit(1)
+	}
+}
+
+// drasl fsck [-repair]
+func fsck(config *Config, args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "Restore missing and corrupt textures from TextureCheck.BackupDirectories, or clear references to them if there's no backup")
+	Check(flags.Parse(args))
+
+	db, err := OpenDB(config)
+	Check(err)
+	app := &App{DB: db, FSMutex: KeyedMutex{}}
+	app.config.Store(config)
+
+	report, err := app.Fsck(*repair)
+	Check(err)
+	fmt.Println(report.String())
+	if len(report.Problems) > 0 && !*repair {
+		os.Exit(1)
+	}
+}
+
+// drasl textures find|clear|replace [-dry-run] skin|cape <hash> [file]
+func texturesCommand(config *Config, args []string) {
+	usage := "Usage: drasl textures find|clear|replace [-dry-run] skin|cape <hash> [file]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	flags := flag.NewFlagSet("textures "+args[0], flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Show what would change without changing anything")
+	Check(flags.Parse(args[1:]))
+	if flags.NArg() < 2 {
+		log.Fatal(usage)
+	}
+	kind := flags.Arg(0)
+	hash, err := ParseTextureHash(flags.Arg(1))
+	Check(err)
+
+	db, err := OpenDB(config)
+	Check(err)
+	app := &App{DB: db, FSMutex: KeyedMutex{}}
+	app.config.Store(config)
+
+	var report *BulkTextureReport
+	switch args[0] {
+	case "find":
+		report, err = app.FindTextureUsers(kind, hash)
+	case "clear":
+		report, err = app.BulkClearTexture(kind, hash, *dryRun)
+	case "replace":
+		if flags.NArg() < 3 {
+			log.Fatal(usage)
+		}
+		file, openErr := os.Open(flags.Arg(2))
+		Check(openErr)
+		defer file.Close()
+		report, err = app.BulkReplaceTexture(kind, hash, file, *dryRun)
+	default:
+		log.Fatalf("Unknown textures command %s", args[0])
+	}
+	Check(err)
+	fmt.Println(report.String())
+}
+
+func runBackgroundJobs(app *App) {
+	go app.RunSuspensionExpiry()
+	go app.BackfillSkinFingerprints()
+
+	if app.Config().TextureCheck.Enable {
+		go app.RunScheduledFsck()
+	}
+
+	if app.Config().SkinRotation.Allow {
+		go app.RunSkinRotation()
+	}
+
+	if app.Config().SessionHistory.Enable {
+		go app.RunSessionHistoryPruning()
+	}
+
+	if app.ChunkedUploads != nil {
+		go app.RunChunkedUploadCleanup()
+	}
+
+	if app.Config().APITokens.Allow {
+		go app.RunAPITokenUsagePruning()
+	}
+
+	go app.RunDeprecatedAPIUsagePruning()
+}
+
 func runServer(e *echo.Echo, listenAddress string) {
 	e.Logger.Fatal(e.Start(listenAddress))
 }
 
 func main() {
-	defaultConfigPath := path.Join(Constants.ConfigDirectory, "config.toml")
-
-	configPath := flag.String("config", defaultConfigPath, "Path to config file")
+	configPath := flag.String("config", "", fmt.Sprintf("Path to config file (default %s)", path.Join(Constants.ConfigDirectory, "config.toml")))
+	portable := flag.Bool("portable", false, "Keep the config file, DataDirectory, and StateDirectory next to the drasl executable by default")
 	help := flag.Bool("help", false, "Show help message")
 	flag.Parse()
 
 	if *help {
-		fmt.Println("Usage: drasl [options]")
+		fmt.Println("Usage: drasl [options] [command]")
 		fmt.Println("Options:")
 		flag.PrintDefaults()
+		fmt.Println("Commands:")
+		fmt.Println("  check-config [-strict] [file]\tValidate a config file, by default the one given with -config, without starting the server")
+		fmt.Println("  doctor\t\tCheck the keys, database, texture directories, BaseURL, SMTP server, and fallback API servers")
+		fmt.Println("  fsck [-repair]\tCheck that every skin and cape file is intact and every texture a user has exists")
+		fmt.Println("  key generate|show-public|rotate [file]\tCreate, print the public key of, or replace the signing key, by default SigningKeyFile")
+		fmt.Println("  rotate-data-key\tRe-encrypt sensitive database columns with DataEncryption.KeyFile")
+		fmt.Println("  setup\t\t\tCreate a config file and an admin account by answering a few questions")
+		fmt.Println("  textures find|clear|replace [-dry-run] skin|cape <hash> [file]\tList the users with a texture, take it away from all of them, or replace it with the texture in file")
 		os.Exit(0)
 	}
 
+	if *portable {
+		directories, err := PortableDirectories()
+		if err != nil {
+			log.Fatalf("Couldn't find the drasl executable: %s", err)
+		}
+		Constants.SetDirectories(directories)
+	}
+	if *configPath == "" {
+		*configPath = path.Join(Constants.ConfigDirectory, "config.toml")
+	}
+
+	// check-config reads the config itself, to report problems instead of
+	// exiting on the first one, and setup writes it
+	if flag.Arg(0) == "check-config" {
+		checkConfig(*configPath, flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "setup" {
+		Check(SetupWizard(os.Stdin, os.Stdout, *configPath))
+		return
+	}
+
 	config := ReadOrCreateConfig(*configPath)
+
+	switch flag.Arg(0) {
+	case "":
+	case "doctor":
+		doctor(config)
+		return
+	case "fsck":
+		fsck(config, flag.Args()[1:])
+		return
+	case "key":
+		keyCommand(config, flag.Args()[1:])
+		return
+	case "rotate-data-key":
+		rotateDataKey(config)
+		return
+	case "textures":
+		texturesCommand(config, flag.Args()[1:])
+		return
+	default:
+		log.Fatalf("Unknown command %s", flag.Arg(0))
+	}
+
+	tenantConfigs, err := ReadTenantConfigs(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	app := setup(config)
+	app.LogInitialInvite()
+	runBackgroundJobs(app)
+
+	if app.Config().Diagnostics.Enable {
+		go runServer(GetDiagnosticsServer(app), app.Config().Diagnostics.ListenAddress)
+	}
 
-	runServer(GetServer(app), app.Config.ListenAddress)
+	if len(tenantConfigs) == 0 {
+		listenAndServe(app.Config(), GetServer(app))
+		return
+	}
+
+	tenants := make([]tenant, 0, len(tenantConfigs))
+	for _, tenantConfig := range tenantConfigs {
+		tenantApp := setup(tenantConfig)
+		tenantApp.LogInitialInvite()
+		runBackgroundJobs(tenantApp)
+		tenants = append(tenants, tenant{App: tenantApp, Server: GetServer(tenantApp)})
+	}
+	handler := makeTenantHandler(tenant{App: app, Server: GetServer(app)}, tenants)
+	listenAndServe(app.Config(), handler)
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Prometheus metrics, served at /metrics on the Diagnostics listener in
+Prometheus's text exposition format. They show where slow logins come from:
+how long each fallback API server takes to answer, how often the request
+cache saves asking it at all, and how much skin and cape traffic Drasl
+serves itself. Metrics are kept in memory and start over when Drasl
+restarts, as Prometheus expects of counters.
+*/
+
+// Names of the lookups CachedGet makes, for the cache metrics
+const (
+	FallbackCacheProfile string = "profile"
+	FallbackCacheUUID    string = "uuid"
+)
+
+// Upper bounds, in seconds, of the buckets of the fallback latency histogram
+var FALLBACK_LATENCY_BUCKETS = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	// Observations per bucket, not cumulative; the last is for those past
+	// every bound
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type cacheMetricKey struct {
+	Cache string
+	Hit   bool
+}
+
+// Safe for concurrent use. A nil *Metrics records nothing, for commands
+// like fsck that don't serve requests.
+type Metrics struct {
+	mutex            sync.Mutex
+	fallbackLatency  map[string]*histogram
+	cacheLookups     map[cacheMetricKey]uint64
+	textureResponses map[string]uint64
+	textureBytes     map[string]uint64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		fallbackLatency:  map[string]*histogram{},
+		cacheLookups:     map[cacheMetricKey]uint64{},
+		textureResponses: map[string]uint64{},
+		textureBytes:     map[string]uint64{},
+	}
+}
+
+// Record how long a request to the fallback API server named `nickname`
+// took, whether or not it succeeded
+func (metrics *Metrics) ObserveFallbackLatency(nickname string, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	h, ok := metrics.fallbackLatency[nickname]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(FALLBACK_LATENCY_BUCKETS)+1)}
+		metrics.fallbackLatency[nickname] = h
+	}
+	seconds := duration.Seconds()
+	i := sort.SearchFloat64s(FALLBACK_LATENCY_BUCKETS, seconds)
+	h.counts[i] += 1
+	h.sum += seconds
+	h.count += 1
+}
+
+func (metrics *Metrics) RecordCacheLookup(cache string, hit bool) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.cacheLookups[cacheMetricKey{Cache: cache, Hit: hit}] += 1
+}
+
+// Record a skin or cape response of `size` bytes. `textureType` is the
+// directory it was served from, e.g. "skin" or "default-cape".
+func (metrics *Metrics) RecordTextureResponse(textureType string, size int64) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.textureResponses[textureType] += 1
+	metrics.textureBytes[textureType] += uint64(size)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Write every metric in Prometheus's text exposition format
+func (metrics *Metrics) WritePrometheus(w io.Writer) error {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	var b strings.Builder
+	header := func(name string, metricType string, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	}
+
+	header("drasl_fallback_request_duration_seconds", "histogram", "Time taken by requests to fallback API servers, including failed ones.")
+	for _, nickname := range sortedKeys(metrics.fallbackLatency) {
+		h := metrics.fallbackLatency[nickname]
+		server := escapeLabelValue(nickname)
+		var cumulative uint64 = 0
+		for i, bound := range FALLBACK_LATENCY_BUCKETS {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "drasl_fallback_request_duration_seconds_bucket{server=\"%s\",le=\"%s\"} %d\n", server, formatFloat(bound), cumulative)
+		}
+		fmt.Fprintf(&b, "drasl_fallback_request_duration_seconds_bucket{server=\"%s\",le=\"+Inf\"} %d\n", server, h.count)
+		fmt.Fprintf(&b, "drasl_fallback_request_duration_seconds_sum{server=\"%s\"} %s\n", server, formatFloat(h.sum))
+		fmt.Fprintf(&b, "drasl_fallback_request_duration_seconds_count{server=\"%s\"} %d\n", server, h.count)
+	}
+
+	header("drasl_fallback_cache_lookups_total", "counter", "Lookups of fallback API server responses in the request cache, by what was looked up and whether it was found.")
+	for _, cache := range []string{FallbackCacheProfile, FallbackCacheUUID} {
+		for _, hit := range []bool{true, false} {
+			result := "miss"
+			if hit {
+				result = "hit"
+			}
+			fmt.Fprintf(&b, "drasl_fallback_cache_lookups_total{cache=\"%s\",result=\"%s\"} %d\n", cache, result, metrics.cacheLookups[cacheMetricKey{Cache: cache, Hit: hit}])
+		}
+	}
+
+	header("drasl_texture_responses_total", "counter", "Skins and capes served, by type.")
+	for _, textureType := range sortedKeys(metrics.textureResponses) {
+		fmt.Fprintf(&b, "drasl_texture_responses_total{type=\"%s\"} %d\n", escapeLabelValue(textureType), metrics.textureResponses[textureType])
+	}
+	header("drasl_texture_response_bytes_total", "counter", "Bytes of skins and capes served, by type.")
+	for _, textureType := range sortedKeys(metrics.textureBytes) {
+		fmt.Fprintf(&b, "drasl_texture_response_bytes_total{type=\"%s\"} %d\n", escapeLabelValue(textureType), metrics.textureBytes[textureType])
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Count the skins and capes served from /drasl/texture/
+func makeTextureMetricsMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+			switch c.Path() {
+			// Routes added with e.Static end in a bare "*"
+			case "/drasl/texture/cape*",
+				"/drasl/texture/skin*",
+				"/drasl/texture/default-cape*",
+				"/drasl/texture/default-skin*",
+				"/drasl/texture/fallback*":
+				res := c.Response()
+				if err == nil && res.Status < http.StatusBadRequest {
+					textureType := strings.TrimSuffix(strings.TrimPrefix(c.Path(), "/drasl/texture/"), "*")
+					app.Metrics.RecordTextureResponse(textureType, res.Size)
+				}
+			}
+			return err
+		}
+	}
+}
+
+// GET /metrics
+func DiagnosticsMetrics(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		c.Response().WriteHeader(http.StatusOK)
+		return app.Metrics.WritePrometheus(c.Response())
+	}
+}
@@ -8,11 +8,17 @@ import (
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/nbutton23/zxcvbn-go"
 	"golang.org/x/crypto/scrypt"
+	"gorm.io/gorm"
+	"log"
 	"lukechampine.com/blake3"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -62,20 +68,54 @@ func IDToUUID(id string) (string, error) {
 	return id[0:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:], nil
 }
 
+// Regular expression and length limits enforced by Mojang, used when
+// MojangCompatiblePlayerNames is set
+var mojangPlayerNameRegex = regexp.MustCompile("^[a-zA-Z0-9_]+$")
+
+const MOJANG_MIN_PLAYER_NAME_LENGTH = 3
+const MOJANG_MAX_PLAYER_NAME_LENGTH = 16
+
 func ValidatePlayerName(app *App, playerName string) error {
 	if TransientLoginEligible(app, playerName) {
 		return errors.New("name is reserved for transient login")
 	}
-	maxLength := app.Constants.MaxPlayerNameLength
 	if playerName == "" {
 		return errors.New("can't be blank")
 	}
-	if len(playerName) > maxLength {
+
+	minLength := app.Config().MinPlayerNameLength
+	maxLength := app.Config().MaxPlayerNameLength
+	if app.Config().MojangCompatiblePlayerNames {
+		if minLength < MOJANG_MIN_PLAYER_NAME_LENGTH {
+			minLength = MOJANG_MIN_PLAYER_NAME_LENGTH
+		}
+		if maxLength > MOJANG_MAX_PLAYER_NAME_LENGTH {
+			maxLength = MOJANG_MAX_PLAYER_NAME_LENGTH
+		}
+	}
+	length := utf8.RuneCountInString(playerName)
+	if length < minLength {
+		return fmt.Errorf("can't be shorter than %d characters", minLength)
+	}
+	if length > maxLength {
 		return fmt.Errorf("can't be longer than %d characters", maxLength)
 	}
 
+	if !app.Config().AllowUnicodePlayerNames {
+		for _, r := range playerName {
+			if r > unicode.MaxASCII {
+				return errors.New("can only contain ASCII characters")
+			}
+		}
+	}
+	if app.Config().MojangCompatiblePlayerNames && !mojangPlayerNameRegex.MatchString(playerName) {
+		return errors.New("can only contain letters, numbers, and underscores")
+	}
 	if !app.ValidPlayerNameRegex.MatchString(playerName) {
-		return fmt.Errorf("must match the following regular expression: %s", app.Config.ValidPlayerNameRegex)
+		return fmt.Errorf("must match the following regular expression: %s", app.Config().ValidPlayerNameRegex)
+	}
+	if app.Config().Floodgate.Enable && app.Config().Floodgate.UsernamePrefix != "" && strings.HasPrefix(playerName, app.Config().Floodgate.UsernamePrefix) {
+		return fmt.Errorf("can't start with %s, which is reserved for Bedrock players", app.Config().Floodgate.UsernamePrefix)
 	}
 	return nil
 }
@@ -96,6 +136,58 @@ func ValidatePlayerNameOrUUID(app *App, player string) error {
 	return nil
 }
 
+// Whether playerName is another user's player name or username. Users can
+// log in with either, so a player name may not be taken as either one.
+func PlayerNameTaken(db *gorm.DB, playerName string, exceptUUID string) (bool, error) {
+	normalized := NormalizeName(playerName)
+	var count int64
+	err := db.Model(&User{}).
+		Where("(normalized_player_name = ? OR normalized_username = ?) AND uuid != ?", normalized, normalized, exceptUUID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// When user may next change their player name, PlayerNameChange.CooldownDays
+// after they last changed it or registered
+func PlayerNameChangeAvailableAt(app *App, user *User) time.Time {
+	return user.NameLastChangedAt.AddDate(0, 0, app.Config().PlayerNameChange.CooldownDays)
+}
+
+// Whether user may change their own player name right now. Admins may always
+// change it.
+func PlayerNameChangeAllowed(app *App, user *User) bool {
+	if user.IsAdmin {
+		return true
+	}
+	return app.Config().AllowChangingPlayerName && !time.Now().Before(PlayerNameChangeAvailableAt(app, user))
+}
+
+var errPlayerNameChangeNotAllowed = errors.New("changing player name not allowed")
+var errPlayerNameChangeCooldown = errors.New("player name changed too recently")
+var errPlayerNameTaken = errors.New("player name taken")
+
+// Check whether actor may change user's player name to playerName, which
+// should already be validated with ValidatePlayerName. Admins may change any
+// player name, but not to one that's taken.
+func CheckPlayerNameChange(app *App, actor *User, user *User, playerName string) error {
+	if !actor.IsAdmin {
+		if !app.Config().AllowChangingPlayerName {
+			return errPlayerNameChangeNotAllowed
+		}
+		if time.Now().Before(PlayerNameChangeAvailableAt(app, user)) {
+			return errPlayerNameChangeCooldown
+		}
+	}
+	taken, err := PlayerNameTaken(app.DB, playerName, user.UUID)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errPlayerNameTaken
+	}
+	return nil
+}
+
 func MakeTransientUser(app *App, playerName string) (User, error) {
 	preimage := bytes.Join([][]byte{
 		[]byte("uuid"),
@@ -116,7 +208,7 @@ func MakeTransientUser(app *App, playerName string) (User, error) {
 		PasswordHash:      []byte{},
 		Clients:           []Client{},
 		PlayerName:        playerName,
-		PreferredLanguage: app.Config.DefaultPreferredLanguage,
+		PreferredLanguage: app.Config().DefaultPreferredLanguage,
 		SkinModel:         SkinModelClassic,
 		BrowserToken:      MakeNullString(nil),
 		CreatedAt:         time.Now(),
@@ -126,19 +218,26 @@ func MakeTransientUser(app *App, playerName string) (User, error) {
 }
 
 func TransientLoginEligible(app *App, playerName string) bool {
-	return app.Config.TransientUsers.Allow &&
+	return app.Config().TransientUsers.Allow &&
 		app.TransientUsernameRegex.MatchString(playerName) &&
-		len(playerName) <= app.Constants.MaxPlayerNameLength
+		utf8.RuneCountInString(playerName) <= app.Config().MaxPlayerNameLength
 }
 
 func ValidatePassword(app *App, password string) error {
 	if password == "" {
 		return errors.New("can't be blank")
 	}
-	if len(password) < app.Config.MinPasswordLength {
-		message := fmt.Sprintf("password must be longer than %d characters", app.Config.MinPasswordLength)
+	if len(password) < app.Config().MinPasswordLength {
+		message := fmt.Sprintf("password must be longer than %d characters", app.Config().MinPasswordLength)
 		return errors.New(message)
 	}
+	if app.Config().MinPasswordStrength > 0 {
+		// zxcvbn scores passwords from 0 (weakest) to 4 (strongest)
+		score := zxcvbn.PasswordStrength(password, nil).Score
+		if score < app.Config().MinPasswordStrength {
+			return fmt.Errorf("password is too weak (strength %d out of 4, must be at least %d)", score, app.Config().MinPasswordStrength)
+		}
+	}
 	return nil
 }
 
@@ -233,7 +332,14 @@ func HashPassword(password string, salt []byte) ([]byte, error) {
 	)
 }
 
+// URL of a skin as advertised to Minecraft clients, on TextureBaseURL
 func SkinURL(app *App, hash string) (string, error) {
+	return url.JoinPath(app.TextureURL, "drasl/texture/skin/"+hash+".png")
+}
+
+// URL of a skin for the web front end, which is always on BaseURL so that
+// pages don't load images from another origin
+func FrontEndSkinURL(app *App, hash string) (string, error) {
 	return url.JoinPath(app.FrontEndURL, "drasl/texture/skin/"+hash+".png")
 }
 
@@ -249,7 +355,7 @@ func UserSkinURL(app *App, user *User) (*string, error) {
 	if !user.SkinHash.Valid {
 		return nil, nil
 	}
-	url, err := SkinURL(app, user.SkinHash.String)
+	url, err := FrontEndSkinURL(app, user.SkinHash.String)
 	if err != nil {
 		return nil, err
 	}
@@ -257,6 +363,10 @@ func UserSkinURL(app *App, user *User) (*string, error) {
 }
 
 func CapeURL(app *App, hash string) (string, error) {
+	return url.JoinPath(app.TextureURL, "drasl/texture/cape/"+hash+".png")
+}
+
+func FrontEndCapeURL(app *App, hash string) (string, error) {
 	return url.JoinPath(app.FrontEndURL, "drasl/texture/cape/"+hash+".png")
 }
 
@@ -266,6 +376,33 @@ type Client struct {
 	Version     int
 	UserUUID    string
 	User        User
+	// Chosen by the user on their profile page, e.g. "Laptop"
+	Name       string
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// An auth-only client can sign in and join servers but can't change the
+	// user's profile through the services API
+	AuthOnly bool `gorm:"not null;default:false"`
+}
+
+const MAX_CLIENT_NAME_LENGTH = 64
+
+// LastUsedAt is only written when it's at least this stale, so that using a
+// token doesn't write to the database on every request
+const CLIENT_LAST_USED_RESOLUTION = time.Minute
+
+// Whether clientToken belongs to one of the user's existing clients.
+// user.Clients must be preloaded.
+func (user *User) HasClient(clientToken *string) bool {
+	if clientToken == nil {
+		return false
+	}
+	for _, client := range user.Clients {
+		if client.ClientToken == *clientToken {
+			return true
+		}
+	}
+	return false
 }
 
 type TokenClaims struct {
@@ -278,14 +415,14 @@ var DISTANT_FUTURE time.Time = Unwrap(time.Parse(time.RFC3339Nano, "2038-01-01T0
 
 func (app *App) MakeAccessToken(client Client) (string, error) {
 	var expiresAt time.Time
-	if app.Config.TokenExpireSec > 0 {
-		expiresAt = time.Now().Add(time.Duration(app.Config.TokenExpireSec) * time.Second)
+	if app.Config().TokenExpireSec > 0 {
+		expiresAt = time.Now().Add(time.Duration(app.Config().TokenExpireSec) * time.Second)
 	} else {
 		expiresAt = DISTANT_FUTURE
 	}
 	var staleAt time.Time
-	if app.Config.TokenStaleSec > 0 {
-		staleAt = time.Now().Add(time.Duration(app.Config.TokenStaleSec) * time.Second)
+	if app.Config().TokenStaleSec > 0 {
+		staleAt = time.Now().Add(time.Duration(app.Config().TokenStaleSec) * time.Second)
 	} else {
 		staleAt = DISTANT_FUTURE
 	}
@@ -311,7 +448,9 @@ const (
 	StalePolicyDeny
 )
 
-func (app *App) GetClient(accessToken string, stalePolicy StaleTokenPolicy) *Client {
+// The claims of an access token signed by this instance, or nil if it isn't
+// one or it has expired
+func (app *App) ParseAccessToken(accessToken string) *TokenClaims {
 	token, err := jwt.ParseWithClaims(accessToken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return app.Key.Public(), nil
 	})
@@ -325,6 +464,14 @@ func (app *App) GetClient(accessToken string, stalePolicy StaleTokenPolicy) *Cli
 	if !ok {
 		return nil
 	}
+	return claims
+}
+
+func (app *App) GetClient(accessToken string, stalePolicy StaleTokenPolicy) *Client {
+	claims := app.ParseAccessToken(accessToken)
+	if claims == nil {
+		return nil
+	}
 
 	var client Client
 	result := app.DB.Preload("User").First(&client, "uuid = ?", claims.RegisteredClaims.Subject)
@@ -337,12 +484,21 @@ func (app *App) GetClient(accessToken string, stalePolicy StaleTokenPolicy) *Cli
 	if claims.Subject != client.UUID || claims.Version != client.Version {
 		return nil
 	}
+	if now := time.Now(); now.Sub(client.LastUsedAt) >= CLIENT_LAST_USED_RESOLUTION {
+		client.LastUsedAt = now
+		if err := app.DB.Model(&Client{}).Where("uuid = ?", client.UUID).Update("last_used_at", now).Error; err != nil {
+			log.Printf("Couldn't update last use of client %s: %s", client.UUID, err)
+		}
+	}
 	return &client
 }
 
 type User struct {
-	IsAdmin           bool
-	IsLocked          bool
+	IsAdmin  bool
+	IsLocked bool
+	// If set, IsLocked is a suspension that's lifted at this time
+	LockedUntil       sql.NullTime
+	IsPendingApproval bool     `gorm:"not null;default:false"`
 	UUID              string   `gorm:"primaryKey"`
 	Username          string   `gorm:"unique;not null"`
 	PasswordSalt      []byte   `gorm:"not null"`
@@ -359,9 +515,472 @@ type User struct {
 	CapeHash          sql.NullString `gorm:"index"`
 	CreatedAt         time.Time
 	NameLastChangedAt time.Time
+	// Kept up to date by gorm; see ProfileLastModified
+	UpdatedAt time.Time
+
+	// Optional; only used when Email.Enable is set
+	Email         sql.NullString `gorm:"index"`
+	EmailVerified bool           `gorm:"not null;default:false"`
+	EmailOptOut   bool           `gorm:"not null;default:false"`
+
+	// Address and time of the most recent /session/minecraft/join, so
+	// TrustedServers can check where a player joined from
+	JoinIP   sql.NullString
+	JoinedAt sql.NullTime
+
+	// Set when the user doesn't want security notification emails
+	SecurityNotificationsOptOut bool `gorm:"not null;default:false"`
+
+	// The user's skin rotation schedule; see skin_rotation.go. SkinRotatedOn
+	// is the last day, like "2006-01-02", the schedule changed their skin.
+	RotateSkinsDaily bool `gorm:"not null;default:false"`
+	SkinRotatedOn    sql.NullString
+
+	// The TermsOfService.Version the user last accepted, and when
+	AcceptedTermsVersion sql.NullString
+	AcceptedTermsAt      sql.NullTime
+
+	// Set by admins to stop the user changing their own skin or cape; see
+	// texture_locks.go
+	SkinLocked bool `gorm:"not null;default:false"`
+	CapeLocked bool `gorm:"not null;default:false"`
+
+	// A password hash in another program's format, set when migrating
+	// accounts from it; see passwords.go
+	ImportedPasswordHash sql.NullString
+
+	// Lowercased copies of Username and PlayerName, kept up to date by
+	// BeforeSave, so that names differing only in case can't coexist
+	NormalizedUsername   string `gorm:"uniqueIndex"`
+	NormalizedPlayerName string `gorm:"uniqueIndex"`
+
+	// Set when an admin is signed in as this user; see
+	// withBrowserAuthentication
+	ImpersonatedBy *User `gorm:"-"`
+}
+
+func (user *User) BeforeSave(tx *gorm.DB) error {
+	user.NormalizedUsername = NormalizeName(user.Username)
+	user.NormalizedPlayerName = NormalizeName(user.PlayerName)
+	return encryptColumns(tx, user.sensitiveColumns())
+}
+
+func (user *User) AfterSave(tx *gorm.DB) error {
+	return decryptColumns(tx, user.sensitiveColumns())
+}
+
+func (user *User) AfterFind(tx *gorm.DB) error {
+	return decryptColumns(tx, user.sensitiveColumns())
+}
+
+// Columns encrypted when DataEncryption is configured; see
+// data_encryption.go
+func (user *User) sensitiveColumns() []*sql.NullString {
+	return []*sql.NullString{&user.Email, &user.JoinIP}
+}
+
+// Usernames and player names are unique regardless of case. The original
+// capitalization is kept for display.
+func NormalizeName(name string) string {
+	return strings.ToLower(name)
 }
 
 type Invite struct {
 	Code      string `gorm:"primaryKey"`
 	CreatedAt time.Time
 }
+
+// An invite code created by Bootstrap, kept so the invite isn't created
+// again once it's used
+type BootstrappedInvite struct {
+	Code      string `gorm:"primaryKey"`
+	CreatedAt time.Time
+}
+
+// Recorded as the actor of audit log entries that Drasl makes on its own
+const AUDIT_SYSTEM_ACTOR = "(Drasl)"
+
+// A record of a sensitive action taken by an admin. Names are copied so that
+// entries stay readable after the users involved are renamed or deleted.
+type AuditLogEntry struct {
+	ID             uint `gorm:"primaryKey"`
+	CreatedAt      time.Time
+	ActorUUID      string `gorm:"index"`
+	ActorUsername  string
+	Action         string
+	TargetUUID     sql.NullString `gorm:"index"`
+	TargetUsername string
+	Details        string
+}
+
+const (
+	AuditActionImpersonationStart       string = "impersonation-start"
+	AuditActionImpersonationStop        string = "impersonation-stop"
+	AuditActionImpersonationRequest     string = "impersonation-request"
+	AuditActionGroupLock                string = "group-lock"
+	AuditActionGroupUnlock              string = "group-unlock"
+	AuditActionGroupSetCape             string = "group-set-cape"
+	AuditActionGroupDeleteCape          string = "group-delete-cape"
+	AuditActionSendEmail                string = "send-email"
+	AuditActionApproveUser              string = "approve-user"
+	AuditActionRejectUser               string = "reject-user"
+	AuditActionCreateGiftCodes          string = "create-gift-codes"
+	AuditActionDeleteGiftCodes          string = "delete-gift-codes"
+	AuditActionCreateForwardingSecret   string = "create-forwarding-secret"
+	AuditActionRotateForwardingSecret   string = "rotate-forwarding-secret"
+	AuditActionDeleteForwardingSecret   string = "delete-forwarding-secret"
+	AuditActionUpdateSettings           string = "update-settings"
+	AuditActionUpdateFallbackAPIServers string = "update-fallback-api-servers"
+	AuditActionSetProfileProperties     string = "set-profile-properties"
+	AuditActionCreateCosmetic           string = "create-cosmetic"
+	AuditActionDeleteCosmetic           string = "delete-cosmetic"
+	AuditActionGrantCosmetic            string = "grant-cosmetic"
+	AuditActionRevokeCosmetic           string = "revoke-cosmetic"
+	AuditActionImportProfile            string = "import-profile"
+	AuditActionResolveReport            string = "resolve-report"
+	AuditActionSuspendUser              string = "suspend-user"
+	AuditActionLiftSuspension           string = "lift-suspension"
+	AuditActionRequestAdminAction       string = "request-admin-action"
+	AuditActionApproveAdminAction       string = "approve-admin-action"
+	AuditActionCancelAdminAction        string = "cancel-admin-action"
+	AuditActionSetTextureLocks          string = "set-texture-locks"
+	AuditActionBulkClearTexture         string = "bulk-clear-texture"
+	AuditActionBulkReplaceTexture       string = "bulk-replace-texture"
+	AuditActionSetAPITokenLimits        string = "set-api-token-limits"
+)
+
+// A named set of users that admins can act on all at once
+type Group struct {
+	ID        uint   `gorm:"primaryKey"`
+	Name      string `gorm:"unique;not null;type:text collate nocase"`
+	CreatedAt time.Time
+}
+
+type GroupMembership struct {
+	GroupID  uint   `gorm:"primaryKey"`
+	UserUUID string `gorm:"primaryKey;index"`
+}
+
+const MAX_GROUP_NAME_LENGTH = 64
+
+func ValidateGroupName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("can't be blank")
+	}
+	if utf8.RuneCountInString(name) > MAX_GROUP_NAME_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_GROUP_NAME_LENGTH)
+	}
+	return nil
+}
+
+// A count of events of one kind on one day, for the statistics dashboard
+type DailyStat struct {
+	Day   string `gorm:"primaryKey"` // YYYY-MM-DD, UTC
+	Name  string `gorm:"primaryKey"`
+	Count int64
+}
+
+// Number of requests by a client, identified by its User-Agent, to a
+// deprecated API route on a given day
+type DeprecatedAPIUsage struct {
+	Day      string `gorm:"primaryKey"` // YYYY-MM-DD, UTC
+	Route    string `gorm:"primaryKey"` // e.g. "GET /drasl/api/v1/info"
+	Client   string `gorm:"primaryKey"`
+	Requests int64  `gorm:"not null"`
+}
+
+// Size of a skin or cape file, so users' storage usage can be summed, filtered,
+// and sorted in SQL. Textures are named by their hash, so sizes never change.
+type TextureSize struct {
+	Kind string `gorm:"primaryKey"` // "skin" or "cape"
+	Hash string `gorm:"primaryKey"`
+	Size int64  `gorm:"not null"`
+}
+
+// A user who joined a server on a given day
+type DailyActivePlayer struct {
+	Day      string `gorm:"primaryKey"`
+	UserUUID string `gorm:"primaryKey"`
+}
+
+// An unexpected error from a request handler. Only the most recent
+// MAX_ERROR_LOG_ENTRIES are kept.
+type ErrorLogEntry struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Method    string
+	Path      string
+	Message   string
+}
+
+// A pending sign-in from a launcher or other device using the device login
+// flow. UserUUID is set once a signed-in user approves the request.
+type DeviceAuthorization struct {
+	DeviceCode   string `gorm:"primaryKey"`
+	UserCode     string `gorm:"uniqueIndex"`
+	UserUUID     sql.NullString
+	Denied       bool `gorm:"not null;default:false"`
+	CreatedAt    time.Time
+	ExpiresAt    time.Time `gorm:"index"`
+	LastPolledAt time.Time
+}
+
+// A single-use code, shown as a QR code by a signed-in browser, that lets a
+// second device sign in as the same user
+type QRLogin struct {
+	Token     string `gorm:"primaryKey"`
+	UserUUID  string `gorm:"index"`
+	CreatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// A code that gives a cape to whoever redeems it, minted by an admin for an
+// event or giveaway. Codes minted together share a Name.
+type GiftCode struct {
+	Code     string `gorm:"primaryKey"`
+	Name     string `gorm:"index"`
+	CapeHash string `gorm:"index;not null"`
+	// 0 means unlimited
+	MaxUses   int `gorm:"not null;default:0"`
+	Uses      int `gorm:"not null;default:0"`
+	ExpiresAt sql.NullTime
+	CreatedAt time.Time
+}
+
+// Each user can redeem a code at most once
+type GiftCodeRedemption struct {
+	Code       string `gorm:"primaryKey"`
+	UserUUID   string `gorm:"primaryKey;index"`
+	RedeemedAt time.Time
+}
+
+// There is at most one Announcement, with ID 1
+type Announcement struct {
+	ID        uint `gorm:"primaryKey"`
+	Markdown  string
+	UpdatedAt time.Time
+}
+
+// The player info forwarding secret shared by a proxy and one of its backend
+// Minecraft servers, e.g. for Velocity's modern forwarding. Backend is the
+// Nickname of the backend's TrustedServers entry.
+type ForwardingSecret struct {
+	Backend   string `gorm:"primaryKey"`
+	Secret    string `gorm:"not null"`
+	CreatedAt time.Time
+	RotatedAt time.Time
+}
+
+// A Bedrock player, identified by their XUID, linked to a Drasl account; see
+// floodgate.go
+type BedrockLink struct {
+	XUID      string `gorm:"primaryKey;column:xuid"`
+	UserUUID  string `gorm:"uniqueIndex;not null"`
+	Gamertag  string
+	CreatedAt time.Time
+}
+
+// An existing account, e.g. a Mojang account, linked to a Drasl account; see
+// account_linking.go
+type LinkedAccount struct {
+	// UUID of the existing account
+	UUID       string `gorm:"primaryKey"`
+	UserUUID   string `gorm:"uniqueIndex;not null"`
+	Source     string `gorm:"not null"`
+	PlayerName string
+	CreatedAt  time.Time
+}
+
+// A skin and cape copied from a user's linked account; see profile_import.go
+type ProfileImport struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserUUID string `gorm:"index;not null"`
+	// Nickname of the fallback API server the profile came from
+	Source     string
+	LinkedUUID string
+	PlayerName string
+	// Player names the linked account has had, oldest first, separated by
+	// ", "
+	NameHistory  string
+	ImportedSkin bool
+	ImportedCape bool
+	CreatedAt    time.Time
+}
+
+// A user's report of another player; see reports.go
+type Report struct {
+	ID         uint   `gorm:"primaryKey"`
+	TargetUUID string `gorm:"index;not null"`
+	// The target's player name and skin when they were reported, since they
+	// may have changed them since
+	TargetPlayerName string
+	TargetSkinHash   sql.NullString
+	ReporterUUID     string `gorm:"index;not null"`
+	Reason           string `gorm:"not null"`
+	Details          string
+	Status           string `gorm:"index;not null"`
+	// What the admin who resolved the report did, one of the ReportAction
+	// values
+	Resolution     sql.NullString
+	ResolvedByUUID sql.NullString
+	ResolvedAt     sql.NullTime
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+// A note an admin left on a user, only shown to admins; see moderation.go
+type UserNote struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserUUID string `gorm:"index;not null"`
+	// Copied so the note stays readable after its author is renamed or
+	// deleted
+	AuthorUUID     string
+	AuthorUsername string
+	Body           string `gorm:"not null"`
+	CreatedAt      time.Time
+}
+
+// A destructive admin action waiting for a second admin's approval; see
+// admin_approvals.go
+type PendingAdminAction struct {
+	ID uint `gorm:"primaryKey"`
+	// One of the AdminAction values
+	Action string `gorm:"not null"`
+	Target string `gorm:"not null"`
+	// For actions on a user, their UUID, since Target, their username, can
+	// change before the action is approved
+	TargetUUID          string
+	RequestedByUUID     string `gorm:"not null"`
+	RequestedByUsername string
+	CreatedAt           time.Time
+	ExpiresAt           time.Time `gorm:"index"`
+}
+
+func (pending *PendingAdminAction) Describe() string {
+	return pending.Action + " " + pending.Target
+}
+
+// A player's join, as verified by a server through hasJoined; see
+// session_history.go
+type SessionRecord struct {
+	ID       uint   `gorm:"primaryKey"`
+	UserUUID string `gorm:"index"`
+	// See HashServerAddress
+	ServerAddressHash string
+	// The player's IP address
+	IP        sql.NullString
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (record *SessionRecord) BeforeSave(tx *gorm.DB) error {
+	return encryptColumns(tx, []*sql.NullString{&record.IP})
+}
+
+func (record *SessionRecord) AfterSave(tx *gorm.DB) error {
+	return decryptColumns(tx, []*sql.NullString{&record.IP})
+}
+
+func (record *SessionRecord) AfterFind(tx *gorm.DB) error {
+	return decryptColumns(tx, []*sql.NullString{&record.IP})
+}
+
+// A code a user enters on a Bedrock server to link their Bedrock account
+type BedrockLinkCode struct {
+	Code      string    `gorm:"primaryKey"`
+	UserUUID  string    `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// An account command a player ran in game, waiting for them to confirm it
+// with Code; see in_game_commands.go
+type PendingInGameCommand struct {
+	// A player has at most one command waiting
+	UserUUID string `gorm:"primaryKey"`
+	Action   string `gorm:"not null"`
+	Code     string `gorm:"not null"`
+	// Nickname of the TrustedServer it was run on
+	TrustedServer string    `gorm:"not null"`
+	Attempts      int       `gorm:"not null;default:0"`
+	ExpiresAt     time.Time `gorm:"index"`
+}
+
+// A personal API token a user minted to automate changes to their profile;
+// see api_tokens.go
+type APIToken struct {
+	ID        string `gorm:"primaryKey"`
+	UserUUID  string `gorm:"index;not null"`
+	User      User
+	Name      string
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	// Comma-separated, from API_TOKEN_SCOPES
+	Scopes     string `gorm:"not null"`
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	// Set by an admin to override APITokens.RequestsPerMinute and
+	// APITokens.DailyQuota for this token; see api_token_limits.go
+	RequestsPerMinute sql.NullInt64
+	DailyQuota        sql.NullInt64
+}
+
+// Number of requests made with an API token on a given day
+type APITokenUsage struct {
+	APITokenID string `gorm:"primaryKey"`
+	Day        string `gorm:"primaryKey;index"` // YYYY-MM-DD, UTC
+	Requests   int64  `gorm:"not null"`
+}
+
+func (apiToken *APIToken) ScopeList() []string {
+	return strings.Split(apiToken.Scopes, ",")
+}
+
+func (apiToken *APIToken) HasScope(scope string) bool {
+	return Contains(apiToken.ScopeList(), scope)
+}
+
+// A skin saved to a user's library, for skin rotation; see skin_rotation.go
+type LibrarySkin struct {
+	ID        string `gorm:"primaryKey"`
+	UserUUID  string `gorm:"index;not null"`
+	Name      string
+	SkinHash  string `gorm:"index;not null"`
+	SkinModel string `gorm:"not null"`
+	// A month and day, like "12-25", on which the skin is worn every year,
+	// or empty
+	Date      string `gorm:"index;not null;default:''"`
+	CreatedAt time.Time
+}
+
+// A skin's perceptual hash, for finding similar skins; see
+// skin_similarity.go. Kept after the skin itself is deleted.
+type SkinFingerprint struct {
+	SkinHash string `gorm:"primaryKey"`
+	// The 64-bit hash's bits, as SQLite has no unsigned integers
+	PerceptualHash int64 `gorm:"not null"`
+	// PerceptualHash split into bytes, to look up candidates by
+	Band0 uint8 `gorm:"index;not null"`
+	Band1 uint8 `gorm:"index;not null"`
+	Band2 uint8 `gorm:"index;not null"`
+	Band3 uint8 `gorm:"index;not null"`
+	Band4 uint8 `gorm:"index;not null"`
+	Band5 uint8 `gorm:"index;not null"`
+	Band6 uint8 `gorm:"index;not null"`
+	Band7 uint8 `gorm:"index;not null"`
+}
+
+// A user's skin, skin model, and cape at some point; see
+// appearance_history.go
+type AppearanceSnapshot struct {
+	ID        uint           `gorm:"primaryKey"`
+	UserUUID  string         `gorm:"index;not null"`
+	SkinHash  sql.NullString `gorm:"index"`
+	SkinModel string
+	CapeHash  sql.NullString `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// An option of the config file changed from the Admin settings page; see
+// config_overrides.go. Value is JSON.
+type ConfigOverride struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string `gorm:"not null"`
+	UpdatedAt time.Time
+}
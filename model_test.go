@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"regexp"
+	"testing"
+)
+
+func TestModel(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test player name validation", ts.testValidatePlayerName)
+		t.Run("Test case-insensitive name uniqueness", ts.testNameUniqueness)
+	}
+}
+
+func (ts *TestSuite) testValidatePlayerName(t *testing.T) {
+	assert.Nil(t, ValidatePlayerName(ts.App, "Steve"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, ""))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "has spaces"))
+
+	// Length limits count characters, not bytes
+	ts.App.Config().MinPlayerNameLength = 3
+	ts.App.Config().MaxPlayerNameLength = 5
+	assert.NotNil(t, ValidatePlayerName(ts.App, "ab"))
+	assert.Nil(t, ValidatePlayerName(ts.App, "abc"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "abcdef"))
+	ts.App.Config().MinPlayerNameLength = 1
+	ts.App.Config().MaxPlayerNameLength = Constants.MaxPlayerNameLength
+
+	// Unicode is allowed if the regex allows it, unless it's disabled
+	ts.App.Config().ValidPlayerNameRegex = ".+"
+	ts.App.ValidPlayerNameRegex = regexp.MustCompile(".+")
+	assert.Nil(t, ValidatePlayerName(ts.App, "Stéve"))
+	assert.Nil(t, ValidatePlayerName(ts.App, "a long name with spaces"))
+	ts.App.Config().AllowUnicodePlayerNames = false
+	assert.NotNil(t, ValidatePlayerName(ts.App, "Stéve"))
+	ts.App.Config().AllowUnicodePlayerNames = true
+
+	// Names that could be mistaken for Bedrock players are reserved
+	ts.App.Config().Floodgate.Enable = true
+	assert.NotNil(t, ValidatePlayerName(ts.App, ".Steve"))
+	ts.App.Config().Floodgate.Enable = false
+	assert.Nil(t, ValidatePlayerName(ts.App, ".Steve"))
+
+	// The Mojang-compatible preset is stricter than a permissive regex
+	ts.App.Config().MojangCompatiblePlayerNames = true
+	assert.Nil(t, ValidatePlayerName(ts.App, "Steve_123"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "ab"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "ThisNameIsTooLong"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "Stéve"))
+	assert.NotNil(t, ValidatePlayerName(ts.App, "has spaces"))
+	ts.App.Config().MojangCompatiblePlayerNames = false
+
+	ts.App.Config().ValidPlayerNameRegex = DefaultConfig().ValidPlayerNameRegex
+	ts.App.ValidPlayerNameRegex = regexp.MustCompile(ts.App.Config().ValidPlayerNameRegex)
+}
+
+func (ts *TestSuite) testNameUniqueness(t *testing.T) {
+	user := ts.InsertTestUser(ts.App, "Stéve")
+	assert.Equal(t, "stéve", user.NormalizedUsername)
+	assert.Equal(t, "stéve", user.NormalizedPlayerName)
+
+	// SQLite's nocase collation only folds ASCII, so this is caught by the
+	// normalized columns
+	other := *user
+	other.UUID = "00000000-0000-0000-0000-000000000000"
+	other.Username = "STÉVE"
+	other.PlayerName = "Alex"
+	err := ts.App.DB.Create(&other).Error
+	assert.True(t, IsErrorUniqueFailedField(err, "users.normalized_username"))
+
+	other.Username = "Alex"
+	other.PlayerName = "STÉVE"
+	err = ts.App.DB.Create(&other).Error
+	assert.True(t, IsErrorUniqueFailedField(err, "users.normalized_player_name"))
+
+	// The chosen capitalization is preserved
+	var found User
+	assert.Nil(t, FindUserByUsernameOrPlayerName(ts.App.DB, &found, "STÉVE"))
+	assert.Equal(t, "Stéve", found.Username)
+	assert.Equal(t, "Stéve", found.PlayerName)
+}
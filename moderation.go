@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"sort"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Staff notes and moderation history. Admins can leave notes on a user, e.g.
+to record a warning given in-game, which only admins ever see. The
+moderation history gathers what has happened to a user from the audit log
+and the reports against them: suspensions, resolved reports (including
+skins cleared in response to one), and reports still waiting.
+*/
+
+const MAX_USER_NOTE_LENGTH = 2000
+
+// How many events the moderation history shows
+const MODERATION_HISTORY_COUNT = 50
+
+// Audit log actions that belong in a user's moderation history
+var MODERATION_AUDIT_ACTIONS = []string{
+	AuditActionSuspendUser,
+	AuditActionLiftSuspension,
+	AuditActionResolveReport,
+}
+
+// The action of a moderation event for a report against the user
+const ModerationActionReport = "report"
+
+var errUserNoteNotFound = errors.New("note not found")
+
+func ValidateUserNote(body string) error {
+	if body == "" {
+		return errors.New("can't be empty")
+	}
+	if utf8.RuneCountInString(body) > MAX_USER_NOTE_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_USER_NOTE_LENGTH)
+	}
+	return nil
+}
+
+// Leave a note from `author` on `user`. The body should already be trimmed
+// and validated with ValidateUserNote.
+func (app *App) AddUserNote(author *User, user *User, body string) (*UserNote, error) {
+	note := UserNote{
+		UserUUID:       user.UUID,
+		AuthorUUID:     author.UUID,
+		AuthorUsername: author.Username,
+		Body:           body,
+		CreatedAt:      time.Now(),
+	}
+	if err := app.DB.Create(&note).Error; err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (app *App) DeleteUserNote(user *User, id uint) error {
+	result := app.DB.Where("id = ? AND user_uuid = ?", id, user.UUID).Delete(&UserNote{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errUserNoteNotFound
+	}
+	return nil
+}
+
+// Notes on `user`, newest first
+func (app *App) GetUserNotes(user *User) ([]UserNote, error) {
+	var notes []UserNote
+	err := app.DB.Where("user_uuid = ?", user.UUID).Order("created_at desc, id desc").Find(&notes).Error
+	return notes, err
+}
+
+type ModerationEvent struct {
+	Time time.Time
+	// One of MODERATION_AUDIT_ACTIONS or ModerationActionReport
+	Action string
+	// The admin who acted, or the reporter of a report. Empty if they've
+	// been deleted.
+	ActorUsername string
+	Details       string
+}
+
+// What has happened to `user`, newest first
+func (app *App) GetModerationHistory(user *User) ([]ModerationEvent, error) {
+	var entries []AuditLogEntry
+	err := app.DB.
+		Where("target_uuid = ? AND action IN ?", user.UUID, MODERATION_AUDIT_ACTIONS).
+		Order("id desc").
+		Limit(MODERATION_HISTORY_COUNT).
+		Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []Report
+	err = app.DB.
+		Where("target_uuid = ?", user.UUID).
+		Order("created_at desc").
+		Limit(MODERATION_HISTORY_COUNT).
+		Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]ModerationEvent, 0, len(entries)+len(reports))
+	for _, entry := range entries {
+		events = append(events, ModerationEvent{
+			Time:          entry.CreatedAt,
+			Action:        entry.Action,
+			ActorUsername: entry.ActorUsername,
+			Details:       entry.Details,
+		})
+	}
+	for _, report := range reports {
+		var reporter User
+		err := app.DB.First(&reporter, "uuid = ?", report.ReporterUUID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		details := fmt.Sprintf("%s (%s)", report.Reason, report.Status)
+		if report.Details != "" {
+			details += ": " + report.Details
+		}
+		events = append(events, ModerationEvent{
+			Time:          report.CreatedAt,
+			Action:        ModerationActionReport,
+			ActorUsername: reporter.Username,
+			Details:       details,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if len(events) > MODERATION_HISTORY_COUNT {
+		events = events[:MODERATION_HISTORY_COUNT]
+	}
+	return events, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestModeration(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		config.Reports.Allow = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test staff notes", ts.testUserNotes)
+		t.Run("Test moderation history", ts.testModerationHistory)
+	}
+}
+
+func (ts *TestSuite) testUserNotes(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	returnURL := ts.App.FrontEndURL + "/drasl/profile?user=" + TEST_USERNAME
+	addNote := func(body string) string {
+		form := url.Values{}
+		form.Set("username", TEST_USERNAME)
+		form.Set("body", body)
+		form.Set("returnUrl", returnURL)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/add-user-note", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	assert.Equal(t, "Invalid note: can't be empty", addNote("   "))
+	assert.Equal(t, "", addNote("Warned about spawn camping"))
+
+	notes, err := ts.App.GetUserNotes(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(notes))
+	assert.Equal(t, "admin", notes[0].AuthorUsername)
+
+	// Notes show up for admins only
+	rec := ts.Get(t, ts.Server, "/drasl/profile?user="+TEST_USERNAME, []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Warned about spawn camping")
+	rec = ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "Warned about spawn camping")
+
+	form := url.Values{}
+	form.Set("username", TEST_USERNAME)
+	form.Set("noteId", fmt.Sprint(notes[0].ID))
+	form.Set("returnUrl", returnURL)
+	rec = ts.PostForm(t, ts.Server, "/drasl/admin/delete-user-note", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+	rec = ts.PostForm(t, ts.Server, "/drasl/admin/delete-user-note", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, "Note not found.", getErrorMessage(rec))
+
+	notes, err = ts.App.GetUserNotes(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(notes))
+}
+
+func (ts *TestSuite) testModerationHistory(t *testing.T) {
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", "admin").Error)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	_, err := ts.App.CreateReport(&admin, user.PlayerName, ReportReasonName, "Rude")
+	assert.Nil(t, err)
+	assert.Nil(t, ts.App.SuspendUser(&admin, &user, time.Now().Add(time.Hour), "Griefing"))
+	assert.Nil(t, ts.App.LiftExpiredSuspensions(time.Now().Add(2*time.Hour)))
+
+	adminAccessToken := ts.authenticate(t, "admin", TEST_PASSWORD).AccessToken
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/admin/users/"+user.UUID+"/notes", apiAddUserNoteRequest{
+		Body: "Suspended for griefing",
+	}, nil, &adminAccessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/users/"+user.UUID+"/moderation", nil, &adminAccessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res apiUserModeration
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, 1, len(res.Notes))
+	assert.Equal(t, "Suspended for griefing", res.Notes[0].Body)
+
+	actions := make([]string, 0, len(res.History))
+	for _, event := range res.History {
+		actions = append(actions, event.Action)
+	}
+	assert.ElementsMatch(t, []string{ModerationActionReport, AuditActionSuspendUser, AuditActionLiftSuspension}, actions)
+
+	// Only admins
+	userAccessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/users/"+user.UUID+"/moderation", nil, &userAccessToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	assert.Nil(t, DeleteUser(ts.App, &user))
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&UserNote{}).Where("user_uuid = ?", user.UUID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
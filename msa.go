@@ -0,0 +1,284 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+A stand-in for the chain of Microsoft and Xbox Live token exchanges that
+modern launchers perform to sign in, for launchers patched to use Drasl's
+URLs in place of Microsoft's:
+
+ 1. login.microsoftonline.com -> <BaseURL>/msa: the OAuth 2.0 device code
+    flow, backed by Drasl's own device login.
+ 2. user.auth.xboxlive.com -> <BaseURL>/xbl: exchange the Microsoft token
+    for an Xbox Live user token.
+ 3. xsts.auth.xboxlive.com -> <BaseURL>/xsts: exchange the user token for an
+    XSTS token.
+ 4. api.minecraftservices.com -> <BaseURL>/services: exchange the XSTS token
+    for a Minecraft access token with /authentication/login_with_xbox.
+
+Every token in the chain is the Drasl access token issued in step 1, so each
+step only has to check that it's still valid.
+*/
+
+// Reported lifetime of the tokens when TokenExpireSec is 0, as Mojang's
+// Minecraft access tokens last a day
+const MSA_DEFAULT_EXPIRES_IN = 86400
+
+const MSA_SCOPE = "XboxLive.signin offline_access"
+
+type msaErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func msaError(c echo.Context, errorCode string, description string) error {
+	return c.JSON(http.StatusBadRequest, msaErrorResponse{
+		Error:            errorCode,
+		ErrorDescription: description,
+	})
+}
+
+func msaExpiresIn(app *App) int {
+	if app.Config().TokenExpireSec > 0 {
+		return app.Config().TokenExpireSec
+	}
+	return MSA_DEFAULT_EXPIRES_IN
+}
+
+// The client a token anywhere in the chain belongs to, or nil if the token
+// is invalid
+func msaGetClient(app *App, token string) *Client {
+	client := app.GetClient(token, StalePolicyDeny)
+	if client == nil || client.User.IsPendingApproval {
+		return nil
+	}
+	return client
+}
+
+type msaDeviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Message         string `json:"message"`
+}
+
+// POST /msa/:tenant/oauth2/v2.0/devicecode
+func MSADeviceCode(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().MSACompatibility.Enable {
+			return echo.ErrNotFound
+		}
+
+		deviceAuthorization, err := app.CreateDeviceAuthorization()
+		if err != nil {
+			return err
+		}
+		verificationURI, err := url.JoinPath(app.FrontEndURL, "drasl/device")
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(http.StatusOK, msaDeviceCodeResponse{
+			DeviceCode:      deviceAuthorization.DeviceCode,
+			UserCode:        deviceAuthorization.UserCode,
+			VerificationURI: verificationURI,
+			ExpiresIn:       app.Config().DeviceLogin.ExpireSec,
+			Interval:        app.Config().DeviceLogin.PollIntervalSec,
+			Message:         "To sign in, use a web browser to open the page " + verificationURI + " and enter the code " + deviceAuthorization.UserCode + " to authenticate.",
+		})
+	}
+}
+
+type msaTokenResponse struct {
+	TokenType    string `json:"token_type"`
+	Scope        string `json:"scope"`
+	ExpiresIn    int    `json:"expires_in"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// POST /msa/:tenant/oauth2/v2.0/token
+// Supports the device_code and refresh_token grants
+func MSAToken(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().MSACompatibility.Enable {
+			return echo.ErrNotFound
+		}
+
+		var accessToken string
+		switch c.FormValue("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			user, errorCode, err := app.RedeemDeviceAuthorization(c.FormValue("device_code"))
+			if err != nil {
+				return err
+			}
+			if user == nil {
+				return msaError(c, errorCode, DEVICE_LOGIN_ERROR_MESSAGES[errorCode])
+			}
+			if user.IsPendingApproval {
+				return msaError(c, DeviceLoginErrorAccessDenied, "Your account is waiting for approval by an admin.")
+			}
+			if user.IsLocked {
+				return msaError(c, DeviceLoginErrorAccessDenied, LockedMessage(user, time.Now()))
+			}
+			if allowed, reason := app.ExternalAuthAllows(user, c.RealIP(), c.Request().UserAgent()); !allowed {
+				return msaError(c, DeviceLoginErrorAccessDenied, reason)
+			}
+			res, err := app.AuthenticateClient(user, nil, nil, false)
+			if err != nil {
+				return err
+			}
+			app.NotifySecurityEvent(user, SecurityEventNewDevice, c.RealIP(), c.Request().UserAgent())
+			app.PublishEvent(MakeEvent(EventLogin, user, c.RealIP()))
+			accessToken = res.AccessToken
+		case "refresh_token":
+			client := app.GetClient(c.FormValue("refresh_token"), StalePolicyAllow)
+			if client == nil || client.User.IsPendingApproval || client.User.IsLocked {
+				return msaError(c, "invalid_grant", "The refresh token is invalid or has expired.")
+			}
+			if allowed, reason := app.ExternalAuthAllows(&client.User, c.RealIP(), c.Request().UserAgent()); !allowed {
+				return msaError(c, "invalid_grant", reason)
+			}
+			client.Version += 1
+			var err error
+			accessToken, err = app.MakeAccessToken(*client)
+			if err != nil {
+				return err
+			}
+			if err := app.DB.Save(client).Error; err != nil {
+				return err
+			}
+		default:
+			return msaError(c, "unsupported_grant_type", "Only the device_code and refresh_token grants are supported.")
+		}
+
+		return c.JSON(http.StatusOK, msaTokenResponse{
+			TokenType:    "Bearer",
+			Scope:        MSA_SCOPE,
+			ExpiresIn:    msaExpiresIn(app),
+			AccessToken:  accessToken,
+			RefreshToken: accessToken,
+		})
+	}
+}
+
+type xboxTokenRequest struct {
+	Properties struct {
+		// For user tokens, "d=" followed by the Microsoft access token
+		RpsTicket string `json:"RpsTicket"`
+		// For XSTS tokens
+		UserTokens []string `json:"UserTokens"`
+	} `json:"Properties"`
+	RelyingParty string `json:"RelyingParty"`
+	TokenType    string `json:"TokenType"`
+}
+
+type xboxUserHash struct {
+	UHS string `json:"uhs"`
+}
+
+type xboxTokenResponse struct {
+	IssueInstant  time.Time `json:"IssueInstant"`
+	NotAfter      time.Time `json:"NotAfter"`
+	Token         string    `json:"Token"`
+	DisplayClaims struct {
+		XUI []xboxUserHash `json:"xui"`
+	} `json:"DisplayClaims"`
+}
+
+func makeXboxTokenResponse(app *App, client *Client, token string) (*xboxTokenResponse, error) {
+	uhs, err := UUIDToID(client.User.UUID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res := xboxTokenResponse{
+		IssueInstant: now,
+		NotAfter:     now.Add(time.Duration(msaExpiresIn(app)) * time.Second),
+		Token:        token,
+	}
+	res.DisplayClaims.XUI = []xboxUserHash{{UHS: uhs}}
+	return &res, nil
+}
+
+// POST /xbl/user/authenticate
+// POST /xsts/xsts/authorize
+func XboxAuthenticate(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().MSACompatibility.Enable {
+			return echo.ErrNotFound
+		}
+
+		req := new(xboxTokenRequest)
+		if err := c.Bind(req); err != nil {
+			return c.NoContent(http.StatusBadRequest)
+		}
+		var token string
+		if req.Properties.RpsTicket != "" {
+			token = strings.TrimPrefix(strings.TrimPrefix(req.Properties.RpsTicket, "d="), "t=")
+		} else if len(req.Properties.UserTokens) == 1 {
+			token = req.Properties.UserTokens[0]
+		}
+
+		client := msaGetClient(app, token)
+		if client == nil {
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		res, err := makeXboxTokenResponse(app, client, token)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type loginWithXboxRequest struct {
+	// XBL3.0 x=<user hash>;<XSTS token>
+	IdentityToken string `json:"identityToken"`
+}
+
+type loginWithXboxResponse struct {
+	Username    string   `json:"username"`
+	Roles       []string `json:"roles"`
+	AccessToken string   `json:"access_token"`
+	TokenType   string   `json:"token_type"`
+	ExpiresIn   int      `json:"expires_in"`
+}
+
+// POST /authentication/login_with_xbox
+func ServicesLoginWithXbox(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().MSACompatibility.Enable {
+			return echo.ErrNotFound
+		}
+
+		req := new(loginWithXboxRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, nil, Ptr("Invalid request body."))
+		}
+		_, token, ok := strings.Cut(req.IdentityToken, ";")
+		if !ok || !strings.HasPrefix(req.IdentityToken, "XBL3.0 x=") {
+			return MakeErrorResponse(&c, http.StatusBadRequest, nil, Ptr("Invalid identity token."))
+		}
+
+		client := msaGetClient(app, token)
+		if client == nil {
+			return MakeErrorResponse(&c, http.StatusUnauthorized, nil, Ptr("Invalid identity token."))
+		}
+		return c.JSON(http.StatusOK, loginWithXboxResponse{
+			Username:    client.User.UUID,
+			Roles:       []string{},
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   msaExpiresIn(app),
+		})
+	}
+}
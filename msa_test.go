@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMSA(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DeviceLogin.Allow = true
+		config.MSACompatibility.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test Microsoft and Xbox Live sign-in", ts.testMSASignIn)
+	}
+}
+
+func (ts *TestSuite) testMSASignIn(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	form := url.Values{}
+	form.Set("client_id", "00000000402b5328")
+	form.Set("scope", MSA_SCOPE)
+	rec := ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/devicecode", form, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var deviceCodeResponse msaDeviceCodeResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&deviceCodeResponse))
+
+	pollForm := url.Values{}
+	pollForm.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	pollForm.Set("device_code", deviceCodeResponse.DeviceCode)
+	rec = ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/token", pollForm, nil, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var errorResponse msaErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Equal(t, DeviceLoginErrorAuthorizationPending, errorResponse.Error)
+
+	form = url.Values{}
+	form.Set("userCode", deviceCodeResponse.UserCode)
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/device")
+	rec = ts.PostForm(t, ts.Server, "/drasl/device/approve", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+
+	rec = ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/token", pollForm, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var tokenResponse msaTokenResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&tokenResponse))
+
+	xboxToken := func(path string, req xboxTokenRequest) xboxTokenResponse {
+		rec := ts.PostJSON(t, ts.Server, path, req, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response xboxTokenResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response
+	}
+
+	var xblRequest xboxTokenRequest
+	xblRequest.Properties.RpsTicket = "d=" + tokenResponse.AccessToken
+	xblResponse := xboxToken("/xbl/user/authenticate", xblRequest)
+
+	var xstsRequest xboxTokenRequest
+	xstsRequest.Properties.UserTokens = []string{xblResponse.Token}
+	xstsResponse := xboxToken("/xsts/xsts/authorize", xstsRequest)
+	uhs := xstsResponse.DisplayClaims.XUI[0].UHS
+
+	rec = ts.PostJSON(t, ts.Server, "/authentication/login_with_xbox", loginWithXboxRequest{
+		IdentityToken: "XBL3.0 x=" + uhs + ";" + xstsResponse.Token,
+	}, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var loginResponse loginWithXboxResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&loginResponse))
+
+	// The Minecraft access token works with the rest of the services API
+	rec = ts.Get(t, ts.Server, "/minecraft/profile", nil, &loginResponse.AccessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var profile ServicesProfile
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&profile))
+	assert.Equal(t, user.PlayerName, profile.Name)
+
+	// Refreshing replaces the token
+	form = url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", tokenResponse.RefreshToken)
+	rec = ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/token", form, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var refreshResponse msaTokenResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&refreshResponse))
+	assert.NotEqual(t, tokenResponse.AccessToken, refreshResponse.AccessToken)
+
+	rec = ts.PostJSON(t, ts.Server, "/xbl/user/authenticate", xblRequest, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Users the ExternalAuth check refuses can't refresh or sign in again
+	tokenShouldBeDenied := func(form url.Values, errorCode string, description string) {
+		rec := ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/token", form, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		var errorResponse msaErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+		assert.Equal(t, errorCode, errorResponse.Error)
+		assert.Equal(t, description, errorResponse.ErrorDescription)
+	}
+	approvedDeviceCode := func() url.Values {
+		form := url.Values{}
+		form.Set("client_id", "00000000402b5328")
+		form.Set("scope", MSA_SCOPE)
+		rec := ts.PostForm(t, ts.Server, "/msa/consumers/oauth2/v2.0/devicecode", form, nil, nil)
+		var deviceCodeResponse msaDeviceCodeResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&deviceCodeResponse))
+
+		form = url.Values{}
+		form.Set("userCode", deviceCodeResponse.UserCode)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/device")
+		rec = ts.PostForm(t, ts.Server, "/drasl/device/approve", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, "", getErrorMessage(rec))
+
+		pollForm := url.Values{}
+		pollForm.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+		pollForm.Set("device_code", deviceCodeResponse.DeviceCode)
+		return pollForm
+	}
+
+	form = url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshResponse.RefreshToken)
+	pollForm = approvedDeviceCode()
+	allowExternalAuth := ts.denyExternalAuth("Not today.")
+	tokenShouldBeDenied(form, "invalid_grant", "Not today.")
+	tokenShouldBeDenied(pollForm, DeviceLoginErrorAccessDenied, "Not today.")
+	allowExternalAuth()
+
+	// Nor can locked users
+	pollForm = approvedDeviceCode()
+	assert.Nil(t, ts.App.SetIsLocked(ts.App.DB, &user, true))
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+	tokenShouldBeDenied(pollForm, DeviceLoginErrorAccessDenied, "Account is locked.")
+}
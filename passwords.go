@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"hash"
+	"log"
+	"strconv"
+	"strings"
+)
+
+/*
+Accounts migrated from other software can keep their old password hashes in
+User.ImportedPasswordHash. The first time the user logs in, the password is
+checked against the imported hash and then rehashed in Drasl's own format,
+so nobody has to reset their password after a migration.
+*/
+
+type ImportedPasswordHashFormat struct {
+	Name string
+	// Whether `encoded` looks like a hash in this format
+	Matches func(encoded string) bool
+	Verify  func(encoded string, password string) (bool, error)
+}
+
+// Checked in order; the first format that matches is used
+var ImportedPasswordHashFormats = []ImportedPasswordHashFormat{
+	{
+		// Also used by ely.by, whose hashes start with $2y$
+		Name: "bcrypt",
+		Matches: func(encoded string) bool {
+			return strings.HasPrefix(encoded, "$2a$") ||
+				strings.HasPrefix(encoded, "$2b$") ||
+				strings.HasPrefix(encoded, "$2y$")
+		},
+		Verify: verifyBcrypt,
+	},
+	{
+		Name: "django-pbkdf2",
+		Matches: func(encoded string) bool {
+			return strings.HasPrefix(encoded, "pbkdf2_sha256$") || strings.HasPrefix(encoded, "pbkdf2_sha1$")
+		},
+		Verify: verifyDjangoPBKDF2,
+	},
+}
+
+func verifyBcrypt(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// algorithm$iterations$salt$base64(hash)
+func verifyDjangoPBKDF2(encoded string, password string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 {
+		return false, errors.New("malformed Django PBKDF2 hash")
+	}
+	var hashFunc func() hash.Hash
+	switch parts[0] {
+	case "pbkdf2_sha256":
+		hashFunc = sha256.New
+	case "pbkdf2_sha1":
+		hashFunc = sha1.New
+	default:
+		return false, fmt.Errorf("unsupported Django hasher %s", parts[0])
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false, errors.New("malformed Django PBKDF2 iteration count")
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, err
+	}
+	actual := pbkdf2.Key([]byte(password), []byte(parts[2]), iterations, len(expected), hashFunc)
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+func GetImportedPasswordHashFormat(encoded string) *ImportedPasswordHashFormat {
+	for i := range ImportedPasswordHashFormats {
+		if ImportedPasswordHashFormats[i].Matches(encoded) {
+			return &ImportedPasswordHashFormats[i]
+		}
+	}
+	return nil
+}
+
+// Set a new password, in Drasl's own format. Doesn't save the user.
+func SetPassword(user *User, password string) error {
+	passwordSalt := make([]byte, 16)
+	if _, err := rand.Read(passwordSalt); err != nil {
+		return err
+	}
+	passwordHash, err := HashPassword(password, passwordSalt)
+	if err != nil {
+		return err
+	}
+	user.PasswordSalt = passwordSalt
+	user.PasswordHash = passwordHash
+	user.ImportedPasswordHash = MakeNullString(nil)
+	return nil
+}
+
+// Whether `password` is the user's password. If the user still has an
+// imported password hash and the password matches it, the password is
+// rehashed and saved in Drasl's own format.
+func (app *App) CheckPassword(user *User, password string) (bool, error) {
+	if !user.ImportedPasswordHash.Valid {
+		passwordHash, err := HashPassword(password, user.PasswordSalt)
+		if err != nil {
+			return false, err
+		}
+		return bytes.Equal(passwordHash, user.PasswordHash), nil
+	}
+
+	format := GetImportedPasswordHashFormat(user.ImportedPasswordHash.String)
+	if format == nil {
+		log.Printf("Unrecognized imported password hash for user %s\n", user.Username)
+		return false, nil
+	}
+	ok, err := format.Verify(user.ImportedPasswordHash.String, password)
+	if err != nil {
+		log.Printf("Couldn't check %s password hash for user %s: %s\n", format.Name, user.Username, err)
+		return false, nil
+	}
+	if !ok {
+		return false, nil
+	}
+
+	if err := SetPassword(user, password); err != nil {
+		return false, err
+	}
+	err = app.DB.Model(user).Select("password_salt", "password_hash", "imported_password_hash").Updates(user).Error
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"testing"
+)
+
+func TestPasswords(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test imported password hash formats", ts.testImportedPasswordHashFormats)
+		t.Run("Test upgrading an imported password hash", ts.testUpgradeImportedPasswordHash)
+	}
+}
+
+func (ts *TestSuite) testImportedPasswordHashFormats(t *testing.T) {
+	check := func(encoded string, password string) bool {
+		format := GetImportedPasswordHashFormat(encoded)
+		if !assert.NotNil(t, format) {
+			return false
+		}
+		ok, err := format.Verify(encoded, password)
+		assert.Nil(t, err)
+		return ok
+	}
+
+	bcryptHash := string(Unwrap(bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)))
+	assert.True(t, check(bcryptHash, "hunter22"))
+	assert.False(t, check(bcryptHash, "hunter23"))
+
+	// ely.by uses PHP's $2y$ prefix for the same format
+	elyHash := "$2y$" + bcryptHash[4:]
+	assert.True(t, check(elyHash, "hunter22"))
+
+	djangoHash := "pbkdf2_sha256$1000$saltsalt$ny5meISIhaoh9Hf5couWXriLEditl+KNHV25zTsYC34="
+	assert.True(t, check(djangoHash, "hunter22"))
+	assert.False(t, check(djangoHash, "hunter23"))
+	assert.True(t, check("pbkdf2_sha1$1000$saltsalt$g6m30ECzIbVENs7QpndcooFuxoE=", "hunter22"))
+
+	assert.Nil(t, GetImportedPasswordHashFormat("5f4dcc3b5aa765d61d8327deb882cf99"))
+}
+
+func (ts *TestSuite) testUpgradeImportedPasswordHash(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	user.ImportedPasswordHash = MakeNullString(Ptr("pbkdf2_sha256$1000$saltsalt$ny5meISIhaoh9Hf5couWXriLEditl+KNHV25zTsYC34="))
+	user.PasswordSalt = []byte{}
+	user.PasswordHash = []byte{}
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	// The old Drasl password no longer works
+	payload := authenticateRequest{
+		Username: TEST_USERNAME,
+		Password: TEST_PASSWORD,
+	}
+	rec := ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	payload.Password = "hunter22"
+	rec = ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The password should now be stored in Drasl's own format
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.False(t, user.ImportedPasswordHash.Valid)
+	passwordOK, err := ts.App.CheckPassword(&user, "hunter22")
+	assert.Nil(t, err)
+	assert.True(t, passwordOK)
+
+	rec = ts.PostJSON(t, ts.Server, "/authenticate", payload, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
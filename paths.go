@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+/*
+Default locations of the config file, DataDirectory, and StateDirectory.
+
+Packaged installs on Linux and other Unix-likes use the FHS paths in
+build_config.go, which packagers may substitute, e.g. /etc/drasl,
+/usr/share/drasl, and /var/lib/drasl. Drasl counts as packaged if
+DEFAULT_DATA_DIRECTORY exists. Otherwise, the defaults follow the platform's
+conventions:
+
+  - Linux and other Unix-likes: the XDG base directories, e.g. ~/.config/drasl,
+    ~/.local/share/drasl, and ~/.local/state/drasl
+  - Windows: %APPDATA%\drasl, with data\ and state\ inside it
+  - macOS: ~/Library/Application Support/drasl, with data/ and state/ inside it
+
+With --portable, everything is kept next to the drasl executable: config.toml
+and the static assets beside it, and the state in state/.
+*/
+
+type Directories struct {
+	Config string
+	Data   string
+	State  string
+}
+
+func platformDirectories(goos string, getenv func(string) string, homeDirectory string, packaged bool) Directories {
+	var directories Directories
+	switch goos {
+	case "windows":
+		appData := getenv("APPDATA")
+		if appData == "" {
+			appData = filepath.Join(homeDirectory, "AppData", "Roaming")
+		}
+		base := filepath.Join(appData, "drasl")
+		directories = Directories{Config: base, Data: filepath.Join(base, "data"), State: filepath.Join(base, "state")}
+	case "darwin":
+		base := filepath.Join(homeDirectory, "Library", "Application Support", "drasl")
+		directories = Directories{Config: base, Data: filepath.Join(base, "data"), State: filepath.Join(base, "state")}
+	default:
+		if packaged {
+			return Directories{Config: DEFAULT_CONFIG_DIRECTORY, Data: DEFAULT_DATA_DIRECTORY, State: DEFAULT_STATE_DIRECTORY}
+		}
+		// Relative XDG paths are invalid and should be ignored
+		xdg := func(name string, fallback string) string {
+			if value := getenv(name); filepath.IsAbs(value) {
+				return filepath.Join(value, "drasl")
+			}
+			return filepath.Join(homeDirectory, fallback, "drasl")
+		}
+		directories = Directories{
+			Config: xdg("XDG_CONFIG_HOME", ".config"),
+			Data:   xdg("XDG_DATA_HOME", filepath.Join(".local", "share")),
+			State:  xdg("XDG_STATE_HOME", filepath.Join(".local", "state")),
+		}
+	}
+	// Assets installed by a package are used wherever they are
+	if packaged {
+		directories.Data = DEFAULT_DATA_DIRECTORY
+	}
+	return directories
+}
+
+func DefaultDirectories() Directories {
+	_, err := os.Stat(DEFAULT_DATA_DIRECTORY)
+	packaged := err == nil
+	homeDirectory, err := os.UserHomeDir()
+	if err != nil {
+		// No home directory, e.g. a service account. The FHS paths are the
+		// best guess.
+		return Directories{Config: DEFAULT_CONFIG_DIRECTORY, Data: DEFAULT_DATA_DIRECTORY, State: DEFAULT_STATE_DIRECTORY}
+	}
+	return platformDirectories(runtime.GOOS, os.Getenv, homeDirectory, packaged)
+}
+
+func PortableDirectories() (Directories, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return Directories{}, err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return Directories{}, err
+	}
+	directory := filepath.Dir(executable)
+	return Directories{Config: directory, Data: directory, State: filepath.Join(directory, "state")}, nil
+}
+
+// Use directories as the defaults for the config file, DataDirectory, and
+// StateDirectory
+func (constants *ConstantsType) SetDirectories(directories Directories) {
+	constants.ConfigDirectory = directories.Config
+	constants.DataDirectory = directories.Data
+	constants.StateDirectory = directories.State
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformDirectories(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(name string) string { return env[name] }
+	home := filepath.Join("/home", "steve")
+
+	// Linux without XDG variables
+	assert.Equal(t, Directories{
+		Config: filepath.Join(home, ".config", "drasl"),
+		Data:   filepath.Join(home, ".local", "share", "drasl"),
+		State:  filepath.Join(home, ".local", "state", "drasl"),
+	}, platformDirectories("linux", getenv, home, false))
+
+	// Relative XDG paths are ignored
+	env["XDG_CONFIG_HOME"] = "/xdg/config"
+	env["XDG_STATE_HOME"] = "relative/state"
+	assert.Equal(t, Directories{
+		Config: filepath.Join("/xdg/config", "drasl"),
+		Data:   filepath.Join(home, ".local", "share", "drasl"),
+		State:  filepath.Join(home, ".local", "state", "drasl"),
+	}, platformDirectories("freebsd", getenv, home, false))
+
+	// Packaged installs keep the FHS paths
+	assert.Equal(t, Directories{
+		Config: DEFAULT_CONFIG_DIRECTORY,
+		Data:   DEFAULT_DATA_DIRECTORY,
+		State:  DEFAULT_STATE_DIRECTORY,
+	}, platformDirectories("linux", getenv, home, true))
+
+	env["APPDATA"] = filepath.Join("C:", "Users", "Steve", "AppData", "Roaming")
+	appData := filepath.Join(env["APPDATA"], "drasl")
+	assert.Equal(t, Directories{
+		Config: appData,
+		Data:   filepath.Join(appData, "data"),
+		State:  filepath.Join(appData, "state"),
+	}, platformDirectories("windows", getenv, home, false))
+
+	applicationSupport := filepath.Join(home, "Library", "Application Support", "drasl")
+	assert.Equal(t, Directories{
+		Config: applicationSupport,
+		Data:   DEFAULT_DATA_DIRECTORY,
+		State:  filepath.Join(applicationSupport, "state"),
+	}, platformDirectories("darwin", getenv, home, true))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"gorm.io/gorm"
+	"unicode/utf8"
+)
+
+/*
+Search for players by the start of their player name, e.g. for tab
+completion in whitelist and moderation plugins. Results are ordered by
+normalized player name and paginated by the last name of the previous page,
+so each page is a range scan over the normalized_player_name index.
+*/
+
+const PLAYER_SEARCH_DEFAULT_LIMIT = 20
+
+// Players whose name starts with `prefix`, ignoring case, and whose
+// normalized name sorts after `after`. At most `limit` players are returned.
+func SearchPlayers(db *gorm.DB, prefix string, after string, limit int) ([]User, error) {
+	normalizedPrefix := NormalizeName(prefix)
+
+	// Everything starting with the prefix sorts between the prefix itself
+	// and the prefix followed by the greatest code point. Unlike LIKE, this
+	// can use the index.
+	query := db.Where("normalized_player_name >= ? AND normalized_player_name < ?", normalizedPrefix, normalizedPrefix+string(utf8.MaxRune))
+	if after != "" {
+		query = query.Where("normalized_player_name > ?", NormalizeName(after))
+	}
+
+	var users []User
+	err := query.
+		Where("is_pending_approval = ?", false).
+		Order("normalized_player_name").
+		Limit(limit).
+		Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
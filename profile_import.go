@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+Importing a profile from a linked account. A user who has linked an existing
+account, e.g. a Mojang account, can copy its current skin and cape onto their
+Drasl profile from their profile page, instead of downloading them and
+uploading them again. The profile is fetched from the FallbackAPIServers,
+starting with the one that shares the linked account's source nickname.
+Each import is kept, with the account's name history and the server it came
+from, and recorded in the audit log.
+*/
+
+var errProfileImportNotLinked = errors.New("no linked account")
+var errProfileImportUnavailable = errors.New("couldn't find the linked account on any fallback API server")
+var errSkinNotAllowed = errors.New("Setting a skin is not allowed.")
+var errCapeNotAllowed = errors.New("Setting a cape is not allowed.")
+
+// An entry of the name history Mojang used to serve at
+// /user/profiles/<id>/names. Few API servers still serve it.
+type nameHistoryEntry struct {
+	Name        string `json:"name"`
+	ChangedToAt *int64 `json:"changedToAt,omitempty"`
+}
+
+// The fallback API servers to look for a linked account on, the one named
+// after the account's source first
+func profileImportServers(app *App, source string) []FallbackAPIServer {
+	servers := make([]FallbackAPIServer, 0)
+	for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
+		if fallbackAPIServer.Nickname == source {
+			servers = append([]FallbackAPIServer{fallbackAPIServer}, servers...)
+		} else {
+			servers = append(servers, fallbackAPIServer)
+		}
+	}
+	return servers
+}
+
+func getJSON(app *App, reqURL string, v any) (int, error) {
+	res, err := app.MakeHTTPClient().Get(reqURL)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(v)
+}
+
+// Fetch the profile with the given ID and its textures from fallbackAPIServer
+func fetchImportProfile(app *App, fallbackAPIServer *FallbackAPIServer, id string) (*SessionProfileResponse, *texturesValue, error) {
+	reqURL, err := url.JoinPath(fallbackAPIServer.SessionURL, "session/minecraft/profile", id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var profile SessionProfileResponse
+	status, err := getJSON(app, reqURL, &profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if status != http.StatusOK {
+		return nil, nil, fmt.Errorf("request to %s resulted in status code %d", reqURL, status)
+	}
+
+	var textures texturesValue
+	for _, property := range profile.Properties {
+		if property.Name != "textures" {
+			continue
+		}
+		valueBlob, err := base64.StdEncoding.DecodeString(property.Value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := json.Unmarshal(valueBlob, &textures); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &profile, &textures, nil
+}
+
+// The names the account with the given ID has had, oldest first. If
+// fallbackAPIServer doesn't serve name histories, only the current name is
+// known.
+func fetchNameHistory(app *App, fallbackAPIServer *FallbackAPIServer, id string, currentName string) []string {
+	names := []string{currentName}
+	reqURL, err := url.JoinPath(fallbackAPIServer.AccountURL, "user/profiles", id, "names")
+	if err != nil {
+		return names
+	}
+	var history []nameHistoryEntry
+	status, err := getJSON(app, reqURL, &history)
+	if err != nil || status != http.StatusOK || len(history) == 0 {
+		return names
+	}
+	names = make([]string, 0, len(history))
+	for _, entry := range history {
+		names = append(names, entry.Name)
+	}
+	return names
+}
+
+func downloadTexture(app *App, textureURL string) (io.Reader, error) {
+	res, err := app.GetTexture(textureURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request to %s resulted in status code %d", textureURL, res.StatusCode)
+	}
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(io.LimitReader(res.Body, 10e6)); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Copy the skin and cape, as chosen, of the account user has linked onto
+// their profile. A skin or cape the linked account doesn't have is left
+// alone. The same rules apply as to uploading a skin or cape.
+func (app *App) ImportLinkedProfile(user *User, importSkin bool, importCape bool) (*ProfileImport, error) {
+	if importSkin && !user.IsAdmin {
+		if !app.Config().AllowSkins {
+			return nil, errSkinNotAllowed
+		}
+		if user.SkinLocked {
+			return nil, errSkinLocked
+		}
+	}
+	if importCape && !user.IsAdmin {
+		if !app.Config().AllowCapes {
+			return nil, errCapeNotAllowed
+		}
+		if user.CapeLocked {
+			return nil, errCapeLocked
+		}
+	}
+
+	linkedAccount, err := app.GetLinkedAccount(user)
+	if err != nil {
+		return nil, err
+	}
+	if linkedAccount == nil {
+		return nil, errProfileImportNotLinked
+	}
+	id, err := UUIDToID(linkedAccount.UUID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fallbackAPIServer *FallbackAPIServer
+	var profile *SessionProfileResponse
+	var textures *texturesValue
+	for _, server := range PtrSlice(profileImportServers(app, linkedAccount.Source)) {
+		profile, textures, err = fetchImportProfile(app, server, id)
+		if err != nil {
+			log.Printf("Couldn't import profile from fallback API server %s: %s\n", server.Nickname, err)
+			continue
+		}
+		fallbackAPIServer = server
+		break
+	}
+	if fallbackAPIServer == nil {
+		return nil, errProfileImportUnavailable
+	}
+
+	profileImport := ProfileImport{
+		UserUUID:    user.UUID,
+		Source:      fallbackAPIServer.Nickname,
+		LinkedUUID:  linkedAccount.UUID,
+		PlayerName:  profile.Name,
+		NameHistory: strings.Join(fetchNameHistory(app, fallbackAPIServer, id, profile.Name), ", "),
+		CreatedAt:   time.Now(),
+	}
+
+	if importSkin && textures.Textures.Skin != nil {
+		skin, err := downloadTexture(app, textures.Textures.Skin.URL)
+		if err != nil {
+			return nil, err
+		}
+		user.SkinModel = SkinModelClassic
+		if metadata := textures.Textures.Skin.Metadata; metadata != nil && metadata.Model == SkinModelSlim {
+			user.SkinModel = SkinModelSlim
+		}
+		if err := SetSkinAndSave(app, user, skin); err != nil {
+			return nil, err
+		}
+		profileImport.ImportedSkin = true
+	}
+	if importCape && textures.Textures.Cape != nil {
+		cape, err := downloadTexture(app, textures.Textures.Cape.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err := SetCapeAndSave(app, user, cape); err != nil {
+			return nil, err
+		}
+		profileImport.ImportedCape = true
+	}
+
+	if err := app.DB.Create(&profileImport).Error; err != nil {
+		return nil, err
+	}
+	details := fmt.Sprintf("from %s account %s (%s)", profileImport.Source, profileImport.PlayerName, profileImport.LinkedUUID)
+	if err := app.LogAudit(user, AuditActionImportProfile, user, details); err != nil {
+		return nil, err
+	}
+	return &profileImport, nil
+}
+
+// The user's most recent import, or nil if they haven't imported a profile
+func (app *App) GetLatestProfileImport(user *User) (*ProfileImport, error) {
+	var profileImports []ProfileImport
+	err := app.DB.Where("user_uuid = ?", user.UUID).Order("created_at desc").Limit(1).Find(&profileImports).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(profileImports) == 0 {
+		return nil, nil
+	}
+	return &profileImports[0], nil
+}
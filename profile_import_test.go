@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestProfileImport(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		config.AccountLinking.Allow = true
+		config.ProfileImport.Allow = true
+		config.RegistrationExistingPlayer = registrationExistingPlayerConfig{
+			Nickname:           "Aux",
+			SessionURL:         ts.AuxApp.SessionURL,
+			AccountURL:         ts.AuxApp.AccountURL,
+			ChallengeExpireSec: DefaultConfig().RegistrationExistingPlayer.ChallengeExpireSec,
+		}
+		config.FallbackAPIServers = []FallbackAPIServer{
+			{
+				Nickname:    "Aux",
+				SessionURL:  ts.AuxApp.SessionURL,
+				AccountURL:  ts.AuxApp.AccountURL,
+				ServicesURL: ts.AuxApp.ServicesURL,
+			},
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.AuxServer, EXISTING_USERNAME)
+
+		t.Run("Test profile import", ts.testProfileImport)
+	}
+}
+
+func (ts *TestSuite) testProfileImport(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	var auxUser User
+	assert.Nil(t, ts.AuxApp.DB.First(&auxUser, "username = ?", EXISTING_USERNAME).Error)
+	auxUser.SkinModel = SkinModelSlim
+	assert.Nil(t, SetSkinAndSave(ts.AuxApp, &auxUser, bytes.NewReader(RED_SKIN)))
+	assert.Nil(t, SetCapeAndSave(ts.AuxApp, &auxUser, bytes.NewReader(RED_CAPE)))
+
+	importProfile := func(importCape bool) string {
+		form := url.Values{}
+		form.Set("importSkin", "on")
+		if importCape {
+			form.Set("importCape", "on")
+		}
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/import-profile", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	// Only a linked account can be imported
+	assert.Equal(t, "Link an account before importing its profile.", importProfile(true))
+
+	// Bypass the skin challenge here; it's tested with account linking
+	assert.Nil(t, ts.App.DB.Create(&LinkedAccount{
+		UUID:       auxUser.UUID,
+		UserUUID:   user.UUID,
+		Source:     "Aux",
+		PlayerName: EXISTING_USERNAME,
+		CreatedAt:  time.Now(),
+	}).Error)
+
+	assert.Equal(t, "", importProfile(false))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.Equal(t, auxUser.SkinHash, user.SkinHash)
+	assert.Equal(t, SkinModelSlim, user.SkinModel)
+	assert.False(t, user.CapeHash.Valid)
+
+	assert.Equal(t, "", importProfile(true))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.Equal(t, auxUser.CapeHash, user.CapeHash)
+
+	// The import and where it came from are recorded
+	profileImport, err := ts.App.GetLatestProfileImport(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, "Aux", profileImport.Source)
+	assert.Equal(t, auxUser.UUID, profileImport.LinkedUUID)
+	assert.Equal(t, EXISTING_USERNAME, profileImport.NameHistory)
+	assert.True(t, profileImport.ImportedSkin)
+	assert.True(t, profileImport.ImportedCape)
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionImportProfile).Error)
+	assert.Equal(t, user.UUID, auditLogEntry.ActorUUID)
+
+	// Imports follow the same rules as uploads
+	ts.App.Config().AllowSkins = false
+	_, err = ts.App.ImportLinkedProfile(&user, true, false)
+	assert.ErrorIs(t, err, errSkinNotAllowed)
+	ts.App.Config().AllowSkins = true
+	assert.Nil(t, ts.App.DB.Model(&user).Update("cape_locked", true).Error)
+	assert.Equal(t, errCapeLocked.Error(), importProfile(true))
+
+	assert.Nil(t, DeleteUser(ts.App, &user))
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&ProfileImport{}).Where("user_uuid = ?", user.UUID).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
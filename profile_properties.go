@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Custom properties of player profiles, like a rank or a donor flag, returned
+alongside the textures by /session/minecraft/profile and /hasJoined. They're
+signed with Drasl's key like the textures, so server plugins can trust them.
+Properties come from the ProfileProperties of the config file, optionally
+limited to the members of a group, and from per-user values set through the
+admin API, which take precedence.
+*/
+
+const MAX_PROFILE_PROPERTY_NAME_LENGTH = 64
+const MAX_PROFILE_PROPERTY_VALUE_LENGTH = 1024
+
+// Names Drasl sets itself
+var RESERVED_PROFILE_PROPERTY_NAMES = []string{"textures"}
+
+// A property set on a single user's profile
+type UserProfileProperty struct {
+	UserUUID  string `gorm:"primaryKey"`
+	Name      string `gorm:"primaryKey"`
+	Value     string `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+func ValidateProfilePropertyName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("can't be blank")
+	}
+	if utf8.RuneCountInString(name) > MAX_PROFILE_PROPERTY_NAME_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_PROFILE_PROPERTY_NAME_LENGTH)
+	}
+	if Contains(RESERVED_PROFILE_PROPERTY_NAMES, name) {
+		return fmt.Errorf("%s is reserved", name)
+	}
+	return nil
+}
+
+func ValidateProfilePropertyValue(value string) error {
+	if utf8.RuneCountInString(value) > MAX_PROFILE_PROPERTY_VALUE_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_PROFILE_PROPERTY_VALUE_LENGTH)
+	}
+	return nil
+}
+
+func (app *App) GetUserProfileProperties(user *User) ([]UserProfileProperty, error) {
+	var properties []UserProfileProperty
+	err := app.DB.Where("user_uuid = ?", user.UUID).Order("name").Find(&properties).Error
+	return properties, err
+}
+
+func ValidateUserProfileProperties(properties []UserProfileProperty) error {
+	names := map[string]bool{}
+	for _, property := range properties {
+		if err := ValidateProfilePropertyName(property.Name); err != nil {
+			return fmt.Errorf("Invalid property name %s: %s", property.Name, err)
+		}
+		if err := ValidateProfilePropertyValue(property.Value); err != nil {
+			return fmt.Errorf("Invalid value of property %s: %s", property.Name, err)
+		}
+		if names[property.Name] {
+			return fmt.Errorf("Duplicate property name %s", property.Name)
+		}
+		names[property.Name] = true
+	}
+	return nil
+}
+
+// Replace the properties set on user's profile. They should already be
+// validated with ValidateUserProfileProperties.
+func (app *App) SetUserProfileProperties(user *User, properties []UserProfileProperty) error {
+	return app.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_uuid = ?", user.UUID).Delete(&UserProfileProperty{}).Error; err != nil {
+			return err
+		}
+		for _, property := range properties {
+			property.UserUUID = user.UUID
+			property.UpdatedAt = time.Now()
+			if err := tx.Create(&property).Error; err != nil {
+				return err
+			}
+		}
+		return TouchUsers(tx, user.UUID)
+	})
+}
+
+// The custom properties of user's profile, in the order of the config file
+// followed by any set only on the user
+func (app *App) profilePropertyValues(user *User) ([]string, map[string]string, error) {
+	var groupNames []string
+	err := app.DB.Table("groups").
+		Joins("JOIN group_memberships ON group_memberships.group_id = groups.id").
+		Where("group_memberships.user_uuid = ?", user.UUID).
+		Pluck("groups.name", &groupNames).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0)
+	values := map[string]string{}
+	set := func(name string, value string) {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+		values[name] = value
+	}
+	for _, property := range app.Config().ProfileProperties {
+		if property.Group != "" && !containsFold(groupNames, property.Group) {
+			continue
+		}
+		set(property.Name, property.Value)
+	}
+	userProperties, err := app.GetUserProfileProperties(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, property := range userProperties {
+		set(property.Name, property.Value)
+	}
+	return names, values, nil
+}
+
+// Group names are case-insensitive
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func GetCustomProfileProperties(app *App, user *User, sign bool) ([]SessionProfileProperty, error) {
+	names, values, err := app.profilePropertyValues(user)
+	if err != nil {
+		return nil, err
+	}
+	properties := make([]SessionProfileProperty, 0, len(names))
+	for _, name := range names {
+		property := SessionProfileProperty{
+			Name:  name,
+			Value: values[name],
+		}
+		if sign {
+			signature, err := SignSHA1(app, []byte(property.Value))
+			if err != nil {
+				return nil, err
+			}
+			property.Signature = Ptr(base64.StdEncoding.EncodeToString(signature))
+		}
+		properties = append(properties, property)
+	}
+	return properties, nil
+}
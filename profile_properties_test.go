@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProfileProperties(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"Bob"}
+		config.ProfileProperties = []ProfileProperty{
+			{Name: "rank", Value: "member"},
+			{Name: "rank", Value: "donor", Group: "Donors"},
+			{Name: "server", Value: "survival"},
+		}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test custom profile properties", ts.testProfileProperties)
+	}
+}
+
+func profilePropertyValues(properties []SessionProfileProperty) map[string]string {
+	values := map[string]string{}
+	for _, property := range properties {
+		values[property.Name] = property.Value
+	}
+	return values
+}
+
+func (ts *TestSuite) getSessionProfile(t *testing.T, user *User, unsigned bool) SessionProfileResponse {
+	url := "/session/minecraft/profile/" + Unwrap(UUIDToID(user.UUID))
+	if !unsigned {
+		url += "?unsigned=false"
+	}
+	rec := ts.Get(t, ts.Server, url, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response SessionProfileResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	return response
+}
+
+func (ts *TestSuite) putProfileProperties(t *testing.T, uuid string, payload interface{}, accessToken *string) *httptest.ResponseRecorder {
+	body := Unwrap(json.Marshal(payload))
+	req := httptest.NewRequest(http.MethodPut, "/drasl/api/v1/admin/users/"+uuid+"/properties", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+*accessToken)
+	rec := httptest.NewRecorder()
+	ts.Server.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ts *TestSuite) testProfileProperties(t *testing.T) {
+	ts.CreateTestUser(ts.Server, "Bob")
+	ts.CreateTestUser(ts.Server, "Alice")
+	var alice User
+	assert.Nil(t, ts.App.DB.First(&alice, "username = ?", "Alice").Error)
+
+	{
+		// Properties from the config file are added after the textures
+		response := ts.getSessionProfile(t, &alice, true)
+		assert.Equal(t, "textures", response.Properties[0].Name)
+		assert.Equal(t, map[string]string{"textures": response.Properties[0].Value, "rank": "member", "server": "survival"}, profilePropertyValues(response.Properties))
+		for _, property := range response.Properties {
+			assert.Nil(t, property.Signature)
+		}
+
+		// And signed with our key when asked
+		response = ts.getSessionProfile(t, &alice, false)
+		for _, property := range response.Properties[1:] {
+			assert.NotNil(t, property.Signature)
+			signature := Unwrap(base64.StdEncoding.DecodeString(*property.Signature))
+			sum := sha1.Sum([]byte(property.Value))
+			assert.Nil(t, rsa.VerifyPKCS1v15(&ts.App.Key.PublicKey, crypto.SHA1, sum[:], signature))
+		}
+	}
+	{
+		// Later properties for a group replace earlier ones
+		group := Group{Name: "donors"}
+		assert.Nil(t, ts.App.DB.Create(&group).Error)
+		assert.Nil(t, ts.App.DB.Create(&GroupMembership{GroupID: group.ID, UserUUID: alice.UUID}).Error)
+
+		response := ts.getSessionProfile(t, &alice, true)
+		assert.Equal(t, "donor", profilePropertyValues(response.Properties)["rank"])
+	}
+
+	accessToken := ts.authenticate(t, "Bob", TEST_PASSWORD).AccessToken
+	otherAccessToken := ts.authenticate(t, "Alice", TEST_PASSWORD).AccessToken
+	{
+		rec := ts.putProfileProperties(t, alice.UUID, apiProfileProperties{}, &otherAccessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		// Per-user properties take precedence over the config file
+		payload := apiProfileProperties{Properties: []apiProfileProperty{
+			{Name: "rank", Value: "moderator"},
+			{Name: "nickname", Value: "Al"},
+		}}
+		rec = ts.putProfileProperties(t, alice.UUID, payload, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response apiProfileProperties
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, []apiProfileProperty{{Name: "nickname", Value: "Al"}, {Name: "rank", Value: "moderator"}}, response.Properties)
+
+		rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/users/"+alice.UUID+"/properties", nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, 2, len(response.Properties))
+
+		profile := ts.getSessionProfile(t, &alice, true)
+		values := profilePropertyValues(profile.Properties)
+		assert.Equal(t, "moderator", values["rank"])
+		assert.Equal(t, "Al", values["nickname"])
+		assert.Equal(t, "survival", values["server"])
+	}
+	{
+		// The textures property can't be replaced
+		payload := apiProfileProperties{Properties: []apiProfileProperty{{Name: "textures", Value: "x"}}}
+		rec := ts.putProfileProperties(t, alice.UUID, payload, &accessToken)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		var errorResponse ErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+		assert.Equal(t, "Invalid property name textures: textures is reserved", *errorResponse.ErrorMessage)
+
+		rec = ts.putProfileProperties(t, "00000000-0000-0000-0000-000000000000", apiProfileProperties{}, &accessToken)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+	{
+		// Properties are deleted along with the user
+		assert.Nil(t, DeleteUser(ts.App, &alice))
+		var count int64
+		assert.Nil(t, ts.App.DB.Model(&UserProfileProperty{}).Where("user_uuid = ?", alice.UUID).Count(&count).Error)
+		assert.Equal(t, int64(0), count)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+	"net/url"
+	"time"
+)
+
+/*
+QR-code login lets a user who is signed in on one browser sign in on a second
+device, such as a phone or a console with an awkward keyboard, by scanning a
+QR code instead of typing their password. Each code is single-use and short
+lived, and the second device must confirm before it is signed in.
+*/
+
+const QR_CODE_SIZE = 256
+
+var errQRLoginNotFound = errors.New("QR login not found")
+
+func (app *App) CreateQRLogin(user *User) (*QRLogin, error) {
+	now := time.Now()
+	if err := app.DB.Where("expires_at < ?", now).Delete(&QRLogin{}).Error; err != nil {
+		return nil, err
+	}
+
+	token, err := RandomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	qrLogin := QRLogin{
+		Token:     token,
+		UserUUID:  user.UUID,
+		ExpiresAt: now.Add(time.Duration(app.Config().QRLogin.ExpireSec) * time.Second),
+	}
+	if err := app.DB.Create(&qrLogin).Error; err != nil {
+		return nil, err
+	}
+	return &qrLogin, nil
+}
+
+// Find an unexpired QR login and the user it signs in as
+func (app *App) GetQRLogin(token string) (*QRLogin, *User, error) {
+	var qrLogin QRLogin
+	if err := app.DB.First(&qrLogin, "token = ? AND expires_at > ?", token, time.Now()).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errQRLoginNotFound
+		}
+		return nil, nil, err
+	}
+
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", qrLogin.UserUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errQRLoginNotFound
+		}
+		return nil, nil, err
+	}
+	return &qrLogin, &user, nil
+}
+
+// Use up a QR login, returning the user it signs in as. Only the first caller
+// succeeds.
+func (app *App) ClaimQRLogin(token string) (*User, error) {
+	qrLogin, user, err := app.GetQRLogin(token)
+	if err != nil {
+		return nil, err
+	}
+
+	result := app.DB.Delete(qrLogin)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errQRLoginNotFound
+	}
+	return user, nil
+}
+
+func QRLoginURL(app *App, qrLogin *QRLogin) (string, error) {
+	base, err := url.JoinPath(app.FrontEndURL, "drasl/qr-login/claim")
+	if err != nil {
+		return "", err
+	}
+	return base + "?" + url.Values{"token": {qrLogin.Token}}.Encode(), nil
+}
+
+// A PNG of a QR code encoding content
+func MakeQRCode(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, QR_CODE_SIZE)
+}
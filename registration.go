@@ -0,0 +1,244 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// Why a registration was refused. Code is a stable identifier for API
+// clients; Message is meant to be shown to the user.
+type RegistrationError struct {
+	Code    string
+	Message string
+}
+
+func (err *RegistrationError) Error() string {
+	return err.Message
+}
+
+const (
+	RegistrationErrorInvalidUsername           = "invalid_username"
+	RegistrationErrorInvalidPassword           = "invalid_password"
+	RegistrationErrorInvalidEmail              = "invalid_email"
+	RegistrationErrorInvalidUUID               = "invalid_uuid"
+	RegistrationErrorAddressDenied             = "address_denied"
+	RegistrationErrorNewPlayerNotAllowed       = "new_player_not_allowed"
+	RegistrationErrorExistingPlayerNotAllowed  = "existing_player_not_allowed"
+	RegistrationErrorChoosingUUIDNotAllowed    = "choosing_uuid_not_allowed"
+	RegistrationErrorInviteNotFound            = "invite_not_found"
+	RegistrationErrorUnknownSource             = "unknown_source"
+	RegistrationErrorExistingPlayerNotVerified = "existing_player_not_verified"
+	RegistrationErrorUsernameTaken             = "username_taken"
+	RegistrationErrorUUIDTaken                 = "uuid_taken"
+	RegistrationErrorTermsNotAccepted          = "terms_not_accepted"
+)
+
+type RegistrationRequest struct {
+	Username       string
+	Password       string
+	Email          string
+	ChosenUUID     string
+	InviteCode     string
+	ExistingPlayer bool
+	// Only used when ExistingPlayer is set
+	Source         string
+	ChallengeToken string
+	// The address the request came from, checked against
+	// RegistrationRestrictions
+	IP           string
+	BrowserToken *string
+	// Whether the user accepted the TermsOfService
+	AcceptedTerms bool
+	// Create the account regardless of the registration policy, for accounts
+	// set up by the operator, e.g. in `drasl setup`. The username and
+	// password are still validated.
+	SkipPolicy bool
+}
+
+// Create an account for a new user, enforcing the registration policy. Errors
+// the user can do something about are returned as *RegistrationError.
+func (app *App) RegisterUser(req *RegistrationRequest) (*User, error) {
+	username := req.Username
+
+	if err := ValidateUsername(app, username); err != nil {
+		return nil, &RegistrationError{RegistrationErrorInvalidUsername, fmt.Sprintf("Invalid username: %s", err)}
+	}
+	if err := ValidatePassword(app, req.Password); err != nil {
+		return nil, &RegistrationError{RegistrationErrorInvalidPassword, fmt.Sprintf("Invalid password: %s", err)}
+	}
+	if err := ValidateRegistrationEmail(app, req.Email); err != nil {
+		return nil, &RegistrationError{RegistrationErrorInvalidEmail, fmt.Sprintf("Invalid email: %s", err)}
+	}
+	if err := ValidateRegistrationIP(app, req.IP); err != nil && !req.SkipPolicy {
+		return nil, &RegistrationError{RegistrationErrorAddressDenied, fmt.Sprintf("Can't register: %s", err)}
+	}
+	if app.Config().TermsOfService.RequireAcceptance && !req.AcceptedTerms && !req.SkipPolicy {
+		return nil, &RegistrationError{RegistrationErrorTermsNotAccepted, "You must accept the Terms of Service."}
+	}
+
+	inviteNotFound := &RegistrationError{RegistrationErrorInviteNotFound, "Invite not found!"}
+
+	var accountUUID string
+	var invite Invite
+	inviteUsed := false
+	requireApproval := false
+	if req.ExistingPlayer {
+		// Registration from an existing account on another server
+		if !app.Config().RegistrationExistingPlayer.Allow {
+			return nil, &RegistrationError{RegistrationErrorExistingPlayerNotAllowed, "Registration from an existing account is not allowed."}
+		}
+
+		if app.Config().RegistrationExistingPlayer.RequireInvite {
+			result := app.DB.First(&invite, "code = ?", req.InviteCode)
+			if result.Error != nil {
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return nil, inviteNotFound
+				}
+				return nil, result.Error
+			}
+			inviteUsed = true
+		}
+
+		source := getExistingPlayerSource(app, req.Source)
+		if source == nil {
+			return nil, &RegistrationError{RegistrationErrorUnknownSource, "Unknown account provider."}
+		}
+
+		// Verify skin challenge
+		details, err := validateChallenge(app, source, username, req.ChallengeToken, app.Config().RegistrationExistingPlayer.RequireSkinVerification)
+		if err != nil {
+			var message string
+			if app.Config().RegistrationExistingPlayer.RequireSkinVerification {
+				message = fmt.Sprintf("Couldn't verify your skin, maybe try again: %s", err)
+			} else {
+				message = fmt.Sprintf("Couldn't find your account, maybe try again: %s", err)
+			}
+			return nil, &RegistrationError{RegistrationErrorExistingPlayerNotVerified, message}
+		}
+		username = details.Username
+		if err := ValidateUsername(app, username); err != nil {
+			return nil, &RegistrationError{RegistrationErrorInvalidUsername, fmt.Sprintf("Invalid username: %s", err)}
+		}
+		accountUUID = details.UUID
+		requireApproval = app.Config().RegistrationExistingPlayer.RequireApproval
+	} else {
+		// New player registration
+		if !app.Config().RegistrationNewPlayer.Allow && !req.SkipPolicy {
+			return nil, &RegistrationError{RegistrationErrorNewPlayerNotAllowed, "Registration without some existing account is not allowed."}
+		}
+
+		if app.Config().RegistrationNewPlayer.RequireInvite && !req.SkipPolicy {
+			result := app.DB.First(&invite, "code = ?", req.InviteCode)
+			if result.Error != nil {
+				if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+					return nil, inviteNotFound
+				}
+				return nil, result.Error
+			}
+			inviteUsed = true
+		}
+
+		if req.ChosenUUID == "" {
+			accountUUID = uuid.New().String()
+		} else {
+			if !app.Config().RegistrationNewPlayer.AllowChoosingUUID {
+				return nil, &RegistrationError{RegistrationErrorChoosingUUIDNotAllowed, "Choosing a UUID is not allowed."}
+			}
+			chosenUUIDStruct, err := uuid.Parse(req.ChosenUUID)
+			if err != nil {
+				return nil, &RegistrationError{RegistrationErrorInvalidUUID, fmt.Sprintf("Invalid UUID: %s", err)}
+			}
+			accountUUID = chosenUUIDStruct.String()
+		}
+		requireApproval = app.Config().RegistrationNewPlayer.RequireApproval && !req.SkipPolicy
+	}
+
+	passwordSalt := make([]byte, 16)
+	_, err := rand.Read(passwordSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := HashPassword(req.Password, passwordSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	offlineUUID, err := OfflineUUID(username)
+	if err != nil {
+		return nil, err
+	}
+
+	isAdmin := Contains(app.Config().DefaultAdmins, username)
+	user := User{
+		IsAdmin:           isAdmin,
+		IsPendingApproval: requireApproval && !isAdmin,
+		UUID:              accountUUID,
+		Username:          username,
+		PasswordSalt:      passwordSalt,
+		PasswordHash:      passwordHash,
+		Clients:           []Client{},
+		PlayerName:        username,
+		OfflineUUID:       offlineUUID,
+		FallbackPlayer:    accountUUID,
+		PreferredLanguage: app.Config().DefaultPreferredLanguage,
+		SkinModel:         SkinModelClassic,
+		BrowserToken:      MakeNullString(req.BrowserToken),
+		CreatedAt:         time.Now(),
+		NameLastChangedAt: time.Now(),
+	}
+	if req.Email != "" {
+		user.Email = MakeNullString(&req.Email)
+	}
+	if req.AcceptedTerms && app.Config().TermsOfService.Version != "" {
+		user.AcceptedTermsVersion = MakeNullString(&app.Config().TermsOfService.Version)
+		user.AcceptedTermsAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+
+	tx := app.DB.Begin()
+	defer tx.Rollback()
+
+	result := tx.Create(&user)
+	if result.Error != nil {
+		if IsErrorUniqueFailedField(result.Error, "users.username") ||
+			IsErrorUniqueFailedField(result.Error, "users.player_name") ||
+			IsErrorUniqueFailedField(result.Error, "users.normalized_username") ||
+			IsErrorUniqueFailedField(result.Error, "users.normalized_player_name") {
+			return nil, &RegistrationError{RegistrationErrorUsernameTaken, "That username is taken."}
+		} else if IsErrorUniqueFailedField(result.Error, "users.uuid") {
+			return nil, &RegistrationError{RegistrationErrorUUIDTaken, "That UUID is taken."}
+		}
+		return nil, result.Error
+	}
+
+	if inviteUsed {
+		result = tx.Delete(&invite)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	}
+
+	result = tx.Commit()
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	app.IncrementStat(StatRegistrations)
+	app.PublishEvent(MakeEvent(EventRegistration, &user, req.IP))
+
+	if user.IsPendingApproval {
+		go app.NotifyPendingApproval(&user)
+	}
+	if req.Email != "" {
+		if err := app.SendVerificationEmail(&user); err != nil {
+			log.Printf("Couldn't send verification email to %s: %s\n", user.Username, err)
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+/*
+Abuse reports. Signed-in users can report another player, e.g. for an
+offensive skin or player name, from their profile page or through
+/drasl/api/v2/reports. Reports wait in a queue on the Admin reports page
+until an admin resolves them by clearing the player's skin, locking their
+account, or dismissing the report. Each user can file at most
+Reports.MaxPerDay reports a day, so the queue can't be flooded.
+*/
+
+const (
+	ReportReasonSkin  string = "skin"
+	ReportReasonName  string = "name"
+	ReportReasonOther string = "other"
+)
+
+var REPORT_REASONS = []string{ReportReasonSkin, ReportReasonName, ReportReasonOther}
+
+const (
+	ReportStatusOpen      string = "open"
+	ReportStatusResolved  string = "resolved"
+	ReportStatusDismissed string = "dismissed"
+)
+
+const (
+	ReportActionClearSkin string = "clear-skin"
+	ReportActionLock      string = "lock"
+	ReportActionDismiss   string = "dismiss"
+)
+
+var REPORT_ACTIONS = []string{ReportActionClearSkin, ReportActionLock, ReportActionDismiss}
+
+const MAX_REPORT_DETAILS_LENGTH = 1000
+
+// How many resolved and dismissed reports the Admin reports page shows
+const RECENT_REPORTS_COUNT = 20
+
+var errReportNotAllowed = errors.New("reports are not allowed")
+var errReportTargetNotFound = errors.New("player not found")
+var errReportSelf = errors.New("you can't report yourself")
+var errReportLimit = errors.New("too many reports")
+var errReportNotFound = errors.New("report not found")
+var errReportClosed = errors.New("report already closed")
+
+func ValidateReportReason(reason string) error {
+	if !Contains(REPORT_REASONS, reason) {
+		return fmt.Errorf("must be one of %s", strings.Join(REPORT_REASONS, ", "))
+	}
+	return nil
+}
+
+func ValidateReportDetails(details string) error {
+	if utf8.RuneCountInString(details) > MAX_REPORT_DETAILS_LENGTH {
+		return fmt.Errorf("can't be longer than %d characters", MAX_REPORT_DETAILS_LENGTH)
+	}
+	return nil
+}
+
+// Report the player named `playerName` on behalf of `reporter`. The reason
+// and details should already be validated with ValidateReportReason and
+// ValidateReportDetails.
+func (app *App) CreateReport(reporter *User, playerName string, reason string, details string) (*Report, error) {
+	if !app.Config().Reports.Allow {
+		return nil, errReportNotAllowed
+	}
+
+	var target User
+	if err := app.DB.First(&target, "player_name = ?", playerName).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errReportTargetNotFound
+		}
+		return nil, err
+	}
+	if target.UUID == reporter.UUID {
+		return nil, errReportSelf
+	}
+
+	report := Report{
+		TargetUUID:       target.UUID,
+		TargetPlayerName: target.PlayerName,
+		TargetSkinHash:   target.SkinHash,
+		ReporterUUID:     reporter.UUID,
+		Reason:           reason,
+		Details:          strings.TrimSpace(details),
+		Status:           ReportStatusOpen,
+		CreatedAt:        time.Now(),
+	}
+	err := app.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&Report{}).
+			Where("reporter_uuid = ? AND created_at > ?", reporter.UUID, time.Now().Add(-24*time.Hour)).
+			Count(&count).Error
+		if err != nil {
+			return err
+		}
+		if count >= int64(app.Config().Reports.MaxPerDay) {
+			return errReportLimit
+		}
+		return tx.Create(&report).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (app *App) GetReport(id uint) (*Report, error) {
+	var report Report
+	if err := app.DB.First(&report, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errReportNotFound
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// A report with the users it's about, for the Admin reports page. Target
+// and Reporter are nil if they can't be found.
+type ReportWithUsers struct {
+	Report
+	Target   *User
+	Reporter *User
+}
+
+// Open reports, oldest first, and the most recently closed ones
+func (app *App) GetReports() ([]ReportWithUsers, []ReportWithUsers, error) {
+	var open []Report
+	if err := app.DB.Where("status = ?", ReportStatusOpen).Order("created_at").Find(&open).Error; err != nil {
+		return nil, nil, err
+	}
+	var closed []Report
+	err := app.DB.Where("status != ?", ReportStatusOpen).Order("resolved_at desc").Limit(RECENT_REPORTS_COUNT).Find(&closed).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := map[string]*User{}
+	withUsers := func(reports []Report) ([]ReportWithUsers, error) {
+		result := make([]ReportWithUsers, 0, len(reports))
+		for _, report := range reports {
+			for _, uuid := range []string{report.TargetUUID, report.ReporterUUID} {
+				if _, ok := users[uuid]; ok {
+					continue
+				}
+				var user User
+				err := app.DB.First(&user, "uuid = ?", uuid).Error
+				if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil, err
+				}
+				if err == nil {
+					users[uuid] = &user
+				} else {
+					users[uuid] = nil
+				}
+			}
+			result = append(result, ReportWithUsers{
+				Report:   report,
+				Target:   users[report.TargetUUID],
+				Reporter: users[report.ReporterUUID],
+			})
+		}
+		return result, nil
+	}
+	openWithUsers, err := withUsers(open)
+	if err != nil {
+		return nil, nil, err
+	}
+	closedWithUsers, err := withUsers(closed)
+	if err != nil {
+		return nil, nil, err
+	}
+	return openWithUsers, closedWithUsers, nil
+}
+
+// Close an open report, doing `action` to the reported player. Clearing the
+// skin leaves it alone if the player has changed it since the report.
+func (app *App) ResolveReport(admin *User, report *Report, action string) error {
+	if !Contains(REPORT_ACTIONS, action) {
+		return fmt.Errorf("Unknown action %s", action)
+	}
+	if report.Status != ReportStatusOpen {
+		return errReportClosed
+	}
+
+	var target User
+	if err := app.DB.First(&target, "uuid = ?", report.TargetUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errReportTargetNotFound
+		}
+		return err
+	}
+
+	switch action {
+	case ReportActionClearSkin:
+		if target.SkinHash.Valid && target.SkinHash == report.TargetSkinHash {
+			if err := SetSkinAndSave(app, &target, nil); err != nil {
+				return err
+			}
+		}
+	case ReportActionLock:
+		if target.IsAdmin {
+			return errors.New("Admins can't be locked")
+		}
+		if err := app.SetIsLocked(app.DB, &target, true); err != nil {
+			return err
+		}
+		if err := app.DB.Save(&target).Error; err != nil {
+			return err
+		}
+	}
+
+	report.Status = ReportStatusResolved
+	if action == ReportActionDismiss {
+		report.Status = ReportStatusDismissed
+	}
+	report.Resolution = MakeNullString(&action)
+	report.ResolvedByUUID = MakeNullString(&admin.UUID)
+	report.ResolvedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := app.DB.Save(report).Error; err != nil {
+		return err
+	}
+
+	details := fmt.Sprintf("report %d (%s): %s", report.ID, report.Reason, action)
+	return app.LogAudit(admin, AuditActionResolveReport, &target, details)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestReports(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test reports not allowed", ts.testReportsNotAllowed)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		config.Reports.Allow = true
+		config.Reports.MaxPerDay = 3
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test reports", ts.testReports)
+	}
+}
+
+func (ts *TestSuite) testReportsNotAllowed(t *testing.T) {
+	username := "notAllowed"
+	ts.CreateTestUser(ts.Server, username)
+	accessToken := ts.authenticate(t, username, TEST_PASSWORD).AccessToken
+
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/reports", apiReportRequest{
+		PlayerName: username,
+		Reason:     ReportReasonSkin,
+	}, nil, &accessToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func (ts *TestSuite) testReports(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+	reporterBrowserTokenCookie := ts.CreateTestUser(ts.Server, "reporter")
+	ts.CreateTestUser(ts.Server, "target")
+
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", "admin").Error)
+	var reporter User
+	assert.Nil(t, ts.App.DB.First(&reporter, "username = ?", "reporter").Error)
+	var target User
+	assert.Nil(t, ts.App.DB.First(&target, "username = ?", "target").Error)
+	assert.Nil(t, SetSkinAndSave(ts.App, &target, bytes.NewReader(RED_SKIN)))
+
+	report := func(playerName string, reason string) string {
+		form := url.Values{}
+		form.Set("playerName", playerName)
+		form.Set("reason", reason)
+		form.Set("details", "Not nice")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/report", form, []http.Cookie{*reporterBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+	resolve := func(reportID uint, action string) string {
+		form := url.Values{}
+		form.Set("reportId", fmt.Sprint(reportID))
+		form.Set("action", action)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/admin/reports")
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/resolve-report", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	assert.Equal(t, "Player not found.", report("nobody", ReportReasonSkin))
+	assert.Equal(t, "You can't report yourself.", report("reporter", ReportReasonSkin))
+	assert.Equal(t, "Invalid reason: must be one of skin, name, other", report("target", "rudeness"))
+	assert.Equal(t, "", report("target", ReportReasonSkin))
+
+	// Reports can also be sent through the API
+	accessToken := ts.authenticate(t, "reporter", TEST_PASSWORD).AccessToken
+	rec := ts.PostJSON(t, ts.Server, "/drasl/api/v1/reports", apiReportRequest{
+		PlayerName: "target",
+		Reason:     ReportReasonName,
+	}, nil, &accessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "", report("admin", ReportReasonOther))
+
+	// Each user can only send so many reports a day
+	assert.Equal(t, "You've sent too many reports. Try again tomorrow.", report("target", ReportReasonSkin))
+	rec = ts.PostJSON(t, ts.Server, "/drasl/api/v1/reports", apiReportRequest{
+		PlayerName: "target",
+		Reason:     ReportReasonName,
+	}, nil, &accessToken)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	var reports []Report
+	assert.Nil(t, ts.App.DB.Order("id").Find(&reports).Error)
+	assert.Equal(t, 3, len(reports))
+	assert.Equal(t, target.SkinHash, reports[0].TargetSkinHash)
+	assert.Equal(t, "Not nice", reports[0].Details)
+
+	// The queue is only for admins
+	rec = ts.Get(t, ts.Server, "/drasl/admin/reports", []http.Cookie{*reporterBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	rec = ts.Get(t, ts.Server, "/drasl/admin/reports", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Not nice")
+
+	assert.Equal(t, "", resolve(reports[0].ID, ReportActionClearSkin))
+	assert.Nil(t, ts.App.DB.First(&target, "uuid = ?", target.UUID).Error)
+	assert.False(t, target.SkinHash.Valid)
+	assert.Equal(t, "That report has already been closed.", resolve(reports[0].ID, ReportActionDismiss))
+
+	assert.Equal(t, "", resolve(reports[1].ID, ReportActionLock))
+	assert.Nil(t, ts.App.DB.First(&target, "uuid = ?", target.UUID).Error)
+	assert.True(t, target.IsLocked)
+
+	// Admins can't be locked, but the report can still be dismissed
+	assert.Equal(t, "Admins can't be locked", resolve(reports[2].ID, ReportActionLock))
+	assert.Equal(t, "", resolve(reports[2].ID, ReportActionDismiss))
+
+	assert.Nil(t, ts.App.DB.Order("id").Find(&reports).Error)
+	assert.Equal(t, ReportStatusResolved, reports[0].Status)
+	assert.Equal(t, ReportStatusResolved, reports[1].Status)
+	assert.Equal(t, ReportStatusDismissed, reports[2].Status)
+	assert.Equal(t, admin.UUID, reports[2].ResolvedByUUID.String)
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionResolveReport).Error)
+	assert.Equal(t, admin.UUID, auditLogEntry.ActorUUID)
+
+	assert.Nil(t, DeleteUser(ts.App, &target))
+	var count int64
+	assert.Nil(t, ts.App.DB.Model(&Report{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	// Deleting the reporter keeps their report but anonymizes it
+	assert.Nil(t, DeleteUser(ts.App, &reporter))
+	assert.Nil(t, ts.App.DB.Order("id").Find(&reports).Error)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, admin.UUID, reports[0].TargetUUID)
+	assert.Equal(t, "", reports[0].ReporterUUID)
+	rec = ts.Get(t, ts.Server, "/drasl/admin/reports", []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
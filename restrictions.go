@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// A small built-in list of well-known disposable email providers, used when
+// RegistrationRestrictions.DenyDisposableEmail is set. It's not meant to be
+// exhaustive; add more with DeniedEmailDomains.
+var disposableEmailDomains = []string{
+	"10minutemail.com",
+	"33mail.com",
+	"burnermail.io",
+	"disposablemail.com",
+	"dispostable.com",
+	"emailfake.com",
+	"emailondeck.com",
+	"fakeinbox.com",
+	"getnada.com",
+	"guerrillamail.com",
+	"guerrillamail.net",
+	"guerrillamailblock.com",
+	"mail.tm",
+	"maildrop.cc",
+	"mailinator.com",
+	"mailnesia.com",
+	"mintemail.com",
+	"mohmal.com",
+	"mytemp.email",
+	"sharklasers.com",
+	"spamgourmet.com",
+	"temp-mail.org",
+	"tempmail.com",
+	"tempmailo.com",
+	"temporary-mail.net",
+	"throwawaymail.com",
+	"tmpmail.org",
+	"trashmail.com",
+	"yopmail.com",
+}
+
+// Whether `domain` is `parent` or one of its subdomains
+func domainMatches(domain string, parent string) bool {
+	parent = strings.ToLower(strings.TrimPrefix(parent, "."))
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+func IsDisposableEmailDomain(domain string) bool {
+	domain = strings.ToLower(domain)
+	for _, disposable := range disposableEmailDomains {
+		if domainMatches(domain, disposable) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether RegistrationRestrictions needs an email address to check
+func RegistrationRequiresEmail(app *App) bool {
+	restrictions := &app.Config().RegistrationRestrictions
+	return len(restrictions.AllowedEmailDomains) > 0 ||
+		len(restrictions.DeniedEmailDomains) > 0 ||
+		restrictions.DenyDisposableEmail
+}
+
+// Check an email address given at registration against
+// RegistrationRestrictions
+func ValidateRegistrationEmail(app *App, email string) error {
+	if email == "" {
+		if RegistrationRequiresEmail(app) {
+			return errors.New("an email address is required")
+		}
+		return nil
+	}
+	if err := ValidateEmail(email); err != nil {
+		return err
+	}
+
+	restrictions := &app.Config().RegistrationRestrictions
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+
+	for _, denied := range restrictions.DeniedEmailDomains {
+		if domainMatches(domain, denied) {
+			return errors.New("addresses from that domain aren't allowed")
+		}
+	}
+	if restrictions.DenyDisposableEmail && IsDisposableEmailDomain(domain) {
+		return errors.New("disposable email addresses aren't allowed")
+	}
+	if len(restrictions.AllowedEmailDomains) > 0 {
+		for _, allowed := range restrictions.AllowedEmailDomains {
+			if domainMatches(domain, allowed) {
+				return nil
+			}
+		}
+		return errors.New("addresses from that domain aren't allowed")
+	}
+	return nil
+}
+
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the address a registration came from against
+// RegistrationRestrictions. Denied ranges take precedence over allowed ones.
+func ValidateRegistrationIP(app *App, address string) error {
+	if len(app.RegistrationAllowedNets) == 0 && len(app.RegistrationDeniedNets) == 0 {
+		return nil
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return errors.New("couldn't determine your IP address")
+	}
+	if containsIP(app.RegistrationDeniedNets, ip) {
+		return errors.New("registration isn't allowed from your network")
+	}
+	if len(app.RegistrationAllowedNets) > 0 && !containsIP(app.RegistrationAllowedNets, ip) {
+		return errors.New("registration isn't allowed from your network")
+	}
+	return nil
+}
+
+// Whether path belongs to the admin pages or the admin API
+func IsAdminPath(path string) bool {
+	prefixes := []string{"/drasl/admin"}
+	for _, version := range API_VERSIONS {
+		prefixes = append(prefixes, API_PATH_PREFIX+version.Name+"/admin")
+	}
+	for _, prefix := range prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the address a request came from against AdminRestrictions. Denied
+// ranges take precedence over allowed ones.
+func IsAdminIPAllowed(app *App, address string) bool {
+	if len(app.AdminAllowedNets) == 0 && len(app.AdminDeniedNets) == 0 {
+		return true
+	}
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	if containsIP(app.AdminDeniedNets, ip) {
+		return false
+	}
+	return len(app.AdminAllowedNets) == 0 || containsIP(app.AdminAllowedNets, ip)
+}
+
+// The rate limiting bucket of a client. IPv6 clients usually get a whole
+// subnet, so they're limited by the RateLimit.IPv6PrefixLength prefix of
+// their address rather than the address itself.
+func RateLimitKey(app *App, address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil || ip.To4() != nil {
+		return address
+	}
+	mask := net.CIDRMask(app.Config().RateLimit.IPv6PrefixLength, 128)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// Whether a client is in RateLimit.ExemptCIDRs, e.g. a Minecraft server that
+// makes many requests on behalf of its players
+func IsRateLimitExempt(app *App, address string) bool {
+	if len(app.RateLimitExemptNets) == 0 {
+		return false
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && containsIP(app.RateLimitExemptNets, ip)
+}
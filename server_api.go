@@ -0,0 +1,634 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Session introspection for Minecraft servers and proxies listed in
+TrustedServers. The hasJoined handshake only tells a server that a player
+joined *some* server with a given server ID; these endpoints let a backend
+server behind a proxy check a player's access token directly, or check that
+the player joined recently from the address the proxy saw. Trusted servers
+also fetch their forwarding secrets here; see forwarding_secrets.go. Other
+trusted services, like map servers and web panels, can check any launcher
+access token or personal API token with /drasl/api/v2/introspect to sign
+players in with their Drasl account.
+*/
+
+// Default and maximum age of a join accepted by /drasl/api/:version/server/joined
+const DEFAULT_RECENT_JOIN_SEC = 30
+const MAX_RECENT_JOIN_SEC = 600
+
+func withTrustedServer(app *App, f func(c echo.Context, trustedServer *TrustedServer) error) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		bearerToken, ok := getBearerToken(c)
+		if ok {
+			for i := range app.Config().TrustedServers {
+				trustedServer := &app.Config().TrustedServers[i]
+				if subtle.ConstantTimeCompare([]byte(bearerToken), []byte(trustedServer.Token)) == 1 {
+					return f(c, trustedServer)
+				}
+			}
+		}
+		return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
+	}
+}
+
+type apiIntrospectRequest struct {
+	AccessToken string `json:"accessToken"`
+}
+
+type apiIntrospectResponse struct {
+	// Whether the token would be accepted by /session/minecraft/join
+	Active bool    `json:"active"`
+	ID     *string `json:"id,omitempty"`
+	Name   *string `json:"name,omitempty"`
+	// Whether the token can only be used to sign in and join servers
+	AuthOnly *bool `json:"authOnly,omitempty"`
+}
+
+// POST /drasl/api/:version/server/introspect
+// Check an access token a player handed to a trusted server
+func APIServerIntrospect(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		req := new(apiIntrospectRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+
+		client := app.GetClient(req.AccessToken, StalePolicyDeny)
+		if client == nil || client.User.IsLocked || client.User.IsPendingApproval {
+			return c.JSON(http.StatusOK, apiIntrospectResponse{Active: false})
+		}
+		id, err := UUIDToID(client.User.UUID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, apiIntrospectResponse{
+			Active:   true,
+			ID:       &id,
+			Name:     &client.User.PlayerName,
+			AuthOnly: &client.AuthOnly,
+		})
+	})
+}
+
+// Scopes of launcher access tokens reported by /drasl/api/:version/introspect.
+// Personal API tokens have the scopes they were created with.
+const (
+	IntrospectScopeJoin    = "join"
+	IntrospectScopeProfile = "profile"
+)
+
+type apiTokenIntrospectRequest struct {
+	Token string `json:"token" form:"token"`
+}
+
+type apiTokenIntrospectResponse struct {
+	Active bool `json:"active"`
+	// "access_token" for a launcher's access token, "api_token" for a
+	// personal API token
+	TokenType *string `json:"tokenType,omitempty"`
+	UUID      *string `json:"uuid,omitempty"`
+	Name      *string `json:"name,omitempty"`
+	// Null if the token doesn't expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+}
+
+// POST /drasl/api/:version/introspect
+// Check a launcher access token or personal API token a user handed to a
+// trusted service
+func APIIntrospect(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		req := new(apiTokenIntrospectRequest)
+		if err := c.Bind(req); err != nil || req.Token == "" {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+
+		if strings.HasPrefix(req.Token, API_TOKEN_PREFIX) {
+			if !app.Config().APITokens.Allow {
+				return c.JSON(http.StatusOK, apiTokenIntrospectResponse{Active: false})
+			}
+			var apiToken APIToken
+			if err := app.DB.Preload("User").First(&apiToken, "token_hash = ?", hashAPIToken(req.Token)).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return c.JSON(http.StatusOK, apiTokenIntrospectResponse{Active: false})
+				}
+				return err
+			}
+			if apiToken.User.IsLocked || apiToken.User.IsPendingApproval {
+				return c.JSON(http.StatusOK, apiTokenIntrospectResponse{Active: false})
+			}
+			return c.JSON(http.StatusOK, apiTokenIntrospectResponse{
+				Active:    true,
+				TokenType: Ptr("api_token"),
+				UUID:      &apiToken.User.UUID,
+				Name:      &apiToken.User.PlayerName,
+				Scopes:    apiToken.ScopeList(),
+			})
+		}
+
+		claims := app.ParseAccessToken(req.Token)
+		client := app.GetClient(req.Token, StalePolicyDeny)
+		if claims == nil || client == nil || client.User.IsLocked || client.User.IsPendingApproval {
+			return c.JSON(http.StatusOK, apiTokenIntrospectResponse{Active: false})
+		}
+		var expiresAt *time.Time
+		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(DISTANT_FUTURE) {
+			expiresAt = &claims.ExpiresAt.Time
+		}
+		scopes := []string{IntrospectScopeJoin}
+		if !client.AuthOnly {
+			scopes = append(scopes, IntrospectScopeProfile)
+		}
+		return c.JSON(http.StatusOK, apiTokenIntrospectResponse{
+			Active:    true,
+			TokenType: Ptr("access_token"),
+			UUID:      &client.User.UUID,
+			Name:      &client.User.PlayerName,
+			ExpiresAt: expiresAt,
+			Scopes:    scopes,
+		})
+	})
+}
+
+type apiServerJoinedResponse struct {
+	Joined   bool       `json:"joined"`
+	ServerID *string    `json:"serverId,omitempty"`
+	JoinedAt *time.Time `json:"joinedAt,omitempty"`
+}
+
+// GET /drasl/api/:version/server/joined?uuid=...&ip=...&withinSec=...
+// Whether the player joined a server from `ip` in the last `withinSec`
+// seconds. `uuid` may be given with or without dashes.
+func APIServerJoined(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		uuid := c.QueryParam("uuid")
+		if !strings.Contains(uuid, "-") {
+			var err error
+			uuid, err = IDToUUID(uuid)
+			if err != nil {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid UUID."))
+			}
+		}
+		ip := c.QueryParam("ip")
+		if ip == "" {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Missing IP address."))
+		}
+		withinSec := DEFAULT_RECENT_JOIN_SEC
+		if withinSecParam := c.QueryParam("withinSec"); withinSecParam != "" {
+			var err error
+			withinSec, err = strconv.Atoi(withinSecParam)
+			if err != nil || withinSec <= 0 || withinSec > MAX_RECENT_JOIN_SEC {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid withinSec."))
+			}
+		}
+
+		var user User
+		if err := app.DB.First(&user, "uuid = ?", uuid).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.JSON(http.StatusOK, apiServerJoinedResponse{Joined: false})
+			}
+			return err
+		}
+
+		since := time.Now().Add(-time.Duration(withinSec) * time.Second)
+		if !user.JoinedAt.Valid || user.JoinedAt.Time.Before(since) || !user.JoinIP.Valid || user.JoinIP.String != ip {
+			return c.JSON(http.StatusOK, apiServerJoinedResponse{Joined: false})
+		}
+		return c.JSON(http.StatusOK, apiServerJoinedResponse{
+			Joined:   true,
+			ServerID: UnmakeNullString(&user.ServerID),
+			JoinedAt: &user.JoinedAt.Time,
+		})
+	})
+}
+
+type apiForwardingSecret struct {
+	Backend   string    `json:"backend"`
+	Secret    string    `json:"secret"`
+	RotatedAt time.Time `json:"rotatedAt"`
+}
+
+type apiForwardingSecretsResponse struct {
+	ForwardingSecrets []apiForwardingSecret `json:"forwardingSecrets"`
+}
+
+// GET /drasl/api/:version/server/forwarding-secrets
+// The forwarding secrets the trusted server may use: every backend's for a
+// proxy, and only its own for a backend
+func APIServerForwardingSecrets(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, trustedServer *TrustedServer) error {
+		forwardingSecrets, err := app.GetForwardingSecrets()
+		if err != nil {
+			return err
+		}
+		res := apiForwardingSecretsResponse{ForwardingSecrets: []apiForwardingSecret{}}
+		for _, forwardingSecret := range forwardingSecrets {
+			if !trustedServer.CanUseForwardingSecret(forwardingSecret.Backend) {
+				continue
+			}
+			res.ForwardingSecrets = append(res.ForwardingSecrets, apiForwardingSecret{
+				Backend:   forwardingSecret.Backend,
+				Secret:    forwardingSecret.Secret,
+				RotatedAt: forwardingSecret.RotatedAt,
+			})
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+type apiVerifyForwardingSecretRequest struct {
+	Backend string `json:"backend"`
+	Secret  string `json:"secret"`
+}
+
+type apiVerifyForwardingSecretResponse struct {
+	Valid bool `json:"valid"`
+}
+
+// POST /drasl/api/:version/server/forwarding-secrets/verify
+// Check that a server's configured secret is the backend's current one, e.g.
+// at startup or after a rotation
+func APIServerVerifyForwardingSecret(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, trustedServer *TrustedServer) error {
+		req := new(apiVerifyForwardingSecretRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		if !trustedServer.CanUseForwardingSecret(req.Backend) {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("This server can't use that backend's forwarding secret."))
+		}
+		valid, err := app.VerifyForwardingSecret(req.Backend, req.Secret)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, apiVerifyForwardingSecretResponse{Valid: valid})
+	})
+}
+
+type apiModernForwardingRequest struct {
+	Backend  string `json:"backend"`
+	Username string `json:"username"`
+	ServerID string `json:"serverId"`
+	// The player's address, as the proxy saw it
+	IP string `json:"ip"`
+}
+
+type apiModernForwardingResponse struct {
+	ID         string                   `json:"id"`
+	Name       string                   `json:"name"`
+	Properties []SessionProfileProperty `json:"properties"`
+	Version    int                      `json:"version"`
+	// Base64-encoded signature and player info, ready to send to the backend
+	ForwardingData string `json:"forwardingData"`
+}
+
+// POST /drasl/api/:version/server/modern-forwarding
+// Check that a player joined with `serverId`, like /session/minecraft/hasJoined,
+// and sign their player info for `backend` in the format of Velocity's modern
+// forwarding. Only proxies can use it.
+func APIServerModernForwarding(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, trustedServer *TrustedServer) error {
+		if !trustedServer.IsProxy {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Only proxies can forward player info."))
+		}
+		req := new(apiModernForwardingRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		if net.ParseIP(req.IP) == nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid IP address."))
+		}
+
+		var user User
+		if err := app.DB.First(&user, "player_name = ?", req.Username).Error; err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		if user.UUID == "" || !user.ServerID.Valid || req.ServerID == "" || user.ServerID.String != req.ServerID {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("That player hasn't joined with that server ID."))
+		}
+
+		profile, err := fullProfile(app, &user, user.UUID, true, app.Config().TexturesCompatibility.Profile)
+		if err != nil {
+			return err
+		}
+		forwardingData, err := app.MakeModernForwardingData(req.Backend, req.IP, &profile)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return MakeErrorResponse(&c, http.StatusNotFound, Ptr("NotFoundException"), Ptr("That backend has no forwarding secret."))
+			}
+			return err
+		}
+		return c.JSON(http.StatusOK, apiModernForwardingResponse{
+			ID:             profile.ID,
+			Name:           profile.Name,
+			Properties:     profile.Properties,
+			Version:        MODERN_FORWARDING_VERSION,
+			ForwardingData: base64.StdEncoding.EncodeToString(forwardingData),
+		})
+	})
+}
+
+type apiBedrockLinkRequest struct {
+	Code     string `json:"code"`
+	XUID     string `json:"xuid"`
+	Gamertag string `json:"gamertag"`
+}
+
+type apiBedrockLinkResponse struct {
+	XUID     string `json:"xuid"`
+	Gamertag string `json:"gamertag"`
+	// The Java profile of the linked account
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func makeAPIBedrockLinkResponse(user *User, link *BedrockLink) (*apiBedrockLinkResponse, error) {
+	id, err := UUIDToID(user.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return &apiBedrockLinkResponse{
+		XUID:     link.XUID,
+		Gamertag: link.Gamertag,
+		ID:       id,
+		Name:     user.PlayerName,
+	}, nil
+}
+
+// POST /drasl/api/:version/server/bedrock-link
+// Link the Bedrock player who entered a link code to the code's account
+func APIServerBedrockLink(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		if !app.Config().Floodgate.Enable {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Bedrock accounts are not supported on this server."))
+		}
+		req := new(apiBedrockLinkRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		if _, err := XUIDToFloodgateUUID(req.XUID); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid XUID."))
+		}
+
+		user, err := app.ClaimBedrockLinkCode(req.Code, req.XUID, req.Gamertag)
+		if err != nil {
+			if errors.Is(err, errBedrockLinkCodeNotFound) {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("That code is invalid or has expired."))
+			}
+			if errors.Is(err, errBedrockAccountAlreadyLinked) {
+				return MakeErrorResponse(&c, http.StatusConflict, Ptr("IllegalArgumentException"), Ptr("That Bedrock account is already linked to another account."))
+			}
+			return err
+		}
+
+		res, err := makeAPIBedrockLinkResponse(user, &BedrockLink{XUID: req.XUID, Gamertag: req.Gamertag})
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// GET /drasl/api/:version/server/bedrock-link?xuid=...
+// The account linked to a Bedrock player. `uuid` may be given instead of
+// `xuid`, as the player's Floodgate UUID.
+func APIServerGetBedrockLink(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		if !app.Config().Floodgate.Enable {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Bedrock accounts are not supported on this server."))
+		}
+		xuid := c.QueryParam("xuid")
+		if uuid := c.QueryParam("uuid"); uuid != "" {
+			var err error
+			xuid, err = FloodgateUUIDToXUID(uuid)
+			if err != nil {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Not a Floodgate UUID."))
+			}
+		}
+
+		user, link, err := app.FindUserByXUID(xuid)
+		if err != nil {
+			return err
+		}
+		if user == nil {
+			return MakeErrorResponse(&c, http.StatusNotFound, Ptr("NotFoundException"), Ptr("That Bedrock account isn't linked."))
+		}
+		res, err := makeAPIBedrockLinkResponse(user, link)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+type apiLinkedAccountResponse struct {
+	// The Drasl profile
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// The existing account linked to it
+	LinkedID   string    `json:"linkedId"`
+	LinkedName string    `json:"linkedName"`
+	Source     string    `json:"source"`
+	LinkedAt   time.Time `json:"linkedAt"`
+}
+
+// Accept either a UUID or an unhyphenated ID
+func parseUUIDQueryParam(value string) (string, error) {
+	if len(value) == 32 {
+		return IDToUUID(strings.ToLower(value))
+	}
+	if len(value) == 36 {
+		return strings.ToLower(value), nil
+	}
+	return "", errors.New("Invalid UUID")
+}
+
+// GET /drasl/api/:version/server/linked-account?uuid=...
+// The existing account linked to a Drasl profile. `linkedUuid` may be given
+// instead of `uuid` to look up the Drasl profile linked to an existing
+// account.
+func APIServerLinkedAccount(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, _ *TrustedServer) error {
+		if !app.Config().AccountLinking.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Account linking is not allowed on this server."))
+		}
+
+		var user *User
+		var linkedAccount *LinkedAccount
+		if linkedUUID := c.QueryParam("linkedUuid"); linkedUUID != "" {
+			linkedUUID, err := parseUUIDQueryParam(linkedUUID)
+			if err != nil {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid linkedUuid."))
+			}
+			user, linkedAccount, err = app.FindUserByLinkedUUID(linkedUUID)
+			if err != nil {
+				return err
+			}
+		} else {
+			userUUID, err := parseUUIDQueryParam(c.QueryParam("uuid"))
+			if err != nil {
+				return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid uuid."))
+			}
+			var found User
+			if err := app.DB.First(&found, "uuid = ?", userUUID).Error; err != nil {
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+			} else {
+				user = &found
+				linkedAccount, err = app.GetLinkedAccount(user)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if user == nil || linkedAccount == nil {
+			return MakeErrorResponse(&c, http.StatusNotFound, Ptr("NotFoundException"), Ptr("That account isn't linked."))
+		}
+
+		id, err := UUIDToID(user.UUID)
+		if err != nil {
+			return err
+		}
+		linkedID, err := UUIDToID(linkedAccount.UUID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, apiLinkedAccountResponse{
+			ID:         id,
+			Name:       user.PlayerName,
+			LinkedID:   linkedID,
+			LinkedName: linkedAccount.PlayerName,
+			Source:     linkedAccount.Source,
+			LinkedAt:   linkedAccount.CreatedAt,
+		})
+	})
+}
+
+type apiServerCommandRequest struct {
+	UUID   string `json:"uuid"`
+	Action string `json:"action"`
+	// The server ID the player joined the calling server with, which only
+	// that server and the player's client know
+	ServerID string `json:"serverId"`
+}
+
+type apiServerCommandResponse struct {
+	Action    string    `json:"action"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type apiServerConfirmCommandRequest struct {
+	UUID string `json:"uuid"`
+	Code string `json:"code"`
+	// New password, for change-password
+	Password string `json:"password"`
+}
+
+type apiServerConfirmCommandResponse struct {
+	Action string `json:"action"`
+}
+
+// The user running an in-game command, by their player's UUID, or an error
+// response if they can't
+func getInGameCommandUser(app *App, c echo.Context, uuid string) (*User, error) {
+	userUUID, err := parseUUIDQueryParam(uuid)
+	if err != nil {
+		return nil, MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid uuid."))
+	}
+	var user User
+	if err := app.DB.First(&user, "uuid = ?", userUUID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, MakeErrorResponse(&c, http.StatusNotFound, Ptr("NotFoundException"), Ptr("That player has no account here."))
+		}
+		return nil, err
+	}
+	if user.IsLocked || user.IsPendingApproval {
+		return nil, MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("This account can't be changed right now."))
+	}
+	return &user, nil
+}
+
+// POST /drasl/api/:version/server/commands
+// Start an account command a player ran in game. The plugin shows the
+// player the returned code, which they confirm the command with.
+func APIServerStartCommand(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, trustedServer *TrustedServer) error {
+		if !app.Config().InGameCommands.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("In-game commands are not allowed on this server."))
+		}
+		req := new(apiServerCommandRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		if !Contains(IN_GAME_COMMANDS, req.Action) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid action. Must be one of: "+strings.Join(IN_GAME_COMMANDS, ", ")+"."))
+		}
+		user, err := getInGameCommandUser(app, c, req.UUID)
+		if user == nil {
+			return err
+		}
+		// Only the server the player last joined can start a command for them
+		if req.ServerID == "" || !user.ServerID.Valid || req.ServerID != user.ServerID.String {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("The player hasn't joined this server."))
+		}
+
+		command, err := app.StartInGameCommand(user, req.Action, trustedServer)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, apiServerCommandResponse{
+			Action:    command.Action,
+			Code:      command.Code,
+			ExpiresAt: command.ExpiresAt,
+		})
+	})
+}
+
+// POST /drasl/api/:version/server/commands/confirm
+// Carry out a player's in-game command once they've entered its code
+func APIServerConfirmCommand(app *App) func(c echo.Context) error {
+	return withTrustedServer(app, func(c echo.Context, trustedServer *TrustedServer) error {
+		if !app.Config().InGameCommands.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("In-game commands are not allowed on this server."))
+		}
+		req := new(apiServerConfirmCommandRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+		user, err := getInGameCommandUser(app, c, req.UUID)
+		if user == nil {
+			return err
+		}
+
+		command, err := app.ConfirmInGameCommand(user, req.Code, req.Password, trustedServer)
+		var commandError *InGameCommandError
+		if errors.As(err, &commandError) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), &commandError.Message)
+		}
+		if err != nil {
+			return err
+		}
+
+		if command.Action == InGameCommandChangePassword {
+			app.NotifySecurityEvent(user, SecurityEventPasswordChange, c.RealIP(), "In-game command on "+trustedServer.Nickname)
+		}
+		return c.JSON(http.StatusOK, apiServerConfirmCommandResponse{Action: command.Action})
+	})
+}
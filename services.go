@@ -10,8 +10,8 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
-	"gorm.io/gorm"
 	"math/big"
 	"net/http"
 	"regexp"
@@ -22,6 +22,27 @@ import (
 // Authenticate a user using a bearer token, and call `f` with a reference to
 // the user
 func withBearerAuthentication(app *App, f func(c echo.Context, user *User) error) func(c echo.Context) error {
+	return withBearerClient(app, true, f)
+}
+
+// Like withBearerAuthentication, for routes that change the user's profile.
+// Clients restricted to authentication are refused.
+func withBearerProfileAuthentication(app *App, f func(c echo.Context, user *User) error) func(c echo.Context) error {
+	return withBearerClient(app, false, f)
+}
+
+var bearerTokenRegex = regexp.MustCompile("^Bearer (.*)$")
+
+// The token in an "Authorization: Bearer <token>" header, if there is one
+func getBearerToken(c echo.Context) (string, bool) {
+	match := bearerTokenRegex.FindStringSubmatch(c.Request().Header.Get("Authorization"))
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+func withBearerClient(app *App, allowAuthOnly bool, f func(c echo.Context, user *User) error) func(c echo.Context) error {
 	bearerExp := regexp.MustCompile("^Bearer (.*)$")
 
 	return func(c echo.Context) error {
@@ -40,6 +61,12 @@ func withBearerAuthentication(app *App, f func(c echo.Context, user *User) error
 		if client == nil {
 			return c.JSON(http.StatusUnauthorized, ErrorResponse{Path: Ptr(c.Request().URL.Path)})
 		}
+		if client.AuthOnly && !allowAuthOnly {
+			return c.JSON(http.StatusForbidden, ErrorResponse{
+				Path:         Ptr(c.Request().URL.Path),
+				ErrorMessage: Ptr("This client is restricted to authentication and can't change your profile."),
+			})
+		}
 		user := client.User
 
 		return f(c, &user)
@@ -53,11 +80,19 @@ type ServicesProfileSkin struct {
 	Variant string `json:"string"`
 }
 
+// One of the cosmetic capes the user is entitled to; see cosmetics.go
+type ServicesProfileCape struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	URL   string `json:"url"`
+	Alias string `json:"alias"`
+}
+
 type ServicesProfile struct {
 	ID    string                `json:"id"`
 	Name  string                `json:"name"`
 	Skins []ServicesProfileSkin `json:"skins"`
-	Capes []string              `json:"capes"` // TODO implement capes, they are undocumented on https://wiki.vg/Mojang_API#Profile_Information
+	Capes []ServicesProfileCape `json:"capes"`
 }
 
 func getServicesProfile(app *App, user *User) (ServicesProfile, error) {
@@ -67,7 +102,7 @@ func getServicesProfile(app *App, user *User) (ServicesProfile, error) {
 	}
 
 	getServicesProfileSkin := func() *ServicesProfileSkin {
-		if !user.SkinHash.Valid && !user.CapeHash.Valid && app.Config.ForwardSkins {
+		if !user.SkinHash.Valid && !user.CapeHash.Valid && app.Config().ForwardSkins {
 			fallbackProperty, err := GetFallbackSkinTexturesProperty(app, user)
 			if err != nil {
 				return nil
@@ -113,11 +148,33 @@ func getServicesProfile(app *App, user *User) (ServicesProfile, error) {
 		skins = []ServicesProfileSkin{*skin}
 	}
 
+	cosmetics, err := app.GetEntitledCosmetics(user)
+	if err != nil {
+		return ServicesProfile{}, err
+	}
+	capes := make([]ServicesProfileCape, 0, len(cosmetics))
+	for _, cosmetic := range cosmetics {
+		capeURL, err := CapeURL(app, cosmetic.TextureHash)
+		if err != nil {
+			return ServicesProfile{}, err
+		}
+		state := "INACTIVE"
+		if PtrEquals(UnmakeNullString(&user.CapeHash), &cosmetic.TextureHash) {
+			state = "ACTIVE"
+		}
+		capes = append(capes, ServicesProfileCape{
+			ID:    cosmetic.UUID,
+			State: state,
+			URL:   capeURL,
+			Alias: cosmetic.Name,
+		})
+	}
+
 	return ServicesProfile{
 		ID:    id,
 		Name:  user.PlayerName,
 		Skins: skins,
-		Capes: []string{},
+		Capes: capes,
 	}, nil
 }
 
@@ -222,8 +279,8 @@ func ServicesPlayerCertificates(app *App) func(c echo.Context) error {
 		now := time.Now().UTC()
 
 		var expiresAt time.Time
-		if app.Config.TokenStaleSec > 0 {
-			expiresAt = now.Add(time.Duration(app.Config.TokenStaleSec) * time.Second)
+		if app.Config().TokenStaleSec > 0 {
+			expiresAt = now.Add(time.Duration(app.Config().TokenStaleSec) * time.Second)
 		} else {
 			expiresAt = DISTANT_FUTURE
 		}
@@ -235,7 +292,7 @@ func ServicesPlayerCertificates(app *App) func(c echo.Context) error {
 		publicKeySignatureText := ""
 		publicKeySignatureV2Text := ""
 
-		if app.Config.SignPublicKeys {
+		if app.Config().SignPublicKeys {
 			// publicKeySignature, used in 1.19
 			// We don't just sign the public key itself---the signed data consists
 			// of expiresAt timestamp as a string, concatenated with the PEM(ish)
@@ -322,10 +379,13 @@ func ServicesPlayerCertificates(app *App) func(c echo.Context) error {
 // POST /minecraft/profile/skins
 // https://wiki.vg/Mojang_API#Upload_Skin
 func ServicesUploadSkin(app *App) func(c echo.Context) error {
-	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
-		if !app.Config.AllowSkins {
+	return withBearerProfileAuthentication(app, func(c echo.Context, user *User) error {
+		if !app.Config().AllowSkins {
 			return MakeErrorResponse(&c, http.StatusBadRequest, nil, Ptr("Changing your skin is not allowed."))
 		}
+		if user.SkinLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, nil, Ptr(errSkinLocked.Error()))
+		}
 
 		model := strings.ToLower(c.FormValue("variant"))
 
@@ -361,7 +421,10 @@ func ServicesUploadSkin(app *App) func(c echo.Context) error {
 // DELETE /minecraft/profile/skins/active
 // https://wiki.vg/Mojang_API#Reset_Skin
 func ServicesResetSkin(app *App) func(c echo.Context) error {
-	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+	return withBearerProfileAuthentication(app, func(c echo.Context, user *User) error {
+		if user.SkinLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, nil, Ptr(errSkinLocked.Error()))
+		}
 		err := SetSkinAndSave(app, user, nil)
 		if err != nil {
 			return err
@@ -371,10 +434,48 @@ func ServicesResetSkin(app *App) func(c echo.Context) error {
 	})
 }
 
+type showCapeRequest struct {
+	CapeID string `json:"capeId"`
+}
+
+// PUT /minecraft/profile/capes/active
+// https://wiki.vg/Mojang_API#Show_Cape
+func ServicesShowCape(app *App) func(c echo.Context) error {
+	return withBearerProfileAuthentication(app, func(c echo.Context, user *User) error {
+		req := new(showCapeRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, nil, Ptr("Invalid request body for showing a cape"))
+		}
+
+		cosmetic, err := app.GetCosmetic(req.CapeID)
+		if err == nil {
+			err = app.WearCosmetic(user, cosmetic)
+		}
+		if errors.Is(err, errCapeLocked) {
+			return MakeErrorResponse(&c, http.StatusForbidden, nil, Ptr(err.Error()))
+		}
+		if errors.Is(err, errCosmeticNotFound) || errors.Is(err, errCosmeticNotEntitled) {
+			return MakeErrorResponse(&c, http.StatusBadRequest, nil, Ptr("profile does not own cape"))
+		}
+		if err != nil {
+			return err
+		}
+
+		servicesProfile, err := getServicesProfile(app, user)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, servicesProfile)
+	})
+}
+
 // DELETE /minecraft/profile/capes/active
 // https://wiki.vg/Mojang_API#Hide_Cape
 func ServicesHideCape(app *App) func(c echo.Context) error {
-	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+	return withBearerProfileAuthentication(app, func(c echo.Context, user *User) error {
+		if user.CapeLocked {
+			return MakeErrorResponse(&c, http.StatusForbidden, nil, Ptr(errCapeLocked.Error()))
+		}
 		err := SetCapeAndSave(app, user, nil)
 		if err != nil {
 			return err
@@ -384,6 +485,88 @@ func ServicesHideCape(app *App) func(c echo.Context) error {
 	})
 }
 
+type entitlementItem struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	// Set for cosmetics; see cosmetics.go
+	ID    string `json:"id,omitempty"`
+	Alias string `json:"alias,omitempty"`
+}
+
+type entitlementsResponse struct {
+	Items     []entitlementItem `json:"items"`
+	Signature string            `json:"signature"`
+	KeyID     string            `json:"keyId"`
+	RequestID string            `json:"requestId,omitempty"`
+}
+
+type entitlementClaims struct {
+	jwt.RegisteredClaims
+	Entitlements []string `json:"entitlements"`
+}
+
+// Every account owns the game
+var GAME_ENTITLEMENTS = []string{"product_minecraft", "game_minecraft"}
+
+func signEntitlements(app *App, user *User, entitlements []string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, entitlementClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  user.UUID,
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+			Issuer:   "drasl",
+		},
+		Entitlements: entitlements,
+	})
+	return token.SignedString(app.Key)
+}
+
+// GET /entitlements/mcstore
+// https://wiki.vg/Mojang_API#Check_Product_Voucher
+// Lists the game and the user's cosmetics. Each item, and the whole list, is
+// signed with Drasl's key as a JWT.
+func ServicesEntitlements(app *App) func(c echo.Context) error {
+	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+		cosmetics, err := app.GetEntitledCosmetics(user)
+		if err != nil {
+			return err
+		}
+
+		res := entitlementsResponse{
+			Items:     make([]entitlementItem, 0, len(GAME_ENTITLEMENTS)+len(cosmetics)),
+			KeyID:     "1",
+			RequestID: c.QueryParam("requestId"),
+		}
+		names := make([]string, 0, cap(res.Items))
+		addItem := func(item entitlementItem, name string) error {
+			signature, err := signEntitlements(app, user, []string{name})
+			if err != nil {
+				return err
+			}
+			item.Signature = signature
+			res.Items = append(res.Items, item)
+			names = append(names, name)
+			return nil
+		}
+		for _, name := range GAME_ENTITLEMENTS {
+			if err := addItem(entitlementItem{Name: name}, name); err != nil {
+				return err
+			}
+		}
+		for _, cosmetic := range cosmetics {
+			item := entitlementItem{Name: cosmetic.Kind, ID: cosmetic.UUID, Alias: cosmetic.Name}
+			if err := addItem(item, cosmetic.Kind+":"+cosmetic.UUID); err != nil {
+				return err
+			}
+		}
+
+		res.Signature, err = signEntitlements(app, user, names)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
 type nameChangeResponse struct {
 	ChangedAt         string `json:"changedAt"`
 	CreatedAt         string `json:"createdAt"`
@@ -399,7 +582,7 @@ func ServicesNameChange(app *App) func(c echo.Context) error {
 		res := nameChangeResponse{
 			ChangedAt:         changedAt,
 			CreatedAt:         createdAt,
-			NameChangeAllowed: app.Config.AllowChangingPlayerName,
+			NameChangeAllowed: PlayerNameChangeAllowed(app, user),
 		}
 		return c.JSON(http.StatusOK, &res)
 	})
@@ -445,22 +628,32 @@ type nameAvailabilityResponse struct {
 func ServicesNameAvailability(app *App) func(c echo.Context) error {
 	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
 		playerName := c.Param("playerName")
-		if !app.Config.AllowChangingPlayerName {
+		if app.NameAvailabilityLimiter != nil {
+			if allowed, err := app.NameAvailabilityLimiter.Allow(user.UUID); err != nil || !allowed {
+				return c.JSON(http.StatusTooManyRequests, changeNameErrorResponse{
+					Path:             c.Request().URL.Path,
+					ErrorType:        "TOO_MANY_REQUESTS",
+					Error:            "TOO_MANY_REQUESTS",
+					ErrorMessage:     "Too many requests.",
+					DeveloperMessage: "Too many requests.",
+				})
+			}
+		}
+		if !PlayerNameChangeAllowed(app, user) {
 			return c.JSON(http.StatusOK, nameAvailabilityResponse{Status: "NOT_ALLOWED"})
 		}
 		if err := ValidatePlayerName(app, playerName); err != nil {
 			errorMessage := fmt.Sprintf("checkNameAvailability.profileName: %s, checkNameAvailability.profileName: Invalid profile name", err.Error())
 			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("CONSTRAINT_VIOLATION"), Ptr(errorMessage))
 		}
-		var otherUser User
-		result := app.DB.First(&otherUser, "player_name = ?", playerName)
-		if result.Error != nil {
-			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-				return c.JSON(http.StatusOK, nameAvailabilityResponse{Status: "AVAILABLE"})
-			}
-			return result.Error
+		taken, err := PlayerNameTaken(app.DB, playerName, "")
+		if err != nil {
+			return err
+		}
+		if taken {
+			return c.JSON(http.StatusOK, nameAvailabilityResponse{Status: "DUPLICATE"})
 		}
-		return c.JSON(http.StatusOK, nameAvailabilityResponse{Status: "DUPLICATE"})
+		return c.JSON(http.StatusOK, nameAvailabilityResponse{Status: "AVAILABLE"})
 	})
 }
 
@@ -479,7 +672,7 @@ type changeNameErrorResponse struct {
 // PUT /minecraft/profile/name/:playerName
 // https://wiki.vg/Mojang_API#Change_Name
 func ServicesChangeName(app *App) func(c echo.Context) error {
-	return withBearerAuthentication(app, func(c echo.Context, user *User) error {
+	return withBearerProfileAuthentication(app, func(c echo.Context, user *User) error {
 		playerName := c.Param("playerName")
 		if err := ValidatePlayerName(app, playerName); err != nil {
 			return c.JSON(http.StatusBadRequest, changeNameErrorResponse{
@@ -490,36 +683,48 @@ func ServicesChangeName(app *App) func(c echo.Context) error {
 				DeveloperMessage: err.Error(),
 			})
 		}
+		forbidden := func(status string, message string) error {
+			return c.JSON(http.StatusForbidden, changeNameErrorResponse{
+				Path:      c.Request().URL.Path,
+				ErrorType: "FORBIDDEN",
+				Error:     "FORBIDDEN",
+				Details: &changeNameErrorDetails{
+					Status: status,
+				},
+				ErrorMessage:     message,
+				DeveloperMessage: message,
+			})
+		}
 		if user.PlayerName != playerName {
-			if app.Config.AllowChangingPlayerName {
-				user.PlayerName = playerName
-				user.NameLastChangedAt = time.Now()
-			} else {
-				message := "Changing your player name is not allowed."
-				return c.JSON(http.StatusBadRequest, changeNameErrorResponse{
-					Path:             c.Request().URL.Path,
-					ErrorType:        "BAD REQUEST",
-					Error:            "BAD REQUEST",
-					ErrorMessage:     message,
-					DeveloperMessage: message,
-				})
+			if err := CheckPlayerNameChange(app, user, user, playerName); err != nil {
+				switch {
+				case errors.Is(err, errPlayerNameChangeNotAllowed):
+					return forbidden("NOT_ALLOWED", "Changing your player name is not allowed.")
+				case errors.Is(err, errPlayerNameChangeCooldown):
+					return forbidden("NOT_ALLOWED", fmt.Sprintf("You can't change your player name again until %s.", PlayerNameChangeAvailableAt(app, user).Format(time.DateOnly)))
+				case errors.Is(err, errPlayerNameTaken):
+					return forbidden("DUPLICATE", "That player name is taken.")
+				}
+				return err
+			}
+			offlineUUID, err := OfflineUUID(playerName)
+			if err != nil {
+				return err
 			}
+			user.PlayerName = playerName
+			user.OfflineUUID = offlineUUID
+			user.NameLastChangedAt = time.Now()
 		}
 
-		err := app.DB.Save(&user).Error
+		db := app.DB
+		if app.TextureQueue != nil {
+			// The texture queue may change these at any time
+			db = db.Omit("skin_hash", "cape_hash")
+		}
+		err := db.Save(user).Error
 		if err != nil {
 			if IsErrorUniqueFailed(err) {
-				message := "That player name is taken."
-				return c.JSON(http.StatusForbidden, changeNameErrorResponse{
-					Path:      c.Request().URL.Path,
-					ErrorType: "FORBIDDEN",
-					Error:     "FORBIDDEN",
-					Details: &changeNameErrorDetails{
-						Status: "DUPLICATE",
-					},
-					ErrorMessage:     message,
-					DeveloperMessage: message,
-				})
+				return forbidden("DUPLICATE", "That player name is taken.")
 			}
 			return err
 		}
@@ -562,15 +767,15 @@ func SerializedKeyToPublicKey(serializedKey SerializedKey) (*rsa.PublicKey, erro
 // GET /publickeys
 // TODO document on wiki.vg
 func ServicesPublicKeys(app *App) func(c echo.Context) error {
-	serializedProfilePropertyKeys := make([]SerializedKey, 0, len(app.ProfilePropertyKeys))
-	serializedPlayerCertificateKeys := make([]SerializedKey, 0, len(app.PlayerCertificateKeys))
+	serializedProfilePropertyKeys := make([]SerializedKey, 0, len(app.ProfilePropertyKeys()))
+	serializedPlayerCertificateKeys := make([]SerializedKey, 0, len(app.PlayerCertificateKeys()))
 
-	for _, key := range app.ProfilePropertyKeys {
+	for _, key := range app.ProfilePropertyKeys() {
 		publicKeyDer := Unwrap(x509.MarshalPKIXPublicKey(&key))
 		serializedKey := SerializedKey{PublicKey: base64.StdEncoding.EncodeToString(publicKeyDer)}
 		serializedProfilePropertyKeys = append(serializedProfilePropertyKeys, serializedKey)
 	}
-	for _, key := range app.ProfilePropertyKeys {
+	for _, key := range app.ProfilePropertyKeys() {
 		publicKeyDer := Unwrap(x509.MarshalPKIXPublicKey(&key))
 		serializedKey := SerializedKey{PublicKey: base64.StdEncoding.EncodeToString(publicKeyDer)}
 		serializedPlayerCertificateKeys = append(serializedPlayerCertificateKeys, serializedKey)
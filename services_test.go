@@ -53,6 +53,7 @@ func TestServices(t *testing.T) {
 		t.Run("Test DELETE /minecraft/profile/capes/active", ts.testServicesHideCape)
 		t.Run("Test GET /minecraft/profile", ts.testServicesProfileInformation)
 		t.Run("Test POST /minecraft/profile/skins", ts.testServicesUploadSkin)
+		t.Run("Test auth-only client", ts.testServicesAuthOnlyClient)
 		t.Run("Test GET /minecraft/profile/name/:playerName/available", ts.testServicesNameAvailability)
 		t.Run("Test GET /privacy/blocklist", ts.testServicesPrivacyBlocklist)
 		t.Run("Test GET /rollout/v1/msamigration", ts.testServicesMSAMigration)
@@ -71,6 +72,20 @@ func TestServices(t *testing.T) {
 
 		t.Run("Test POST /minecraft/profile/skins, skins not allowed", ts.testServicesUploadSkinSkinsNotAllowed)
 	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.PlayerNameChange.CooldownDays = 30
+		config.PlayerNameChange.AvailabilityRequestsPerMinute = 3
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test GET /minecraft/profile/name/:playerName/available, cooldown and rate limit", ts.testServicesNameAvailabilityCooldown)
+		t.Run("Test PUT /minecraft/profile/name/:playerName, cooldown", ts.testServicesChangeNameCooldown)
+	}
 }
 
 func (ts *TestSuite) testServicesProfileInformation(t *testing.T) {
@@ -339,6 +354,7 @@ func (ts *TestSuite) testServicesChangeName(t *testing.T) {
 		// New name should be in the database
 		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
 		assert.Equal(t, newName, user.PlayerName)
+		assert.Equal(t, Unwrap(OfflineUUID(newName)), user.OfflineUUID)
 
 		// Change it back
 		user.PlayerName = TEST_USERNAME
@@ -364,6 +380,30 @@ func (ts *TestSuite) testServicesChangeName(t *testing.T) {
 		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
 		assert.Equal(t, TEST_USERNAME, user.PlayerName)
 	}
+	{
+		// Names are case-insensitive
+		newName := strings.ToLower(SERVICES_EXISTING_USERNAME)
+		req := httptest.NewRequest(http.MethodPut, "/minecraft/profile/name/"+newName, nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		var response changeNameErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, "DUPLICATE", response.Details.Status)
+	}
+	{
+		// Invalid names should fail
+		newName := "AReallyLongPlayerName" + strings.Repeat("a", ts.App.Config().MaxPlayerNameLength)
+		req := httptest.NewRequest(http.MethodPut, "/minecraft/profile/name/"+newName, nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
 	{
 		// Should fail if we send an invalid access token
 		newName := "NewName"
@@ -376,6 +416,36 @@ func (ts *TestSuite) testServicesChangeName(t *testing.T) {
 	}
 }
 
+func (ts *TestSuite) testServicesChangeNameCooldown(t *testing.T) {
+	accessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+
+	changeName := func(newName string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/minecraft/profile/name/"+newName, nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	user.NameLastChangedAt = time.Now().AddDate(0, 0, -31)
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+
+	rec := changeName("NewName")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A second change within the cooldown should fail
+	rec = changeName("OtherName")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var response changeNameErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, "NOT_ALLOWED", response.Details.Status)
+
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.Equal(t, "NewName", user.PlayerName)
+}
+
 func (ts *TestSuite) testServicesNameChange(t *testing.T) {
 	accessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
 
@@ -412,6 +482,57 @@ func (ts *TestSuite) testServicesNameAvailability(t *testing.T) {
 
 		assert.Equal(t, "DUPLICATE", response.Status)
 	}
+	{
+		// Names are case-insensitive
+		playerName := strings.ToLower(SERVICES_EXISTING_USERNAME)
+
+		rec := ts.Get(t, ts.Server, "/minecraft/profile/name/"+playerName+"/available", nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var response nameAvailabilityResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+
+		assert.Equal(t, "DUPLICATE", response.Status)
+	}
+}
+
+func (ts *TestSuite) testServicesNameAvailabilityCooldown(t *testing.T) {
+	accessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+
+	getStatus := func() string {
+		rec := ts.Get(t, ts.Server, "/minecraft/profile/name/NewName/available", nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response nameAvailabilityResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response.Status
+	}
+	{
+		// New users are within the cooldown
+		assert.Equal(t, "NOT_ALLOWED", getStatus())
+
+		rec := ts.Get(t, ts.Server, "/minecraft/profile/namechange", nil, &accessToken)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response nameChangeResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.False(t, response.NameChangeAllowed)
+	}
+	{
+		// Once the cooldown is over, the name is available
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		user.NameLastChangedAt = time.Now().AddDate(0, 0, -31)
+		assert.Nil(t, ts.App.DB.Save(&user).Error)
+		assert.Equal(t, "AVAILABLE", getStatus())
+	}
+	{
+		// Too many requests
+		assert.Equal(t, "AVAILABLE", getStatus())
+		rec := ts.Get(t, ts.Server, "/minecraft/profile/name/NewName/available", nil, &accessToken)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		var response changeNameErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, "TOO_MANY_REQUESTS", response.Error)
+	}
 }
 
 func (ts *TestSuite) testServicesPrivacyBlocklist(t *testing.T) {
@@ -481,3 +602,34 @@ func (ts *TestSuite) makeTestAccountPlayerNamesToIDs(url string) func(t *testing
 		assert.Equal(t, []playerNameToUUIDResponse{{Name: TEST_USERNAME, ID: id}}, response)
 	}
 }
+
+func (ts *TestSuite) testServicesAuthOnlyClient(t *testing.T) {
+	accessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+	client := ts.App.GetClient(accessToken, StalePolicyDeny)
+	assert.NotNil(t, client)
+	assert.Nil(t, ts.App.DB.Model(&Client{}).Where("uuid = ?", client.UUID).Update("auth_only", true).Error)
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	{
+		// Profile changes should be refused
+		req := httptest.NewRequest(http.MethodDelete, "/minecraft/profile/skins/active", nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+
+		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+		assert.NotNil(t, UnmakeNullString(&user.SkinHash))
+	}
+	{
+		// Reading the profile should still work
+		req := httptest.NewRequest(http.MethodGet, "/minecraft/profile", nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec := httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, nil))
+}
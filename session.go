@@ -1,12 +1,17 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 type sessionJoinRequest struct {
@@ -29,25 +34,69 @@ func SessionJoin(app *App) func(c echo.Context) error {
 			return c.JSONBlob(http.StatusForbidden, invalidAccessTokenBlob)
 		}
 
-		user := client.User
-
-		user.ServerID = MakeNullString(&req.ServerID)
-		result := app.DB.Save(&user)
-		if result.Error != nil {
-			return result.Error
+		if err := app.JoinServer(&client.User, req.ServerID, c.RealIP()); err != nil {
+			return err
 		}
 
 		return c.NoContent(http.StatusNoContent)
 	}
 }
 
-func fullProfile(app *App, user *User, uuid string, sign bool) (SessionProfileResponse, error) {
+// Record that user is joining the server with the given server ID, for
+// /session/minecraft/hasJoined to check. Shared with the legacy
+// /game/joinserver.jsp.
+func (app *App) JoinServer(user *User, serverID string, ip string) error {
+	user.ServerID = MakeNullString(&serverID)
+	user.JoinIP = MakeNullString(&ip)
+	user.JoinedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	if err := app.DB.Save(user).Error; err != nil {
+		return err
+	}
+	app.RecordJoin(user)
+	event := MakeEvent(EventJoin, user, ip)
+	event.ServerID = serverID
+	app.PublishEvent(event)
+	return nil
+}
+
+// The textures compatibility profile for a request: TexturesCompatibility.Profile,
+// unless the request asks for another with `compat`
+func getTexturesProfile(app *App, c echo.Context) (string, bool) {
+	texturesProfile := c.QueryParam("compat")
+	if texturesProfile == "" {
+		return app.Config().TexturesCompatibility.Profile, true
+	}
+	return texturesProfile, Contains(TEXTURES_PROFILES, texturesProfile)
+}
+
+// The player's IP address for the session history: the `ip` the server saw,
+// if it sent one, or else the address they joined from
+func sessionPlayerIP(c echo.Context, user *User) *string {
+	if ip := c.QueryParam("ip"); ip != "" {
+		return &ip
+	}
+	return UnmakeNullString(&user.JoinIP)
+}
+
+// Whether a request for a profile asks for its properties to be signed. Like
+// Mojang's session server, they're unsigned unless `unsigned` is false.
+func requestsSignedProfile(c echo.Context) bool {
+	unsigned, err := strconv.ParseBool(c.QueryParam("unsigned"))
+	return err == nil && !unsigned
+}
+
+func fullProfile(app *App, user *User, uuid string, sign bool, texturesProfile string) (SessionProfileResponse, error) {
 	id, err := UUIDToID(uuid)
 	if err != nil {
 		return SessionProfileResponse{}, err
 	}
 
-	texturesProperty, err := GetSkinTexturesProperty(app, user, sign)
+	texturesProperty, err := GetSkinTexturesProperty(app, user, sign, texturesProfile)
+	if err != nil {
+		return SessionProfileResponse{}, err
+	}
+
+	customProperties, err := GetCustomProfileProperties(app, user, sign)
 	if err != nil {
 		return SessionProfileResponse{}, err
 	}
@@ -55,7 +104,7 @@ func fullProfile(app *App, user *User, uuid string, sign bool) (SessionProfileRe
 	return SessionProfileResponse{
 		ID:         id,
 		Name:       user.PlayerName,
-		Properties: []SessionProfileProperty{texturesProperty},
+		Properties: append([]SessionProfileProperty{texturesProperty}, customProperties...),
 	}, nil
 }
 
@@ -65,11 +114,18 @@ func SessionHasJoined(app *App) func(c echo.Context) error {
 	return func(c echo.Context) error {
 		playerName := c.QueryParam("username")
 		serverID := c.QueryParam("serverId")
+		texturesProfile, ok := getTexturesProfile(app, c)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				ErrorMessage: Ptr("Unknown compatibility profile: " + texturesProfile),
+			})
+		}
 
+		ctx := c.Request().Context()
 		var user User
-		result := app.DB.First(&user, "player_name = ?", playerName)
+		result := app.DBWithContext(ctx).First(&user, "player_name = ?", playerName)
 		if result.Error != nil && !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			if app.Config.TransientUsers.Allow && app.TransientUsernameRegex.MatchString(playerName) {
+			if app.Config().TransientUsers.Allow && app.TransientUsernameRegex.MatchString(playerName) {
 				var err error
 				user, err = MakeTransientUser(app, playerName)
 				if err != nil {
@@ -81,7 +137,7 @@ func SessionHasJoined(app *App) func(c echo.Context) error {
 		}
 
 		if result.Error != nil || !user.ServerID.Valid || serverID != user.ServerID.String {
-			for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+			for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
 				if fallbackAPIServer.DenyUnknownUsers && result.Error != nil {
 					// If DenyUnknownUsers is enabled and the player name is
 					// not known, don't query the fallback server.
@@ -99,7 +155,14 @@ func SessionHasJoined(app *App) func(c echo.Context) error {
 				params.Add("serverId", serverID)
 				base.RawQuery = params.Encode()
 
-				res, err := MakeHTTPClient().Get(base.String())
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.String(), nil)
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				start := time.Now()
+				res, err := app.MakeHTTPClient().Do(req)
+				app.Metrics.ObserveFallbackLatency(fallbackAPIServer.Nickname, time.Since(start))
 				if err != nil {
 					log.Printf("Received invalid response from fallback API server at %s\n", base.String())
 					continue
@@ -107,6 +170,16 @@ func SessionHasJoined(app *App) func(c echo.Context) error {
 				defer res.Body.Close()
 
 				if res.StatusCode == http.StatusOK {
+					app.RecordFallbackUse(&fallbackAPIServer)
+					if fallbackAPIServer.ProxyTextures {
+						var profileRes SessionProfileResponse
+						if err := json.NewDecoder(res.Body).Decode(&profileRes); err != nil {
+							log.Printf("Received invalid response from fallback API server at %s\n", base.String())
+							continue
+						}
+						app.ProxyFallbackProfileTextures(&fallbackAPIServer, &profileRes)
+						return c.JSON(http.StatusOK, profileRes)
+					}
 					return c.Stream(http.StatusOK, res.Header.Get("Content-Type"), res.Body)
 				}
 			}
@@ -114,11 +187,15 @@ func SessionHasJoined(app *App) func(c echo.Context) error {
 			return c.NoContent(http.StatusForbidden)
 		}
 
-		profile, err := fullProfile(app, &user, user.UUID, true)
+		profile, err := fullProfile(app, &user, user.UUID, true, texturesProfile)
 		if err != nil {
 			return err
 		}
 
+		if err := app.RecordSession(&user, c.RealIP(), sessionPlayerIP(c, &user)); err != nil {
+			return err
+		}
+
 		return c.JSON(http.StatusOK, profile)
 	}
 }
@@ -134,6 +211,18 @@ func SessionProfile(app *App) func(c echo.Context) error {
 				ErrorMessage: Ptr("Not a valid UUID: " + c.Param("id")),
 			})
 		}
+		texturesProfile, ok := getTexturesProfile(app, c)
+		if !ok {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				ErrorMessage: Ptr("Unknown compatibility profile: " + texturesProfile),
+			})
+		}
+		sign := requestsSignedProfile(c)
+		if !sign && app.Config().RequireSignedProfiles {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				ErrorMessage: Ptr("Only signed profiles are served. Request the profile with unsigned=false."),
+			})
+		}
 
 		findUser := func() (*User, error) {
 			var user User
@@ -145,8 +234,22 @@ func SessionProfile(app *App) func(c echo.Context) error {
 				return nil, err
 			}
 
+			// Could be the Floodgate UUID of a linked Bedrock player
+			if app.Config().Floodgate.Enable && IsFloodgateUUID(uuid) {
+				xuid, err := FloodgateUUIDToXUID(uuid)
+				if err == nil {
+					linkedUser, _, err := app.FindUserByXUID(xuid)
+					if err != nil {
+						return nil, err
+					}
+					if linkedUser != nil {
+						return linkedUser, nil
+					}
+				}
+			}
+
 			// Could be an offline UUID
-			if app.Config.OfflineSkins {
+			if app.Config().OfflineSkins {
 				result = app.DB.First(&user, "offline_uuid = ?", uuid)
 				if result.Error == nil {
 					return &user, nil
@@ -165,27 +268,49 @@ func SessionProfile(app *App) func(c echo.Context) error {
 		}
 
 		if user == nil {
-			for _, fallbackAPIServer := range app.Config.FallbackAPIServers {
+			for _, fallbackAPIServer := range app.Config().EnabledFallbackAPIServers() {
 				reqURL, err := url.JoinPath(fallbackAPIServer.SessionURL, "session/minecraft/profile", id)
 				if err != nil {
 					log.Println(err)
 					continue
 				}
-				res, err := app.CachedGet(reqURL+"?unsigned=false", fallbackAPIServer.CacheTTLSeconds)
+				res, err := app.CachedGet(c.Request().Context(), &fallbackAPIServer, FallbackCacheProfile, reqURL+"?unsigned=false")
 				if err != nil {
 					log.Printf("Couldn't access fallback API server at %s: %s\n", reqURL, err)
 					continue
 				}
 
 				if res.StatusCode == http.StatusOK {
+					app.RecordFallbackUse(&fallbackAPIServer)
+					if fallbackAPIServer.ProxyTextures || !sign {
+						var profileRes SessionProfileResponse
+						if err := json.Unmarshal(res.BodyBytes, &profileRes); err != nil {
+							log.Printf("Received invalid response from fallback API server at %s\n", reqURL)
+							continue
+						}
+						if fallbackAPIServer.ProxyTextures {
+							app.ProxyFallbackProfileTextures(&fallbackAPIServer, &profileRes)
+						}
+						// The profile is always fetched signed, so it can be
+						// cached for both kinds of request
+						if !sign {
+							for i := range profileRes.Properties {
+								profileRes.Properties[i].Signature = nil
+							}
+						}
+						return c.JSON(http.StatusOK, profileRes)
+					}
 					return c.Blob(http.StatusOK, "application/json", res.BodyBytes)
 				}
 			}
 			return c.NoContent(http.StatusNoContent)
 		}
 
-		sign := c.QueryParam("unsigned") == "false"
-		profile, err := fullProfile(app, user, uuid, sign)
+		variant := fmt.Sprintf("%s %t %s", uuid, sign, texturesProfile)
+		if notModified, err := app.ProfileNotModified(c, user, variant); notModified || err != nil {
+			return err
+		}
+		profile, err := fullProfile(app, user, uuid, sign, texturesProfile)
 		if err != nil {
 			return err
 		}
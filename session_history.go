@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+)
+
+/*
+Optional history of the servers each player has joined. With
+SessionHistory.Enable, every successful hasJoined check records the time, a
+hash of the address of the server that made the check, and the player's IP
+address. Players see the servers they've joined recently on their profile
+page, and admins can look through a player's full history when investigating
+abuse. Records older than SessionHistory.RetentionDays are deleted.
+*/
+
+// How often old session records are deleted
+const SESSION_HISTORY_PRUNE_INTERVAL = time.Hour
+
+// How many servers the profile page lists as recent
+const RECENT_SERVERS_COUNT = 10
+
+// How many session records admins see
+const SESSION_HISTORY_COUNT = 100
+
+// Servers are told apart by a hash of their address, so the history doesn't
+// reveal where they are. Admins who know a server's address can still
+// recognize it.
+func HashServerAddress(address string) string {
+	sum := sha256.Sum256([]byte(address))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Record that a server at `serverAddress` verified `user`'s join. `ip` is
+// the player's address, if known.
+func (app *App) RecordSession(user *User, serverAddress string, ip *string) error {
+	if !app.Config().SessionHistory.Enable {
+		return nil
+	}
+	record := SessionRecord{
+		UserUUID:          user.UUID,
+		ServerAddressHash: HashServerAddress(serverAddress),
+		IP:                MakeNullString(ip),
+		CreatedAt:         time.Now(),
+	}
+	return app.DB.Create(&record).Error
+}
+
+// `user`'s sessions, newest first
+func (app *App) GetSessionHistory(user *User, limit int) ([]SessionRecord, error) {
+	var records []SessionRecord
+	err := app.DB.
+		Where("user_uuid = ?", user.UUID).
+		Order("created_at desc, id desc").
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
+
+type RecentServer struct {
+	ServerAddressHash string
+	LastJoinedAt      time.Time
+	Joins             int
+}
+
+// The servers `user` has joined, most recently joined first
+func (app *App) GetRecentServers(user *User) ([]RecentServer, error) {
+	var records []SessionRecord
+	err := app.DB.
+		Select("server_address_hash", "created_at").
+		Where("user_uuid = ?", user.UUID).
+		Order("created_at desc, id desc").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	servers := []RecentServer{}
+	indices := map[string]int{}
+	for _, record := range records {
+		if i, ok := indices[record.ServerAddressHash]; ok {
+			servers[i].Joins += 1
+			continue
+		}
+		indices[record.ServerAddressHash] = len(servers)
+		servers = append(servers, RecentServer{
+			ServerAddressHash: record.ServerAddressHash,
+			LastJoinedAt:      record.CreatedAt,
+			Joins:             1,
+		})
+	}
+	if len(servers) > RECENT_SERVERS_COUNT {
+		servers = servers[:RECENT_SERVERS_COUNT]
+	}
+	return servers, nil
+}
+
+// Delete the session records older than SessionHistory.RetentionDays
+func (app *App) PruneSessionHistory(now time.Time) error {
+	cutoff := now.Add(-time.Duration(app.Config().SessionHistory.RetentionDays) * 24 * time.Hour)
+	return app.DB.Where("created_at < ?", cutoff).Delete(&SessionRecord{}).Error
+}
+
+func (app *App) RunSessionHistoryPruning() {
+	for {
+		if err := app.PruneSessionHistory(time.Now()); err != nil {
+			log.Printf("Couldn't prune session history: %s\n", err)
+		}
+		time.Sleep(SESSION_HISTORY_PRUNE_INTERVAL)
+	}
+}
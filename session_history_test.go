@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionHistory(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		config.SessionHistory.Enable = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test session history", ts.testSessionHistory)
+	}
+	{
+		ts := &TestSuite{}
+
+		keyDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+		defer os.RemoveAll(keyDirectory)
+
+		config := testConfig()
+		config.SessionHistory.Enable = true
+		config.DataEncryption.KeyFile = writeTestDataKey(keyDirectory, "data.key")
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test session history with data encryption", ts.testSessionHistoryDataEncryption)
+	}
+}
+
+func (ts *TestSuite) testSessionHistoryDataEncryption(t *testing.T) {
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	serverID := "0000000000000000000000000000000000000000"
+	assert.Nil(t, ts.App.JoinServer(&user, serverID, "203.0.113.7"))
+
+	// Queries made with a request's context still decrypt
+	var joinedUser User
+	assert.Nil(t, ts.App.DBWithContext(context.Background()).First(&joinedUser, "uuid = ?", user.UUID).Error)
+	assert.Equal(t, "203.0.113.7", joinedUser.JoinIP.String)
+
+	// Without an `ip`, the address the player joined from is recorded
+	url := "/session/minecraft/hasJoined?username=" + user.PlayerName + "&serverId=" + serverID
+	assert.Equal(t, http.StatusOK, ts.Get(t, ts.Server, url, nil, nil).Code)
+
+	var rawIP string
+	assert.Nil(t, ts.App.DB.Raw("SELECT ip FROM session_records WHERE user_uuid = ?", user.UUID).Scan(&rawIP).Error)
+	assert.True(t, strings.HasPrefix(rawIP, ENCRYPTED_VALUE_PREFIX))
+	records, err := ts.App.GetSessionHistory(&user, SESSION_HISTORY_COUNT)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "203.0.113.7", records[0].IP.String)
+}
+
+func (ts *TestSuite) testSessionHistory(t *testing.T) {
+	ts.CreateTestUser(ts.Server, "admin")
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	serverID := "0000000000000000000000000000000000000000"
+	hasJoined := func(serverID string) int {
+		url := "/session/minecraft/hasJoined?username=" + user.PlayerName + "&serverId=" + serverID + "&ip=203.0.113.7"
+		return ts.Get(t, ts.Server, url, nil, nil).Code
+	}
+	assert.Nil(t, ts.App.JoinServer(&user, serverID, "203.0.113.7"))
+	assert.Equal(t, http.StatusOK, hasJoined(serverID))
+	assert.Equal(t, http.StatusOK, hasJoined(serverID))
+
+	// Failed checks aren't recorded
+	assert.Equal(t, http.StatusForbidden, hasJoined("invalid"))
+
+	records, err := ts.App.GetSessionHistory(&user, SESSION_HISTORY_COUNT)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	// httptest requests come from 192.0.2.1
+	assert.Equal(t, HashServerAddress("192.0.2.1"), records[0].ServerAddressHash)
+	assert.Equal(t, "203.0.113.7", records[0].IP.String)
+
+	assert.Nil(t, ts.App.RecordSession(&user, "198.51.100.1", nil))
+	recentServers, err := ts.App.GetRecentServers(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(recentServers))
+	assert.Equal(t, HashServerAddress("198.51.100.1"), recentServers[0].ServerAddressHash)
+	assert.Equal(t, 2, recentServers[1].Joins)
+
+	rec := ts.Get(t, ts.Server, "/drasl/profile", []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), HashServerAddress("192.0.2.1"))
+	assert.NotContains(t, rec.Body.String(), "203.0.113.7")
+
+	// Admins see the player's IP addresses
+	adminAccessToken := ts.authenticate(t, "admin", TEST_PASSWORD).AccessToken
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/users/"+user.UUID+"/sessions", nil, &adminAccessToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var res []apiSessionRecord
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&res))
+	assert.Equal(t, 3, len(res))
+	assert.Nil(t, res[0].IP)
+	assert.Equal(t, "203.0.113.7", *res[1].IP)
+
+	userAccessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+	rec = ts.Get(t, ts.Server, "/drasl/api/v1/admin/users/"+user.UUID+"/sessions", nil, &userAccessToken)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Old records are pruned
+	assert.Nil(t, ts.App.PruneSessionHistory(time.Now().Add(time.Duration(ts.App.Config().SessionHistory.RetentionDays)*24*time.Hour+time.Minute)))
+	records, err = ts.App.GetSessionHistory(&user, SESSION_HISTORY_COUNT)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
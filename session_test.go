@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -22,6 +29,36 @@ func TestSession(t *testing.T) {
 		t.Run("Test /session/minecraft/profile/:id", ts.testSessionProfile)
 		t.Run("Test /blockedservers", ts.testSessionBlockedServers)
 	}
+	{
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		fallback := ts.ToFallbackAPIServer(ts.AuxApp, "Aux")
+		fallback.SkinDomains = []string{"localhost"}
+		fallback.ProxyTextures = true
+		config.FallbackAPIServers = []FallbackAPIServer{fallback}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.AuxServer, TEST_USERNAME)
+
+		t.Run("Test /session/minecraft/profile/:id, proxied fallback textures", ts.testSessionProfileProxyFallbackTextures)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.RequireSignedProfiles = true
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+		t.Run("Test /session/minecraft/profile/:id, RequireSignedProfiles", ts.testSessionProfileRequireSigned)
+	}
 }
 
 func (ts *TestSuite) testSessionJoin(t *testing.T) {
@@ -157,9 +194,104 @@ func (ts *TestSuite) testSessionProfile(t *testing.T) {
 		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
 		assert.Equal(t, "Not a valid UUID: "+"invalid", *response.ErrorMessage)
 	}
+	{
+		// The modern profile only signs the textures when asked to
+		url := "/session/minecraft/profile/" + Unwrap(UUIDToID(user.UUID))
+		rec := ts.Get(t, ts.Server, url, nil, nil)
+		var response SessionProfileResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Nil(t, response.Properties[0].Signature)
+
+		rec = ts.Get(t, ts.Server, url+"?unsigned=true", nil, nil)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Nil(t, response.Properties[0].Signature)
+
+		// `unsigned` is parsed like any other boolean
+		rec = ts.Get(t, ts.Server, url+"?unsigned=FALSE", nil, nil)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.NotNil(t, response.Properties[0].Signature)
+
+		// The legacy profile always signs them, and timestamps them in
+		// milliseconds
+		rec = ts.Get(t, ts.Server, url+"?compat=legacy", nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.NotNil(t, response.Properties[0].Signature)
+		valueJSON := Unwrap(base64.StdEncoding.DecodeString(response.Properties[0].Value))
+		assert.True(t, strings.Contains(string(valueJSON), `"isPublic":true,"textures"`))
+		var value legacyTexturesValue
+		assert.Nil(t, json.Unmarshal(valueJSON, &value))
+		assert.Less(t, value.Timestamp, int64(1e14))
+
+		rec = ts.Get(t, ts.Server, url+"?compat=ancient", nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
 }
 
 func (ts *TestSuite) testSessionBlockedServers(t *testing.T) {
 	rec := ts.Get(t, ts.Server, "/blockedservers", nil, nil)
 	assert.Equal(t, http.StatusOK, rec.Code)
 }
+
+func (ts *TestSuite) testSessionProfileProxyFallbackTextures(t *testing.T) {
+	var auxUser User
+	assert.Nil(t, ts.AuxApp.DB.First(&auxUser, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, SetSkinAndSave(ts.AuxApp, &auxUser, bytes.NewReader(BLUE_SKIN)))
+
+	url := "/session/minecraft/profile/" + Unwrap(UUIDToID(auxUser.UUID)) + "?unsigned=false"
+	rec := ts.Get(t, ts.Server, url, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response SessionProfileResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.Equal(t, 1, len(response.Properties))
+	property := response.Properties[0]
+	assert.Equal(t, "textures", property.Name)
+
+	// The property should be re-signed with our own key
+	assert.NotNil(t, property.Signature)
+	signature := Unwrap(base64.StdEncoding.DecodeString(*property.Signature))
+	sum := sha1.Sum([]byte(property.Value))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&ts.App.Key.PublicKey, crypto.SHA1, sum[:], signature))
+
+	var value texturesValue
+	assert.Nil(t, json.Unmarshal(Unwrap(base64.StdEncoding.DecodeString(property.Value)), &value))
+	skinURL := value.Textures.Skin.URL
+	fallbackPrefix := Unwrap(FallbackTextureURL(ts.App, ""))
+	assert.True(t, strings.HasPrefix(skinURL, fallbackPrefix))
+
+	// The skin should have been downloaded from the fallback API server
+	auxSkin := Unwrap(os.ReadFile(GetSkinPath(ts.AuxApp, *UnmakeNullString(&auxUser.SkinHash))))
+	filename := strings.TrimPrefix(skinURL, fallbackPrefix)
+	assert.Equal(t, auxSkin, Unwrap(os.ReadFile(GetFallbackTexturePath(ts.App, filename))))
+	rec = ts.Get(t, ts.Server, "/drasl/texture/fallback/"+filename, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, auxSkin, rec.Body.Bytes())
+
+	// Unless asked for, the signature is left out
+	rec = ts.Get(t, ts.Server, strings.TrimSuffix(url, "?unsigned=false"), nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var unsignedResponse SessionProfileResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&unsignedResponse))
+	assert.Nil(t, unsignedResponse.Properties[0].Signature)
+}
+
+func (ts *TestSuite) testSessionProfileRequireSigned(t *testing.T) {
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	url := "/session/minecraft/profile/" + Unwrap(UUIDToID(user.UUID))
+
+	for _, query := range []string{"", "?unsigned=true", "?unsigned=maybe"} {
+		rec := ts.Get(t, ts.Server, url+query, nil, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		var response ErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, "Only signed profiles are served. Request the profile with unsigned=false.", *response.ErrorMessage)
+	}
+
+	rec := ts.Get(t, ts.Server, url+"?unsigned=false", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response SessionProfileResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+	assert.NotNil(t, response.Properties[0].Signature)
+}
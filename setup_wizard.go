@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+`drasl setup` asks for the handful of settings every instance needs, writes
+them to a new config file, and creates the first admin account. It's also run
+when Drasl starts without a config file from an interactive terminal;
+otherwise, e.g. under systemd or Docker, Drasl writes TEMPLATE_CONFIG_FILE as
+before.
+*/
+
+const SETUP_GENERATED_PASSWORD_LENGTH = 16
+
+type registrationPolicy struct {
+	Name        string
+	Description string
+	Allow       bool
+	Invite      bool
+	Approval    bool
+}
+
+var REGISTRATION_POLICIES = []registrationPolicy{
+	{Name: "invite", Description: "New players need an invite from an admin", Allow: true, Invite: true},
+	{Name: "approval", Description: "Anyone can register, but an admin must approve each account", Allow: true, Approval: true},
+	{Name: "open", Description: "Anyone can register", Allow: true},
+	{Name: "closed", Description: "Nobody can register", Allow: false},
+}
+
+// The settings asked for, in the shape of Config so they encode the same way
+type setupConfigFile struct {
+	Domain                string
+	BaseURL               string
+	DefaultAdmins         []string
+	RegistrationNewPlayer struct {
+		Allow             bool
+		AllowChoosingUUID bool
+		RequireInvite     bool
+		RequireApproval   bool
+	}
+}
+
+type setupPrompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// Ask a question. An empty answer means defaultAnswer.
+func (prompter *setupPrompter) ask(question string, defaultAnswer string) (string, error) {
+	if defaultAnswer != "" {
+		fmt.Fprintf(prompter.out, "%s [%s]: ", question, defaultAnswer)
+	} else {
+		fmt.Fprintf(prompter.out, "%s: ", question)
+	}
+	line, err := prompter.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	answer := strings.TrimSpace(line)
+	if answer == "" {
+		return defaultAnswer, nil
+	}
+	return answer, nil
+}
+
+// Ask until validate accepts the answer
+func (prompter *setupPrompter) askValid(question string, defaultAnswer string, validate func(string) error) (string, error) {
+	for {
+		answer, err := prompter.ask(question, defaultAnswer)
+		if err != nil {
+			return "", err
+		}
+		if err := validate(answer); err != nil {
+			fmt.Fprintf(prompter.out, "%s\n", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+func validateSetupDomain(domain string) error {
+	if domain == "" || strings.ContainsAny(domain, "/: ") {
+		return errors.New("Enter a domain name like drasl.example.com.")
+	}
+	return nil
+}
+
+func validateSetupBaseURL(baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("Enter a URL like https://drasl.example.com.")
+	}
+	return nil
+}
+
+func (prompter *setupPrompter) askRegistrationPolicy() (*registrationPolicy, error) {
+	fmt.Fprintln(prompter.out, "Who can register?")
+	names := make([]string, 0, len(REGISTRATION_POLICIES))
+	for _, policy := range REGISTRATION_POLICIES {
+		fmt.Fprintf(prompter.out, "  %-9s %s\n", policy.Name, policy.Description)
+		names = append(names, policy.Name)
+	}
+	var chosen *registrationPolicy
+	_, err := prompter.askValid("Registration policy", REGISTRATION_POLICIES[0].Name, func(answer string) error {
+		for i := range REGISTRATION_POLICIES {
+			if REGISTRATION_POLICIES[i].Name == strings.ToLower(answer) {
+				chosen = &REGISTRATION_POLICIES[i]
+				return nil
+			}
+		}
+		return fmt.Errorf("Enter one of %s.", strings.Join(names, ", "))
+	})
+	return chosen, err
+}
+
+func writeSetupConfigFile(configPath string, configFile *setupConfigFile) error {
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(configPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString("# Drasl config file, written by `drasl setup`.\n# See https://github.com/unmojang/drasl/blob/master/doc/configuration.md for\n# every option.\n\n"); err != nil {
+		return err
+	}
+	encoder := toml.NewEncoder(f)
+	encoder.Indent = ""
+	return encoder.Encode(configFile)
+}
+
+// Create the admin account in the instance described by config. Returns a
+// *RegistrationError if the username or password isn't acceptable.
+func createSetupAdmin(config *Config, username string, password string) error {
+	app := setup(config)
+	defer func() {
+		if sqlDB, err := app.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+	_, err := app.RegisterUser(&RegistrationRequest{
+		Username:   username,
+		Password:   password,
+		SkipPolicy: true,
+	})
+	return err
+}
+
+// Ask for the basic settings, write them to configPath, and create the admin
+// account
+func SetupWizard(in io.Reader, out io.Writer, configPath string) error {
+	prompter := setupPrompter{in: bufio.NewReader(in), out: out}
+
+	if _, err := os.Stat(configPath); err == nil {
+		overwrite, err := prompter.ask(fmt.Sprintf("%s already exists. Overwrite it? (y/N)", configPath), "")
+		if err != nil {
+			return err
+		}
+		if strings.ToLower(overwrite) != "y" {
+			return fmt.Errorf("Not overwriting %s", configPath)
+		}
+	}
+
+	fmt.Fprintf(out, "Setting up a new Drasl instance. The config will be written to %s.\n", configPath)
+
+	configFile := setupConfigFile{}
+	var err error
+	configFile.Domain, err = prompter.askValid("Domain, e.g. drasl.example.com", "", validateSetupDomain)
+	if err != nil {
+		return err
+	}
+	configFile.BaseURL, err = prompter.askValid("Base URL", "https://"+configFile.Domain, validateSetupBaseURL)
+	if err != nil {
+		return err
+	}
+	configFile.BaseURL = strings.TrimRight(configFile.BaseURL, "/")
+	policy, err := prompter.askRegistrationPolicy()
+	if err != nil {
+		return err
+	}
+	configFile.RegistrationNewPlayer.Allow = policy.Allow
+	configFile.RegistrationNewPlayer.AllowChoosingUUID = policy.Allow
+	configFile.RegistrationNewPlayer.RequireInvite = policy.Invite
+	configFile.RegistrationNewPlayer.RequireApproval = policy.Approval
+
+	for {
+		username, err := prompter.ask("Admin username", "")
+		if err != nil {
+			return err
+		}
+		password, err := prompter.ask("Admin password (leave empty to generate one)", "")
+		if err != nil {
+			return err
+		}
+		generated := password == ""
+		if generated {
+			password, err = RandomBase62(SETUP_GENERATED_PASSWORD_LENGTH)
+			if err != nil {
+				return err
+			}
+		}
+
+		configFile.DefaultAdmins = []string{username}
+		if err := writeSetupConfigFile(configPath, &configFile); err != nil {
+			return err
+		}
+		config, err := ReadConfig(configPath)
+		if err != nil {
+			return err
+		}
+		err = createSetupAdmin(config, username, password)
+		var registrationError *RegistrationError
+		if errors.As(err, &registrationError) {
+			fmt.Fprintf(out, "%s\n", registrationError.Message)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Wrote %s and created the admin account %s.\n", configPath, username)
+		if generated {
+			fmt.Fprintf(out, "Its password is %s. You can change it on your profile page.\n", password)
+		}
+		return nil
+	}
+}
+
+// Whether f is a terminal someone can answer questions on
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSetupWizard(t *testing.T) {
+	stateDirectory := Unwrap(os.MkdirTemp("", "tmp"))
+	defer os.RemoveAll(stateDirectory)
+
+	defaultDirectories := Directories{Config: Constants.ConfigDirectory, Data: Constants.DataDirectory, State: Constants.StateDirectory}
+	Constants.SetDirectories(Directories{Config: stateDirectory, Data: ".", State: stateDirectory})
+	defer Constants.SetDirectories(defaultDirectories)
+
+	configPath := path.Join(stateDirectory, "config.toml")
+	input := strings.Join([]string{
+		"https://drasl.example.com", // Not a domain
+		"drasl.example.com",
+		"", // Default BaseURL
+		"everyone",
+		"approval",
+		"admin",
+		"short", // Too short
+		"admin",
+		"", // Generate a password
+	}, "\n") + "\n"
+	var out bytes.Buffer
+	assert.Nil(t, SetupWizard(strings.NewReader(input), &out, configPath))
+	assert.Contains(t, out.String(), "Enter a domain name")
+	assert.Contains(t, out.String(), "Enter one of invite, approval, open, closed.")
+	assert.Contains(t, out.String(), "Invalid password")
+	assert.Contains(t, out.String(), "created the admin account admin")
+
+	config := Unwrap(ReadConfig(configPath))
+	assert.Equal(t, "drasl.example.com", config.Domain)
+	assert.Equal(t, "https://drasl.example.com", config.BaseURL)
+	assert.Equal(t, []string{"admin"}, config.DefaultAdmins)
+	assert.True(t, config.RegistrationNewPlayer.Allow)
+	assert.False(t, config.RegistrationNewPlayer.RequireInvite)
+	assert.True(t, config.RegistrationNewPlayer.RequireApproval)
+
+	// The admin is neither held for approval nor asked for an invite
+	db := Unwrap(OpenDB(config))
+	var user User
+	assert.Nil(t, db.First(&user, "username = ?", "admin").Error)
+	assert.True(t, user.IsAdmin)
+	assert.False(t, user.IsPendingApproval)
+
+	// An existing config isn't overwritten without asking
+	err := SetupWizard(strings.NewReader("\n"), &out, configPath)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*
+The signing key signs textures, player certificates, and forwarded profiles,
+and secrets like skin verification challenges are derived from it. It lives
+at StateDirectory/key.pkcs8 unless SigningKeyFile points somewhere else, e.g.
+to a secret managed by NixOS or systemd's LoadCredential. The `drasl key`
+commands generate, inspect, and rotate it.
+*/
+
+const SIGNING_KEY_BITS = 4096
+
+func SigningKeyPath(config *Config) string {
+	if config.SigningKeyFile != "" {
+		return config.SigningKeyFile
+	}
+	return path.Join(config.StateDirectory, "key.pkcs8")
+}
+
+func GenerateSigningKey() (*rsa.PrivateKey, error) {
+	return rsa.GenerateKey(rand.Reader, SIGNING_KEY_BITS)
+}
+
+func ReadSigningKey(keyPath string) (*rsa.PrivateKey, error) {
+	der, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s isn't a PKCS #8 private key: %w", keyPath, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't an RSA key", keyPath)
+	}
+	return rsaKey, nil
+}
+
+// Write `key` to `keyPath`, replacing any key already there only once the
+// new one is completely written
+func WriteSigningKey(keyPath string, key *rsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(keyPath), ".key-*.pkcs8")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(der); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), keyPath)
+}
+
+// Replace the key at `keyPath` with a new one. The old key is kept at
+// `keyPath`.old.
+func RotateSigningKey(keyPath string) (*rsa.PrivateKey, error) {
+	oldKey, err := ReadSigningKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteSigningKey(keyPath+".old", oldKey); err != nil {
+		return nil, err
+	}
+	key, err := GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteSigningKey(keyPath, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func ReadOrCreateKey(config *Config) (*rsa.PrivateKey, error) {
+	keyPath := SigningKeyPath(config)
+	key, err := ReadSigningKey(keyPath)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	// A key supplied from outside Drasl should be there already
+	if config.SigningKeyFile != "" {
+		return nil, fmt.Errorf("SigningKeyFile %s doesn't exist. Create it with `drasl key generate %s`.", keyPath, keyPath)
+	}
+	key, err = GenerateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteSigningKey(keyPath, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSigningKey(t *testing.T) {
+	stateDirectory := t.TempDir()
+	config := testConfig()
+	config.StateDirectory = stateDirectory
+
+	// Generated in StateDirectory by default
+	key, err := ReadOrCreateKey(config)
+	assert.Nil(t, err)
+	assert.Equal(t, path.Join(stateDirectory, "key.pkcs8"), SigningKeyPath(config))
+	readKey, err := ReadOrCreateKey(config)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(readKey))
+
+	// A SigningKeyFile has to exist already
+	keyPath := path.Join(stateDirectory, "secret.pkcs8")
+	config.SigningKeyFile = keyPath
+	_, err = ReadOrCreateKey(config)
+	assert.NotNil(t, err)
+	_, err = os.Stat(keyPath)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, WriteSigningKey(keyPath, key))
+	readKey, err = ReadOrCreateKey(config)
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(readKey))
+
+	rotatedKey, err := RotateSigningKey(keyPath)
+	assert.Nil(t, err)
+	assert.False(t, key.Equal(rotatedKey))
+	readKey, err = ReadSigningKey(keyPath)
+	assert.Nil(t, err)
+	assert.True(t, rotatedKey.Equal(readKey))
+	oldKey, err := ReadSigningKey(keyPath + ".old")
+	assert.Nil(t, err)
+	assert.True(t, key.Equal(oldKey))
+
+	assert.Nil(t, os.WriteFile(keyPath, []byte("not a key"), 0600))
+	_, err = ReadOrCreateKey(config)
+	assert.NotNil(t, err)
+}
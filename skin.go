@@ -0,0 +1,110 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+)
+
+// A region of a skin copied to another, mirrored horizontally, in units of
+// a 64-pixel-wide skin
+type skinRegionCopy struct {
+	FromX, FromY  int
+	Width, Height int
+	ToX, ToY      int
+}
+
+// Skins from before Minecraft 1.8 are 64 × 32 and have only one arm and one
+// leg, which are used for both sides. The 64 × 64 layout has room for the
+// left arm and leg below, which the client fills with the mirrored right arm
+// and leg when loading a legacy skin. These are the same copies, face by face.
+var LEGACY_SKIN_REGION_COPIES = []skinRegionCopy{
+	// Leg
+	{FromX: 4, FromY: 16, Width: 4, Height: 4, ToX: 20, ToY: 48},
+	{FromX: 8, FromY: 16, Width: 4, Height: 4, ToX: 24, ToY: 48},
+	{FromX: 0, FromY: 20, Width: 4, Height: 12, ToX: 24, ToY: 52},
+	{FromX: 4, FromY: 20, Width: 4, Height: 12, ToX: 20, ToY: 52},
+	{FromX: 8, FromY: 20, Width: 4, Height: 12, ToX: 16, ToY: 52},
+	{FromX: 12, FromY: 20, Width: 4, Height: 12, ToX: 28, ToY: 52},
+	// Arm
+	{FromX: 44, FromY: 16, Width: 4, Height: 4, ToX: 36, ToY: 48},
+	{FromX: 48, FromY: 16, Width: 4, Height: 4, ToX: 40, ToY: 48},
+	{FromX: 40, FromY: 20, Width: 4, Height: 12, ToX: 40, ToY: 52},
+	{FromX: 44, FromY: 20, Width: 4, Height: 12, ToX: 36, ToY: 52},
+	{FromX: 48, FromY: 20, Width: 4, Height: 12, ToX: 32, ToY: 52},
+	{FromX: 52, FromY: 20, Width: 4, Height: 12, ToX: 44, ToY: 52},
+}
+
+// Convert a legacy 64 × 32 skin, or a high-resolution one with the same
+// proportions, to the 64 × 64 layout
+func ConvertLegacySkin(legacy image.Image) *image.NRGBA {
+	bounds := legacy.Bounds()
+	width := bounds.Dx()
+	scale := width / 64
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, width))
+	draw.Draw(img, image.Rect(0, 0, width, width/2), legacy, bounds.Min, draw.Src)
+
+	for _, region := range LEGACY_SKIN_REGION_COPIES {
+		for y := 0; y < region.Height*scale; y += 1 {
+			for x := 0; x < region.Width*scale; x += 1 {
+				from := img.NRGBAAt(region.FromX*scale+x, region.FromY*scale+y)
+				img.SetNRGBA(region.ToX*scale+region.Width*scale-1-x, region.ToY*scale+y, from)
+			}
+		}
+	}
+	return img
+}
+
+// Regions of a 64-pixel-wide skin that slim skins, with 3-pixel-wide arms,
+// leave unused. The left arm's are only in 64 × 64 skins.
+var SLIM_SKIN_UNUSED_REGIONS = []image.Rectangle{
+	image.Rect(50, 16, 52, 20),
+	image.Rect(54, 20, 56, 32),
+	image.Rect(42, 48, 44, 52),
+	image.Rect(46, 52, 48, 64),
+}
+
+// Guess whether a skin is slim or classic from whether the columns that only
+// classic skins use are empty: transparent, or, as some skin editors leave
+// them, solid black or white
+func DetectSkinModel(img image.Image) string {
+	bounds := img.Bounds()
+	scale := bounds.Dx() / 64
+	if scale == 0 {
+		return SkinModelClassic
+	}
+
+	var first color.NRGBA
+	for i, region := range SLIM_SKIN_UNUSED_REGIONS {
+		region = image.Rect(region.Min.X*scale, region.Min.Y*scale, region.Max.X*scale, region.Max.Y*scale).Add(bounds.Min)
+		if !region.In(bounds) {
+			continue
+		}
+		for y := region.Min.Y; y < region.Max.Y; y += 1 {
+			for x := region.Min.X; x < region.Max.X; x += 1 {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if i == 0 && y == region.Min.Y && x == region.Min.X {
+					first = c
+				}
+				isEmpty := c.A == 0 ||
+					(c == first && (c == color.NRGBA{A: 255} || c == color.NRGBA{R: 255, G: 255, B: 255, A: 255}))
+				if !isEmpty {
+					return SkinModelClassic
+				}
+			}
+		}
+	}
+	return SkinModelSlim
+}
+
+// Like DetectSkinModel, for a PNG
+func DetectSkinModelPNG(reader io.Reader) (string, error) {
+	img, err := png.Decode(reader)
+	if err != nil {
+		return "", err
+	}
+	return DetectSkinModel(img), nil
+}
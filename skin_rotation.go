@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+/*
+Users can save skins to a library on their profile page and have Drasl change
+their skin on a schedule: to a different library skin every day, and to a
+particular skin on particular dates every year, e.g. a holiday skin. The
+schedule is applied at most once a day per user, so a skin the user sets by
+hand stays until the next day's change. Library skins are stored like any
+other skin and keep their file around until they're deleted.
+
+Signed profiles aren't cached: the textures property is built and signed when
+it's requested, so a rotated skin shows up the next time a client or server
+asks for the profile.
+*/
+
+// How often RunSkinRotation checks for skins due to be changed
+const SKIN_ROTATION_INTERVAL = time.Minute
+
+// Dates of library skins are a month and day, worn every year
+const LIBRARY_SKIN_DATE_FORMAT = "01-02"
+
+const SKIN_ROTATION_DAY_FORMAT = "2006-01-02"
+
+const MAX_LIBRARY_SKIN_NAME_LENGTH = 64
+
+var errLibraryFull = errors.New("skin library is full")
+var errNoSkin = errors.New("no skin")
+var errInvalidLibrarySkinDate = errors.New("invalid date")
+
+// Save the user's current skin to their library. `date`, if not empty, is a
+// month and day like "12-25" on which the skin is worn.
+func (app *App) SaveLibrarySkin(user *User, name string, date string) (*LibrarySkin, error) {
+	if !user.SkinHash.Valid {
+		return nil, errNoSkin
+	}
+	if date != "" {
+		if _, err := time.Parse(LIBRARY_SKIN_DATE_FORMAT, date); err != nil {
+			return nil, errInvalidLibrarySkinDate
+		}
+	}
+
+	var count int64
+	if err := app.DB.Model(&LibrarySkin{}).Where("user_uuid = ?", user.UUID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= int64(app.Config().SkinRotation.MaxLibrarySkins) {
+		return nil, errLibraryFull
+	}
+
+	librarySkin := LibrarySkin{
+		ID:        uuid.New().String(),
+		UserUUID:  user.UUID,
+		Name:      name,
+		SkinHash:  user.SkinHash.String,
+		SkinModel: user.SkinModel,
+		Date:      date,
+		CreatedAt: time.Now(),
+	}
+	if err := app.DB.Create(&librarySkin).Error; err != nil {
+		return nil, err
+	}
+	return &librarySkin, nil
+}
+
+// Delete one of the user's library skins. Returns gorm.ErrRecordNotFound if
+// they have no library skin with that ID.
+func (app *App) DeleteLibrarySkin(user *User, id string) error {
+	var librarySkin LibrarySkin
+	if err := app.DB.First(&librarySkin, "id = ? AND user_uuid = ?", id, user.UUID).Error; err != nil {
+		return err
+	}
+	if err := app.DB.Delete(&librarySkin).Error; err != nil {
+		return err
+	}
+	return DeleteSkinIfUnused(app, &librarySkin.SkinHash)
+}
+
+func (app *App) GetLibrarySkins(user *User) ([]LibrarySkin, error) {
+	var librarySkins []LibrarySkin
+	err := app.DB.Order("created_at").Find(&librarySkins, "user_uuid = ?", user.UUID).Error
+	return librarySkins, err
+}
+
+// The library skin the user should wear on `day`, or nil if their schedule
+// doesn't call for one. librarySkins must be in library order.
+func scheduledLibrarySkin(user *User, librarySkins []LibrarySkin, day time.Time) *LibrarySkin {
+	date := day.Format(LIBRARY_SKIN_DATE_FORMAT)
+	for i := range librarySkins {
+		if librarySkins[i].Date == date {
+			return &librarySkins[i]
+		}
+	}
+	if !user.RotateSkinsDaily || len(librarySkins) == 0 {
+		return nil
+	}
+	// Days since the epoch, counting from local midnight
+	dayNumber := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+	return &librarySkins[dayNumber%int64(len(librarySkins))]
+}
+
+// Change the skin of every user whose schedule calls for a library skin on
+// the day of `now` and who hasn't had their skin changed yet that day
+func (app *App) RotateSkins(now time.Time) error {
+	day := now.Format(SKIN_ROTATION_DAY_FORMAT)
+	date := now.Format(LIBRARY_SKIN_DATE_FORMAT)
+
+	var users []User
+	err := app.DB.Where("skin_rotated_on IS NULL OR skin_rotated_on <> ?", day).
+		Where("NOT skin_locked").
+		Where("rotate_skins_daily OR uuid IN (?)", app.DB.Model(&LibrarySkin{}).Select("user_uuid").Where("date = ?", date)).
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		librarySkins, err := app.GetLibrarySkins(&user)
+		if err != nil {
+			return err
+		}
+		librarySkin := scheduledLibrarySkin(&user, librarySkins, now)
+		if librarySkin == nil {
+			continue
+		}
+
+		oldSkinHash := UnmakeNullString(&user.SkinHash)
+		err = app.DB.Model(&user).Updates(map[string]interface{}{
+			"skin_hash":       librarySkin.SkinHash,
+			"skin_model":      librarySkin.SkinModel,
+			"skin_rotated_on": day,
+		}).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.RecordAppearance(user.UUID); err != nil {
+			return err
+		}
+		if err := DeleteSkinIfUnused(app, oldSkinHash); err != nil {
+			log.Printf("Couldn't delete old skin of user %s: %s\n", user.UUID, err)
+		}
+	}
+	return nil
+}
+
+// Run RotateSkins every SKIN_ROTATION_INTERVAL
+func (app *App) RunSkinRotation() {
+	for {
+		if err := app.RotateSkins(time.Now()); err != nil {
+			log.Printf("Couldn't rotate skins: %s\n", err)
+		}
+		time.Sleep(SKIN_ROTATION_INTERVAL)
+	}
+}
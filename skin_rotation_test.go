@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSkinRotation(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.SkinRotation.Allow = true
+		config.SkinRotation.MaxLibrarySkins = 2
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test skin rotation", ts.testSkinRotation)
+	}
+}
+
+func (ts *TestSuite) testSkinRotation(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+
+	saveSkin := func(name string, date string) {
+		form := url.Values{}
+		form.Set("name", name)
+		form.Set("date", date)
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		rec := ts.PostForm(t, ts.Server, "/drasl/save-library-skin", form, []http.Cookie{*browserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+	}
+
+	// Save a red skin for Christmas and a blue one for every other day
+	user.SkinModel = SkinModelSlim
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(RED_SKIN)))
+	redHash := user.SkinHash.String
+	saveSkin("Christmas", "12-25")
+	user.SkinModel = SkinModelClassic
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, bytes.NewReader(BLUE_SKIN)))
+	blueHash := user.SkinHash.String
+	saveSkin("Everyday", "")
+
+	// Library skins are kept even when no one is wearing them
+	_, err := os.Stat(GetSkinPath(ts.App, redHash))
+	assert.Nil(t, err)
+
+	form := url.Values{}
+	form.Set("name", "One too many")
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec := ts.PostForm(t, ts.Server, "/drasl/save-library-skin", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, "You can't save more than 2 skins.", getErrorMessage(rec))
+
+	christmas := time.Date(2030, time.December, 25, 12, 0, 0, 0, time.Local)
+	assert.Nil(t, ts.App.RotateSkins(christmas))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, redHash, user.SkinHash.String)
+	assert.Equal(t, SkinModelSlim, user.SkinModel)
+
+	// The schedule changes the skin at most once a day, so a skin set by
+	// hand stays until the next day
+	assert.Nil(t, SetSkinAndSave(ts.App, &user, nil))
+	assert.Nil(t, ts.App.RotateSkins(christmas.Add(time.Hour)))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.False(t, user.SkinHash.Valid)
+
+	// Without daily rotation, nothing changes on other days
+	assert.Nil(t, ts.App.RotateSkins(christmas.AddDate(0, 0, 1)))
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.False(t, user.SkinHash.Valid)
+
+	form = url.Values{}
+	form.Set("rotateSkinsDaily", "on")
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec = ts.PostForm(t, ts.Server, "/drasl/update-skin-rotation", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "", getErrorMessage(rec))
+
+	// Daily rotation goes through the whole library
+	seen := map[string]bool{}
+	for i := 2; i < 4; i += 1 {
+		assert.Nil(t, ts.App.RotateSkins(christmas.AddDate(0, 0, i)))
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		seen[user.SkinHash.String] = true
+	}
+	assert.Equal(t, map[string]bool{redHash: true, blueHash: true}, seen)
+
+	librarySkins, err := ts.App.GetLibrarySkins(&user)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(librarySkins))
+	assert.Nil(t, DeleteUser(ts.App, &user))
+	for _, hash := range []string{redHash, blueHash} {
+		_, err := os.Stat(GetSkinPath(ts.App, hash))
+		assert.True(t, os.IsNotExist(err))
+	}
+}
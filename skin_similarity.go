@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+/*
+Admins can look for skins that look like a given one, e.g. to find re-uploads
+of a skin that was cleared after a report. Each skin gets a 64-bit perceptual
+hash when it's saved: the skin is shrunk to 9 × 8 pixels of brightness, and
+each bit records whether a pixel is brighter than the one to its right.
+Re-encoded, rescaled, or lightly edited copies of a skin have hashes that
+differ in only a few bits. So that a search doesn't compare against every
+skin, the hash is also stored as eight bytes in indexed columns: two hashes
+that differ in at most 7 bits have at least one byte in common, and only
+skins that share a byte with the one searched for are compared.
+
+Fingerprints are kept after their skins are deleted, so a cleared skin can
+still be searched for.
+*/
+
+const MAX_SKIN_SIMILARITY_DISTANCE = 7
+const DEFAULT_SKIN_SIMILARITY_DISTANCE = 4
+
+// Why a search couldn't be done, meant to be shown to the admin
+type SkinSearchError struct {
+	Message string
+}
+
+func (err *SkinSearchError) Error() string {
+	return err.Message
+}
+
+var errSkinFingerprintNotFound = &SkinSearchError{Message: "Skin not found."}
+
+// A skin similar to the one searched for, and the users wearing it
+type SimilarSkin struct {
+	SkinHash string
+	URL      string
+	// Number of bits in which the perceptual hashes differ
+	Distance int
+	Users    []User
+}
+
+// Overlap of pixel `i` with the span from `start` to `end`, in pixels
+func pixelCoverage(i int, start float64, end float64) float64 {
+	return math.Min(float64(i+1), end) - math.Max(float64(i), start)
+}
+
+// dHash of the image's brightness, with transparent pixels counting as black.
+// Each of the 9 × 8 cells averages the pixels it covers, weighted by how much
+// of each pixel it covers, so a skin scaled by a whole factor hashes the same.
+func PerceptualHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	cellWidth := float64(bounds.Dx()) / width
+	cellHeight := float64(bounds.Dy()) / height
+
+	var brightness [height][width]float64
+	for cy := 0; cy < height; cy += 1 {
+		y0, y1 := float64(cy)*cellHeight, float64(cy+1)*cellHeight
+		for cx := 0; cx < width; cx += 1 {
+			x0, x1 := float64(cx)*cellWidth, float64(cx+1)*cellWidth
+			var sum float64
+			for y := int(y0); float64(y) < y1; y += 1 {
+				yWeight := pixelCoverage(y, y0, y1)
+				for x := int(x0); float64(x) < x1; x += 1 {
+					weight := yWeight * pixelCoverage(x, x0, x1)
+					// Premultiplied, so transparent pixels are black
+					r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+					sum += weight * (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b))
+				}
+			}
+			brightness[cy][cx] = sum / (cellWidth * cellHeight)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y += 1 {
+		for x := 0; x < width-1; x += 1 {
+			hash <<= 1
+			if brightness[y][x] > brightness[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func makeSkinFingerprint(skinHash string, perceptualHash uint64) SkinFingerprint {
+	band := func(i int) uint8 {
+		return uint8(perceptualHash >> (8 * i))
+	}
+	return SkinFingerprint{
+		SkinHash:       skinHash,
+		PerceptualHash: int64(perceptualHash),
+		Band0:          band(0),
+		Band1:          band(1),
+		Band2:          band(2),
+		Band3:          band(3),
+		Band4:          band(4),
+		Band5:          band(5),
+		Band6:          band(6),
+		Band7:          band(7),
+	}
+}
+
+// Save the perceptual hash of a skin, given as the PNG it's stored as
+func SaveSkinFingerprint(db *gorm.DB, skinHash string, data []byte) error {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	fingerprint := makeSkinFingerprint(skinHash, PerceptualHash(img))
+	// A skin's fingerprint never changes
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&fingerprint).Error
+}
+
+// Fingerprint the skins that were saved before fingerprints were, so they
+// can be found too
+func (app *App) BackfillSkinFingerprints() {
+	directory := path.Join(app.Config().StateDirectory, "skin")
+	entries, err := os.ReadDir(directory)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Couldn't fingerprint skins: %s\n", err)
+		return
+	}
+
+	var fingerprinted []string
+	if err := app.DB.Model(&SkinFingerprint{}).Pluck("skin_hash", &fingerprinted).Error; err != nil {
+		log.Printf("Couldn't fingerprint skins: %s\n", err)
+		return
+	}
+	done := make(map[string]bool, len(fingerprinted))
+	for _, hash := range fingerprinted {
+		done[hash] = true
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".png") {
+			continue
+		}
+		hash := strings.TrimSuffix(name, ".png")
+		if done[hash] {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(directory, name))
+		if err == nil {
+			err = SaveSkinFingerprint(app.DB, hash, data)
+		}
+		// The skin may have been deleted since, or be corrupt, which is
+		// fsck's business
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Couldn't fingerprint skin %s: %s\n", hash, err)
+		}
+	}
+}
+
+func (app *App) getSkinFingerprint(skinHash string) (*SkinFingerprint, error) {
+	var fingerprint SkinFingerprint
+	err := app.DB.First(&fingerprint, "skin_hash = ?", skinHash).Error
+	if err == nil {
+		return &fingerprint, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Not fingerprinted yet, e.g. if the backfill hasn't got to it
+	data, err := os.ReadFile(GetSkinPath(app, skinHash))
+	if os.IsNotExist(err) {
+		return nil, errSkinFingerprintNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := SaveSkinFingerprint(app.DB, skinHash, data); err != nil {
+		return nil, err
+	}
+	return app.getSkinFingerprint(skinHash)
+}
+
+// Find the skin to search for from a skin hash or URL, or the name of a
+// player wearing it
+func (app *App) ResolveSkinQuery(query string) (string, error) {
+	if hash, err := ParseTextureHash(query); err == nil {
+		return hash, nil
+	}
+	var user User
+	err := app.DB.First(&user, "player_name = ?", query).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", &SkinSearchError{Message: fmt.Sprintf("%s is neither a skin nor a player.", query)}
+	}
+	if err != nil {
+		return "", err
+	}
+	if !user.SkinHash.Valid {
+		return "", &SkinSearchError{Message: fmt.Sprintf("%s has no skin.", user.PlayerName)}
+	}
+	return user.SkinHash.String, nil
+}
+
+// Skins whose perceptual hashes differ from the given skin's in at most
+// maxDistance bits, and that someone is wearing, most similar first. The skin
+// itself is included, if anyone is wearing it.
+func (app *App) FindSimilarSkins(skinHash string, maxDistance int) ([]SimilarSkin, error) {
+	if maxDistance < 0 || maxDistance > MAX_SKIN_SIMILARITY_DISTANCE {
+		return nil, &SkinSearchError{Message: fmt.Sprintf("The maximum difference must be between 0 and %d.", MAX_SKIN_SIMILARITY_DISTANCE)}
+	}
+	fingerprint, err := app.getSkinFingerprint(skinHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []SkinFingerprint
+	err = app.DB.Where(
+		"band0 = ? OR band1 = ? OR band2 = ? OR band3 = ? OR band4 = ? OR band5 = ? OR band6 = ? OR band7 = ?",
+		fingerprint.Band0, fingerprint.Band1, fingerprint.Band2, fingerprint.Band3,
+		fingerprint.Band4, fingerprint.Band5, fingerprint.Band6, fingerprint.Band7,
+	).Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	distances := map[string]int{}
+	hashes := []string{}
+	for _, candidate := range candidates {
+		distance := bits.OnesCount64(uint64(candidate.PerceptualHash ^ fingerprint.PerceptualHash))
+		if distance <= maxDistance {
+			distances[candidate.SkinHash] = distance
+			hashes = append(hashes, candidate.SkinHash)
+		}
+	}
+
+	var users []User
+	if err := app.DB.Where("skin_hash IN ?", hashes).Order("player_name").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	similarSkins := map[string]*SimilarSkin{}
+	for _, user := range users {
+		hash := user.SkinHash.String
+		similarSkin, ok := similarSkins[hash]
+		if !ok {
+			url, err := FrontEndSkinURL(app, hash)
+			if err != nil {
+				return nil, err
+			}
+			similarSkin = &SimilarSkin{SkinHash: hash, URL: url, Distance: distances[hash]}
+			similarSkins[hash] = similarSkin
+		}
+		similarSkin.Users = append(similarSkin.Users, user)
+	}
+
+	results := make([]SimilarSkin, 0, len(similarSkins))
+	for _, similarSkin := range similarSkins {
+		results = append(results, *similarSkin)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+		return results[i].SkinHash < results[j].SkinHash
+	})
+	return results, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSkinSimilarity(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test perceptual hash", testPerceptualHash)
+		t.Run("Test finding similar skins", ts.testFindSimilarSkins)
+	}
+}
+
+// A 64 × 64 skin of random colors, and copies of it scaled up to 128 × 128,
+// with a small part painted over, and with its colors inverted
+func similarSkins() (*image.NRGBA, *image.NRGBA, *image.NRGBA, *image.NRGBA) {
+	random := rand.New(rand.NewSource(1))
+	original := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(original.Pix); i += 4 {
+		original.Pix[i] = uint8(random.Intn(256))
+		original.Pix[i+1] = uint8(random.Intn(256))
+		original.Pix[i+2] = uint8(random.Intn(256))
+		original.Pix[i+3] = 255
+	}
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	edited := image.NewNRGBA(original.Bounds())
+	inverted := image.NewNRGBA(original.Bounds())
+	for y := 0; y < 128; y += 1 {
+		for x := 0; x < 128; x += 1 {
+			scaled.SetNRGBA(x, y, original.NRGBAAt(x/2, y/2))
+		}
+	}
+	for y := 0; y < 64; y += 1 {
+		for x := 0; x < 64; x += 1 {
+			c := original.NRGBAAt(x, y)
+			if x < 2 && y < 2 {
+				edited.SetNRGBA(x, y, color.NRGBA{A: 255})
+			} else {
+				edited.SetNRGBA(x, y, c)
+			}
+			inverted.SetNRGBA(x, y, color.NRGBA{R: 255 - c.R, G: 255 - c.G, B: 255 - c.B, A: 255})
+		}
+	}
+	return original, scaled, edited, inverted
+}
+
+func testPerceptualHash(t *testing.T) {
+	original, scaled, edited, inverted := similarSkins()
+	distance := func(a image.Image, b image.Image) int {
+		return bits.OnesCount64(PerceptualHash(a) ^ PerceptualHash(b))
+	}
+	assert.Equal(t, 0, distance(original, scaled))
+	assert.LessOrEqual(t, distance(original, edited), DEFAULT_SKIN_SIMILARITY_DISTANCE)
+	assert.Greater(t, distance(original, inverted), MAX_SKIN_SIMILARITY_DISTANCE)
+
+	// Each byte of the hash gets its own column
+	fingerprint := makeSkinFingerprint("hash", 0x0102030405060708)
+	assert.Equal(t, uint8(0x08), fingerprint.Band0)
+	assert.Equal(t, uint8(0x01), fingerprint.Band7)
+}
+
+func (ts *TestSuite) testFindSimilarSkins(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+
+	original, scaled, edited, inverted := similarSkins()
+	hashes := map[string]string{}
+	for _, skin := range []struct {
+		Username string
+		Image    *image.NRGBA
+	}{
+		{"original", original},
+		{"scaled", scaled},
+		{"edited", edited},
+		{"inverted", inverted},
+	} {
+		ts.CreateTestUser(ts.Server, skin.Username)
+		var user User
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", skin.Username).Error)
+		var buf bytes.Buffer
+		assert.Nil(t, png.Encode(&buf, skin.Image))
+		assert.Nil(t, SetSkinAndSave(ts.App, &user, &buf))
+		hashes[skin.Username] = user.SkinHash.String
+	}
+
+	results, err := ts.App.FindSimilarSkins(hashes["original"], DEFAULT_SKIN_SIMILARITY_DISTANCE)
+	assert.Nil(t, err)
+	found := map[string]int{}
+	for _, result := range results {
+		for _, user := range result.Users {
+			found[user.Username] = result.Distance
+		}
+	}
+	assert.Equal(t, 0, found["original"])
+	assert.Equal(t, 0, found["scaled"])
+	assert.Contains(t, found, "edited")
+	assert.NotContains(t, found, "inverted")
+	// Most similar first
+	assert.Equal(t, 0, results[0].Distance)
+
+	_, err = ts.App.FindSimilarSkins(hashes["original"], MAX_SKIN_SIMILARITY_DISTANCE+1)
+	assert.IsType(t, &SkinSearchError{}, err)
+
+	// A skin that was cleared can still be searched for
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", "original").Error)
+	assert.Nil(t, ts.App.DB.Model(&user).Update("skin_hash", nil).Error)
+	originalHash := hashes["original"]
+	assert.Nil(t, DeleteSkinIfUnused(ts.App, &originalHash))
+	hash, err := ts.App.ResolveSkinQuery(originalHash)
+	assert.Nil(t, err)
+	results, err = ts.App.FindSimilarSkins(hash, 0)
+	assert.Nil(t, err)
+	var usernames []string
+	for _, result := range results {
+		assert.NotEqual(t, originalHash, result.SkinHash)
+		for _, user := range result.Users {
+			usernames = append(usernames, user.Username)
+		}
+	}
+	assert.Contains(t, usernames, "scaled")
+
+	// Search by player name from the reports page
+	query := url.Values{}
+	query.Set("similarTo", "scaled")
+	rec := ts.Get(t, ts.Server, "/drasl/admin/reports?"+query.Encode(), []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), hashes["edited"]))
+	assert.False(t, strings.Contains(rec.Body.String(), hashes["inverted"]))
+
+	query.Set("similarTo", "nobody")
+	rec = ts.Get(t, ts.Server, "/drasl/admin/reports?"+query.Encode(), []http.Cookie{*adminBrowserTokenCookie}, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "nobody is neither a skin nor a player."))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func TestSkin(t *testing.T) {
+	t.Run("Test converting legacy skins", testConvertLegacySkin)
+	t.Run("Test sanitizing textures", testSanitizeTexture)
+	t.Run("Test transcoding textures", testTranscodeTexture)
+	t.Run("Test detecting the skin model", testDetectSkinModel)
+}
+
+func testConvertLegacySkin(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	legacy := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	// Outer edges of the front of the right leg and the right arm
+	legacy.SetNRGBA(4, 20, red)
+	legacy.SetNRGBA(47, 20, blue)
+
+	img := ConvertLegacySkin(legacy)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), img.Bounds())
+	// The top half is unchanged
+	assert.Equal(t, red, img.NRGBAAt(4, 20))
+	assert.Equal(t, blue, img.NRGBAAt(47, 20))
+	// The left leg and arm are mirrored, so the outer edges stay outside
+	assert.Equal(t, red, img.NRGBAAt(23, 52))
+	assert.Equal(t, blue, img.NRGBAAt(36, 52))
+	assert.Equal(t, color.NRGBA{}, img.NRGBAAt(20, 52))
+
+	var legacyPNG bytes.Buffer
+	assert.Nil(t, png.Encode(&legacyPNG, legacy))
+
+	app := &App{}
+	app.config.Store(testConfig())
+	reader, err := ValidateSkin(app, bytes.NewReader(legacyPNG.Bytes()))
+	assert.Nil(t, err)
+	converted, err := png.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), converted.Bounds())
+
+	app.Config().ConvertLegacySkins = false
+	_, err = ValidateSkin(app, bytes.NewReader(legacyPNG.Bytes()))
+	assert.Equal(t, "texture must be square", err.Error())
+}
+
+func testSanitizeTexture(t *testing.T) {
+	app := &App{}
+	app.config.Store(testConfig())
+
+	// Ancillary chunks, like RED_SKIN's sRGB chunk, are dropped
+	assert.True(t, bytes.Contains(RED_SKIN, []byte("sRGB")))
+	reader, err := ValidateSkin(app, bytes.NewReader(RED_SKIN))
+	assert.Nil(t, err)
+	sanitized, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(sanitized, []byte("sRGB")))
+
+	// Transparent pixels lose their color
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 0})
+	img.SetNRGBA(1, 0, color.NRGBA{R: 255, G: 255, B: 255, A: 1})
+	var capePNG bytes.Buffer
+	assert.Nil(t, png.Encode(&capePNG, img))
+	reader, err = ValidateCape(app, bytes.NewReader(capePNG.Bytes()))
+	assert.Nil(t, err)
+	decoded, err := png.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, color.NRGBA{}, decoded.At(0, 0))
+	assert.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 1}, decoded.At(1, 0))
+
+	// A header claiming a huge image is rejected before anything is
+	// decoded, even without SkinSizeLimit
+	app.Config().SkinSizeLimit = 0
+	bomb := append([]byte{}, capePNG.Bytes()...)
+	// IHDR's width and height follow the 8-byte signature and the chunk's
+	// length and type
+	binary.BigEndian.PutUint32(bomb[16:20], 65536)
+	binary.BigEndian.PutUint32(bomb[20:24], 32768)
+	binary.BigEndian.PutUint32(bomb[29:33], crc32.ChecksumIEEE(bomb[12:29]))
+	_, err = ValidateCape(app, bytes.NewReader(bomb))
+	assert.Equal(t, "texture is too large", err.Error())
+
+	// So is a truncated one
+	_, err = ValidateSkin(app, bytes.NewReader(RED_SKIN[:len(RED_SKIN)/2]))
+	assert.NotNil(t, err)
+}
+
+// A 64 × 32 lossless WebP, solid red
+const RED_CAPE_WEBP_BASE64_STRING = "UklGRhoAAABXRUJQVlA4TA0AAAAvP8AHEChA/wvQ/wIAAA=="
+
+func testTranscodeTexture(t *testing.T) {
+	app := &App{}
+	app.config.Store(testConfig())
+	red := color.NRGBA{R: 255, A: 255}
+
+	// JPEGs and WebPs are stored as PNGs
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:i+4], []byte{255, 0, 0, 255})
+	}
+	var skinJPEG bytes.Buffer
+	assert.Nil(t, jpeg.Encode(&skinJPEG, img, &jpeg.Options{Quality: 100}))
+	reader, err := ValidateSkin(app, bytes.NewReader(skinJPEG.Bytes()))
+	assert.Nil(t, err)
+	decoded, err := png.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), decoded.Bounds())
+
+	capeWebP := Unwrap(base64.StdEncoding.DecodeString(RED_CAPE_WEBP_BASE64_STRING))
+	reader, err = ValidateCape(app, bytes.NewReader(capeWebP))
+	assert.Nil(t, err)
+	decoded, err = png.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 32), decoded.Bounds())
+	assert.Equal(t, red, color.NRGBAModel.Convert(decoded.At(10, 10)))
+
+	// Dimensions are checked whatever the format
+	_, err = ValidateSkin(app, bytes.NewReader(capeWebP))
+	assert.Nil(t, err)
+	app.Config().ConvertLegacySkins = false
+	_, err = ValidateSkin(app, bytes.NewReader(capeWebP))
+	assert.Equal(t, "texture must be square", err.Error())
+	_, err = ValidateCape(app, bytes.NewReader(skinJPEG.Bytes()))
+	assert.Equal(t, "cape's width must be twice its height", err.Error())
+
+	// Animated WebPs are rejected
+	vp8x := []byte("VP8X\x0a\x00\x00\x00\x02\x00\x00\x00\x3f\x00\x00\x1f\x00\x00")
+	animatedWebP := append([]byte{}, capeWebP[:12]...)
+	animatedWebP = append(animatedWebP, vp8x...)
+	animatedWebP = append(animatedWebP, capeWebP[12:]...)
+	binary.LittleEndian.PutUint32(animatedWebP[4:8], uint32(len(animatedWebP)-8))
+	_, err = ValidateCape(app, bytes.NewReader(animatedWebP))
+	assert.Equal(t, errTextureAnimated, err)
+
+	// ...and so are APNGs, which have an acTL chunk after IHDR
+	acTL := make([]byte, 20)
+	binary.BigEndian.PutUint32(acTL[0:4], 8)
+	copy(acTL[4:8], "acTL")
+	binary.BigEndian.PutUint32(acTL[8:12], 2)
+	binary.BigEndian.PutUint32(acTL[16:20], crc32.ChecksumIEEE(acTL[4:16]))
+	animatedPNG := append([]byte{}, RED_SKIN[:33]...)
+	animatedPNG = append(animatedPNG, acTL...)
+	animatedPNG = append(animatedPNG, RED_SKIN[33:]...)
+	_, err = ValidateSkin(app, bytes.NewReader(animatedPNG))
+	assert.Equal(t, errTextureAnimated, err)
+
+	_, err = ValidateSkin(app, bytes.NewReader([]byte("GIF89a")))
+	assert.Equal(t, errTextureFormat, err)
+}
+
+func testDetectSkinModel(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	fill := func(img *image.NRGBA, rect image.Rectangle, c color.NRGBA) {
+		for y := rect.Min.Y; y < rect.Max.Y; y += 1 {
+			for x := rect.Min.X; x < rect.Max.X; x += 1 {
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	fill(img, img.Bounds(), red)
+	assert.Equal(t, SkinModelClassic, DetectSkinModel(img))
+
+	for _, region := range SLIM_SKIN_UNUSED_REGIONS {
+		fill(img, region, color.NRGBA{})
+	}
+	assert.Equal(t, SkinModelSlim, DetectSkinModel(img))
+
+	// Some editors fill the unused regions with black
+	for _, region := range SLIM_SKIN_UNUSED_REGIONS {
+		fill(img, region, color.NRGBA{A: 255})
+	}
+	assert.Equal(t, SkinModelSlim, DetectSkinModel(img))
+
+	img.SetNRGBA(54, 20, red)
+	assert.Equal(t, SkinModelClassic, DetectSkinModel(img))
+
+	detected, err := DetectSkinModelPNG(bytes.NewReader(RED_SKIN))
+	assert.Nil(t, err)
+	assert.Equal(t, SkinModelClassic, detected)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"time"
+)
+
+/*
+Counts of the accounts on this instance, for status pages and server lists.
+Mojang's POST /orders/statistics reported how many copies of Minecraft had
+been sold; Drasl reports how many accounts have been registered instead, and
+serves more detail at /drasl/api/v2/statistics. Some admins consider these
+counts sensitive, so neither is served unless Statistics.Allow is set.
+*/
+
+// Metric keys of /orders/statistics that count Minecraft accounts. Mojang's
+// other keys, for its other games, are always 0.
+var MINECRAFT_STATISTICS_METRIC_KEYS = []string{"item_sold_minecraft", "prepaid_card_redeemed_minecraft"}
+
+type Statistics struct {
+	Users              int64
+	NewUsersLast24h    int64
+	ActiveUsersLast24h int64
+	Admins             int64
+	LockedUsers        int64
+}
+
+// Count the registered accounts. Accounts pending approval aren't counted.
+func (app *App) GetStatistics() (*Statistics, error) {
+	since := time.Now().Add(-24 * time.Hour)
+	users := func() *gorm.DB {
+		return app.DB.Model(&User{}).Where("is_pending_approval = ?", false)
+	}
+
+	var statistics Statistics
+	if err := users().Count(&statistics.Users).Error; err != nil {
+		return nil, err
+	}
+	if err := users().Where("created_at >= ?", since).Count(&statistics.NewUsersLast24h).Error; err != nil {
+		return nil, err
+	}
+	activeUUIDs := app.DB.Model(&Client{}).Select("user_uuid").Where("last_used_at >= ?", since)
+	if err := users().Where("uuid IN (?)", activeUUIDs).Count(&statistics.ActiveUsersLast24h).Error; err != nil {
+		return nil, err
+	}
+	if err := users().Where("is_admin = ?", true).Count(&statistics.Admins).Error; err != nil {
+		return nil, err
+	}
+	if err := users().Where("is_locked = ?", true).Count(&statistics.LockedUsers).Error; err != nil {
+		return nil, err
+	}
+	return &statistics, nil
+}
+
+type ordersStatisticsRequest struct {
+	MetricKeys []string `json:"metricKeys"`
+}
+
+type ordersStatisticsResponse struct {
+	Total                  int64   `json:"total"`
+	Last24h                int64   `json:"last24h"`
+	SaleVelocityPerSeconds float64 `json:"saleVelocityPerSeconds"`
+}
+
+// POST /orders/statistics
+// https://wiki.vg/Mojang_API#Statistics
+func AccountOrdersStatistics(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().Statistics.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Statistics are not available on this server."))
+		}
+
+		req := new(ordersStatisticsRequest)
+		if err := c.Bind(req); err != nil {
+			return MakeErrorResponse(&c, http.StatusBadRequest, Ptr("IllegalArgumentException"), Ptr("Invalid request body."))
+		}
+
+		res := ordersStatisticsResponse{}
+		counted := false
+		for _, key := range req.MetricKeys {
+			if Contains(MINECRAFT_STATISTICS_METRIC_KEYS, key) {
+				counted = true
+			}
+		}
+		if counted {
+			statistics, err := app.GetStatistics()
+			if err != nil {
+				return err
+			}
+			res.Total = statistics.Users
+			res.Last24h = statistics.NewUsersLast24h
+			res.SaleVelocityPerSeconds = float64(statistics.NewUsersLast24h) / (24 * 60 * 60)
+		}
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+type apiStatisticsResponse struct {
+	Users              int64 `json:"users"`
+	NewUsersLast24h    int64 `json:"newUsersLast24h"`
+	ActiveUsersLast24h int64 `json:"activeUsersLast24h"`
+	Admins             int64 `json:"admins"`
+	LockedUsers        int64 `json:"lockedUsers"`
+}
+
+// GET /drasl/api/:version/statistics
+func APIStatistics(app *App) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		if !app.Config().Statistics.Allow {
+			return MakeErrorResponse(&c, http.StatusForbidden, Ptr("ForbiddenOperationException"), Ptr("Statistics are not available on this server."))
+		}
+
+		statistics, err := app.GetStatistics()
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, apiStatisticsResponse(*statistics))
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestStatistics(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.Statistics.Allow = true
+		config.DefaultAdmins = []string{"Bob"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test statistics", ts.testStatistics)
+	}
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test statistics, not allowed", ts.testStatisticsNotAllowed)
+	}
+}
+
+func (ts *TestSuite) testStatistics(t *testing.T) {
+	ts.CreateTestUser(ts.Server, "Bob")
+	ts.CreateTestUser(ts.Server, "Alice")
+	ts.authenticate(t, "Alice", TEST_PASSWORD)
+	assert.Nil(t, ts.App.DB.Model(&User{}).Where("username = ?", "Alice").Update("is_locked", true).Error)
+
+	{
+		rec := ts.Get(t, ts.Server, "/drasl/api/v1/statistics", nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response apiStatisticsResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, apiStatisticsResponse{
+			Users:              2,
+			NewUsersLast24h:    2,
+			ActiveUsersLast24h: 1,
+			Admins:             1,
+			LockedUsers:        1,
+		}, response)
+	}
+	{
+		// Accounts are counted as Minecraft sales
+		rec := ts.PostJSON(t, ts.Server, "/orders/statistics", ordersStatisticsRequest{MetricKeys: []string{"item_sold_minecraft", "prepaid_card_redeemed_minecraft"}}, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response ordersStatisticsResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, int64(2), response.Total)
+		assert.Equal(t, int64(2), response.Last24h)
+		assert.Greater(t, response.SaleVelocityPerSeconds, 0.0)
+
+		// Other games have no sales
+		rec = ts.PostJSON(t, ts.Server, "/account/orders/statistics", ordersStatisticsRequest{MetricKeys: []string{"item_sold_dungeons"}}, nil, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, ordersStatisticsResponse{}, response)
+	}
+}
+
+func (ts *TestSuite) testStatisticsNotAllowed(t *testing.T) {
+	rec := ts.Get(t, ts.Server, "/drasl/api/v1/statistics", nil, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = ts.PostJSON(t, ts.Server, "/orders/statistics", ordersStatisticsRequest{MetricKeys: []string{"item_sold_minecraft"}}, nil, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Aggregated statistics for the admin dashboard. Events are counted per day as
+they happen, so the dashboard never has to scan logs or large tables.
+*/
+
+const (
+	StatRegistrations string = "registrations"
+	StatJoins         string = "joins"
+	// Wrong passwords given to /authenticate, and requests slowed or
+	// refused by AuthenticateThrottle
+	StatAuthenticateFailures  string = "authenticate-failures"
+	StatAuthenticateTarpitted string = "authenticate-tarpitted"
+	StatAuthenticateThrottled string = "authenticate-throttled"
+	// Followed by the fallback API server's Nickname
+	StatFallbackPrefix string = "fallback:"
+)
+
+const MAX_ERROR_LOG_ENTRIES = 100
+
+// Number of days shown on the dashboard
+const STATS_DAYS = 30
+
+func statDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// Count one occurrence of `name` today. Failing to record a statistic
+// shouldn't fail the request, so errors are only logged.
+func (app *App) IncrementStat(name string) {
+	err := app.DB.Exec(
+		"INSERT INTO daily_stats (day, name, count) VALUES (?, ?, 1) ON CONFLICT (day, name) DO UPDATE SET count = count + 1",
+		statDay(time.Now()), name,
+	).Error
+	if err != nil {
+		log.Printf("Couldn't record statistic %s: %s\n", name, err)
+	}
+}
+
+func (app *App) RecordJoin(user *User) {
+	app.IncrementStat(StatJoins)
+	err := app.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&DailyActivePlayer{
+		Day:      statDay(time.Now()),
+		UserUUID: user.UUID,
+	}).Error
+	if err != nil {
+		log.Printf("Couldn't record active player %s: %s\n", user.Username, err)
+	}
+}
+
+func (app *App) RecordFallbackUse(fallbackAPIServer *FallbackAPIServer) {
+	app.IncrementStat(StatFallbackPrefix + fallbackAPIServer.Nickname)
+}
+
+func (app *App) RecordError(method string, path string, err error) {
+	entry := ErrorLogEntry{
+		CreatedAt: time.Now(),
+		Method:    method,
+		Path:      path,
+		Message:   err.Error(),
+	}
+	if err := app.DB.Create(&entry).Error; err != nil {
+		log.Printf("Couldn't record error: %s\n", err)
+		return
+	}
+	err = app.DB.Where("id <= ?", int(entry.ID)-MAX_ERROR_LOG_ENTRIES).Delete(&ErrorLogEntry{}).Error
+	if err != nil {
+		log.Printf("Couldn't prune error log: %s\n", err)
+	}
+}
+
+type DayCount struct {
+	Day   string
+	Count int64
+	// Count relative to the largest count in the series, 0 to 100
+	Percent int64
+}
+
+type NameCount struct {
+	Name  string
+	Count int64
+}
+
+type StorageUsage struct {
+	Name  string
+	Files int
+	Bytes int64
+}
+
+type Stats struct {
+	Registrations []DayCount
+	Joins         []DayCount
+	ActivePlayers []DayCount
+	// From /authenticate
+	FailedLogins    []DayCount
+	ThrottledLogins []DayCount
+	FallbackUsage   []NameCount
+	// Clients still using deprecated versions of Drasl's API
+	DeprecatedAPIClients []DeprecatedAPIClient
+	Storage              []StorageUsage
+	RecentErrors         []ErrorLogEntry
+}
+
+// Fill in days with no events and compute each day's Percent
+func makeDaySeries(counts map[string]int64, days []string) []DayCount {
+	var maxCount int64 = 0
+	for _, count := range counts {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+	series := make([]DayCount, 0, len(days))
+	for _, day := range days {
+		dayCount := DayCount{Day: day, Count: counts[day]}
+		if maxCount > 0 {
+			dayCount.Percent = 100 * dayCount.Count / maxCount
+		}
+		series = append(series, dayCount)
+	}
+	return series
+}
+
+func (app *App) getDailyStat(name string, since string) (map[string]int64, error) {
+	var stats []DailyStat
+	if err := app.DB.Where("name = ? AND day >= ?", name, since).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(stats))
+	for _, stat := range stats {
+		counts[stat.Day] = stat.Count
+	}
+	return counts, nil
+}
+
+func getDirectoryUsage(name string, dir string) (StorageUsage, error) {
+	usage := StorageUsage{Name: name}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			usage.Files += 1
+			usage.Bytes += info.Size()
+		}
+		return nil
+	})
+	return usage, err
+}
+
+func FormatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp += 1
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func (app *App) GetStats() (*Stats, error) {
+	now := time.Now()
+	days := make([]string, 0, STATS_DAYS)
+	for i := STATS_DAYS - 1; i >= 0; i-- {
+		days = append(days, statDay(now.AddDate(0, 0, -i)))
+	}
+	since := days[0]
+
+	stats := Stats{}
+
+	registrations, err := app.getDailyStat(StatRegistrations, since)
+	if err != nil {
+		return nil, err
+	}
+	stats.Registrations = makeDaySeries(registrations, days)
+
+	joins, err := app.getDailyStat(StatJoins, since)
+	if err != nil {
+		return nil, err
+	}
+	stats.Joins = makeDaySeries(joins, days)
+
+	failedLogins, err := app.getDailyStat(StatAuthenticateFailures, since)
+	if err != nil {
+		return nil, err
+	}
+	stats.FailedLogins = makeDaySeries(failedLogins, days)
+
+	// Tarpitted and refused logins are shown together
+	throttledLogins, err := app.getDailyStat(StatAuthenticateThrottled, since)
+	if err != nil {
+		return nil, err
+	}
+	tarpittedLogins, err := app.getDailyStat(StatAuthenticateTarpitted, since)
+	if err != nil {
+		return nil, err
+	}
+	for day, count := range tarpittedLogins {
+		throttledLogins[day] += count
+	}
+	stats.ThrottledLogins = makeDaySeries(throttledLogins, days)
+
+	var activePlayers []DayCount
+	err = app.DB.Model(&DailyActivePlayer{}).
+		Select("day, count(*) AS count").
+		Where("day >= ?", since).
+		Group("day").
+		Find(&activePlayers).Error
+	if err != nil {
+		return nil, err
+	}
+	activePlayerCounts := make(map[string]int64, len(activePlayers))
+	for _, dayCount := range activePlayers {
+		activePlayerCounts[dayCount.Day] = dayCount.Count
+	}
+	stats.ActivePlayers = makeDaySeries(activePlayerCounts, days)
+
+	var fallbackStats []DailyStat
+	err = app.DB.Where("name LIKE ? AND day >= ?", StatFallbackPrefix+"%", since).Find(&fallbackStats).Error
+	if err != nil {
+		return nil, err
+	}
+	fallbackCounts := map[string]int64{}
+	for _, stat := range fallbackStats {
+		fallbackCounts[strings.TrimPrefix(stat.Name, StatFallbackPrefix)] += stat.Count
+	}
+	for name, count := range fallbackCounts {
+		stats.FallbackUsage = append(stats.FallbackUsage, NameCount{Name: name, Count: count})
+	}
+	sort.Slice(stats.FallbackUsage, func(i, j int) bool {
+		return stats.FallbackUsage[i].Count > stats.FallbackUsage[j].Count
+	})
+
+	stats.DeprecatedAPIClients, err = app.GetDeprecatedAPIClients(since)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dir := range []string{"skin", "cape"} {
+		usage, err := getDirectoryUsage(dir, filepath.Join(app.Config().StateDirectory, dir))
+		if err != nil {
+			return nil, err
+		}
+		stats.Storage = append(stats.Storage, usage)
+	}
+	dbUsage, err := getDirectoryUsage("database", filepath.Join(app.Config().StateDirectory, "drasl.db"))
+	if err != nil {
+		return nil, err
+	}
+	stats.Storage = append(stats.Storage, dbUsage)
+
+	err = app.DB.Order("id desc").Limit(20).Find(&stats.RecentErrors).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+Temporary suspensions. A suspended user is locked like any other, but with
+LockedUntil set; a background job lifts the lock once that time has passed.
+Locking or unlocking the user by hand from the Admin page replaces the
+suspension.
+*/
+
+// How often expired suspensions are checked for
+const SUSPENSION_CHECK_INTERVAL = time.Minute
+
+// The longest suspension an admin can set; lock the account for longer
+const MAX_SUSPENSION_DURATION = 365 * 24 * time.Hour
+
+var errSuspendAdmin = errors.New("Admins can't be suspended.")
+
+// Suspend `user` until `until`, on behalf of `admin`
+func (app *App) SuspendUser(admin *User, user *User, until time.Time, reason string) error {
+	if user.IsAdmin {
+		return errSuspendAdmin
+	}
+	if err := app.SetIsLocked(app.DB, user, true); err != nil {
+		return err
+	}
+	user.LockedUntil = sql.NullTime{Time: until, Valid: true}
+	if err := app.DB.Save(user).Error; err != nil {
+		return err
+	}
+
+	details := "until " + until.UTC().Format(time.RFC3339)
+	if reason != "" {
+		details += ": " + reason
+	}
+	return app.LogAudit(admin, AuditActionSuspendUser, user, details)
+}
+
+// Unlock the users whose suspensions ended by `now`
+func (app *App) LiftExpiredSuspensions(now time.Time) error {
+	var users []User
+	if err := app.DB.Where("is_locked AND locked_until <= ?", now).Find(&users).Error; err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := app.SetIsLocked(app.DB, &user, false); err != nil {
+			return err
+		}
+		if err := app.DB.Save(&user).Error; err != nil {
+			return err
+		}
+		if err := app.LogAudit(nil, AuditActionLiftSuspension, &user, "expired"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (app *App) RunSuspensionExpiry() {
+	for {
+		if err := app.LiftExpiredSuspensions(time.Now()); err != nil {
+			log.Printf("Couldn't lift expired suspensions: %s\n", err)
+		}
+		time.Sleep(SUSPENSION_CHECK_INTERVAL)
+	}
+}
+
+// A rough, human-readable length of time, like "2 days, 3 hours"
+func FormatRemaining(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	minutes := int((d + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	days, hours := minutes/(24*60), minutes/60%24
+	var parts []string
+	switch {
+	case days > 0:
+		parts = append(parts, plural(days, "day"))
+		if hours > 0 {
+			parts = append(parts, plural(hours, "hour"))
+		}
+	case hours > 0:
+		parts = append(parts, plural(hours, "hour"))
+		if minutes%60 > 0 {
+			parts = append(parts, plural(minutes%60, "minute"))
+		}
+	default:
+		parts = append(parts, plural(minutes, "minute"))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Why a locked user can't log in
+func LockedMessage(user *User, now time.Time) string {
+	if user.LockedUntil.Valid {
+		return fmt.Sprintf("Account is suspended for another %s.", FormatRemaining(user.LockedUntil.Time.Sub(now)))
+	}
+	return "Account is locked."
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSuspensions(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test formatting remaining time", ts.testFormatRemaining)
+		t.Run("Test suspensions", ts.testSuspensions)
+	}
+}
+
+func (ts *TestSuite) testFormatRemaining(t *testing.T) {
+	assert.Equal(t, "1 minute", FormatRemaining(10*time.Second))
+	assert.Equal(t, "45 minutes", FormatRemaining(45*time.Minute))
+	assert.Equal(t, "1 hour, 30 minutes", FormatRemaining(90*time.Minute))
+	assert.Equal(t, "2 days, 3 hours", FormatRemaining(51*time.Hour))
+	assert.Equal(t, "7 days", FormatRemaining(7*24*time.Hour))
+}
+
+func (ts *TestSuite) testSuspensions(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+	ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", "admin").Error)
+
+	suspend := func(username string, duration string, unit string) string {
+		form := url.Values{}
+		form.Set("username", username)
+		form.Set("duration", duration)
+		form.Set("unit", unit)
+		form.Set("reason", "Griefing")
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile?user="+username)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/suspend-user", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	assert.Equal(t, "Invalid duration.", suspend(TEST_USERNAME, "0", "days"))
+	assert.Equal(t, "Invalid duration.", suspend(TEST_USERNAME, "2", "weeks"))
+	assert.Equal(t, "Suspensions can't be longer than a year. Lock the account instead.", suspend(TEST_USERNAME, "366", "days"))
+	assert.Equal(t, "Admins can't be suspended.", suspend("admin", "1", "days"))
+	assert.Equal(t, "", suspend(TEST_USERNAME, "2", "days"))
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.True(t, user.IsLocked)
+	assert.True(t, user.LockedUntil.Valid)
+	assert.False(t, user.BrowserToken.Valid)
+
+	// The user is told how long is left
+	form := url.Values{}
+	form.Set("username", TEST_USERNAME)
+	form.Set("password", TEST_PASSWORD)
+	rec := ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Regexp(t, `^Account is suspended for another (1 day, 23 hours|2 days)\.$`, getErrorMessage(rec))
+
+	// Launchers can't sign in either
+	rec = ts.PostJSON(t, ts.Server, "/authenticate", authenticateRequest{Username: TEST_USERNAME, Password: TEST_PASSWORD}, nil, nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	var errorResponse ErrorResponse
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&errorResponse))
+	assert.Regexp(t, `^Account is suspended for another`, *errorResponse.ErrorMessage)
+
+	// Changing only whether the user is an admin keeps the suspension
+	for _, isAdmin := range []string{"on", ""} {
+		updateForm := url.Values{}
+		updateForm.Set("user", TEST_USERNAME)
+		updateForm.Set("admin-"+TEST_USERNAME, isAdmin)
+		updateForm.Set("locked-"+TEST_USERNAME, "on")
+		updateForm.Set("returnUrl", ts.App.FrontEndURL+"/drasl/admin")
+		rec = ts.PostForm(t, ts.Server, "/drasl/admin/update-users", updateForm, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+		assert.Equal(t, isAdmin == "on", user.IsAdmin)
+		assert.True(t, user.IsLocked)
+		assert.True(t, user.LockedUntil.Valid)
+	}
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionSuspendUser).Error)
+	assert.Equal(t, admin.UUID, auditLogEntry.ActorUUID)
+	assert.Contains(t, auditLogEntry.Details, "Griefing")
+
+	// Nothing is lifted early
+	assert.Nil(t, ts.App.LiftExpiredSuspensions(time.Now()))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.True(t, user.IsLocked)
+
+	assert.Nil(t, ts.App.LiftExpiredSuspensions(time.Now().Add(49*time.Hour)))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.False(t, user.IsLocked)
+	assert.False(t, user.LockedUntil.Valid)
+
+	var liftEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&liftEntry, "action = ?", AuditActionLiftSuspension).Error)
+	assert.Equal(t, AUDIT_SYSTEM_ACTOR, liftEntry.ActorUsername)
+	assert.Equal(t, user.UUID, liftEntry.TargetUUID.String)
+
+	rec = ts.PostForm(t, ts.Server, "/drasl/login", form, nil, nil)
+	ts.loginShouldSucceed(t, rec)
+
+	// Locking by hand replaces a suspension, so it isn't lifted
+	assert.Equal(t, "", suspend(TEST_USERNAME, "1", "hours"))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.Nil(t, ts.App.SetIsLocked(ts.App.DB, &user, true))
+	assert.Nil(t, ts.App.DB.Save(&user).Error)
+	assert.Nil(t, ts.App.LiftExpiredSuspensions(time.Now().Add(2*time.Hour)))
+	assert.Nil(t, ts.App.DB.First(&user, "uuid = ?", user.UUID).Error)
+	assert.True(t, user.IsLocked)
+}
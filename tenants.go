@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+/*
+One Drasl process can host several separate instances, or tenants, e.g. for a
+host running authentication for many communities. Each tenant has its own
+config file and so its own BaseURL, StateDirectory, and everything kept there:
+its database, its keys, and its textures. Tenants share nothing but the
+process and the main instance's ListenAddress. A request goes to the tenant
+whose BaseURL or TextureBaseURL has the request's host, and to the main
+instance if there's no such tenant.
+*/
+
+type tenant struct {
+	App    *App
+	Server *echo.Echo
+}
+
+// Hostnames whose requests are handled by the instance
+func tenantHosts(config *Config) []string {
+	hosts := []string{}
+	for _, rawURL := range []string{config.BaseURL, config.TextureBaseURL} {
+		if rawURL == "" {
+			continue
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || parsed.Hostname() == "" {
+			continue
+		}
+		hosts = append(hosts, strings.ToLower(parsed.Hostname()))
+	}
+	return hosts
+}
+
+// Read the config files listed in config.Tenants
+func ReadTenantConfigs(config *Config) ([]*Config, error) {
+	tenantConfigs := make([]*Config, 0, len(config.Tenants))
+	for _, path := range config.Tenants {
+		tenantConfig, err := ReadConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read config of tenant %s: %s", path, err)
+		}
+		tenantConfigs = append(tenantConfigs, tenantConfig)
+	}
+	if err := CheckTenantConfigs(config, tenantConfigs); err != nil {
+		return nil, err
+	}
+	return tenantConfigs, nil
+}
+
+// Make sure tenants don't step on each other's or the main instance's toes
+func CheckTenantConfigs(config *Config, tenantConfigs []*Config) error {
+	stateDirectories := map[string]bool{filepath.Clean(config.StateDirectory): true}
+	hosts := map[string]bool{}
+	for _, host := range tenantHosts(config) {
+		hosts[host] = true
+	}
+	for _, tenantConfig := range tenantConfigs {
+		if len(tenantConfig.Tenants) > 0 {
+			return fmt.Errorf("Tenant %s can't have tenants of its own", tenantConfig.BaseURL)
+		}
+		stateDirectory := filepath.Clean(tenantConfig.StateDirectory)
+		if stateDirectories[stateDirectory] {
+			return fmt.Errorf("Tenant %s must have its own StateDirectory, %s is already used", tenantConfig.BaseURL, tenantConfig.StateDirectory)
+		}
+		stateDirectories[stateDirectory] = true
+		for _, host := range tenantHosts(tenantConfig) {
+			if hosts[host] {
+				return fmt.Errorf("Tenant %s must have its own host, %s is already used", tenantConfig.BaseURL, host)
+			}
+			hosts[host] = true
+		}
+	}
+	return nil
+}
+
+// Dispatch each request to the tenant serving its Host, or to `main`
+func makeTenantHandler(main tenant, tenants []tenant) http.Handler {
+	servers := map[string]http.Handler{}
+	for _, t := range tenants {
+		for _, host := range tenantHosts(t.App.Config()) {
+			servers[host] = t.Server
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		if server, ok := servers[strings.ToLower(host)]; ok {
+			server.ServeHTTP(w, r)
+			return
+		}
+		main.Server.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTenants(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.InstanceName = "Main"
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		auxConfig := testConfig()
+		auxConfig.InstanceName = "Tenant"
+		ts.SetupAux(auxConfig)
+
+		t.Run("Test tenant dispatch", ts.testTenantDispatch)
+		t.Run("Test CheckTenantConfigs", ts.testCheckTenantConfigs)
+	}
+}
+
+func (ts *TestSuite) testTenantDispatch(t *testing.T) {
+	handler := makeTenantHandler(
+		tenant{App: ts.App, Server: ts.Server},
+		[]tenant{{App: ts.AuxApp, Server: ts.AuxServer}},
+	)
+
+	serverName := func(host string) string {
+		req := httptest.NewRequest(http.MethodGet, "/authlib-injector", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var response authlibInjectorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		return response.Meta.ServerName
+	}
+
+	// The port doesn't matter, only the host
+	assert.Equal(t, "Tenant", serverName("localhost"))
+	assert.Equal(t, "Tenant", serverName("LOCALHOST:1"))
+	assert.Equal(t, "Main", serverName("drasl.example.com"))
+
+	// Unknown hosts go to the main instance
+	assert.Equal(t, "Main", serverName("other.example.com"))
+}
+
+func (ts *TestSuite) testCheckTenantConfigs(t *testing.T) {
+	config := testConfig()
+	config.StateDirectory = "/var/lib/drasl"
+
+	tenantConfig := testConfig()
+	tenantConfig.BaseURL = "https://other.example.com"
+	tenantConfig.StateDirectory = "/var/lib/drasl-other"
+	assert.Nil(t, CheckTenantConfigs(config, []*Config{tenantConfig}))
+
+	// Tenants can't share a host
+	tenantConfig.TextureBaseURL = "https://drasl.example.com"
+	assert.NotNil(t, CheckTenantConfigs(config, []*Config{tenantConfig}))
+	tenantConfig.TextureBaseURL = ""
+
+	// ...or a StateDirectory
+	tenantConfig.StateDirectory = "/var/lib/drasl/"
+	assert.NotNil(t, CheckTenantConfigs(config, []*Config{tenantConfig}))
+	tenantConfig.StateDirectory = "/var/lib/drasl-other"
+
+	// Tenants can't be nested
+	tenantConfig.Tenants = []string{"/etc/drasl/nested.toml"}
+	assert.NotNil(t, CheckTenantConfigs(config, []*Config{tenantConfig}))
+}
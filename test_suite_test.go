@@ -6,6 +6,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -48,6 +49,16 @@ const BLUE_CAPE_BASE64_STRING = "iVBORw0KGgoAAAANSUhEUgAAAEAAAAAgCAIAAAAt/+nTAAA
 
 var BLUE_CAPE []byte = Unwrap(base64.StdEncoding.DecodeString(BLUE_CAPE_BASE64_STRING))
 
+// The hash a texture is stored under, after ValidateSkin or ValidateCape
+// re-encodes it
+func storedTextureHash(t *testing.T, app *App, validate func(*App, io.Reader) (io.Reader, error), texture []byte) string {
+	validTexture, err := validate(app, bytes.NewReader(texture))
+	assert.Nil(t, err)
+	_, hash, err := ReadTexture(app, validTexture)
+	assert.Nil(t, err)
+	return hash
+}
+
 type TestSuite struct {
 	suite.Suite
 	App               *App
@@ -99,8 +110,9 @@ func (ts *TestSuite) SetupAux(config *Config) {
 
 	// Hack: patch these after we know the listen address
 	baseURL := fmt.Sprintf("http://localhost:%d/", ts.AuxServer.Listener.Addr().(*net.TCPAddr).Port)
-	ts.AuxApp.Config.BaseURL = baseURL
+	ts.AuxApp.Config().BaseURL = baseURL
 	ts.AuxApp.FrontEndURL = baseURL
+	ts.AuxApp.TextureURL = baseURL
 	ts.AuxApp.AccountURL = Unwrap(url.JoinPath(baseURL, "account"))
 	ts.AuxApp.AuthURL = Unwrap(url.JoinPath(baseURL, "auth"))
 	ts.AuxApp.ServicesURL = Unwrap(url.JoinPath(baseURL, "services"))
@@ -153,6 +165,28 @@ func (ts *TestSuite) CreateTestUser(server *echo.Echo, username string) *http.Co
 	return getCookie(rec, "browserToken")
 }
 
+// Insert a user directly into the database, for when the registration route
+// is unavailable
+func (ts *TestSuite) InsertTestUser(app *App, username string) *User {
+	passwordSalt := Unwrap(RandomHex(16))
+	user := User{
+		IsAdmin:           Contains(app.Config().DefaultAdmins, username),
+		UUID:              uuid.New().String(),
+		Username:          username,
+		PasswordSalt:      []byte(passwordSalt),
+		PasswordHash:      Unwrap(HashPassword(TEST_PASSWORD, []byte(passwordSalt))),
+		PlayerName:        username,
+		OfflineUUID:       Unwrap(OfflineUUID(username)),
+		FallbackPlayer:    username,
+		PreferredLanguage: app.Config().DefaultPreferredLanguage,
+		SkinModel:         SkinModelClassic,
+		CreatedAt:         time.Now(),
+		NameLastChangedAt: time.Now(),
+	}
+	Check(app.DB.Create(&user).Error)
+	return &user
+}
+
 func (ts *TestSuite) Get(t *testing.T, server *echo.Echo, path string, cookies []http.Cookie, accessToken *string) *httptest.ResponseRecorder {
 	req := httptest.NewRequest(http.MethodGet, path, nil)
 	for _, cookie := range cookies {
@@ -204,19 +238,41 @@ func (ts *TestSuite) PostJSON(t *testing.T, server *echo.Echo, path string, payl
 	assert.Nil(t, err)
 	req := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
 	req.Header.Add("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(&cookie)
+	}
+	if accessToken != nil {
+		req.Header.Add("Authorization", "Bearer "+*accessToken)
+	}
 	rec := httptest.NewRecorder()
 	server.ServeHTTP(rec, req)
 	ts.CheckAuthlibInjectorHeader(t, ts.App, rec)
 	return rec
+}
 
+func (ts *TestSuite) PutJSON(t *testing.T, server *echo.Echo, path string, payload interface{}, cookies []http.Cookie, accessToken *string) *httptest.ResponseRecorder {
+	body, err := json.Marshal(payload)
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPut, path, bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(&cookie)
+	}
+	if accessToken != nil {
+		req.Header.Add("Authorization", "Bearer "+*accessToken)
+	}
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+	ts.CheckAuthlibInjectorHeader(t, ts.App, rec)
+	return rec
 }
 
 func testConfig() *Config {
 	config := DefaultConfig()
 	config.BaseURL = "https://drasl.example.com"
 	config.Domain = "drasl.example.com"
-	noRateLimit := rateLimitConfig{Enable: false}
-	config.RateLimit = noRateLimit
+	config.RateLimit.Enable = false
+	config.AuthenticateThrottle.Enable = false
 	config.FallbackAPIServers = []FallbackAPIServer{}
 	config.LogRequests = false
 	config.TestMode = true
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+/*
+AllowedTextureDomains limits where skins and capes may come from when Drasl
+takes them from a URL: a skin or cape URL entered on the profile page, a skin
+or cape imported from a linked account, a skin forwarded from a fallback API
+server with ForwardSkins, and a texture copied by ProxyTextures. Otherwise Drasl could be made to download, store, and
+re-sign textures from any host. Drasl's own texture URLs are always allowed.
+An empty list allows every domain.
+*/
+
+// Whether Drasl may take a texture from textureURL
+func (app *App) IsAllowedTextureURL(textureURL string) bool {
+	if len(app.Config().AllowedTextureDomains) == 0 {
+		return true
+	}
+	parsed, err := url.Parse(textureURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	host := parsed.Hostname()
+	for _, ownURL := range []string{app.TextureURL, app.FrontEndURL} {
+		own, err := url.Parse(ownURL)
+		if err == nil && own.Hostname() == host {
+			return true
+		}
+	}
+	return MatchesSkinDomain(host, app.Config().AllowedTextureDomains)
+}
+
+var errTextureDomainNotAllowed = errors.New("textures can't be downloaded from that domain")
+
+// An HTTP client for downloading textures that won't follow redirects
+// outside AllowedTextureDomains
+func (app *App) MakeTextureHTTPClient() *http.Client {
+	client := app.MakeHTTPClient()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !app.IsAllowedTextureURL(req.URL.String()) {
+			return errTextureDomainNotAllowed
+		}
+		return nil
+	}
+	return client
+}
+
+// Download a texture from textureURL, checking it against
+// AllowedTextureDomains
+func (app *App) GetTexture(textureURL string) (*http.Response, error) {
+	if !app.IsAllowedTextureURL(textureURL) {
+		return nil, errTextureDomainNotAllowed
+	}
+	res, err := app.MakeTextureHTTPClient().Get(textureURL)
+	if errors.Is(err, errTextureDomainNotAllowed) {
+		return nil, errTextureDomainNotAllowed
+	}
+	return res, err
+}
+
+// Whether every skin and cape in a textures property is allowed
+func (app *App) texturesPropertyAllowed(property *SessionProfileProperty) (bool, error) {
+	valueBlob, err := base64.StdEncoding.DecodeString(property.Value)
+	if err != nil {
+		return false, err
+	}
+	var value texturesValue
+	if err := json.Unmarshal(valueBlob, &value); err != nil {
+		return false, err
+	}
+	for _, texture := range []*texture{value.Textures.Skin, value.Textures.Cape} {
+		if texture != nil && !app.IsAllowedTextureURL(texture.URL) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
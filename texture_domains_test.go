@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestTextureDomains(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		auxConfig := testConfig()
+		ts.SetupAux(auxConfig)
+
+		config := testConfig()
+		config.ForwardSkins = true
+		config.FallbackAPIServers = []FallbackAPIServer{ts.ToFallbackAPIServer(ts.AuxApp, "Aux")}
+		config.AllowedTextureDomains = []string{"textures.example.com", ".example.org"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test IsAllowedTextureURL", ts.testIsAllowedTextureURL)
+		t.Run("Test AllowedTextureDomains", ts.testAllowedTextureDomains)
+	}
+}
+
+func (ts *TestSuite) testIsAllowedTextureURL(t *testing.T) {
+	assert.True(t, ts.App.IsAllowedTextureURL("https://textures.example.com/skin.png"))
+	assert.True(t, ts.App.IsAllowedTextureURL("http://skins.example.org/skin.png"))
+	assert.False(t, ts.App.IsAllowedTextureURL("https://example.org/skin.png"))
+	assert.False(t, ts.App.IsAllowedTextureURL("https://textures.example.com.evil.com/skin.png"))
+	assert.False(t, ts.App.IsAllowedTextureURL("file:///etc/passwd"))
+	// Drasl's own textures are always allowed
+	assert.True(t, ts.App.IsAllowedTextureURL(Unwrap(SkinURL(ts.App, "abc"))))
+}
+
+func (ts *TestSuite) testAllowedTextureDomains(t *testing.T) {
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+	ts.CreateTestUser(ts.AuxServer, TEST_USERNAME)
+
+	var auxUser User
+	assert.Nil(t, ts.AuxApp.DB.First(&auxUser, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, SetSkinAndSave(ts.AuxApp, &auxUser, bytes.NewReader(RED_SKIN)))
+	auxSkinURL := Unwrap(SkinURL(ts.AuxApp, *UnmakeNullString(&auxUser.SkinHash)))
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Nil(t, ts.App.DB.Model(&user).Update("fallback_player", TEST_USERNAME).Error)
+
+	// The aux server's skins aren't forwarded
+	property, err := GetFallbackSkinTexturesProperty(ts.App, &user)
+	assert.Nil(t, err)
+	assert.Nil(t, property)
+
+	// Or downloaded from a URL
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("skinUrl", auxSkinURL)
+	writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	assert.Nil(t, writer.Close())
+	rec := ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+	ts.updateShouldFail(t, rec, "Skins can't be downloaded from that domain.", ts.App.FrontEndURL+"/drasl/profile")
+
+	// Until their domain is allowed
+	auxTextureURL := Unwrap(url.Parse(ts.AuxApp.TextureURL))
+	ts.App.Config().AllowedTextureDomains = []string{auxTextureURL.Hostname()}
+	defer func() {
+		ts.App.Config().AllowedTextureDomains = []string{"textures.example.com", ".example.org"}
+	}()
+	property, err = GetFallbackSkinTexturesProperty(ts.App, &user)
+	assert.Nil(t, err)
+	assert.NotNil(t, property)
+
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	writer.WriteField("skinUrl", auxSkinURL)
+	writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	assert.Nil(t, writer.Close())
+	rec = ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*browserTokenCookie}, nil)
+	ts.updateShouldSucceed(t, rec)
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.Equal(t, auxUser.SkinHash, user.SkinHash)
+}
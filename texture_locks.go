@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Admins can stop a user from changing their own skin or cape, e.g. to enforce
+a staff uniform or after a moderation action. While a user's skin is locked,
+they can't upload, remove, import, or roll back their skin, and skin rotation
+leaves it alone; while their cape is locked, they can't change or hide their
+cape, by upload, cosmetic, gift code, import, or rollback. Admins can still
+change a locked user's skin and cape from their profile page.
+*/
+
+var errSkinLocked = errors.New("An admin has locked your skin, so you can't change it.")
+var errCapeLocked = errors.New("An admin has locked your cape, so you can't change it.")
+
+func lockedString(locked bool) string {
+	if locked {
+		return "locked"
+	}
+	return "unlocked"
+}
+
+func (app *App) SetTextureLocks(admin *User, user *User, skinLocked bool, capeLocked bool) error {
+	if user.SkinLocked == skinLocked && user.CapeLocked == capeLocked {
+		return nil
+	}
+	user.SkinLocked = skinLocked
+	user.CapeLocked = capeLocked
+	err := app.DB.Model(user).Updates(map[string]interface{}{
+		"skin_locked": skinLocked,
+		"cape_locked": capeLocked,
+	}).Error
+	if err != nil {
+		return err
+	}
+	details := fmt.Sprintf("skin %s, cape %s", lockedString(skinLocked), lockedString(capeLocked))
+	return app.LogAudit(admin, AuditActionSetTextureLocks, user, details)
+}
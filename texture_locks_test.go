@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTextureLocks(t *testing.T) {
+	{
+		ts := &TestSuite{}
+
+		config := testConfig()
+		config.DefaultAdmins = []string{"admin"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test texture locks", ts.testTextureLocks)
+	}
+}
+
+func (ts *TestSuite) testTextureLocks(t *testing.T) {
+	adminBrowserTokenCookie := ts.CreateTestUser(ts.Server, "admin")
+	browserTokenCookie := ts.CreateTestUser(ts.Server, TEST_USERNAME)
+
+	var admin User
+	assert.Nil(t, ts.App.DB.First(&admin, "username = ?", "admin").Error)
+
+	setLocks := func(username string, skinLocked bool, capeLocked bool) string {
+		form := url.Values{}
+		form.Set("username", username)
+		if skinLocked {
+			form.Set("skinLocked", "on")
+		}
+		if capeLocked {
+			form.Set("capeLocked", "on")
+		}
+		form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile?user="+username)
+		rec := ts.PostForm(t, ts.Server, "/drasl/admin/set-texture-locks", form, []http.Cookie{*adminBrowserTokenCookie}, nil)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		return getErrorMessage(rec)
+	}
+
+	assert.Equal(t, "User not found.", setLocks("nonexistent", true, false))
+
+	// Only admins can set locks
+	form := url.Values{}
+	form.Set("username", TEST_USERNAME)
+	form.Set("skinLocked", "on")
+	form.Set("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+	rec := ts.PostForm(t, ts.Server, "/drasl/admin/set-texture-locks", form, []http.Cookie{*browserTokenCookie}, nil)
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "You are not an admin.", getErrorMessage(rec))
+
+	assert.Equal(t, "", setLocks(TEST_USERNAME, true, false))
+
+	var user User
+	assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+	assert.True(t, user.SkinLocked)
+	assert.False(t, user.CapeLocked)
+
+	var auditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&auditLogEntry, "action = ?", AuditActionSetTextureLocks).Error)
+	assert.Equal(t, admin.UUID, auditLogEntry.ActorUUID)
+	assert.Equal(t, "skin locked, cape unlocked", auditLogEntry.Details)
+
+	updateSkin := func(cookie *http.Cookie, username string) *httptest.ResponseRecorder {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		skinFileField, err := writer.CreateFormFile("skinFile", "redSkin.png")
+		assert.Nil(t, err)
+		_, err = skinFileField.Write(RED_SKIN)
+		assert.Nil(t, err)
+		writer.WriteField("username", username)
+		writer.WriteField("returnUrl", ts.App.FrontEndURL+"/drasl/profile")
+		return ts.PostMultipart(t, ts.Server, "/drasl/update", body, writer, []http.Cookie{*cookie}, nil)
+	}
+
+	{
+		// The user can't change their locked skin from the web front end
+		rec := updateSkin(browserTokenCookie, TEST_USERNAME)
+		ts.updateShouldFail(t, rec, errSkinLocked.Error(), ts.App.FrontEndURL+"/drasl/profile")
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		assert.Nil(t, UnmakeNullString(&user.SkinHash))
+	}
+	{
+		// ...or from the services API
+		accessToken := ts.authenticate(t, TEST_USERNAME, TEST_PASSWORD).AccessToken
+
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		writer.WriteField("variant", "slim")
+		skinFileField, err := writer.CreateFormFile("file", "redSkin.png")
+		assert.Nil(t, err)
+		_, err = skinFileField.Write(RED_SKIN)
+		assert.Nil(t, err)
+		rec := ts.PostMultipart(t, ts.Server, "/minecraft/profile/skins", body, writer, nil, &accessToken)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		var response ErrorResponse
+		assert.Nil(t, json.NewDecoder(rec.Body).Decode(&response))
+		assert.Equal(t, errSkinLocked.Error(), *response.ErrorMessage)
+
+		// The cape isn't locked, so it can still be hidden
+		req := httptest.NewRequest(http.MethodDelete, "/minecraft/profile/capes/active", nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec = httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Until it is
+		assert.Equal(t, "", setLocks(TEST_USERNAME, true, true))
+		req = httptest.NewRequest(http.MethodDelete, "/minecraft/profile/capes/active", nil)
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+		rec = httptest.NewRecorder()
+		ts.Server.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+	{
+		// Admins can still change a locked skin
+		rec := updateSkin(adminBrowserTokenCookie, TEST_USERNAME)
+		assert.Equal(t, http.StatusSeeOther, rec.Code)
+		assert.Equal(t, "", getErrorMessage(rec))
+		assert.Nil(t, ts.App.DB.First(&user, "username = ?", TEST_USERNAME).Error)
+		assert.NotNil(t, UnmakeNullString(&user.SkinHash))
+	}
+
+	// Unlocking lets the user change their skin again
+	assert.Equal(t, "", setLocks(TEST_USERNAME, false, false))
+	rec = updateSkin(browserTokenCookie, TEST_USERNAME)
+	ts.updateShouldSucceed(t, rec)
+
+	var lastAuditLogEntry AuditLogEntry
+	assert.Nil(t, ts.App.DB.Last(&lastAuditLogEntry, "action = ?", AuditActionSetTextureLocks).Error)
+	assert.Equal(t, "skin unlocked, cape unlocked", lastAuditLogEntry.Details)
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"sync"
+)
+
+/*
+Skins and capes uploaded on the web front end are validated, hashed, and
+written to disk by a pool of workers, so a burst of uploads can't tie up the
+request handlers. The handler only reads the upload into memory and queues it.
+Each user has at most one queued skin and one queued cape; a newer upload, or
+deleting the texture, replaces the queued one. Statuses are kept in memory
+and are lost on restart, along with any queued uploads.
+*/
+
+const (
+	TextureTypeSkin = "skin"
+	TextureTypeCape = "cape"
+)
+
+const (
+	TextureStatePending    = "pending"
+	TextureStateProcessing = "processing"
+	TextureStateFailed     = "failed"
+)
+
+var ErrTextureQueueFull = errors.New("texture queue is full")
+
+type TextureStatus struct {
+	State string
+	// Why the texture couldn't be used, if State is TextureStateFailed
+	Error string
+}
+
+type textureJob struct {
+	ID          uint64
+	UserUUID    string
+	TextureType string
+	Data        []byte
+	// For skins, whether to set the user's skin model to the one detected
+	// from the skin
+	DetectSkinModel bool
+}
+
+type textureJobEntry struct {
+	ID     uint64
+	Status TextureStatus
+}
+
+type TextureQueue struct {
+	app    *App
+	jobs   chan textureJob
+	mutex  sync.Mutex
+	nextID uint64
+	// Keyed by textureJobKey
+	entries map[string]*textureJobEntry
+}
+
+func textureJobKey(userUUID string, textureType string) string {
+	return textureType + ":" + userUUID
+}
+
+func NewTextureQueue(app *App) *TextureQueue {
+	queue := &TextureQueue{
+		app:     app,
+		jobs:    make(chan textureJob, app.Config().TextureQueue.QueueSize),
+		entries: map[string]*textureJobEntry{},
+	}
+	for i := 0; i < app.Config().TextureQueue.Workers; i += 1 {
+		go queue.work()
+	}
+	return queue
+}
+
+// Read an uploaded texture into memory, to be queued
+func ReadTextureUpload(reader io.Reader) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	_, err := buf.ReadFrom(io.LimitReader(reader, MAX_TEXTURE_FILE_SIZE))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Queue a new skin or cape for the user, replacing any that's already queued.
+// Returns ErrTextureQueueFull if the workers are too far behind.
+func (queue *TextureQueue) Enqueue(user *User, textureType string, data []byte, detectSkinModel bool) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.nextID += 1
+	job := textureJob{
+		ID:              queue.nextID,
+		UserUUID:        user.UUID,
+		TextureType:     textureType,
+		Data:            data,
+		DetectSkinModel: detectSkinModel && textureType == TextureTypeSkin,
+	}
+	select {
+	case queue.jobs <- job:
+	default:
+		return ErrTextureQueueFull
+	}
+	queue.entries[textureJobKey(user.UUID, textureType)] = &textureJobEntry{
+		ID:     job.ID,
+		Status: TextureStatus{State: TextureStatePending},
+	}
+	return nil
+}
+
+// Remove the user's skin or cape, and drop any that's queued
+func (queue *TextureQueue) Delete(user *User, textureType string) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	delete(queue.entries, textureJobKey(user.UUID, textureType))
+	oldHash, err := queue.setHash(user.UUID, textureType, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := queue.app.RecordAppearance(user.UUID); err != nil {
+		return err
+	}
+	return queue.deleteIfUnused(textureType, oldHash)
+}
+
+// Drop the user's queued skin and cape, e.g. because they're being deleted
+func (queue *TextureQueue) Drop(userUUID string) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	delete(queue.entries, textureJobKey(userUUID, TextureTypeSkin))
+	delete(queue.entries, textureJobKey(userUUID, TextureTypeCape))
+}
+
+// The status of the user's queued skin or cape, or nil if there's nothing
+// queued. A failure is reported only once.
+func (queue *TextureQueue) Status(user *User, textureType string) *TextureStatus {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	key := textureJobKey(user.UUID, textureType)
+	entry, ok := queue.entries[key]
+	if !ok {
+		return nil
+	}
+	status := entry.Status
+	if status.State == TextureStateFailed {
+		delete(queue.entries, key)
+	}
+	return &status
+}
+
+func (queue *TextureQueue) work() {
+	for job := range queue.jobs {
+		queue.process(job)
+	}
+}
+
+// Whether job is the latest for its user and texture, i.e. it hasn't been
+// replaced or dropped. Call with the mutex held.
+func (queue *TextureQueue) isCurrent(job textureJob) bool {
+	entry, ok := queue.entries[textureJobKey(job.UserUUID, job.TextureType)]
+	return ok && entry.ID == job.ID
+}
+
+func (queue *TextureQueue) setState(job textureJob, status TextureStatus) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if queue.isCurrent(job) {
+		queue.entries[textureJobKey(job.UserUUID, job.TextureType)].Status = status
+	}
+}
+
+// Set the user's texture hash, and optionally their skin model, in the
+// database, returning the old hash. Call with the mutex held.
+func (queue *TextureQueue) setHash(userUUID string, textureType string, hash *string, skinModel *string) (*string, error) {
+	var user User
+	if err := queue.app.DB.First(&user, "uuid = ?", userUUID).Error; err != nil {
+		return nil, err
+	}
+	var oldHash *string
+	var column string
+	if textureType == TextureTypeSkin {
+		oldHash = UnmakeNullString(&user.SkinHash)
+		column = "skin_hash"
+	} else {
+		oldHash = UnmakeNullString(&user.CapeHash)
+		column = "cape_hash"
+	}
+	updates := map[string]interface{}{column: MakeNullString(hash)}
+	if skinModel != nil {
+		updates["skin_model"] = *skinModel
+	}
+	if err := queue.app.DB.Model(&user).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return oldHash, nil
+}
+
+func (queue *TextureQueue) deleteIfUnused(textureType string, hash *string) error {
+	if textureType == TextureTypeSkin {
+		return DeleteSkinIfUnused(queue.app, hash)
+	}
+	return DeleteCapeIfUnused(queue.app, hash)
+}
+
+func (queue *TextureQueue) process(job textureJob) {
+	app := queue.app
+
+	queue.mutex.Lock()
+	current := queue.isCurrent(job)
+	queue.mutex.Unlock()
+	if !current {
+		return
+	}
+	queue.setState(job, TextureStatus{State: TextureStateProcessing})
+
+	// As in FrontUpdate, validate and hash the texture, then update the
+	// database, then write the texture to disk
+	var validHandle io.Reader
+	var err error
+	if job.TextureType == TextureTypeSkin {
+		validHandle, err = ValidateSkin(app, bytes.NewReader(job.Data))
+	} else {
+		validHandle, err = ValidateCape(app, bytes.NewReader(job.Data))
+	}
+	if err != nil {
+		queue.setState(job, TextureStatus{State: TextureStateFailed, Error: err.Error()})
+		return
+	}
+	buf, hash, err := ReadTexture(app, validHandle)
+	if err != nil {
+		queue.fail(job, err)
+		return
+	}
+
+	var skinModel *string
+	if job.DetectSkinModel {
+		model, err := DetectSkinModelPNG(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			queue.fail(job, err)
+			return
+		}
+		skinModel = &model
+	}
+
+	queue.mutex.Lock()
+	if !queue.isCurrent(job) {
+		queue.mutex.Unlock()
+		return
+	}
+	oldHash, err := queue.setHash(job.UserUUID, job.TextureType, &hash, skinModel)
+	// If the user was deleted while the texture was queued, there's nothing
+	// left to do
+	if err == nil || errors.Is(err, gorm.ErrRecordNotFound) {
+		delete(queue.entries, textureJobKey(job.UserUUID, job.TextureType))
+	}
+	queue.mutex.Unlock()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		queue.fail(job, err)
+		return
+	}
+
+	if job.TextureType == TextureTypeSkin {
+		err = WriteSkin(app, hash, buf)
+	} else {
+		err = WriteCape(app, hash, buf)
+	}
+	if err != nil {
+		queue.logError(job, err)
+		return
+	}
+	if err := app.RecordAppearance(job.UserUUID); err != nil {
+		queue.logError(job, err)
+	}
+	if err := queue.deleteIfUnused(job.TextureType, oldHash); err != nil {
+		queue.logError(job, err)
+	}
+}
+
+func (queue *TextureQueue) logError(job textureJob, err error) {
+	log.Printf("Couldn't process %s for user %s: %s\n", job.TextureType, job.UserUUID, err)
+}
+
+// Mark the job as failed because of an unexpected error
+func (queue *TextureQueue) fail(job textureJob, err error) {
+	queue.logError(job, err)
+	queue.setState(job, TextureStatus{State: TextureStateFailed, Error: "unexpected error"})
+}
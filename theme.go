@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*
+Themes let operators override any template or static asset of the web front
+end without forking. A theme is a directory `<StateDirectory>/themes/<Theme>`
+mirroring the layout of the DataDirectory, e.g. `view/footer.tmpl` or
+`public/style.css`. Files missing from the theme fall back to the defaults.
+*/
+
+func GetThemeDirectory(config *Config) string {
+	if config.Theme == "" {
+		return ""
+	}
+	return path.Join(config.StateDirectory, "themes", config.Theme)
+}
+
+// Return the path to `filename` in `subdirectory` of the theme if the theme
+// provides it, otherwise the path to the default file in the DataDirectory
+func GetThemedPath(app *App, subdirectory string, filename string) string {
+	themeDirectory := GetThemeDirectory(app.Config())
+	if themeDirectory != "" {
+		themedPath := path.Join(themeDirectory, subdirectory, filename)
+		if info, err := os.Stat(themedPath); err == nil && !info.IsDir() {
+			return themedPath
+		}
+	}
+	return path.Join(app.Config().DataDirectory, subdirectory, filename)
+}
+
+// Serve static files from `subdirectory`, preferring the theme's version of
+// each file. Like echo's Static, but layered.
+func ThemedStatic(app *App, subdirectory string) func(c echo.Context) error {
+	return func(c echo.Context) error {
+		p, err := url.PathUnescape(c.Param("*"))
+		if err != nil {
+			return err
+		}
+		// Prevent directory traversal
+		name := filepath.Clean("/" + p)
+		return c.File(GetThemedPath(app, subdirectory, name))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+	"lukechampine.com/blake3"
+	"sync"
+	"time"
+)
+
+/*
+Brute-force protection for the Yggdrasil /authenticate endpoint. The generic
+rate limiter doesn't cover the Yggdrasil API, and launchers stuck retrying
+with a stale password shouldn't be able to lock anyone out, so /authenticate
+gets its own per-IP limit and, optionally, a per-account tarpit that slows
+down repeated wrong passwords instead of refusing them.
+*/
+
+// Failures older than this are forgotten
+const AUTHENTICATE_FAILURE_WINDOW = 15 * time.Minute
+
+type accountFailures struct {
+	Count       int
+	LastFailure time.Time
+	// Hash of the last wrong password, so a launcher retrying the same one
+	// in a loop only counts once
+	LastPasswordSum [32]byte
+}
+
+type AuthenticateThrottle struct {
+	config   *authenticateThrottleConfig
+	ipStore  *middleware.RateLimiterMemoryStore
+	mutex    sync.Mutex
+	accounts map[string]*accountFailures
+	key      []byte
+}
+
+func NewAuthenticateThrottle(config *authenticateThrottleConfig, key []byte) *AuthenticateThrottle {
+	perSecond := float64(config.RequestsPerMinute) / 60
+	return &AuthenticateThrottle{
+		config: config,
+		ipStore: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(perSecond),
+			Burst:     config.RequestsPerMinute,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		accounts: map[string]*accountFailures{},
+		key:      key,
+	}
+}
+
+// Whether another /authenticate request from the client with the given
+// RateLimitKey is allowed right now
+func (throttle *AuthenticateThrottle) AllowIP(key string) bool {
+	allowed, err := throttle.ipStore.Allow(key)
+	return err == nil && allowed
+}
+
+// Get the account's failures, or nil if there are none recent. Must be
+// called with the mutex held.
+func (throttle *AuthenticateThrottle) getFailures(userUUID string) *accountFailures {
+	failures, ok := throttle.accounts[userUUID]
+	if !ok {
+		return nil
+	}
+	if time.Since(failures.LastFailure) > AUTHENTICATE_FAILURE_WINDOW {
+		delete(throttle.accounts, userUUID)
+		return nil
+	}
+	return failures
+}
+
+// How long to hold an attempt to log in to the account before checking its
+// password. Doubles with each failure beyond Tarpit.FreeFailures, up to
+// Tarpit.MaxDelaySec.
+func (throttle *AuthenticateThrottle) Delay(userUUID string) time.Duration {
+	if !throttle.config.Tarpit.Enable {
+		return 0
+	}
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	failures := throttle.getFailures(userUUID)
+	if failures == nil || failures.Count < throttle.config.Tarpit.FreeFailures {
+		return 0
+	}
+	maxDelay := time.Duration(throttle.config.Tarpit.MaxDelaySec) * time.Second
+	delay := time.Second
+	for i := throttle.config.Tarpit.FreeFailures; i < failures.Count && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay
+}
+
+func (throttle *AuthenticateThrottle) RecordFailure(userUUID string, password string) {
+	sum := blake3.Sum256(append([]byte(password+"\x00"+userUUID+"\x00"), throttle.key...))
+
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	failures := throttle.getFailures(userUUID)
+	if failures == nil {
+		failures = &accountFailures{}
+		throttle.accounts[userUUID] = failures
+	} else if failures.LastPasswordSum == sum {
+		failures.LastFailure = time.Now()
+		return
+	}
+	failures.Count += 1
+	failures.LastFailure = time.Now()
+	failures.LastPasswordSum = sum
+}
+
+func (throttle *AuthenticateThrottle) RecordSuccess(userUUID string) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+	delete(throttle.accounts, userUUID)
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+If Tracing.Enable is set, Drasl records OpenTelemetry spans and exports them
+over OTLP/HTTP, using the JSON encoding, to Tracing.OTLPEndpoint, e.g. an
+OpenTelemetry Collector, Jaeger, or Grafana Tempo. Every request gets a server
+span, continuing the trace from an incoming W3C traceparent header if there is
+one. Database queries and outgoing HTTP requests, e.g. to fallback API
+servers, made with the request's context get child spans, and the trace is
+passed on to the server being requested in the traceparent header.
+*/
+
+const TRACING_EXPORT_INTERVAL = 5 * time.Second
+const TRACING_EXPORT_TIMEOUT = 10 * time.Second
+
+// Spans are exported early once this many are waiting
+const TRACING_BATCH_SIZE = 512
+
+// Spans are dropped rather than queued past this, e.g. if the collector is
+// down
+const TRACING_MAX_QUEUED_SPANS = 8 * TRACING_BATCH_SIZE
+
+const (
+	SPAN_KIND_SERVER = 2
+	SPAN_KIND_CLIENT = 3
+)
+
+const SPAN_STATUS_ERROR = 2
+
+var traceparentRegex = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+type Tracer struct {
+	TracesURL   string
+	Headers     map[string]string
+	ServiceName string
+	SampleRatio float64
+	Client      *http.Client
+	mutex       sync.Mutex
+	queue       []*Span
+}
+
+// A span is only touched by the goroutine that started it until it's ended.
+// All methods are no-ops on a nil span, which is what StartSpan returns when
+// the trace isn't being recorded.
+type Span struct {
+	tracer        *Tracer
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	Name          string
+	Kind          int
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	StatusCode    int
+	StatusMessage string
+}
+
+type spanContextKey struct{}
+
+func NewTracer(config *tracingConfig) (*Tracer, error) {
+	tracesURL, err := url.JoinPath(config.OTLPEndpoint, "v1/traces")
+	if err != nil {
+		return nil, err
+	}
+	tracer := &Tracer{
+		TracesURL:   tracesURL,
+		Headers:     config.Headers,
+		ServiceName: config.ServiceName,
+		SampleRatio: config.SampleRatio,
+		Client:      &http.Client{Timeout: TRACING_EXPORT_TIMEOUT},
+	}
+	go func() {
+		for range time.Tick(TRACING_EXPORT_INTERVAL) {
+			if err := tracer.Flush(); err != nil {
+				log.Printf("Couldn't export traces: %s\n", err)
+			}
+		}
+	}()
+	return tracer, nil
+}
+
+// Parse a W3C traceparent header, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(header string) (traceID string, parentSpanID string, sampled bool, ok bool) {
+	match := traceparentRegex.FindStringSubmatch(strings.TrimSpace(header))
+	if match == nil || match[1] == "ff" {
+		return "", "", false, false
+	}
+	traceID, parentSpanID = match[2], match[3]
+	if traceID == strings.Repeat("0", 32) || parentSpanID == strings.Repeat("0", 16) {
+		return "", "", false, false
+	}
+	flags, err := strconv.ParseUint(match[4], 16, 8)
+	if err != nil {
+		return "", "", false, false
+	}
+	return traceID, parentSpanID, flags&1 == 1, true
+}
+
+func (tracer *Tracer) newSpan(traceID string, parentSpanID string, name string, kind int) *Span {
+	spanID, err := RandomHex(8)
+	if err != nil {
+		log.Printf("Couldn't start span: %s\n", err)
+		return nil
+	}
+	return &Span{
+		tracer:       tracer,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Name:         name,
+		Kind:         kind,
+		StartTime:    time.Now(),
+		Attributes:   map[string]interface{}{},
+	}
+}
+
+// Start a span with no parent in this process. If traceparent is a valid
+// W3C traceparent header, the span continues that trace, and is recorded if
+// the caller recorded its own span. Otherwise, a new trace is started and
+// recorded with probability Tracing.SampleRatio.
+func (tracer *Tracer) StartRootSpan(ctx context.Context, name string, kind int, traceparent string) (context.Context, *Span) {
+	traceID, parentSpanID, sampled, ok := parseTraceparent(traceparent)
+	if !ok {
+		sampled = rand.Float64() < tracer.SampleRatio
+		var err error
+		traceID, err = RandomHex(16)
+		if err != nil {
+			log.Printf("Couldn't start trace: %s\n", err)
+			return ctx, nil
+		}
+	}
+	if !sampled {
+		return ctx, nil
+	}
+	span := tracer.newSpan(traceID, parentSpanID, name, kind)
+	if span == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// Start a child of the span in ctx. Returns a nil span, and ctx unchanged, if
+// there isn't one.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.tracer.newSpan(parent.TraceID, parent.SpanID, name, kind)
+	if span == nil {
+		return ctx, nil
+	}
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+func (span *Span) SetAttribute(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	span.Attributes[key] = value
+}
+
+func (span *Span) SetError(err error) {
+	if span == nil {
+		return
+	}
+	span.StatusCode = SPAN_STATUS_ERROR
+	span.StatusMessage = scrubText(err.Error())
+}
+
+// The traceparent header to send to the next server in the trace
+func (span *Span) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", span.TraceID, span.SpanID)
+}
+
+// End the span and queue it for export
+func (span *Span) End() {
+	if span == nil {
+		return
+	}
+	span.EndTime = time.Now()
+	span.tracer.record(span)
+}
+
+func (tracer *Tracer) record(span *Span) {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	if len(tracer.queue) >= TRACING_MAX_QUEUED_SPANS {
+		return
+	}
+	tracer.queue = append(tracer.queue, span)
+	if len(tracer.queue) == TRACING_BATCH_SIZE {
+		go func() {
+			if err := tracer.Flush(); err != nil {
+				log.Printf("Couldn't export traces: %s\n", err)
+			}
+		}()
+	}
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTracesRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func makeOTLPKeyValue(key string, value interface{}) otlpKeyValue {
+	var anyValue otlpAnyValue
+	switch v := value.(type) {
+	case bool:
+		anyValue.BoolValue = &v
+	case int:
+		anyValue.IntValue = Ptr(strconv.Itoa(v))
+	case int64:
+		anyValue.IntValue = Ptr(strconv.FormatInt(v, 10))
+	case float64:
+		anyValue.DoubleValue = &v
+	default:
+		anyValue.StringValue = Ptr(fmt.Sprint(v))
+	}
+	return otlpKeyValue{Key: key, Value: anyValue}
+}
+
+func makeOTLPTracesRequest(serviceName string, spans []*Span) otlpTracesRequest {
+	scopeSpans := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scopeSpans.Scope.Name = "drasl"
+	scopeSpans.Scope.Version = Constants.Version
+	for _, span := range spans {
+		attributes := make([]otlpKeyValue, 0, len(span.Attributes))
+		for key, value := range span.Attributes {
+			attributes = append(attributes, makeOTLPKeyValue(key, value))
+		}
+		scopeSpans.Spans = append(scopeSpans.Spans, otlpSpan{
+			TraceID:           span.TraceID,
+			SpanID:            span.SpanID,
+			ParentSpanID:      span.ParentSpanID,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        attributes,
+			Status:            otlpStatus{Code: span.StatusCode, Message: span.StatusMessage},
+		})
+	}
+
+	resourceSpans := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scopeSpans}}
+	resourceSpans.Resource.Attributes = []otlpKeyValue{
+		makeOTLPKeyValue("service.name", serviceName),
+		makeOTLPKeyValue("service.version", Constants.Version),
+	}
+	return otlpTracesRequest{ResourceSpans: []otlpResourceSpans{resourceSpans}}
+}
+
+// Export all queued spans now
+func (tracer *Tracer) Flush() error {
+	tracer.mutex.Lock()
+	spans := tracer.queue
+	tracer.queue = nil
+	tracer.mutex.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(makeOTLPTracesRequest(tracer.ServiceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, tracer.TracesURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range tracer.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := tracer.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("OTLP endpoint responded with %s", res.Status)
+	}
+	return nil
+}
+
+func makeTracingMiddleware(app *App) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			ctx, span := app.Tracer.StartRootSpan(req.Context(), req.Method+" "+c.Path(), SPAN_KIND_SERVER, req.Header.Get("traceparent"))
+			if span == nil {
+				return next(c)
+			}
+			c.SetRequest(req.WithContext(ctx))
+
+			err := next(c)
+
+			// The error handler hasn't run yet, so work out the status it
+			// will send
+			status := c.Response().Status
+			if err != nil {
+				status = http.StatusInternalServerError
+				var httpError *echo.HTTPError
+				if errors.As(err, &httpError) {
+					status = httpError.Code
+				}
+			}
+			span.SetAttribute("http.request.method", req.Method)
+			span.SetAttribute("http.route", c.Path())
+			span.SetAttribute("url.path", req.URL.Path)
+			span.SetAttribute("client.address", c.RealIP())
+			span.SetAttribute("user_agent.original", req.UserAgent())
+			span.SetAttribute("http.response.status_code", status)
+			if status >= 500 {
+				if err == nil {
+					err = errors.New(http.StatusText(status))
+				}
+				span.SetError(err)
+			}
+			span.End()
+			return err
+		}
+	}
+}
+
+// Records a client span for each request made with a context that has a
+// span, and passes the trace on in the traceparent header
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (transport *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartSpan(req.Context(), req.Method, SPAN_KIND_CLIENT)
+	if span == nil {
+		return transport.base.RoundTrip(req)
+	}
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.Traceparent())
+
+	fullURL := *req.URL
+	fullURL.RawQuery = scrubQuery(req.URL.Query())
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("url.full", fullURL.String())
+	span.SetAttribute("server.address", req.URL.Hostname())
+
+	res, err := transport.base.RoundTrip(req)
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute("http.response.status_code", res.StatusCode)
+		if res.StatusCode >= 500 {
+			span.SetError(errors.New(res.Status))
+		}
+	}
+	span.End()
+	return res, err
+}
+
+const DB_SPAN_KEY = "drasl:span"
+
+func startDBSpan(db *gorm.DB) {
+	if db.Statement.Context == nil {
+		return
+	}
+	_, span := StartSpan(db.Statement.Context, "db", SPAN_KIND_CLIENT)
+	if span == nil {
+		return
+	}
+	db.InstanceSet(DB_SPAN_KEY, span)
+}
+
+func endDBSpan(db *gorm.DB) {
+	value, ok := db.InstanceGet(DB_SPAN_KEY)
+	if !ok {
+		return
+	}
+	span := value.(*Span)
+
+	// Name the span like "SELECT users"
+	statement := db.Statement.SQL.String()
+	if fields := strings.Fields(statement); len(fields) > 0 {
+		span.Name = strings.ToUpper(fields[0])
+		span.SetAttribute("db.operation.name", span.Name)
+	}
+	if db.Statement.Table != "" {
+		span.Name += " " + db.Statement.Table
+		span.SetAttribute("db.collection.name", db.Statement.Table)
+	}
+	span.SetAttribute("db.system", "sqlite")
+	// Values are bound separately, so the statement only has placeholders
+	span.SetAttribute("db.query.text", statement)
+	span.SetAttribute("db.rows_affected", db.Statement.RowsAffected)
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.SetError(db.Error)
+	}
+	span.End()
+}
+
+// Record a span for each query made with a context that has a span, e.g.
+// app.DBWithContext(c.Request().Context())
+func InstrumentDB(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		callbacks.Create().Before("gorm:create").Register("drasl:start_span", startDBSpan),
+		callbacks.Create().After("gorm:create").Register("drasl:end_span", endDBSpan),
+		callbacks.Query().Before("gorm:query").Register("drasl:start_span", startDBSpan),
+		callbacks.Query().After("gorm:query").Register("drasl:end_span", endDBSpan),
+		callbacks.Update().Before("gorm:update").Register("drasl:start_span", startDBSpan),
+		callbacks.Update().After("gorm:update").Register("drasl:end_span", endDBSpan),
+		callbacks.Delete().Before("gorm:delete").Register("drasl:start_span", startDBSpan),
+		callbacks.Delete().After("gorm:delete").Register("drasl:end_span", endDBSpan),
+		callbacks.Row().Before("gorm:row").Register("drasl:start_span", startDBSpan),
+		callbacks.Row().After("gorm:row").Register("drasl:end_span", endDBSpan),
+		callbacks.Raw().Before("gorm:raw").Register("drasl:start_span", startDBSpan),
+		callbacks.Raw().After("gorm:raw").Register("drasl:end_span", endDBSpan),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	t.Run("Test parseTraceparent", testParseTraceparent)
+	{
+		ts := &TestSuite{}
+
+		exports := make(chan otlpTracesRequest, 4)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request otlpTracesRequest
+			if r.URL.Path == "/v1/traces" && r.Header.Get("Authorization") == "Bearer collector-token" {
+				if err := json.NewDecoder(r.Body).Decode(&request); err == nil {
+					exports <- request
+				}
+			}
+		}))
+		defer collector.Close()
+
+		config := testConfig()
+		config.Tracing.Enable = true
+		config.Tracing.OTLPEndpoint = collector.URL
+		config.Tracing.Headers = map[string]string{"Authorization": "Bearer collector-token"}
+		ts.Setup(config)
+		defer ts.Teardown()
+
+		t.Run("Test tracing hasJoined", func(t *testing.T) {
+			traceparents := make(chan string, 1)
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				traceparents <- r.Header.Get("traceparent")
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer fallback.Close()
+			ts.App.Config().FallbackAPIServers = []FallbackAPIServer{{Nickname: "Fallback", SessionURL: fallback.URL}}
+			defer func() { ts.App.Config().FallbackAPIServers = []FallbackAPIServer{} }()
+
+			traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+			req := httptest.NewRequest(http.MethodGet, "/session/session/minecraft/hasJoined?username=Nonexistent&serverId=abc", nil)
+			req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+			rec := httptest.NewRecorder()
+			ts.Server.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusForbidden, rec.Code)
+
+			assert.Nil(t, ts.App.Tracer.Flush())
+			export := <-exports
+			resource := export.ResourceSpans[0]
+			assert.Equal(t, "service.name", resource.Resource.Attributes[0].Key)
+			assert.Equal(t, "drasl", *resource.Resource.Attributes[0].Value.StringValue)
+
+			spans := map[string]otlpSpan{}
+			for _, span := range resource.ScopeSpans[0].Spans {
+				assert.Equal(t, traceID, span.TraceID)
+				spans[span.Name] = span
+			}
+			server, ok := spans["GET /session/session/minecraft/hasJoined"]
+			assert.True(t, ok)
+			assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+			assert.Equal(t, SPAN_KIND_SERVER, server.Kind)
+
+			query, ok := spans["SELECT users"]
+			assert.True(t, ok)
+			assert.Equal(t, server.SpanID, query.ParentSpanID)
+
+			client, ok := spans["GET"]
+			assert.True(t, ok)
+			assert.Equal(t, server.SpanID, client.ParentSpanID)
+			assert.Equal(t, SPAN_KIND_CLIENT, client.Kind)
+			assert.Equal(t, "00-"+traceID+"-"+client.SpanID+"-01", <-traceparents)
+		})
+
+		t.Run("Test sampling", func(t *testing.T) {
+			ts.App.Tracer.SampleRatio = 0
+			defer func() { ts.App.Tracer.SampleRatio = 1 }()
+
+			rec := ts.Get(t, ts.Server, "/drasl/api/v1/info", nil, nil)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Nil(t, ts.App.Tracer.Flush())
+			assert.Equal(t, 0, len(exports))
+
+			// An incoming sampled trace is still recorded
+			req := httptest.NewRequest(http.MethodGet, "/drasl/api/v1/info", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			ts.Server.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Nil(t, ts.App.Tracer.Flush())
+			export := <-exports
+			assert.Equal(t, 1, len(export.ResourceSpans[0].ScopeSpans[0].Spans))
+		})
+	}
+}
+
+func testParseTraceparent(t *testing.T) {
+	traceID, parentSpanID, sampled, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+	assert.Equal(t, "00f067aa0ba902b7", parentSpanID)
+	assert.True(t, sampled)
+
+	_, _, sampled, ok = parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	assert.True(t, ok)
+	assert.False(t, sampled)
+
+	for _, header := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+	} {
+		_, _, _, ok := parseTraceparent(header)
+		assert.False(t, ok, header)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Server-side paging, filtering, and sorting of the user list on the Admin page
+and in /drasl/api/v2/admin/users, so both stay usable with many accounts.
+A user's last login is the last use of any of their clients, and their
+storage usage is the size of their skin and cape files. File sizes are kept
+in the texture_sizes table, filled in the first time a texture shows up in
+the list, so filtering and sorting by storage happen in the query too.
+*/
+
+const USER_LIST_DEFAULT_PER_PAGE = 50
+const USER_LIST_MAX_PER_PAGE = 500
+
+const (
+	UserListSortUsername  = "username"
+	UserListSortCreatedAt = "createdAt"
+	UserListSortLastLogin = "lastLogin"
+	UserListSortStorage   = "storage"
+)
+
+var USER_LIST_SORTS = []string{UserListSortUsername, UserListSortCreatedAt, UserListSortLastLogin, UserListSortStorage}
+
+const USER_LIST_DATE_FORMAT = "2006-01-02"
+
+// SQL for the time of a user's last login, or NULL if they have no clients
+const lastLoginSQL = "(SELECT MAX(clients.last_used_at) FROM clients WHERE clients.user_uuid = users.uuid)"
+
+// SQL for the size of a user's skin and cape files. Files whose size isn't
+// known count as empty.
+const storageSQL = "(COALESCE((SELECT texture_sizes.size FROM texture_sizes WHERE texture_sizes.kind = 'skin' AND texture_sizes.hash = users.skin_hash), 0)" +
+	" + COALESCE((SELECT texture_sizes.size FROM texture_sizes WHERE texture_sizes.kind = 'cape' AND texture_sizes.hash = users.cape_hash), 0))"
+
+type UserListQuery struct {
+	Page    int
+	PerPage int
+	// Dates are inclusive, in local time
+	RegisteredAfter  *time.Time
+	RegisteredBefore *time.Time
+	LastLoginAfter   *time.Time
+	LastLoginBefore  *time.Time
+	// Users who have never logged in are included by NeverLoggedIn
+	NeverLoggedIn bool
+	Locked        *bool
+	MinStorageKiB *int64
+	Sort          string
+	Descending    bool
+}
+
+type UserListEntry struct {
+	User         User
+	LastLoginAt  *time.Time
+	StorageBytes int64
+}
+
+type UserList struct {
+	Query     UserListQuery
+	Entries   []UserListEntry
+	Total     int64
+	PageCount int
+}
+
+func parseUserListDate(values url.Values, key string, endOfDay bool) (*time.Time, error) {
+	value := values.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	date, err := time.ParseInLocation(USER_LIST_DATE_FORMAT, value, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s: must be a date like 2006-01-02.", key)
+	}
+	if endOfDay {
+		date = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return &date, nil
+}
+
+// Parse a UserListQuery from the query parameters of the Admin page or the
+// API. The error is fit to show to the user.
+func ParseUserListQuery(values url.Values) (UserListQuery, error) {
+	query := UserListQuery{
+		Page:    1,
+		PerPage: USER_LIST_DEFAULT_PER_PAGE,
+		Sort:    UserListSortUsername,
+	}
+	var err error
+
+	if page := values.Get("page"); page != "" {
+		query.Page, err = strconv.Atoi(page)
+		if err != nil || query.Page < 1 {
+			return query, errors.New("Invalid page.")
+		}
+	}
+	if perPage := values.Get("perPage"); perPage != "" {
+		query.PerPage, err = strconv.Atoi(perPage)
+		if err != nil || query.PerPage < 1 || query.PerPage > USER_LIST_MAX_PER_PAGE {
+			return query, fmt.Errorf("Invalid perPage: must be between 1 and %d.", USER_LIST_MAX_PER_PAGE)
+		}
+	}
+
+	if query.RegisteredAfter, err = parseUserListDate(values, "registeredAfter", false); err != nil {
+		return query, err
+	}
+	if query.RegisteredBefore, err = parseUserListDate(values, "registeredBefore", true); err != nil {
+		return query, err
+	}
+	if query.LastLoginAfter, err = parseUserListDate(values, "lastLoginAfter", false); err != nil {
+		return query, err
+	}
+	if query.LastLoginBefore, err = parseUserListDate(values, "lastLoginBefore", true); err != nil {
+		return query, err
+	}
+	query.NeverLoggedIn = values.Get("neverLoggedIn") == "true"
+
+	switch values.Get("locked") {
+	case "":
+	case "true":
+		query.Locked = Ptr(true)
+	case "false":
+		query.Locked = Ptr(false)
+	default:
+		return query, errors.New("Invalid locked: must be true or false.")
+	}
+
+	if minStorageKiB := values.Get("minStorageKiB"); minStorageKiB != "" {
+		kib, err := strconv.ParseInt(minStorageKiB, 10, 64)
+		if err != nil || kib < 0 {
+			return query, errors.New("Invalid minStorageKiB.")
+		}
+		query.MinStorageKiB = &kib
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		if !Contains(USER_LIST_SORTS, sort) {
+			return query, fmt.Errorf("Invalid sort: must be one of %v.", USER_LIST_SORTS)
+		}
+		query.Sort = sort
+	}
+	query.Descending = values.Get("order") == "desc"
+
+	return query, nil
+}
+
+// The query parameters for query, with a different page
+func (query UserListQuery) Values(page int) url.Values {
+	values := url.Values{}
+	values.Set("page", strconv.Itoa(page))
+	if query.PerPage != USER_LIST_DEFAULT_PER_PAGE {
+		values.Set("perPage", strconv.Itoa(query.PerPage))
+	}
+	setDate := func(key string, date *time.Time) {
+		if date != nil {
+			values.Set(key, date.Format(USER_LIST_DATE_FORMAT))
+		}
+	}
+	setDate("registeredAfter", query.RegisteredAfter)
+	setDate("registeredBefore", query.RegisteredBefore)
+	setDate("lastLoginAfter", query.LastLoginAfter)
+	setDate("lastLoginBefore", query.LastLoginBefore)
+	if query.NeverLoggedIn {
+		values.Set("neverLoggedIn", "true")
+	}
+	if locked := query.LockedFilter(); locked != "" {
+		values.Set("locked", locked)
+	}
+	if query.MinStorageKiB != nil {
+		values.Set("minStorageKiB", strconv.FormatInt(*query.MinStorageKiB, 10))
+	}
+	values.Set("sort", query.Sort)
+	if query.Descending {
+		values.Set("order", "desc")
+	}
+	return values
+}
+
+// The `locked` query parameter for query: "true", "false", or empty
+func (query UserListQuery) LockedFilter() string {
+	if query.Locked == nil {
+		return ""
+	}
+	return strconv.FormatBool(*query.Locked)
+}
+
+// Record the sizes of the skins and capes users have that aren't in
+// texture_sizes yet. Files that can't be read are skipped, and tried again
+// next time.
+func (app *App) recordTextureSizes() error {
+	for i := range TEXTURE_KINDS {
+		kind := &TEXTURE_KINDS[i]
+		var hashes []string
+		err := app.DB.Model(&User{}).
+			Where(kind.Column+" IS NOT NULL").
+			Where(kind.Column+" NOT IN (SELECT hash FROM texture_sizes WHERE kind = ?)", kind.Name).
+			Distinct().
+			Pluck(kind.Column, &hashes).Error
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			info, err := os.Stat(kind.Path(app, hash))
+			if err != nil {
+				continue
+			}
+			textureSize := TextureSize{Kind: kind.Name, Hash: hash, Size: info.Size()}
+			if err := app.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&textureSize).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Set the StorageBytes of each entry
+func (app *App) fillStorageBytes(entries []UserListEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	uuids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		uuids = append(uuids, entry.User.UUID)
+	}
+	var rows []struct {
+		UUID         string
+		StorageBytes int64
+	}
+	err := app.DB.Model(&User{}).
+		Select("users.uuid AS uuid, "+storageSQL+" AS storage_bytes").
+		Where("users.uuid IN ?", uuids).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	storageBytes := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		storageBytes[row.UUID] = row.StorageBytes
+	}
+	for i := range entries {
+		entries[i].StorageBytes = storageBytes[entries[i].User.UUID]
+	}
+	return nil
+}
+
+func lastLogin(user *User) *time.Time {
+	var last *time.Time
+	for i := range user.Clients {
+		if last == nil || user.Clients[i].LastUsedAt.After(*last) {
+			last = &user.Clients[i].LastUsedAt
+		}
+	}
+	return last
+}
+
+func (app *App) ListUsers(query UserListQuery) (*UserList, error) {
+	if err := app.recordTextureSizes(); err != nil {
+		return nil, err
+	}
+
+	db := app.DB.Model(&User{})
+	if query.RegisteredAfter != nil {
+		db = db.Where("users.created_at >= ?", *query.RegisteredAfter)
+	}
+	if query.RegisteredBefore != nil {
+		db = db.Where("users.created_at <= ?", *query.RegisteredBefore)
+	}
+	if query.LastLoginAfter != nil || query.LastLoginBefore != nil || query.NeverLoggedIn {
+		// Users whose last login is in the range, or who never logged in
+		conditions := []string{}
+		args := []interface{}{}
+		if query.LastLoginAfter != nil || query.LastLoginBefore != nil {
+			inRange := lastLoginSQL + " IS NOT NULL"
+			if query.LastLoginAfter != nil {
+				inRange += " AND " + lastLoginSQL + " >= ?"
+				args = append(args, *query.LastLoginAfter)
+			}
+			if query.LastLoginBefore != nil {
+				inRange += " AND " + lastLoginSQL + " <= ?"
+				args = append(args, *query.LastLoginBefore)
+			}
+			conditions = append(conditions, inRange)
+		}
+		if query.NeverLoggedIn {
+			conditions = append(conditions, lastLoginSQL+" IS NULL")
+		}
+		db = db.Where("(("+strings.Join(conditions, ") OR (")+"))", args...)
+	}
+	if query.Locked != nil {
+		db = db.Where("users.is_locked = ?", *query.Locked)
+	}
+
+	if query.MinStorageKiB != nil {
+		db = db.Where(storageSQL+" >= ?", *query.MinStorageKiB*1024)
+	}
+
+	direction := "ASC"
+	if query.Descending {
+		direction = "DESC"
+	}
+	switch query.Sort {
+	case UserListSortCreatedAt:
+		db = db.Order("users.created_at " + direction)
+	case UserListSortLastLogin:
+		// Users who have never logged in come first in ascending order
+		db = db.Order("COALESCE(" + lastLoginSQL + ", '') " + direction)
+	case UserListSortStorage:
+		db = db.Order(storageSQL + " " + direction)
+	}
+	// Usernames are unique, so this makes the order stable
+	db = db.Order("users.username " + direction).Session(&gorm.Session{})
+
+	list := UserList{Query: query}
+	if err := db.Count(&list.Total).Error; err != nil {
+		return nil, err
+	}
+	var users []User
+	offset := (query.Page - 1) * query.PerPage
+	if err := db.Preload("Clients").Offset(offset).Limit(query.PerPage).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	for _, user := range users {
+		list.Entries = append(list.Entries, UserListEntry{
+			User:        user,
+			LastLoginAt: lastLogin(&user),
+		})
+	}
+	if err := app.fillStorageBytes(list.Entries); err != nil {
+		return nil, err
+	}
+
+	list.PageCount = int((list.Total + int64(query.PerPage) - 1) / int64(query.PerPage))
+	return &list, nil
+}